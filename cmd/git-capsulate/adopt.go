@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newAdoptContainerCmd builds the adopt-container command that brings an existing
+// container under git-capsulate's management
+func newAdoptContainerCmd() *cobra.Command {
+	adoptCmd := &cobra.Command{
+		Use:   "adopt-container [container] --as [agent-id]",
+		Short: "Manage an existing container as an agent",
+		Long: `Bring a container created outside git-capsulate, such as a long-lived dev
+container, under its management as an agent, without recreating it. The
+container is renamed after the agent and started if stopped; exec, status,
+diff, checkpoints and destroy then work on it like on any agent.
+
+The agent's repository is expected at /workspace/repo. If the container holds
+it elsewhere, --repo links /workspace/repo to it.
+
+Docker cannot label an existing container, so the agent is known by its
+container name alone. An adopted agent cannot be recreated, from a newer base
+image or after its container was removed, since git-capsulate did not build it.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID, _ := cmd.Flags().GetString("as")
+			repoPath, _ := cmd.Flags().GetString("repo")
+			labelEntries, _ := cmd.Flags().GetStringArray("label")
+
+			labels, err := agent.ParseLabels(labelEntries)
+			if err != nil {
+				errorf("Error parsing labels: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			manager := newManager()
+			if err := manager.AdoptContainer(args[0], agentID, agent.AdoptOptions{
+				RepoPath: repoPath,
+				Labels:   labels,
+			}); err != nil {
+				errorf("Error adopting container: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("✅ Container '%s' adopted as agent '%s'\n", args[0], agentID)
+		},
+	}
+	adoptCmd.Flags().String("as", "", "ID of the agent the container becomes")
+	adoptCmd.Flags().String("repo", "", "Path of the repository in the container, if not /workspace/repo")
+	adoptCmd.Flags().StringArrayP("label", "l", nil, "Label as key=value (repeatable), used to select agents with --selector")
+	adoptCmd.MarkFlagRequired("as")
+	return adoptCmd
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newAnalyzeCmd builds the analyze command that inspects how agents use the host
+func newAnalyzeCmd() *cobra.Command {
+	analyzeCmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "Analyze how agents use the host",
+	}
+
+	storageCmd := &cobra.Command{
+		Use:   "storage",
+		Short: "Measure shared and duplicated storage and recommend modes that save space",
+		Long: `Measure the disk space the agents of the current project share (the base image's
+layers, the overlay base, the core and team dependency layers and the clone cache)
+and what each one holds on its own: its workspace or overlay diff, its container
+dependencies and its container's writable layer.
+
+Agents cloning the same repository are compared to estimate the duplicated data and
+recommend the overlay filesystem, the clone cache or team-level dependencies, with
+the savings measured on the existing agents. Overlay savings are only estimated when
+an overlay agent of the same repository exists to compare with.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			analysis, err := newManager().AnalyzeStorage()
+			if err != nil {
+				errorf("Error analyzing storage: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(analysis, "", "  ")
+				if err != nil {
+					errorf("Error marshaling storage analysis to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			displayStorageAnalysis(analysis)
+		},
+	}
+	storageCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	analyzeCmd.AddCommand(storageCmd)
+
+	return analyzeCmd
+}
+
+// displayStorageAnalysis prints the shared and per-agent storage, then the
+// recommendations
+func displayStorageAnalysis(analysis *agent.StorageAnalysis) {
+	infof("📦 Shared by all agents:\n")
+	fmt.Printf("  %-20s %10s\n", "base image", formatBytes(analysis.Image))
+	fmt.Printf("  %-20s %10s\n", "overlay base", formatBytes(analysis.OverlayBase))
+	fmt.Printf("  %-20s %10s\n", "shared dependencies", formatBytes(analysis.SharedDeps))
+	fmt.Printf("  %-20s %10s\n", "clone cache", formatBytes(analysis.CloneCache))
+
+	infof("\n🗂️  Per agent (%s, %s duplicated):\n", formatBytes(analysis.PerAgent), formatBytes(analysis.Duplicated))
+	fmt.Printf("  %-24s %-8s %10s %10s %10s\n", "AGENT", "MODE", "WORKSPACE", "DEPS", "LAYER")
+	for _, usage := range analysis.Agents {
+		mode := "plain"
+		if usage.UseOverlay {
+			mode = "overlay"
+		}
+		fmt.Printf("  %-24s %-8s %10s %10s %10s\n", usage.ID, mode, formatBytes(usage.Workspace),
+			formatBytes(usage.Dependencies), formatBytes(usage.WritableLayer))
+	}
+
+	if len(analysis.Recommendations) == 0 {
+		infof("\n✅ No recommendation: no agents of a repository duplicate each other\n")
+		return
+	}
+	infof("\n💡 Recommendations:\n")
+	for _, rec := range analysis.Recommendations {
+		saving := "save about " + formatBytes(rec.Savings)
+		if rec.Mode == agent.RecommendCloneCache {
+			saving = "avoid downloading about " + formatBytes(rec.Savings)
+		}
+		fmt.Printf("  %s: %s (%s)\n", rec.Mode, saving, strings.Join(rec.Agents, ", "))
+		fmt.Printf("    %s\n", rec.Reason)
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/artifacts"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// newArtifactsCmd builds the artifacts command that browses stored check runs
+func newArtifactsCmd() *cobra.Command {
+	artifactsCmd := &cobra.Command{
+		Use:   "artifacts",
+		Short: "Browse the stored output and artifacts of check runs",
+		Long: `Every check run keeps its complete output, and the files listed under its artifacts
+key in capsulate.yaml, in .capsulate/artifacts/<agent>/<check>/<run>. Runs outlive
+the agent and Docker is not needed to read them. The newest runs are kept according
+to artifacts.keep_runs (default 10) and artifacts.max_age.`,
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list [agent-id]",
+		Short: "List stored runs of an agent, newest first",
+		Args:  agentIDArgs(1, 1),
+		Run: func(cmd *cobra.Command, args []string) {
+			job, _ := cmd.Flags().GetString("check")
+			format, _ := cmd.Flags().GetString("format")
+
+			runs, err := openArtifactStore().List(args[0], job)
+			if err != nil {
+				errorf("Error listing artifacts: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				if runs == nil {
+					runs = []*artifacts.Run{}
+				}
+				jsonData, err := json.MarshalIndent(runs, "", "  ")
+				if err != nil {
+					errorf("Error marshaling runs to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			if len(runs) == 0 {
+				fmt.Println("No runs")
+				return
+			}
+			fmt.Printf("%-20s %-20s %-8s %-10s %-10s %s\n", "CHECK", "RUN", "RESULT", "DURATION", "OUTPUT", "FILES")
+			for _, run := range runs {
+				result := "passed"
+				if !run.Passed {
+					result = fmt.Sprintf("exit %d", run.ExitCode)
+				}
+				fmt.Printf("%-20s %-20s %-8s %-10s %-10d %d\n", run.Job, run.ID, result,
+					run.Duration.Round(time.Millisecond), run.OutputSize, len(run.Files))
+			}
+		},
+	}
+	listCmd.Flags().String("check", "", "Only list runs of this check")
+	listCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	getCmd := &cobra.Command{
+		Use:   "get [agent-id] [check] [run-id]",
+		Short: "Print the output or an artifact of a stored run",
+		Long: `Print the complete output of a stored run (the latest when no run ID is given),
+an artifact file with --file, or the run's directory with --dir.`,
+		Args: agentIDArgs(2, 3),
+		Run: func(cmd *cobra.Command, args []string) {
+			file, _ := cmd.Flags().GetString("file")
+			printDir, _ := cmd.Flags().GetBool("dir")
+
+			runID := ""
+			if len(args) > 2 {
+				runID = args[2]
+			}
+
+			store := openArtifactStore()
+			run, err := store.Get(args[0], args[1], runID)
+			if err != nil {
+				errorf("Error getting run: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if printDir {
+				fmt.Println(store.RunDir(run))
+				return
+			}
+
+			source := store.OutputPath(run)
+			if file != "" {
+				// Only files recorded with the run are served
+				want := path.Clean(filepath.ToSlash(file))
+				found := false
+				for _, stored := range run.Files {
+					if stored.Path == want {
+						found = true
+						break
+					}
+				}
+				if !found {
+					errorf("Error: run %s has no artifact '%s'\n", run.ID, file)
+					exit(exitFailure)
+				}
+				source = filepath.Join(store.FilesPath(run), filepath.FromSlash(want))
+			}
+
+			f, err := os.Open(source)
+			if err != nil {
+				errorf("Error opening %s: %v\n", source, err)
+				exit(exitCode(err))
+			}
+			defer f.Close()
+			io.Copy(os.Stdout, f)
+		},
+	}
+	getCmd.Flags().String("file", "", "Print this artifact instead of the output")
+	getCmd.Flags().Bool("dir", false, "Print the directory holding the run's output and artifacts")
+
+	artifactsCmd.AddCommand(listCmd)
+	artifactsCmd.AddCommand(getCmd)
+
+	return artifactsCmd
+}
+
+// agentIDArgs accepts between min and max arguments, the first of which must be a
+// valid agent ID
+func agentIDArgs(min, max int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := cobra.RangeArgs(min, max)(cmd, args); err != nil {
+			return err
+		}
+		return agent.ValidateAgentID(args[0])
+	}
+}
+
+// openArtifactStore opens the artifact store of the workspace without contacting Docker
+func openArtifactStore() *artifacts.Store {
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		errorf("Error getting current directory: %v\n", err)
+		exit(exitCode(err))
+	}
+
+	cfg, err := config.Load(workspaceDir)
+	if err != nil {
+		errorf("Error loading config: %v\n", err)
+		exit(exitConfig)
+	}
+
+	project, err := config.ResolveProject(cfg)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(exitConfig)
+	}
+
+	store, err := artifacts.NewStore(config.DataDir(workspaceDir, project), artifacts.Retention{
+		KeepRuns: cfg.Artifacts.KeepRuns,
+		MaxAge:   time.Duration(cfg.Artifacts.MaxAge),
+	})
+	if err != nil {
+		errorf("Error opening artifact store: %v\n", err)
+		exit(exitCode(err))
+	}
+	return store
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/backup"
+)
+
+// newBackupCmd builds the backup command that archives and restores the workspace's
+// fleet configuration
+func newBackupCmd() *cobra.Command {
+	backupCmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Back up and restore the workspace's capsulate state",
+		Long: `Archive what is needed to rebuild the fleet after a host rebuild or on another
+machine: capsulate.yaml with its templates, the state and schedules of every
+project's agents, core and team dependencies, overlay bases and cache stats.
+Containers and images are not included. With --workspaces the agents' repositories
+and installed dependencies are kept too, and 'image refresh --rollout' recreates their
+containers after a restore. Docker is not needed.`,
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create [file]",
+		Short: "Write a backup archive (default capsulate-backup-<time>.tar.gz, - for stdout)",
+		Args:  cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			withArtifacts, _ := cmd.Flags().GetBool("artifacts")
+			withWorkspaces, _ := cmd.Flags().GetBool("workspaces")
+			format, _ := cmd.Flags().GetString("format")
+
+			file := fmt.Sprintf("capsulate-backup-%s.tar.gz", time.Now().Format("20060102-150405"))
+			if len(args) > 0 {
+				file = args[0]
+			}
+
+			var out io.Writer = os.Stdout
+			if file != "-" {
+				f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+				if err != nil {
+					errorf("Error creating backup: %v\n", err)
+					exit(exitCode(err))
+				}
+				defer f.Close()
+				out = f
+			}
+
+			summary, err := backup.Create(workspaceDir(), out, backup.Options{
+				Artifacts:  withArtifacts,
+				Workspaces: withWorkspaces,
+			})
+			if err != nil {
+				if file != "-" {
+					os.Remove(file)
+				}
+				errorf("Error creating backup: %v\n", err)
+				exit(exitCode(err))
+			}
+			if file == "-" {
+				return
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(summary, "", "  ")
+				if err != nil {
+					errorf("Error marshaling backup summary to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			infof("✅ Backed up %d files (%d bytes) to %s\n", summary.Files, summary.Bytes, file)
+			for _, name := range summary.Contents {
+				fmt.Printf("  %s\n", name)
+			}
+			for _, name := range summary.Scrubbed {
+				infof("🧽 Left credentials out: %s\n", name)
+			}
+		},
+	}
+	createCmd.Flags().Bool("artifacts", false, "Include the stored output and artifacts of check runs")
+	createCmd.Flags().Bool("workspaces", false, "Include the agents' repositories and installed dependencies")
+	createCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	restoreCmd := &cobra.Command{
+		Use:   "restore [file]",
+		Short: "Restore a backup archive into the workspace (- for stdin)",
+		Long: `Extract a backup made by 'backup create' into the current workspace. A workspace
+that already records agents is left untouched unless --force is given, in which case
+files from the backup replace existing ones. An existing capsulate.yaml is only
+replaced with --force.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			force, _ := cmd.Flags().GetBool("force")
+
+			var in io.Reader = os.Stdin
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					errorf("Error restoring backup: %v\n", err)
+					exit(exitCode(err))
+				}
+				defer f.Close()
+				in = f
+			}
+
+			summary, err := backup.Restore(workspaceDir(), in, force)
+			if err != nil {
+				errorf("Error restoring backup: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("✅ Restored %d files (%d bytes) from a backup made %s by git-capsulate %s\n",
+				summary.Files, summary.Bytes, summary.CreatedAt.Local().Format("2006-01-02 15:04:05"), summary.ToolVersion)
+			for _, name := range summary.Contents {
+				if path.Base(name) == "workspaces" {
+					infof("Recreate the agents' containers with 'git-capsulate image refresh --rollout'\n")
+					return
+				}
+			}
+			infof("The backup has no agent workspaces; create the agents again with 'git-capsulate create'\n")
+		},
+	}
+	restoreCmd.Flags().Bool("force", false, "Restore over existing agent state")
+
+	backupCmd.AddCommand(createCmd, restoreCmd)
+
+	return backupCmd
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newBenchCmd builds the bench command that measures the latency of agent operations
+func newBenchCmd() *cobra.Command {
+	benchCmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Measure the performance of agent operations",
+	}
+
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Measure create, clone, exec and destroy latencies",
+		Long: `Create an agent cloning --repo, run --exec in it and destroy it, --iterations
+times, then print the median latency of each phase. --use-overlay and --cache add
+modes creating agents on the overlay filesystem and cloning through the clone cache,
+compared with the default mode. The first iteration of a cache mode fills the cache;
+compare medians to see the benefit of a warm cache.
+
+Bench agents are named bench-<mode>-<pid>-<n> and destroyed along with their
+directories. Destroy any left behind by an interrupted run with 'destroy'.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			repoURL, _ := cmd.Flags().GetString("repo")
+			branch, _ := cmd.Flags().GetString("branch")
+			iterations, _ := cmd.Flags().GetInt("iterations")
+			command, _ := cmd.Flags().GetString("exec")
+			useOverlay, _ := cmd.Flags().GetBool("use-overlay")
+			useCache, _ := cmd.Flags().GetBool("cache")
+			format, _ := cmd.Flags().GetString("format")
+
+			manager := newManager()
+			report, err := manager.Bench(agent.BenchOptions{
+				RepoURL:    repoURL,
+				Branch:     branch,
+				Iterations: iterations,
+				Command:    command,
+				Overlay:    useOverlay,
+				Cache:      useCache,
+				Progress: func(mode string, iteration int) {
+					if format != "json" {
+						infof("  %s %d/%d\n", mode, iteration, iterations)
+					}
+				},
+			})
+			if err != nil {
+				errorf("Error benchmarking: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					errorf("Error marshaling benchmark to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			displayBenchReport(report)
+		},
+	}
+	createCmd.Flags().StringP("repo", "r", "", "Git repository URL to clone")
+	createCmd.MarkFlagRequired("repo")
+	createCmd.Flags().StringP("branch", "b", "", "Branch to checkout")
+	createCmd.Flags().Int("iterations", 5, "Number of agents created in each mode")
+	createCmd.Flags().String("exec", "git status", "Command run in each agent, empty to skip")
+	createCmd.Flags().Bool("use-overlay", false, "Also measure agents using the overlay filesystem")
+	createCmd.Flags().Bool("cache", false, "Also measure agents cloning through the clone cache")
+	createCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	benchCmd.AddCommand(createCmd)
+
+	return benchCmd
+}
+
+// displayBenchReport prints the median latency of each phase by mode, with the change
+// relative to the default mode
+func displayBenchReport(report *agent.BenchReport) {
+	infof("⏱️  Median latency over %d iterations (%s)\n", report.Iterations, report.RepoURL)
+	fmt.Printf("%-10s", "PHASE")
+	for _, mode := range report.Modes {
+		fmt.Printf(" %-20s", mode.Name)
+	}
+	fmt.Println()
+
+	for i, phase := range report.Modes[0].Phases {
+		if len(phase.Samples) == 0 {
+			continue
+		}
+		fmt.Printf("%-10s", phase.Name)
+		base := phase.Median
+		for j, mode := range report.Modes {
+			median := mode.Phases[i].Median
+			cell := median.Round(time.Millisecond).String()
+			if j > 0 && base > 0 {
+				cell += fmt.Sprintf(" (%+.0f%%)", float64(median-base)/float64(base)*100)
+			}
+			fmt.Printf(" %-20s", cell)
+		}
+		fmt.Println()
+	}
+}
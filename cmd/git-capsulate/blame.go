@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newBlameCmd builds the blame command that attributes lines of a file to commits
+func newBlameCmd() *cobra.Command {
+	blameCmd := &cobra.Command{
+		Use:   "blame [agent-id] [path]",
+		Short: "Show which commit last changed each line of a file",
+		Long: `Attribute each line of a file in an agent's repository, given relative to the
+repository root, to the commit, author and date that last changed it. Uncommitted
+lines are attributed to "Not Committed Yet". Use -L to limit the lines, e.g. -L 10,20.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID := args[0]
+			file := args[1]
+			format, _ := cmd.Flags().GetString("format")
+			rangeFlag, _ := cmd.Flags().GetString("lines")
+
+			lines, err := parseLineRange(rangeFlag)
+			if err != nil {
+				errorf("Error: %v\n", err)
+				exit(exitUsage)
+			}
+
+			manager := newManager()
+			blame, err := manager.Blame(agentID, file, lines)
+			if err != nil {
+				errorf("Error running blame: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				if blame == nil {
+					blame = []agent.BlameLine{}
+				}
+				jsonData, err := json.MarshalIndent(blame, "", "  ")
+				if err != nil {
+					errorf("Error marshaling blame to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			for _, line := range blame {
+				fmt.Printf("%.8s (%-20.20s %s %5d) %s\n", line.Commit, line.Author,
+					line.Date.Format("2006-01-02"), line.Line, line.Content)
+			}
+		},
+	}
+	blameCmd.Flags().StringP("lines", "L", "", "Line range as start,end (either may be omitted) or a single line")
+	blameCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	return blameCmd
+}
+
+// parseLineRange parses a -L value such as "10,20", "10,", ",20" or "10"
+func parseLineRange(value string) (agent.LineRange, error) {
+	var lines agent.LineRange
+	if value == "" {
+		return lines, nil
+	}
+
+	start, end, isRange := strings.Cut(value, ",")
+	var err error
+	if start != "" {
+		if lines.Start, err = strconv.Atoi(start); err != nil || lines.Start < 1 {
+			return lines, fmt.Errorf("invalid line range '%s'", value)
+		}
+	}
+	if !isRange {
+		lines.End = lines.Start
+		return lines, nil
+	}
+	if end != "" {
+		if lines.End, err = strconv.Atoi(end); err != nil || lines.End < 1 {
+			return lines, fmt.Errorf("invalid line range '%s'", value)
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newCacheCmd builds the cache command that inspects and warms the clone cache
+func newCacheCmd() *cobra.Command {
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect and prefetch the clone cache",
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "List the clone cache mirrors and when they were last fetched",
+		Long: `List the mirrors of the clone cache, least recently used first, with their size,
+when an agent last cloned from them and when they were last fetched from the remote.
+Repositories listed under cache.prefetch without a mirror yet come last.
+
+Mirrors not fetched for twice cache.prefetch_interval, or a day without one, are
+marked stale; --stale-after sets another threshold.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			staleAfter, _ := cmd.Flags().GetDuration("stale-after")
+			format, _ := cmd.Flags().GetString("format")
+
+			statuses, err := newManager().CacheStatus(staleAfter)
+			if err != nil {
+				errorf("Error reading clone cache: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				if statuses == nil {
+					statuses = []agent.CacheStatus{}
+				}
+				jsonData, err := json.MarshalIndent(statuses, "", "  ")
+				if err != nil {
+					errorf("Error marshaling clone cache to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			displayCacheStatus(statuses)
+		},
+	}
+	statusCmd.Flags().Duration("stale-after", 0, "Mark mirrors not fetched for longer than this as stale")
+	statusCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	prefetchCmd := &cobra.Command{
+		Use:   "prefetch",
+		Short: "Fetch the repositories listed under cache.prefetch into the clone cache",
+		Long: `Fetch the repositories and branches listed under cache.prefetch in capsulate.yaml
+into the clone cache, cloning the mirrors that do not exist yet, so that new agents
+clone from a warm, near-current mirror. Fetches run in a throwaway container from
+the base image with the agents' SSH, host key and mirror configuration.
+
+With --every, or cache.prefetch_interval when set, prefetch runs in the foreground
+at that interval until interrupted.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			every, _ := cmd.Flags().GetDuration("every")
+			format, _ := cmd.Flags().GetString("format")
+
+			manager := newManager()
+			if len(manager.Config().Cache.Prefetch) == 0 {
+				errorf("Error: no repositories listed under cache.prefetch in capsulate.yaml\n")
+				exit(exitUsage)
+			}
+			if !cmd.Flags().Changed("every") {
+				every = time.Duration(manager.Config().Cache.PrefetchInterval)
+			}
+
+			prefetch := func() bool {
+				results, err := manager.Prefetch()
+				if format == "json" {
+					if results == nil {
+						results = []agent.PrefetchResult{}
+					}
+					jsonData, jsonErr := json.MarshalIndent(results, "", "  ")
+					if jsonErr != nil {
+						errorf("Error marshaling prefetch results to JSON: %v\n", jsonErr)
+						exit(exitCode(jsonErr))
+					}
+					fmt.Println(string(jsonData))
+				} else {
+					displayPrefetchResults(results)
+				}
+				if err != nil {
+					errorf("Error prefetching: %v\n", err)
+					return false
+				}
+				return true
+			}
+
+			if every <= 0 {
+				if !prefetch() {
+					exit(exitFailure)
+				}
+				return
+			}
+
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+			infof("🔄 Prefetching every %s (Ctrl+C to stop)...\n", every)
+			for {
+				prefetch()
+				startOperation(cmd)
+				select {
+				case <-sigCh:
+					return
+				case <-time.After(every):
+				}
+			}
+		},
+	}
+	prefetchCmd.Flags().Duration("every", 0, "Prefetch repeatedly at this interval until interrupted (default cache.prefetch_interval)")
+	prefetchCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	cacheCmd.AddCommand(statusCmd)
+	cacheCmd.AddCommand(prefetchCmd)
+
+	return cacheCmd
+}
+
+// displayCacheStatus prints the clone cache mirrors with their last use and fetch
+func displayCacheStatus(statuses []agent.CacheStatus) {
+	if len(statuses) == 0 {
+		infof("No repositories in the clone cache\n")
+		return
+	}
+	fmt.Printf("%-16s %10s %-12s %-12s %-8s %s\n", "KEY", "SIZE", "LAST USED", "LAST FETCH", "STATUS", "URL")
+	for _, status := range statuses {
+		state := "fresh"
+		switch {
+		case status.Missing:
+			state = "missing"
+		case status.Stale:
+			state = "stale"
+		}
+		if status.Prefetched {
+			state += "*"
+		}
+		fmt.Printf("%-16s %10s %-12s %-12s %-8s %s\n", status.Key, formatBytes(status.Size),
+			formatAge(status.LastUsed), formatAge(status.LastFetch), state, status.URL)
+	}
+	infof("\n* listed under cache.prefetch\n")
+}
+
+// displayPrefetchResults prints the outcome of fetching each repository
+func displayPrefetchResults(results []agent.PrefetchResult) {
+	for _, result := range results {
+		duration := result.Duration.Round(time.Millisecond)
+		switch {
+		case result.Error != "":
+			fmt.Printf("❌ %s: %s\n", result.URL, result.Error)
+		case result.Created:
+			fmt.Printf("📥 %s: cloned in %s\n", result.URL, duration)
+		default:
+			fmt.Printf("✅ %s: fetched in %s\n", result.URL, duration)
+		}
+	}
+}
+
+// formatAge formats how long ago t was, or "never" for the zero time
+func formatAge(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/reports"
+)
+
+// newCheckCmd builds the check command that runs configured validation commands
+func newCheckCmd() *cobra.Command {
+	checkCmd := &cobra.Command{
+		Use:   "check [agent-id]",
+		Short: "Run configured checks inside an agent",
+		Long: `Run the build, test and lint commands declared in the checks section of
+capsulate.yaml inside an agent. Results are recorded in the agent's state and
+metrics. Exits with a non-zero status if any check fails.
+
+Example capsulate.yaml:
+
+  checks:
+    - name: build
+      command: go build ./...
+    - name: test
+      command: go test ./...
+      timeout: 10m`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID := args[0]
+
+			only, _ := cmd.Flags().GetStringSlice("only")
+			format, _ := cmd.Flags().GetString("format")
+			verbose, _ := cmd.Flags().GetBool("verbose")
+
+			manager := newManager()
+			results, err := manager.RunChecks(agentID, only)
+			if err != nil {
+				errorf("Error running checks: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			failed := 0
+			for _, result := range results {
+				if !result.Passed {
+					failed++
+				}
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(results, "", "  ")
+				if err != nil {
+					errorf("Error marshaling results to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+			} else {
+				infof("🧪 Checks for agent '%s':\n", agentID)
+				infof("==========================================\n")
+				for _, result := range results {
+					mark := "✅"
+					if !result.Passed {
+						mark = "❌"
+					}
+					fmt.Printf("%s %-20s exit %-4d %s\n", mark, result.Name, result.ExitCode, result.Duration.Round(time.Millisecond))
+					if result.Reports != nil {
+						displayReportSummary(result.Reports)
+					}
+					if verbose || !result.Passed {
+						for _, line := range strings.Split(strings.TrimRight(result.Output, "\n"), "\n") {
+							fmt.Printf("    %s\n", line)
+						}
+					}
+				}
+				fmt.Printf("\n%d passed, %d failed\n", len(results)-failed, failed)
+			}
+
+			if failed > 0 {
+				exit(exitFailure)
+			}
+		},
+	}
+
+	checkCmd.Flags().StringSlice("only", nil, "Run only the named checks")
+	checkCmd.Flags().String("format", "text", "Output format (text or json)")
+	checkCmd.Flags().BoolP("verbose", "v", false, "Show output of passing checks too")
+
+	return checkCmd
+}
+
+// displayReportSummary prints the summary of a check's reports, the failed tests and
+// the reports that could not be read
+func displayReportSummary(summary *reports.Summary) {
+	if line := summary.String(); line != "" {
+		fmt.Printf("    📊 %s\n", line)
+	} else {
+		fmt.Printf("    📊 no report files found\n")
+	}
+	if summary.Tests != nil {
+		for _, name := range summary.Tests.Failures {
+			fmt.Printf("       ✗ %s\n", name)
+		}
+		if more := summary.Tests.Failed - len(summary.Tests.Failures); more > 0 {
+			fmt.Printf("       ... and %d more\n", more)
+		}
+	}
+	for _, err := range summary.Errors {
+		errorf("Warning: %s\n", err)
+	}
+}
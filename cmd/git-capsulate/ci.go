@@ -0,0 +1,545 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// Log styles of 'ci exec'
+const (
+	ciStyleGitHub = "github"
+	ciStyleGitLab = "gitlab"
+	ciStylePlain  = "plain"
+)
+
+// ciOutputLines is the number of trailing output lines kept in the JUnit report of a
+// failed command
+const ciOutputLines = 100
+
+// ciMatcherOwner identifies the problem matcher registered with GitHub Actions
+const ciMatcherOwner = "git-capsulate"
+
+// ciProblemMatcher turns compiler and linter messages such as "main.go:12:5: undefined: x"
+// into annotations on GitHub Actions
+const ciProblemMatcher = `{
+  "problemMatcher": [
+    {
+      "owner": "` + ciMatcherOwner + `",
+      "pattern": [
+        {
+          "regexp": "^([^\\s:]+):(\\d+):(?:(\\d+):)?\\s+(.*)$",
+          "file": 1,
+          "line": 2,
+          "column": 3,
+          "message": 4
+        }
+      ]
+    }
+  ]
+}
+`
+
+// ciCommandResult is the outcome of one command run by 'ci exec'
+type ciCommandResult struct {
+	Command  string
+	ExitCode int
+	Duration time.Duration
+	Output   string
+	// Error is set when the command could not be run at all
+	Error   string
+	Skipped bool
+}
+
+// Passed reports whether the command ran and exited 0
+func (r ciCommandResult) Passed() bool {
+	return !r.Skipped && r.Error == "" && r.ExitCode == 0
+}
+
+// newCICmd builds the ci command that runs commands in ephemeral agents for CI jobs
+func newCICmd() *cobra.Command {
+	ciCmd := &cobra.Command{
+		Use:   "ci",
+		Short: "Run CI jobs in ephemeral agents",
+	}
+
+	execCmd := &cobra.Command{
+		Use:   "exec [command...]",
+		Short: "Run commands in an ephemeral agent and always destroy it",
+		Long: `Create an agent defined by the ci section of capsulate.yaml, run each command in
+order inside it, export its artifacts and a JUnit XML summary into --output-dir, and
+destroy the agent, also when a command fails or the job is cancelled.
+
+Without --repo the agent clones the origin of the current checkout at its HEAD
+commit, which is what a CI job has checked out. Without commands the ci.commands of
+capsulate.yaml are run. The CLI exits 1 if any command failed.
+
+Output is formatted for the CI system detected from the environment (--style
+overrides it): collapsible groups and error annotations on GitHub Actions, with a
+problem matcher for file:line:col messages and a job summary, and collapsible
+sections on GitLab CI.
+
+Example capsulate.yaml:
+
+  ci:
+    template: node
+    depth: 1
+    commands:
+      - npm ci
+      - npm test
+    artifacts:
+      - coverage
+      - junit.xml`,
+		Run: func(cmd *cobra.Command, args []string) {
+			repoURL, _ := cmd.Flags().GetString("repo")
+			branch, _ := cmd.Flags().GetString("branch")
+			commit, _ := cmd.Flags().GetString("commit")
+			template, _ := cmd.Flags().GetString("template")
+			extraArtifacts, _ := cmd.Flags().GetStringArray("artifact")
+			outputDir, _ := cmd.Flags().GetString("output-dir")
+			junitPath, _ := cmd.Flags().GetString("junit")
+			style, _ := cmd.Flags().GetString("style")
+			keepGoing, _ := cmd.Flags().GetBool("keep-going")
+
+			cfg, err := config.Load(workspaceDir())
+			if err != nil {
+				errorf("Error loading config: %v\n", err)
+				exit(exitConfig)
+			}
+
+			commands := args
+			if len(commands) == 0 {
+				commands = cfg.CI.Commands
+			}
+			if len(commands) == 0 {
+				errorf("Error: no commands given and no ci.commands in capsulate.yaml\n")
+				exit(exitUsage)
+			}
+			if template == "" {
+				template = cfg.CI.Template
+			}
+			if style == "" {
+				style = detectCIStyle()
+			}
+			switch style {
+			case ciStyleGitHub, ciStyleGitLab, ciStylePlain:
+			default:
+				errorf("Error: unknown style '%s' (github, gitlab or plain)\n", style)
+				exit(exitUsage)
+			}
+
+			// Default to what the CI job checked out
+			if repoURL == "" {
+				repoURL, err = workspaceGit("remote", "get-url", "origin")
+				if err != nil {
+					errorf("Error: --repo not given and the origin of the current checkout is unknown: %v\n", err)
+					exit(exitUsage)
+				}
+				if branch == "" && commit == "" {
+					if commit, err = workspaceGit("rev-parse", "HEAD"); err != nil {
+						errorf("Error getting the checked out commit: %v\n", err)
+						exit(exitUsage)
+					}
+				}
+			}
+
+			logDir := filepath.Join(outputDir, "logs")
+			if err := os.MkdirAll(logDir, 0755); err != nil {
+				errorf("Error creating output directory: %v\n", err)
+				exit(exitCode(err))
+			}
+			if junitPath == "" {
+				junitPath = filepath.Join(outputDir, "junit.xml")
+			}
+
+			manager := newManager()
+			agentID, err := manager.GenerateAgentID()
+			if err != nil {
+				errorf("Error generating agent ID: %v\n", err)
+				exit(exitCode(err))
+			}
+			agentID = "ci-" + agentID
+
+			// The agent is destroyed exactly once: after the commands, or when the job
+			// is cancelled while they run
+			var destroyOnce sync.Once
+			destroy := func() {
+				destroyOnce.Do(func() {
+					if err := manager.DestroyWithOptions(agentID, agent.DestroyOptions{SkipTrash: true}); err != nil && !errors.Is(err, agent.ErrAgentNotFound) {
+						errorf("Warning: failed to destroy agent '%s': %v\n", agentID, err)
+						return
+					}
+					fmt.Printf("Agent '%s' destroyed\n", agentID)
+				})
+			}
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				sig := <-sigCh
+				errorf("Received %s, destroying agent '%s'\n", sig, agentID)
+				destroy()
+				exit(exitFailure)
+			}()
+
+			dependencyLevel := cfg.CI.DependencyLevel
+			if dependencyLevel == "" {
+				dependencyLevel = "container"
+			}
+
+			log := &ciLog{style: style}
+			log.group("Create agent " + agentID)
+			err = manager.Create(agent.AgentConfig{
+				ID:              agentID,
+				DependencyLevel: dependencyLevel,
+				RepoURL:         repoURL,
+				Branch:          branch,
+				Commit:          commit,
+				Depth:           cfg.CI.Depth,
+				Template:        template,
+				Labels:          map[string]string{"purpose": "ci"},
+			})
+			log.endGroup()
+			if err != nil {
+				log.error("Create agent", fmt.Sprintf("failed to create agent: %v", err))
+				destroy()
+				exit(exitCode(err))
+			}
+
+			matcher := false
+			if style == ciStyleGitHub {
+				if path, err := writeProblemMatcher(outputDir); err != nil {
+					errorf("Warning: failed to register problem matcher: %v\n", err)
+				} else {
+					fmt.Printf("::add-matcher::%s\n", path)
+					matcher = true
+				}
+			}
+
+			results := make([]ciCommandResult, 0, len(commands))
+			failed := false
+			for i, command := range commands {
+				if failed && !keepGoing {
+					results = append(results, ciCommandResult{Command: command, Skipped: true})
+					continue
+				}
+				result := runCICommand(manager, log, agentID, command, filepath.Join(logDir, fmt.Sprintf("%02d.log", i+1)))
+				results = append(results, result)
+				if !result.Passed() {
+					failed = true
+				}
+			}
+			if matcher {
+				fmt.Printf("::remove-matcher owner=%s::\n", ciMatcherOwner)
+			}
+
+			artifactPaths := append(append([]string{}, cfg.CI.Artifacts...), extraArtifacts...)
+			if len(artifactPaths) > 0 {
+				log.group("Export artifacts")
+				files, missing, err := manager.ExportArtifacts(agentID, artifactPaths, filepath.Join(outputDir, "artifacts"))
+				for _, file := range files {
+					fmt.Printf("%s (%s)\n", file.Path, formatBytes(file.Size))
+				}
+				for _, path := range missing {
+					errorf("Warning: artifact '%s' does not exist\n", path)
+				}
+				log.endGroup()
+				if err != nil {
+					log.error("Export artifacts", err.Error())
+					failed = true
+				}
+			}
+
+			destroy()
+			signal.Stop(sigCh)
+
+			if err := writeCIJUnit(junitPath, agentID, results); err != nil {
+				errorf("Warning: failed to write JUnit report: %v\n", err)
+			}
+			summary := ciSummary(results)
+			fmt.Println(summary)
+			if style == ciStyleGitHub {
+				writeStepSummary(summary, results)
+			}
+			if failed {
+				exit(exitFailure)
+			}
+		},
+	}
+	execCmd.Flags().StringP("repo", "r", "", "Git repository URL to clone (default: origin of the current checkout at HEAD)")
+	execCmd.Flags().StringP("branch", "b", "", "Branch to check out")
+	execCmd.Flags().String("commit", "", "Commit SHA to check out as a detached HEAD")
+	execCmd.MarkFlagsMutuallyExclusive("branch", "commit")
+	execCmd.Flags().String("template", "", "Environment template of the agent, overriding ci.template")
+	execCmd.Flags().StringArray("artifact", nil, "File or directory to export in addition to ci.artifacts (repeatable)")
+	execCmd.Flags().String("output-dir", "capsulate-ci", "Directory receiving command logs, artifacts and the JUnit report")
+	execCmd.Flags().String("junit", "", "Path of the JUnit XML report (default: junit.xml in --output-dir)")
+	execCmd.Flags().String("style", "", "Log style: github, gitlab or plain (default: detected from the environment)")
+	execCmd.Flags().Bool("keep-going", false, "Run the remaining commands after one fails")
+
+	ciCmd.AddCommand(execCmd)
+	return ciCmd
+}
+
+// runCICommand runs one command in the agent, printing its output in a group
+func runCICommand(manager *agent.Manager, log *ciLog, agentID, command, logFile string) ciCommandResult {
+	log.group(command)
+	start := time.Now()
+	result, err := manager.ExecWithOptions(agentID, command, agent.ExecOptions{
+		OutputFile: logFile,
+		WorkingDir: manager.WorkDir(agentID),
+		Record:     true,
+	})
+	outcome := ciCommandResult{Command: command, Duration: time.Since(start)}
+	if result != nil {
+		fmt.Print(result.Output)
+		if result.Output != "" && !strings.HasSuffix(result.Output, "\n") {
+			fmt.Println()
+		}
+		if result.Truncated() {
+			fmt.Printf("Output truncated: complete output in %s\n", logFile)
+		}
+		outcome.ExitCode = result.ExitCode
+		outcome.Output = result.Output
+	}
+	log.endGroup()
+
+	var exitErr *agent.ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		outcome.ExitCode = exitErr.Code
+		log.error(command, fmt.Sprintf("exited with code %d after %s", exitErr.Code, outcome.Duration.Round(time.Millisecond)))
+	case err != nil:
+		outcome.Error = err.Error()
+		log.error(command, err.Error())
+	}
+	return outcome
+}
+
+// ciLog writes the group markers and error annotations of a CI system
+type ciLog struct {
+	style   string
+	section string
+	counter int
+}
+
+// group starts a collapsible group of log lines
+func (l *ciLog) group(title string) {
+	switch l.style {
+	case ciStyleGitHub:
+		fmt.Printf("::group::%s\n", escapeWorkflowData(title))
+	case ciStyleGitLab:
+		l.counter++
+		l.section = fmt.Sprintf("capsulate_%d", l.counter)
+		fmt.Printf("\x1b[0Ksection_start:%d:%s[collapsed=true]\r\x1b[0K%s\n", time.Now().Unix(), l.section, title)
+	default:
+		fmt.Printf("==> %s\n", title)
+	}
+}
+
+// endGroup ends the current group
+func (l *ciLog) endGroup() {
+	switch l.style {
+	case ciStyleGitHub:
+		fmt.Println("::endgroup::")
+	case ciStyleGitLab:
+		fmt.Printf("\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", time.Now().Unix(), l.section)
+	}
+}
+
+// error reports a failure so that the CI system highlights it
+func (l *ciLog) error(title, message string) {
+	switch l.style {
+	case ciStyleGitHub:
+		fmt.Printf("::error title=%s::%s\n", escapeWorkflowProperty(title), escapeWorkflowData(message))
+	case ciStyleGitLab:
+		fmt.Printf("\x1b[31;1mERROR: %s: %s\x1b[0m\n", title, message)
+	default:
+		fmt.Printf("ERROR: %s: %s\n", title, message)
+	}
+}
+
+// detectCIStyle selects the log style of the CI system running the CLI
+func detectCIStyle() string {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		return ciStyleGitHub
+	case os.Getenv("GITLAB_CI") == "true":
+		return ciStyleGitLab
+	}
+	return ciStylePlain
+}
+
+// escapeWorkflowData escapes the message of a GitHub Actions workflow command
+func escapeWorkflowData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeWorkflowProperty escapes a property value of a GitHub Actions workflow command
+func escapeWorkflowProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// writeProblemMatcher writes the problem matcher file registered with GitHub Actions
+// and returns its absolute path
+func writeProblemMatcher(outputDir string) (string, error) {
+	matcher, err := filepath.Abs(filepath.Join(outputDir, "problem-matcher.json"))
+	if err != nil {
+		return "", err
+	}
+	return matcher, os.WriteFile(matcher, []byte(ciProblemMatcher), 0644)
+}
+
+// workspaceGit runs a Git command in the current checkout and returns its trimmed output
+func workspaceGit(args ...string) (string, error) {
+	output, err := exec.Command("git", append([]string{"-C", workspaceDir()}, args...)...).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+			return "", errors.New(strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// ciSummary summarizes the command results on one line
+func ciSummary(results []ciCommandResult) string {
+	passed, failed, skipped := 0, 0, 0
+	for _, result := range results {
+		switch {
+		case result.Skipped:
+			skipped++
+		case result.Passed():
+			passed++
+		default:
+			failed++
+		}
+	}
+	return fmt.Sprintf("%d commands: %d passed, %d failed, %d skipped", len(results), passed, failed, skipped)
+}
+
+// writeStepSummary appends a table of the command results to the job summary of
+// GitHub Actions
+func writeStepSummary(summary string, results []ciCommandResult) {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "### git-capsulate ci\n\n%s\n\n| Command | Result | Duration |\n|---|---|---|\n", summary)
+	for _, result := range results {
+		outcome := "✅ passed"
+		switch {
+		case result.Skipped:
+			outcome = "⏭️ skipped"
+		case result.Error != "":
+			outcome = "❌ error"
+		case result.ExitCode != 0:
+			outcome = fmt.Sprintf("❌ exit %d", result.ExitCode)
+		}
+		command := strings.ReplaceAll(result.Command, "|", "\\|")
+		fmt.Fprintf(&b, "| `%s` | %s | %s |\n", command, outcome, result.Duration.Round(time.Millisecond))
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		errorf("Warning: failed to write job summary: %v\n", err)
+		return
+	}
+	defer f.Close()
+	f.WriteString(b.String())
+}
+
+// JUnit XML elements of the 'ci exec' report
+type (
+	ciJUnitSuite struct {
+		XMLName   xml.Name      `xml:"testsuite"`
+		Name      string        `xml:"name,attr"`
+		Tests     int           `xml:"tests,attr"`
+		Failures  int           `xml:"failures,attr"`
+		Errors    int           `xml:"errors,attr"`
+		Skipped   int           `xml:"skipped,attr"`
+		Time      string        `xml:"time,attr"`
+		Timestamp string        `xml:"timestamp,attr"`
+		Cases     []ciJUnitCase `xml:"testcase"`
+	}
+	ciJUnitCase struct {
+		Name      string          `xml:"name,attr"`
+		Classname string          `xml:"classname,attr"`
+		Time      string          `xml:"time,attr"`
+		Failure   *ciJUnitMessage `xml:"failure,omitempty"`
+		Error     *ciJUnitMessage `xml:"error,omitempty"`
+		Skipped   *struct{}       `xml:"skipped,omitempty"`
+	}
+	ciJUnitMessage struct {
+		Message string `xml:"message,attr"`
+		Text    string `xml:",chardata"`
+	}
+)
+
+// invalidXMLChars matches control characters that XML 1.0 documents cannot contain
+var invalidXMLChars = regexp.MustCompile("[\x00-\x08\x0B\x0C\x0E-\x1F]")
+
+// writeCIJUnit writes a JUnit XML report with a test case per command
+func writeCIJUnit(path, agentID string, results []ciCommandResult) error {
+	suite := ciJUnitSuite{
+		Name:      "git-capsulate ci " + agentID,
+		Tests:     len(results),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	var total time.Duration
+	for _, result := range results {
+		total += result.Duration
+		testCase := ciJUnitCase{
+			Name:      result.Command,
+			Classname: "git-capsulate.ci",
+			Time:      fmt.Sprintf("%.3f", result.Duration.Seconds()),
+		}
+		switch {
+		case result.Skipped:
+			suite.Skipped++
+			testCase.Skipped = &struct{}{}
+		case result.Error != "":
+			suite.Errors++
+			testCase.Error = &ciJUnitMessage{Message: result.Error}
+		case result.ExitCode != 0:
+			suite.Failures++
+			testCase.Failure = &ciJUnitMessage{
+				Message: fmt.Sprintf("exited with code %d", result.ExitCode),
+				Text:    invalidXMLChars.ReplaceAllString(lastLines(result.Output, ciOutputLines), ""),
+			}
+		}
+		suite.Cases = append(suite.Cases, testCase)
+	}
+	suite.Time = fmt.Sprintf("%.3f", total.Seconds())
+
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), 0644)
+}
+
+// lastLines returns the last n lines of s
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newCommitCmd builds the commit command that records an agent's changes
+func newCommitCmd() *cobra.Command {
+	commitCmd := &cobra.Command{
+		Use:   "commit [agent-id] [paths...]",
+		Short: "Commit changes inside an agent",
+		Long: `Commit the staged changes of an agent's repository. Any paths given are staged first.
+
+When provenance is enabled in capsulate.yaml (or with --provenance), the commit
+message gets Capsulate-Agent, Capsulate-Template, Capsulate-Version and
+Capsulate-Trace-Id trailers so the commit can be traced back to the agent run
+that produced it.
+
+With --suggested, the message drafted by 'git-capsulate suggest commit-message' is
+used.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID := args[0]
+
+			message, _ := cmd.Flags().GetString("message")
+			all, _ := cmd.Flags().GetBool("all")
+			suggested, _ := cmd.Flags().GetBool("suggested")
+			if message == "" && !suggested {
+				errorf("Error: a commit message is required (--message or --suggested)\n")
+				exit(exitUsage)
+			}
+
+			opts := agent.CommitOptions{
+				Message:   message,
+				All:       all,
+				Paths:     args[1:],
+				Suggested: suggested,
+			}
+			if cmd.Flags().Changed("provenance") {
+				provenance, _ := cmd.Flags().GetBool("provenance")
+				opts.Provenance = &provenance
+			}
+
+			manager := newManager()
+			result, err := manager.Commit(agentID, opts)
+			if err != nil {
+				errorf("Error committing changes: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			// Quiet mode prints the full SHA alone so scripts can capture it
+			if quiet {
+				fmt.Println(result.SHA)
+				return
+			}
+
+			sha := result.SHA
+			if len(sha) > 7 {
+				sha = sha[:7]
+			}
+			fmt.Printf("Committed %s on branch '%s' in agent '%s'\n", sha, result.Branch, agentID)
+			keys := make([]string, 0, len(result.Trailers))
+			for key := range result.Trailers {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				fmt.Printf("  %s: %s\n", key, result.Trailers[key])
+			}
+		},
+	}
+
+	commitCmd.Flags().StringP("message", "m", "", "Commit message")
+	commitCmd.Flags().BoolP("all", "a", false, "Stage all modified and deleted tracked files")
+	commitCmd.Flags().Bool("provenance", false, "Append provenance trailers (overrides provenance.enabled in capsulate.yaml)")
+	commitCmd.Flags().Bool("suggested", false, "Use the message drafted by 'git-capsulate suggest commit-message'")
+	commitCmd.MarkFlagsMutuallyExclusive("message", "suggested")
+
+	return commitCmd
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newCommitImageCmd builds the commit-image command that exports an agent as a Docker
+// image
+func newCommitImageCmd() *cobra.Command {
+	commitImageCmd := &cobra.Command{
+		Use:   "commit-image [agent-id]",
+		Short: "Commit an agent's container to a Docker image",
+		Long: `Commit an agent's container, with the packages and tools installed in it, to a
+Docker image, so that a fully prepared environment can be reused or archived.
+
+The repository is copied into the image at /workspace/repo unless --no-workspace
+is given. Registry credentials injected into the agent, and credential files of
+the repository that are not committed to it, are removed, and the image is
+flattened into a single layer so that no layer keeps them. Its capsulate.agent-id
+label is blanked, so containers of the image are not taken for agents. The agent is paused while its container is committed
+and keeps running afterwards.
+
+With --push, the image is pushed to its registry, with the credentials of the
+registry configured with provider docker for its host in capsulate.yaml:
+
+  registries:
+    - provider: docker
+      url: https://ghcr.io
+      username: myorg-bot
+      token: env:GHCR_TOKEN`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			tag, _ := cmd.Flags().GetString("tag")
+			noWorkspace, _ := cmd.Flags().GetBool("no-workspace")
+			push, _ := cmd.Flags().GetBool("push")
+			format, _ := cmd.Flags().GetString("format")
+
+			manager := newManager()
+			result, err := manager.CommitImage(args[0], agent.CommitImageOptions{
+				Tag:              tag,
+				ExcludeWorkspace: noWorkspace,
+				Push:             push,
+			})
+			if err != nil && result == nil {
+				errorf("Error committing image: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, jsonErr := json.MarshalIndent(result, "", "  ")
+				if jsonErr != nil {
+					errorf("Error marshaling image to JSON: %v\n", jsonErr)
+					exit(exitCode(jsonErr))
+				}
+				fmt.Println(string(jsonData))
+			} else if quiet {
+				fmt.Println(result.ImageID)
+			} else {
+				what := "with its workspace"
+				if !result.Workspace {
+					what = "without its workspace"
+				}
+				fmt.Printf("✅ Committed agent '%s' %s to %s (%s)\n", result.AgentID, what, result.Tag, shortImageID(result.ImageID))
+				if result.Pushed {
+					fmt.Printf("Pushed %s@%s\n", result.Tag, result.Digest)
+				}
+			}
+			if err != nil {
+				errorf("Error pushing image: %v\n", err)
+				exit(exitCode(err))
+			}
+		},
+	}
+	commitImageCmd.Flags().StringP("tag", "t", "", "Name of the image, e.g. myorg/agent-result:run42")
+	commitImageCmd.Flags().Bool("no-workspace", false, "Leave the repository out of the image")
+	commitImageCmd.Flags().Bool("push", false, "Push the image to its registry after committing it")
+	commitImageCmd.Flags().String("format", "text", "Output format (text or json)")
+	commitImageCmd.MarkFlagRequired("tag")
+	return commitImageCmd
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newConflictsCmd builds the conflicts command that lists and shows conflicted files
+func newConflictsCmd() *cobra.Command {
+	conflictsCmd := &cobra.Command{
+		Use:   "conflicts [agent-id]",
+		Short: "List conflicted files in an agent",
+		Long: `List the files left unmerged by a merge, rebase or cherry-pick in an agent's
+repository, with the versions (base, ours, theirs) each has. Use --show with --side to
+print one version of a file. Resolve files with the resolve command.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID := args[0]
+			format, _ := cmd.Flags().GetString("format")
+			show, _ := cmd.Flags().GetString("show")
+			side, _ := cmd.Flags().GetString("side")
+
+			manager := newManager()
+
+			if show != "" {
+				content, err := manager.ConflictContent(agentID, show, agent.ConflictSide(side))
+				if err != nil {
+					errorf("Error reading conflict: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Print(content)
+				return
+			}
+
+			conflicts, err := manager.GetConflicts(agentID)
+			if err != nil {
+				errorf("Error listing conflicts: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				if conflicts == nil {
+					conflicts = []agent.Conflict{}
+				}
+				jsonData, err := json.MarshalIndent(conflicts, "", "  ")
+				if err != nil {
+					errorf("Error marshaling conflicts to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			if len(conflicts) == 0 {
+				infof("No conflicts in agent '%s'\n", agentID)
+				return
+			}
+			for _, conflict := range conflicts {
+				fmt.Printf("%s %s\n", conflict.Type, conflict.Path)
+			}
+		},
+	}
+	conflictsCmd.Flags().String("format", "text", "Output format (text or json)")
+	conflictsCmd.Flags().String("show", "", "Print one version of this conflicted file")
+	conflictsCmd.Flags().String("side", string(agent.SideOurs), "Version printed by --show (base, ours or theirs)")
+
+	return conflictsCmd
+}
+
+// newResolveCmd builds the resolve command that resolves a conflicted file
+func newResolveCmd() *cobra.Command {
+	resolveCmd := &cobra.Command{
+		Use:   "resolve [agent-id] [path]",
+		Short: "Resolve a conflicted file in an agent",
+		Long: `Resolve a conflicted file by taking our or their version, or by writing the merged
+content from a file (- for stdin), and mark it resolved. Taking a side that deleted
+the file removes it. The merge is not committed.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID := args[0]
+			file := args[1]
+			ours, _ := cmd.Flags().GetBool("ours")
+			theirs, _ := cmd.Flags().GetBool("theirs")
+			contentFile, _ := cmd.Flags().GetString("content-file")
+
+			var resolution agent.Resolution
+			switch {
+			case ours:
+				resolution.Side = agent.SideOurs
+			case theirs:
+				resolution.Side = agent.SideTheirs
+			case contentFile != "":
+				var content []byte
+				var err error
+				if contentFile == "-" {
+					content, err = io.ReadAll(os.Stdin)
+				} else {
+					content, err = os.ReadFile(contentFile)
+				}
+				if err != nil {
+					errorf("Error reading resolution: %v\n", err)
+					exit(exitCode(err))
+				}
+				resolution.Content = string(content)
+			default:
+				errorf("Error: one of --ours, --theirs or --content-file is required\n")
+				exit(exitUsage)
+			}
+
+			manager := newManager()
+			if err := manager.ResolveConflict(agentID, file, resolution); err != nil {
+				errorf("Error resolving conflict: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			infof("Resolved %s in agent '%s'\n", file, agentID)
+		},
+	}
+	resolveCmd.Flags().Bool("ours", false, "Take our version of the file")
+	resolveCmd.Flags().Bool("theirs", false, "Take their version of the file")
+	resolveCmd.Flags().String("content-file", "", "Write the merged content from this file (- for stdin)")
+	resolveCmd.MarkFlagsMutuallyExclusive("ours", "theirs", "content-file")
+
+	return resolveCmd
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// newCoreDepsCmd builds the core-deps command group that manages the core dependency layer
+func newCoreDepsCmd() *cobra.Command {
+	coreDepsCmd := &cobra.Command{
+		Use:   "core-deps",
+		Short: "Manage core dependencies shared by all agents",
+		Long: `Populate the core dependency layer (.capsulate/dependencies/core). Packages are
+installed by a dependency provider (npm, go, pip or cargo) in a throwaway container
+and recorded in capsulate-deps.json. The layer is mounted read-only into every agent.`,
+	}
+
+	installCmd := &cobra.Command{
+		Use:   "install [package[@version]...]",
+		Short: "Install packages into the core layer",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			provider, _ := cmd.Flags().GetString("provider")
+
+			manager := newManager()
+			for _, spec := range args {
+				record, err := manager.InstallCoreDependency(provider, spec)
+				if err != nil {
+					errorf("Error installing core dependency: %v\n", err)
+					exit(exitCode(err))
+				}
+				infof("Installed %s@%s into core dependencies (%s)\n", record.Name, record.Version, record.Provider)
+			}
+		},
+	}
+	installCmd.Flags().StringP("provider", "p", "", "Dependency provider (npm, go, pip, cargo); detected from the workspace by default")
+
+	syncCmd := &cobra.Command{
+		Use:   "sync",
+		Short: "Make the core layer match a lockfile",
+		Long: `Install exactly the packages pinned in a lockfile (package-lock.json, go.sum,
+requirements.txt or Cargo.lock) into the core layer. Without --from-lockfile the
+core layer is reinstalled from the files it already holds.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			lockfile, _ := cmd.Flags().GetString("from-lockfile")
+			provider, _ := cmd.Flags().GetString("provider")
+
+			manager := newManager()
+			records, err := manager.SyncCoreDependencies(provider, lockfile)
+			if err != nil {
+				errorf("Error syncing core dependencies: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("Core dependencies synced (%d packages)\n", len(records))
+		},
+	}
+	syncCmd.Flags().String("from-lockfile", "", "Lockfile to install from (default package-lock.json when given without a value)")
+	syncCmd.Flags().Lookup("from-lockfile").NoOptDefVal = "package-lock.json"
+	syncCmd.Flags().StringP("provider", "p", "", "Dependency provider; inferred from the lockfile name by default")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List packages in the core layer",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			manager := newManager()
+			manifest, err := manager.CoreDependencies()
+			if err != nil {
+				errorf("Error reading core dependencies: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(manifest, "", "  ")
+				if err != nil {
+					errorf("Error marshaling manifest to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			infof("📦 Core dependencies:\n")
+			infof("==========================================\n")
+			if len(manifest.Packages) == 0 {
+				fmt.Println("No core dependencies installed")
+				return
+			}
+			for _, pkg := range manifest.Packages {
+				fmt.Printf("%-8s %-40s %-14s %s\n", pkg.Provider, pkg.Name, pkg.Version, pkg.Source)
+			}
+		},
+	}
+	listCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	coreDepsCmd.AddCommand(installCmd)
+	coreDepsCmd.AddCommand(syncCmd)
+	coreDepsCmd.AddCommand(listCmd)
+
+	return coreDepsCmd
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// newDedupCmd builds the dedup command that shares identical workspace files across
+// agents of the same repository
+func newDedupCmd() *cobra.Command {
+	dedupCmd := &cobra.Command{
+		Use:   "dedup [agent-id...]",
+		Short: "Share identical workspace files across agents of the same repository",
+		Long: `Reclaim the space of duplicate checkouts on hosts running many agents of one
+repository. Files of the agents' workspaces are compared by content, per
+repository, and each duplicate is replaced by a link to one copy:
+
+  - Git's objects and packs, which Git never writes in place, by hard links
+  - working tree files by reflinks, copy-on-write clones on btrfs or XFS, which
+    split again as soon as an agent changes them
+
+Where the file system has no reflinks, working tree files are left alone unless
+--hardlink is given. A hard-linked file is one file for every agent sharing it:
+tools that write it in place instead of replacing it change it for all of them.
+Git's other metadata, such as the index, is never touched.
+
+Agents with overlay workspaces share their base already and are skipped. Without
+agent IDs, every agent takes part.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			hardLink, _ := cmd.Flags().GetBool("hardlink")
+			minSize, _ := cmd.Flags().GetString("min-size")
+			format, _ := cmd.Flags().GetString("format")
+
+			opts := agent.DedupOptions{AgentIDs: args, HardLink: hardLink, DryRun: dryRun}
+			if minSize != "" {
+				size, err := config.ParseByteSize(minSize)
+				if err != nil {
+					errorf("Error: --min-size: %v\n", err)
+					exit(exitUsage)
+				}
+				opts.MinSize = int64(size)
+			}
+
+			manager := newManager()
+			report, err := manager.Dedup(opts)
+			if err != nil {
+				errorf("Error deduplicating workspaces: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					errorf("Error marshaling dedup report to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			displayDedupReport(report)
+		},
+	}
+	dedupCmd.Flags().Bool("dry-run", false, "Only report what would be shared")
+	dedupCmd.Flags().Bool("hardlink", false, "Hard-link working tree files where reflinks are not supported")
+	dedupCmd.Flags().String("min-size", "", "Skip files smaller than this, e.g. 64KB (default 4KB)")
+	dedupCmd.Flags().String("format", "text", "Output format (text or json)")
+	return dedupCmd
+}
+
+// displayDedupReport prints the files shared per repository and the space reclaimed
+func displayDedupReport(report *agent.DedupReport) {
+	if len(report.Groups) == 0 {
+		infof("No two agents share a repository outside overlay workspaces\n")
+		return
+	}
+	verb, total := "Shared", "Reclaimed"
+	if report.DryRun {
+		verb, total = "Would share", "Would reclaim"
+	}
+	for _, group := range report.Groups {
+		fmt.Printf("%s (%d agents)\n", group.RepoURL, len(group.Agents))
+		fmt.Printf("  %s %d files, %s: %d hard links, %d reflinks", verb, group.Files, formatBytes(group.Bytes),
+			group.HardLinks, group.Reflinks)
+		if group.Skipped > 0 {
+			fmt.Printf(", %d skipped", group.Skipped)
+		}
+		fmt.Println()
+	}
+	infof("%s %s\n", total, formatBytes(report.Reclaimed))
+}
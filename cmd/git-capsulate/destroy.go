@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// guardDestroy decides whether an agent may be destroyed. With snapshotFirst its work
+// is saved to a bundle first; otherwise an agent with uncommitted changes, stashes or
+// unpushed commits is only destroyed with force or after confirming at a terminal.
+func guardDestroy(manager *agent.Manager, agentID string, force, snapshotFirst bool) bool {
+	if snapshotFirst {
+		snapshot, err := manager.SnapshotWork(agentID)
+		if err != nil {
+			errorf("Error snapshotting agent '%s', not destroying it: %v\n", agentID, err)
+			return false
+		}
+		infof("Snapshot of agent '%s' written to %s (%s)\n", agentID, snapshot.Path, formatBytes(snapshot.Size))
+		return true
+	}
+	if force {
+		return true
+	}
+
+	unsaved, err := manager.UnsavedWork(agentID)
+	if errors.Is(err, agent.ErrAgentNotFound) {
+		// Nothing to lose; destroy removes what is left or reports the agent missing
+		return true
+	}
+	if err != nil {
+		errorf("Error checking agent '%s' for unsaved work: %v\n", agentID, err)
+		errorf("Use --force to destroy it anyway\n")
+		return false
+	}
+	if len(unsaved) == 0 {
+		return true
+	}
+
+	work := strings.Join(unsaved, ", ")
+	if !isTerminal(os.Stdin) {
+		errorf("Error: agent '%s' has %s\n", agentID, work)
+		errorf("Use --snapshot-first to save the work to a bundle, or --force to discard it\n")
+		return false
+	}
+	errorf("Agent '%s' has %s.\nDestroy it anyway? [y/N] ", agentID, work)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	errorf("Agent '%s' kept\n", agentID)
+	return false
+}
+
+// isTerminal reports whether f is an interactive terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// previewDestroy prints what destroying an agent would remove and leave in place. It
+// reports whether the agent could be inspected.
+func previewDestroy(manager *agent.Manager, agentID string, opts agent.DestroyOptions) bool {
+	plan, err := manager.PlanDestroy(agentID, opts)
+	if err != nil {
+		errorf("Error inspecting agent '%s': %v\n", agentID, err)
+		return false
+	}
+
+	infof("Would destroy agent '%s':\n", agentID)
+	if unsaved, err := manager.UnsavedWork(agentID); err == nil && len(unsaved) > 0 {
+		fmt.Printf("  Unsaved work: %s (destroy asks for confirmation)\n", strings.Join(unsaved, ", "))
+	}
+	if !opts.SkipScrub {
+		fmt.Printf("  %-7s %-10s %10s  %s\n", "scrub", "files", "-", "credential files in the repository and dependencies")
+	}
+	for _, r := range plan.Removed {
+		fmt.Printf("  %-7s %-10s %10s  %s\n", "remove", r.Kind, resourceSize(r), r.Name)
+	}
+	for _, r := range plan.Trashed {
+		fmt.Printf("  %-7s %-10s %10s  %s\n", "trash", r.Kind, resourceSize(r), r.Name)
+	}
+	for _, r := range plan.Kept {
+		fmt.Printf("  %-7s %-10s %10s  %s\n", "keep", r.Kind, resourceSize(r), r.Name)
+	}
+	infof("Would reclaim %s; %s stays on disk\n", formatBytes(plan.Reclaimed), formatBytes(plan.Retained))
+	return true
+}
+
+// resourceSize formats the size of an affected resource, "-" for the kinds that are
+// not measured
+func resourceSize(r agent.AffectedResource) string {
+	if r.Kind == agent.ResourceState || r.Kind == agent.ResourceVolume {
+		return "-"
+	}
+	return formatBytes(r.Size)
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/your-org/capsulate-repo/pkg/version"
+)
+
+// newDocsCmd builds the docs command that generates man pages and example scripts
+func newDocsCmd() *cobra.Command {
+	docsCmd := &cobra.Command{
+		Use:   "docs",
+		Short: "Generate man pages and runnable example scripts",
+	}
+
+	manCmd := &cobra.Command{
+		Use:   "man",
+		Short: "Write a man page for every command",
+		Long: `Write a man page in section 1 for every command, git-capsulate.1 and
+git-capsulate-<command>.1 such as git-capsulate-team-deps-freeze.1, generated from
+the commands' help, flags and examples. Install them into a man directory, e.g.
+
+  git-capsulate docs man --dir /usr/local/share/man/man1
+
+The date of the pages is SOURCE_DATE_EPOCH when set, for reproducible builds.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			dir, _ := cmd.Flags().GetString("dir")
+
+			date := time.Now()
+			if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+				seconds, err := strconv.ParseInt(epoch, 10, 64)
+				if err != nil {
+					errorf("Error: invalid SOURCE_DATE_EPOCH '%s'\n", epoch)
+					exit(exitUsage)
+				}
+				date = time.Unix(seconds, 0)
+			}
+
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				errorf("Error creating output directory: %v\n", err)
+				exit(exitCode(err))
+			}
+			count, err := writeManPages(cmd.Root(), dir, date.UTC())
+			if err != nil {
+				errorf("Error writing man pages: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("✅ Wrote %d man pages to %s\n", count, dir)
+		},
+	}
+	manCmd.Flags().String("dir", "man", "Directory to write the man pages to")
+
+	examplesCmd := &cobra.Command{
+		Use:   "examples",
+		Short: "Write the example workflows as runnable scripts",
+		Long: `Write each workflow of 'git-capsulate help examples' as a runnable bash script,
+<topic>.sh. The scripts create and destroy agents of their own; their variables,
+such as REPO, are read from the environment.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			dir, _ := cmd.Flags().GetString("dir")
+
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				errorf("Error creating output directory: %v\n", err)
+				exit(exitCode(err))
+			}
+			for _, topic := range exampleTopics {
+				path := filepath.Join(dir, topic.Name+".sh")
+				if err := os.WriteFile(path, []byte(topic.script(cmd.Root(), true)), 0755); err != nil {
+					errorf("Error writing file: %v\n", err)
+					exit(exitCode(err))
+				}
+			}
+			infof("✅ Wrote %d example scripts to %s\n", len(exampleTopics), dir)
+		},
+	}
+	examplesCmd.Flags().String("dir", "examples", "Directory to write the scripts to")
+
+	docsCmd.AddCommand(manCmd)
+	docsCmd.AddCommand(examplesCmd)
+	return docsCmd
+}
+
+// manPageName returns the name of the man page of a command, e.g.
+// git-capsulate-team-deps-freeze
+func manPageName(cmd *cobra.Command) string {
+	return strings.ReplaceAll(cmd.CommandPath(), " ", "-")
+}
+
+// writeManPages writes the man page of a command and of its subcommands, skipping
+// hidden commands and help topics, and returns how many it wrote
+func writeManPages(cmd *cobra.Command, dir string, date time.Time) (int, error) {
+	count := 0
+	for _, sub := range cmd.Commands() {
+		if !sub.IsAvailableCommand() || sub.IsAdditionalHelpTopicCommand() {
+			continue
+		}
+		n, err := writeManPages(sub, dir, date)
+		if err != nil {
+			return count, err
+		}
+		count += n
+	}
+	path := filepath.Join(dir, manPageName(cmd)+".1")
+	if err := os.WriteFile(path, []byte(manPage(cmd, date)), 0644); err != nil {
+		return count, err
+	}
+	return count + 1, nil
+}
+
+// manPage renders the man page of a command in roff
+func manPage(cmd *cobra.Command, date time.Time) string {
+	var b strings.Builder
+	name := manPageName(cmd)
+	fmt.Fprintf(&b, ".TH \"%s\" \"1\" \"%s\" \"git-capsulate %s\" \"git-capsulate Manual\"\n",
+		strings.ToUpper(name), date.Format("2006-01-02"), version.Version)
+
+	b.WriteString(".SH NAME\n")
+	fmt.Fprintf(&b, "%s \\- %s\n", name, roffEscape(cmd.Short))
+
+	b.WriteString(".SH SYNOPSIS\n")
+	if cmd.Runnable() {
+		fmt.Fprintf(&b, "\\fB%s\\fP\n", roffEscape(cmd.UseLine()))
+	}
+	if cmd.HasAvailableSubCommands() {
+		if cmd.Runnable() {
+			b.WriteString(".br\n")
+		}
+		fmt.Fprintf(&b, "\\fB%s [command]\\fP\n", roffEscape(cmd.CommandPath()))
+	}
+
+	b.WriteString(".SH DESCRIPTION\n")
+	description := cmd.Long
+	if description == "" {
+		description = cmd.Short
+	}
+	writeRoffText(&b, description)
+
+	writeRoffFlags(&b, "OPTIONS", cmd.NonInheritedFlags())
+	writeRoffFlags(&b, "OPTIONS INHERITED FROM PARENT COMMANDS", cmd.InheritedFlags())
+
+	if cmd.Example != "" {
+		b.WriteString(".SH EXAMPLES\n")
+		writeRoffPreformatted(&b, cmd.Example)
+	}
+
+	workflows := commandWorkflows(cmd)
+	if !cmd.HasParent() {
+		for _, topic := range exampleTopics {
+			workflows = append(workflows, topic.Name)
+		}
+	}
+	if len(workflows) > 0 {
+		b.WriteString(".SH WORKFLOWS\n")
+		b.WriteString("Step-by-step examples, shown by \\fBgit\\-capsulate help examples\\fP \\fItopic\\fP:\n")
+		for _, workflow := range workflows {
+			for _, topic := range exampleTopics {
+				if topic.Name == workflow {
+					fmt.Fprintf(&b, ".TP\n\\fB%s\\fP\n%s\n", roffEscape(topic.Name), roffEscape(topic.Short))
+				}
+			}
+		}
+	}
+
+	var seeAlso []string
+	if cmd.HasParent() {
+		seeAlso = append(seeAlso, manPageName(cmd.Parent()))
+	}
+	for _, sub := range cmd.Commands() {
+		if sub.IsAvailableCommand() && !sub.IsAdditionalHelpTopicCommand() {
+			seeAlso = append(seeAlso, manPageName(sub))
+		}
+	}
+	if len(seeAlso) > 0 {
+		sort.Strings(seeAlso)
+		refs := make([]string, len(seeAlso))
+		for i, page := range seeAlso {
+			refs[i] = fmt.Sprintf("\\fB%s\\fP(1)", roffEscape(page))
+		}
+		b.WriteString(".SH SEE ALSO\n")
+		b.WriteString(strings.Join(refs, ", ") + "\n")
+	}
+	return b.String()
+}
+
+// writeRoffFlags writes a section listing flags, if there are any besides hidden ones
+func writeRoffFlags(b *strings.Builder, title string, flags *pflag.FlagSet) {
+	if !flags.HasAvailableFlags() {
+		return
+	}
+	fmt.Fprintf(b, ".SH %s\n", title)
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if flag.Hidden {
+			return
+		}
+		b.WriteString(".TP\n")
+		if flag.Shorthand != "" && flag.ShorthandDeprecated == "" {
+			fmt.Fprintf(b, "\\fB\\-%s\\fP, ", roffEscape(flag.Shorthand))
+		}
+		fmt.Fprintf(b, "\\fB\\-\\-%s\\fP", roffEscape(flag.Name))
+		varName, usage := pflag.UnquoteUsage(flag)
+		if varName != "" {
+			fmt.Fprintf(b, " \\fI%s\\fP", roffEscape(varName))
+		}
+		b.WriteString("\n")
+		switch flag.DefValue {
+		case "", "false", "0", "0s", "[]":
+		default:
+			usage += fmt.Sprintf(" (default %s)", flag.DefValue)
+		}
+		fmt.Fprintf(b, "%s\n", roffEscape(usage))
+	})
+}
+
+// writeRoffText writes help text as roff paragraphs. Lines indented in the help,
+// such as commands and configuration, are kept as they are.
+func writeRoffText(b *strings.Builder, text string) {
+	for i, paragraph := range strings.Split(strings.TrimSpace(text), "\n\n") {
+		if strings.HasPrefix(paragraph, " ") {
+			writeRoffPreformatted(b, paragraph)
+			continue
+		}
+		if i > 0 {
+			b.WriteString(".PP\n")
+		}
+		for _, line := range strings.Split(paragraph, "\n") {
+			b.WriteString(roffLine(line) + "\n")
+		}
+	}
+}
+
+// writeRoffPreformatted writes text without filling or adjusting its lines
+func writeRoffPreformatted(b *strings.Builder, text string) {
+	b.WriteString(".PP\n.RS\n.nf\n")
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		b.WriteString(roffLine(line) + "\n")
+	}
+	b.WriteString(".fi\n.RE\n")
+}
+
+// roffLine escapes a line of text, so that one starting with a period or an
+// apostrophe is not taken for a request
+func roffLine(line string) string {
+	line = roffEscape(line)
+	if strings.HasPrefix(line, ".") || strings.HasPrefix(line, "'") {
+		return "\\&" + line
+	}
+	return line
+}
+
+// roffEscape escapes backslashes and hyphens, which roff would otherwise interpret
+func roffEscape(s string) string {
+	s = strings.ReplaceAll(s, "\\", "\\e")
+	return strings.ReplaceAll(s, "-", "\\-")
+}
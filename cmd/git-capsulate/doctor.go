@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/dockerclient"
+)
+
+// newDoctorCmd builds the doctor command that checks the setup git-capsulate runs in
+func newDoctorCmd() *cobra.Command {
+	doctorCmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the Docker daemon, configuration and container setup",
+		Long: `Check that the Docker daemon is reachable and compatible and that capsulate.yaml
+is valid, and look for orphaned resources: containers of agents without state, state
+entries whose container is gone, overlay directories of unknown agents and dangling
+images, which 'prune --orphans' removes, and for agents whose healthcheck fails.
+Exits with code 1 when a check fails; warnings alone exit 0.
+
+With --in-container, also check running git-capsulate inside a container. Two setups
+are supported and detected automatically:
+
+  socket  the host's daemon through its socket (docker run -v /var/run/docker.sock:...);
+          directories git-capsulate bind mounts into agents must be mounted from the
+          host, and their paths are translated to host paths
+  dind    a Docker-in-Docker daemon in the container, or in a sidecar sharing the
+          workspace volume at the same path
+
+A throwaway container from the base image checks that bind mounts show the files
+git-capsulate wrote. The simplest socket setup mounts the workspace at its host path:
+
+  docker run -v /var/run/docker.sock:/var/run/docker.sock \
+    -v "$PWD:$PWD" -w "$PWD" -v "$HOME/.ssh:/root/.ssh:ro" <image> git-capsulate doctor --in-container`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			inContainer, _ := cmd.Flags().GetBool("in-container")
+			format, _ := cmd.Flags().GetString("format")
+
+			var checks []agent.DoctorCheck
+			ctx, cancel := context.WithTimeout(context.Background(), dockerProbeTimeout)
+			defer cancel()
+			docker, err := dockerclient.GetVersion(ctx)
+			if err != nil {
+				checks = append(checks, agent.DoctorCheck{Name: "docker", Status: agent.CheckError, Detail: err.Error(),
+					Hint: "start the Docker daemon, or mount its socket into this container"})
+			} else {
+				checks = append(checks, agent.DoctorCheck{Name: "docker", Status: agent.CheckOK,
+					Detail: fmt.Sprintf("daemon %s (API %s, %s/%s)", docker.ServerVersion, docker.APIVersion, docker.OS, docker.Arch)})
+				for _, warning := range docker.CompatibilityWarnings() {
+					checks = append(checks, agent.DoctorCheck{Name: "docker", Status: agent.CheckWarning, Detail: warning})
+				}
+			}
+
+			checks = append(checks, configCheck())
+
+			if docker == nil {
+				checks = append(checks, agent.DoctorCheck{Name: "orphans", Status: agent.CheckWarning,
+					Detail: "not checked: the Docker daemon is not reachable"})
+				checks = append(checks, agent.DoctorCheck{Name: "health", Status: agent.CheckWarning,
+					Detail: "not checked: the Docker daemon is not reachable"})
+			} else {
+				manager := newManager()
+				checks = append(checks, manager.CheckOrphans(), manager.CheckHealth())
+			}
+
+			if inContainer {
+				if docker == nil {
+					checks = append(checks, agent.DoctorCheck{Name: "container", Status: agent.CheckError,
+						Detail: "not checked: the Docker daemon is not reachable"})
+				} else {
+					checks = append(checks, newManager().CheckInContainer()...)
+				}
+			}
+
+			failed := false
+			for _, check := range checks {
+				failed = failed || check.Status == agent.CheckError
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(checks, "", "  ")
+				if err != nil {
+					errorf("Error marshaling checks to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+			} else {
+				for _, check := range checks {
+					fmt.Printf("%-8s %-16s %s\n", "["+check.Status+"]", check.Name, check.Detail)
+					if check.Hint != "" && check.Status != agent.CheckOK {
+						fmt.Printf("%-8s %-16s hint: %s\n", "", "", check.Hint)
+					}
+				}
+			}
+			if failed {
+				exit(exitFailure)
+			}
+		},
+	}
+	doctorCmd.Flags().Bool("in-container", false, "Also check running git-capsulate inside a container (socket mount or Docker-in-Docker)")
+	doctorCmd.Flags().String("format", "text", "Output format (text or json)")
+	return doctorCmd
+}
+
+// configCheck validates the configuration file of the workspace, if there is one
+func configCheck() agent.DoctorCheck {
+	check := agent.DoctorCheck{Name: "config", Status: agent.CheckOK}
+	path := config.ResolvePath(workspaceDir())
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		check.Detail = fmt.Sprintf("no %s, defaults are used", config.FileName)
+		return check
+	}
+	issues, err := config.ValidateFile(path)
+	if err != nil {
+		check.Status, check.Detail = agent.CheckError, err.Error()
+		return check
+	}
+	errors, warnings := 0, 0
+	for _, issue := range issues {
+		if issue.Severity == config.SeverityError {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+	check.Detail = fmt.Sprintf("%s: %d errors, %d warnings", path, errors, warnings)
+	switch {
+	case errors > 0:
+		check.Status, check.Hint = agent.CheckError, "run 'git-capsulate validate' for details"
+	case warnings > 0:
+		check.Status, check.Hint = agent.CheckWarning, "run 'git-capsulate validate' for details"
+	}
+	return check
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/diskusage"
+)
+
+// newDuCmd builds the du command that reports the disk space taken by capsulate storage
+func newDuCmd() *cobra.Command {
+	duCmd := &cobra.Command{
+		Use:   "du",
+		Short: "Show the disk space taken by capsulate storage",
+		Long: `Summarize the disk space taken by the workspace's capsulate storage by category
+(workspaces, overlay diffs, dependencies, clone cache, artifacts, state) along with
+the traces and metrics files, then by agent across all projects, largest first.
+Agents marked orphaned have storage left but no recorded state.
+
+Use 'prune' to enforce the retention of the clone cache and images, and
+'metrics clear' to remove the metrics files.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+			top, _ := cmd.Flags().GetInt("top")
+
+			report, err := diskusage.Measure(workspaceDir())
+			if err != nil {
+				errorf("Error measuring disk usage: %v\n", err)
+				exit(exitCode(err))
+			}
+			if top > 0 && len(report.Agents) > top {
+				report.Agents = report.Agents[:top]
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					errorf("Error marshaling disk usage to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			displayDiskUsage(report)
+		},
+	}
+	duCmd.Flags().Int("top", 0, "Only show the N largest agents")
+	duCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	return duCmd
+}
+
+// displayDiskUsage prints the disk usage by category, then by agent
+func displayDiskUsage(report *diskusage.Report) {
+	fmt.Printf("%-16s %10s %8s\n", "CATEGORY", "SIZE", "FILES")
+	for _, category := range report.Categories {
+		fmt.Printf("%-16s %10s %8d\n", category.Name, formatBytes(category.Size), category.Files)
+	}
+	fmt.Printf("%-16s %10s\n", "total", formatBytes(report.Size))
+
+	if len(report.Agents) == 0 {
+		return
+	}
+	fmt.Println()
+	fmt.Printf("%-32s %10s %10s %10s %10s %10s\n", "AGENT", "SIZE", "WORKSPACE", "DIFFS", "DEPS", "ARTIFACTS")
+	for _, agent := range report.Agents {
+		name := diskusage.AgentName(agent)
+		if agent.Orphaned {
+			name += " (orphaned)"
+		}
+		fmt.Printf("%-32s %10s %10s %10s %10s %10s\n", name, formatBytes(agent.Size),
+			formatBytes(agent.Categories[diskusage.Workspaces]),
+			formatBytes(agent.Categories[diskusage.OverlayDiffs]),
+			formatBytes(agent.Categories[diskusage.Dependencies]),
+			formatBytes(agent.Categories[diskusage.Artifacts]))
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newEnvCmd builds the env command that records agent environments for reproduction
+func newEnvCmd() *cobra.Command {
+	envCmd := &cobra.Command{
+		Use:   "env",
+		Short: "Record agent environments to reproduce them later",
+	}
+
+	captureCmd := &cobra.Command{
+		Use:   "capture [agent-id]",
+		Short: "Write a manifest of an agent's image, commit, packages and environment",
+		Long: `Record what an agent runs with into a JSON manifest: the image and its digest,
+the repository and the commit checked out, the system packages (dpkg), the
+effective packages of each dependency provider, the agent's dependency settings
+and its environment variables. Values of variables whose names look like secrets
+(TOKEN, SECRET, PASSWORD, KEY...) are redacted.
+
+'create --from-manifest' creates an agent from the same image at the same commit
+and reports what differs. Uncommitted changes are not captured; commit them first.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID := args[0]
+			output, _ := cmd.Flags().GetString("output")
+
+			manifest, err := newManager().CaptureEnvironment(agentID)
+			if err != nil {
+				errorf("Error capturing environment: %v\n", err)
+				exit(exitCode(err))
+			}
+			if manifest.Dirty {
+				errorf("Warning: agent '%s' has uncommitted changes, which the manifest does not capture\n", agentID)
+			}
+
+			jsonData, err := json.MarshalIndent(manifest, "", "  ")
+			if err != nil {
+				errorf("Error marshaling manifest to JSON: %v\n", err)
+				exit(exitCode(err))
+			}
+			if output == "" || output == "-" {
+				fmt.Println(string(jsonData))
+				return
+			}
+			if err := os.WriteFile(output, append(jsonData, '\n'), 0644); err != nil {
+				errorf("Error writing manifest: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("📝 Environment of '%s' written to %s (%d system packages, %d dependencies)\n",
+				agentID, output, len(manifest.SystemPackages), len(manifest.Dependencies))
+		},
+	}
+	captureCmd.Flags().StringP("output", "o", "", "File to write the manifest to (default: standard output)")
+
+	envCmd.AddCommand(captureCmd)
+
+	return envCmd
+}
+
+// manifestFlags are the create flags a manifest replaces
+var manifestFlags = []string{
+	"repo", "branch", "commit", "depth", "dependency-level", "team-id", "team-snapshot",
+	"override-deps", "use-overlay", "template", "path", "sparse", "platform", "from-dir",
+}
+
+// reportManifestDifferences captures the environment of an agent created from a
+// manifest and warns about what differs from the manifest
+func reportManifestDifferences(manager *agent.Manager, agentID string, manifest *agent.EnvManifest) {
+	captured, err := manager.CaptureEnvironment(agentID)
+	if err != nil {
+		errorf("Warning: failed to compare with the manifest: %v\n", err)
+		return
+	}
+	differences := agent.CompareManifests(manifest, captured)
+	if len(differences) == 0 {
+		infof("✅ Environment matches the manifest\n")
+		return
+	}
+	errorf("Warning: the environment differs from the manifest in %d places:\n", len(differences))
+	for _, d := range differences {
+		errorf("  %-10s %s: %s -> %s\n", d.Kind, d.Name, d.Expected, d.Actual)
+	}
+}
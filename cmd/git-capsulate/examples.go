@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// workflowsAnnotation lists, on a command, the example workflows that use it
+const workflowsAnnotation = "capsulate.workflows"
+
+// exampleVar is a variable of an example workflow, set from the environment when
+// the script runs
+type exampleVar struct {
+	Name    string
+	Default string
+}
+
+// exampleStep is one command of an example workflow
+type exampleStep struct {
+	// Command is the command path without the program name, e.g. "team-deps freeze"
+	Command string
+	// Args are the arguments of the command, as written in a shell script
+	Args string
+	// Comment explains the step; the Short of the command when empty
+	Comment string
+}
+
+// exampleTopic is a multi-step workflow shown by 'help examples <name>' and written
+// as a runnable script by 'docs examples'
+type exampleTopic struct {
+	Name  string
+	Short string
+	Intro string
+	Vars  []exampleVar
+	Steps []exampleStep
+}
+
+// exampleTopics are the workflows that take more than one command to discover
+var exampleTopics = []exampleTopic{
+	{
+		Name:  "overlay-team-deps",
+		Short: "Share a team's dependencies across agents on overlay workspaces",
+		Intro: `Agents of a team share one dependency layer, versioned with snapshots, and
+overlay workspaces so that each agent only stores what it changes.`,
+		Vars: []exampleVar{
+			{Name: "REPO", Default: "https://github.com/your-org/web-app.git"},
+			{Name: "TEAM", Default: "frontend"},
+			{Name: "PACKAGE", Default: "react"},
+		},
+		Steps: []exampleStep{
+			{Command: "create-team", Args: `"$TEAM"`},
+			{Command: "add-team-dep", Args: `"$TEAM" "$PACKAGE"`},
+			{Command: "team-deps freeze", Args: `"$TEAM" --label baseline`, Comment: "Snapshot the team layer so agents can be pinned to it"},
+			{Command: "create", Args: `"$TEAM-1" --repo "$REPO" --use-overlay --dependency-level team --team-id "$TEAM" --team-snapshot baseline`},
+			{Command: "create", Args: `"$TEAM-2" --repo "$REPO" --use-overlay --dependency-level team --team-id "$TEAM" --team-snapshot baseline`},
+			{Command: "overlay-status", Args: `"$TEAM-1"`},
+			{Command: "exec", Args: `--team "$TEAM" "git status --short"`, Comment: "Run a command in every agent of the team"},
+			{Command: "du", Comment: "See how little the second agent added"},
+			{Command: "destroy", Args: `"$TEAM-1" --force`},
+			{Command: "destroy", Args: `"$TEAM-2" --force`},
+		},
+	},
+	{
+		Name:  "review-and-commit",
+		Short: "Work on a branch in an agent, review the changes and commit them",
+		Intro: `An agent works on its own branch; its changes are reviewed, with tests and
+linters run inside it, before they are committed with a drafted message.`,
+		Vars: []exampleVar{
+			{Name: "REPO", Default: "https://github.com/your-org/web-app.git"},
+			{Name: "AGENT", Default: "my-feature"},
+			{Name: "BRANCH", Default: "feature/example"},
+		},
+		Steps: []exampleStep{
+			{Command: "create", Args: `"$AGENT" --repo "$REPO"`},
+			{Command: "branch", Args: `"$AGENT" "$BRANCH" --checkout`},
+			{Command: "exec", Args: `"$AGENT" "echo 'Example change' >> NOTES.md"`, Comment: "Make a change, as an agent or a person would"},
+			{Command: "status", Args: `"$AGENT"`},
+			{Command: "review", Args: `"$AGENT" --output review.md`},
+			{Command: "suggest commit-message", Args: `"$AGENT"`},
+			{Command: "commit", Args: `"$AGENT" --all --suggested`},
+			{Command: "destroy", Args: `"$AGENT" --snapshot-first --force`, Comment: "Destroy the agent, keeping its work in a Git bundle"},
+		},
+	},
+	{
+		Name:  "ci",
+		Short: "Run a repository's commands in an ephemeral agent, as a CI job",
+		Intro: `A CI job runs its commands in an agent that is always destroyed afterwards, and
+collects their logs, artifacts and a JUnit report.`,
+		Vars: []exampleVar{
+			{Name: "REPO", Default: "https://github.com/your-org/web-app.git"},
+			{Name: "BRANCH", Default: "main"},
+		},
+		Steps: []exampleStep{
+			{Command: "validate", Comment: "Check capsulate.yaml, which holds the ci section"},
+			{Command: "ci exec", Args: `--repo "$REPO" --branch "$BRANCH" --output-dir capsulate-ci "git log -1 --oneline" "git status"`},
+		},
+	},
+	{
+		Name:  "backup-and-recover",
+		Short: "Back up agents, undelete them and bring them back after a reboot",
+		Intro: `Destroyed agents go to the trash and can be undeleted; agents whose containers
+stopped are recovered; and backups move the whole state to another host.`,
+		Vars: []exampleVar{
+			{Name: "REPO", Default: "https://github.com/your-org/web-app.git"},
+			{Name: "AGENT", Default: "my-feature"},
+		},
+		Steps: []exampleStep{
+			{Command: "create", Args: `"$AGENT" --repo "$REPO"`},
+			{Command: "backup create", Args: `capsulate-backup.tar.gz --workspaces`},
+			{Command: "destroy", Args: `"$AGENT" --force`},
+			{Command: "trash list"},
+			{Command: "undelete", Args: `"$AGENT"`},
+			{Command: "recover", Comment: "Restart agents whose containers stopped, e.g. after a reboot"},
+			{Command: "list"},
+			{Command: "destroy", Args: `"$AGENT" --force --no-trash`},
+		},
+	},
+	{
+		Name:  "scheduled-maintenance",
+		Short: "Keep long-lived agents fetched and their repositories compact",
+		Intro: `Schedules run commands in agents on cron expressions, while 'schedule run' is
+running, typically as a service.`,
+		Vars: []exampleVar{
+			{Name: "REPO", Default: "https://github.com/your-org/web-app.git"},
+			{Name: "AGENT", Default: "long-lived"},
+		},
+		Steps: []exampleStep{
+			{Command: "create", Args: `"$AGENT" --repo "$REPO"`},
+			{Command: "schedule add", Args: `"$AGENT" --cron @hourly --name "fetch-$AGENT" -- git fetch --all --prune`},
+			{Command: "schedule maintenance", Args: `"$AGENT" --cron @weekly`},
+			{Command: "schedule list", Args: `--agent "$AGENT"`},
+			{Command: "schedule run", Args: `--once`, Comment: "Run the schedules that are due once; without --once it keeps running"},
+			{Command: "schedule history", Args: `"fetch-$AGENT"`},
+			{Command: "destroy", Args: `"$AGENT" --force`},
+		},
+	},
+}
+
+// newExamplesCmd builds the examples help topic, with a topic per workflow, read
+// with 'git-capsulate help examples [topic]'. It must be added once every other
+// command is registered, since the steps are checked against the command tree and
+// the commands they use annotated with the workflow.
+func newExamplesCmd(rootCmd *cobra.Command) *cobra.Command {
+	var list strings.Builder
+	for _, topic := range exampleTopics {
+		fmt.Fprintf(&list, "  %-24s %s\n", topic.Name, topic.Short)
+	}
+	examplesCmd := &cobra.Command{
+		Use:   "examples",
+		Short: "Workflows that take several commands, step by step",
+		Long: `Workflows that take several commands, step by step. Show one with
+'git-capsulate help examples <topic>', or write them all as runnable scripts with
+'git-capsulate docs examples'.
+
+Topics:
+` + strings.TrimRight(list.String(), "\n"),
+	}
+	// Topics are read, not run: print their text alone, without a usage section
+	examplesCmd.SetHelpTemplate("{{with (or .Long .Short)}}{{. | trimTrailingWhitespaces}}{{end}}\n")
+
+	for _, topic := range exampleTopics {
+		annotateWorkflow(rootCmd, topic)
+		examplesCmd.AddCommand(&cobra.Command{
+			Use:   topic.Name,
+			Short: topic.Short,
+			Long:  topic.Short + "\n\n" + topic.Intro + "\n\n" + topic.script(rootCmd, false),
+		})
+	}
+	return examplesCmd
+}
+
+// annotateWorkflow records a workflow on the commands its steps use. A step naming
+// a command that does not exist is a mistake in exampleTopics; it is warned about and
+// left out.
+func annotateWorkflow(rootCmd *cobra.Command, topic exampleTopic) {
+	for _, step := range topic.Steps {
+		cmd := findCommand(rootCmd, step.Command)
+		if cmd == nil {
+			errorf("Warning: example '%s' uses unknown command '%s'\n", topic.Name, step.Command)
+			continue
+		}
+		if cmd.Annotations == nil {
+			cmd.Annotations = make(map[string]string)
+		}
+		workflows := commandWorkflows(cmd)
+		if !containsString(workflows, topic.Name) {
+			cmd.Annotations[workflowsAnnotation] = strings.Join(append(workflows, topic.Name), ",")
+		}
+	}
+}
+
+// commandWorkflows returns the example workflows that use a command
+func commandWorkflows(cmd *cobra.Command) []string {
+	if cmd.Annotations[workflowsAnnotation] == "" {
+		return nil
+	}
+	workflows := strings.Split(cmd.Annotations[workflowsAnnotation], ",")
+	sort.Strings(workflows)
+	return workflows
+}
+
+// findCommand returns the command with the given path, e.g. "team-deps freeze", or
+// nil when there is none
+func findCommand(rootCmd *cobra.Command, path string) *cobra.Command {
+	cmd := rootCmd
+	for _, name := range strings.Fields(path) {
+		var next *cobra.Command
+		for _, sub := range cmd.Commands() {
+			if sub.Name() == name {
+				next = sub
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		cmd = next
+	}
+	return cmd
+}
+
+// script renders the steps of a workflow as shell commands, each preceded by a
+// comment. As a runnable script it starts with the variables, overridable from the
+// environment, and stops at the first failing step.
+func (topic exampleTopic) script(rootCmd *cobra.Command, runnable bool) string {
+	var b strings.Builder
+	if runnable {
+		b.WriteString("#!/usr/bin/env bash\n")
+		fmt.Fprintf(&b, "# %s\n#\n", topic.Short)
+		for _, line := range strings.Split(topic.Intro, "\n") {
+			fmt.Fprintf(&b, "# %s\n", line)
+		}
+		fmt.Fprintf(&b, "#\n# Generated by 'git-capsulate docs examples'; shown by 'git-capsulate help examples %s'.\n", topic.Name)
+		b.WriteString("set -euo pipefail\n\n")
+	}
+	for _, v := range topic.Vars {
+		if runnable {
+			fmt.Fprintf(&b, "%s=\"${%s:-%s}\"\n", v.Name, v.Name, v.Default)
+		} else {
+			fmt.Fprintf(&b, "  %s=%s\n", v.Name, v.Default)
+		}
+	}
+
+	indent := "  "
+	if runnable {
+		indent = ""
+	}
+	for _, step := range topic.Steps {
+		comment := step.Comment
+		if comment == "" {
+			if cmd := findCommand(rootCmd, step.Command); cmd != nil {
+				comment = cmd.Short
+			}
+		}
+		line := rootCmd.Name() + " " + step.Command
+		if step.Args != "" {
+			line += " " + step.Args
+		}
+		fmt.Fprintf(&b, "\n%s# %s\n%s%s\n", indent, comment, indent, line)
+	}
+	return b.String()
+}
+
+// containsString reports whether a slice holds a string
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
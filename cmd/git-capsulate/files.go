@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newFilesCmd builds the files command that reads, writes and lists files in an agent
+func newFilesCmd() *cobra.Command {
+	filesCmd := &cobra.Command{
+		Use:   "files",
+		Short: "Read, write and list files in an agent's repository",
+		Long: fmt.Sprintf(`Read and write files in an agent's repository through the Docker copy API instead
+of shell commands, and list its files as a tree. Paths are relative to the repository
+root and files read or written are limited to %d MiB.`, agent.MaxFileSize>>20),
+	}
+
+	readCmd := &cobra.Command{
+		Use:   "read [agent-id] [path]",
+		Short: "Print a file from an agent",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			manager := newManager()
+			contents, err := manager.ReadFile(args[0], args[1])
+			if err != nil {
+				errorf("Error reading file: %v\n", err)
+				exit(exitCode(err))
+			}
+			os.Stdout.Write(contents)
+		},
+	}
+
+	writeCmd := &cobra.Command{
+		Use:   "write [agent-id] [path]",
+		Short: "Write a file in an agent from stdin or --from",
+		Args:  cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			from, _ := cmd.Flags().GetString("from")
+			modeFlag, _ := cmd.Flags().GetString("mode")
+
+			mode, err := strconv.ParseUint(modeFlag, 8, 32)
+			if err != nil {
+				errorf("Error: invalid mode '%s' (use octal, e.g. 0755)\n", modeFlag)
+				exit(exitUsage)
+			}
+
+			var contents []byte
+			if from == "" || from == "-" {
+				contents, err = io.ReadAll(io.LimitReader(os.Stdin, agent.MaxFileSize+1))
+			} else {
+				contents, err = os.ReadFile(from)
+			}
+			if err != nil {
+				errorf("Error reading input: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			manager := newManager()
+			if err := manager.WriteFile(args[0], args[1], contents, os.FileMode(mode)); err != nil {
+				errorf("Error writing file: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("Wrote %d bytes to %s in agent '%s'\n", len(contents), args[1], args[0])
+		},
+	}
+	writeCmd.Flags().String("from", "", "Host file to copy (default: stdin)")
+	writeCmd.Flags().String("mode", "0644", "Permission bits of the written file, in octal")
+
+	treeCmd := &cobra.Command{
+		Use:   "tree [agent-id] [path]",
+		Short: "List the files of an agent's repository as a tree",
+		Long: `List a directory of an agent's repository (the repository root by default) with the
+size, mode and modification time of every entry. The .git directory is always left
+out, files ignored by Git unless --ignored is given.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			depth, _ := cmd.Flags().GetInt("depth")
+			includeIgnored, _ := cmd.Flags().GetBool("ignored")
+			format, _ := cmd.Flags().GetString("format")
+
+			dir := ""
+			if len(args) > 1 {
+				dir = args[1]
+			}
+
+			manager := newManager()
+			tree, err := manager.ListTree(args[0], dir, depth, !includeIgnored)
+			if err != nil {
+				errorf("Error listing files: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(tree, "", "  ")
+				if err != nil {
+					errorf("Error marshaling tree to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			printTree(tree, 0)
+		},
+	}
+	treeCmd.Flags().Int("depth", 0, "Levels below the directory to list (0 for all)")
+	treeCmd.Flags().Bool("ignored", false, "Include files ignored by Git")
+	treeCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	filesCmd.AddCommand(readCmd)
+	filesCmd.AddCommand(writeCmd)
+	filesCmd.AddCommand(treeCmd)
+
+	return filesCmd
+}
+
+// printTree prints a tree entry and its children, indented by level
+func printTree(entry *agent.TreeEntry, level int) {
+	name := entry.Name
+	if entry.Type == "dir" {
+		name += "/"
+	}
+	fmt.Printf("%s %10d  %s  %s%s\n", entry.Mode, entry.Size,
+		entry.ModTime.Local().Format("2006-01-02 15:04"), strings.Repeat("  ", level), name)
+	for _, child := range entry.Children {
+		printTree(child, level+1)
+	}
+}
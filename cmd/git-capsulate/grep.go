@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newGrepCmd builds the grep command that searches an agent's repository
+func newGrepCmd() *cobra.Command {
+	grepCmd := &cobra.Command{
+		Use:   "grep [agent-id] [pattern]",
+		Short: "Search the files of an agent's repository",
+		Long: `Search the tracked files of an agent's repository for an extended regular
+expression with git grep, without copying the tree out of the container. Binary
+files are skipped. Agents created with --path search their subdirectory unless
+--path is given. File paths in the results are relative to the repository root.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID := args[0]
+			pattern := args[1]
+			paths, _ := cmd.Flags().GetStringArray("path")
+			ignoreCase, _ := cmd.Flags().GetBool("ignore-case")
+			fixed, _ := cmd.Flags().GetBool("fixed-strings")
+			format, _ := cmd.Flags().GetString("format")
+
+			manager := newManager()
+			matches, err := manager.Grep(agentID, pattern, agent.GrepOptions{
+				Paths:       paths,
+				IgnoreCase:  ignoreCase,
+				FixedString: fixed,
+			})
+			if err != nil {
+				errorf("Error searching repository: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				if matches == nil {
+					matches = []agent.GrepMatch{}
+				}
+				jsonData, err := json.MarshalIndent(matches, "", "  ")
+				if err != nil {
+					errorf("Error marshaling matches to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			if len(matches) == 0 {
+				infof("No matches\n")
+				return
+			}
+			for _, match := range matches {
+				fmt.Printf("%s:%d:%s\n", match.File, match.Line, match.Match)
+			}
+		},
+	}
+	grepCmd.Flags().StringArray("path", nil, "Only search this file or directory, relative to the repository root (repeatable)")
+	grepCmd.Flags().BoolP("ignore-case", "i", false, "Match case-insensitively")
+	grepCmd.Flags().BoolP("fixed-strings", "F", false, "Treat the pattern as a literal string")
+	grepCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	return grepCmd
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newHealthCmd builds the health command that shows the healthcheck status of agents
+func newHealthCmd() *cobra.Command {
+	healthCmd := &cobra.Command{
+		Use:   "health [agent-id]",
+		Short: "Show the health of agents",
+		Long: `Show the health of an agent, or of every agent, as reported by the healthcheck
+of its template, which Docker runs in the container:
+
+  templates:
+    web:
+      command: [npm, run, dev]
+      healthcheck:
+        command: curl -fs localhost:3000/health
+        interval: 30s
+        timeout: 5s
+        retries: 3
+        start_period: 1m
+
+An agent is unhealthy once the check failed retries times in a row, e.g. because
+its dev server froze; the output of the last check shows why. Agents of templates
+without a healthcheck have health 'none'. Exits with code 1 when an agent is
+unhealthy.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+			manager := newManager()
+
+			var results []agent.AgentHealth
+			if len(args) > 0 {
+				health, err := manager.Health(args[0])
+				if err != nil {
+					errorf("Error getting health: %v\n", err)
+					exit(exitCode(err))
+				}
+				results = append(results, *health)
+			} else {
+				ids, err := manager.AgentIDs()
+				if err != nil {
+					errorf("Error listing agents: %v\n", err)
+					exit(exitCode(err))
+				}
+				results = manager.HealthAll(ids)
+			}
+
+			unhealthy := false
+			for _, health := range results {
+				unhealthy = unhealthy || health.Health == agent.HealthUnhealthy
+			}
+
+			if format == "json" {
+				if results == nil {
+					results = []agent.AgentHealth{}
+				}
+				jsonData, err := json.MarshalIndent(results, "", "  ")
+				if err != nil {
+					errorf("Error marshaling health to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+			} else if len(results) == 0 {
+				fmt.Println("No agents")
+			} else {
+				fmt.Printf("%-20s %-10s %-10s %-7s %s\n", "AGENT", "CONTAINER", "HEALTH", "FAILS", "LAST CHECK")
+				for _, health := range results {
+					if health.Error != "" {
+						fmt.Printf("%-20s error: %s\n", health.AgentID, health.Error)
+						continue
+					}
+					lastCheck := "-"
+					if !health.LastCheck.IsZero() {
+						lastCheck = health.LastCheck.Local().Format(time.RFC3339)
+					}
+					fmt.Printf("%-20s %-10s %-10s %-7d %s\n", health.AgentID, health.Container, health.Health,
+						health.FailingStreak, lastCheck)
+					if health.Health == agent.HealthUnhealthy && health.LastOutput != "" {
+						fmt.Printf("  last output: %s\n", health.LastOutput)
+					}
+				}
+			}
+			if unhealthy {
+				exit(exitFailure)
+			}
+		},
+	}
+	healthCmd.Flags().String("format", "text", "Output format (text or json)")
+	return healthCmd
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// newHistoryCmd builds the history command that lists and shows run summaries
+func newHistoryCmd() *cobra.Command {
+	historyCmd := &cobra.Command{
+		Use:   "history",
+		Short: "List and show summaries of finished agent runs",
+		Long: `When an agent is destroyed, including the ephemeral agents of 'ci exec' jobs, a
+summary of its run is kept in .capsulate/state/history: how long it lived, the commands
+run with exec, ci exec and schedules, the commits made on top of the cloned commit, the
+status of its last check run and its total CPU, memory, disk and network usage.`,
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List run summaries, most recent first",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID, _ := cmd.Flags().GetString("agent")
+			limit, _ := cmd.Flags().GetInt("limit")
+			format, _ := cmd.Flags().GetString("format")
+
+			summaries, err := newManager().History(agentID)
+			if err != nil {
+				errorf("Error listing history: %v\n", err)
+				exit(exitCode(err))
+			}
+			if limit > 0 && len(summaries) > limit {
+				summaries = summaries[:limit]
+			}
+
+			if format == "json" {
+				if summaries == nil {
+					summaries = []*state.RunSummary{}
+				}
+				jsonData, err := json.MarshalIndent(summaries, "", "  ")
+				if err != nil {
+					errorf("Error marshaling history to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			if len(summaries) == 0 {
+				fmt.Println("No agent runs recorded")
+				return
+			}
+			fmt.Printf("%-40s %-20s %-17s %10s %8s %7s %-6s\n", "SUMMARY", "AGENT", "ENDED", "DURATION", "COMMANDS", "COMMITS", "CHECKS")
+			for _, summary := range summaries {
+				fmt.Printf("%-40s %-20s %-17s %10s %8d %7d %-6s\n", summary.ID, summary.AgentID,
+					summary.EndedAt.Local().Format("2006-01-02 15:04"), summary.Duration.Round(time.Second),
+					summary.CommandCount, summary.CommitCount, summary.ChecksStatus)
+			}
+		},
+	}
+	listCmd.Flags().String("agent", "", "Only list the runs of this agent ID")
+	listCmd.Flags().Int("limit", 0, "Maximum number of runs to list, 0 for all")
+	listCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	showCmd := &cobra.Command{
+		Use:   "show [summary-id|agent-id]",
+		Short: "Show a run summary, or the most recent one of an agent",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			summary, err := newManager().RunSummary(args[0])
+			if err != nil {
+				errorf("Error showing run summary: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(summary, "", "  ")
+				if err != nil {
+					errorf("Error marshaling run summary to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			printRunSummary(summary)
+		},
+	}
+	showCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	historyCmd.AddCommand(listCmd)
+	historyCmd.AddCommand(showCmd)
+	return historyCmd
+}
+
+// printRunSummary prints a run summary as text
+func printRunSummary(summary *state.RunSummary) {
+	fmt.Printf("Summary:    %s\n", summary.ID)
+	fmt.Printf("Agent:      %s\n", summary.AgentID)
+	if summary.RepoURL != "" {
+		fmt.Printf("Repository: %s\n", summary.RepoURL)
+	}
+	if summary.Branch != "" {
+		fmt.Printf("Branch:     %s\n", summary.Branch)
+	}
+	if summary.Template != "" {
+		fmt.Printf("Template:   %s\n", summary.Template)
+	}
+	keys := make([]string, 0, len(summary.Labels))
+	for key := range summary.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Printf("Label:      %s=%s\n", key, summary.Labels[key])
+	}
+	fmt.Printf("Started:    %s\n", summary.StartedAt.Local().Format(time.RFC822))
+	fmt.Printf("Ended:      %s (%s)\n", summary.EndedAt.Local().Format(time.RFC822), summary.Duration.Round(time.Second))
+	fmt.Printf("Checks:     %s (%d passed, %d failed)\n", summary.ChecksStatus, summary.ChecksPassed, summary.ChecksFailed)
+	if summary.TrashEntry != "" {
+		fmt.Printf("Trash:      %s\n", summary.TrashEntry)
+	}
+
+	r := summary.Resources
+	fmt.Printf("\nResources:  %.1fs CPU, %s peak memory, %s read, %s written, %s received, %s sent, %s writable layer\n",
+		r.CPUSeconds, formatBytes(r.PeakMemory), formatBytes(r.DiskRead), formatBytes(r.DiskWrite),
+		formatBytes(r.NetRx), formatBytes(r.NetTx), formatBytes(r.WritableLayer))
+
+	fmt.Printf("\nCommands (%d):\n", summary.CommandCount)
+	if summary.CommandCount > len(summary.Commands) {
+		fmt.Printf("  ... %d earlier commands not kept\n", summary.CommandCount-len(summary.Commands))
+	}
+	for _, command := range summary.Commands {
+		fmt.Printf("  %s  exit %-4d %8s  %s\n", command.StartedAt.Local().Format("15:04:05"),
+			command.ExitCode, command.Duration.Round(time.Millisecond), command.Command)
+	}
+
+	fmt.Printf("\nCommits (%d):\n", summary.CommitCount)
+	for _, commit := range summary.Commits {
+		fmt.Printf("  %.12s %s\n", commit.SHA, commit.Subject)
+	}
+	if summary.CommitCount > len(summary.Commits) {
+		fmt.Printf("  ... %d more\n", summary.CommitCount-len(summary.Commits))
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newImageCmd builds the image command that manages the base image agents run on
+func newImageCmd() *cobra.Command {
+	imageCmd := &cobra.Command{
+		Use:   "image",
+		Short: "Manage the base image agents run on",
+	}
+
+	refreshCmd := &cobra.Command{
+		Use:   "refresh",
+		Short: "Rebuild the base image, optionally recreating agents on it",
+		Long: `Rebuild the base image from a freshly pulled images.from image (ubuntu by default),
+picking up new versions of Git and the toolchain. New agents use it right away;
+existing agents keep their current image until they are recreated.
+
+The base image is built for the architecture of the Docker host. With --platform
+the image of agents created with --platform on another architecture is rebuilt,
+under qemu emulation.
+
+With --rollout, agents are then recreated one at a time on the new image. An agent's
+workspace, overlay diff and container-level dependencies are kept on the host, so
+its repository and uncommitted changes survive; processes running in it do not.
+Agents already on the new image are left alone, and the rollout stops at the first
+failure, so running the command again resumes it.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			rollout, _ := cmd.Flags().GetBool("rollout")
+			selector, _ := cmd.Flags().GetString("selector")
+			format, _ := cmd.Flags().GetString("format")
+			platform, _ := cmd.Flags().GetString("platform")
+
+			manager := newManager()
+
+			// Progress is printed as text; JSON is printed once at the end
+			text := format != "json"
+
+			if text {
+				infof("🔨 Rebuilding base image...\n")
+			}
+			previous, current, err := manager.RefreshBaseImage(platform)
+			if err != nil {
+				errorf("Error refreshing base image: %v\n", err)
+				exit(exitCode(err))
+			}
+			if text {
+				if previous == current {
+					infof("Base image unchanged (%s)\n", shortImageID(current))
+				} else {
+					infof("Base image rebuilt: %s -> %s\n", shortImageID(previous), shortImageID(current))
+				}
+			}
+
+			var results []agent.RolloutResult
+			if rollout {
+				agentIDs, err := manager.SelectAgentIDs(selector)
+				if err != nil {
+					errorf("Error selecting agents: %v\n", err)
+					exit(exitCode(err))
+				}
+
+				results, err = manager.RolloutImage(agentIDs, func(result agent.RolloutResult) {
+					if text {
+						printRolloutResult(result)
+					}
+				})
+				if err != nil {
+					errorf("Error rolling out base image: %v\n", err)
+					exit(exitCode(err))
+				}
+			}
+
+			if !text {
+				printImageRefreshJSON(previous, current, results)
+			}
+			for _, result := range results {
+				if result.Status == agent.RolloutFailed {
+					errorf("Error: rollout stopped at agent '%s'; re-run to resume\n", result.AgentID)
+					exit(exitFailure)
+				}
+			}
+			if text && rollout {
+				infof("Rolled out base image to %d agents\n", len(results))
+			}
+		},
+	}
+	refreshCmd.Flags().Bool("rollout", false, "Recreate agents one at a time on the new image")
+	refreshCmd.Flags().String("selector", "", "With --rollout, only recreate agents whose labels match")
+	refreshCmd.Flags().String("platform", "", "Rebuild the base image of this platform (linux/amd64 or linux/arm64) instead of the host's")
+	refreshCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	imageCmd.AddCommand(refreshCmd)
+
+	return imageCmd
+}
+
+// printRolloutResult prints the outcome of one agent of an image rollout
+func printRolloutResult(result agent.RolloutResult) {
+	switch result.Status {
+	case agent.RolloutRecreated:
+		fmt.Printf("  ✓ %s recreated\n", result.AgentID)
+	case agent.RolloutCurrent:
+		fmt.Printf("  = %s already on the new image\n", result.AgentID)
+	case agent.RolloutFailed:
+		fmt.Printf("  ✗ %s: %s\n", result.AgentID, result.Error)
+	case agent.RolloutSkipped:
+		fmt.Printf("  - %s skipped\n", result.AgentID)
+	}
+}
+
+// printImageRefreshJSON prints the outcome of an image refresh as JSON
+func printImageRefreshJSON(previous, current string, results []agent.RolloutResult) {
+	if results == nil {
+		results = []agent.RolloutResult{}
+	}
+	jsonData, err := json.MarshalIndent(map[string]interface{}{
+		"previous_image": previous,
+		"image":          current,
+		"agents":         results,
+	}, "", "  ")
+	if err != nil {
+		errorf("Error marshaling result to JSON: %v\n", err)
+		exit(exitCode(err))
+	}
+	fmt.Println(string(jsonData))
+}
+
+// shortImageID abbreviates an image ID such as sha256:0123... for display
+func shortImageID(id string) string {
+	if id == "" {
+		return "none"
+	}
+	if len(id) > 7+12 && id[:7] == "sha256:" {
+		return id[7 : 7+12]
+	}
+	return id
+}
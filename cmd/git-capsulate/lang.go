@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/your-org/capsulate-repo/pkg/i18n"
+)
+
+// usageHeadings are the headings of cobra's usage template, translated for help;
+// "Global Flags:" comes before "Flags:", which it contains
+var usageHeadings = []string{
+	"Usage:",
+	"Aliases:",
+	"Examples:",
+	"Available Commands:",
+	"Additional Commands:",
+	"Global Flags:",
+	"Flags:",
+	"Additional help topics:",
+	`for more information about a command.`,
+}
+
+// langFromArgs returns the value of --lang among the arguments. The language is
+// needed before cobra parses them, since help is printed while parsing.
+func langFromArgs(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if val, ok := strings.CutPrefix(arg, "--lang="); ok {
+			return val
+		}
+		if arg == "--lang" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// setLanguage selects the language of messages and help from --lang,
+// GIT_CAPSULATE_LANG or the locale, and translates the help of every command. An
+// unknown language given with --lang or GIT_CAPSULATE_LANG is reported; one from
+// the locale is not, since most have no catalog.
+func setLanguage(rootCmd *cobra.Command, args []string) {
+	flag := langFromArgs(args)
+	if err := i18n.SetLanguage(i18n.Detect(flag)); err != nil {
+		if flag != "" || os.Getenv(i18n.Env) != "" {
+			errorf("Warning: %v\n", err)
+		}
+		return
+	}
+	if i18n.Language() == i18n.DefaultLanguage {
+		return
+	}
+
+	template := rootCmd.UsageTemplate()
+	for _, heading := range usageHeadings {
+		template = strings.ReplaceAll(template, heading, i18n.T(heading))
+	}
+	rootCmd.SetUsageTemplate(template)
+
+	// Cobra adds the help and completion commands when executing; add them now so
+	// that their help is translated too
+	rootCmd.InitDefaultHelpCmd()
+	rootCmd.InitDefaultCompletionCmd()
+	localizeHelp(rootCmd)
+}
+
+// localizeHelp translates the descriptions of a command, its flags and its subcommands
+func localizeHelp(cmd *cobra.Command) {
+	cmd.InitDefaultHelpFlag()
+	cmd.Short = i18n.T(cmd.Short)
+	cmd.Long = i18n.T(cmd.Long)
+	translate := func(flag *pflag.Flag) {
+		if name, ok := strings.CutPrefix(flag.Usage, "help for "); ok && flag.Name == "help" {
+			flag.Usage = i18n.Sprintf("help for %s", name)
+			return
+		}
+		flag.Usage = i18n.T(flag.Usage)
+	}
+	cmd.LocalNonPersistentFlags().VisitAll(translate)
+	cmd.PersistentFlags().VisitAll(translate)
+	for _, sub := range cmd.Commands() {
+		localizeHelp(sub)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// projectAgent is an agent listed with --all-projects
+type projectAgent struct {
+	Project string `json:"project"`
+	*state.AgentState
+}
+
+// healthAgent is an agent listed with --health
+type healthAgent struct {
+	*state.AgentState
+	Health string `json:"health"`
+}
+
+// newListCmd builds the list command that shows the recorded agents
+func newListCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List agents",
+		Long: `List the agents recorded in the workspace with their branch, team and labels.
+Use --selector to filter by label, e.g. --selector purpose=refactor,owner!=ci.
+Only the agents of the current project (--project) are listed unless
+--all-projects is given. --health adds the health reported by the healthcheck of
+the agents' template, read from Docker.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			selector, _ := cmd.Flags().GetString("selector")
+			format, _ := cmd.Flags().GetString("format")
+			allProjects, _ := cmd.Flags().GetBool("all-projects")
+			withHealth, _ := cmd.Flags().GetBool("health")
+
+			var agents []projectAgent
+			health := make(map[string]string)
+			if allProjects {
+				agents = listAllProjects(selector)
+			} else {
+				manager := newManager()
+				states, err := manager.ListAgents(selector)
+				if err != nil {
+					errorf("Error listing agents: %v\n", err)
+					exit(exitCode(err))
+				}
+				ids := make([]string, 0, len(states))
+				for _, st := range states {
+					agents = append(agents, projectAgent{AgentState: st})
+					ids = append(ids, st.ID)
+				}
+				if withHealth {
+					for _, h := range manager.HealthAll(ids) {
+						health[h.AgentID] = h.Health
+						if h.Error != "" {
+							health[h.AgentID] = "error"
+						}
+					}
+				}
+			}
+
+			if format == "json" {
+				var data interface{} = agents
+				if withHealth {
+					withStatus := make([]healthAgent, 0, len(agents))
+					for _, a := range agents {
+						withStatus = append(withStatus, healthAgent{AgentState: a.AgentState, Health: health[a.ID]})
+					}
+					data = withStatus
+				} else if !allProjects {
+					states := make([]*state.AgentState, 0, len(agents))
+					for _, a := range agents {
+						states = append(states, a.AgentState)
+					}
+					data = states
+				}
+				jsonData, err := json.MarshalIndent(data, "", "  ")
+				if err != nil {
+					errorf("Error marshaling agents to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			infof("🤖 Agents:\n")
+			infof("==========================================\n")
+			if len(agents) == 0 {
+				fmt.Println("No agents")
+				return
+			}
+			if allProjects {
+				fmt.Printf("%-16s ", "PROJECT")
+			}
+			fmt.Printf("%-20s %-25s %-12s %-20s ", "AGENT", "BRANCH", "TEAM", "CREATED")
+			if withHealth {
+				fmt.Printf("%-10s ", "HEALTH")
+			}
+			fmt.Println("LABELS")
+			for _, a := range agents {
+				st := a.AgentState
+				branch := st.Branch
+				if sha := st.Commit; sha != "" {
+					if len(sha) > 7 {
+						sha = sha[:7]
+					}
+					branch = "(detached " + sha + ")"
+				}
+				if allProjects {
+					project := a.Project
+					if project == "" {
+						project = "(default)"
+					}
+					fmt.Printf("%-16s ", project)
+				}
+				fmt.Printf("%-20s %-25s %-12s %-20s ",
+					st.ID, branch, st.TeamID,
+					st.CreatedAt.Format("2006-01-02 15:04:05"))
+				if withHealth {
+					fmt.Printf("%-10s ", health[st.ID])
+				}
+				fmt.Println(agent.FormatLabels(st.Labels))
+			}
+		},
+	}
+	listCmd.Flags().String("selector", "", "Only list agents whose labels match")
+	listCmd.Flags().String("format", "text", "Output format (text or json)")
+	listCmd.Flags().Bool("all-projects", false, "List the agents of every project in the workspace")
+	listCmd.Flags().Bool("health", false, "Show the health of each agent's container")
+	listCmd.MarkFlagsMutuallyExclusive("all-projects", "health")
+
+	return listCmd
+}
+
+// listAllProjects returns the agents of every project in the workspace matching a
+// selector, read from their state stores without contacting Docker
+func listAllProjects(selector string) []projectAgent {
+	sel, err := agent.ParseSelector(selector)
+	if err != nil {
+		errorf("Error listing agents: %v\n", err)
+		exit(exitCode(err))
+	}
+	projects, err := config.Projects(workspaceDir())
+	if err != nil {
+		errorf("Error listing agents: %v\n", err)
+		exit(exitCode(err))
+	}
+
+	var agents []projectAgent
+	for _, project := range projects {
+		store, err := state.NewStore(config.DataDir(workspaceDir(), project))
+		if err != nil {
+			errorf("Error opening agent state: %v\n", err)
+			exit(exitCode(err))
+		}
+		states, err := store.List()
+		if err != nil {
+			errorf("Error listing agents: %v\n", err)
+			exit(exitCode(err))
+		}
+		for _, st := range states {
+			if sel.Matches(st.Labels) {
+				agents = append(agents, projectAgent{Project: project, AgentState: st})
+			}
+		}
+	}
+	return agents
+}
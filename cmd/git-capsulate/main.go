@@ -2,17 +2,21 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/config"
 	"github.com/your-org/capsulate-repo/pkg/metrics"
 	"github.com/your-org/capsulate-repo/pkg/monitor"
+	"github.com/your-org/capsulate-repo/pkg/telemetry"
 	"github.com/your-org/capsulate-repo/pkg/tracing"
 )
 
@@ -28,19 +32,62 @@ func main() {
 	createCmd := &cobra.Command{
 		Use:   "create [agent-id]",
 		Short: "Create a new Git isolation container",
-		Long:  `Create a new container with Git isolation for development.`,
-		Args:  cobra.ExactArgs(1),
+		Long: `Create a new container with Git isolation for development.
+
+Agent IDs may contain letters, digits, '.', '_' and '-', start with a letter or digit
+and are at most 63 characters long. With --auto-id a readable unique ID such as
+brave-otter-3f2a is generated and printed (alone with --quiet).
+
+--from-manifest reproduces an environment recorded by 'env capture': the same image,
+the same commit as a detached HEAD and the same dependency settings, then reports
+what still differs.
+
+Without --repo or --from-dir the agent is a bare tool container with its
+dependencies but no repository; status and review report that it has none.
+
+--from-dir mounts an existing checkout on the host as the agent's repository
+instead of cloning one. The directory is used in place: the agent's changes are
+made to it, and it is left alone when the agent is destroyed.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if autoID, _ := cmd.Flags().GetBool("auto-id"); autoID {
+				return cobra.NoArgs(cmd, args)
+			}
+			if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+				return err
+			}
+			return agent.ValidateAgentID(args[0])
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			agentID := args[0]
-			
 			// Get command-line flags
+			autoID, _ := cmd.Flags().GetBool("auto-id")
 			repoURL, _ := cmd.Flags().GetString("repo")
 			branch, _ := cmd.Flags().GetString("branch")
+			commit, _ := cmd.Flags().GetString("commit")
 			depth, _ := cmd.Flags().GetInt("depth")
 			depLevel, _ := cmd.Flags().GetString("dependency-level")
 			teamID, _ := cmd.Flags().GetString("team-id")
+			teamSnapshot, _ := cmd.Flags().GetString("team-snapshot")
 			overrideDepsStr, _ := cmd.Flags().GetString("override-deps")
 			useOverlay, _ := cmd.Flags().GetBool("use-overlay")
+			template, _ := cmd.Flags().GetString("template")
+			labelEntries, _ := cmd.Flags().GetStringArray("label")
+			sshAcceptNew, _ := cmd.Flags().GetBool("ssh-accept-new")
+			keepOnFailure, _ := cmd.Flags().GetBool("keep-on-failure")
+			repoPath, _ := cmd.Flags().GetString("path")
+			sparse, _ := cmd.Flags().GetBool("sparse")
+			enableSSH, _ := cmd.Flags().GetBool("enable-ssh")
+			sshKeyFile, _ := cmd.Flags().GetString("ssh-key")
+			sshPort, _ := cmd.Flags().GetInt("ssh-port")
+			fromManifest, _ := cmd.Flags().GetString("from-manifest")
+			platform, _ := cmd.Flags().GetString("platform")
+			fromDir, _ := cmd.Flags().GetString("from-dir")
+			skipCapacityCheck, _ := cmd.Flags().GetBool("skip-capacity-check")
+			
+			labels, err := agent.ParseLabels(labelEntries)
+			if err != nil {
+				errorf("Error parsing labels: %v\n", err)
+				exit(exitCode(err))
+			}
 			
 			// Parse override dependencies
 			var overrideDeps []string
@@ -48,26 +95,73 @@ func main() {
 				overrideDeps = strings.Split(overrideDepsStr, ",")
 			}
 			
+			// Read the public key to authorize in the agent's SSH server
+			var sshKey string
+			if sshKeyFile != "" {
+				data, err := os.ReadFile(sshKeyFile)
+				if err != nil {
+					errorf("Error reading SSH key: %v\n", err)
+					exit(exitUsage)
+				}
+				sshKey = string(data)
+			}
+			
+			// A manifest describes the whole environment, so it replaces the flags that do
+			var manifest *agent.EnvManifest
+			if fromManifest != "" {
+				for _, name := range manifestFlags {
+					if cmd.Flags().Changed(name) {
+						errorf("Error: --%s cannot be combined with --from-manifest\n", name)
+						exit(exitUsage)
+					}
+				}
+				manifest, err = agent.ReadManifest(fromManifest)
+				if err != nil {
+					errorf("Error reading manifest: %v\n", err)
+					exit(exitUsage)
+				}
+				if manifest.Dirty {
+					errorf("Warning: agent '%s' had uncommitted changes when captured, which are not reproduced\n", manifest.AgentID)
+				}
+			}
+			
 			// Get SSH directory for auth
 			homeDir, err := os.UserHomeDir()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting user home directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting user home directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			sshDir := filepath.Join(homeDir, ".ssh")
 			
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create agent manager
 			manager, err := agent.NewManager(sshDir, workspaceDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating agent manager: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating agent manager: %v\n", err)
+				exit(exitCode(err))
+			}
+			manager.SetCapacityWarningHandler(func(err error) {
+				errorf("Warning: %v\n", err)
+			})
+
+			// Generate an ID if requested
+			var agentID string
+			if autoID {
+				agentID, err = manager.GenerateAgentID()
+				if err != nil {
+					errorf("Error generating agent ID: %v\n", err)
+					exit(exitCode(err))
+				}
+				infof("Generated agent ID: ")
+				fmt.Println(agentID)
+			} else {
+				agentID = args[0]
 			}
 
 			// Create agent configuration
@@ -75,115 +169,337 @@ func main() {
 				ID:              agentID,
 				DependencyLevel: depLevel,
 				TeamID:          teamID,
+				TeamSnapshot:    teamSnapshot,
 				OverrideDeps:    overrideDeps,
 				UseOverlay:      useOverlay,
 				RepoURL:         repoURL,
 				Branch:          branch,
+				Commit:          commit,
 				Depth:           depth,
+				Template:        template,
+				Labels:          labels,
+				SSHAcceptNew:    sshAcceptNew,
+				Path:            repoPath,
+				Sparse:          sparse,
+				KeepOnFailure:   keepOnFailure,
+				SSHServer:       enableSSH,
+				SSHServerPort:   sshPort,
+				SSHAuthorizedKey: sshKey,
+				Platform:        platform,
+				FromDir:         fromDir,
+				SkipCapacityCheck: skipCapacityCheck,
+			}
+			if manifest != nil {
+				reproduced := manifest.AgentConfig(agentID)
+				reproduced.Labels = config.Labels
+				reproduced.SSHAcceptNew = config.SSHAcceptNew
+				reproduced.KeepOnFailure = config.KeepOnFailure
+				reproduced.SSHServer = config.SSHServer
+				reproduced.SSHServerPort = config.SSHServerPort
+				reproduced.SSHAuthorizedKey = config.SSHAuthorizedKey
+				reproduced.SkipCapacityCheck = config.SkipCapacityCheck
+				config = reproduced
 			}
 
 			// Create the agent
 			if err := manager.Create(config); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating agent: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating agent: %v\n", err)
+				exit(exitCode(err))
 			}
 
-			fmt.Printf("Agent '%s' created successfully\n", agentID)
+			infof("Agent '%s' created successfully\n", agentID)
+			if manifest != nil {
+				reportManifestDifferences(manager, agentID, manifest)
+			}
+			if enableSSH {
+				if host, port, err := manager.SSHEndpoint(agentID); err == nil {
+					infof("SSH server: ssh -p %d root@%s\n", port, host)
+				}
+			}
 		},
 	}
 
 	// Add create command flags
 	createCmd.Flags().StringP("repo", "r", "", "Git repository URL to clone")
 	createCmd.Flags().StringP("branch", "b", "", "Branch to checkout")
+	createCmd.Flags().String("commit", "", "Commit SHA to check out as a detached HEAD instead of a branch")
+	createCmd.MarkFlagsMutuallyExclusive("branch", "commit")
 	createCmd.Flags().IntP("depth", "d", 0, "Depth for shallow clones (0 for full clone)")
 	createCmd.Flags().String("dependency-level", "container", "Dependency isolation level (core, team, container)")
 	createCmd.Flags().String("team-id", "", "Team identifier for team-level dependencies")
-	createCmd.Flags().String("override-deps", "", "Comma-separated list of dependencies to override")
+	createCmd.Flags().String("team-snapshot", "", "Pin team dependencies to a snapshot (ID or label) instead of the live layer")
+	createCmd.Flags().String("override-deps", "", "Comma-separated container-level overrides: name, name@version or name=path (optionally provider:name@version)")
 	createCmd.Flags().Bool("use-overlay", false, "Use overlay filesystem for efficient storage")
+	createCmd.Flags().String("template", "", "Name of the environment template the agent is created from")
+	createCmd.Flags().StringArrayP("label", "l", nil, "Label as key=value (repeatable), used to select agents with --selector")
+	createCmd.Flags().String("path", "", "Repository subdirectory (e.g. services/foo) used as the working directory for exec, status and checks")
+	createCmd.Flags().Bool("sparse", false, "With --path, check out only that subdirectory (sparse checkout)")
+	createCmd.Flags().Bool("ssh-accept-new", false, "Trust SSH host keys of Git servers seen for the first time (changed keys are still rejected)")
+	createCmd.Flags().Bool("enable-ssh", false, "Run an SSH server in the agent for remote IDEs, rsync and scp")
+	createCmd.Flags().String("ssh-key", "", "Public key file to authorize with --enable-ssh (default: id_ed25519.pub, id_ecdsa.pub or id_rsa.pub in ~/.ssh)")
+	createCmd.Flags().Int("ssh-port", 0, "Host port of the SSH server with --enable-ssh (default: assigned by Docker)")
+	createCmd.Flags().Bool("keep-on-failure", false, "Keep the container, state and directories of a failed create for debugging instead of removing them")
+	createCmd.Flags().String("from-manifest", "", "Reproduce the environment recorded by 'env capture' in this manifest file")
+	createCmd.Flags().String("platform", "", "Run the agent on another architecture than the Docker host's (linux/amd64 or linux/arm64), emulated with qemu")
+	createCmd.Flags().String("from-dir", "", "Mount this existing checkout as the agent's repository instead of cloning one")
+	createCmd.Flags().Bool("skip-capacity-check", false, "Create the agent even if the host is short of disk, memory or CPU (see capacity in capsulate.yaml)")
+	createCmd.Flags().Bool("auto-id", false, "Generate a readable unique agent ID (adjective-noun-hash) and print it")
 
 	// Add destroy command
 	destroyCmd := &cobra.Command{
 		Use:   "destroy [agent-id]",
 		Short: "Destroy a Git isolation container",
-		Long:  `Stop and remove a Git isolation container.`,
-		Args:  cobra.ExactArgs(1),
+		Long: `Stop and remove a Git isolation container, or with --selector every agent whose labels match.
+
+Credential files are scrubbed from the agent's repository and dependencies, after
+the pre-stop hooks, since they outlive the container (see 'scrub'); --no-scrub skips
+this. Snapshot and trash bundles leave out the credential files of the work.
+
+An agent with uncommitted changes, stashes or commits not on any remote is only
+destroyed after confirming at the terminal, or with --force. --snapshot-first saves
+its refs and uncommitted work to a Git bundle in .capsulate/snapshots first; restore
+it with 'git fetch <bundle> refs/capsulate/snapshot:refs/heads/recovered'.
+
+The agent's workspace, overlay and dependency directories are moved to
+.capsulate/trash with a bundle of its repository, and can be brought back with
+'undelete' until trash.retention (default 168h) ends. --no-trash, or trash.enabled:
+false in capsulate.yaml, leaves them in place instead.
+
+Before the container is stopped, the stop.pre_stop hooks of capsulate.yaml run in the
+agent's repository, e.g. to flush caches or commit a checkpoint; a failing hook is
+reported and does not prevent the destroy, and --no-hooks skips them. The container's
+processes then get stop.timeout (default 10s), or --timeout, to exit after SIGTERM
+before they are killed. --kill sends SIGKILL right away and skips the hooks.
+
+--dry-run lists the containers and state that would be removed, and the workspace
+directories and volumes trashed or left in place, with their sizes, without removing
+anything.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if selector, _ := cmd.Flags().GetString("selector"); selector != "" {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			agentID := args[0]
-			
 			// Get SSH directory for auth
 			homeDir, err := os.UserHomeDir()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting user home directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting user home directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			sshDir := filepath.Join(homeDir, ".ssh")
 			
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create agent manager
 			manager, err := agent.NewManager(sshDir, workspaceDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating agent manager: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating agent manager: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			noScrub, _ := cmd.Flags().GetBool("no-scrub")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			force, _ := cmd.Flags().GetBool("force")
+			snapshotFirst, _ := cmd.Flags().GetBool("snapshot-first")
+			noTrash, _ := cmd.Flags().GetBool("no-trash")
+			kill, _ := cmd.Flags().GetBool("kill")
+			noHooks, _ := cmd.Flags().GetBool("no-hooks")
+			opts := agent.DestroyOptions{
+				SkipTrash: noTrash,
+				Kill:      kill,
+				SkipHooks: noHooks,
+				OnHookError: func(name string, err error) {
+					errorf("Warning: pre-stop hook '%s' failed: %v\n", name, err)
+				},
+				SkipScrub: noScrub,
+				OnScrub:   reportScrubBeforeDestroy,
+			}
+			if cmd.Flags().Changed("timeout") {
+				timeout, _ := cmd.Flags().GetDuration("timeout")
+				if timeout < 0 {
+					errorf("Error: --timeout must not be negative\n")
+					exit(exitUsage)
+				}
+				opts.StopTimeout = &timeout
+			}
+
+			// Destroy every agent matching the selector
+			if selector, _ := cmd.Flags().GetString("selector"); selector != "" {
+				agentIDs, err := manager.SelectAgentIDs(selector)
+				if err != nil {
+					errorf("Error selecting agents: %v\n", err)
+					exit(exitCode(err))
+				}
+				failed := false
+				for _, agentID := range agentIDs {
+					if dryRun {
+						if !previewDestroy(manager, agentID, opts) {
+							failed = true
+						}
+						continue
+					}
+					if !guardDestroy(manager, agentID, force, snapshotFirst) {
+						failed = true
+						continue
+					}
+					if err := manager.DestroyWithOptions(agentID, opts); err != nil {
+						errorf("Error destroying agent '%s': %v\n", agentID, err)
+						failed = true
+						continue
+					}
+					infof("Agent '%s' destroyed successfully\n", agentID)
+				}
+				if len(agentIDs) == 0 {
+					fmt.Println("No agents match the selector")
+				}
+				if failed {
+					exit(exitFailure)
+				}
+				return
+			}
+			agentID := args[0]
+
+			if dryRun {
+				if !previewDestroy(manager, agentID, opts) {
+					exit(exitFailure)
+				}
+				return
 			}
 
 			// Destroy the agent
-			if err := manager.Destroy(agentID); err != nil {
-				fmt.Fprintf(os.Stderr, "Error destroying agent: %v\n", err)
-				os.Exit(1)
+			if !guardDestroy(manager, agentID, force, snapshotFirst) {
+				exit(exitFailure)
+			}
+			if err := manager.DestroyWithOptions(agentID, opts); err != nil {
+				errorf("Error destroying agent: %v\n", err)
+				exit(exitCode(err))
 			}
 
-			fmt.Printf("Agent '%s' destroyed successfully\n", agentID)
+			infof("Agent '%s' destroyed successfully\n", agentID)
 		},
 	}
 
+	destroyCmd.Flags().String("selector", "", "Destroy every agent whose labels match, e.g. purpose=refactor,team!=core")
+	destroyCmd.Flags().Bool("no-scrub", false, "Keep credential files in the agent's repository and dependencies")
+	destroyCmd.Flags().Bool("force", false, "Destroy agents with uncommitted changes, stashes or unpushed commits without asking")
+	destroyCmd.Flags().Bool("snapshot-first", false, "Save the agent's refs and uncommitted changes to a Git bundle in .capsulate/snapshots before destroying it")
+	destroyCmd.MarkFlagsMutuallyExclusive("force", "snapshot-first")
+	destroyCmd.Flags().Bool("no-trash", false, "Leave the agent's files in place instead of moving them to the trash")
+	destroyCmd.Flags().Bool("dry-run", false, "Only list the containers, state, directories and volumes affected, with sizes")
+	destroyCmd.Flags().Duration("timeout", 0, "Time the container gets to exit after SIGTERM before it is killed (default: stop.timeout, or 10s)")
+	destroyCmd.Flags().Bool("kill", false, "Kill the container with SIGKILL right away, without running the pre-stop hooks")
+	destroyCmd.Flags().Bool("no-hooks", false, "Do not run the stop.pre_stop hooks of capsulate.yaml")
+	destroyCmd.MarkFlagsMutuallyExclusive("kill", "timeout")
+
 	// Add exec command
 	execCmd := &cobra.Command{
 		Use:   "exec [agent-id] [command]",
 		Short: "Execute a command in a Git isolation container",
-		Long:  `Run a command inside a Git isolation container.`,
-		Args:  cobra.ExactArgs(2),
+		Long:  `Run a command inside a Git isolation container.
+
+The CLI exits with the exit code of the command, so scripts can check it directly.
+Use --allow-nonzero to always exit 0 and handle failures from the reported code.
+
+With --all, --team or --selector the agent ID is omitted and the command runs on every
+matching agent in parallel; the CLI exits 1 if it failed on any of them.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if isFanOut(cmd) {
+				return cobra.ExactArgs(1)(cmd, args)
+			}
+			return cobra.ExactArgs(2)(cmd, args)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			agentID := args[0]
-			command := args[1]
-			
 			// Get SSH directory for auth
 			homeDir, err := os.UserHomeDir()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting user home directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting user home directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			sshDir := filepath.Join(homeDir, ".ssh")
 			
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create agent manager
 			manager, err := agent.NewManager(sshDir, workspaceDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating agent manager: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating agent manager: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			maxOutput, _ := cmd.Flags().GetInt64("max-output")
+			outputFile, _ := cmd.Flags().GetString("output-file")
+			allowNonzero, _ := cmd.Flags().GetBool("allow-nonzero")
+
+			// Fan the command out to a group of agents
+			if isFanOut(cmd) {
+				if outputFile != "" {
+					errorf("Error: --output-file cannot be used with --all, --team or --selector\n")
+					exit(exitUsage)
+				}
+				agentIDs, err := fanOutAgentIDs(cmd, manager)
+				if err != nil {
+					errorf("Error selecting agents: %v\n", err)
+					exit(exitCode(err))
+				}
+				format, _ := cmd.Flags().GetString("format")
+				results := manager.ExecAll(agentIDs, args[0], agent.ExecOptions{MaxCapture: maxOutput, Record: true})
+				if !printExecResults(results, format) && !allowNonzero {
+					exit(exitFailure)
+				}
+				return
 			}
+			agentID := args[0]
+			command := args[1]
 
 			// Execute the command
-			output, err := manager.Exec(agentID, command)
+			result, err := manager.ExecWithOptions(agentID, command, agent.ExecOptions{
+				MaxCapture: maxOutput,
+				OutputFile: outputFile,
+				WorkingDir: manager.WorkDir(agentID),
+				Record:     true,
+			})
+			if result != nil {
+				fmt.Print(result.Output)
+				if result.Truncated() {
+					errorf("Output truncated: %d of %d bytes shown\n", result.CapturedBytes, result.TotalBytes)
+				}
+				if result.OutputFile != "" {
+					errorf("Complete output written to %s\n", result.OutputFile)
+				}
+			}
+			// Pass the exit code of the command through unless the caller handles it
+			var exitErr *agent.ExitError
+			if errors.As(err, &exitErr) {
+				if allowNonzero {
+					errorf("Command exited with code %d\n", exitErr.Code)
+					return
+				}
+				exit(exitErr.Code)
+			}
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error executing command: %v\n", err)
-				os.Exit(1)
+				errorf("Error executing command: %v\n", err)
+				exit(exitCode(err))
 			}
-
-			fmt.Print(output)
 		},
 	}
+	execCmd.Flags().Bool("all", false, "Run the command on every agent")
+	execCmd.Flags().String("team", "", "Run the command on every agent of a team")
+	execCmd.Flags().String("selector", "", "Run the command on every agent whose labels match, e.g. purpose=refactor")
+	execCmd.Flags().String("format", "text", "Output format for --all/--team/--selector results (text or json)")
+	execCmd.Flags().Bool("allow-nonzero", false, "Exit 0 even when the command fails, reporting its exit code on stderr")
+	execCmd.Flags().Int64("max-output", agent.DefaultMaxCapture, "Maximum bytes of output to keep in memory (-1 for no limit)")
+	execCmd.Flags().String("output-file", "", "Write the complete, untruncated output to this file")
 
 	// Add Git branch command
 	branchCmd := &cobra.Command{
@@ -196,45 +512,58 @@ func main() {
 			branchName := args[1]
 			
 			checkout, _ := cmd.Flags().GetBool("checkout")
+			useTemplate, _ := cmd.Flags().GetBool("template")
+			track, _ := cmd.Flags().GetBool("track")
 			
 			// Get SSH directory for auth
 			homeDir, err := os.UserHomeDir()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting user home directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting user home directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			sshDir := filepath.Join(homeDir, ".ssh")
 			
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create agent manager
 			manager, err := agent.NewManager(sshDir, workspaceDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating agent manager: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating agent manager: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			// Expand the configured branch template if requested
+			if useTemplate {
+				branchName, err = manager.ExpandBranchTemplate(agentID, branchName)
+				if err != nil {
+					errorf("Error expanding branch template: %v\n", err)
+					exit(exitCode(err))
+				}
 			}
 
 			// Create the branch
-			if err := manager.CreateBranch(agentID, branchName, checkout); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating branch: %v\n", err)
-				os.Exit(1)
+			if err := manager.CreateBranch(agentID, branchName, checkout, track); err != nil {
+				errorf("Error creating branch: %v\n", err)
+				exit(exitCode(err))
 			}
 
-			fmt.Printf("Branch '%s' created", branchName)
+			infof("Branch '%s' created", branchName)
 			if checkout {
-				fmt.Print(" and checked out")
+				infof(" and checked out")
 			}
-			fmt.Println()
+			infof("\n")
 		},
 	}
 
 	// Add branch command flags
 	branchCmd.Flags().BoolP("checkout", "c", false, "Checkout the new branch after creation")
+	branchCmd.Flags().BoolP("template", "t", false, "Treat the branch name as {name} in the configured branch template")
+	branchCmd.Flags().Bool("track", false, "Make the branch track origin/<branch-name>")
 
 	// Add Git checkout command
 	checkoutCmd := &cobra.Command{
@@ -245,93 +574,167 @@ func main() {
 		Run: func(cmd *cobra.Command, args []string) {
 			agentID := args[0]
 			branchName := args[1]
+			track, _ := cmd.Flags().GetBool("track")
 			
 			// Get SSH directory for auth
 			homeDir, err := os.UserHomeDir()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting user home directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting user home directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			sshDir := filepath.Join(homeDir, ".ssh")
 			
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create agent manager
 			manager, err := agent.NewManager(sshDir, workspaceDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating agent manager: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating agent manager: %v\n", err)
+				exit(exitCode(err))
 			}
 
 			// Checkout the branch
-			if err := manager.CheckoutBranch(agentID, branchName); err != nil {
-				fmt.Fprintf(os.Stderr, "Error checking out branch: %v\n", err)
-				os.Exit(1)
+			if err := manager.CheckoutBranch(agentID, branchName, track); err != nil {
+				errorf("Error checking out branch: %v\n", err)
+				exit(exitCode(err))
 			}
 
-			fmt.Printf("Switched to branch '%s'\n", branchName)
+			infof("Switched to branch '%s'\n", branchName)
 		},
 	}
 
+	// Add checkout command flags
+	checkoutCmd.Flags().Bool("track", false, "Make the branch track origin/<branch-name> if it has no upstream")
+
 	// Add Git status command
 	statusCmd := &cobra.Command{
 		Use:   "status [agent-id]",
 		Short: "Show Git status in a container",
-		Long:  `Display Git status information for a container.`,
-		Args:  cobra.ExactArgs(1),
+		Long:  `Display Git status information for a container, or with --all a summary table for every agent.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if all, _ := cmd.Flags().GetBool("all"); all {
+				return cobra.NoArgs(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			agentID := args[0]
-			
 			// Get SSH directory for auth
 			homeDir, err := os.UserHomeDir()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting user home directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting user home directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			sshDir := filepath.Join(homeDir, ".ssh")
 			
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create agent manager
 			manager, err := agent.NewManager(sshDir, workspaceDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating agent manager: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating agent manager: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			format, _ := cmd.Flags().GetString("format")
+
+			// Gather the status of every agent concurrently
+			if all, _ := cmd.Flags().GetBool("all"); all {
+				agentIDs, err := manager.AgentIDs()
+				if err != nil {
+					errorf("Error listing agents: %v\n", err)
+					exit(exitCode(err))
+				}
+				printFleetStatus(manager.GetGitStatusAll(agentIDs), format)
+				return
 			}
+			agentID := args[0]
 
 			// Get Git status
 			status, err := manager.GetGitStatus(agentID)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting Git status: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting Git status: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if porcelain, _ := cmd.Flags().GetBool("porcelain"); porcelain {
+				format = "porcelain"
+			}
+
+			switch format {
+			case "json":
+				jsonData, err := json.MarshalIndent(status, "", "  ")
+				if err != nil {
+					errorf("Error marshaling status to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			case "porcelain":
+				printPorcelainStatus(status)
+				return
+			}
+
+			if status.NoRepository {
+				fmt.Printf("No repository: agent '%s' was created without one\n", agentID)
+				return
 			}
 
 			// Print status
-			fmt.Printf("Branch: %s\n", status.Branch)
+			branch := status.Branch
+			if status.Detached {
+				branch = "(detached HEAD)"
+			}
+			fmt.Printf("Branch: %s\n", branch)
 			fmt.Printf("Commit: %s\n", status.CurrentCommit)
-			fmt.Printf("Ahead: %d, Behind: %d\n\n", status.AheadCount, status.BehindCount)
-			
-			fmt.Println("Modified files:")
+			if status.PinnedCommit != "" {
+				fmt.Printf("Pinned: %s\n", status.PinnedCommit)
+			}
+			if status.Upstream != "" {
+				fmt.Printf("Upstream: %s\n", status.Upstream)
+			}
+			fmt.Printf("Tracking: %s\n", status.Tracking)
+			if status.Tracking != agent.TrackingNone && status.Tracking != agent.TrackingGone {
+				fmt.Printf("Ahead: %d, Behind: %d\n", status.AheadCount, status.BehindCount)
+			}
+			fmt.Printf("Stashes: %d\n\n", status.StashCount)
+
+			if len(status.ConflictedFiles) > 0 {
+				fmt.Println("Conflicted files:")
+				for _, file := range status.ConflictedFiles {
+					fmt.Printf("  - %s\n", file)
+				}
+				fmt.Println()
+			}
+
+			fmt.Println("Staged files:")
+			for _, file := range status.StagedFiles {
+				fmt.Printf("  - %s\n", file)
+			}
+
+			fmt.Println("\nModified files:")
 			for _, file := range status.ModifiedFiles {
 				fmt.Printf("  - %s\n", file)
 			}
-			
+
 			fmt.Println("\nUntracked files:")
 			for _, file := range status.UntrackedFiles {
 				fmt.Printf("  - %s\n", file)
 			}
 		},
 	}
+	statusCmd.Flags().String("format", "text", "Output format (text or json)")
+	statusCmd.Flags().Bool("porcelain", false, "Print stable line-oriented output for scripts")
+	statusCmd.Flags().Bool("all", false, "Show a summary of the Git status of every agent")
 
 	// Add dependency commands
 	
@@ -339,7 +742,7 @@ func main() {
 	listDepsCmd := &cobra.Command{
 		Use:   "list-deps [agent-id]",
 		Short: "List dependencies in a container",
-		Long:  `Display the dependencies available in a container.`,
+		Long:  `Display the dependencies available in a container with their versions and the layer (core, team, container or override) each one resolves from.`,
 		Args:  cobra.ExactArgs(1),
 		Run: func(cmd *cobra.Command, args []string) {
 			agentID := args[0]
@@ -347,38 +750,62 @@ func main() {
 			// Get SSH directory for auth
 			homeDir, err := os.UserHomeDir()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting user home directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting user home directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			sshDir := filepath.Join(homeDir, ".ssh")
 			
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create agent manager
 			manager, err := agent.NewManager(sshDir, workspaceDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating agent manager: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating agent manager: %v\n", err)
+				exit(exitCode(err))
 			}
 
-			// Get command to list all dependencies
-			command := "ls -la /workspace/node_modules/"
-			output, err := manager.Exec(agentID, command)
+			format, _ := cmd.Flags().GetString("format")
+
+			// Resolve the effective dependencies across the layers
+			dependencies, err := manager.ListDependencies(agentID)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error listing dependencies: %v\n", err)
-				os.Exit(1)
+				errorf("Error listing dependencies: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(dependencies, "", "  ")
+				if err != nil {
+					errorf("Error marshaling dependencies to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
 			}
 
 			fmt.Printf("Dependencies for agent '%s':\n", agentID)
-			fmt.Println(output)
+			if len(dependencies) == 0 {
+				fmt.Println("No dependencies found")
+				return
+			}
+			fmt.Printf("%-8s %-40s %-14s %-10s %s\n", "PROVIDER", "PACKAGE", "VERSION", "LAYER", "SHADOWS")
+			for _, dep := range dependencies {
+				version := dep.Version
+				if dep.Path != "" {
+					version = dep.Path
+				}
+				fmt.Printf("%-8s %-40s %-14s %-10s %s\n", dep.Provider, dep.Name, version, dep.Layer, strings.Join(dep.Shadowed, ", "))
+			}
 		},
 	}
 
+	listDepsCmd.Flags().String("format", "text", "Output format (text or json)")
+
 	// Add dependency command
 	addDepCmd := &cobra.Command{
 		Use:   "add-dep [agent-id] [package]",
@@ -392,50 +819,54 @@ func main() {
 			// Get SSH directory for auth
 			homeDir, err := os.UserHomeDir()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting user home directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting user home directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			sshDir := filepath.Join(homeDir, ".ssh")
 			
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create agent manager
 			manager, err := agent.NewManager(sshDir, workspaceDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating agent manager: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating agent manager: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			// The package name becomes a path segment; commands get it as an argument
+			if packageName == "." || packageName == ".." || strings.ContainsAny(packageName, "/\\\x00") || strings.HasPrefix(packageName, "-") {
+				errorf("Error adding dependency: invalid package name '%s'\n", packageName)
+				exit(exitUsage)
 			}
+			packageDir := "/workspace/container-deps/" + packageName
 
 			// Create a stub directory for the package in the container-deps
-			command := fmt.Sprintf("mkdir -p /workspace/container-deps/%s", packageName)
-			_, err = manager.Exec(agentID, command)
+			_, err = manager.ExecArgs(agentID, "", "mkdir", "-p", packageDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error adding dependency: %v\n", err)
-				os.Exit(1)
+				errorf("Error adding dependency: %v\n", err)
+				exit(exitCode(err))
 			}
 
 			// Create a version file in the package directory
-			command = fmt.Sprintf("echo '1.0.0' > /workspace/container-deps/%s/version", packageName)
-			_, err = manager.Exec(agentID, command)
+			_, err = manager.ExecArgs(agentID, "", "sh", "-c", `echo '1.0.0' > "$1/version"`, "sh", packageDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error setting dependency version: %v\n", err)
-				os.Exit(1)
+				errorf("Error setting dependency version: %v\n", err)
+				exit(exitCode(err))
 			}
 
 			// Create symbolic link in node_modules
-			command = fmt.Sprintf("ln -sf /workspace/container-deps/%s /workspace/node_modules/%s", packageName, packageName)
-			_, err = manager.Exec(agentID, command)
+			_, err = manager.ExecArgs(agentID, "", "ln", "-sf", packageDir, "/workspace/node_modules/"+packageName)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error linking dependency: %v\n", err)
-				os.Exit(1)
+				errorf("Error linking dependency: %v\n", err)
+				exit(exitCode(err))
 			}
 
-			fmt.Printf("Added dependency '%s' to agent '%s'\n", packageName, agentID)
+			infof("Added dependency '%s' to agent '%s'\n", packageName, agentID)
 		},
 	}
 
@@ -453,31 +884,31 @@ func main() {
 			// Get SSH directory for auth
 			homeDir, err := os.UserHomeDir()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting user home directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting user home directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			sshDir := filepath.Join(homeDir, ".ssh")
 			
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create agent manager
 			manager, err := agent.NewManager(sshDir, workspaceDir)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating agent manager: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating agent manager: %v\n", err)
+				exit(exitCode(err))
 			}
 
 			// Check if the agent uses overlay
 			command := "if mount | grep -q 'overlay on /workspace/merged'; then echo 'enabled'; else echo 'disabled'; fi"
 			output, err := manager.Exec(agentID, command)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error checking overlay status: %v\n", err)
-				os.Exit(1)
+				errorf("Error checking overlay status: %v\n", err)
+				exit(exitCode(err))
 			}
 
 			isEnabled := strings.TrimSpace(output) == "enabled"
@@ -527,18 +958,18 @@ func main() {
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create team directory
 			teamPath := filepath.Join(workspaceDir, ".capsulate", "dependencies", "team", teamID)
 			if err := os.MkdirAll(teamPath, 0755); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating team directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating team directory: %v\n", err)
+				exit(exitCode(err))
 			}
 
-			fmt.Printf("Team '%s' created successfully\n", teamID)
+			infof("Team '%s' created successfully\n", teamID)
 		},
 	}
 	
@@ -555,25 +986,25 @@ func main() {
 			// Get current working directory as workspace
 			workspaceDir, err := os.Getwd()
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error getting current directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error getting current directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create package directory in team dependencies
 			packagePath := filepath.Join(workspaceDir, ".capsulate", "dependencies", "team", teamID, packageName)
 			if err := os.MkdirAll(packagePath, 0755); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating package directory: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating package directory: %v\n", err)
+				exit(exitCode(err))
 			}
 			
 			// Create a version file
 			versionFile := filepath.Join(packagePath, "version")
 			if err := os.WriteFile(versionFile, []byte("1.0.0"), 0644); err != nil {
-				fmt.Fprintf(os.Stderr, "Error creating version file: %v\n", err)
-				os.Exit(1)
+				errorf("Error creating version file: %v\n", err)
+				exit(exitCode(err))
 			}
 
-			fmt.Printf("Added dependency '%s' to team '%s'\n", packageName, teamID)
+			infof("Added dependency '%s' to team '%s'\n", packageName, teamID)
 		},
 	}
 
@@ -588,23 +1019,33 @@ func main() {
 	metricsShowCmd := &cobra.Command{
 		Use:   "show",
 		Short: "Show collected metrics",
-		Long:  `Display a summary of collected metrics.`,
+		Long:  `Display a summary of collected metrics.
+
+Timer durations, such as create_container, exec_command and clone, accumulate across
+commands in histograms, shown with their p50, p90 and p99 since averages hide the
+slow tail. Percentiles are estimated within the histogram buckets, set with
+metrics.buckets and metrics.timers in capsulate.yaml.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			format, _ := cmd.Flags().GetString("format")
 			
 			if format == "json" {
 				jsonSummary, err := metrics.GetSummaryJSON()
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error generating metrics summary: %v\n", err)
-					os.Exit(1)
+					errorf("Error generating metrics summary: %v\n", err)
+					exit(exitCode(err))
 				}
 				fmt.Println(jsonSummary)
 			} else {
-				summary := metrics.GetSummary()
-				fmt.Println("📊 Metrics Summary:")
-				fmt.Println("=====================================")
+				report, err := metrics.GetReport()
+				if err != nil {
+					errorf("Error generating metrics summary: %v\n", err)
+					exit(exitCode(err))
+				}
+				infof("📊 Metrics Summary:\n")
+				infof("=====================================\n")
 				
-				for category, catSummary := range summary {
+				for _, category := range sortedKeys(report.Categories) {
+					catSummary := report.Categories[category]
 					fmt.Printf("🔹 Category: %s\n", category)
 					fmt.Printf("  Total operations: %d\n", catSummary.TotalCount)
 					if catSummary.AvgDuration > 0 {
@@ -612,15 +1053,25 @@ func main() {
 						fmt.Printf("  Min/Max duration: %.2f ms / %.2f ms\n", catSummary.MinDuration, catSummary.MaxDuration)
 					}
 					fmt.Println("  Operations:")
-					for opName, opStats := range catSummary.Operations {
+					for _, opName := range sortedKeys(catSummary.Operations) {
+						opStats := catSummary.Operations[opName]
 						fmt.Printf("    - %s: %d operations", opName, opStats.Count)
 						if opStats.AvgDuration > 0 {
-							fmt.Printf(", avg: %.2f ms", opStats.AvgDuration)
+							fmt.Printf(", avg: %s, p50: %s, p90: %s, p99: %s", formatMillis(opStats.AvgDuration),
+								formatMillis(opStats.P50), formatMillis(opStats.P90), formatMillis(opStats.P99))
 						}
 						fmt.Println()
 					}
 					fmt.Println()
 				}
+
+				if len(report.Caches) > 0 {
+					infof("💾 Caches:\n")
+					totals := metrics.CacheTotals(report.Caches)
+					for _, name := range metrics.CacheNames(report.Caches) {
+						displayCacheStats(name, totals[name])
+					}
+				}
 			}
 		},
 	}
@@ -632,7 +1083,15 @@ func main() {
 		Long:  `Clear all collected metrics from memory.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			metrics.Clear()
-			fmt.Println("✅ Metrics cleared")
+			if err := metrics.ClearCacheStats(); err != nil {
+				errorf("Error clearing cache stats: %v\n", err)
+				exit(exitCode(err))
+			}
+			if err := metrics.ClearHistograms(); err != nil {
+				errorf("Error clearing timer histograms: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("✅ Metrics cleared\n")
 		},
 	}
 	
@@ -658,41 +1117,61 @@ func main() {
 				stats = monitor.GetContainerStatsByAgentID(agentID)
 				if stats == nil || len(stats.([]*monitor.ContainerStats)) == 0 {
 					fmt.Printf("No stats available for agent '%s'\n", agentID)
-					os.Exit(0)
+					exit(0)
 				}
 			} else {
 				// Show stats for all agents
 				stats = monitor.GetAllContainerStats()
 				if stats == nil || len(stats.(map[string]*monitor.ContainerStats)) == 0 {
 					fmt.Println("No container stats available")
-					os.Exit(0)
+					exit(0)
 				}
 			}
 			
 			if format == "json" {
 				jsonData, err := json.MarshalIndent(stats, "", "  ")
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "Error marshaling stats to JSON: %v\n", err)
-					os.Exit(1)
+					errorf("Error marshaling stats to JSON: %v\n", err)
+					exit(exitCode(err))
 				}
 				fmt.Println(string(jsonData))
 			} else {
 				if len(args) > 0 {
 					// Display stats for a specific agent
 					agentStats := stats.([]*monitor.ContainerStats)
-					fmt.Printf("📊 Resource Usage for Agent '%s':\n", args[0])
-					fmt.Println("==========================================")
-					for _, stat := range agentStats {
-						displayContainerStats(stat)
+					infof("📊 Resource Usage for Agent '%s':\n", args[0])
+					infof("==========================================\n")
+					displayAgentStats(agentStats)
+					if caches, err := metrics.GetAgentCacheStats(args[0]); err == nil && len(caches) > 0 {
+						fmt.Println("  Caches:")
+						for _, name := range []string{metrics.CloneCache, metrics.DependencyCache} {
+							if cacheStats, ok := caches[name]; ok {
+								displayCacheStats(name, cacheStats)
+							}
+						}
 					}
 				} else {
 					// Display stats for all agents
 					allStats := stats.(map[string]*monitor.ContainerStats)
-					fmt.Println("📊 Resource Usage for All Agents:")
-					fmt.Println("==========================================")
+					infof("📊 Resource Usage for All Agents:\n")
+					infof("==========================================\n")
+					byAgent := make(map[string][]*monitor.ContainerStats)
 					for _, stat := range allStats {
-						fmt.Printf("🔹 Agent: %s\n", stat.AgentID)
-						displayContainerStats(stat)
+						// Agents of other projects may share an ID
+						key := stat.AgentID
+						if stat.Project != "" {
+							key = stat.Project + "/" + stat.AgentID
+						}
+						byAgent[key] = append(byAgent[key], stat)
+					}
+					agentIDs := make([]string, 0, len(byAgent))
+					for agentID := range byAgent {
+						agentIDs = append(agentIDs, agentID)
+					}
+					sort.Strings(agentIDs)
+					for _, agentID := range agentIDs {
+						fmt.Printf("🔹 Agent: %s\n", agentID)
+						displayAgentStats(byAgent[agentID])
 						fmt.Println()
 					}
 				}
@@ -707,7 +1186,7 @@ func main() {
 		Long:  `Start collecting resource usage statistics for agent containers.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			monitor.Start()
-			fmt.Println("✅ Monitoring started")
+			infof("✅ Monitoring started\n")
 		},
 	}
 	
@@ -717,7 +1196,7 @@ func main() {
 		Long:  `Stop collecting resource usage statistics for agent containers.`,
 		Run: func(cmd *cobra.Command, args []string) {
 			monitor.Stop()
-			fmt.Println("✅ Monitoring stopped")
+			infof("✅ Monitoring stopped\n")
 		},
 	}
 	
@@ -734,8 +1213,8 @@ func main() {
 				return
 			}
 			
-			fmt.Printf("🔍 Active Traces: %d\n", len(activeSpans))
-			fmt.Println("==========================================")
+			infof("🔍 Active Traces: %d\n", len(activeSpans))
+			infof("==========================================\n")
 			
 			// Group spans by trace ID
 			traceMap := make(map[string][]*tracing.Span)
@@ -765,6 +1244,22 @@ func main() {
 	// Register commands
 	rootCmd.AddCommand(createCmd)
 	rootCmd.AddCommand(destroyCmd)
+	rootCmd.AddCommand(newUndeleteCmd())
+	rootCmd.AddCommand(newTrashCmd())
+	rootCmd.AddCommand(newHistoryCmd())
+	rootCmd.AddCommand(newSearchCmd())
+	rootCmd.AddCommand(newDoctorCmd())
+	rootCmd.AddCommand(newWarmCmd())
+	rootCmd.AddCommand(newListCmd())
+	rootCmd.AddCommand(newHealthCmd())
+	rootCmd.AddCommand(newRecoverCmd())
+	rootCmd.AddCommand(newDedupCmd())
+	rootCmd.AddCommand(newCommitImageCmd())
+	rootCmd.AddCommand(newAdoptContainerCmd())
+	rootCmd.AddCommand(newTelemetryCmd())
+	rootCmd.AddCommand(newDocsCmd())
+	rootCmd.AddCommand(newValidateCmd())
+	rootCmd.AddCommand(newVersionCmd())
 	rootCmd.AddCommand(execCmd)
 	rootCmd.AddCommand(branchCmd)
 	rootCmd.AddCommand(checkoutCmd)
@@ -773,6 +1268,7 @@ func main() {
 	// Register dependency commands
 	rootCmd.AddCommand(listDepsCmd)
 	rootCmd.AddCommand(addDepCmd)
+	rootCmd.AddCommand(newCoreDepsCmd())
 	
 	// Register overlay commands
 	rootCmd.AddCommand(overlayStatusCmd)
@@ -780,6 +1276,52 @@ func main() {
 	// Register team commands
 	rootCmd.AddCommand(createTeamCmd)
 	rootCmd.AddCommand(addTeamDepCmd)
+	rootCmd.AddCommand(newTeamDepsCmd())
+
+	// Register sync commands
+	rootCmd.AddCommand(newSyncCmd())
+
+	// Register review commands
+	rootCmd.AddCommand(newReviewCmd())
+	rootCmd.AddCommand(newCheckCmd())
+	rootCmd.AddCommand(newScanCmd())
+	rootCmd.AddCommand(newScrubCmd())
+	rootCmd.AddCommand(newArtifactsCmd())
+	rootCmd.AddCommand(newCommitCmd())
+	rootCmd.AddCommand(newSuggestCmd())
+	rootCmd.AddCommand(newBlameCmd())
+	rootCmd.AddCommand(newGrepCmd())
+	rootCmd.AddCommand(newFilesCmd())
+
+	// Register conflict resolution commands
+	rootCmd.AddCommand(newConflictsCmd())
+	rootCmd.AddCommand(newResolveCmd())
+
+	// Register schedule commands
+	rootCmd.AddCommand(newScheduleCmd())
+	rootCmd.AddCommand(newRepoStatsCmd())
+	rootCmd.AddCommand(newCICmd())
+
+	// Register image and template commands
+	rootCmd.AddCommand(newImageCmd())
+	rootCmd.AddCommand(newTemplateCmd())
+
+	// Register service and port-forward commands
+	rootCmd.AddCommand(newServiceCmd())
+	rootCmd.AddCommand(newPortForwardCmd())
+
+	// Register backup, state and storage commands
+	rootCmd.AddCommand(newBackupCmd())
+	rootCmd.AddCommand(newStateCmd())
+	rootCmd.AddCommand(newEnvCmd())
+	rootCmd.AddCommand(newPruneCmd())
+	rootCmd.AddCommand(newCacheCmd())
+	rootCmd.AddCommand(newDuCmd())
+	rootCmd.AddCommand(newAnalyzeCmd())
+
+	// Register operation and benchmark commands
+	rootCmd.AddCommand(newOpCmd())
+	rootCmd.AddCommand(newBenchCmd())
 
 	// Add subcommands to their parent commands
 	metricsCmd.AddCommand(metricsShowCmd)
@@ -794,25 +1336,291 @@ func main() {
 	rootCmd.AddCommand(monitorCmd)
 	rootCmd.AddCommand(tracesCmd)
 
-	// Execute the root command
+	// The example workflows refer to the commands above, so they come last
+	rootCmd.AddCommand(newExamplesCmd(rootCmd))
+
+	// Global flags
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Suppress decorative and informational output")
+	rootCmd.PersistentFlags().String("project", "", "Project whose agents to work on (default $CAPSULATE_PROJECT or project in capsulate.yaml)")
+	rootCmd.PersistentFlags().String("caller", "", "Caller whose quotas apply, e.g. the orchestrator running the command (default $CAPSULATE_CALLER or the user)")
+	rootCmd.PersistentFlags().Bool("strict", false, "Refuse remote templates and images without a valid signature (default $CAPSULATE_STRICT or signatures.strict)")
+	rootCmd.PersistentFlags().Bool("no-telemetry", false, "Collect no metrics or traces for this command and the processes it starts")
+	rootCmd.PersistentFlags().String("lang", "", "Language of messages and help, e.g. de (default $GIT_CAPSULATE_LANG or the locale)")
+
+	// --project, --caller, --strict and --no-telemetry are passed on through the
+	// environment, so that every store and manager opened by a command, and the
+	// processes it starts, see them
+	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if project, _ := cmd.Flags().GetString("project"); project != "" {
+			if err := config.ValidateProject(project); err != nil {
+				errorf("Error: %v\n", err)
+				exit(exitUsage)
+			}
+			os.Setenv(config.ProjectEnv, project)
+		}
+		if caller, _ := cmd.Flags().GetString("caller"); caller != "" {
+			os.Setenv(config.CallerEnv, caller)
+		}
+		if strict, _ := cmd.Flags().GetBool("strict"); strict {
+			os.Setenv(config.StrictEnv, "1")
+		}
+		if noTelemetry, _ := cmd.Flags().GetBool("no-telemetry"); noTelemetry {
+			os.Setenv(telemetry.Env, "0")
+		}
+		applyTelemetry(cmd)
+		// Everything the command records is tied to its operation ID, except for the
+		// commands that read operations back
+		if !strings.HasPrefix(cmd.CommandPath(), "git-capsulate op") {
+			recordCommand(cmd)
+		}
+	}
+	rootCmd.PersistentPostRun = func(cmd *cobra.Command, args []string) {
+		recordUsage()
+	}
+
+	// Translate messages and help; JSON and other machine-readable output stays as is
+	setLanguage(rootCmd, os.Args[1:])
+
+	// Execute the root command. Commands exit on their own failures, so an error
+	// here is a usage error that cobra has already reported on stderr.
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		exit(exitUsage)
 	}
 }
 
+// newManager creates an agent manager for the current workspace, exiting on failure
+func newManager() *agent.Manager {
+	// Get SSH directory for auth
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		errorf("Error getting user home directory: %v\n", err)
+		exit(exitCode(err))
+	}
+	sshDir := filepath.Join(homeDir, ".ssh")
+
+	// Get current working directory as workspace
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		errorf("Error getting current directory: %v\n", err)
+		exit(exitCode(err))
+	}
+
+	// Create agent manager
+	manager, err := agent.NewManager(sshDir, workspaceDir)
+	if err != nil {
+		errorf("Error creating agent manager: %v\n", err)
+		exit(exitCode(err))
+	}
+	manager.SetNotifyErrorHandler(func(err error) {
+		errorf("Warning: failed to deliver %v\n", err)
+	})
+	manager.SetCapacityWarningHandler(func(err error) {
+		errorf("Warning: %v\n", err)
+	})
+	return manager
+}
+
 // Helper function to display container stats
 func displayContainerStats(stat *monitor.ContainerStats) {
-	fmt.Printf("  CPU: %.2f%%\n", stat.CPUUsage)
-	fmt.Printf("  Memory: %.2f%% (%.2f MB / %.2f MB)\n", 
+	if stat.CPULimit > 0 {
+		fmt.Printf("  CPU: %.2f%% of %.2f CPUs limit (%.2f%%)\n", stat.CPULimitPercent, stat.CPULimit, stat.CPUUsage)
+	} else {
+		fmt.Printf("  CPU: %.2f%%\n", stat.CPUUsage)
+	}
+	if stat.ThrottledPeriods > 0 {
+		fmt.Printf("  Throttled: %.2f%% of periods (%d periods, %s in total)\n",
+			stat.ThrottledPercent, stat.ThrottledPeriods, stat.ThrottledTime.Round(time.Millisecond))
+	}
+	memoryLimit := "host"
+	if stat.MemoryLimited {
+		memoryLimit = "limit"
+	}
+	fmt.Printf("  Memory: %.2f%% (%.2f MB / %.2f MB %s)\n", 
 		stat.MemoryPercent, 
 		float64(stat.MemoryUsage)/(1024*1024), 
-		float64(stat.MemoryLimit)/(1024*1024))
-	fmt.Printf("  Disk: Read %.2f MB, Write %.2f MB\n", 
-		float64(stat.DiskRead)/(1024*1024), 
-		float64(stat.DiskWrite)/(1024*1024))
-	fmt.Printf("  Network: Rx %.2f MB, Tx %.2f MB\n", 
-		float64(stat.NetRx)/(1024*1024), 
-		float64(stat.NetTx)/(1024*1024))
+		float64(stat.MemoryLimit)/(1024*1024),
+		memoryLimit)
+	fmt.Printf("  Disk: Read %.2f MB (%s), Write %.2f MB (%s)\n", 
+		float64(stat.DiskRead)/(1024*1024), formatRate(stat.DiskReadRate),
+		float64(stat.DiskWrite)/(1024*1024), formatRate(stat.DiskWriteRate))
+	fmt.Printf("  Network: Rx %.2f MB (%s), Tx %.2f MB (%s)\n", 
+		float64(stat.NetRx)/(1024*1024), formatRate(stat.NetRxRate),
+		float64(stat.NetTx)/(1024*1024), formatRate(stat.NetTxRate))
 	fmt.Printf("  Last Update: %s\n", stat.Timestamp.Format(time.RFC3339))
+	for _, alert := range stat.Alerts {
+		fmt.Printf("  ⚠️  %s\n", monitorAlerts[alert])
+	}
+}
+
+// monitorAlerts describes the alerts of the monitor
+var monitorAlerts = map[string]string{
+	monitor.AlertCPUPinned:       "pinned at its CPU limit",
+	monitor.AlertCPUThrottled:    "throttled by the CPU scheduler",
+	monitor.AlertMemoryNearLimit: "near its memory limit; it may be OOM-killed",
+}
+
+// displayAgentStats prints the stats of an agent's container followed by those of its
+// sidecars
+func displayAgentStats(stats []*monitor.ContainerStats) {
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Sidecar < stats[j].Sidecar
+	})
+	for _, stat := range stats {
+		if stat.Sidecar != "" {
+			fmt.Printf("  🔸 Sidecar: %s\n", stat.Sidecar)
+		}
+		displayContainerStats(stat)
+	}
+}
+
+// Helper function to print Git status as stable "key value" lines, one file per line
+func printPorcelainStatus(status *agent.GitStatus) {
+	fmt.Printf("branch %s\n", status.Branch)
+	fmt.Printf("commit %s\n", status.CurrentCommit)
+	fmt.Printf("upstream %s\n", status.Upstream)
+	fmt.Printf("tracking %s\n", status.Tracking)
+	fmt.Printf("detached %t\n", status.Detached)
+	fmt.Printf("pinned %s\n", status.PinnedCommit)
+	fmt.Printf("dirty %t\n", status.Dirty)
+	fmt.Printf("ahead %d\n", status.AheadCount)
+	fmt.Printf("behind %d\n", status.BehindCount)
+	fmt.Printf("stash %d\n", status.StashCount)
+	fmt.Printf("repository %t\n", !status.NoRepository)
+	for _, file := range status.StagedFiles {
+		fmt.Printf("staged %s\n", file)
+	}
+	for _, file := range status.ModifiedFiles {
+		fmt.Printf("modified %s\n", file)
+	}
+	for _, file := range status.ConflictedFiles {
+		fmt.Printf("conflicted %s\n", file)
+	}
+	for _, file := range status.UntrackedFiles {
+		fmt.Printf("untracked %s\n", file)
+	}
+}
+
+// Helper function reporting whether exec targets a group of agents
+func isFanOut(cmd *cobra.Command) bool {
+	all, _ := cmd.Flags().GetBool("all")
+	team, _ := cmd.Flags().GetString("team")
+	selector, _ := cmd.Flags().GetString("selector")
+	return all || team != "" || selector != ""
+}
+
+// Helper function resolving the agents targeted by --all, --team or --selector
+func fanOutAgentIDs(cmd *cobra.Command, manager *agent.Manager) ([]string, error) {
+	var agentIDs []string
+	var err error
+	if selector, _ := cmd.Flags().GetString("selector"); selector != "" {
+		agentIDs, err = manager.SelectAgentIDs(selector)
+	} else if team, _ := cmd.Flags().GetString("team"); team != "" {
+		agentIDs, err = manager.TeamAgentIDs(team)
+	} else {
+		agentIDs, err = manager.AgentIDs()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(agentIDs) == 0 {
+		return nil, fmt.Errorf("no agents match")
+	}
+	return agentIDs, nil
+}
+
+// Helper function to print fan-out exec results grouped by agent. It returns
+// whether the command succeeded on every agent.
+func printExecResults(results []agent.AgentExecResult, format string) bool {
+	ok := true
+	for _, r := range results {
+		if r.Error != "" || r.ExecResult == nil || r.ExitCode != 0 {
+			ok = false
+		}
+	}
+
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			errorf("Error marshaling results to JSON: %v\n", err)
+			exit(exitCode(err))
+		}
+		fmt.Println(string(jsonData))
+		return ok
+	}
+
+	succeeded := 0
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			fmt.Printf("=== %s (error) ===\n%s\n\n", r.AgentID, r.Error)
+		default:
+			fmt.Printf("=== %s (exit %d) ===\n", r.AgentID, r.ExitCode)
+			fmt.Print(r.Output)
+			if r.Output != "" && !strings.HasSuffix(r.Output, "\n") {
+				fmt.Println()
+			}
+			fmt.Println()
+			if r.ExitCode == 0 {
+				succeeded++
+			}
+		}
+	}
+	fmt.Printf("%d/%d agents succeeded\n", succeeded, len(results))
+	return ok
+}
+
+// Helper function to print the Git status of many agents as a compact table
+func printFleetStatus(statuses []agent.AgentStatus, format string) {
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			errorf("Error marshaling status to JSON: %v\n", err)
+			exit(exitCode(err))
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+
+	infof("📋 Agent Git Status:\n")
+	infof("==========================================\n")
+	if len(statuses) == 0 {
+		fmt.Println("No agents")
+		return
+	}
+	fmt.Printf("%-20s %-30s %-12s %s\n", "AGENT", "BRANCH", "AHEAD/BEHIND", "DIRTY FILES")
+	for _, s := range statuses {
+		if s.Error != "" {
+			fmt.Printf("%-20s error: %s\n", s.AgentID, s.Error)
+			continue
+		}
+		if s.Status.NoRepository {
+			fmt.Printf("%-20s %-30s %-12s %s\n", s.AgentID, "(no repository)", "-", "-")
+			continue
+		}
+		branch := s.Status.Branch
+		if s.Status.Detached {
+			branch = "(detached HEAD)"
+		}
+		dirty := len(s.Status.StagedFiles) + len(s.Status.ModifiedFiles) +
+			len(s.Status.UntrackedFiles) + len(s.Status.ConflictedFiles)
+		aheadBehind := fmt.Sprintf("+%d/-%d", s.Status.AheadCount, s.Status.BehindCount)
+		if s.Status.Tracking == agent.TrackingNone || s.Status.Tracking == agent.TrackingGone {
+			aheadBehind = s.Status.Tracking
+		}
+		fmt.Printf("%-20s %-30s %-12s %d\n", s.AgentID, branch, aheadBehind, dirty)
+	}
+}
+
+// Helper function to display the effectiveness of a cache
+func displayCacheStats(name string, stats metrics.CacheStats) {
+	fmt.Printf("    - %s: %d hits, %d misses (%.0f%% hit rate), %.2f MB saved",
+		name,
+		stats.Hits,
+		stats.Misses,
+		stats.HitRate()*100,
+		float64(stats.BytesSaved)/(1024*1024))
+	if stats.TimeSaved > 0 {
+		fmt.Printf(", %.1f s saved", stats.TimeSaved/1000)
+	}
+	fmt.Println()
 } 
\ No newline at end of file
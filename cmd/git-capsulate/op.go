@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// operationDetails is everything recorded about an operation, as shown by op show
+type operationDetails struct {
+	ID      string                     `json:"id"`
+	Entries []tracing.OperationEntry   `json:"entries"`
+	Traces  map[string][]*tracing.Span `json:"traces,omitempty"`
+}
+
+// newOpCmd builds the op command that shows what was recorded about operations
+func newOpCmd() *cobra.Command {
+	opCmd := &cobra.Command{
+		Use:   "op",
+		Short: "Show what was recorded about past operations",
+		Long: `Every command is an operation with its own ID, passed on to the processes it starts
+through GIT_CAPSULATE_OPERATION_ID. The traces, events and metrics recorded while it
+runs are tied to that ID, along with the command line, so that a failure can be
+debugged from everything it left behind. Long-running loops such as 'schedule run'
+and 'prune --every' start a new operation for each round.
+
+Operations are recorded in the operations directory of the traces path
+(~/.git-capsulate/traces or $GIT_CAPSULATE_TRACES_PATH) unless tracing is disabled.`,
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List recent operations",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			limit, _ := cmd.Flags().GetInt("limit")
+			format, _ := cmd.Flags().GetString("format")
+
+			operations, err := tracing.ListOperations()
+			if err != nil {
+				errorf("Error listing operations: %v\n", err)
+				exit(exitCode(err))
+			}
+			if limit > 0 && len(operations) > limit {
+				operations = operations[:limit]
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(operations, "", "  ")
+				if err != nil {
+					errorf("Error marshaling operations to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			if len(operations) == 0 {
+				infof("No operations recorded\n")
+				return
+			}
+			for _, op := range operations {
+				fmt.Printf("%s  %s  %4d entries  %s\n", op.ID, op.StartedAt.Local().Format("2006-01-02 15:04:05"),
+					op.Entries, op.Command)
+			}
+		},
+	}
+	listCmd.Flags().Int("limit", 20, "Show at most this many operations, 0 for all")
+	listCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	showCmd := &cobra.Command{
+		Use:   "show [operation-id]",
+		Short: "Show the command, traces, events and metrics of an operation",
+		Long: `Show everything recorded about an operation: the command line, the traces with
+their spans, the events and the metrics, in the order they were recorded. The ID
+may be shortened to an unambiguous prefix.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			id, entries, err := tracing.ReadOperation(args[0])
+			if err != nil {
+				errorf("Error reading operation: %v\n", err)
+				exit(exitCode(err))
+			}
+			details := operationDetails{ID: id, Entries: entries, Traces: make(map[string][]*tracing.Span)}
+			for _, entry := range entries {
+				if entry.Kind != tracing.OperationTrace {
+					continue
+				}
+				traceID, _ := entry.Attributes["trace_id"].(string)
+				// Traces removed since are left out, the journal still has their summary
+				if spans, err := tracing.ReadTrace(traceID); err == nil {
+					details.Traces[traceID] = spans
+				}
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(details, "", "  ")
+				if err != nil {
+					errorf("Error marshaling operation to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			displayOperation(details)
+		},
+	}
+	showCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	opCmd.AddCommand(listCmd)
+	opCmd.AddCommand(showCmd)
+
+	return opCmd
+}
+
+// recordCommand records the command line that started the current operation
+func recordCommand(cmd *cobra.Command) {
+	tracing.RecordOperation(tracing.OperationCommand, strings.Join(append([]string{cmd.Root().Name()}, os.Args[1:]...), " "), "", map[string]interface{}{
+		"command": cmd.CommandPath(),
+		"user":    os.Getenv("USER"),
+		"pid":     os.Getpid(),
+	})
+}
+
+// startOperation starts a new operation for a round of a long-running command
+func startOperation(cmd *cobra.Command) {
+	tracing.StartOperation()
+	recordCommand(cmd)
+}
+
+// displayOperation prints the journal of an operation, with the spans of each trace
+// indented under it
+func displayOperation(details operationDetails) {
+	infof("🔎 Operation %s\n", details.ID)
+	for _, entry := range details.Entries {
+		at := entry.Time.Local().Format("15:04:05.000")
+		agent := ""
+		if entry.AgentID != "" {
+			agent = " [" + entry.AgentID + "]"
+		}
+		switch entry.Kind {
+		case tracing.OperationCommand:
+			fmt.Printf("%s  command  %s\n", at, entry.Name)
+		case tracing.OperationTrace:
+			status, _ := entry.Attributes["status"].(float64)
+			fmt.Printf("%s  trace    %s%s %vms %s\n", at, entry.Name, agent, entry.Attributes["duration_ms"], spanStatus(int(status)))
+			traceID, _ := entry.Attributes["trace_id"].(string)
+			displaySpans(details.Traces[traceID])
+		default:
+			fmt.Printf("%s  %-7s  %s%s%s\n", at, entry.Kind, entry.Name, agent, formatAttributes(entry.Attributes))
+		}
+	}
+}
+
+// displaySpans prints the spans of a trace in the order they started, nested under
+// their parent
+func displaySpans(spans []*tracing.Span) {
+	sort.Slice(spans, func(i, j int) bool { return spans[i].StartTime.Before(spans[j].StartTime) })
+	depth := make(map[string]int)
+	for _, span := range spans {
+		if span.ParentID != "" {
+			depth[span.Context.SpanID] = depth[span.ParentID] + 1
+		}
+		indent := strings.Repeat("  ", depth[span.Context.SpanID]+1)
+		fmt.Printf("%s%s- %s %dms %s", strings.Repeat(" ", 14), indent, span.Name, span.Duration, spanStatus(span.Status.Code))
+		if span.Status.Message != "" {
+			fmt.Printf(": %s", span.Status.Message)
+		}
+		fmt.Println()
+		for _, event := range span.Events {
+			fmt.Printf("%s%s    * %s %s%s\n", strings.Repeat(" ", 14), indent, event.Timestamp.Local().Format(time.TimeOnly),
+				event.Name, formatAttributes(event.Attributes))
+		}
+	}
+}
+
+// spanStatus names a span status code
+func spanStatus(code int) string {
+	switch code {
+	case 1:
+		return "ok"
+	case 2:
+		return "error"
+	}
+	return "unset"
+}
+
+// formatAttributes formats attributes as " key=value" pairs sorted by key
+func formatAttributes(attributes map[string]interface{}) string {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%v", key, attributes[key])
+	}
+	return b.String()
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/i18n"
+)
+
+// Exit codes of the CLI. Scripts may rely on them; exec is the exception and exits
+// with the code of the command it ran.
+const (
+	exitOK            = 0
+	exitFailure       = 1 // any failure not covered below
+	exitUsage         = 2 // invalid arguments or flags
+	exitAgentNotFound = 3 // the agent does not exist
+	exitGit           = 4 // a Git (or other) command inside the agent failed
+	exitDocker        = 5 // the Docker daemon is unreachable or failed
+	exitConfig        = 6 // the configuration file is invalid
+	exitAgentBusy     = 7 // another process is creating, destroying or resetting the agent
+	exitHostKey       = 8 // the SSH host key of the Git server is unknown or changed
+	exitQuota         = 9 // the caller is over one of its quotas
+)
+
+// quiet suppresses decorative and informational output (set by --quiet)
+var quiet bool
+
+// exitCode maps an error returned by the Manager to the CLI exit code contract
+func exitCode(err error) int {
+	var exitErr *agent.ExitError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, agent.ErrAgentNotFound), errors.Is(err, agent.ErrNotInTrash), errors.Is(err, agent.ErrNoRunSummary):
+		return exitAgentNotFound
+	case errors.Is(err, agent.ErrAgentBusy):
+		return exitAgentBusy
+	case errors.Is(err, agent.ErrInvalidAgentID):
+		return exitUsage
+	case errors.Is(err, agent.ErrHostKeyVerification):
+		return exitHostKey
+	case errors.Is(err, agent.ErrQuotaExceeded):
+		return exitQuota
+	case agent.IsDockerError(err):
+		return exitDocker
+	case errors.Is(err, agent.ErrNoRepository), errors.As(err, &exitErr):
+		return exitGit
+	}
+	return exitFailure
+}
+
+// infof prints decorative or informational output such as headers, separators and
+// confirmations, in the language of --lang. It prints nothing with --quiet, leaving
+// only the requested data.
+func infof(format string, args ...interface{}) {
+	if quiet {
+		return
+	}
+	fmt.Print(i18n.Sprintf(format, args...))
+}
+
+// errorf prints an error or warning on stderr, in the language of --lang
+func errorf(format string, args ...interface{}) {
+	fmt.Fprint(os.Stderr, i18n.Sprintf(format, args...))
+}
+
+// formatMillis formats a duration in milliseconds, e.g. "1.2s" or "850ms"
+func formatMillis(ms float64) string {
+	d := time.Duration(ms * float64(time.Millisecond))
+	if d >= time.Second {
+		return d.Round(100 * time.Millisecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// formatBytes formats a size with a binary unit, e.g. "1.5 GiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// formatRate formats a rate in bytes per second, e.g. "1.5 MiB/s"
+func formatRate(bytesPerSec float64) string {
+	return formatBytes(int64(bytesPerSec)) + "/s"
+}
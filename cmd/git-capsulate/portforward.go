@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newPortForwardCmd builds the port-forward command that tunnels local ports into an agent
+func newPortForwardCmd() *cobra.Command {
+	portForwardCmd := &cobra.Command{
+		Use:   "port-forward [agent-id] [local-port:]agent-port...",
+		Short: "Forward local ports to ports inside an agent",
+		Long: `Listen on local ports and tunnel each connection to a port on the agent's localhost,
+where its services and sidecars listen, until interrupted. Connections run through
+docker exec, so no ports need to be published when the agent is created and
+forwarding works with a remote Docker host. A local port of 0 picks a free one.`,
+		Example: `  git-capsulate port-forward my-feature 8080:3000
+  git-capsulate port-forward my-feature 5432 6379:6379`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.MinimumNArgs(2)(cmd, args); err != nil {
+				return err
+			}
+			if err := agent.ValidateAgentID(args[0]); err != nil {
+				return err
+			}
+			for _, mapping := range args[1:] {
+				if _, _, err := agent.ParsePortMapping(mapping); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			address, _ := cmd.Flags().GetString("address")
+
+			manager := newManager()
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			errCh := make(chan error, len(args)-1)
+			for _, mapping := range args[1:] {
+				local, remote, _ := agent.ParsePortMapping(mapping)
+				listener, err := net.Listen("tcp", net.JoinHostPort(address, strconv.Itoa(local)))
+				if err != nil {
+					errorf("Error listening on port %d: %v\n", local, err)
+					exit(exitFailure)
+				}
+				infof("Forwarding %s -> %s:%d\n", listener.Addr(), args[0], remote)
+				go func() {
+					errCh <- manager.ForwardPort(ctx, args[0], listener, remote, func(err error) {
+						errorf("Error forwarding connection: %v\n", err)
+					})
+				}()
+			}
+
+			for range args[1:] {
+				if err := <-errCh; err != nil {
+					errorf("Error forwarding ports: %v\n", err)
+					exit(exitCode(err))
+				}
+			}
+		},
+	}
+	portForwardCmd.Flags().String("address", "127.0.0.1", "Local address to listen on")
+
+	return portForwardCmd
+}
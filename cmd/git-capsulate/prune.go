@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// newPruneCmd builds the prune command that enforces the retention of the clone cache
+// and images
+func newPruneCmd() *cobra.Command {
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove least recently used clone cache mirrors, old base images and orphans",
+		Long: `Enforce the retention policies of the clone cache (cache.retention) and of the
+base images replaced by 'image refresh' (images.retention) in capsulate.yaml:
+entries unused for longer than max_age are removed, then the least recently used
+ones until the total fits in max_size. The current base image and images used by
+containers are always kept. --max-age and --max-size override both policies.
+
+--orphans removes what no agent accounts for, as reported by 'doctor': containers of
+agents without state, state entries whose container is gone, overlay diff and work
+directories of unknown agents, and dangling images git-capsulate built. Agents being
+created or destroyed at the time are left alone. --cache, --images and --orphans
+select what is pruned; without any of them, the clone cache and images are.
+
+With --every, prune runs in the foreground at that interval until interrupted.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			cacheOnly, _ := cmd.Flags().GetBool("cache")
+			imagesOnly, _ := cmd.Flags().GetBool("images")
+			orphans, _ := cmd.Flags().GetBool("orphans")
+			maxAge, _ := cmd.Flags().GetDuration("max-age")
+			maxSize, _ := cmd.Flags().GetString("max-size")
+			every, _ := cmd.Flags().GetDuration("every")
+			format, _ := cmd.Flags().GetString("format")
+
+			manager := newManager()
+			cfg := manager.Config()
+			cacheRetention, imageRetention := cfg.Cache.Retention, cfg.Images.Retention
+			if maxAge > 0 {
+				cacheRetention.MaxAge = config.Duration(maxAge)
+				imageRetention.MaxAge = config.Duration(maxAge)
+			}
+			if maxSize != "" {
+				size, err := config.ParseByteSize(maxSize)
+				if err != nil {
+					errorf("Error: --max-size: %v\n", err)
+					exit(exitUsage)
+				}
+				cacheRetention.MaxSize = size
+				imageRetention.MaxSize = size
+			}
+
+			opts := agent.PruneOptions{DryRun: dryRun, Orphans: orphans}
+			selected := cacheOnly || imagesOnly || orphans
+			if !selected || cacheOnly {
+				opts.CloneCache = &cacheRetention
+			}
+			if !selected || imagesOnly {
+				opts.Images = &imageRetention
+			}
+
+			prune := func() bool {
+				report, err := manager.Prune(opts)
+				if format == "json" {
+					jsonData, jsonErr := json.MarshalIndent(report, "", "  ")
+					if jsonErr != nil {
+						errorf("Error marshaling prune report to JSON: %v\n", jsonErr)
+						exit(exitCode(jsonErr))
+					}
+					fmt.Println(string(jsonData))
+				} else {
+					displayPruneReport(report)
+				}
+				if err != nil {
+					errorf("Error pruning: %v\n", err)
+					return false
+				}
+				return true
+			}
+
+			if every <= 0 {
+				if !prune() {
+					exit(exitFailure)
+				}
+				return
+			}
+
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+			infof("🧹 Pruning every %s (Ctrl+C to stop)...\n", every)
+			for {
+				prune()
+				startOperation(cmd)
+				select {
+				case <-sigCh:
+					return
+				case <-time.After(every):
+				}
+			}
+		},
+	}
+	pruneCmd.Flags().Bool("dry-run", false, "Only report what would be removed")
+	pruneCmd.Flags().Bool("cache", false, "Only prune the clone cache")
+	pruneCmd.Flags().Bool("images", false, "Only prune images")
+	pruneCmd.Flags().Bool("orphans", false, "Remove containers, state, overlay directories and dangling images no agent accounts for")
+	pruneCmd.Flags().Duration("max-age", 0, "Remove entries unused for longer than this, overriding capsulate.yaml")
+	pruneCmd.Flags().String("max-size", "", "Keep at most this much, e.g. 10GB, overriding capsulate.yaml")
+	pruneCmd.Flags().Duration("every", 0, "Prune repeatedly at this interval until interrupted")
+	pruneCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	return pruneCmd
+}
+
+// displayPruneReport prints the entries removed by prune and the space reclaimed
+func displayPruneReport(report *agent.PruneReport) {
+	if report == nil {
+		return
+	}
+	verb := "Removed"
+	if report.DryRun {
+		verb = "Would remove"
+	}
+	if len(report.Removed) == 0 {
+		infof("Nothing to prune\n")
+		return
+	}
+	for _, entry := range report.Removed {
+		fmt.Printf("%-12s %-10s %10s  last used %s  %s\n", entry.Kind, entry.Reason, formatBytes(entry.Size),
+			entry.LastUsed.Local().Format("2006-01-02 15:04"), entry.Name)
+	}
+	infof("%s %d entries, %s\n", verb, len(report.Removed), formatBytes(report.Reclaimed))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newRecoverCmd builds the recover command that brings agents back after a host reboot
+func newRecoverCmd() *cobra.Command {
+	recoverCmd := &cobra.Command{
+		Use:   "recover [agent-id...]",
+		Short: "Bring agents back after their containers stopped, e.g. after a reboot",
+		Long: `Bring agents back after the host rebooted or their containers stopped. Stopped
+containers and sidecars are started, overlay workspaces are mounted again,
+dependency layers relinked and the SSH server and services marked autostart started.
+Containers that are gone are recreated from the agent's recorded configuration; the
+workspace on the host is kept. Running agents whose workspace is in place are left
+alone.
+
+The repository of each agent is then checked with git fsck. Without agent IDs,
+every agent matching --selector is recovered. 'schedule run' recovers all agents
+when it starts. Exits with code 1 when an agent could not be recovered or its
+repository is damaged.`,
+		Args: cobra.ArbitraryArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			selector, _ := cmd.Flags().GetString("selector")
+			format, _ := cmd.Flags().GetString("format")
+
+			manager := newManager()
+			agentIDs := args
+			if len(agentIDs) == 0 {
+				ids, err := manager.SelectAgentIDs(selector)
+				if err != nil {
+					errorf("Error selecting agents: %v\n", err)
+					exit(exitCode(err))
+				}
+				agentIDs = ids
+			}
+
+			text := format != "json"
+			if text {
+				infof("🩹 Recovering %d agents...\n", len(agentIDs))
+			}
+			results := manager.Recover(agentIDs, func(result agent.RecoverResult) {
+				if text {
+					printRecoverResult(result)
+				}
+			})
+
+			if !text {
+				jsonData, err := json.MarshalIndent(results, "", "  ")
+				if err != nil {
+					errorf("Error marshaling results to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+			}
+			if !recovered(results) {
+				exit(exitFailure)
+			}
+		},
+	}
+	recoverCmd.Flags().String("selector", "", "Without agent IDs, only recover agents whose labels match")
+	recoverCmd.Flags().String("format", "text", "Output format (text or json)")
+	return recoverCmd
+}
+
+// printRecoverResult prints the outcome of recovering one agent
+func printRecoverResult(result agent.RecoverResult) {
+	switch result.Status {
+	case agent.RecoverFailed:
+		fmt.Printf("  ✗ %s: %s\n", result.AgentID, result.Error)
+		return
+	case agent.RecoverHealthy:
+		fmt.Printf("  = %s already running\n", result.AgentID)
+	default:
+		fmt.Printf("  ✓ %s %s\n", result.AgentID, result.Status)
+	}
+	if result.Integrity != "ok" {
+		fmt.Printf("    ✗ repository damaged: %s\n", result.Integrity)
+	}
+}
+
+// recovered reports whether every agent was recovered with an intact repository
+func recovered(results []agent.RecoverResult) bool {
+	for _, result := range results {
+		if result.Status == agent.RecoverFailed || result.Integrity != "ok" {
+			return false
+		}
+	}
+	return true
+}
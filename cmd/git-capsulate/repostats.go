@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/schedule"
+)
+
+// newRepoStatsCmd builds the repo-stats command that reports the object storage of an
+// agent's repository
+func newRepoStatsCmd() *cobra.Command {
+	repoStatsCmd := &cobra.Command{
+		Use:   "repo-stats [agent-id]",
+		Short: "Show the objects, loose objects and pack size of an agent's repository",
+		Long: `Report how much the repository of an agent has grown: its object count, the loose
+objects not yet packed, the packfiles and their size, and the refs, along with the
+maintenance scheduled for the agent. Many loose objects or packs mean the repository
+needs maintenance: add it with 'schedule maintenance [agent-id]'.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+				return err
+			}
+			return agent.ValidateAgentID(args[0])
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			stats, err := newManager().RepoStats(args[0])
+			if err != nil {
+				errorf("Error getting repository stats: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(stats, "", "  ")
+				if err != nil {
+					errorf("Error marshaling repository stats to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			schedules, err := openScheduleStore().List(args[0])
+			if err != nil {
+				errorf("Warning: failed to list schedules: %v\n", err)
+			}
+			displayRepoStats(stats, schedules)
+		},
+	}
+	repoStatsCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	return repoStatsCmd
+}
+
+// displayRepoStats prints the repository stats of an agent and its maintenance
+// schedules
+func displayRepoStats(stats *agent.RepoStats, schedules []*schedule.Schedule) {
+	infof("📦 Repository of agent '%s':\n", stats.AgentID)
+	fmt.Printf("  Objects:        %d\n", stats.Objects)
+	fmt.Printf("  Loose objects:  %d (%s)\n", stats.LooseObjects, formatBytes(stats.LooseSize))
+	fmt.Printf("  Packs:          %d (%d objects, %s)\n", stats.Packs, stats.PackedObjects, formatBytes(stats.PackSize))
+	if stats.PrunePackable > 0 {
+		fmt.Printf("  Prunable:       %d loose objects already packed\n", stats.PrunePackable)
+	}
+	if stats.Garbage > 0 {
+		fmt.Printf("  Garbage:        %d files (%s)\n", stats.Garbage, formatBytes(stats.GarbageSize))
+	}
+	fmt.Printf("  Refs:           %d\n", stats.Refs)
+
+	scheduled := false
+	for _, sched := range schedules {
+		if !agent.IsMaintenanceCommand(sched.Command) {
+			continue
+		}
+		scheduled = true
+		lastRun := "never"
+		if n := len(sched.History); n > 0 {
+			lastRun = sched.LastRun.Local().Format("2006-01-02 15:04") + " (" + executionResult(sched.History[n-1]) + ")"
+		}
+		fmt.Printf("  Maintenance:    '%s' %s, last run %s\n", sched.ID, sched.Cron, lastRun)
+	}
+	if !scheduled {
+		fmt.Printf("  Maintenance:    not scheduled\n")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newReviewCmd builds the review command that summarizes an agent's changeset
+func newReviewCmd() *cobra.Command {
+	reviewCmd := &cobra.Command{
+		Use:   "review [agent-id]",
+		Short: "Generate a review report of an agent's changes",
+		Long: `Generate a report of the commits made, files touched and diff stat of an agent's
+work relative to a base ref, along with the results of any test and lint commands.
+The report is the artifact a human reviews before accepting agent work.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID := args[0]
+
+			format, _ := cmd.Flags().GetString("format")
+			base, _ := cmd.Flags().GetString("base")
+			tests, _ := cmd.Flags().GetStringArray("test")
+			lints, _ := cmd.Flags().GetStringArray("lint")
+			outputFile, _ := cmd.Flags().GetString("output")
+
+			if format != "markdown" && format != "json" {
+				errorf("Error: unknown format '%s' (use markdown or json)\n", format)
+				exit(exitUsage)
+			}
+
+			manager := newManager()
+			report, err := manager.Review(agentID, agent.ReviewOptions{
+				BaseRef: base,
+				Tests:   tests,
+				Lints:   lints,
+			})
+			if err != nil {
+				errorf("Error generating review: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			var content string
+			if format == "json" {
+				data, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					errorf("Error marshaling review to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				content = string(data) + "\n"
+			} else {
+				content = report.Markdown()
+			}
+
+			if outputFile != "" {
+				if err := os.WriteFile(outputFile, []byte(content), 0644); err != nil {
+					errorf("Error writing review: %v\n", err)
+					exit(exitCode(err))
+				}
+				infof("Review for agent '%s' written to %s\n", agentID, outputFile)
+				return
+			}
+			fmt.Print(content)
+		},
+	}
+
+	reviewCmd.Flags().String("format", "markdown", "Output format (markdown or json)")
+	reviewCmd.Flags().String("base", "", "Base ref to compare against (default: upstream branch or origin/HEAD)")
+	reviewCmd.Flags().StringArray("test", nil, "Test command to run inside the agent (repeatable)")
+	reviewCmd.Flags().StringArray("lint", nil, "Lint command to run inside the agent (repeatable)")
+	reviewCmd.Flags().StringP("output", "o", "", "Write the report to a file instead of stdout")
+
+	return reviewCmd
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// newScanCmd builds the scan command that checks images and dependency layers for
+// known vulnerabilities
+func newScanCmd() *cobra.Command {
+	scanCmd := &cobra.Command{
+		Use:   "scan [agent-id]",
+		Short: "Scan an agent's image and dependency layers for vulnerabilities",
+		Long: `Run the vulnerability scanner configured under scan in capsulate.yaml (trivy by
+default, or grype) on the host against an agent's image and its core, team and
+container dependency layers. With --image, only the base image is scanned.
+
+--fail-on (default scan.fail_on) exits with status 1 when a vulnerability of that
+severity or higher is found: low, medium, high or critical.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if image, _ := cmd.Flags().GetBool("image"); image {
+				return cobra.NoArgs(cmd, args)
+			}
+			if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+				return err
+			}
+			return agent.ValidateAgentID(args[0])
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			failOn, _ := cmd.Flags().GetString("fail-on")
+			format, _ := cmd.Flags().GetString("format")
+
+			var agentID string
+			if len(args) > 0 {
+				agentID = args[0]
+			}
+
+			manager := newManager()
+			if !cmd.Flags().Changed("fail-on") {
+				failOn = manager.Config().Scan.FailOn
+			}
+			if failOn != "" && !config.ValidScanSeverity(failOn) {
+				errorf("Error: --fail-on must be one of %s\n", strings.Join(config.ScanSeverities, ", "))
+				exit(exitUsage)
+			}
+
+			report, err := manager.Scan(agentID)
+			if err != nil {
+				errorf("Error scanning: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					errorf("Error marshaling scan report to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+			} else {
+				displayScanReport(report)
+			}
+
+			if failOn != "" {
+				if count := report.AtOrAbove(failOn); count > 0 {
+					errorf("Error: %d vulnerabilities of severity %s or higher\n", count, failOn)
+					exit(exitFailure)
+				}
+			}
+		},
+	}
+	scanCmd.Flags().Bool("image", false, "Scan the base image instead of an agent")
+	scanCmd.Flags().String("fail-on", "", "Fail when a vulnerability of this severity or higher is found (low, medium, high, critical)")
+	scanCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	return scanCmd
+}
+
+// displayScanReport prints the vulnerabilities found, most severe first, and the
+// counts by severity
+func displayScanReport(report *agent.ScanReport) {
+	infof("🔍 Scanned with %s:\n", report.Scanner)
+	for _, target := range report.Targets {
+		infof("  %s %s (%s)\n", target.Kind, target.Name, target.Ref)
+	}
+
+	if len(report.Vulnerabilities) == 0 {
+		infof("\n✅ No known vulnerabilities\n")
+		return
+	}
+	fmt.Printf("\n%-9s %-20s %-30s %-20s %-20s %s\n", "SEVERITY", "ID", "PACKAGE", "VERSION", "FIXED IN", "TARGET")
+	for _, vuln := range report.Vulnerabilities {
+		fmt.Printf("%-9s %-20s %-30s %-20s %-20s %s\n", vuln.Severity, vuln.ID, vuln.Package, vuln.Version, vuln.FixedVersion, vuln.Target)
+	}
+
+	var counts []string
+	for i := len(config.ScanSeverities) - 1; i >= 0; i-- {
+		severity := config.ScanSeverities[i]
+		counts = append(counts, fmt.Sprintf("%d %s", report.Counts[severity], severity))
+	}
+	if unknown := report.Counts["unknown"]; unknown > 0 {
+		counts = append(counts, fmt.Sprintf("%d unknown", unknown))
+	}
+	infof("\n%d vulnerabilities: %s\n", len(report.Vulnerabilities), strings.Join(counts, ", "))
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/schedule"
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// newScheduleCmd builds the schedule command that runs commands in agents on a cron schedule
+func newScheduleCmd() *cobra.Command {
+	scheduleCmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Run commands in agents on a cron schedule",
+		Long: `Schedule commands, such as 'git fetch --all', to run in an agent's working directory
+on a five-field cron expression (minute hour day-of-month month day-of-week, in local
+time) or a macro such as @hourly. Schedules are kept in .capsulate/schedules and run
+by 'git-capsulate schedule run', which keeps long-lived agents fresh without an
+external cron. A schedule that was missed while no scheduler ran runs once.`,
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add [agent-id] --cron <expr> -- [command...]",
+		Short: "Schedule a command in an agent",
+		Example: `  git-capsulate schedule add agent1 --cron "0 * * * *" -- git fetch --all
+  git-capsulate schedule add agent1 --cron @daily --name nightly-gc -- git gc --auto`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.MinimumNArgs(2)(cmd, args); err != nil {
+				return fmt.Errorf("%v (the command follows the agent ID, after --)", err)
+			}
+			return agent.ValidateAgentID(args[0])
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			cronExpr, _ := cmd.Flags().GetString("cron")
+			name, _ := cmd.Flags().GetString("name")
+
+			states, err := state.NewStore(dataDir())
+			if err != nil {
+				errorf("Error opening agent state: %v\n", err)
+				exit(exitCode(err))
+			}
+			if _, exists, err := states.Get(args[0]); err != nil || !exists {
+				if err == nil {
+					err = fmt.Errorf("%w: '%s'", agent.ErrAgentNotFound, args[0])
+				}
+				errorf("Error adding schedule: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			sched := &schedule.Schedule{
+				ID:      name,
+				AgentID: args[0],
+				Cron:    cronExpr,
+				Command: args[1:],
+			}
+			if err := openScheduleStore().Add(sched); err != nil {
+				errorf("Error adding schedule: %v\n", err)
+				exit(exitUsage)
+			}
+
+			next, _ := sched.Next()
+			infof("Added schedule '%s' for agent '%s', next run %s\n", sched.ID, sched.AgentID, next.Format("2006-01-02 15:04"))
+		},
+	}
+	addCmd.Flags().String("cron", "", "Cron expression, e.g. \"0 * * * *\" or @hourly")
+	addCmd.Flags().String("name", "", "ID of the schedule (default: random)")
+	addCmd.MarkFlagRequired("cron")
+
+	maintenanceCmd := &cobra.Command{
+		Use:   "maintenance [agent-id]",
+		Short: "Schedule Git maintenance in an agent",
+		Long: `Schedule the maintenance of a long-lived agent's repository: reflog entries older
+than --reflog-expire are pruned, then 'git maintenance run --auto' (or 'git gc --auto'
+with older Git) repacks and collects garbage when needed. Use 'repo-stats' to see the
+effect on the repository's objects and packs.`,
+		Example: `  git-capsulate schedule maintenance agent1
+  git-capsulate schedule maintenance agent1 --cron "0 3 * * 0" --reflog-expire 2.weeks`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := cobra.ExactArgs(1)(cmd, args); err != nil {
+				return err
+			}
+			return agent.ValidateAgentID(args[0])
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			cronExpr, _ := cmd.Flags().GetString("cron")
+			name, _ := cmd.Flags().GetString("name")
+			reflogExpire, _ := cmd.Flags().GetString("reflog-expire")
+
+			command, err := agent.MaintenanceCommand(reflogExpire)
+			if err != nil {
+				errorf("Error: %v\n", err)
+				exit(exitUsage)
+			}
+
+			states, err := state.NewStore(dataDir())
+			if err != nil {
+				errorf("Error opening agent state: %v\n", err)
+				exit(exitCode(err))
+			}
+			if _, exists, err := states.Get(args[0]); err != nil || !exists {
+				if err == nil {
+					err = fmt.Errorf("%w: '%s'", agent.ErrAgentNotFound, args[0])
+				}
+				errorf("Error adding schedule: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if name == "" {
+				name = maintenanceSchedulePrefix + args[0]
+			}
+			sched := &schedule.Schedule{
+				ID:      name,
+				AgentID: args[0],
+				Cron:    cronExpr,
+				Command: command,
+			}
+			if err := openScheduleStore().Add(sched); err != nil {
+				errorf("Error adding schedule: %v\n", err)
+				exit(exitUsage)
+			}
+
+			next, _ := sched.Next()
+			infof("Scheduled maintenance '%s' for agent '%s', next run %s\n", sched.ID, sched.AgentID, next.Format("2006-01-02 15:04"))
+		},
+	}
+	maintenanceCmd.Flags().String("cron", "@daily", "Cron expression, e.g. \"0 3 * * *\" or @weekly")
+	maintenanceCmd.Flags().String("name", "", "ID of the schedule (default: maintenance-<agent-id>)")
+	maintenanceCmd.Flags().String("reflog-expire", agent.DefaultReflogExpire, "Prune reflog entries older than this, e.g. 2.weeks, or never")
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List schedules with their last and next runs",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID, _ := cmd.Flags().GetString("agent")
+			format, _ := cmd.Flags().GetString("format")
+
+			schedules, err := openScheduleStore().List(agentID)
+			if err != nil {
+				errorf("Error listing schedules: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				if schedules == nil {
+					schedules = []*schedule.Schedule{}
+				}
+				jsonData, err := json.MarshalIndent(schedules, "", "  ")
+				if err != nil {
+					errorf("Error marshaling schedules to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			if len(schedules) == 0 {
+				fmt.Println("No schedules")
+				return
+			}
+			fmt.Printf("%-12s %-15s %-15s %-16s %-16s %-8s %s\n", "ID", "AGENT", "CRON", "LAST RUN", "NEXT RUN", "RESULT", "COMMAND")
+			for _, sched := range schedules {
+				lastRun, result := "-", "-"
+				if n := len(sched.History); n > 0 {
+					lastRun = sched.LastRun.Local().Format("2006-01-02 15:04")
+					result = executionResult(sched.History[n-1])
+				}
+				nextRun := "never"
+				if next, err := sched.Next(); err == nil && !next.IsZero() {
+					nextRun = next.Format("2006-01-02 15:04")
+				}
+				fmt.Printf("%-12s %-15s %-15s %-16s %-16s %-8s %s\n", sched.ID, sched.AgentID, sched.Cron,
+					lastRun, nextRun, result, strings.Join(sched.Command, " "))
+			}
+		},
+	}
+	listCmd.Flags().String("agent", "", "Only list the schedules of this agent")
+	listCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	removeCmd := &cobra.Command{
+		Use:   "remove [schedule-id]",
+		Short: "Remove a schedule and its history",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := openScheduleStore().Remove(args[0]); err != nil {
+				errorf("Error removing schedule: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("Removed schedule '%s'\n", args[0])
+		},
+	}
+
+	historyCmd := &cobra.Command{
+		Use:   "history [schedule-id]",
+		Short: "Show the recent executions of a schedule",
+		Long: fmt.Sprintf(`Show the last %d executions of a schedule, newest first, with the last lines of
+their output (--output for all that was kept).`, schedule.MaxHistory),
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+			showOutput, _ := cmd.Flags().GetBool("output")
+
+			sched, err := openScheduleStore().Get(args[0])
+			if err != nil {
+				errorf("Error getting schedule: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				history := sched.History
+				if history == nil {
+					history = []schedule.Execution{}
+				}
+				jsonData, err := json.MarshalIndent(history, "", "  ")
+				if err != nil {
+					errorf("Error marshaling history to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			if len(sched.History) == 0 {
+				fmt.Println("No executions")
+				return
+			}
+			for i := len(sched.History) - 1; i >= 0; i-- {
+				exec := sched.History[i]
+				fmt.Printf("%s  %-8s %s\n", exec.StartedAt.Local().Format("2006-01-02 15:04:05"),
+					executionResult(exec), exec.Duration.Round(time.Millisecond))
+				if exec.Error != "" {
+					fmt.Printf("    %s\n", exec.Error)
+				}
+				printExecutionOutput(exec.Output, showOutput)
+			}
+		},
+	}
+	historyCmd.Flags().String("format", "text", "Output format (text or json)")
+	historyCmd.Flags().Bool("output", false, "Show all of the output kept for each execution")
+
+	runCmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run due schedules until interrupted",
+		Long: `Run the scheduler in the foreground: every minute, the schedules that are due run one
+after another and their results are recorded in their history. With --once, the due
+schedules run a single time, e.g. from a host cron job or a CI step.
+
+Before running schedules, agents whose containers stopped, e.g. because the host
+rebooted, are recovered as with 'git-capsulate recover', unless --no-recover is
+given.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			once, _ := cmd.Flags().GetBool("once")
+
+			manager := newManager()
+			runDue := func(now time.Time) {
+				ran, err := manager.RunDueSchedules(now)
+				for _, sched := range ran {
+					exec := sched.History[len(sched.History)-1]
+					fmt.Printf("%s  %-12s %-15s %-8s %s\n", exec.StartedAt.Local().Format("2006-01-02 15:04:05"),
+						sched.ID, sched.AgentID, executionResult(exec), strings.Join(sched.Command, " "))
+					if exec.Error != "" {
+						errorf("Error running schedule '%s': %s\n", sched.ID, exec.Error)
+					}
+				}
+				if err != nil {
+					errorf("Error running schedules: %v\n", err)
+				}
+			}
+
+			if once {
+				runDue(time.Now())
+				return
+			}
+
+			noRecover, _ := cmd.Flags().GetBool("no-recover")
+			if !noRecover {
+				agentIDs, err := manager.AgentIDs()
+				if err != nil {
+					errorf("Error listing agents: %v\n", err)
+					exit(exitCode(err))
+				}
+				for _, result := range manager.Recover(agentIDs, nil) {
+					if result.Status != agent.RecoverHealthy || result.Integrity != "ok" {
+						printRecoverResult(result)
+					}
+				}
+			}
+
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+
+			infof("⏰ Running schedules (Ctrl+C to stop)...\n")
+			for {
+				runDue(time.Now())
+				startOperation(cmd)
+				// Wake at the start of the next minute
+				now := time.Now()
+				select {
+				case <-sigCh:
+					return
+				case <-time.After(now.Truncate(time.Minute).Add(time.Minute).Sub(now)):
+				}
+			}
+		},
+	}
+	runCmd.Flags().Bool("once", false, "Run the due schedules once and exit")
+	runCmd.Flags().Bool("no-recover", false, "Do not recover stopped agents on startup")
+
+	scheduleCmd.AddCommand(addCmd)
+	scheduleCmd.AddCommand(maintenanceCmd)
+	scheduleCmd.AddCommand(listCmd)
+	scheduleCmd.AddCommand(removeCmd)
+	scheduleCmd.AddCommand(historyCmd)
+	scheduleCmd.AddCommand(runCmd)
+
+	return scheduleCmd
+}
+
+// maintenanceSchedulePrefix starts the default IDs of maintenance schedules
+const maintenanceSchedulePrefix = "maintenance-"
+
+// executionResult summarizes the outcome of a schedule execution
+func executionResult(exec schedule.Execution) string {
+	switch {
+	case exec.Error != "":
+		return "error"
+	case exec.Passed:
+		return "passed"
+	}
+	return fmt.Sprintf("exit %d", exec.ExitCode)
+}
+
+// printExecutionOutput prints the output of an execution indented, limited to its
+// last lines unless all is set
+func printExecutionOutput(output string, all bool) {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return
+	}
+	lines := strings.Split(output, "\n")
+	if !all && len(lines) > 5 {
+		lines = lines[len(lines)-5:]
+	}
+	for _, line := range lines {
+		fmt.Printf("    %s\n", line)
+	}
+}
+
+// workspaceDir returns the current directory, the workspace of git-capsulate
+func workspaceDir() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		errorf("Error getting current directory: %v\n", err)
+		exit(exitCode(err))
+	}
+	return dir
+}
+
+// dataDir returns the data directory of the current project in the workspace
+func dataDir() string {
+	workspaceDir := workspaceDir()
+	cfg, err := config.Load(workspaceDir)
+	if err != nil {
+		errorf("Error loading config: %v\n", err)
+		exit(exitConfig)
+	}
+	project, err := config.ResolveProject(cfg)
+	if err != nil {
+		errorf("Error: %v\n", err)
+		exit(exitConfig)
+	}
+	return config.DataDir(workspaceDir, project)
+}
+
+// openScheduleStore opens the schedule store of the workspace without contacting Docker
+func openScheduleStore() *schedule.Store {
+	store, err := schedule.NewStore(dataDir())
+	if err != nil {
+		errorf("Error opening schedule store: %v\n", err)
+		exit(exitCode(err))
+	}
+	return store
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newScrubCmd builds the scrub command that removes credentials from an agent's files
+func newScrubCmd() *cobra.Command {
+	scrubCmd := &cobra.Command{
+		Use:   "scrub [agent-id]",
+		Short: "Remove credential files from an agent and look for secrets in its changes",
+		Long: `Remove the credential files (.git-credentials, .netrc, .pypirc and SSH private
+keys) from the agent's repository and container-level dependencies, which outlive
+its container, and strip the auth entries of .npmrc files there. Files committed to
+the repository are only reported.
+
+The agent's uncommitted changes are scanned for private keys, well-known token
+formats and high-entropy values assigned to secret-looking keys; those are reported,
+not removed. 'destroy' scrubs agents after their pre-stop hooks unless --no-scrub is
+given; its snapshot and trash bundles, 'backup create --workspaces' and 'commit-image'
+leave credential files out.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID := args[0]
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			format, _ := cmd.Flags().GetString("format")
+
+			report, err := newManager().Scrub(agentID, dryRun)
+			if err != nil {
+				errorf("Error scrubbing agent: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					errorf("Error marshaling scrub report to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			displayScrubReport(report)
+		},
+	}
+	scrubCmd.Flags().Bool("dry-run", false, "Only report what would be removed")
+	scrubCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	return scrubCmd
+}
+
+// displayScrubReport prints the credential files removed and warns about secrets
+// found in the changes
+func displayScrubReport(report *agent.ScrubReport) {
+	if report.Clean() {
+		infof("✅ No credentials found in agent '%s'\n", report.AgentID)
+		return
+	}
+	removed, scrubbed := "Removed", "Stripped auth entries from"
+	if report.DryRun {
+		removed, scrubbed = "Would remove", "Would strip auth entries from"
+	}
+	for _, file := range report.Removed {
+		fmt.Printf("🧽 %s %s\n", removed, file)
+	}
+	for _, file := range report.Scrubbed {
+		fmt.Printf("🧽 %s %s\n", scrubbed, file)
+	}
+	for _, file := range report.Tracked {
+		errorf("Warning: %s is committed to the repository and was left in place\n", file)
+	}
+	for _, secret := range report.Secrets {
+		errorf("Warning: possible %s in %s:%d\n", secret.Kind, secret.Path, secret.Line)
+	}
+}
+
+// reportScrubBeforeDestroy reports the scrub of an agent being destroyed. Failures,
+// e.g. for a stopped container, are only warned about, as the destroy goes on.
+func reportScrubBeforeDestroy(agentID string, report *agent.ScrubReport, err error) {
+	if err != nil {
+		if !errors.Is(err, agent.ErrAgentNotFound) {
+			errorf("Warning: failed to scrub agent '%s' before destroying it: %v\n", agentID, err)
+		}
+		return
+	}
+	if !report.Clean() {
+		displayScrubReport(report)
+	}
+}
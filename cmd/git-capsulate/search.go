@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// newSearchCmd builds the search command that finds agents and past runs by query
+func newSearchCmd() *cobra.Command {
+	searchCmd := &cobra.Command{
+		Use:   "search [query]",
+		Short: "Find agents by branch, team, labels, checks and live Git status",
+		Long: `Find the agents matching a query of space-separated key:value terms that must
+all hold. A term prefixed with - must not hold, and a bare word matches agent IDs
+containing it:
+
+  id, branch, team, template, repo   glob; * matches any text, / included
+  label:key=glob, label:key          label value, or that the label is set
+  checks:passed|failed|none          outcome of the last check run
+  age:>24h, age:<7d                  time since the agent was created
+  dirty:true|false                   uncommitted changes
+  tracking:ahead|behind|diverged|in-sync|gone|none
+  container:running|exited|missing
+
+dirty, tracking and container are read live from each agent, concurrently, and
+make branch match the current branch rather than the one the agent was created on.
+With --history the summaries of destroyed agents are searched instead; only the
+first group of keys applies, and age is the time since the run ended.
+
+  git-capsulate search "branch:feature/* dirty:true team:payments"
+  git-capsulate search "label:purpose=ci checks:failed" --history`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			history, _ := cmd.Flags().GetBool("history")
+			format, _ := cmd.Flags().GetString("format")
+			query := strings.Join(args, " ")
+
+			if _, err := agent.ParseQuery(query); err != nil {
+				errorf("Error: %v\n", err)
+				exit(exitUsage)
+			}
+			manager := newManager()
+
+			if history {
+				summaries, err := manager.SearchHistory(query)
+				if err != nil {
+					errorf("Error searching history: %v\n", err)
+					exit(exitCode(err))
+				}
+				printSearchHistory(summaries, format)
+				return
+			}
+
+			matches, err := manager.Search(query)
+			if err != nil {
+				errorf("Error searching agents: %v\n", err)
+				exit(exitCode(err))
+			}
+			printSearchMatches(matches, format)
+		},
+	}
+	searchCmd.Flags().Bool("history", false, "Search the run summaries of destroyed agents")
+	searchCmd.Flags().String("format", "text", "Output format (text or json)")
+	return searchCmd
+}
+
+// printSearchMatches prints the agents found by a search
+func printSearchMatches(matches []*agent.SearchMatch, format string) {
+	if format == "json" {
+		if matches == nil {
+			matches = []*agent.SearchMatch{}
+		}
+		jsonData, err := json.MarshalIndent(matches, "", "  ")
+		if err != nil {
+			errorf("Error marshaling matches to JSON: %v\n", err)
+			exit(exitCode(err))
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+
+	if len(matches) == 0 {
+		fmt.Println("No matching agents")
+		return
+	}
+	fmt.Printf("%-20s %-25s %-12s %-10s %-6s %s\n", "AGENT", "BRANCH", "TEAM", "CONTAINER", "DIRTY", "LABELS")
+	for _, match := range matches {
+		branch, container, dirty := match.Branch, "-", "-"
+		if match.Container != "" {
+			container = match.Container
+		}
+		if match.Git != nil {
+			if match.Git.Branch != "" {
+				branch = match.Git.Branch
+			}
+			dirty = fmt.Sprintf("%t", match.Git.Dirty)
+		}
+		fmt.Printf("%-20s %-25s %-12s %-10s %-6s %s\n", match.ID, branch, match.TeamID, container, dirty,
+			agent.FormatLabels(match.Labels))
+		if match.Error != "" {
+			errorf("Warning: status of agent '%s' unknown: %s\n", match.ID, match.Error)
+		}
+	}
+}
+
+// printSearchHistory prints the run summaries found by a search
+func printSearchHistory(summaries []*state.RunSummary, format string) {
+	if format == "json" {
+		if summaries == nil {
+			summaries = []*state.RunSummary{}
+		}
+		jsonData, err := json.MarshalIndent(summaries, "", "  ")
+		if err != nil {
+			errorf("Error marshaling run summaries to JSON: %v\n", err)
+			exit(exitCode(err))
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+
+	if len(summaries) == 0 {
+		fmt.Println("No matching agent runs")
+		return
+	}
+	fmt.Printf("%-40s %-25s %-12s %-17s %-6s %s\n", "SUMMARY", "BRANCH", "TEAM", "ENDED", "CHECKS", "LABELS")
+	for _, summary := range summaries {
+		fmt.Printf("%-40s %-25s %-12s %-17s %-6s %s\n", summary.ID, summary.Branch, summary.TeamID,
+			summary.EndedAt.Local().Format("2006-01-02 15:04"), summary.ChecksStatus,
+			agent.FormatLabels(summary.Labels))
+	}
+}
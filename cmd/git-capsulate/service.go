@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+)
+
+// newServiceCmd builds the service command that controls the services running inside agents
+func newServiceCmd() *cobra.Command {
+	serviceCmd := &cobra.Command{
+		Use:   "service",
+		Short: "Control the services running inside an agent",
+		Long: `Run long-lived processes, such as a database or a dev server, inside an agent.
+Services are declared under services in capsulate.yaml and run by
+capsulate-supervisor in the agent's container, which restarts them according to
+their restart policy (always, on-failure or never) and keeps their output.
+Services marked autostart start when the agent is created or recreated.`,
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list [agent-id]",
+		Short: "List the services of an agent and their state",
+		Args:  agentIDArgs(1, 1),
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			services, err := newManager().ListServices(args[0])
+			if err != nil {
+				errorf("Error listing services: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(services, "", "  ")
+				if err != nil {
+					errorf("Error marshaling services to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			if len(services) == 0 {
+				fmt.Println("No services declared")
+				return
+			}
+			fmt.Printf("%-20s %-10s %-8s %-10s %-6s %s\n", "NAME", "STATE", "PID", "RESTARTS", "EXIT", "RESTART")
+			for _, service := range services {
+				pid, exit := "-", "-"
+				if service.PID != 0 {
+					pid = strconv.Itoa(service.PID)
+				}
+				if service.ExitCode != nil {
+					exit = strconv.Itoa(*service.ExitCode)
+				}
+				restart := service.Restart
+				if !service.Declared {
+					restart += " (undeclared)"
+				}
+				fmt.Printf("%-20s %-10s %-8s %-10d %-6s %s\n", service.Name, service.State, pid, service.Restarts, exit, restart)
+			}
+		},
+	}
+	listCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	startCmd := &cobra.Command{
+		Use:   "start [agent-id] [name]",
+		Short: "Start a declared service in an agent",
+		Args:  agentIDArgs(2, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := newManager().StartService(args[0], args[1]); err != nil {
+				errorf("Error starting service: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("Started service '%s' in agent '%s'\n", args[1], args[0])
+		},
+	}
+
+	stopCmd := &cobra.Command{
+		Use:   "stop [agent-id] [name]",
+		Short: "Stop a service and the processes it started",
+		Args:  agentIDArgs(2, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := newManager().StopService(args[0], args[1]); err != nil {
+				errorf("Error stopping service: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("Stopped service '%s' in agent '%s'\n", args[1], args[0])
+		},
+	}
+
+	logsCmd := &cobra.Command{
+		Use:   "logs [agent-id] [name]",
+		Short: "Show the output of a service",
+		Args:  agentIDArgs(2, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			lines, _ := cmd.Flags().GetInt("lines")
+
+			output, err := newManager().ServiceLogs(args[0], args[1], lines)
+			if err != nil {
+				errorf("Error reading service logs: %v\n", err)
+				exit(exitCode(err))
+			}
+			fmt.Print(output)
+		},
+	}
+	logsCmd.Flags().Int("lines", 100, "Number of lines to show")
+
+	serviceCmd.AddCommand(listCmd, startCmd, stopCmd, logsCmd)
+
+	return serviceCmd
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// newStateCmd builds the state command that manages the on-disk format of the workspace
+func newStateCmd() *cobra.Command {
+	stateCmd := &cobra.Command{
+		Use:   "state",
+		Short: "Manage the on-disk format of the workspace's capsulate state",
+	}
+
+	migrateCmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade the workspace's state to the current schema version",
+		Long: `The agent state and the layout of .capsulate carry a schema version. Commands
+that open the workspace migrate state written by older releases automatically; this
+command does it explicitly. With --check nothing is changed: the pending migrations
+are listed and the command exits with 1 when there are any. State written by a newer
+release is never downgraded.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			check, _ := cmd.Flags().GetBool("check")
+			format, _ := cmd.Flags().GetString("format")
+
+			current, err := state.WorkspaceVersion(workspaceDir())
+			if err != nil {
+				errorf("Error reading schema version: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			var migrations []state.Migration
+			if check {
+				migrations, err = state.PendingMigrations(workspaceDir())
+			} else {
+				migrations, err = state.Migrate(workspaceDir())
+			}
+			if err != nil {
+				errorf("Error migrating state: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(map[string]interface{}{
+					"from_version": current,
+					"to_version":   state.SchemaVersion,
+					"migrations":   migrations,
+					"applied":      !check,
+				}, "", "  ")
+				if err != nil {
+					errorf("Error marshaling migrations to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+			} else if len(migrations) == 0 {
+				infof("✅ State is at schema version %d\n", current)
+			} else {
+				if check {
+					infof("%d migrations pending (schema version %d to %d):\n", len(migrations), current, state.SchemaVersion)
+				} else {
+					infof("✅ Migrated state from schema version %d to %d:\n", current, state.SchemaVersion)
+				}
+				for _, migration := range migrations {
+					fmt.Printf("  %d: %s\n", migration.Version, migration.Description)
+				}
+			}
+
+			if check && len(migrations) > 0 {
+				exit(exitFailure)
+			}
+		},
+	}
+	migrateCmd.Flags().Bool("check", false, "Only list pending migrations; exit with 1 if there are any")
+	migrateCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	stateCmd.AddCommand(migrateCmd)
+
+	return stateCmd
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// newSuggestCmd builds the suggest command that drafts commit messages and pull request descriptions
+func newSuggestCmd() *cobra.Command {
+	suggestCmd := &cobra.Command{
+		Use:   "suggest",
+		Short: "Draft a commit message or pull request description for an agent's changes",
+		Long: `Draft a commit message or pull request description from an agent's diff. With
+suggest.command set in capsulate.yaml, the command (e.g. a script calling an LLM
+endpoint) receives instructions and the diff on stdin and prints the draft;
+otherwise the draft summarizes the changed files and commits.
+
+Only the draft is printed, so it can be piped to a clipboard tool. It is also
+recorded in the agent's state: 'git-capsulate commit --suggested' uses the latest
+commit message and 'suggest show' prints either draft again.`,
+	}
+
+	commitMessageCmd := &cobra.Command{
+		Use:   "commit-message [agent-id]",
+		Short: "Draft a commit message for the staged (or all uncommitted) changes",
+		Args:  agentIDArgs(1, 1),
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+			runSuggest(args[0], agent.SuggestCommitMessage, "", format)
+		},
+	}
+	commitMessageCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	prDescriptionCmd := &cobra.Command{
+		Use:   "pr-description [agent-id]",
+		Short: "Draft a pull request description for the agent's branch",
+		Args:  agentIDArgs(1, 1),
+		Run: func(cmd *cobra.Command, args []string) {
+			base, _ := cmd.Flags().GetString("base")
+			format, _ := cmd.Flags().GetString("format")
+			runSuggest(args[0], agent.SuggestPRDescription, base, format)
+		},
+	}
+	prDescriptionCmd.Flags().String("base", "", "Base ref to compare against (default: upstream branch or origin/HEAD)")
+	prDescriptionCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	showCmd := &cobra.Command{
+		Use:   "show [agent-id] [commit-message|pr-description]",
+		Short: "Print the latest draft recorded for an agent",
+		Args:  agentIDArgs(2, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			suggestion, err := newManager().Suggestion(args[0], args[1])
+			if err != nil {
+				errorf("Error reading suggestion: %v\n", err)
+				exit(exitCode(err))
+			}
+			printSuggestion(suggestion, format)
+		},
+	}
+	showCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	suggestCmd.AddCommand(commitMessageCmd, prDescriptionCmd, showCmd)
+
+	return suggestCmd
+}
+
+// runSuggest drafts and prints a suggestion of the given kind
+func runSuggest(agentID, kind, base, format string) {
+	suggestion, err := newManager().Suggest(agentID, kind, base)
+	if err != nil {
+		errorf("Error drafting suggestion: %v\n", err)
+		exit(exitCode(err))
+	}
+	printSuggestion(suggestion, format)
+}
+
+// printSuggestion prints the text of a suggestion, or all of it as JSON
+func printSuggestion(suggestion *state.Suggestion, format string) {
+	if format == "json" {
+		jsonData, err := json.MarshalIndent(suggestion, "", "  ")
+		if err != nil {
+			errorf("Error marshaling suggestion to JSON: %v\n", err)
+			exit(exitCode(err))
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+	fmt.Println(suggestion.Text)
+}
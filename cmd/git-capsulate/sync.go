@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newSyncCmd builds the sync command for host <-> agent file synchronization
+func newSyncCmd() *cobra.Command {
+	syncCmd := &cobra.Command{
+		Use:   "sync [agent-id]",
+		Short: "Sync files between a host directory and an agent container",
+		Long: `Copy a host directory into an agent container and optionally keep watching it,
+pushing every change into the container. Useful for hybrid workflows where a human
+edits locally and the agent builds and tests inside the container.
+
+With --pull the direction is reversed: files changed inside the agent (according to
+git status at --to) are copied back into the host checkout at --from. Files that are
+also modified locally are left alone unless --force is given.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			agentID := args[0]
+
+			from, _ := cmd.Flags().GetString("from")
+			to, _ := cmd.Flags().GetString("to")
+			watch, _ := cmd.Flags().GetBool("watch")
+			exclude, _ := cmd.Flags().GetStringSlice("exclude")
+			pull, _ := cmd.Flags().GetBool("pull")
+			force, _ := cmd.Flags().GetBool("force")
+
+			if pull && watch {
+				errorf("Error: --pull cannot be combined with --watch\n")
+				exit(exitUsage)
+			}
+
+			absFrom, err := filepath.Abs(from)
+			if err != nil {
+				errorf("Error resolving source directory: %v\n", err)
+				exit(exitCode(err))
+			}
+			if info, err := os.Stat(absFrom); err != nil || !info.IsDir() {
+				errorf("Error: '%s' is not a directory\n", from)
+				exit(exitUsage)
+			}
+
+			manager := newManager()
+			opts := agent.SyncOptions{
+				From:    absFrom,
+				To:      to,
+				Exclude: exclude,
+			}
+
+			if pull {
+				result, err := manager.PullFromAgent(agentID, opts, force)
+				if err != nil {
+					var conflictErr *agent.SyncConflictError
+					if errors.As(err, &conflictErr) {
+						errorf("Error: these files are modified both locally and in the agent:\n")
+						for _, file := range conflictErr.Files {
+							errorf("  - %s\n", file)
+						}
+						errorf("Commit or stash your local changes, or re-run with --force to overwrite them.\n")
+						exit(exitFailure)
+					}
+					errorf("Error pulling from agent: %v\n", err)
+					exit(exitCode(err))
+				}
+				for _, file := range result.Copied {
+					fmt.Printf("  ↓ %s\n", file)
+				}
+				for _, file := range result.Removed {
+					fmt.Printf("  ✗ %s\n", file)
+				}
+				infof("Pulled %d changed and %d removed files from agent '%s'\n", len(result.Copied), len(result.Removed), agentID)
+				return
+			}
+
+			// Initial full copy
+			count, err := manager.SyncToAgent(agentID, opts)
+			if err != nil {
+				errorf("Error syncing to agent: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("Synced %d files from '%s' to agent '%s':%s\n", count, from, agentID, to)
+
+			if !watch {
+				return
+			}
+
+			// Watch until interrupted
+			stop := make(chan struct{})
+			sigCh := make(chan os.Signal, 1)
+			signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+			go func() {
+				<-sigCh
+				close(stop)
+			}()
+
+			infof("👀 Watching for changes (Ctrl+C to stop)...\n")
+			err = manager.WatchSync(agentID, opts, stop, func(event agent.SyncEvent) {
+				if event.Err != nil {
+					errorf("Error syncing changes: %v\n", event.Err)
+					return
+				}
+				for _, file := range event.Copied {
+					fmt.Printf("  ↑ %s\n", file)
+				}
+				for _, file := range event.Removed {
+					fmt.Printf("  ✗ %s\n", file)
+				}
+			})
+			if err != nil {
+				errorf("Error watching for changes: %v\n", err)
+				exit(exitCode(err))
+			}
+		},
+	}
+
+	syncCmd.Flags().String("from", ".", "Host directory to sync from")
+	syncCmd.Flags().String("to", "/workspace/repo", "Path inside the container to sync into")
+	syncCmd.Flags().BoolP("watch", "w", false, "Keep watching the host directory and push changes")
+	syncCmd.Flags().Bool("pull", false, "Copy files changed inside the agent back into the host checkout")
+	syncCmd.Flags().Bool("force", false, "With --pull, overwrite locally modified files")
+	syncCmd.Flags().StringSlice("exclude", nil, "Additional patterns to exclude (.git and .capsulate are always excluded)")
+
+	return syncCmd
+}
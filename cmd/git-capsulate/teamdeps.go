@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// newTeamDepsCmd builds the team-deps command group that versions team dependency layers
+func newTeamDepsCmd() *cobra.Command {
+	teamDepsCmd := &cobra.Command{
+		Use:   "team-deps",
+		Short: "Snapshot and roll back team dependencies",
+		Long: `Freeze the team dependency layer into timestamped snapshots and roll back to them.
+Agents created with --team-snapshot mount a snapshot instead of the live layer, so
+team-level upgrades do not change them.`,
+	}
+
+	freezeCmd := &cobra.Command{
+		Use:   "freeze [team-id]",
+		Short: "Snapshot the current team dependencies",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			teamID := args[0]
+			label, _ := cmd.Flags().GetString("label")
+
+			manager := newManager()
+			snapshot, err := manager.FreezeTeamDependencies(teamID, label)
+			if err != nil {
+				errorf("Error freezing team dependencies: %v\n", err)
+				exit(exitCode(err))
+			}
+			if quiet {
+				fmt.Println(snapshot.ID)
+				return
+			}
+			fmt.Printf("Team '%s' dependencies frozen as snapshot %s\n", teamID, snapshot.ID)
+		},
+	}
+	freezeCmd.Flags().StringP("label", "l", "", "Label to refer to the snapshot by")
+
+	rollbackCmd := &cobra.Command{
+		Use:   "rollback [team-id] [snapshot]",
+		Short: "Restore team dependencies from a snapshot",
+		Long: `Restore the team dependency layer from a snapshot ID or label. The current layer
+is frozen first (labelled pre-rollback) so the rollback can be undone.`,
+		Args: cobra.ExactArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			teamID := args[0]
+
+			manager := newManager()
+			snapshot, err := manager.RollbackTeamDependencies(teamID, args[1])
+			if err != nil {
+				errorf("Error rolling back team dependencies: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("Team '%s' dependencies rolled back to snapshot %s\n", teamID, snapshot.ID)
+		},
+	}
+
+	snapshotsCmd := &cobra.Command{
+		Use:   "snapshots [team-id]",
+		Short: "List team dependency snapshots",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			teamID := args[0]
+			format, _ := cmd.Flags().GetString("format")
+
+			manager := newManager()
+			snapshots, err := manager.ListTeamSnapshots(teamID)
+			if err != nil {
+				errorf("Error listing snapshots: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(snapshots, "", "  ")
+				if err != nil {
+					errorf("Error marshaling snapshots to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			infof("📸 Dependency snapshots for team '%s':\n", teamID)
+			infof("==========================================\n")
+			if len(snapshots) == 0 {
+				fmt.Println("No snapshots")
+				return
+			}
+			for _, snapshot := range snapshots {
+				fmt.Printf("%-20s %-16s %s\n", snapshot.ID, snapshot.Label, snapshot.CreatedAt.Format("2006-01-02 15:04:05"))
+			}
+		},
+	}
+	snapshotsCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	teamDepsCmd.AddCommand(freezeCmd)
+	teamDepsCmd.AddCommand(rollbackCmd)
+	teamDepsCmd.AddCommand(snapshotsCmd)
+
+	return teamDepsCmd
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/telemetry"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Telemetry of the running command, set by applyTelemetry
+var (
+	commandStarted    time.Time
+	telemetryDecision telemetry.Decision
+	telemetryConfig   config.TelemetryConfig
+)
+
+// telemetryStatus is what 'telemetry status' reports
+type telemetryStatus struct {
+	telemetry.Decision
+	// Commands are the commands switched individually in capsulate.yaml
+	Commands       map[string]bool  `json:"commands,omitempty"`
+	Tracing        bool             `json:"tracing"`
+	MetricsDir     string           `json:"metrics_dir"`
+	TracesDir      string           `json:"traces_dir"`
+	UsageReporting bool             `json:"usage_reporting"`
+	Usage          *telemetry.Usage `json:"usage,omitempty"`
+}
+
+// commandName returns the command path without the program name, e.g. "team-deps sync"
+func commandName(cmd *cobra.Command) string {
+	return strings.TrimPrefix(strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()), " ")
+}
+
+// applyTelemetry switches the collection of metrics and traces for the command
+// about to run. A configuration that fails to load leaves telemetry on; the command
+// reports the error itself.
+func applyTelemetry(cmd *cobra.Command) {
+	commandStarted = time.Now()
+	if cfg, err := config.Load(workspaceDir()); err == nil {
+		telemetryConfig = cfg.Telemetry
+	}
+	telemetryDecision = telemetry.Resolve(telemetryConfig, commandName(cmd))
+	telemetry.Apply(telemetryDecision.Enabled)
+}
+
+// recordUsage adds the command that ran to the usage reported to the endpoint
+// of telemetry.usage, and reports it once the interval has passed. Nothing is
+// recorded without an endpoint or while telemetry is off for the command.
+func recordUsage() {
+	if !telemetryDecision.Enabled || telemetryConfig.Usage.Endpoint == "" || telemetryDecision.Command == "" {
+		return
+	}
+	telemetry.RecordUsage(telemetryDecision.Command, time.Since(commandStarted))
+	telemetry.ReportUsage(telemetryConfig.Usage, false)
+}
+
+// exit records the usage of the command, which PersistentPostRun does not do for
+// commands that exit on their own, e.g. on failure, and exits with the given code
+func exit(code int) {
+	recordUsage()
+	os.Exit(code)
+}
+
+// newTelemetryCmd builds the telemetry command that shows and reports what is collected
+func newTelemetryCmd() *cobra.Command {
+	telemetryCmd := &cobra.Command{
+		Use:   "telemetry",
+		Short: "Show whether metrics and traces are collected and report usage",
+		Long: `Metrics, traces and operation journals are collected on the host, in
+~/.git-capsulate, unless telemetry is switched off, for every command or
+individual ones, in capsulate.yaml:
+
+  telemetry:
+    enabled: false        # off for every command...
+    commands:
+      create: true        # ...but create
+      exec: false         # a command entry also covers its subcommands
+
+GIT_CAPSULATE_TELEMETRY=0 (or --no-telemetry) turns it off for every command
+regardless, and GIT_CAPSULATE_TELEMETRY=1 on.
+
+With telemetry.usage.endpoint set, the commands run, without their arguments,
+with their counts and durations are reported there as JSON once per interval
+(default 24h), under a random installation ID. Agent IDs, repositories, paths
+and user names are never reported.`,
+	}
+
+	statusCmd := &cobra.Command{
+		Use:   "status [command]",
+		Short: "Show whether telemetry is collected, for every command or one",
+		Long: `Show whether metrics and traces are collected, and what decided it, for
+commands in general or the given one, e.g. 'telemetry status team-deps sync', along
+with the usage recorded since the last report.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			decision := telemetry.Resolve(telemetryConfig, strings.Join(args, " "))
+			status := telemetryStatus{
+				Decision:       decision,
+				Commands:       telemetryConfig.Commands,
+				Tracing:        decision.Enabled && tracing.EnvEnabled(),
+				MetricsDir:     metrics.Dir(),
+				TracesDir:      tracing.Dir(),
+				UsageReporting: decision.Enabled && telemetryConfig.Usage.Endpoint != "",
+			}
+			if telemetryConfig.Usage.Endpoint != "" {
+				usage, err := telemetry.LoadUsage()
+				if err != nil {
+					errorf("Error reading usage: %v\n", err)
+					exit(exitCode(err))
+				}
+				status.Usage = usage
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(status, "", "  ")
+				if err != nil {
+					errorf("Error marshaling telemetry status to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+			displayTelemetryStatus(status)
+		},
+	}
+	statusCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	reportCmd := &cobra.Command{
+		Use:   "report",
+		Short: "Report the usage recorded since the last report now",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if telemetryConfig.Usage.Endpoint == "" {
+				errorf("Error: no usage endpoint is configured under telemetry.usage in capsulate.yaml\n")
+				exit(exitUsage)
+			}
+			sent, err := telemetry.ReportUsage(telemetryConfig.Usage, true)
+			if err != nil {
+				errorf("Error reporting usage: %v\n", err)
+				exit(exitCode(err))
+			}
+			if !sent {
+				infof("No usage recorded since the last report\n")
+				return
+			}
+			infof("✅ Usage reported\n")
+		},
+	}
+
+	telemetryCmd.AddCommand(statusCmd)
+	telemetryCmd.AddCommand(reportCmd)
+	return telemetryCmd
+}
+
+// displayTelemetryStatus prints whether telemetry is collected and the pending usage
+func displayTelemetryStatus(status telemetryStatus) {
+	state := "on"
+	if !status.Enabled {
+		state = "off"
+	}
+	subject := "Telemetry"
+	if status.Command != "" {
+		subject = fmt.Sprintf("Telemetry for '%s'", status.Command)
+	}
+	fmt.Printf("%s: %s (%s)\n", subject, state, status.Source)
+	if status.Enabled && !status.Tracing {
+		fmt.Println("Tracing: off (GIT_CAPSULATE_TRACING_ENABLED)")
+	}
+	if len(status.Commands) > 0 {
+		names := make([]string, 0, len(status.Commands))
+		for name := range status.Commands {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("Commands:")
+		for _, name := range names {
+			state := "on"
+			if !status.Commands[name] {
+				state = "off"
+			}
+			fmt.Printf("  %-24s %s\n", name, state)
+		}
+	}
+	fmt.Printf("Metrics: %s\n", status.MetricsDir)
+	fmt.Printf("Traces:  %s\n", status.TracesDir)
+
+	if status.Usage == nil {
+		fmt.Println("Usage reporting: off (no telemetry.usage.endpoint)")
+		return
+	}
+	usage := status.Usage
+	state = "on"
+	if !status.UsageReporting {
+		state = "off"
+	}
+	fmt.Printf("Usage reporting: %s, installation %s\n", state, usage.InstallID)
+	if !usage.LastReport.IsZero() {
+		fmt.Printf("  Last report: %s\n", usage.LastReport.Local().Format("2006-01-02 15:04:05"))
+	}
+	if usage.LastError != "" {
+		fmt.Printf("  Last error: %s\n", usage.LastError)
+	}
+	names := make([]string, 0, len(usage.Commands))
+	for name := range usage.Commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Printf("  Pending since %s: %d commands\n", usage.Since.Local().Format("2006-01-02 15:04:05"), len(names))
+	for _, name := range names {
+		stats := usage.Commands[name]
+		fmt.Printf("    %-24s %4d runs, avg %s\n", name, stats.Count, formatMillis(stats.TotalDuration/float64(stats.Count)))
+	}
+}
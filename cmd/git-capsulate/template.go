@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"gopkg.in/yaml.v3"
+)
+
+// newTemplateCmd builds the template command that compares agents with their templates
+func newTemplateCmd() *cobra.Command {
+	templateCmd := &cobra.Command{
+		Use:   "template",
+		Short: "Manage templates and compare agents with the environment they were created from",
+	}
+
+	diffCmd := &cobra.Command{
+		Use:   "diff [agent-id]",
+		Short: "Report drift between an agent's container and its template",
+		Long: `Compare an agent's running container with the container its template and recorded
+configuration produce now: the base image, command, mounts, environment, labels and
+resource limits. Drift appears when the base image is refreshed, capsulate.yaml
+changes (e.g. registries or dependency providers) or the container is modified by
+hand. Exits with 1 when drift is found.
+
+With --fix, a drifted agent is recreated to converge; its workspace and uncommitted
+changes are kept.`,
+		Args: agentIDArgs(1, 1),
+		Run: func(cmd *cobra.Command, args []string) {
+			fix, _ := cmd.Flags().GetBool("fix")
+			format, _ := cmd.Flags().GetString("format")
+
+			manager := newManager()
+			report, err := manager.DetectDrift(args[0])
+			if err != nil {
+				errorf("Error detecting drift: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			fixed := false
+			if fix && report.Drifted() {
+				if format != "json" {
+					printDriftReport(report)
+					infof("🔧 Recreating agent '%s'...\n", args[0])
+				}
+				if err := manager.Recreate(args[0]); err != nil {
+					errorf("Error recreating agent: %v\n", err)
+					exit(exitCode(err))
+				}
+				if report, err = manager.DetectDrift(args[0]); err != nil {
+					errorf("Error detecting drift: %v\n", err)
+					exit(exitCode(err))
+				}
+				fixed = true
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					errorf("Error marshaling drift to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+			} else if !fixed || report.Drifted() {
+				printDriftReport(report)
+			} else {
+				infof("Agent '%s' recreated; no drift remains\n", args[0])
+			}
+
+			if report.Drifted() {
+				exit(exitFailure)
+			}
+		},
+	}
+	diffCmd.Flags().Bool("fix", false, "Recreate a drifted agent to converge it")
+	diffCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	useCmd := &cobra.Command{
+		Use:   "use [repo//path@ref]",
+		Short: "Use a template from a central Git repository",
+		Long: `Fetch a template from a Git repository at a pinned tag, branch or commit and add
+it to capsulate.yaml as templates.<name>.from, so agents can be created from it with
+--template <name>. The name defaults to the file name without its extension.
+
+  git-capsulate template use git@github.com:org/platform.git//templates/node-dev.yaml@v3
+
+The repository is fetched with the Git client of the host and cached in
+.capsulate/remote at the commit the ref resolves to; a moving ref such as a branch
+stays at that commit until 'template update'. Values set next to from in
+capsulate.yaml override those of the remote template. capsulate.yaml itself can
+layer on a central configuration with extends: <repo>//<path>@<ref>.
+
+When signatures keys are configured the template must carry a valid detached
+signature (<file>.minisig or <file>.sig) next to it; with --strict or
+signatures.strict an unsigned template is refused.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			name, _ := cmd.Flags().GetString("name")
+
+			ref, err := config.ParseRemoteRef(args[0])
+			if err != nil {
+				errorf("Error: %v\n", err)
+				exit(exitUsage)
+			}
+			if name == "" {
+				name = strings.TrimSuffix(path.Base(ref.Path), path.Ext(ref.Path))
+			}
+			if !config.ValidName(name) {
+				errorf("Error: invalid template name '%s'; set one with --name\n", name)
+				exit(exitUsage)
+			}
+
+			file, _, err := config.UpdateRemote(workspaceDir(), ref)
+			if err != nil {
+				errorf("Error fetching template: %v\n", err)
+				exit(exitFailure)
+			}
+			configPath := config.ResolvePath(workspaceDir())
+			var sigs config.SignatureConfig
+			if cfg, err := config.Load(workspaceDir()); err == nil {
+				sigs = cfg.Signatures
+			}
+			signer, err := config.VerifyRemote(workspaceDir(), filepath.Dir(configPath), sigs, file)
+			if err != nil {
+				errorf("Error verifying template: %v\n", err)
+				exit(exitFailure)
+			}
+			var template config.TemplateConfig
+			if err := yaml.Unmarshal(file.Content, &template); err != nil {
+				errorf("Error: %s is not a template: %v\n", ref, err)
+				exit(exitConfig)
+			}
+
+			if err := config.SetTemplateSource(configPath, name, ref.String()); err != nil {
+				errorf("Error updating config: %v\n", err)
+				exit(exitConfig)
+			}
+			if _, err := config.Load(workspaceDir()); err != nil {
+				errorf("Error: %s was updated but no longer loads: %v\n", configPath, err)
+				exit(exitConfig)
+			}
+			infof("Template '%s' uses %s at commit %.12s\n", name, ref, file.Commit)
+			if signer != "" {
+				infof("Signature verified with %s\n", signer)
+			}
+			infof("Create agents from it with --template %s\n", name)
+		},
+	}
+	useCmd.Flags().String("name", "", "Name of the template in capsulate.yaml (default: the file name)")
+
+	updateCmd := &cobra.Command{
+		Use:   "update",
+		Short: "Fetch the remote templates and configuration again",
+		Long: `Fetch the refs of the remote templates and of the configuration capsulate.yaml
+extends again. Tags and commits normally resolve to the same commit; branches move
+to their latest commit. Run 'template diff' afterwards to find agents to recreate.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			configPath := config.ResolvePath(workspaceDir())
+			data, err := os.ReadFile(configPath)
+			if err != nil {
+				errorf("Error reading config: %v\n", err)
+				exit(exitConfig)
+			}
+			refs, err := config.RemoteRefs(data)
+			if err != nil {
+				errorf("Error parsing config: %v\n", err)
+				exit(exitConfig)
+			}
+			if len(refs) == 0 {
+				infof("%s uses no remote templates\n", configPath)
+				return
+			}
+
+			failed := false
+			for _, name := range sortedKeys(refs) {
+				label := "extends"
+				if name != "" {
+					label = "template " + name
+				}
+				ref, err := config.ParseRemoteRef(refs[name])
+				if err != nil {
+					errorf("Error in %s: %v\n", label, err)
+					failed = true
+					continue
+				}
+				file, previous, err := config.UpdateRemote(workspaceDir(), ref)
+				if err != nil {
+					errorf("Error updating %s: %v\n", label, err)
+					failed = true
+					continue
+				}
+				switch previous {
+				case file.Commit:
+					fmt.Printf("%-24s %s unchanged (%.12s)\n", label, ref, file.Commit)
+				case "":
+					fmt.Printf("%-24s %s fetched (%.12s)\n", label, ref, file.Commit)
+				default:
+					fmt.Printf("%-24s %s updated %.12s -> %.12s\n", label, ref, previous, file.Commit)
+				}
+			}
+			if failed {
+				exit(exitFailure)
+			}
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the templates of capsulate.yaml and where they come from",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			cfg, err := config.Load(workspaceDir())
+			if err != nil {
+				errorf("Error loading config: %v\n", err)
+				exit(exitConfig)
+			}
+
+			type templateSource struct {
+				Name   string `json:"name"`
+				From   string `json:"from,omitempty"`
+				Commit string `json:"commit,omitempty"`
+				// Signature is the tool that verified the remote template, empty if unsigned
+				Signature string `json:"signature,omitempty"`
+				Sidecars  int    `json:"sidecars"`
+			}
+			configDir := filepath.Dir(config.ResolvePath(workspaceDir()))
+			var templates []templateSource
+			for _, name := range sortedKeys(cfg.Templates) {
+				template := cfg.Templates[name]
+				source := templateSource{Name: name, From: template.From, Sidecars: len(template.Sidecars)}
+				if ref, err := config.ParseRemoteRef(template.From); err == nil {
+					// Load has fetched it already
+					if file, err := config.ReadRemote(workspaceDir(), ref); err == nil {
+						source.Commit = file.Commit
+						source.Signature, _ = config.VerifyRemote(workspaceDir(), configDir, cfg.Signatures, file)
+					}
+				}
+				templates = append(templates, source)
+			}
+
+			if format == "json" {
+				if templates == nil {
+					templates = []templateSource{}
+				}
+				jsonData, err := json.MarshalIndent(templates, "", "  ")
+				if err != nil {
+					errorf("Error marshaling templates to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			if cfg.Extends != "" {
+				infof("Extends %s\n", cfg.Extends)
+			}
+			if len(templates) == 0 {
+				fmt.Println("No templates")
+				return
+			}
+			fmt.Printf("%-20s %-12s %-9s %8s  %s\n", "TEMPLATE", "COMMIT", "SIGNATURE", "SIDECARS", "FROM")
+			for _, template := range templates {
+				from, commit, signature := template.From, "-", "-"
+				if from == "" {
+					from = "(local)"
+				}
+				if template.Commit != "" {
+					commit = template.Commit[:12]
+					signature = "unsigned"
+				}
+				if template.Signature != "" {
+					signature = template.Signature
+				}
+				fmt.Printf("%-20s %-12s %-9s %8d  %s\n", template.Name, commit, signature, template.Sidecars, from)
+			}
+		},
+	}
+	listCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	templateCmd.AddCommand(diffCmd)
+	templateCmd.AddCommand(useCmd)
+	templateCmd.AddCommand(updateCmd)
+	templateCmd.AddCommand(listCmd)
+
+	return templateCmd
+}
+
+// printDriftReport prints the drift of an agent, one difference per line
+func printDriftReport(report *agent.DriftReport) {
+	if !report.Drifted() {
+		fmt.Printf("No drift: agent '%s' matches its configuration\n", report.AgentID)
+		return
+	}
+	template := report.Template
+	if template == "" {
+		template = "(none)"
+	}
+	infof("Agent '%s' (template %s) has drifted:\n", report.AgentID, template)
+	for _, drift := range report.Drift {
+		name := drift.Kind
+		if drift.Name != "" {
+			name += " " + drift.Name
+		}
+		fmt.Printf("  %s\n      expected: %s\n      actual:   %s\n", name, drift.Expected, drift.Actual)
+	}
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newUndeleteCmd builds the undelete command that restores a destroyed agent
+func newUndeleteCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "undelete [agent-id|entry-id]",
+		Short: "Restore a destroyed agent from the trash",
+		Long: `Restore a destroyed agent from .capsulate/trash: its workspace, overlay and
+dependency directories and its state are moved back and its container is recreated
+from the recorded configuration. Given an agent ID, its most recent entry is
+restored; 'trash list' shows the entry IDs of older ones.
+
+Processes and files outside the workspace, such as installed system packages, are
+not restored. Each entry also holds repo.bundle with the agent's refs and uncommitted
+work, taken when it was destroyed.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			manager := newManager()
+			entry, err := manager.Undelete(args[0])
+			if err != nil {
+				errorf("Error undeleting agent: %v\n", err)
+				exit(exitCode(err))
+			}
+			infof("Agent '%s' restored (destroyed %s)\n", entry.AgentID, entry.DeletedAt.Local().Format(time.RFC822))
+		},
+	}
+}
+
+// newTrashCmd builds the trash command that lists and empties destroyed agents
+func newTrashCmd() *cobra.Command {
+	trashCmd := &cobra.Command{
+		Use:   "trash",
+		Short: "List and empty the destroyed agents kept for undelete",
+	}
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List destroyed agents in the trash, newest first",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			entries, err := newManager().ListTrash()
+			if err != nil {
+				errorf("Error listing trash: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				if entries == nil {
+					entries = []*agent.TrashEntry{}
+				}
+				jsonData, err := json.MarshalIndent(entries, "", "  ")
+				if err != nil {
+					errorf("Error marshaling trash to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			if len(entries) == 0 {
+				fmt.Println("Trash is empty")
+				return
+			}
+			fmt.Printf("%-40s %-20s %-17s %-17s %10s %s\n", "ENTRY", "AGENT", "DESTROYED", "EXPIRES", "SIZE", "BUNDLE")
+			for _, entry := range entries {
+				bundle := "no"
+				if entry.Bundle {
+					bundle = "yes"
+				}
+				fmt.Printf("%-40s %-20s %-17s %-17s %10s %s\n", entry.ID, entry.AgentID,
+					entry.DeletedAt.Local().Format("2006-01-02 15:04"), entry.ExpiresAt.Local().Format("2006-01-02 15:04"),
+					formatBytes(entry.Size), bundle)
+			}
+		},
+	}
+	listCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	emptyCmd := &cobra.Command{
+		Use:   "empty",
+		Short: "Permanently remove destroyed agents from the trash",
+		Long: `Permanently remove every destroyed agent from the trash, or with --expired only
+those past trash.retention. Expired entries are also purged whenever an agent is
+destroyed.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			expired, _ := cmd.Flags().GetBool("expired")
+
+			purged, err := newManager().PurgeTrash(!expired)
+			for _, entry := range purged {
+				infof("Removed %s (%s)\n", entry.ID, formatBytes(entry.Size))
+			}
+			if err != nil {
+				errorf("Error emptying trash: %v\n", err)
+				exit(exitCode(err))
+			}
+			if len(purged) == 0 {
+				infof("Nothing to remove\n")
+			}
+		},
+	}
+	emptyCmd.Flags().Bool("expired", false, "Only remove entries past their retention")
+
+	trashCmd.AddCommand(listCmd)
+	trashCmd.AddCommand(emptyCmd)
+	return trashCmd
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// newValidateCmd builds the validate command that checks capsulate.yaml without touching Docker
+func newValidateCmd() *cobra.Command {
+	validateCmd := &cobra.Command{
+		Use:   "validate [config-file]",
+		Short: "Validate capsulate.yaml",
+		Long: `Check a configuration file (capsulate.yaml in the current directory by default)
+against the configuration schema: unknown keys, wrongly typed values, bad durations,
+conflicting settings and secret references that cannot be resolved. Docker is not
+contacted. Exits with code 6 when errors are found; warnings alone exit 0.
+
+Use --schema to print the JSON Schema of the configuration file for editors and CI.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			if printSchema, _ := cmd.Flags().GetBool("schema"); printSchema {
+				jsonData, err := json.MarshalIndent(config.Schema(), "", "  ")
+				if err != nil {
+					errorf("Error marshaling schema to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			var path string
+			if len(args) > 0 {
+				path = args[0]
+			} else {
+				workspaceDir, err := os.Getwd()
+				if err != nil {
+					errorf("Error getting current directory: %v\n", err)
+					exit(exitCode(err))
+				}
+				path = config.ResolvePath(workspaceDir)
+			}
+
+			issues, err := config.ValidateFile(path)
+			if err != nil {
+				errorf("Error validating config: %v\n", err)
+				exit(exitConfig)
+			}
+
+			if format == "json" {
+				if issues == nil {
+					issues = []config.Issue{}
+				}
+				jsonData, err := json.MarshalIndent(issues, "", "  ")
+				if err != nil {
+					errorf("Error marshaling issues to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+			} else {
+				for _, issue := range issues {
+					fmt.Printf("%s: %s\n", path, issue)
+				}
+				if len(issues) == 0 {
+					infof("✅ %s is valid\n", path)
+				}
+			}
+
+			if config.HasErrors(issues) {
+				exit(exitConfig)
+			}
+		},
+	}
+	validateCmd.Flags().String("format", "text", "Output format (text or json)")
+	validateCmd.Flags().Bool("schema", false, "Print the JSON Schema of capsulate.yaml instead of validating")
+
+	return validateCmd
+}
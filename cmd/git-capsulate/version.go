@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/dockerclient"
+	"github.com/your-org/capsulate-repo/pkg/version"
+)
+
+// dockerProbeTimeout bounds how long version waits for the Docker daemon
+const dockerProbeTimeout = 5 * time.Second
+
+// versionInfo is the output of the version command
+type versionInfo struct {
+	Version     string                `json:"version"`
+	Commit      string                `json:"commit,omitempty"`
+	GoVersion   string                `json:"go_version"`
+	Platform    string                `json:"platform"`
+	Docker      *dockerclient.Version `json:"docker,omitempty"`
+	DockerError string                `json:"docker_error,omitempty"`
+	Warnings    []string              `json:"warnings,omitempty"`
+}
+
+// newVersionCmd builds the version command that reports build and Docker versions
+func newVersionCmd() *cobra.Command {
+	versionCmd := &cobra.Command{
+		Use:   "version",
+		Short: "Show version and Docker compatibility",
+		Long: `Show the git-capsulate version, the Git commit and Go version it was built from,
+and, when the Docker daemon is reachable, the daemon version and the negotiated API
+version. Warns when the daemon and the CLI API versions diverge.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString("format")
+
+			info := versionInfo{
+				Version:   version.Version,
+				Commit:    version.GitCommit(),
+				GoVersion: version.GoVersion(),
+				Platform:  version.Platform(),
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), dockerProbeTimeout)
+			defer cancel()
+			if docker, err := dockerclient.GetVersion(ctx); err != nil {
+				info.DockerError = err.Error()
+			} else {
+				info.Docker = docker
+				info.Warnings = docker.CompatibilityWarnings()
+			}
+
+			if format == "json" {
+				jsonData, err := json.MarshalIndent(info, "", "  ")
+				if err != nil {
+					errorf("Error marshaling version to JSON: %v\n", err)
+					exit(exitCode(err))
+				}
+				fmt.Println(string(jsonData))
+				return
+			}
+
+			fmt.Printf("git-capsulate %s\n", info.Version)
+			if info.Commit != "" {
+				fmt.Printf("  Commit:          %s\n", info.Commit)
+			}
+			fmt.Printf("  Go version:      %s\n", info.GoVersion)
+			fmt.Printf("  Platform:        %s\n", info.Platform)
+			fmt.Println("Docker:")
+			if info.Docker == nil {
+				fmt.Printf("  Not connected:   %s\n", info.DockerError)
+				return
+			}
+			fmt.Printf("  Daemon version:  %s (%s/%s)\n", info.Docker.ServerVersion, info.Docker.OS, info.Docker.Arch)
+			fmt.Printf("  API version:     %s (daemon %s, CLI %s)\n",
+				info.Docker.APIVersion, info.Docker.ServerAPIVersion, info.Docker.ClientAPIVersion)
+			for _, warning := range info.Warnings {
+				errorf("⚠️  %s\n", warning)
+			}
+		},
+	}
+	versionCmd.Flags().String("format", "text", "Output format (text or json)")
+
+	return versionCmd
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/your-org/capsulate-repo/pkg/agent"
+)
+
+// newWarmCmd builds the warm command that prepares images, caches and pooled agents
+func newWarmCmd() *cobra.Command {
+	warmCmd := &cobra.Command{
+		Use:   "warm",
+		Short: "Pre-pull images, refresh the clone cache and pre-create pooled agents",
+		Long: `Prepare the host before a scheduled batch of agent work, so the batch does not wait
+on downloads: build the base image, pull the sidecar images of --template and fetch
+the repositories under cache.prefetch into the clone cache.
+
+With --count, agents of the template are pre-created into a pool labeled
+pool=<template> (pool=default without a template) until it holds that many. Batch
+jobs take agents from the pool by label and destroy them when done; the next warm
+tops the pool up again. The agents to create are first checked, together, against
+the disk, memory and CPU the host has left (see capacity in capsulate.yaml):
+
+  git-capsulate warm --template node-dev --count 5 --repo git@github.com:org/app.git
+  git-capsulate list --selector pool=node-dev
+
+Every step is attempted; the command exits 1 when any of them failed.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			template, _ := cmd.Flags().GetString("template")
+			count, _ := cmd.Flags().GetInt("count")
+			repoURL, _ := cmd.Flags().GetString("repo")
+			branch, _ := cmd.Flags().GetString("branch")
+			platform, _ := cmd.Flags().GetString("platform")
+			noPrefetch, _ := cmd.Flags().GetBool("no-prefetch")
+			skipCapacityCheck, _ := cmd.Flags().GetBool("skip-capacity-check")
+			format, _ := cmd.Flags().GetString("format")
+
+			if count < 0 {
+				errorf("Error: --count must not be negative\n")
+				exit(exitUsage)
+			}
+			if count == 0 && (repoURL != "" || branch != "") {
+				errorf("Error: --repo and --branch need --count\n")
+				exit(exitUsage)
+			}
+
+			report, err := newManager().Warm(agent.WarmOptions{
+				Template:          template,
+				Count:             count,
+				RepoURL:           repoURL,
+				Branch:            branch,
+				Platform:          platform,
+				SkipPrefetch:      noPrefetch,
+				SkipCapacityCheck: skipCapacityCheck,
+			})
+			if report == nil {
+				errorf("Error warming up: %v\n", err)
+				exit(exitCode(err))
+			}
+
+			if format == "json" {
+				jsonData, jsonErr := json.MarshalIndent(report, "", "  ")
+				if jsonErr != nil {
+					errorf("Error marshaling warm-up report to JSON: %v\n", jsonErr)
+					exit(exitCode(jsonErr))
+				}
+				fmt.Println(string(jsonData))
+			} else {
+				for _, step := range report.Steps {
+					if step.Error != "" {
+						fmt.Printf("  ✗ %-20s %s\n", step.Name, step.Error)
+						continue
+					}
+					fmt.Printf("  ✓ %-20s %s (%s)\n", step.Name, step.Detail, step.Duration.Round(time.Millisecond))
+				}
+				if report.Pool != "" {
+					infof("Pool '%s' holds %d agents: %s\n", report.Pool, len(report.PoolAgents), strings.Join(report.PoolAgents, ", "))
+				}
+			}
+			if err != nil {
+				errorf("Error warming up: %v\n", err)
+				exit(exitFailure)
+			}
+		},
+	}
+	warmCmd.Flags().String("template", "", "Template whose sidecar images are pulled and whose agents are pooled")
+	warmCmd.Flags().Int("count", 0, "Number of pooled agents to keep pre-created (0 for none)")
+	warmCmd.Flags().StringP("repo", "r", "", "Git repository URL the pooled agents clone")
+	warmCmd.Flags().StringP("branch", "b", "", "Branch the pooled agents check out")
+	warmCmd.Flags().String("platform", "", "Platform of the base image and pooled agents (default: the Docker host's)")
+	warmCmd.Flags().Bool("no-prefetch", false, "Do not fetch cache.prefetch repositories into the clone cache")
+	warmCmd.Flags().Bool("skip-capacity-check", false, "Create the pooled agents even if the host is short of disk, memory or CPU")
+	warmCmd.Flags().String("format", "text", "Output format (text or json)")
+	return warmCmd
+}
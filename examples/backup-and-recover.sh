@@ -0,0 +1,35 @@
+#!/usr/bin/env bash
+# Back up agents, undelete them and bring them back after a reboot
+#
+# Destroyed agents go to the trash and can be undeleted; agents whose containers
+# stopped are recovered; and backups move the whole state to another host.
+#
+# Generated by 'git-capsulate docs examples'; shown by 'git-capsulate help examples backup-and-recover'.
+set -euo pipefail
+
+REPO="${REPO:-https://github.com/your-org/web-app.git}"
+AGENT="${AGENT:-my-feature}"
+
+# Create a new Git isolation container
+git-capsulate create "$AGENT" --repo "$REPO"
+
+# Write a backup archive (default capsulate-backup-<time>.tar.gz, - for stdout)
+git-capsulate backup create capsulate-backup.tar.gz --workspaces
+
+# Destroy a Git isolation container
+git-capsulate destroy "$AGENT" --force
+
+# List destroyed agents in the trash, newest first
+git-capsulate trash list
+
+# Restore a destroyed agent from the trash
+git-capsulate undelete "$AGENT"
+
+# Restart agents whose containers stopped, e.g. after a reboot
+git-capsulate recover
+
+# List agents
+git-capsulate list
+
+# Destroy a Git isolation container
+git-capsulate destroy "$AGENT" --force --no-trash
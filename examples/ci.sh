@@ -0,0 +1,17 @@
+#!/usr/bin/env bash
+# Run a repository's commands in an ephemeral agent, as a CI job
+#
+# A CI job runs its commands in an agent that is always destroyed afterwards, and
+# collects their logs, artifacts and a JUnit report.
+#
+# Generated by 'git-capsulate docs examples'; shown by 'git-capsulate help examples ci'.
+set -euo pipefail
+
+REPO="${REPO:-https://github.com/your-org/web-app.git}"
+BRANCH="${BRANCH:-main}"
+
+# Check capsulate.yaml, which holds the ci section
+git-capsulate validate
+
+# Run commands in an ephemeral agent and always destroy it
+git-capsulate ci exec --repo "$REPO" --branch "$BRANCH" --output-dir capsulate-ci "git log -1 --oneline" "git status"
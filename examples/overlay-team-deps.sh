@@ -0,0 +1,42 @@
+#!/usr/bin/env bash
+# Share a team's dependencies across agents on overlay workspaces
+#
+# Agents of a team share one dependency layer, versioned with snapshots, and
+# overlay workspaces so that each agent only stores what it changes.
+#
+# Generated by 'git-capsulate docs examples'; shown by 'git-capsulate help examples overlay-team-deps'.
+set -euo pipefail
+
+REPO="${REPO:-https://github.com/your-org/web-app.git}"
+TEAM="${TEAM:-frontend}"
+PACKAGE="${PACKAGE:-react}"
+
+# Create a new team
+git-capsulate create-team "$TEAM"
+
+# Add a team dependency
+git-capsulate add-team-dep "$TEAM" "$PACKAGE"
+
+# Snapshot the team layer so agents can be pinned to it
+git-capsulate team-deps freeze "$TEAM" --label baseline
+
+# Create a new Git isolation container
+git-capsulate create "$TEAM-1" --repo "$REPO" --use-overlay --dependency-level team --team-id "$TEAM" --team-snapshot baseline
+
+# Create a new Git isolation container
+git-capsulate create "$TEAM-2" --repo "$REPO" --use-overlay --dependency-level team --team-id "$TEAM" --team-snapshot baseline
+
+# Show overlay filesystem status
+git-capsulate overlay-status "$TEAM-1"
+
+# Run a command in every agent of the team
+git-capsulate exec --team "$TEAM" "git status --short"
+
+# See how little the second agent added
+git-capsulate du
+
+# Destroy a Git isolation container
+git-capsulate destroy "$TEAM-1" --force
+
+# Destroy a Git isolation container
+git-capsulate destroy "$TEAM-2" --force
@@ -0,0 +1,36 @@
+#!/usr/bin/env bash
+# Work on a branch in an agent, review the changes and commit them
+#
+# An agent works on its own branch; its changes are reviewed, with tests and
+# linters run inside it, before they are committed with a drafted message.
+#
+# Generated by 'git-capsulate docs examples'; shown by 'git-capsulate help examples review-and-commit'.
+set -euo pipefail
+
+REPO="${REPO:-https://github.com/your-org/web-app.git}"
+AGENT="${AGENT:-my-feature}"
+BRANCH="${BRANCH:-feature/example}"
+
+# Create a new Git isolation container
+git-capsulate create "$AGENT" --repo "$REPO"
+
+# Create a Git branch in a container
+git-capsulate branch "$AGENT" "$BRANCH" --checkout
+
+# Make a change, as an agent or a person would
+git-capsulate exec "$AGENT" "echo 'Example change' >> NOTES.md"
+
+# Show Git status in a container
+git-capsulate status "$AGENT"
+
+# Generate a review report of an agent's changes
+git-capsulate review "$AGENT" --output review.md
+
+# Draft a commit message for the staged (or all uncommitted) changes
+git-capsulate suggest commit-message "$AGENT"
+
+# Commit changes inside an agent
+git-capsulate commit "$AGENT" --all --suggested
+
+# Destroy the agent, keeping its work in a Git bundle
+git-capsulate destroy "$AGENT" --snapshot-first --force
@@ -0,0 +1,32 @@
+#!/usr/bin/env bash
+# Keep long-lived agents fetched and their repositories compact
+#
+# Schedules run commands in agents on cron expressions, while 'schedule run' is
+# running, typically as a service.
+#
+# Generated by 'git-capsulate docs examples'; shown by 'git-capsulate help examples scheduled-maintenance'.
+set -euo pipefail
+
+REPO="${REPO:-https://github.com/your-org/web-app.git}"
+AGENT="${AGENT:-long-lived}"
+
+# Create a new Git isolation container
+git-capsulate create "$AGENT" --repo "$REPO"
+
+# Schedule a command in an agent
+git-capsulate schedule add "$AGENT" --cron @hourly --name "fetch-$AGENT" -- git fetch --all --prune
+
+# Schedule Git maintenance in an agent
+git-capsulate schedule maintenance "$AGENT" --cron @weekly
+
+# List schedules with their last and next runs
+git-capsulate schedule list --agent "$AGENT"
+
+# Run the schedules that are due once; without --once it keeps running
+git-capsulate schedule run --once
+
+# Show the recent executions of a schedule
+git-capsulate schedule history "fetch-$AGENT"
+
+# Destroy a Git isolation container
+git-capsulate destroy "$AGENT" --force
@@ -0,0 +1,158 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// AdoptOptions configures AdoptContainer
+type AdoptOptions struct {
+	// RepoPath is where the container holds its repository, linked to /workspace/repo
+	// when elsewhere; empty when it is at /workspace/repo or there is none
+	RepoPath string
+	// Labels are the key=value labels to select the agent with
+	Labels map[string]string
+}
+
+// inspectCheckout resolves the host checkout of an agent created with FromDir and
+// records the repository it was cloned from and its branch, when it is a clone
+func (m *Manager) inspectCheckout(config *AgentConfig) error {
+	dir, err := filepath.Abs(config.FromDir)
+	if err != nil {
+		return fmt.Errorf("invalid directory '%s': %w", config.FromDir, err)
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read checkout: %w", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	config.FromDir = dir
+
+	if origin, err := exec.Command("git", "-C", dir, "remote", "get-url", "origin").Output(); err == nil {
+		config.RepoURL = strings.TrimSpace(string(origin))
+	}
+	if branch, err := exec.Command("git", "-C", dir, "symbolic-ref", "--short", "-q", "HEAD").Output(); err == nil {
+		config.Branch = strings.TrimSpace(string(branch))
+	}
+	return nil
+}
+
+// AdoptContainer brings a container built outside git-capsulate under its management
+// as an agent, without recreating it: the container is renamed after the agent and
+// started if stopped, its repository linked to /workspace/repo, and the agent
+// recorded in the state store. Docker cannot label an existing container, so the
+// agent is known by its container name alone. Adopted agents cannot be recreated,
+// since their container is not built from a template.
+func (m *Manager) AdoptContainer(container, agentID string, opts AdoptOptions) (err error) {
+	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return err
+	}
+
+	unlock := m.agentLocks.lock(agentID)
+	defer unlock()
+	release, err := m.store.Lock(agentID, "adopted")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.Adopt", map[string]interface{}{
+		"agent_id":  agentID,
+		"container": container,
+	})
+	defer func() {
+		if err != nil {
+			tracing.RecordError(spanID, err)
+			tracing.EndSpanError(spanID, err.Error())
+			return
+		}
+		tracing.EndSpanSuccess(spanID)
+	}()
+
+	if _, exists, err := m.store.Get(agentID); err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("agent with ID '%s' already exists", agentID)
+	}
+	info, err := m.dockerClient.ContainerInspect(ctx, container)
+	if errdefs.IsNotFound(err) {
+		return fmt.Errorf("container '%s' not found", container)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if owner := info.Config.Labels["capsulate.agent-id"]; owner != "" {
+		return fmt.Errorf("container '%s' already belongs to agent '%s'", container, owner)
+	}
+
+	// Rename the container after the agent, and back if adopting it fails
+	originalName := strings.TrimPrefix(info.Name, "/")
+	name := m.containerName(agentID)
+	if originalName != name {
+		if err := m.dockerClient.ContainerRename(ctx, info.ID, name); err != nil {
+			return fmt.Errorf("failed to rename container to %s: %w", name, err)
+		}
+		defer func() {
+			if err != nil {
+				m.dockerClient.ContainerRename(context.Background(), info.ID, originalName)
+			}
+		}()
+	}
+	if !info.State.Running {
+		if err := m.dockerClient.ContainerStart(ctx, info.ID, types.ContainerStartOptions{}); err != nil {
+			return fmt.Errorf("failed to start container: %w", err)
+		}
+	}
+
+	if opts.RepoPath != "" && opts.RepoPath != repoRoot {
+		if _, err := m.ExecArgs(agentID, "", "test", "-e", repoRoot); err == nil {
+			return fmt.Errorf("the container already has %s; it cannot be linked to %s", repoRoot, opts.RepoPath)
+		}
+		if _, err := m.ExecArgs(agentID, "", "mkdir", "-p", filepath.Dir(repoRoot)); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(repoRoot), err)
+		}
+		if _, err := m.ExecArgs(agentID, "", "ln", "-s", opts.RepoPath, repoRoot); err != nil {
+			return fmt.Errorf("failed to link %s to %s: %w", repoRoot, opts.RepoPath, err)
+		}
+	}
+
+	now := time.Now()
+	st := &state.AgentState{
+		ID:        agentID,
+		CreatedAt: now,
+		Labels:    opts.Labels,
+		Adopted:   true,
+	}
+	if origin, err := m.ExecArgs(agentID, repoRoot, "git", "remote", "get-url", "origin"); err == nil {
+		st.RepoURL = strings.TrimSpace(origin)
+	}
+	if branch, err := m.ExecArgs(agentID, repoRoot, "git", "symbolic-ref", "--short", "-q", "HEAD"); err == nil {
+		st.Branch = strings.TrimSpace(branch)
+	}
+	if err := m.store.Save(st); err != nil {
+		return fmt.Errorf("failed to record agent state: %w", err)
+	}
+	m.recordStartCommit(agentID)
+
+	tracing.AddEvent(spanID, "container_adopted", map[string]interface{}{
+		"container_id": info.ID,
+		"repo_url":     st.RepoURL,
+	})
+	metrics.RecordCount("container_adopted", metrics.ContainerOps, 1, agentID)
+	return nil
+}
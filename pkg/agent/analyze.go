@@ -0,0 +1,269 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Modes recommended by AnalyzeStorage
+const (
+	RecommendOverlay          = "overlay"           // create agents with --use-overlay
+	RecommendCloneCache       = "clone-cache"       // set cache.clone in capsulate.yaml
+	RecommendTeamDependencies = "team-dependencies" // share dependencies at the team level
+)
+
+// AgentStorage is the disk space taken by one agent
+type AgentStorage struct {
+	ID              string `json:"id"`
+	RepoURL         string `json:"repo_url,omitempty"`
+	UseOverlay      bool   `json:"use_overlay"`
+	DependencyLevel string `json:"dependency_level"`
+	// Workspace is the agent's workspace directory, or its overlay upper and work
+	// directories
+	Workspace int64 `json:"workspace_bytes"`
+	// GitDir is the size of the repository's .git directory, when it is on the host
+	GitDir int64 `json:"git_dir_bytes,omitempty"`
+	// Dependencies is the agent's container-level dependency layer
+	Dependencies int64 `json:"dependencies_bytes"`
+	// WritableLayer is the data written to the container's own filesystem layer
+	WritableLayer int64 `json:"writable_layer_bytes"`
+}
+
+// Private returns the space taken by the agent alone, dependencies excluded
+func (a AgentStorage) Private() int64 {
+	return a.Workspace + a.WritableLayer
+}
+
+// StorageRecommendation is a mode that would save space or transfer for some agents
+type StorageRecommendation struct {
+	Mode   string   `json:"mode"`
+	Agents []string `json:"agents"`
+	// Savings is the estimated disk space saved, or data not downloaded again for
+	// the clone cache
+	Savings int64  `json:"savings_bytes"`
+	Reason  string `json:"reason"`
+}
+
+// StorageAnalysis is the outcome of AnalyzeStorage
+type StorageAnalysis struct {
+	Agents []AgentStorage `json:"agents"`
+	// Space shared by all agents: the base image's layers, counted once, the overlay
+	// base, the core and team dependency layers and the clone cache
+	Image       int64 `json:"image_bytes"`
+	OverlayBase int64 `json:"overlay_base_bytes"`
+	SharedDeps  int64 `json:"shared_dependencies_bytes"`
+	CloneCache  int64 `json:"clone_cache_bytes"`
+	// PerAgent is the space taken by the agents themselves
+	PerAgent int64 `json:"per_agent_bytes"`
+	// Duplicated is the part of PerAgent repeating Git objects or dependencies that
+	// another agent of the same repository already holds
+	Duplicated      int64                   `json:"duplicated_bytes"`
+	Recommendations []StorageRecommendation `json:"recommendations"`
+}
+
+// AnalyzeStorage measures how much disk the agents of the current project share
+// through the base image and the shared layers, and how much each one holds on its
+// own. Agents cloning the same repository are compared to recommend the overlay
+// filesystem, the clone cache or team-level dependencies. Estimates only come from
+// measured agents: overlay savings need an overlay agent of the same repository to
+// compare with.
+func (m *Manager) AnalyzeStorage() (*StorageAnalysis, error) {
+	ctx := context.Background()
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.AnalyzeStorage", nil)
+
+	agents, err := m.store.List()
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	analysis := &StorageAnalysis{
+		OverlayBase: dirSize(m.baseRepoPath),
+		SharedDeps:  dirSize(m.coreDepsPath) + dirSize(filepath.Join(m.workspaceDir, ".capsulate", "dependencies", "team")),
+		CloneCache:  dirSize(m.cloneCachePath()),
+	}
+	if image, _, err := m.dockerClient.ImageInspectWithRaw(ctx, m.baseImageName); err == nil {
+		analysis.Image = image.Size
+	}
+
+	for _, st := range agents {
+		usage := AgentStorage{
+			ID:              st.ID,
+			RepoURL:         st.RepoURL,
+			UseOverlay:      st.UseOverlay,
+			DependencyLevel: st.DependencyLevel,
+			Dependencies:    dirSize(filepath.Join(m.containerDepsPath, st.ID)),
+		}
+		if st.UseOverlay {
+			usage.Workspace = dirSize(filepath.Join(m.diffsPath, st.ID)) + dirSize(filepath.Join(m.workPath, st.ID))
+		} else {
+			workspace := filepath.Join(m.dataDir, "workspaces", st.ID)
+			usage.Workspace = dirSize(workspace)
+			usage.GitDir = dirSize(filepath.Join(workspace, "repo", ".git"))
+		}
+		info, _, err := m.dockerClient.ContainerInspectWithRaw(ctx, m.containerName(st.ID), true)
+		if err != nil && !errdefs.IsNotFound(err) {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, fmt.Errorf("failed to inspect container of agent '%s': %w", st.ID, err)
+		}
+		if err == nil && info.SizeRw != nil {
+			usage.WritableLayer = *info.SizeRw
+		}
+		analysis.Agents = append(analysis.Agents, usage)
+		analysis.PerAgent += usage.Private() + usage.Dependencies
+	}
+	sort.Slice(analysis.Agents, func(i, j int) bool {
+		return analysis.Agents[i].Private() > analysis.Agents[j].Private()
+	})
+
+	m.recommendStorage(analysis)
+
+	tracing.AddEvent(spanID, "analyzed", map[string]interface{}{
+		"agents":          len(analysis.Agents),
+		"duplicated":      analysis.Duplicated,
+		"recommendations": len(analysis.Recommendations),
+	})
+	tracing.EndSpanSuccess(spanID)
+	return analysis, nil
+}
+
+// recommendStorage compares the agents of each repository to estimate the duplicated
+// data and the savings of other modes
+func (m *Manager) recommendStorage(analysis *StorageAnalysis) {
+	byRepo := make(map[string][]AgentStorage)
+	var repos []string
+	for _, usage := range analysis.Agents {
+		if usage.RepoURL == "" {
+			continue
+		}
+		if _, ok := byRepo[usage.RepoURL]; !ok {
+			repos = append(repos, usage.RepoURL)
+		}
+		byRepo[usage.RepoURL] = append(byRepo[usage.RepoURL], usage)
+	}
+	sort.Strings(repos)
+
+	for _, repo := range repos {
+		group := byRepo[repo]
+		if len(group) < 2 {
+			continue
+		}
+
+		// Every agent but one holds a copy of the repository's objects
+		var gitDirs []int64
+		var plain, overlay []AgentStorage
+		for _, usage := range group {
+			if usage.UseOverlay {
+				overlay = append(overlay, usage)
+			} else {
+				plain = append(plain, usage)
+				gitDirs = append(gitDirs, usage.GitDir)
+			}
+		}
+		analysis.Duplicated += sumExceptLargest(gitDirs)
+
+		// Overlay agents of the same repository show what an agent takes in that mode
+		if len(plain) > 0 && len(overlay) > 0 {
+			var plainTotal, overlayTotal int64
+			for _, usage := range plain {
+				plainTotal += usage.Private()
+			}
+			for _, usage := range overlay {
+				overlayTotal += usage.Private()
+			}
+			if savings := plainTotal - int64(len(plain))*(overlayTotal/int64(len(overlay))); savings > 0 {
+				analysis.Recommendations = append(analysis.Recommendations, StorageRecommendation{
+					Mode:    RecommendOverlay,
+					Agents:  agentIDs(plain),
+					Savings: savings,
+					Reason: fmt.Sprintf("overlay agents of %s take %s on average, agents without overlay %s",
+						repo, formatSize(overlayTotal/int64(len(overlay))), formatSize(plainTotal/int64(len(plain)))),
+				})
+			}
+		}
+
+		// Without the clone cache, each new agent downloads the whole history again
+		if !m.config.Cache.Clone {
+			history := dirSize(filepath.Join(m.cloneCachePath(), cacheKey(repo)+".git"))
+			for _, size := range gitDirs {
+				if size > history {
+					history = size
+				}
+			}
+			if history > 0 {
+				analysis.Recommendations = append(analysis.Recommendations, StorageRecommendation{
+					Mode:    RecommendCloneCache,
+					Agents:  agentIDs(group),
+					Savings: history * int64(len(group)-1),
+					Reason: fmt.Sprintf("%d agents cloned %s, each downloading %s of history",
+						len(group), repo, formatSize(history)),
+				})
+			}
+		}
+
+		// Agents of the same repository install the same dependencies
+		var deps []int64
+		var depAgents []AgentStorage
+		for _, usage := range group {
+			if usage.DependencyLevel == "container" && usage.Dependencies > 0 {
+				deps = append(deps, usage.Dependencies)
+				depAgents = append(depAgents, usage)
+			}
+		}
+		if savings := sumExceptLargest(deps); savings > 0 {
+			analysis.Duplicated += savings
+			analysis.Recommendations = append(analysis.Recommendations, StorageRecommendation{
+				Mode:    RecommendTeamDependencies,
+				Agents:  agentIDs(depAgents),
+				Savings: savings,
+				Reason: fmt.Sprintf("%d agents of %s install their dependencies at the container level",
+					len(depAgents), repo),
+			})
+		}
+	}
+	sort.SliceStable(analysis.Recommendations, func(i, j int) bool {
+		return analysis.Recommendations[i].Savings > analysis.Recommendations[j].Savings
+	})
+}
+
+// sumExceptLargest returns the total of the sizes but the largest one, i.e. the space
+// taken by copies when one of them would be kept
+func sumExceptLargest(sizes []int64) int64 {
+	var total, largest int64
+	for _, size := range sizes {
+		total += size
+		if size > largest {
+			largest = size
+		}
+	}
+	return total - largest
+}
+
+// agentIDs returns the IDs of the agents
+func agentIDs(usages []AgentStorage) []string {
+	ids := make([]string, len(usages))
+	for i, usage := range usages {
+		ids[i] = usage.ID
+	}
+	return ids
+}
+
+// formatSize formats a size in bytes with a binary unit
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
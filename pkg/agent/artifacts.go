@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/artifacts"
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// recordCheckRun completes the artifact store record of a check run: its result and
+// the declared artifacts, copied out of the agent
+func (m *Manager) recordCheckRun(agentID string, check config.CheckConfig, run *artifacts.Run, result state.CheckResult) error {
+	run.Duration = result.Duration
+	run.ExitCode = result.ExitCode
+	run.Passed = result.Passed
+
+	if err := m.collectArtifacts(agentID, run, check.Artifacts); err != nil {
+		return err
+	}
+	return m.artifacts.Finish(run)
+}
+
+// collectArtifacts copies files and directories, given relative to the agent's working
+// directory, into the files directory of a run. Paths that do not exist are recorded
+// as missing rather than failing the run.
+func (m *Manager) collectArtifacts(agentID string, run *artifacts.Run, paths []string) error {
+	files, missing, err := m.ExportArtifacts(agentID, paths, m.artifacts.FilesPath(run))
+	run.Files = append(run.Files, files...)
+	run.Missing = append(run.Missing, missing...)
+	return err
+}
+
+// ExportArtifacts copies files and directories, given relative to the agent's working
+// directory, into destDir on the host, keeping their relative paths. It returns the
+// files copied and the paths that do not exist in the agent.
+func (m *Manager) ExportArtifacts(agentID string, paths []string, destDir string) ([]artifacts.File, []string, error) {
+	ctx := context.Background()
+	containerName := m.containerName(agentID)
+	var files []artifacts.File
+	var missing []string
+	for _, declared := range paths {
+		cleaned, err := cleanRepoPath(declared)
+		if err != nil || cleaned == "" {
+			return files, missing, fmt.Errorf("invalid artifact path '%s'", declared)
+		}
+
+		reader, _, err := m.dockerClient.CopyFromContainer(ctx, containerName, path.Join(m.repoDir(agentID), cleaned))
+		if errdefs.IsNotFound(err) {
+			missing = append(missing, declared)
+			continue
+		}
+		if err != nil {
+			return files, missing, fmt.Errorf("failed to copy artifact %s: %w", declared, err)
+		}
+		copied, err := extractArtifact(reader, destDir, path.Dir(cleaned))
+		reader.Close()
+		if err != nil {
+			return files, missing, fmt.Errorf("failed to store artifact %s: %w", declared, err)
+		}
+		files = append(files, copied...)
+	}
+	return files, missing, nil
+}
+
+// extractArtifact unpacks the regular files and directories of a tar stream from the
+// Docker archive API into filesDir/relDir. Entries are named after the copied path's
+// base name; links and entries escaping the directory are skipped.
+func extractArtifact(r io.Reader, filesDir, relDir string) ([]artifacts.File, error) {
+	var files []artifacts.File
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Clean(header.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		rel := path.Join(relDir, name)
+		target := filepath.Join(filesDir, filepath.FromSlash(rel))
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return nil, err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&os.ModePerm|0600)
+			if err != nil {
+				return nil, err
+			}
+			size, err := io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, artifacts.File{Path: rel, Size: size})
+		}
+	}
+}
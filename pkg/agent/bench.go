@@ -0,0 +1,188 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Phases measured by Bench
+const (
+	BenchCreate  = "create"  // Create as a whole, clone included
+	BenchClone   = "clone"   // cloning the repository, clone cache update included
+	BenchExec    = "exec"    // running the benchmark command
+	BenchDestroy = "destroy" // Destroy
+)
+
+// benchPhases lists the phases in the order they are reported
+var benchPhases = []string{BenchCreate, BenchClone, BenchExec, BenchDestroy}
+
+// BenchOptions configure Bench
+type BenchOptions struct {
+	RepoURL    string
+	Branch     string
+	Iterations int
+	// Command is run in each agent once created
+	Command string
+	// Overlay and Cache add modes creating agents with --use-overlay and with the
+	// clone cache, and one with both when both are set
+	Overlay bool
+	Cache   bool
+	// Progress, if set, is called after each agent is destroyed
+	Progress func(mode string, iteration int)
+}
+
+// BenchPhase is the latency of a phase over the iterations of a mode
+type BenchPhase struct {
+	Name    string          `json:"name"`
+	Samples []time.Duration `json:"samples_ns"`
+	Min     time.Duration   `json:"min_ns"`
+	Median  time.Duration   `json:"median_ns"`
+	Mean    time.Duration   `json:"mean_ns"`
+	Max     time.Duration   `json:"max_ns"`
+}
+
+// BenchMode is the outcome of the iterations of one mode
+type BenchMode struct {
+	Name    string       `json:"name"`
+	Overlay bool         `json:"use_overlay"`
+	Cache   bool         `json:"cache"`
+	Phases  []BenchPhase `json:"phases"`
+}
+
+// BenchReport is the outcome of Bench
+type BenchReport struct {
+	RepoURL    string      `json:"repo_url"`
+	Iterations int         `json:"iterations"`
+	Command    string      `json:"command"`
+	Modes      []BenchMode `json:"modes"`
+}
+
+// Bench creates, execs in and destroys agents cloning a repository, Iterations times
+// for each mode, and reports the latency of each phase. Modes run one after another
+// so they do not compete for the host. The agents are named bench-<mode>-<pid>-<n>
+// and their directories are removed with them. The clone cache is used only by the
+// cache modes, whatever capsulate.yaml says; their first iteration fills the cache.
+func (m *Manager) Bench(opts BenchOptions) (*BenchReport, error) {
+	if opts.RepoURL == "" {
+		return nil, fmt.Errorf("benchmarking needs a repository")
+	}
+	if opts.Iterations <= 0 {
+		return nil, fmt.Errorf("invalid number of iterations %d", opts.Iterations)
+	}
+
+	_, spanID := tracing.StartSpan(context.Background(), "agent.Bench", map[string]interface{}{
+		"iterations":  opts.Iterations,
+		"use_overlay": opts.Overlay,
+		"cache":       opts.Cache,
+	})
+
+	modes := []BenchMode{{Name: "default"}}
+	if opts.Overlay {
+		modes = append(modes, BenchMode{Name: "overlay", Overlay: true})
+	}
+	if opts.Cache {
+		modes = append(modes, BenchMode{Name: "cache", Cache: true})
+	}
+	if opts.Overlay && opts.Cache {
+		modes = append(modes, BenchMode{Name: "overlay+cache", Overlay: true, Cache: true})
+	}
+
+	cacheClone := m.config.Cache.Clone
+	defer func() { m.config.Cache.Clone = cacheClone }()
+
+	report := &BenchReport{RepoURL: opts.RepoURL, Iterations: opts.Iterations, Command: opts.Command}
+	for _, mode := range modes {
+		m.config.Cache.Clone = mode.Cache
+		samples := make(map[string][]time.Duration)
+		for i := 1; i <= opts.Iterations; i++ {
+			phases, err := m.benchIteration(mode, i, opts)
+			if err != nil {
+				err = fmt.Errorf("mode %s, iteration %d: %w", mode.Name, i, err)
+				tracing.EndSpanError(spanID, err.Error())
+				return report, err
+			}
+			for name, d := range phases {
+				samples[name] = append(samples[name], d)
+			}
+			if opts.Progress != nil {
+				opts.Progress(mode.Name, i)
+			}
+		}
+		for _, name := range benchPhases {
+			mode.Phases = append(mode.Phases, summarizeBench(name, samples[name]))
+		}
+		report.Modes = append(report.Modes, mode)
+	}
+
+	tracing.EndSpanSuccess(spanID)
+	return report, nil
+}
+
+// benchIteration creates, execs in and destroys one agent, returning the duration
+// of each phase. The agent is destroyed even when a phase fails.
+func (m *Manager) benchIteration(mode BenchMode, iteration int, opts BenchOptions) (map[string]time.Duration, error) {
+	agentID := fmt.Sprintf("bench-%s-%d-%d", strings.ReplaceAll(mode.Name, "+", "-"), os.Getpid(), iteration)
+	phases := make(map[string]time.Duration)
+
+	start := time.Now()
+	if err := m.Create(AgentConfig{
+		ID:              agentID,
+		DependencyLevel: "container",
+		UseOverlay:      mode.Overlay,
+		RepoURL:         opts.RepoURL,
+		Branch:          opts.Branch,
+		phases:          phases,
+	}); err != nil {
+		return nil, err
+	}
+	phases[BenchCreate] = time.Since(start)
+
+	var execErr error
+	if opts.Command != "" {
+		start = time.Now()
+		_, execErr = m.Exec(agentID, opts.Command)
+		phases[BenchExec] = time.Since(start)
+	}
+
+	start = time.Now()
+	if err := m.DestroyWithOptions(agentID, DestroyOptions{SkipTrash: true, SkipSummary: true, SkipScrub: true}); err != nil {
+		return nil, err
+	}
+	phases[BenchDestroy] = time.Since(start)
+	for _, dir := range m.agentDirs(agentID) {
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", dir, err)
+		}
+	}
+	return phases, execErr
+}
+
+// summarizeBench computes the statistics of the samples of a phase
+func summarizeBench(name string, samples []time.Duration) BenchPhase {
+	phase := BenchPhase{Name: name, Samples: samples}
+	if len(samples) == 0 {
+		return phase
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	phase.Min = sorted[0]
+	phase.Max = sorted[len(sorted)-1]
+	phase.Mean = total / time.Duration(len(sorted))
+	if n := len(sorted); n%2 == 1 {
+		phase.Median = sorted[n/2]
+	} else {
+		phase.Median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	return phase
+}
@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+)
+
+// uncommittedSHA is the commit git blame reports for lines not committed yet
+const uncommittedSHA = "0000000000000000000000000000000000000000"
+
+// LineRange selects lines of a file, 1-based and inclusive. A zero Start or End
+// leaves that end of the range open; the zero value selects the whole file.
+type LineRange struct {
+	Start int
+	End   int
+}
+
+// BlameLine attributes one line of a file to the commit that last changed it
+type BlameLine struct {
+	Line        int       `json:"line"`
+	Commit      string    `json:"commit"` // all zeros for uncommitted changes
+	Author      string    `json:"author"`
+	AuthorEmail string    `json:"author_email"`
+	Date        time.Time `json:"date"`
+	Summary     string    `json:"summary"`
+	Content     string    `json:"content"`
+}
+
+// Uncommitted reports whether the line has changes that are not committed yet
+func (l BlameLine) Uncommitted() bool {
+	return l.Commit == uncommittedSHA
+}
+
+// Blame attributes the lines of a file, given relative to the repository root, to
+// the commits that last changed them, including uncommitted changes
+func (m *Manager) Blame(agentID, file string, lines LineRange) ([]BlameLine, error) {
+	metrics.StartTimer("git_blame", metrics.GitOps, agentID)
+	defer metrics.StopTimer("git_blame", metrics.GitOps, agentID)
+
+	cleaned, err := cleanRepoPath(file)
+	if err != nil {
+		return nil, err
+	}
+	if cleaned == "" {
+		return nil, fmt.Errorf("path '%s' is not a file", file)
+	}
+	if lines.Start < 0 || lines.End < 0 || (lines.End > 0 && lines.End < lines.Start) {
+		return nil, fmt.Errorf("invalid line range %d-%d", lines.Start, lines.End)
+	}
+
+	args := []string{"git", "blame", "--line-porcelain"}
+	if lines.Start > 0 || lines.End > 0 {
+		start, end := "1", ""
+		if lines.Start > 0 {
+			start = strconv.Itoa(lines.Start)
+		}
+		if lines.End > 0 {
+			end = strconv.Itoa(lines.End)
+		}
+		args = append(args, "-L", start+","+end)
+	}
+	args = append(args, "--", cleaned)
+
+	output, err := m.ExecArgs(agentID, repoRoot, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to blame %s: %w", cleaned, err)
+	}
+	return parseBlame(output), nil
+}
+
+// parseBlame parses `git blame --line-porcelain` output: for every line a header
+// "<sha> <orig-line> <final-line> [<group-size>]", commit fields such as
+// "author-time 1700000000", and the line itself prefixed with a tab
+func parseBlame(output string) []BlameLine {
+	var lines []BlameLine
+	var current BlameLine
+	var authorTime int64
+	inLine := false
+	for _, row := range strings.Split(output, "\n") {
+		if strings.HasPrefix(row, "\t") {
+			current.Content = row[1:]
+			current.Date = time.Unix(authorTime, 0).UTC()
+			lines = append(lines, current)
+			inLine = false
+			continue
+		}
+		if !inLine {
+			fields := strings.Fields(row)
+			if len(fields) < 3 {
+				continue
+			}
+			current = BlameLine{Commit: fields[0]}
+			current.Line, _ = strconv.Atoi(fields[2])
+			authorTime = 0
+			inLine = true
+			continue
+		}
+		key, value, _ := strings.Cut(row, " ")
+		switch key {
+		case "author":
+			current.Author = value
+		case "author-mail":
+			current.AuthorEmail = strings.TrimSuffix(strings.TrimPrefix(value, "<"), ">")
+		case "author-time":
+			authorTime, _ = strconv.ParseInt(value, 10, 64)
+		case "summary":
+			current.Summary = value
+		}
+	}
+	return lines
+}
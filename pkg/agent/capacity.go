@@ -0,0 +1,107 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+)
+
+// ErrInsufficientCapacity is returned (wrapped) when the host has no room for the
+// agents being created
+var ErrInsufficientCapacity = errors.New("insufficient host capacity")
+
+// hostResources are the free resources of the host; a negative value is unknown
+type hostResources struct {
+	diskFree        int64
+	memoryAvailable int64
+	load            float64
+	cpus            int
+}
+
+// SetCapacityWarningHandler sets the function told when agents are created although
+// the host lacks room for them, with capacity.check set to warn
+func (m *Manager) SetCapacityWarningHandler(handler func(error)) {
+	m.capacityWarning = handler
+}
+
+// checkCapacity estimates the disk and memory count agents like agentConfig take and
+// compares them, and the host's load, with what the host has left. Per capacity.check
+// it returns an error wrapping ErrInsufficientCapacity, warns or does nothing.
+// Resources the host does not report are not checked.
+func (m *Manager) checkCapacity(count int, agentConfig AgentConfig) error {
+	mode := m.config.Capacity.Mode()
+	if mode == config.CapacityOff || count <= 0 {
+		return nil
+	}
+	limits := m.config.Capacity.Limits()
+	host := readHostResources(m.dataDir)
+
+	var problems []string
+	if host.diskFree >= 0 {
+		disk := int64(count) * m.agentDiskEstimate(agentConfig)
+		if host.diskFree-disk < int64(limits.MinFreeDisk) {
+			problems = append(problems, fmt.Sprintf("%d agent(s) need about %s of disk, %s is free and %s must stay free",
+				count, formatSize(disk), formatSize(host.diskFree), formatSize(int64(limits.MinFreeDisk))))
+		}
+	}
+	if host.memoryAvailable >= 0 {
+		memory := int64(count) * int64(limits.AgentMemory)
+		if host.memoryAvailable-memory < int64(limits.MinFreeMemory) {
+			problems = append(problems, fmt.Sprintf("%d agent(s) need about %s of memory, %s is available and %s must stay free",
+				count, formatSize(memory), formatSize(host.memoryAvailable), formatSize(int64(limits.MinFreeMemory))))
+		}
+	}
+	if host.load >= 0 && host.cpus > 0 && host.load/float64(host.cpus) > limits.MaxLoad {
+		problems = append(problems, fmt.Sprintf("the load average is %.2f on %d CPUs, above %.2f per CPU",
+			host.load, host.cpus, limits.MaxLoad))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	err := fmt.Errorf("%w: %s", ErrInsufficientCapacity, strings.Join(problems, "; "))
+	if mode == config.CapacityWarn {
+		metrics.RecordCount("capacity_warnings", metrics.ContainerOps, 1, agentConfig.ID)
+		if m.capacityWarning != nil {
+			m.capacityWarning(err)
+		}
+		return nil
+	}
+	metrics.RecordCount("capacity_refusals", metrics.ContainerOps, 1, agentConfig.ID)
+	return err
+}
+
+// agentDiskEstimate returns the disk an agent like agentConfig is expected to take:
+// its changes for an overlay agent, nothing for a mounted checkout, and otherwise the
+// size of another agent's clone of the repository, twice the size of its mirror in
+// the clone cache (history and checkout), or capacity.agent_disk
+func (m *Manager) agentDiskEstimate(agentConfig AgentConfig) int64 {
+	limits := m.config.Capacity.Limits()
+	switch {
+	case agentConfig.UseOverlay:
+		return int64(limits.OverlayDisk)
+	case agentConfig.FromDir != "" || agentConfig.RepoURL == "":
+		return 0
+	}
+
+	if states, err := m.store.List(); err == nil {
+		for _, st := range states {
+			if st.RepoURL != agentConfig.RepoURL || st.UseOverlay || st.SourceDir != "" {
+				continue
+			}
+			if size := dirSize(filepath.Join(m.dataDir, "workspaces", st.ID)); size > 0 {
+				return size
+			}
+		}
+	}
+	for _, candidate := range m.cloneCandidates(agentConfig.RepoURL) {
+		if size := dirSize(filepath.Join(m.cloneCachePath(), cacheKey(candidate.URL)+".git")); size > 0 {
+			return 2 * size
+		}
+	}
+	return int64(limits.AgentDisk)
+}
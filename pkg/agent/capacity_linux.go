@@ -0,0 +1,46 @@
+//go:build linux
+
+package agent
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// readHostResources reads the free disk of the file system holding dir, the memory
+// available to new processes from /proc/meminfo and the 1-minute load average
+func readHostResources(dir string) hostResources {
+	host := hostResources{diskFree: -1, memoryAvailable: -1, load: -1, cpus: runtime.NumCPU()}
+
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fs); err == nil {
+		host.diskFree = int64(fs.Bavail) * int64(fs.Bsize)
+	}
+
+	if file, err := os.Open("/proc/meminfo"); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+				if kb, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+					host.memoryAvailable = kb << 10
+				}
+				break
+			}
+		}
+		file.Close()
+	}
+
+	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			if load, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				host.load = load
+			}
+		}
+	}
+	return host
+}
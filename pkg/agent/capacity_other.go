@@ -0,0 +1,11 @@
+//go:build !linux
+
+package agent
+
+import "runtime"
+
+// readHostResources reports the free disk, memory and load of the host as unknown
+// outside Linux, where the capacity preflight checks nothing
+func readHostResources(dir string) hostResources {
+	return hostResources{diskFree: -1, memoryAvailable: -1, load: -1, cpus: runtime.NumCPU()}
+}
@@ -0,0 +1,193 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/reports"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// timeoutExitCode is the exit code coreutils timeout uses when a command runs too long
+const timeoutExitCode = 124
+
+// RunChecks runs the checks configured in capsulate.yaml inside the agent, in order.
+// When names is non-empty only those checks are run. Results are recorded in the
+// state store and metrics; a check failing does not stop the remaining checks.
+func (m *Manager) RunChecks(agentID string, names []string) ([]state.CheckResult, error) {
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+	if _, exists, err := m.store.Get(agentID); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+
+	checks, err := m.selectChecks(names)
+	if err != nil {
+		return nil, err
+	}
+
+	metrics.StartTimer("run_checks", metrics.CheckOps, agentID)
+	defer metrics.StopTimer("run_checks", metrics.CheckOps, agentID)
+
+	_, spanID := tracing.StartSpan(context.Background(), "agent.RunChecks", map[string]interface{}{
+		"agent_id": agentID,
+		"checks":   len(checks),
+	})
+
+	results := make([]state.CheckResult, 0, len(checks))
+	failed := 0
+	for _, check := range checks {
+		result := m.runCheck(agentID, check)
+		if !result.Passed {
+			failed++
+		}
+		results = append(results, result)
+	}
+
+	err = m.store.Update(agentID, func(st *state.AgentState) error {
+		st.Checks = results
+		return nil
+	})
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return results, fmt.Errorf("failed to record check results: %w", err)
+	}
+
+	if failed > 0 {
+		tracing.EndSpanError(spanID, fmt.Sprintf("%d of %d checks failed", failed, len(checks)))
+	} else {
+		tracing.EndSpanSuccess(spanID)
+	}
+	m.notifyChecks(agentID, results)
+	return results, nil
+}
+
+// selectChecks returns the configured checks matching names, or all checks if names is empty
+func (m *Manager) selectChecks(names []string) ([]config.CheckConfig, error) {
+	if len(m.config.Checks) == 0 {
+		return nil, fmt.Errorf("no checks configured in %s", config.FileName)
+	}
+	if len(names) == 0 {
+		return m.config.Checks, nil
+	}
+
+	var selected []config.CheckConfig
+	for _, name := range names {
+		found := false
+		for _, check := range m.config.Checks {
+			if check.Name == name {
+				selected = append(selected, check)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown check '%s'", name)
+		}
+	}
+	return selected, nil
+}
+
+// runCheck runs a single check and records its metrics
+func (m *Manager) runCheck(agentID string, check config.CheckConfig) state.CheckResult {
+	command := check.Command
+	if check.Timeout > 0 {
+		// Fractional seconds, so that a timeout below a second does not become 0,
+		// which disables it
+		seconds := strconv.FormatFloat(time.Duration(check.Timeout).Seconds(), 'f', -1, 64)
+		command = fmt.Sprintf("timeout %ss bash -c %s", seconds, shellQuote(check.Command))
+	}
+
+	// Keep the complete output, and later the declared artifacts, in the artifact store
+	startedAt := time.Now()
+	var opts ExecOptions
+	run, storeErr := m.artifacts.Begin(agentID, check.Name, check.Command, startedAt)
+	if storeErr == nil {
+		opts.OutputFile = m.artifacts.OutputPath(run)
+	}
+
+	res := m.runRepoCommand(agentID, command, opts)
+	result := state.CheckResult{
+		Name:      check.Name,
+		Command:   check.Command,
+		Passed:    res.Passed,
+		ExitCode:  res.ExitCode,
+		Duration:  res.Duration,
+		StartedAt: startedAt,
+		Output:    res.Output,
+	}
+	if check.Timeout > 0 && res.ExitCode == timeoutExitCode {
+		result.Output += fmt.Sprintf("\n[timed out after %s]\n", time.Duration(check.Timeout))
+	}
+	if len(check.Reports) > 0 {
+		summary, err := m.collectReports(agentID, check.Reports)
+		if err != nil {
+			result.Output += fmt.Sprintf("\n[reports not read: %v]\n", err)
+		}
+		result.Reports = summary
+	}
+
+	if storeErr == nil {
+		storeErr = m.recordCheckRun(agentID, check, run, result)
+		result.ArtifactRun = run.ID
+	}
+	if storeErr != nil {
+		result.Output += fmt.Sprintf("\n[output and artifacts not stored: %v]\n", storeErr)
+	}
+
+	if result.Passed {
+		metrics.RecordCount("check_passed", metrics.CheckOps, 1, agentID)
+	} else {
+		metrics.RecordCount("check_failed", metrics.CheckOps, 1, agentID)
+	}
+	metrics.RecordGauge("check_duration_"+check.Name, metrics.CheckOps, float64(result.Duration.Milliseconds()), "ms", agentID)
+
+	return result
+}
+
+// collectReports reads the report files of a check matching patterns, relative to the
+// agent's working directory, and summarizes them. ** matches any number of directories.
+func (m *Manager) collectReports(agentID string, patterns []string) (*reports.Summary, error) {
+	quoted := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		cleaned, err := cleanRepoPath(pattern)
+		if err != nil {
+			return nil, err
+		}
+		quoted[i] = casePattern(cleaned)
+	}
+	script := `shopt -s globstar nullglob; for f in ` + strings.Join(quoted, " ") +
+		`; do [ -f "$f" ] && printf '%s\0' "$PWD/$f"; done; exit 0`
+	output, err := m.ExecArgs(agentID, m.repoDir(agentID), "bash", "-c", script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find report files: %w", err)
+	}
+
+	summary := &reports.Summary{Files: []string{}}
+	seen := make(map[string]bool)
+	for _, file := range strings.Split(output, "\x00") {
+		file = strings.TrimPrefix(path.Clean(file), repoRoot+"/")
+		if file == "" || file == "." || seen[file] {
+			continue
+		}
+		seen[file] = true
+		data, err := m.ReadFile(agentID, file)
+		if err != nil {
+			summary.Files = append(summary.Files, file)
+			summary.Errors = append(summary.Errors, fmt.Sprintf("%s: %v", file, err))
+			continue
+		}
+		summary.Add(file, data)
+	}
+	return summary, nil
+}
@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+)
+
+// cloneCacheMount is where the clone cache is mounted in agents when cache.clone is set
+const cloneCacheMount = "/var/cache/capsulate/repos"
+
+// cacheRepoScript updates the bare mirror of a repository in the clone cache, or
+// creates it in a temporary directory renamed into place so that concurrent agents
+// never clone from a half-written mirror. Branches given after the mirror limit the
+// update to them. It prints "hit" or "miss", and "fetched" once the mirror is up to
+// date.
+const cacheRepoScript = `url=$1 mirror=$2
+shift 2
+if [ -d "$mirror" ]; then
+	echo hit
+	for branch in "$@"; do
+		set -- "$@" "+refs/heads/$branch:refs/heads/$branch"
+		shift
+	done
+	git -C "$mirror" -c protocol.ext.allow=never fetch --prune --quiet origin "$@" && echo fetched
+	exit 0
+fi
+tmp=$(mktemp -d "$mirror.tmp.XXXXXX") || exit 1
+if ! git -c protocol.ext.allow=never clone --mirror --quiet -- "$url" "$tmp"; then
+	rm -rf "$tmp"
+	exit 1
+fi
+mv -T "$tmp" "$mirror" 2>/dev/null || rm -rf "$tmp"
+echo miss
+echo fetched
+`
+
+// CacheEntry is a repository mirror in the clone cache
+type CacheEntry struct {
+	Key       string    `json:"key"`
+	URL       string    `json:"url"`
+	CreatedAt time.Time `json:"created_at"`
+	LastUsed  time.Time `json:"last_used"`  // last clone served from the mirror
+	LastFetch time.Time `json:"last_fetch"` // last successful fetch from the remote
+	Size      int64     `json:"size_bytes"` // measured when listed, not recorded
+}
+
+// cloneCachePath returns the host directory of the clone cache, shared by all projects
+func (m *Manager) cloneCachePath() string {
+	return filepath.Join(m.workspaceDir, ".capsulate", "cache", "repos")
+}
+
+// cacheKey names the mirror of a repository URL in the clone cache
+func cacheKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:8])
+}
+
+// cloneFromCache brings the mirror of an agent's repository up to date in the clone
+// cache, creating it on first use, and returns its path in the container for
+// git clone --reference. Hits and misses are recorded in the cache stats.
+func (m *Manager) cloneFromCache(agentID, url string) (string, error) {
+	key := cacheKey(url)
+	mirror := cloneCacheMount + "/" + key + ".git"
+	output, err := m.ExecArgs(agentID, "", "bash", "-c", cacheRepoScript, "cache", url, mirror)
+	if err != nil {
+		if hostKeyErr := hostKeyError(url, output); hostKeyErr != nil {
+			return "", hostKeyErr
+		}
+		return "", fmt.Errorf("failed to update clone cache: %w", err)
+	}
+
+	entry, err := m.readCacheEntry(key)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now().UTC()
+	if entry == nil {
+		entry = &CacheEntry{Key: key, URL: url, CreatedAt: now}
+	}
+	entry.LastUsed = now
+	if strings.Contains(output, "fetched") {
+		entry.LastFetch = now
+	}
+	if err := m.writeCacheEntry(entry); err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(output, "hit") {
+		metrics.RecordCacheHit(metrics.CloneCache, agentID, dirSize(filepath.Join(m.cloneCachePath(), key+".git")), 0)
+	} else {
+		metrics.RecordCacheMiss(metrics.CloneCache, agentID)
+	}
+	return mirror, nil
+}
+
+// CloneCache lists the mirrors in the clone cache with their size, least recently
+// used first
+func (m *Manager) CloneCache() ([]CacheEntry, error) {
+	dir := m.cloneCachePath()
+	files, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clone cache: %w", err)
+	}
+
+	var entries []CacheEntry
+	for _, file := range files {
+		if !file.IsDir() || !strings.HasSuffix(file.Name(), ".git") {
+			continue
+		}
+		key := strings.TrimSuffix(file.Name(), ".git")
+		entry, err := m.readCacheEntry(key)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			// A mirror without its index, e.g. from an interrupted clone, counts as
+			// unused since it was last modified
+			info, err := file.Info()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read clone cache: %w", err)
+			}
+			entry = &CacheEntry{Key: key, CreatedAt: info.ModTime(), LastUsed: info.ModTime()}
+		}
+		entry.Size = dirSize(filepath.Join(dir, file.Name()))
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.Before(entries[j].LastUsed) })
+	return entries, nil
+}
+
+// removeCacheEntry deletes a mirror and its index from the clone cache
+func (m *Manager) removeCacheEntry(key string) error {
+	dir := m.cloneCachePath()
+	if err := os.RemoveAll(filepath.Join(dir, key+".git")); err != nil {
+		return fmt.Errorf("failed to remove cached repository %s: %w", key, err)
+	}
+	if err := os.Remove(filepath.Join(dir, key+".json")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cached repository %s: %w", key, err)
+	}
+	return nil
+}
+
+// readCacheEntry reads the index of a mirror, nil when it has none
+func (m *Manager) readCacheEntry(key string) (*CacheEntry, error) {
+	data, err := os.ReadFile(filepath.Join(m.cloneCachePath(), key+".json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clone cache index: %w", err)
+	}
+	var entry CacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse clone cache index %s: %w", key, err)
+	}
+	return &entry, nil
+}
+
+// writeCacheEntry records the index of a mirror atomically
+func (m *Manager) writeCacheEntry(entry *CacheEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal clone cache index: %w", err)
+	}
+	path := filepath.Join(m.cloneCachePath(), entry.Key+".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write clone cache index: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write clone cache index: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+	"github.com/your-org/capsulate-repo/pkg/version"
+)
+
+// Provenance trailer keys appended to agent commits
+const (
+	TrailerAgent    = "Capsulate-Agent"
+	TrailerTemplate = "Capsulate-Template"
+	TrailerVersion  = "Capsulate-Version"
+	TrailerTraceID  = "Capsulate-Trace-Id"
+)
+
+// CommitOptions configures a commit made through the Manager
+type CommitOptions struct {
+	Message string
+	// All stages modified and deleted tracked files before committing (git commit -a)
+	All bool
+	// Paths are staged with git add before committing
+	Paths []string
+	// Provenance overrides the provenance.enabled setting from capsulate.yaml when set
+	Provenance *bool
+	// Suggested takes the message from the agent's latest suggested commit message
+	// when Message is empty; the suggestion is used up by the commit
+	Suggested bool
+}
+
+// CommitResult describes a commit created by an agent
+type CommitResult struct {
+	SHA      string            `json:"sha"`
+	Branch   string            `json:"branch"`
+	Trailers map[string]string `json:"trailers,omitempty"`
+}
+
+// Commit records the staged changes of an agent's repository. When provenance is
+// enabled, structured trailers identify the agent, its template, the capsulate
+// version and the trace of the run that produced the commit.
+func (m *Manager) Commit(agentID string, opts CommitOptions) (*CommitResult, error) {
+	metrics.StartTimer("git_commit", metrics.GitOps, agentID)
+	defer metrics.StopTimer("git_commit", metrics.GitOps, agentID)
+
+	ctx, spanID := tracing.StartSpan(context.Background(), "agent.Commit", map[string]interface{}{
+		"agent_id": agentID,
+	})
+
+	if opts.Suggested && strings.TrimSpace(opts.Message) == "" {
+		suggestion, err := m.Suggestion(agentID, SuggestCommitMessage)
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, err
+		}
+		opts.Message = suggestion.Text
+	}
+
+	if strings.TrimSpace(opts.Message) == "" {
+		err := fmt.Errorf("commit message must not be empty")
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	// Enforce the protected branch policy
+	if err := m.checkGitPolicy(agentID, []string{"commit"}); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	if len(opts.Paths) > 0 {
+		addArgs := append([]string{"git", "add", "--"}, opts.Paths...)
+		if _, err := m.ExecArgs(agentID, "/workspace/repo", addArgs...); err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, fmt.Errorf("failed to stage files: %w", err)
+		}
+	}
+
+	provenance := m.config.Provenance.Enabled
+	if opts.Provenance != nil {
+		provenance = *opts.Provenance
+	}
+
+	args := []string{"git", "commit", "-m", opts.Message}
+	if opts.All {
+		args = append(args, "-a")
+	}
+	if m.config.Provenance.Sign {
+		args = append(args, "-S")
+	}
+
+	var trailers map[string]string
+	if provenance {
+		trailers = m.provenanceTrailers(ctx, agentID)
+		for _, key := range []string{TrailerAgent, TrailerTemplate, TrailerVersion, TrailerTraceID} {
+			if value, ok := trailers[key]; ok {
+				args = append(args, "--trailer", key+": "+value)
+			}
+		}
+	}
+
+	if output, err := m.ExecArgs(agentID, "/workspace/repo", args...); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, fmt.Errorf("failed to commit: %w\n%s", err, strings.TrimSpace(output))
+	}
+	metrics.RecordCount("git_commit", metrics.GitOps, 1, agentID)
+
+	// A suggested message describes the changes just committed
+	if opts.Suggested {
+		m.store.Update(agentID, func(st *state.AgentState) error {
+			delete(st.Suggestions, SuggestCommitMessage)
+			return nil
+		})
+	}
+
+	sha, err := m.Exec(agentID, "cd /workspace/repo && git rev-parse HEAD")
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, fmt.Errorf("failed to resolve commit: %w", err)
+	}
+	branch, _ := m.Exec(agentID, "cd /workspace/repo && git branch --show-current")
+
+	tracing.EndSpanSuccess(spanID)
+	return &CommitResult{
+		SHA:      strings.TrimSpace(sha),
+		Branch:   strings.TrimSpace(branch),
+		Trailers: trailers,
+	}, nil
+}
+
+// provenanceTrailers collects the trailer values for a commit made in the given span context
+func (m *Manager) provenanceTrailers(ctx context.Context, agentID string) map[string]string {
+	trailers := map[string]string{
+		TrailerAgent:   agentID,
+		TrailerVersion: version.Version,
+	}
+	if st, exists, err := m.store.Get(agentID); err == nil && exists && st.Template != "" {
+		trailers[TrailerTemplate] = st.Template
+	}
+	if traceID := tracing.TraceIDFromContext(ctx); traceID != "" {
+		trailers[TrailerTraceID] = traceID
+	}
+	return trailers
+}
@@ -0,0 +1,411 @@
+package agent
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/scrub"
+	"github.com/your-org/capsulate-repo/pkg/secrets"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// exportArchive is where the agent's repository is archived in its container while
+// an image is committed
+const exportArchive = "/tmp/capsulate-export.tar"
+
+// scrubHomeCredentials removes the registry credential files rendered by
+// registryCredentials from the home directories of an exported image
+const scrubHomeCredentials = `for home in /root /home/*; do
+  rm -f "$home/.npmrc" "$home/.netrc" "$home/.config/pip/pip.conf"
+done
+rm -f ` + exportArchive + `
+`
+
+// scrubExportScript returns the script run in the export container before the image
+// is flattened: it removes the registry credentials of the home directories and, like
+// Scrub, the credential files of the repository that are not committed to it, and
+// strips the auth entries of its .npmrc files
+func scrubExportScript() string {
+	var names []string
+	for _, name := range scrub.CredentialNames() {
+		if len(names) > 0 {
+			names = append(names, "-o")
+		}
+		names = append(names, "-name", "'"+name+"'")
+	}
+	return scrubHomeCredentials + `[ -d ` + repoRoot + ` ] || exit 0
+cd ` + repoRoot + `
+find . -name .git -prune -o -type f \( ` + strings.Join(names, " ") + ` \) -print | while IFS= read -r file; do
+  if git ls-files --error-unmatch -- "$file" >/dev/null 2>&1; then
+    continue
+  fi
+  case "$(basename "$file")" in
+  ` + scrub.Npmrc + `) sed -i -E '/(^[[:space:]]*|:)(_authToken|_auth|_password)[[:space:]]*=/d' "$file" ;;
+  *) rm -f "$file" ;;
+  esac
+done
+`
+}
+
+// CommitImageOptions configures CommitImage
+type CommitImageOptions struct {
+	// Tag names the image, e.g. myorg/agent-result:run42
+	Tag string
+	// ExcludeWorkspace leaves the repository out; the image holds only the container's
+	// own files, such as installed packages
+	ExcludeWorkspace bool
+	// Push pushes the image to its registry after committing it
+	Push bool
+}
+
+// CommitImageResult describes an image committed from an agent
+type CommitImageResult struct {
+	AgentID   string `json:"agent_id"`
+	Tag       string `json:"tag"`
+	ImageID   string `json:"image_id"`
+	Workspace bool   `json:"workspace"`
+	Pushed    bool   `json:"pushed,omitempty"`
+	// Digest is the manifest digest reported by the registry when pushed
+	Digest string `json:"digest,omitempty"`
+}
+
+// CommitImage commits an agent's container to an image, so that a prepared
+// environment can be reused or archived. The workspace is a bind mount that docker
+// commit leaves out; unless excluded, the repository is copied to /workspace/repo of
+// the image. Registry credentials injected into the agent, and credential files of
+// the repository, are removed and the image flattened into a single layer, so that
+// no layer keeps them. The capsulate.agent-id label is blanked so that containers of
+// the image are not taken for agents. The agent keeps running, paused while its
+// container is committed.
+func (m *Manager) CommitImage(agentID string, opts CommitImageOptions) (result *CommitImageResult, err error) {
+	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+	if opts.Tag == "" {
+		return nil, fmt.Errorf("an image tag is required")
+	}
+
+	unlock := m.agentLocks.lock(agentID)
+	defer unlock()
+	release, err := m.store.Lock(agentID, "committed to an image")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	metrics.StartTimer("commit_image", metrics.ContainerOps, agentID)
+	defer metrics.StopTimer("commit_image", metrics.ContainerOps, agentID)
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.CommitImage", map[string]interface{}{
+		"agent_id":  agentID,
+		"tag":       opts.Tag,
+		"workspace": !opts.ExcludeWorkspace,
+		"push":      opts.Push,
+	})
+	defer func() {
+		if err != nil {
+			tracing.RecordError(spanID, err)
+			tracing.EndSpanError(spanID, err.Error())
+			return
+		}
+		tracing.EndSpanSuccess(spanID)
+	}()
+
+	st, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+	info, err := m.dockerClient.ContainerInspect(ctx, m.containerName(agentID))
+	if err != nil {
+		return nil, agentError(agentID, fmt.Errorf("failed to inspect container: %w", err))
+	}
+	if !info.State.Running {
+		return nil, fmt.Errorf("agent '%s' is not running", agentID)
+	}
+
+	// Archive the repository first, so that it is taken at the same time as the
+	// container's files; the archive itself is scrubbed from the image
+	if !opts.ExcludeWorkspace {
+		parent := "/workspace"
+		if st.UseOverlay {
+			parent = "/workspace/merged"
+		}
+		if _, err := m.ExecArgs(agentID, "/", "tar", "-C", parent, "-cf", exportArchive, "repo"); err != nil {
+			return nil, fmt.Errorf("failed to archive the repository: %w", err)
+		}
+		defer m.ExecArgs(agentID, "/", "rm", "-f", exportArchive)
+	}
+
+	commit, err := m.dockerClient.ContainerCommit(ctx, info.ID, types.ContainerCommitOptions{Pause: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit container: %w", err)
+	}
+	// The commit still holds the credentials and the archive in its layers, so it is
+	// only an intermediate image: the result is flattened from it below
+	defer m.dockerClient.ImageRemove(context.Background(), commit.ID, types.ImageRemoveOptions{Force: true, PruneChildren: true})
+	tracing.AddEvent(spanID, "container_committed", map[string]interface{}{"image": commit.ID})
+	committed, _, err := m.dockerClient.ImageInspectWithRaw(ctx, commit.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect committed container: %w", err)
+	}
+
+	// Finish the image in a throwaway container of the commit, which receives the
+	// repository and removes the credentials from it and the home directories
+	finish, err := m.dockerClient.ContainerCreate(ctx, &container.Config{
+		Image:      commit.ID,
+		User:       "0",
+		Entrypoint: []string{"sh", "-c"},
+		Cmd:        []string{scrubExportScript()},
+	}, nil, nil, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export container: %w", err)
+	}
+	defer m.dockerClient.ContainerRemove(context.Background(), finish.ID, types.ContainerRemoveOptions{Force: true})
+	if !opts.ExcludeWorkspace {
+		if err := m.copyExportArchive(ctx, agentID, finish.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.dockerClient.ContainerStart(ctx, finish.ID, types.ContainerStartOptions{}); err != nil {
+		return nil, fmt.Errorf("failed to start export container: %w", err)
+	}
+	statusCh, errCh := m.dockerClient.ContainerWait(ctx, finish.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return nil, fmt.Errorf("failed to wait for export container: %w", err)
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return nil, fmt.Errorf("failed to remove credentials from the image (exit code %d)", status.StatusCode)
+		}
+	}
+
+	// Flatten the scrubbed file system into a single layer, so that no lower layer
+	// keeps the credentials removed above
+	changes, err := exportChanges(agentID, info.Config)
+	if err != nil {
+		return nil, err
+	}
+	imageID, err := m.flattenContainer(ctx, finish.ID, opts.Tag, types.ImageImportOptions{
+		Message:  fmt.Sprintf("Committed from git-capsulate agent %s", agentID),
+		Changes:  changes,
+		Platform: committed.Os + "/" + committed.Architecture,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result = &CommitImageResult{AgentID: agentID, Tag: opts.Tag, ImageID: imageID, Workspace: !opts.ExcludeWorkspace}
+	metrics.RecordCount("image_committed", metrics.ContainerOps, 1, agentID)
+
+	if opts.Push {
+		digest, err := m.pushImage(ctx, opts.Tag)
+		if err != nil {
+			return result, err
+		}
+		result.Pushed, result.Digest = true, digest
+		tracing.AddEvent(spanID, "image_pushed", map[string]interface{}{"digest": digest})
+	}
+	return result, nil
+}
+
+// copyExportArchive extracts the repository archived in an agent's container into
+// /workspace of the export container
+func (m *Manager) copyExportArchive(ctx context.Context, agentID, exportID string) error {
+	reader, _, err := m.dockerClient.CopyFromContainer(ctx, m.containerName(agentID), exportArchive)
+	if err != nil {
+		return fmt.Errorf("failed to copy the repository archive: %w", err)
+	}
+	defer reader.Close()
+	// CopyFromContainer wraps the archive in a tar stream of its own
+	tr := tar.NewReader(reader)
+	if _, err := tr.Next(); err != nil {
+		return fmt.Errorf("failed to read the repository archive: %w", err)
+	}
+	if err := m.dockerClient.CopyToContainer(ctx, exportID, "/workspace", tr, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy the repository into the image: %w", err)
+	}
+	return nil
+}
+
+// exportChanges carries the configuration of the agent container over to the
+// flattened image, which inherits none: environment, working directory, exposed
+// ports, labels, entrypoint, command and user. The labels marking agents and base
+// images are blanked.
+func exportChanges(agentID string, config *container.Config) ([]string, error) {
+	var changes []string
+	for _, env := range config.Env {
+		key, value, _ := strings.Cut(env, "=")
+		changes = append(changes, fmt.Sprintf("ENV %s=%s", key, strconv.Quote(value)))
+	}
+	if config.WorkingDir != "" {
+		changes = append(changes, "WORKDIR "+config.WorkingDir)
+	}
+	ports := make([]string, 0, len(config.ExposedPorts))
+	for port := range config.ExposedPorts {
+		ports = append(ports, string(port))
+	}
+	sort.Strings(ports)
+	for _, port := range ports {
+		changes = append(changes, "EXPOSE "+port)
+	}
+	if config.StopSignal != "" {
+		changes = append(changes, "STOPSIGNAL "+config.StopSignal)
+	}
+	keys := make([]string, 0, len(config.Labels))
+	for key := range config.Labels {
+		if key != "capsulate.agent-id" && key != ImageLabel {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		changes = append(changes, fmt.Sprintf("LABEL %s=%s", strconv.Quote(key), strconv.Quote(config.Labels[key])))
+	}
+
+	entrypoint, err := json.Marshal(append([]string{}, config.Entrypoint...))
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := json.Marshal(append([]string{}, config.Cmd...))
+	if err != nil {
+		return nil, err
+	}
+	user := config.User
+	if user == "" {
+		user = "0"
+	}
+	return append(changes,
+		"ENTRYPOINT "+string(entrypoint),
+		"CMD "+string(cmd),
+		"USER "+user,
+		`LABEL capsulate.agent-id=""`,
+		fmt.Sprintf("LABEL %s=export", ImageLabel),
+		fmt.Sprintf("LABEL capsulate.exported-from=%q", agentID),
+	), nil
+}
+
+// flattenContainer imports the file system of a container as a single-layer image
+// tagged tag, and returns its ID
+func (m *Manager) flattenContainer(ctx context.Context, containerID, tag string, opts types.ImageImportOptions) (string, error) {
+	export, err := m.dockerClient.ContainerExport(ctx, containerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to export container: %w", err)
+	}
+	defer export.Close()
+
+	out, err := m.dockerClient.ImageImport(ctx, types.ImageImportSource{Source: export, SourceName: "-"}, tag, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to import image %s: %w", tag, err)
+	}
+	defer out.Close()
+	decoder := json.NewDecoder(out)
+	for {
+		var message jsonmessage.JSONMessage
+		if err := decoder.Decode(&message); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("failed to read import progress of %s: %w", tag, err)
+		}
+		if message.Error != nil {
+			return "", fmt.Errorf("failed to import image %s: %s", tag, message.Error.Message)
+		}
+	}
+
+	image, _, err := m.dockerClient.ImageInspectWithRaw(ctx, tag)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", tag, err)
+	}
+	return image.ID, nil
+}
+
+// pushImage pushes an image with the credentials of the docker registry configured
+// for its host, if any, and returns the digest the registry reported
+func (m *Manager) pushImage(ctx context.Context, tag string) (string, error) {
+	auth, err := m.pushAuth(tag)
+	if err != nil {
+		return "", err
+	}
+	out, err := m.dockerClient.ImagePush(ctx, tag, types.ImagePushOptions{RegistryAuth: auth})
+	if err != nil {
+		return "", fmt.Errorf("failed to push %s: %w", tag, err)
+	}
+	defer out.Close()
+
+	var digest string
+	decoder := json.NewDecoder(out)
+	for {
+		var message jsonmessage.JSONMessage
+		if err := decoder.Decode(&message); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("failed to read push progress of %s: %w", tag, err)
+		}
+		if message.Error != nil {
+			return "", fmt.Errorf("failed to push %s: %s", tag, message.Error.Message)
+		}
+		if message.Aux != nil {
+			var aux struct {
+				Digest string `json:"Digest"`
+			}
+			if json.Unmarshal(*message.Aux, &aux) == nil && aux.Digest != "" {
+				digest = aux.Digest
+			}
+		}
+	}
+	return digest, nil
+}
+
+// pushAuth encodes the credentials of the docker registry configured for the host of
+// an image reference; images of unconfigured registries are pushed anonymously
+func (m *Manager) pushAuth(tag string) (string, error) {
+	host := imageRegistryHost(tag)
+	for _, reg := range m.config.Registries {
+		if reg.Provider != "docker" {
+			continue
+		}
+		parsed, err := url.Parse(reg.URL)
+		if err != nil || parsed.Host == "" || imageRegistryHost(parsed.Host+"/") != host {
+			continue
+		}
+		token, err := secrets.Resolve(reg.Token)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve credentials for registry %s: %w", reg.URL, err)
+		}
+		return registry.EncodeAuthConfig(registry.AuthConfig{
+			Username:      reg.Username,
+			Password:      token,
+			ServerAddress: parsed.Host,
+		})
+	}
+	return registry.EncodeAuthConfig(registry.AuthConfig{})
+}
+
+// imageRegistryHost returns the registry host of an image reference, with Docker
+// Hub's aliases folded into docker.io
+func imageRegistryHost(reference string) string {
+	host, _, found := strings.Cut(reference, "/")
+	if !found || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		return "docker.io"
+	}
+	switch host {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return host
+}
@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// ConflictSide names one version of a conflicted file
+type ConflictSide string
+
+// Versions of a conflicted file, matching the index stages Git records for it
+const (
+	SideBase   ConflictSide = "base"   // the common ancestor (stage 1)
+	SideOurs   ConflictSide = "ours"   // the checked-out branch (stage 2)
+	SideTheirs ConflictSide = "theirs" // the branch being merged in (stage 3)
+)
+
+// conflictStages maps each side to its index stage
+var conflictStages = map[ConflictSide]string{SideBase: "1", SideOurs: "2", SideTheirs: "3"}
+
+// Conflict is a file left unmerged by a merge, rebase, cherry-pick or stash pop
+type Conflict struct {
+	Path string `json:"path"` // relative to the repository root
+	// Type is Git's short status of the conflict: UU (both modified), AA (both added),
+	// DU (deleted by us), UD (deleted by them), AU (added by us), UA (added by them)
+	// or DD (both deleted)
+	Type      string `json:"type"`
+	HasBase   bool   `json:"has_base"`
+	HasOurs   bool   `json:"has_ours"`
+	HasTheirs bool   `json:"has_theirs"`
+
+	// mode is the file mode of the ours (or theirs) version, used for written resolutions
+	mode string
+}
+
+// Resolution says how ResolveConflict resolves a file: by taking SideOurs or
+// SideTheirs, or when Side is empty, by writing Content as the merged file
+type Resolution struct {
+	Side    ConflictSide
+	Content string
+}
+
+// GetConflicts lists the conflicted files of an agent's repository, sorted by path
+func (m *Manager) GetConflicts(agentID string) ([]Conflict, error) {
+	metrics.StartTimer("get_conflicts", metrics.GitOps, agentID)
+	defer metrics.StopTimer("get_conflicts", metrics.GitOps, agentID)
+
+	output, err := m.ExecArgs(agentID, repoRoot, "git", "ls-files", "--unmerged", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conflicts: %w", err)
+	}
+	return parseUnmerged(output), nil
+}
+
+// parseUnmerged parses `git ls-files --unmerged -z` output, one entry per stage:
+// "<mode> <object> <stage>\t<path>\x00"
+func parseUnmerged(output string) []Conflict {
+	var conflicts []Conflict
+	for _, entry := range strings.Split(output, "\x00") {
+		meta, file, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 {
+			continue
+		}
+		if len(conflicts) == 0 || conflicts[len(conflicts)-1].Path != file {
+			conflicts = append(conflicts, Conflict{Path: file})
+		}
+		c := &conflicts[len(conflicts)-1]
+		switch fields[2] {
+		case "1":
+			c.HasBase = true
+		case "2":
+			c.HasOurs = true
+			c.mode = fields[0]
+		case "3":
+			c.HasTheirs = true
+			if c.mode == "" {
+				c.mode = fields[0]
+			}
+		}
+	}
+
+	for i := range conflicts {
+		c := &conflicts[i]
+		switch {
+		case c.HasOurs && c.HasTheirs && c.HasBase:
+			c.Type = "UU"
+		case c.HasOurs && c.HasTheirs:
+			c.Type = "AA"
+		case c.HasBase && c.HasTheirs:
+			c.Type = "DU"
+		case c.HasBase && c.HasOurs:
+			c.Type = "UD"
+		case c.HasOurs:
+			c.Type = "AU"
+		case c.HasTheirs:
+			c.Type = "UA"
+		default:
+			c.Type = "DD"
+		}
+	}
+	return conflicts
+}
+
+// ConflictContent returns one version of a conflicted file
+func (m *Manager) ConflictContent(agentID, file string, side ConflictSide) (string, error) {
+	stage, ok := conflictStages[side]
+	if !ok {
+		return "", fmt.Errorf("unknown conflict side '%s' (use base, ours or theirs)", side)
+	}
+	conflict, err := m.findConflict(agentID, file)
+	if err != nil {
+		return "", err
+	}
+	if !conflict.has(side) {
+		return "", fmt.Errorf("%s has no %s version (%s conflict)", conflict.Path, side, conflict.Type)
+	}
+
+	output, err := m.ExecArgs(agentID, repoRoot, "git", "show", ":"+stage+":"+conflict.Path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s version of %s: %w", side, conflict.Path, err)
+	}
+	return output, nil
+}
+
+// ResolveConflict resolves a conflicted file and marks it resolved in the index. Taking
+// a side that deleted the file removes it. The merge itself is not committed.
+func (m *Manager) ResolveConflict(agentID, file string, resolution Resolution) error {
+	metrics.StartTimer("resolve_conflict", metrics.GitOps, agentID)
+	defer metrics.StopTimer("resolve_conflict", metrics.GitOps, agentID)
+
+	_, spanID := tracing.StartSpan(context.Background(), "agent.ResolveConflict", map[string]interface{}{
+		"agent_id": agentID,
+		"path":     file,
+		"side":     string(resolution.Side),
+	})
+
+	err := m.resolveConflict(agentID, file, resolution)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return err
+	}
+	tracing.EndSpanSuccess(spanID)
+	return nil
+}
+
+// resolveConflict does the work of ResolveConflict
+func (m *Manager) resolveConflict(agentID, file string, resolution Resolution) error {
+	if resolution.Side != "" && resolution.Side != SideOurs && resolution.Side != SideTheirs {
+		return fmt.Errorf("cannot resolve with side '%s' (use ours, theirs or content)", resolution.Side)
+	}
+	conflict, err := m.findConflict(agentID, file)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case resolution.Side == "":
+		mode := os.FileMode(0644)
+		if conflict.mode == "100755" {
+			mode = 0755
+		}
+		if err := m.WriteFile(agentID, conflict.Path, []byte(resolution.Content), mode); err != nil {
+			return fmt.Errorf("failed to write resolution of %s: %w", conflict.Path, err)
+		}
+	case !conflict.has(resolution.Side):
+		// The chosen side deleted the file
+		if _, err := m.ExecArgs(agentID, repoRoot, "git", "rm", "--quiet", "--", conflict.Path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", conflict.Path, err)
+		}
+		return nil
+	default:
+		if _, err := m.ExecArgs(agentID, repoRoot, "git", "checkout", "--"+string(resolution.Side), "--", conflict.Path); err != nil {
+			return fmt.Errorf("failed to check out %s version of %s: %w", resolution.Side, conflict.Path, err)
+		}
+	}
+
+	if _, err := m.ExecArgs(agentID, repoRoot, "git", "add", "--", conflict.Path); err != nil {
+		return fmt.Errorf("failed to mark %s as resolved: %w", conflict.Path, err)
+	}
+	return nil
+}
+
+// findConflict returns the conflict of a file given relative to the repository root
+func (m *Manager) findConflict(agentID, file string) (*Conflict, error) {
+	cleaned, err := cleanRepoPath(file)
+	if err != nil {
+		return nil, err
+	}
+	if cleaned == "" {
+		return nil, fmt.Errorf("path '%s' is not a file", file)
+	}
+
+	conflicts, err := m.GetConflicts(agentID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range conflicts {
+		if conflicts[i].Path == cleaned {
+			return &conflicts[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not in conflict", cleaned)
+}
+
+// has reports whether the conflict has a version on the given side
+func (c *Conflict) has(side ConflictSide) bool {
+	switch side {
+	case SideBase:
+		return c.HasBase
+	case SideOurs:
+		return c.HasOurs
+	case SideTheirs:
+		return c.HasTheirs
+	}
+	return false
+}
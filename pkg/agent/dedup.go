@@ -0,0 +1,340 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// DefaultDedupMinSize is the smallest file Dedup considers; smaller ones save less
+// than a block
+const DefaultDedupMinSize = 4096
+
+// How Dedup shares the content of a duplicate file
+const (
+	DedupHardLink = "hardlink" // the duplicate is replaced by a hard link to the kept copy
+	DedupReflink  = "reflink"  // the duplicate is replaced by a copy-on-write clone of the kept copy
+)
+
+// errReflinkUnsupported is returned by cloneFile where the file system cannot share
+// extents between files
+var errReflinkUnsupported = errors.New("file system does not support reflinks")
+
+// DedupOptions select the agents and files Dedup shares
+type DedupOptions struct {
+	// AgentIDs limits the pass to these agents; empty is every agent
+	AgentIDs []string
+	// MinSize skips smaller files; zero is DefaultDedupMinSize
+	MinSize int64
+	// HardLink shares working tree files by hard links where reflinks are not
+	// supported. Tools writing such a file in place change it in every agent sharing it.
+	HardLink bool
+	// DryRun reports what would be shared without changing any file
+	DryRun bool
+}
+
+// DedupGroup is the outcome of Dedup for the agents of one repository
+type DedupGroup struct {
+	RepoURL string   `json:"repo_url"`
+	Agents  []string `json:"agents"`
+	// Files is how many duplicate files were replaced, and Bytes their size
+	Files     int   `json:"files"`
+	Bytes     int64 `json:"bytes"`
+	HardLinks int   `json:"hard_links"`
+	Reflinks  int   `json:"reflinks"`
+	// Skipped duplicates could not be shared: they changed during the pass, differ in
+	// mode or owner, or their file system lacks reflinks and HardLink was not set
+	Skipped int `json:"skipped"`
+}
+
+// DedupReport is the outcome of Dedup
+type DedupReport struct {
+	Groups    []DedupGroup `json:"groups"`
+	Reclaimed int64        `json:"reclaimed_bytes"`
+	DryRun    bool         `json:"dry_run,omitempty"`
+}
+
+// dedupFile is a file of an agent workspace considered by Dedup
+type dedupFile struct {
+	path string
+	info os.FileInfo
+	// immutable files, Git's objects and packs, are never written in place and are
+	// always shared by hard links
+	immutable bool
+}
+
+// Dedup shares identical files across the workspaces of agents cloned from the same
+// repository, which hold the same checkout and mostly the same Git objects. Only
+// agents without overlay workspaces take part; overlay agents already share their
+// base. Files are compared by content: Git's objects are replaced by hard links to
+// one copy, working tree files by reflinks where the file system supports them, and
+// by hard links with HardLink. Git's other metadata, such as the index, is left alone.
+func (m *Manager) Dedup(opts DedupOptions) (*DedupReport, error) {
+	ctx := context.Background()
+
+	metrics.StartTimer("dedup", metrics.ContainerOps, "")
+	defer metrics.StopTimer("dedup", metrics.ContainerOps, "")
+
+	_, spanID := tracing.StartSpan(ctx, "agent.Dedup", map[string]interface{}{
+		"dry_run": opts.DryRun,
+	})
+
+	if opts.MinSize <= 0 {
+		opts.MinSize = DefaultDedupMinSize
+	}
+	agentIDs := opts.AgentIDs
+	if len(agentIDs) == 0 {
+		ids, err := m.AgentIDs()
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, err
+		}
+		agentIDs = ids
+	}
+
+	// Group the agents by the repository they were cloned from
+	repos := make(map[string][]string)
+	for _, agentID := range agentIDs {
+		st, exists, err := m.store.Get(agentID)
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, err
+		}
+		if !exists {
+			err := fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, err
+		}
+		// Overlay agents share their base, and the repositories of agents created from a
+		// host checkout or adopted are not in their workspace
+		if st.UseOverlay || st.RepoURL == "" || st.SourceDir != "" || st.Adopted {
+			continue
+		}
+		repos[st.RepoURL] = append(repos[st.RepoURL], agentID)
+	}
+	var urls []string
+	for url, ids := range repos {
+		if len(ids) > 1 {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+
+	report := &DedupReport{DryRun: opts.DryRun}
+	for _, url := range urls {
+		group, err := m.dedupRepo(url, repos[url], opts)
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return report, err
+		}
+		report.Groups = append(report.Groups, *group)
+		report.Reclaimed += group.Bytes
+	}
+
+	if !opts.DryRun && report.Reclaimed > 0 {
+		metrics.RecordGauge("dedup_reclaimed", metrics.ContainerOps, float64(report.Reclaimed), "bytes", "")
+	}
+	tracing.AddEvent(spanID, "dedup_completed", map[string]interface{}{
+		"groups":          len(report.Groups),
+		"reclaimed_bytes": report.Reclaimed,
+	})
+	tracing.EndSpanSuccess(spanID)
+	return report, nil
+}
+
+// dedupRepo shares the identical files of the workspaces of one repository's agents
+func (m *Manager) dedupRepo(repoURL string, agentIDs []string, opts DedupOptions) (*DedupGroup, error) {
+	sort.Strings(agentIDs)
+	group := &DedupGroup{RepoURL: repoURL, Agents: agentIDs}
+
+	// Only files of a size found more than once are hashed
+	bySize := make(map[int64][]dedupFile)
+	for _, agentID := range agentIDs {
+		root := filepath.Join(m.dataDir, "workspaces", agentID, "repo")
+		if err := walkDedupFiles(root, opts.MinSize, func(file dedupFile) {
+			bySize[file.info.Size()] = append(bySize[file.info.Size()], file)
+		}); err != nil {
+			return nil, fmt.Errorf("failed to scan workspace of agent '%s': %w", agentID, err)
+		}
+	}
+
+	for _, files := range bySize {
+		if len(files) < 2 {
+			continue
+		}
+		byDigest := make(map[string][]dedupFile)
+		var digests []string
+		for _, file := range files {
+			digest, err := hashFile(file.path)
+			if err != nil {
+				// Removed or unreadable since the scan
+				group.Skipped++
+				continue
+			}
+			if _, ok := byDigest[digest]; !ok {
+				digests = append(digests, digest)
+			}
+			byDigest[digest] = append(byDigest[digest], file)
+		}
+		for _, digest := range digests {
+			same := byDigest[digest]
+			for _, file := range same[1:] {
+				m.dedupFile(same[0], file, opts, group)
+			}
+		}
+	}
+	return group, nil
+}
+
+// dedupFile replaces duplicate by a hard link or reflink to keep, counting the
+// outcome in group
+func (m *Manager) dedupFile(keep, duplicate dedupFile, opts DedupOptions, group *DedupGroup) {
+	if os.SameFile(keep.info, duplicate.info) {
+		return
+	}
+	if keep.info.Mode() != duplicate.info.Mode() || !sameOwner(keep.info, duplicate.info) {
+		group.Skipped++
+		return
+	}
+	// A file changed since it was hashed is not replaced
+	current, err := os.Lstat(duplicate.path)
+	if err != nil || current.Size() != duplicate.info.Size() || !current.ModTime().Equal(duplicate.info.ModTime()) {
+		group.Skipped++
+		return
+	}
+
+	method := DedupReflink
+	if keep.immutable {
+		method = DedupHardLink
+	}
+	if opts.DryRun {
+		if method == DedupReflink && !reflinksSupported(keep.path) {
+			if !opts.HardLink {
+				group.Skipped++
+				return
+			}
+			method = DedupHardLink
+		}
+	} else {
+		if method == DedupReflink {
+			err = replaceFile(duplicate.path, func(tmp string) error {
+				return cloneWithTimes(keep.path, tmp, duplicate.info)
+			})
+			if errors.Is(err, errReflinkUnsupported) && opts.HardLink {
+				method = DedupHardLink
+			}
+		}
+		if method == DedupHardLink {
+			err = replaceFile(duplicate.path, func(tmp string) error { return os.Link(keep.path, tmp) })
+		}
+		if err != nil {
+			group.Skipped++
+			return
+		}
+	}
+
+	group.Files++
+	group.Bytes += duplicate.info.Size()
+	if method == DedupHardLink {
+		group.HardLinks++
+	} else {
+		group.Reflinks++
+	}
+}
+
+// replaceFile atomically puts the file made by create in place of path
+func replaceFile(path string, create func(tmp string) error) error {
+	tmp := path + ".capsulate-dedup"
+	os.Remove(tmp)
+	if err := create(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// cloneWithTimes clones src to dst with the mode and modification time of the file
+// it replaces, so that Git's index still matches it
+func cloneWithTimes(src, dst string, info os.FileInfo) error {
+	if err := cloneFile(src, dst); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// walkDedupFiles calls fn with the regular files of a repository of at least minSize
+// bytes: the working tree and Git's objects, but not Git's other metadata
+func walkDedupFiles(root string, minSize int64, fn func(dedupFile)) error {
+	gitDir := filepath.Join(root, ".git")
+	objectsDir := filepath.Join(gitDir, "objects")
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root && errors.Is(err, fs.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if path == gitDir || path == objectsDir {
+				return nil
+			}
+			if strings.HasPrefix(path, gitDir+string(filepath.Separator)) && !strings.HasPrefix(path, objectsDir+string(filepath.Separator)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		immutable := strings.HasPrefix(path, objectsDir+string(filepath.Separator))
+		if !immutable && filepath.Dir(path) == gitDir {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || info.Size() < minSize {
+			return nil
+		}
+		fn(dedupFile{path: path, info: info, immutable: immutable})
+		return nil
+	})
+	return err
+}
+
+// hashFile returns the SHA-256 of a file's content
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// reflinksSupported checks whether a file can be cloned in its directory
+func reflinksSupported(path string) bool {
+	tmp := path + ".capsulate-dedup"
+	defer os.Remove(tmp)
+	return cloneFile(path, tmp) == nil
+}
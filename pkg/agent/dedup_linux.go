@@ -0,0 +1,46 @@
+//go:build linux
+
+package agent
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// ficlone is the FICLONE ioctl sharing all extents of a file with another
+const ficlone = 0x40049409
+
+// cloneFile creates dst as a copy-on-write clone of src
+func cloneFile(src, dst string) error {
+	source, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer target.Close()
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, target.Fd(), ficlone, source.Fd())
+	if errno != 0 {
+		os.Remove(dst)
+		if errors.Is(errno, syscall.EOPNOTSUPP) || errors.Is(errno, syscall.EXDEV) || errors.Is(errno, syscall.EINVAL) || errors.Is(errno, syscall.ENOTTY) {
+			return errReflinkUnsupported
+		}
+		return errno
+	}
+	return nil
+}
+
+// sameOwner reports whether two files have the same owner and group
+func sameOwner(a, b os.FileInfo) bool {
+	statA, okA := a.Sys().(*syscall.Stat_t)
+	statB, okB := b.Sys().(*syscall.Stat_t)
+	if !okA || !okB {
+		return true
+	}
+	return statA.Uid == statB.Uid && statA.Gid == statB.Gid
+}
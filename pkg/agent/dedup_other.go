@@ -0,0 +1,15 @@
+//go:build !linux
+
+package agent
+
+import "os"
+
+// cloneFile is not supported outside Linux, where Dedup only makes hard links
+func cloneFile(src, dst string) error {
+	return errReflinkUnsupported
+}
+
+// sameOwner always holds on platforms where Dedup does not compare owners
+func sameOwner(a, b os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,642 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/your-org/capsulate-repo/pkg/deps"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// DependencyManifestFile is the metadata manifest kept at the root of a dependency layer
+const DependencyManifestFile = "capsulate-deps.json"
+
+// DependencyRecord describes a package installed into a dependency layer
+type DependencyRecord struct {
+	Name        string    `json:"name"`
+	Version     string    `json:"version"`
+	Provider    string    `json:"provider"`
+	Requested   string    `json:"requested,omitempty"`
+	Source      string    `json:"source"` // "install" or "lockfile"
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// DependencyManifest is the content of a layer's capsulate-deps.json
+type DependencyManifest struct {
+	Layer     string             `json:"layer"`
+	UpdatedAt time.Time          `json:"updated_at"`
+	Packages  []DependencyRecord `json:"packages"`
+}
+
+// ParsePackageSpec splits "name@version" into its parts. Scoped names such as
+// "@types/node@20.1.0" are supported; version is empty when none is given.
+func ParsePackageSpec(spec string) (name, version string) {
+	i := strings.LastIndex(spec, "@")
+	if i <= 0 {
+		return spec, ""
+	}
+	return spec[:i], spec[i+1:]
+}
+
+// InstallCoreDependency installs a package (name or name@version) with the given
+// provider into the core dependency layer shared read-only by all agents. An empty
+// provider name selects the default provider of the workspace.
+func (m *Manager) InstallCoreDependency(providerName, spec string) (*DependencyRecord, error) {
+	metrics.StartTimer("core_dep_install", metrics.DependencyOps, "")
+	defer metrics.StopTimer("core_dep_install", metrics.DependencyOps, "")
+
+	_, spanID := tracing.StartSpan(context.Background(), "agent.InstallCoreDependency", map[string]interface{}{
+		"provider": providerName,
+		"package":  spec,
+	})
+
+	provider, err := m.providerOrDefault(providerName)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	name, requested := ParsePackageSpec(spec)
+	if name == "" {
+		err := fmt.Errorf("invalid package spec '%s'", spec)
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	command := provider.InstallCommand(name, requested)
+	if output, err := m.runInstaller(provider.Image(), m.coreDepsPath, command); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, fmt.Errorf("failed to install %s: %v\n%s", spec, err, strings.TrimSpace(output))
+	}
+
+	manifest, err := m.refreshManifest(m.coreDepsPath, "core", provider, "install", map[string]string{name: requested})
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+	for _, record := range manifest.Packages {
+		if record.Provider == provider.Name() && record.Name == name {
+			metrics.RecordCount("core_dep_installed", metrics.DependencyOps, 1, "")
+			tracing.EndSpanSuccess(spanID)
+			return &record, nil
+		}
+	}
+
+	err = fmt.Errorf("package %s was not found in the core layer after installing", name)
+	tracing.EndSpanError(spanID, err.Error())
+	return nil, err
+}
+
+// SyncCoreDependencies makes the core layer match a lockfile exactly. The files the
+// provider needs from the lockfile's directory (e.g. package.json, go.mod) are copied
+// along with it. An empty lockfile path reinstalls the core layer from the files it
+// already holds. The provider is inferred from the lockfile name when not given.
+func (m *Manager) SyncCoreDependencies(providerName, lockfile string) ([]DependencyRecord, error) {
+	metrics.StartTimer("core_dep_sync", metrics.DependencyOps, "")
+	defer metrics.StopTimer("core_dep_sync", metrics.DependencyOps, "")
+
+	_, spanID := tracing.StartSpan(context.Background(), "agent.SyncCoreDependencies", map[string]interface{}{
+		"provider": providerName,
+		"lockfile": lockfile,
+	})
+
+	var provider deps.Provider
+	var err error
+	if providerName == "" && lockfile != "" {
+		provider, err = deps.ForLockfile(lockfile)
+	} else {
+		provider, err = m.providerOrDefault(providerName)
+	}
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	if lockfile != "" {
+		syncDir := filepath.Join(m.coreDepsPath, provider.SyncDir())
+		if err := os.MkdirAll(syncDir, 0755); err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, fmt.Errorf("failed to create %s: %w", syncDir, err)
+		}
+		for _, file := range provider.SyncFiles(lockfile) {
+			src := filepath.Join(filepath.Dir(lockfile), file)
+			if _, err := os.Stat(src); os.IsNotExist(err) && file != filepath.Base(lockfile) {
+				continue // optional companion file, e.g. go.sum for a module without dependencies
+			}
+			if err := copyFile(src, filepath.Join(syncDir, file)); err != nil {
+				tracing.EndSpanError(spanID, err.Error())
+				return nil, fmt.Errorf("failed to copy %s into core layer: %w", src, err)
+			}
+		}
+	}
+
+	if output, err := m.runInstaller(provider.Image(), m.coreDepsPath, provider.SyncCommand(lockfile)); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, fmt.Errorf("failed to sync core dependencies: %w\n%s", err, strings.TrimSpace(output))
+	}
+
+	manifest, err := m.refreshManifest(m.coreDepsPath, "core", provider, "lockfile", nil)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	var records []DependencyRecord
+	for _, record := range manifest.Packages {
+		if record.Provider == provider.Name() {
+			records = append(records, record)
+		}
+	}
+
+	metrics.RecordCount("core_dep_synced", metrics.DependencyOps, len(records), "")
+	tracing.EndSpanSuccess(spanID)
+	return records, nil
+}
+
+// CoreDependencies returns the manifest of the core dependency layer
+func (m *Manager) CoreDependencies() (*DependencyManifest, error) {
+	return readDependencyManifest(m.coreDepsPath, "core")
+}
+
+// ActiveProviders returns the dependency providers set up for an agent
+func (m *Manager) ActiveProviders(agentID string) ([]deps.Provider, error) {
+	st, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists || len(st.Providers) == 0 {
+		return m.resolveProviders(agentID), nil
+	}
+
+	providers := make([]deps.Provider, 0, len(st.Providers))
+	for _, name := range st.Providers {
+		provider, err := deps.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// installOverrides installs the --override-deps entries into the agent's container
+// layer and returns how each one resolved. Entries without a "provider:" prefix use
+// the first active provider.
+func (m *Manager) installOverrides(agentID string, providers []deps.Provider, specs []string) ([]state.DependencyResolution, error) {
+	var resolutions []state.DependencyResolution
+	layerDir := filepath.Join(m.containerDepsPath, agentID)
+
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		override, err := deps.ParseOverride(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		provider := providers[0]
+		if override.Provider != "" {
+			if provider, err = deps.Get(override.Provider); err != nil {
+				return nil, err
+			}
+		}
+
+		if output, err := m.Exec(agentID, provider.OverrideCommand(override)); err != nil {
+			return nil, fmt.Errorf("failed to install override %s: %v\n%s", spec, err, strings.TrimSpace(output))
+		}
+
+		resolution := state.DependencyResolution{
+			Provider:  provider.Name(),
+			Name:      override.Name,
+			Requested: override.String(),
+			Version:   override.Version,
+			Path:      override.Path,
+		}
+		if override.Path == "" {
+			if installed, err := provider.Packages(layerDir); err == nil && installed[override.Name] != "" {
+				resolution.Version = installed[override.Name]
+			}
+		}
+		resolutions = append(resolutions, resolution)
+		metrics.RecordCount("dependency_override", metrics.DependencyOps, 1, agentID)
+	}
+	return resolutions, nil
+}
+
+// configuredProviders returns the providers listed in capsulate.yaml, if any
+func (m *Manager) configuredProviders() ([]deps.Provider, error) {
+	var providers []deps.Provider
+	for _, name := range m.config.Dependencies.Providers {
+		provider, err := deps.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// resolveProviders returns the configured providers, or detects them from the marker
+// files in the agent's repository. Projects without any marker fall back to npm.
+func (m *Manager) resolveProviders(agentID string) []deps.Provider {
+	if providers, err := m.configuredProviders(); err == nil && len(providers) > 0 {
+		return providers
+	}
+
+	var markers []string
+	for _, name := range deps.Names() {
+		provider, _ := deps.Get(name)
+		for _, marker := range provider.Markers() {
+			markers = append(markers, shellQuote(marker))
+		}
+	}
+	found := make(map[string]bool)
+	command := fmt.Sprintf(`cd /workspace/repo 2>/dev/null && for f in %s; do [ -e "$f" ] && echo "$f"; done; true`, strings.Join(markers, " "))
+	if output, err := m.Exec(agentID, command); err == nil {
+		for _, line := range strings.Split(output, "\n") {
+			found[strings.TrimSpace(line)] = true
+		}
+	}
+
+	providers := deps.Detect(func(path string) bool { return found[path] })
+	if len(providers) == 0 {
+		npm, _ := deps.Get("npm")
+		providers = []deps.Provider{npm}
+	}
+	return providers
+}
+
+// providerOrDefault returns the named provider, or the default provider of the
+// workspace: the first configured one, else one detected from the workspace files
+func (m *Manager) providerOrDefault(name string) (deps.Provider, error) {
+	if name != "" {
+		return deps.Get(name)
+	}
+	providers, err := m.configuredProviders()
+	if err != nil {
+		return nil, err
+	}
+	if len(providers) == 0 {
+		providers = deps.Detect(func(path string) bool {
+			_, err := os.Stat(filepath.Join(m.workspaceDir, path))
+			return err == nil
+		})
+	}
+	if len(providers) == 0 {
+		return deps.Get("npm")
+	}
+	return providers[0], nil
+}
+
+// providerNames returns the names of the given providers
+func providerNames(providers []deps.Provider) []string {
+	names := make([]string, len(providers))
+	for i, provider := range providers {
+		names[i] = provider.Name()
+	}
+	return names
+}
+
+// refreshManifest rebuilds a provider's entries in a layer manifest from the packages
+// installed in the layer. Entries of unchanged packages keep their metadata; requested
+// holds the version specs of the packages that were just installed.
+func (m *Manager) refreshManifest(layerDir, layer string, provider deps.Provider, source string, requested map[string]string) (*DependencyManifest, error) {
+	installed, err := provider.Packages(layerDir)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readDependencyManifest(layerDir, layer)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := make(map[string]DependencyRecord)
+	var packages []DependencyRecord
+	for _, record := range manifest.Packages {
+		if record.Provider == "" {
+			record.Provider = "npm" // recorded before providers existed
+		}
+		if record.Provider == provider.Name() {
+			previous[record.Name] = record
+			continue
+		}
+		packages = append(packages, record)
+	}
+
+	now := time.Now()
+	for name, version := range installed {
+		record, known := previous[name]
+		_, justInstalled := requested[name]
+		if !known || record.Version != version || justInstalled {
+			record = DependencyRecord{
+				Name:        name,
+				Source:      source,
+				InstalledAt: now,
+			}
+			if justInstalled {
+				record.Requested = requested[name]
+			}
+		}
+		record.Version = version
+		record.Provider = provider.Name()
+		packages = append(packages, record)
+	}
+
+	manifest.Packages = packages
+	if err := writeDependencyManifest(layerDir, manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// runInstaller runs a package manager command in a throwaway container from image with
+// the layer directory mounted at /deps, so native packages are built for the agent's platform
+func (m *Manager) runInstaller(image, layerDir, command string) (string, error) {
+	ctx := context.Background()
+
+	if err := m.verifyImage(ctx, image); err != nil {
+		return "", err
+	}
+	if _, _, err := m.dockerClient.ImageInspectWithRaw(ctx, image); err != nil {
+		out, err := m.dockerClient.ImagePull(ctx, image, types.ImagePullOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to pull installer image %s: %w", image, err)
+		}
+		io.Copy(io.Discard, out)
+		out.Close()
+	}
+
+	// Installs into core and team layers may pull from private registries too
+	registryCreds, err := m.registryCredentials()
+	if err != nil {
+		return "", err
+	}
+
+	mounts := []mount.Mount{{
+		Type:   mount.TypeBind,
+		Source: layerDir,
+		Target: "/deps",
+	}}
+	if err := m.hostMounts(mounts); err != nil {
+		return "", err
+	}
+
+	resp, err := m.dockerClient.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image:      image,
+			Cmd:        []string{"/bin/bash", "-c", command},
+			WorkingDir: "/deps",
+			User:       fmt.Sprintf("%d:%d", os.Getuid(), os.Getgid()),
+			Env:        append([]string{"HOME=/tmp"}, registryCreds.env...),
+		},
+		&container.HostConfig{
+			Mounts: mounts,
+		},
+		nil,
+		nil,
+		"",
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create installer container: %w", err)
+	}
+	defer m.dockerClient.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{Force: true})
+
+	if err := m.injectRegistryCredentials(ctx, resp.ID, "/tmp", os.Getuid(), os.Getgid(), registryCreds); err != nil {
+		return "", err
+	}
+
+	if err := m.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return "", fmt.Errorf("failed to start installer container: %w", err)
+	}
+
+	var exitCode int
+	statusCh, errCh := m.dockerClient.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return "", fmt.Errorf("installer wait error: %w", err)
+		}
+	case status := <-statusCh:
+		exitCode = int(status.StatusCode)
+	}
+
+	logs, err := m.dockerClient.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to read installer output: %w", err)
+	}
+	defer logs.Close()
+	var output bytes.Buffer
+	stdcopy.StdCopy(&output, &output, logs)
+
+	if exitCode != 0 {
+		return output.String(), &ExitError{Code: exitCode}
+	}
+	return output.String(), nil
+}
+
+// readDependencyManifest loads a layer manifest, returning an empty one if none exists
+func readDependencyManifest(layerDir, layer string) (*DependencyManifest, error) {
+	path := filepath.Join(layerDir, DependencyManifestFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &DependencyManifest{Layer: layer}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	manifest := &DependencyManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// writeDependencyManifest stores a layer manifest with packages sorted by name
+func writeDependencyManifest(layerDir string, manifest *DependencyManifest) error {
+	sort.Slice(manifest.Packages, func(i, j int) bool {
+		return manifest.Packages[i].Name < manifest.Packages[j].Name
+	})
+	manifest.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal dependency manifest: %w", err)
+	}
+	path := filepath.Join(layerDir, DependencyManifestFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// copyFile copies a regular file, replacing the destination
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+// Dependency is a package visible to an agent, resolved across the dependency layers
+type Dependency struct {
+	Name     string `json:"name"`
+	Version  string `json:"version"`
+	Provider string `json:"provider"`
+	// Layer is the layer the package resolves from: core, team, container or override
+	Layer string `json:"layer"`
+	// Path is the local source of a path override
+	Path string `json:"path,omitempty"`
+	// Shadowed lists lower layers that also provide the package, as layer@version
+	Shadowed []string `json:"shadowed,omitempty"`
+}
+
+// ListDependencies returns the effective dependencies of an agent. For each active
+// provider the core, team and container layers are read in order of precedence;
+// packages installed through --override-deps are reported with the override layer.
+func (m *Manager) ListDependencies(agentID string) ([]Dependency, error) {
+	metrics.StartTimer("list_dependencies", metrics.DependencyOps, agentID)
+	defer metrics.StopTimer("list_dependencies", metrics.DependencyOps, agentID)
+
+	st, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		st = &state.AgentState{ID: agentID}
+	}
+	providers, err := m.ActiveProviders(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Layers from lowest to highest precedence
+	type layer struct {
+		name string
+		dir  string
+	}
+	layers := []layer{{"core", m.coreDepsPath}}
+	if st.DependencyLevel == "team" && st.TeamID != "" {
+		teamDir := m.teamLayerPath(st.TeamID)
+		if st.TeamSnapshot != "" {
+			teamDir = m.teamSnapshotPath(st.TeamID, st.TeamSnapshot)
+		}
+		layers = append(layers, layer{"team", teamDir})
+	}
+	layers = append(layers, layer{"container", filepath.Join(m.containerDepsPath, agentID)})
+
+	var dependencies []Dependency
+	for _, provider := range providers {
+		overrides := make(map[string]state.DependencyResolution)
+		for _, override := range st.Overrides {
+			if override.Provider == provider.Name() {
+				overrides[override.Name] = override
+			}
+		}
+
+		resolved := make(map[string]*Dependency)
+		for _, l := range layers {
+			packages, err := provider.Packages(l.dir)
+			if err != nil {
+				return nil, err
+			}
+			for name, version := range packages {
+				dep, ok := resolved[name]
+				if !ok {
+					dep = &Dependency{Name: name, Provider: provider.Name()}
+					resolved[name] = dep
+				} else {
+					dep.Shadowed = append(dep.Shadowed, dep.Layer+"@"+dep.Version)
+				}
+				dep.Version = version
+				dep.Layer = l.name
+			}
+		}
+
+		// Overrides resolve outside the layer directories for path and lockfile-based pins
+		for name, override := range overrides {
+			dep, ok := resolved[name]
+			if !ok {
+				dep = &Dependency{Name: name, Provider: provider.Name(), Version: override.Version}
+				resolved[name] = dep
+			} else if dep.Layer != "container" {
+				dep.Shadowed = append(dep.Shadowed, dep.Layer+"@"+dep.Version)
+				dep.Version = override.Version
+			}
+			dep.Layer = "override"
+			dep.Path = override.Path
+		}
+
+		names := make([]string, 0, len(resolved))
+		for name := range resolved {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			dependencies = append(dependencies, *resolved[name])
+		}
+	}
+	return dependencies, nil
+}
+
+// recordDependencyCacheUse records whether the shared core and team layers served any
+// packages to a new agent. Bytes saved is the size of the shared layers the agent would
+// otherwise have had to install itself.
+func (m *Manager) recordDependencyCacheUse(config AgentConfig, providers []deps.Provider) {
+	dirs := []string{m.coreDepsPath}
+	if config.DependencyLevel == "team" && config.TeamID != "" {
+		if config.TeamSnapshot != "" {
+			dirs = append(dirs, m.teamSnapshotPath(config.TeamID, config.TeamSnapshot))
+		} else {
+			dirs = append(dirs, m.teamLayerPath(config.TeamID))
+		}
+	}
+
+	served := 0
+	for _, provider := range providers {
+		for _, dir := range dirs {
+			packages, err := provider.Packages(dir)
+			if err == nil {
+				served += len(packages)
+			}
+		}
+	}
+	if served == 0 {
+		metrics.RecordCacheMiss(metrics.DependencyCache, config.ID)
+		return
+	}
+
+	var size int64
+	for _, dir := range dirs {
+		size += dirSize(dir)
+	}
+	metrics.RecordCacheHit(metrics.DependencyCache, config.ID, size, 0)
+}
+
+// dirSize returns the total size of the regular files below dir
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Kinds of resources listed in a DestroyPlan
+const (
+	ResourceContainer = "container"
+	ResourceSidecar   = "sidecar"
+	ResourceState     = "state"
+	ResourceDirectory = "directory"
+	ResourceVolume    = "volume"
+)
+
+// AffectedResource is a container, state record, directory or volume of an agent
+type AffectedResource struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Size is the container's writable layer or the directory's content; state
+	// records and volumes are not measured
+	Size int64 `json:"size_bytes"`
+}
+
+// DestroyPlan lists what Destroy would remove for an agent, the host directories it
+// moves to the trash and those and the volumes it leaves in place
+type DestroyPlan struct {
+	AgentID string             `json:"agent_id"`
+	Removed []AffectedResource `json:"removed"`
+	Trashed []AffectedResource `json:"trashed"`
+	Kept    []AffectedResource `json:"kept"`
+	// Reclaimed is the space freed by the removed resources
+	Reclaimed int64 `json:"reclaimed_bytes"`
+	// Retained is the space still taken by the trashed and kept resources
+	Retained int64 `json:"retained_bytes"`
+}
+
+// PlanDestroy reports what destroying an agent with opts would remove, with sizes,
+// without changing anything
+func (m *Manager) PlanDestroy(agentID string, opts DestroyOptions) (*DestroyPlan, error) {
+	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.PlanDestroy", map[string]interface{}{
+		"agent_id": agentID,
+	})
+
+	plan, err := m.planDestroy(ctx, agentID, opts)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	tracing.EndSpanSuccess(spanID)
+	return plan, nil
+}
+
+// planDestroy does the work of PlanDestroy
+func (m *Manager) planDestroy(ctx context.Context, agentID string, opts DestroyOptions) (*DestroyPlan, error) {
+	plan := &DestroyPlan{AgentID: agentID}
+	remove := func(r AffectedResource) {
+		plan.Removed = append(plan.Removed, r)
+		plan.Reclaimed += r.Size
+	}
+	keep := func(r AffectedResource) {
+		plan.Kept = append(plan.Kept, r)
+		plan.Retained += r.Size
+	}
+
+	// Sidecars are removed first, like Destroy does
+	sidecars, err := m.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+		All:  true,
+		Size: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", "capsulate.agent-id="+agentID),
+			filters.Arg("label", SidecarLabel),
+		),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sidecars: %w", err)
+	}
+	for _, c := range sidecars {
+		if c.Labels[ProjectLabel] != m.project {
+			continue
+		}
+		remove(AffectedResource{Kind: ResourceSidecar, Name: c.Labels[SidecarLabel], Size: c.SizeRw})
+	}
+
+	containerName := m.containerName(agentID)
+	info, _, err := m.dockerClient.ContainerInspectWithRaw(ctx, containerName, true)
+	switch {
+	case errdefs.IsNotFound(err):
+	case err != nil:
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	default:
+		var size int64
+		if info.SizeRw != nil {
+			size = *info.SizeRw
+		}
+		remove(AffectedResource{Kind: ResourceContainer, Name: containerName, Size: size})
+		// Volumes outlive the container, which is removed without them
+		for _, mp := range info.Mounts {
+			if mp.Type == mount.TypeVolume {
+				keep(AffectedResource{Kind: ResourceVolume, Name: mp.Name})
+			}
+		}
+	}
+
+	_, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		remove(AffectedResource{Kind: ResourceState, Name: agentID})
+	}
+	if len(plan.Removed) == 0 {
+		return nil, fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+
+	// The workspace and dependency directories on the host go to the trash or are
+	// left in place
+	toTrash := !opts.SkipTrash && m.config.Trash.TrashEnabled()
+	for _, dir := range m.agentDirs(agentID) {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		r := AffectedResource{Kind: ResourceDirectory, Name: dir, Size: dirSize(dir)}
+		if toTrash {
+			plan.Trashed = append(plan.Trashed, r)
+			plan.Retained += r.Size
+		} else {
+			keep(r)
+		}
+	}
+	return plan, nil
+}
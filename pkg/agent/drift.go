@@ -0,0 +1,238 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// Kinds of drift between an agent's container and its recorded configuration
+const (
+	DriftImage   = "image"
+	DriftCommand = "command"
+	DriftMount   = "mount"
+	DriftEnv     = "env"
+	DriftLabel   = "label"
+	DriftLimit   = "limit"
+)
+
+// Drift is one difference between an agent's running container and the container its
+// template and recorded configuration produce
+type Drift struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name,omitempty"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+}
+
+// DriftReport lists the drift of one agent
+type DriftReport struct {
+	AgentID  string  `json:"agent_id"`
+	Template string  `json:"template,omitempty"`
+	Drift    []Drift `json:"drift"`
+}
+
+// Drifted reports whether the agent's container differs from its configuration
+func (r *DriftReport) Drifted() bool {
+	return len(r.Drift) > 0
+}
+
+// DetectDrift compares an agent's container (image, command, mounts, environment,
+// labels and resource limits) against the container Create or Recreate would make
+// for it now. Recreate converges a drifted agent.
+func (m *Manager) DetectDrift(agentID string) (*DriftReport, error) {
+	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+	st, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+
+	inspect, err := m.dockerClient.ContainerInspect(ctx, m.containerName(agentID))
+	if err != nil {
+		return nil, agentError(agentID, err)
+	}
+
+	config := agentConfigFromState(st)
+	spec, err := m.containerSpec(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DriftReport{AgentID: agentID, Template: st.Template, Drift: []Drift{}}
+	add := func(kind, name, expected, actual string) {
+		report.Drift = append(report.Drift, Drift{Kind: kind, Name: name, Expected: expected, Actual: actual})
+	}
+
+	// Image: the container should run the current base image of its platform
+	baseImage := m.baseImageFor(st.Platform)
+	imageID, err := m.imageID(baseImage)
+	if err != nil {
+		return nil, err
+	}
+	if imageID != "" && inspect.Image != imageID {
+		add(DriftImage, baseImage, imageID, inspect.Image)
+	}
+
+	// Primary process: entrypoint, command and init
+	if expected, actual := strings.Join(spec.config.Entrypoint, " "), strings.Join(inspect.Config.Entrypoint, " "); expected != actual {
+		add(DriftCommand, "entrypoint", expected, actual)
+	}
+	if expected, actual := strings.Join(spec.config.Cmd, " "), strings.Join(inspect.Config.Cmd, " "); expected != actual {
+		add(DriftCommand, "cmd", expected, actual)
+	}
+	if inspect.HostConfig != nil {
+		actualInit := inspect.HostConfig.Init != nil && *inspect.HostConfig.Init
+		if expected := *spec.hostConfig.Init; expected != actualInit {
+			add(DriftCommand, "init", strconv.FormatBool(expected), strconv.FormatBool(actualInit))
+		}
+	}
+
+	// Mounts, keyed by their target in the container
+	actualMounts := make(map[string]string)
+	for _, mp := range inspect.Mounts {
+		actualMounts[mp.Destination] = formatMount(mp.Source, !mp.RW)
+	}
+	expectedMounts := make(map[string]string)
+	for _, mt := range spec.hostConfig.Mounts {
+		expectedMounts[mt.Target] = formatMount(mt.Source, mt.ReadOnly)
+	}
+	diffMaps(DriftMount, expectedMounts, actualMounts, nil, add)
+
+	// Environment. Variables of the image itself are not drift; OVERRIDE_DEPS is
+	// recorded as resolved overrides, not as the spelling it was created with.
+	imageEnv := make(map[string]bool)
+	if image, _, err := m.dockerClient.ImageInspectWithRaw(ctx, inspect.Image); err == nil && image.Config != nil {
+		for key := range envMap(image.Config.Env) {
+			imageEnv[key] = true
+		}
+	}
+	expectedEnv := envMap(spec.config.Env)
+	actualEnv := envMap(inspect.Config.Env)
+	delete(expectedEnv, "OVERRIDE_DEPS")
+	delete(actualEnv, "OVERRIDE_DEPS")
+	diffMaps(DriftEnv, expectedEnv, actualEnv, func(key string) bool { return imageEnv[key] }, add)
+
+	// Labels set by git-capsulate
+	actualLabels := make(map[string]string)
+	for key, value := range inspect.Config.Labels {
+		if strings.HasPrefix(key, "capsulate.") {
+			actualLabels[key] = value
+		}
+	}
+	diffMaps(DriftLabel, spec.config.Labels, actualLabels, nil, add)
+
+	// Resource limits
+	if inspect.HostConfig != nil {
+		for name, values := range resourceLimits(spec.hostConfig.Resources, inspect.HostConfig.Resources) {
+			if values[0] != values[1] {
+				add(DriftLimit, name, strconv.FormatInt(values[0], 10), strconv.FormatInt(values[1], 10))
+			}
+		}
+	}
+
+	sort.SliceStable(report.Drift, func(i, j int) bool {
+		if report.Drift[i].Kind != report.Drift[j].Kind {
+			return report.Drift[i].Kind < report.Drift[j].Kind
+		}
+		return report.Drift[i].Name < report.Drift[j].Name
+	})
+	return report, nil
+}
+
+// agentConfigFromState rebuilds the configuration of an existing agent from its
+// recorded state. Dependency overrides are taken from their resolutions.
+func agentConfigFromState(st *state.AgentState) AgentConfig {
+	config := AgentConfig{
+		ID:               st.ID,
+		DependencyLevel:  st.DependencyLevel,
+		TeamID:           st.TeamID,
+		TeamSnapshot:     st.TeamSnapshot,
+		UseOverlay:       st.UseOverlay,
+		OverlayMount:     st.OverlayMount,
+		StorageDriver:    st.StorageDriver,
+		RepoURL:          st.RepoURL,
+		Branch:           st.Branch,
+		Commit:           st.Commit,
+		Template:         st.Template,
+		Labels:           st.Labels,
+		Path:             st.Path,
+		Sparse:           st.Sparse,
+		SSHAcceptNew:     st.SSHAcceptNew,
+		FromDir:          st.SourceDir,
+		SSHServer:        st.SSHServer,
+		SSHServerPort:    st.SSHServerPort,
+		SSHAuthorizedKey: st.SSHAuthorizedKey,
+		Platform:         st.Platform,
+	}
+	for _, override := range st.Overrides {
+		config.OverrideDeps = append(config.OverrideDeps, override.Provider+":"+override.Requested)
+	}
+	return config
+}
+
+// diffMaps reports the keys whose values differ between expected and actual. Keys
+// only present in actual are reported unless ignoreExtra returns true for them.
+func diffMaps(kind string, expected, actual map[string]string, ignoreExtra func(string) bool, add func(kind, name, expected, actual string)) {
+	for key, want := range expected {
+		got, ok := actual[key]
+		switch {
+		case !ok:
+			add(kind, key, want, "(missing)")
+		case got != want:
+			add(kind, key, want, got)
+		}
+	}
+	for key, got := range actual {
+		if _, ok := expected[key]; ok || (ignoreExtra != nil && ignoreExtra(key)) {
+			continue
+		}
+		add(kind, key, "(none)", got)
+	}
+}
+
+// envMap converts KEY=VALUE entries into a map
+func envMap(env []string) map[string]string {
+	result := make(map[string]string, len(env))
+	for _, entry := range env {
+		key, value, _ := strings.Cut(entry, "=")
+		result[key] = value
+	}
+	return result
+}
+
+// formatMount describes the source of a mount for a drift report
+func formatMount(source string, readOnly bool) string {
+	if readOnly {
+		return source + " (ro)"
+	}
+	return source
+}
+
+// resourceLimits pairs the expected and actual values of the resource limits
+// git-capsulate may set
+func resourceLimits(expected, actual container.Resources) map[string][2]int64 {
+	// Docker reports an unlimited PID count as 0, -1 or nil
+	pids := func(limit *int64) int64 {
+		if limit == nil || *limit < 0 {
+			return 0
+		}
+		return *limit
+	}
+	return map[string][2]int64{
+		"memory":     {expected.Memory, actual.Memory},
+		"nano_cpus":  {expected.NanoCPUs, actual.NanoCPUs},
+		"pids_limit": {pids(expected.PidsLimit), pids(actual.PidsLimit)},
+	}
+}
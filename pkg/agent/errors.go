@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/dockerclient"
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// ErrAgentNotFound is returned (wrapped) when an operation targets an agent whose
+// container or recorded state does not exist
+var ErrAgentNotFound = state.ErrAgentNotFound
+
+// ErrNoRepository is returned (wrapped) when a Git operation targets an agent created
+// without a repository
+var ErrNoRepository = errors.New("no repository")
+
+// ErrAgentBusy is returned (wrapped) when another process is creating, destroying or
+// resetting the agent
+var ErrAgentBusy = state.ErrAgentBusy
+
+// ErrQuotaExceeded is returned (wrapped) when the caller has created as many agents
+// in the last hour, or runs as many commands, as quotas allow
+var ErrQuotaExceeded = state.ErrQuotaExceeded
+
+// agentError maps a Docker "not found" error for an agent's container to
+// ErrAgentNotFound and returns other errors unchanged
+func agentError(agentID string, err error) error {
+	if err != nil && errdefs.IsNotFound(err) {
+		return fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+	return err
+}
+
+// IsDockerError reports whether err was caused by the Docker daemon being unreachable,
+// too old or failing, as opposed to a problem with the agent or the command it ran
+func IsDockerError(err error) bool {
+	var unsupported *dockerclient.UnsupportedError
+	if client.IsErrConnectionFailed(err) || errors.As(err, &unsupported) {
+		return true
+	}
+	return errdefs.IsSystem(err) || errdefs.IsUnavailable(err) || errdefs.IsUnknown(err) ||
+		errdefs.IsDeadline(err) || errdefs.IsDataLoss(err)
+}
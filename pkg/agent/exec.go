@@ -0,0 +1,132 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// DefaultMaxCapture is the amount of command output kept in memory by Exec when no
+// limit is given. Output beyond it is truncated in the middle so both the start and
+// the end (where errors usually are) of the output survive.
+const DefaultMaxCapture int64 = 64 << 20
+
+// ExecOptions controls how the output of a command run with ExecWithOptions is captured
+type ExecOptions struct {
+	// MaxCapture is the maximum number of output bytes kept in memory. Zero selects
+	// DefaultMaxCapture, a negative value disables the limit.
+	MaxCapture int64
+	// OutputFile, when set, receives the complete, untruncated output on the host
+	OutputFile string
+	// WorkingDir is the directory the command runs in; the container's default when empty
+	WorkingDir string
+	// Record adds the command to the agent's run summary and counts it against the
+	// caller's quotas.concurrent_execs; set for commands run on behalf of users rather
+	// than by git-capsulate itself
+	Record bool
+}
+
+// ExecResult is the outcome of a command run with ExecWithOptions
+type ExecResult struct {
+	// Output is the captured stdout and stderr, with a truncation marker if it was capped
+	Output string `json:"output"`
+	// ExitCode is the exit code of the command
+	ExitCode int `json:"exit_code"`
+	// TotalBytes is the size of the complete output produced by the command
+	TotalBytes int64 `json:"total_bytes"`
+	// CapturedBytes is the number of output bytes kept in Output
+	CapturedBytes int64 `json:"captured_bytes"`
+	// TruncatedBytes is the number of output bytes dropped from Output
+	TruncatedBytes int64 `json:"truncated_bytes"`
+	// OutputFile is the host file holding the complete output, if one was requested
+	OutputFile string `json:"output_file,omitempty"`
+}
+
+// Truncated reports whether part of the output was dropped from Output
+func (r *ExecResult) Truncated() bool {
+	return r.TruncatedBytes > 0
+}
+
+// cappedBuffer keeps the first and last halves of at most max bytes written to it
+// and counts the rest, so commands that dump huge output cannot exhaust memory
+type cappedBuffer struct {
+	max   int64
+	head  bytes.Buffer
+	tail  []byte // ring buffer holding the most recent bytes once head is full
+	start int    // index of the oldest byte in tail
+	total int64
+}
+
+// newCappedBuffer returns a buffer keeping at most max bytes; max < 0 means no limit
+func newCappedBuffer(max int64) *cappedBuffer {
+	if max == 0 {
+		max = DefaultMaxCapture
+	}
+	return &cappedBuffer{max: max}
+}
+
+// Write implements io.Writer and never fails
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	b.total += int64(n)
+
+	if b.max < 0 {
+		b.head.Write(p)
+		return n, nil
+	}
+
+	headMax := b.max / 2
+	if room := headMax - int64(b.head.Len()); room > 0 {
+		if int64(len(p)) <= room {
+			b.head.Write(p)
+			return n, nil
+		}
+		b.head.Write(p[:room])
+		p = p[room:]
+	}
+
+	tailMax := int(b.max - headMax)
+	if tailMax == 0 {
+		return n, nil
+	}
+	if len(p) >= tailMax {
+		// The write alone fills the tail; keep its last tailMax bytes
+		b.tail = append(b.tail[:0], p[len(p)-tailMax:]...)
+		b.start = 0
+		return n, nil
+	}
+	for len(p) > 0 {
+		if len(b.tail) < tailMax {
+			free := tailMax - len(b.tail)
+			if free > len(p) {
+				free = len(p)
+			}
+			b.tail = append(b.tail, p[:free]...)
+			p = p[free:]
+			continue
+		}
+		copied := copy(b.tail[b.start:], p)
+		p = p[copied:]
+		b.start = (b.start + copied) % tailMax
+	}
+	return n, nil
+}
+
+// captured returns the number of bytes kept
+func (b *cappedBuffer) captured() int64 {
+	return int64(b.head.Len() + len(b.tail))
+}
+
+// String returns the kept output with a marker where bytes were dropped
+func (b *cappedBuffer) String() string {
+	if len(b.tail) == 0 {
+		return b.head.String()
+	}
+	var out bytes.Buffer
+	out.Write(b.head.Bytes())
+	if dropped := b.total - b.captured(); dropped > 0 {
+		fmt.Fprintf(&out, "\n... [%d bytes truncated] ...\n", dropped)
+	}
+	out.Write(b.tail[b.start:])
+	out.Write(b.tail[:b.start])
+	return out.String()
+}
@@ -0,0 +1,134 @@
+package agent
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+)
+
+// MaxFileSize is the largest file ReadFile returns and WriteFile accepts
+const MaxFileSize int64 = 16 << 20
+
+// ErrFileTooLarge is returned (wrapped) when a file exceeds MaxFileSize
+var ErrFileTooLarge = errors.New("file too large")
+
+// ReadFile returns the contents of a regular file in an agent's repository, given
+// relative to the repository root. The file is copied with the Docker archive API
+// rather than through a shell. A missing file returns an error wrapping fs.ErrNotExist.
+func (m *Manager) ReadFile(agentID, file string) ([]byte, error) {
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+	metrics.StartTimer("read_file", metrics.FileOps, agentID)
+	defer metrics.StopTimer("read_file", metrics.FileOps, agentID)
+
+	target, err := repoFilePath(file)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	containerName := m.containerName(agentID)
+	stat, err := m.dockerClient.ContainerStatPath(ctx, containerName, target)
+	if err != nil {
+		return nil, m.fileError(ctx, agentID, file, err)
+	}
+	if !stat.Mode.IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", file)
+	}
+	if stat.Size > MaxFileSize {
+		return nil, fmt.Errorf("%w: %s is %d bytes (limit %d)", ErrFileTooLarge, file, stat.Size, MaxFileSize)
+	}
+
+	reader, _, err := m.dockerClient.CopyFromContainer(ctx, containerName, target)
+	if err != nil {
+		return nil, m.fileError(ctx, agentID, file, err)
+	}
+	defer reader.Close()
+
+	tr := tar.NewReader(reader)
+	if _, err := tr.Next(); err != nil {
+		return nil, fmt.Errorf("failed to read %s from container: %w", file, err)
+	}
+	contents, err := io.ReadAll(io.LimitReader(tr, MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from container: %w", file, err)
+	}
+	if int64(len(contents)) > MaxFileSize {
+		return nil, fmt.Errorf("%w: %s exceeds %d bytes", ErrFileTooLarge, file, MaxFileSize)
+	}
+
+	metrics.RecordCount("bytes_read", metrics.FileOps, len(contents), agentID)
+	return contents, nil
+}
+
+// WriteFile writes a file in an agent's repository, given relative to the repository
+// root, creating missing parent directories. mode holds the permission bits; zero
+// selects 0644.
+func (m *Manager) WriteFile(agentID, file string, contents []byte, mode os.FileMode) error {
+	if err := ValidateAgentID(agentID); err != nil {
+		return err
+	}
+	metrics.StartTimer("write_file", metrics.FileOps, agentID)
+	defer metrics.StopTimer("write_file", metrics.FileOps, agentID)
+
+	target, err := repoFilePath(file)
+	if err != nil {
+		return err
+	}
+	if int64(len(contents)) > MaxFileSize {
+		return fmt.Errorf("%w: %s is %d bytes (limit %d)", ErrFileTooLarge, file, len(contents), MaxFileSize)
+	}
+	if mode&^fs.ModePerm != 0 {
+		return fmt.Errorf("invalid file mode %v: only permission bits may be set", mode)
+	}
+	if mode == 0 {
+		mode = 0644
+	}
+
+	ctx := context.Background()
+	containerName := m.containerName(agentID)
+	files := map[string]string{target: string(contents)}
+	if err := m.injectFiles(ctx, containerName, files, int64(mode)); err != nil {
+		if errdefs.IsNotFound(errors.Unwrap(err)) {
+			return fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+		}
+		return fmt.Errorf("failed to write %s: %w", file, err)
+	}
+
+	metrics.RecordCount("bytes_written", metrics.FileOps, len(contents), agentID)
+	return nil
+}
+
+// repoFilePath validates a file path given relative to the repository root and
+// returns its absolute path in the container
+func repoFilePath(file string) (string, error) {
+	cleaned, err := cleanRepoPath(file)
+	if err != nil {
+		return "", err
+	}
+	if cleaned == "" {
+		return "", fmt.Errorf("path '%s' is not a file", file)
+	}
+	return path.Join(repoRoot, cleaned), nil
+}
+
+// fileError maps a Docker "not found" error for a file to ErrAgentNotFound when the
+// agent's container is missing and to fs.ErrNotExist when the file is
+func (m *Manager) fileError(ctx context.Context, agentID, file string, err error) error {
+	if !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to access %s: %w", file, err)
+	}
+	if _, inspectErr := m.dockerClient.ContainerInspect(ctx, m.containerName(agentID)); inspectErr != nil {
+		return agentError(agentID, inspectErr)
+	}
+	return fmt.Errorf("%s: %w", file, fs.ErrNotExist)
+}
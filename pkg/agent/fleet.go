@@ -0,0 +1,103 @@
+package agent
+
+import (
+	"sync"
+)
+
+// maxParallel bounds the number of agents operated on at the same time by fleet-wide
+// operations, so a large fleet does not flood the Docker daemon
+const maxParallel = 8
+
+// AgentStatus is the Git status of one agent in a fleet-wide status query
+type AgentStatus struct {
+	AgentID string     `json:"agent_id"`
+	Status  *GitStatus `json:"status,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+// AgentIDs returns the IDs of all agents recorded in the state store, sorted
+func (m *Manager) AgentIDs() ([]string, error) {
+	states, err := m.store.List()
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(states))
+	for _, st := range states {
+		ids = append(ids, st.ID)
+	}
+	return ids, nil
+}
+
+// GetGitStatusAll gathers the Git status of the given agents concurrently. Results
+// are returned in the order of agentIDs; failures are reported per agent.
+func (m *Manager) GetGitStatusAll(agentIDs []string) []AgentStatus {
+	results := make([]AgentStatus, len(agentIDs))
+	forEachParallel(agentIDs, func(i int, agentID string) {
+		results[i].AgentID = agentID
+		status, err := m.GetGitStatus(agentID)
+		if err != nil {
+			results[i].Error = err.Error()
+			return
+		}
+		results[i].Status = status
+	})
+	return results
+}
+
+// forEachParallel calls fn for every agent ID, running at most maxParallel calls at once
+func forEachParallel(agentIDs []string, fn func(i int, agentID string)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, maxParallel)
+	for i, agentID := range agentIDs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, agentID string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i, agentID)
+		}(i, agentID)
+	}
+	wg.Wait()
+}
+
+// AgentExecResult is the outcome of a command run on one agent of a fan-out exec
+type AgentExecResult struct {
+	AgentID string `json:"agent_id"`
+	*ExecResult
+	Error string `json:"error,omitempty"`
+}
+
+// TeamAgentIDs returns the IDs of the agents belonging to a team, sorted
+func (m *Manager) TeamAgentIDs(teamID string) ([]string, error) {
+	states, err := m.store.List()
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, st := range states {
+		if st.TeamID == teamID {
+			ids = append(ids, st.ID)
+		}
+	}
+	return ids, nil
+}
+
+// ExecAll runs the same command on the given agents in parallel, each in its working
+// directory unless opts sets one. Results are returned
+// in the order of agentIDs; a non-zero exit code is reported in the result, other
+// failures in its Error field.
+func (m *Manager) ExecAll(agentIDs []string, command string, opts ExecOptions) []AgentExecResult {
+	results := make([]AgentExecResult, len(agentIDs))
+	forEachParallel(agentIDs, func(i int, agentID string) {
+		agentOpts := opts
+		if agentOpts.WorkingDir == "" {
+			agentOpts.WorkingDir = m.WorkDir(agentID)
+		}
+		result, err := m.ExecWithOptions(agentID, command, agentOpts)
+		results[i] = AgentExecResult{AgentID: agentID, ExecResult: result}
+		if result == nil && err != nil {
+			results[i].Error = err.Error()
+		}
+	})
+	return results
+}
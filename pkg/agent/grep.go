@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+)
+
+// GrepOptions configures a search run with Grep
+type GrepOptions struct {
+	// Paths limits the search to these files or directories, relative to the
+	// repository root. Agents scoped with --path search their subdirectory by default.
+	Paths []string
+	// IgnoreCase matches case-insensitively
+	IgnoreCase bool
+	// FixedString treats the pattern as a literal string instead of an extended
+	// regular expression
+	FixedString bool
+}
+
+// GrepMatch is a line matching a Grep pattern
+type GrepMatch struct {
+	File   string `json:"file"` // relative to the repository root
+	Line   int    `json:"line"`
+	Column int    `json:"column"` // 1-based byte offset of the first match in the line
+	Match  string `json:"match"`  // the matching line
+}
+
+// Grep searches the tracked files of an agent's repository with git grep, skipping
+// binary files. No matches is not an error.
+func (m *Manager) Grep(agentID, pattern string, opts GrepOptions) ([]GrepMatch, error) {
+	metrics.StartTimer("git_grep", metrics.GitOps, agentID)
+	defer metrics.StopTimer("git_grep", metrics.GitOps, agentID)
+
+	if pattern == "" {
+		return nil, fmt.Errorf("search pattern must not be empty")
+	}
+
+	args := []string{"git", "grep", "--line-number", "--column", "--null", "-I"}
+	if opts.IgnoreCase {
+		args = append(args, "--ignore-case")
+	}
+	if opts.FixedString {
+		args = append(args, "--fixed-strings")
+	} else {
+		args = append(args, "--extended-regexp")
+	}
+	args = append(args, "-e", pattern, "--")
+
+	paths := opts.Paths
+	if len(paths) == 0 {
+		if st, exists, err := m.store.Get(agentID); err == nil && exists && st.Path != "" {
+			paths = []string{st.Path}
+		}
+	}
+	for _, p := range paths {
+		cleaned, err := cleanRepoPath(p)
+		if err != nil {
+			return nil, err
+		}
+		if cleaned == "" {
+			cleaned = "."
+		}
+		args = append(args, cleaned)
+	}
+
+	output, err := m.ExecArgs(agentID, repoRoot, args...)
+	if err != nil {
+		// git grep exits 1 when nothing matches
+		var exitErr *ExitError
+		if errors.As(err, &exitErr) && exitErr.Code == 1 && output == "" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to search repository: %w", err)
+	}
+	return parseGrep(output), nil
+}
+
+// parseGrep parses `git grep --line-number --column --null` output:
+// "<file>\x00<line>\x00<column>\x00<text>" per matching line
+func parseGrep(output string) []GrepMatch {
+	var matches []GrepMatch
+	for _, row := range strings.Split(output, "\n") {
+		fields := strings.SplitN(row, "\x00", 4)
+		if len(fields) != 4 {
+			continue
+		}
+		match := GrepMatch{File: fields[0], Match: strings.TrimSuffix(fields[3], "\r")}
+		match.Line, _ = strconv.Atoi(fields[1])
+		match.Column, _ = strconv.Atoi(fields[2])
+		matches = append(matches, match)
+	}
+	return matches
+}
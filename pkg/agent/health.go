@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// Health statuses of agents. Docker reports starting, healthy and unhealthy for agents
+// whose template has a healthcheck; the others have none.
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+	HealthNone      = "none"
+)
+
+// AgentHealth is the health of an agent's container
+type AgentHealth struct {
+	AgentID string `json:"agent_id"`
+	// Container is the state of the container, e.g. running or exited, or missing
+	Container string `json:"container"`
+	// Health is one of the Health statuses, and FailingStreak the number of
+	// consecutive failed checks
+	Health        string `json:"health"`
+	FailingStreak int    `json:"failing_streak,omitempty"`
+	// LastCheck is when the healthcheck last ran, and LastOutput its output
+	LastCheck  time.Time `json:"last_check,omitempty"`
+	LastOutput string    `json:"last_output,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// healthConfig converts the healthcheck of a template to Docker's, run with sh -c
+func healthConfig(hc *config.HealthcheckConfig) *container.HealthConfig {
+	if hc == nil {
+		return nil
+	}
+	return &container.HealthConfig{
+		Test:        []string{"CMD-SHELL", hc.Command},
+		Interval:    hc.HealthInterval(),
+		Timeout:     hc.HealthTimeout(),
+		Retries:     hc.HealthRetries(),
+		StartPeriod: time.Duration(hc.StartPeriod),
+	}
+}
+
+// Health returns the health of an agent's container as Docker's healthcheck reports it
+func (m *Manager) Health(agentID string) (*AgentHealth, error) {
+	if _, exists, err := m.store.Get(agentID); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+
+	health := &AgentHealth{AgentID: agentID, Health: HealthNone}
+	info, err := m.dockerClient.ContainerInspect(context.Background(), m.containerName(agentID))
+	if errdefs.IsNotFound(err) {
+		health.Container = "missing"
+		return health, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	health.Container = info.State.Status
+	if info.State.Health == nil {
+		return health, nil
+	}
+	health.Health = info.State.Health.Status
+	health.FailingStreak = info.State.Health.FailingStreak
+	if logs := info.State.Health.Log; len(logs) > 0 {
+		last := logs[len(logs)-1]
+		health.LastCheck = last.End
+		health.LastOutput = strings.TrimSpace(last.Output)
+	}
+	return health, nil
+}
+
+// HealthAll gathers the health of the given agents concurrently, in the order of
+// agentIDs; failures are reported per agent
+func (m *Manager) HealthAll(agentIDs []string) []AgentHealth {
+	results := make([]AgentHealth, len(agentIDs))
+	forEachParallel(agentIDs, func(i int, agentID string) {
+		health, err := m.Health(agentID)
+		if err != nil {
+			results[i] = AgentHealth{AgentID: agentID, Error: err.Error()}
+			return
+		}
+		results[i] = *health
+	})
+	return results
+}
+
+// CheckHealth reports the agents whose healthcheck fails as a doctor check
+func (m *Manager) CheckHealth() DoctorCheck {
+	check := DoctorCheck{Name: "health", Status: CheckOK}
+	ids, err := m.AgentIDs()
+	if err != nil {
+		check.Status, check.Detail = CheckError, err.Error()
+		return check
+	}
+	var unhealthy []string
+	checked := 0
+	for _, health := range m.HealthAll(ids) {
+		if health.Health != HealthNone {
+			checked++
+		}
+		if health.Health == HealthUnhealthy {
+			unhealthy = append(unhealthy, health.AgentID)
+		}
+	}
+	if len(unhealthy) > 0 {
+		check.Status = CheckWarning
+		check.Detail = fmt.Sprintf("%d unhealthy agents: %s", len(unhealthy), strings.Join(unhealthy, ", "))
+		check.Hint = "see why with 'git-capsulate health <agent-id>'"
+		return check
+	}
+	check.Detail = fmt.Sprintf("%d agents with a healthcheck, all healthy or starting", checked)
+	return check
+}
@@ -0,0 +1,232 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// commandHistoryLimit is the number of recent commands kept in an agent's state and
+// run summary; older ones are only counted
+const commandHistoryLimit = 100
+
+// summaryCommitLimit is the number of commits listed in a run summary
+const summaryCommitLimit = 50
+
+// ErrNoRunSummary is returned (wrapped) by RunSummary when no summary matches
+var ErrNoRunSummary = errors.New("no run summary")
+
+// recordCommand adds a command run in an agent to its state for the run summary.
+// Agents without recorded state are skipped, and failures to record are ignored.
+func (m *Manager) recordCommand(agentID, command string, startedAt time.Time, result *ExecResult) {
+	if _, exists, err := m.store.Get(agentID); err != nil || !exists {
+		return
+	}
+	record := state.CommandRecord{
+		Command:   command,
+		ExitCode:  -1,
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt),
+	}
+	if result != nil {
+		record.ExitCode = result.ExitCode
+	}
+	m.store.Update(agentID, func(st *state.AgentState) error {
+		st.Commands = append(st.Commands, record)
+		if len(st.Commands) > commandHistoryLimit {
+			st.Commands = st.Commands[len(st.Commands)-commandHistoryLimit:]
+		}
+		st.CommandCount++
+		return nil
+	})
+}
+
+// recordStartCommit records the commit a freshly cloned agent starts from. An empty
+// repository has none, and its run summary lists no commits.
+func (m *Manager) recordStartCommit(agentID string) {
+	output, err := m.ExecArgs(agentID, repoRoot, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return
+	}
+	m.store.Update(agentID, func(st *state.AgentState) error {
+		st.StartCommit = strings.TrimSpace(output)
+		return nil
+	})
+}
+
+// summarizeRun collects the summary of an agent's run from its state and, while its
+// container still exists, its repository and resource usage. What cannot be read,
+// e.g. from a stopped container, is left out and recorded as a span event.
+func (m *Manager) summarizeRun(ctx context.Context, st *state.AgentState, spanID string) *state.RunSummary {
+	now := time.Now().UTC()
+	summary := &state.RunSummary{
+		ID:           fmt.Sprintf("%s-%s", st.ID, now.Format("20060102-150405")),
+		AgentID:      st.ID,
+		RepoURL:      st.RepoURL,
+		Branch:       st.Branch,
+		Template:     st.Template,
+		TeamID:       st.TeamID,
+		Labels:       st.Labels,
+		StartedAt:    st.CreatedAt,
+		EndedAt:      now,
+		Duration:     now.Sub(st.CreatedAt),
+		Commands:     st.Commands,
+		CommandCount: st.CommandCount,
+		StartCommit:  st.StartCommit,
+	}
+
+	summary.ChecksStatus, summary.ChecksPassed, summary.ChecksFailed = checksStatus(st.Checks)
+
+	if st.RepoURL != "" {
+		if err := m.summarizeCommits(summary); err != nil {
+			tracing.AddEvent(spanID, "summary_commits_failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+	if err := m.summarizeResources(ctx, summary); err != nil {
+		tracing.AddEvent(spanID, "summary_resources_failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+	}
+	return summary
+}
+
+// checksStatus summarizes check results as failed when any failed, passed when all
+// passed and none when there are none, with the number passed and failed
+func checksStatus(checks []state.CheckResult) (string, int, int) {
+	passed, failed := 0, 0
+	for _, check := range checks {
+		if check.Passed {
+			passed++
+		} else {
+			failed++
+		}
+	}
+	switch {
+	case failed > 0:
+		return state.ChecksFailed, passed, failed
+	case passed > 0:
+		return state.ChecksPassed, passed, failed
+	}
+	return state.ChecksNone, passed, failed
+}
+
+// summarizeCommits records the agent's HEAD and the commits made on top of its start
+func (m *Manager) summarizeCommits(summary *state.RunSummary) error {
+	head, err := m.ExecArgs(summary.AgentID, repoRoot, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	summary.HeadCommit = strings.TrimSpace(head)
+	if summary.StartCommit == "" {
+		return nil
+	}
+
+	rangeSpec := summary.StartCommit + "..HEAD"
+	count, err := m.ExecArgs(summary.AgentID, repoRoot, "git", "rev-list", "--count", rangeSpec)
+	if err != nil {
+		return fmt.Errorf("failed to count commits: %w", err)
+	}
+	summary.CommitCount, _ = strconv.Atoi(strings.TrimSpace(count))
+	if summary.CommitCount == 0 {
+		return nil
+	}
+
+	log, err := m.ExecArgs(summary.AgentID, repoRoot, "git", "log", "--format=%H%x09%s",
+		"-n", strconv.Itoa(summaryCommitLimit), rangeSpec)
+	if err != nil {
+		return fmt.Errorf("failed to list commits: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(log), "\n") {
+		sha, subject, _ := strings.Cut(line, "\t")
+		if sha != "" {
+			summary.Commits = append(summary.Commits, state.CommitRecord{SHA: sha, Subject: subject})
+		}
+	}
+	return nil
+}
+
+// summarizeResources records the lifetime resource usage of the agent's container
+func (m *Manager) summarizeResources(ctx context.Context, summary *state.RunSummary) error {
+	containerName := m.containerName(summary.AgentID)
+	info, _, err := m.dockerClient.ContainerInspectWithRaw(ctx, containerName, true)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	totals := &summary.Resources
+	if info.SizeRw != nil {
+		totals.WritableLayer = *info.SizeRw
+	}
+	if info.State == nil || !info.State.Running {
+		// Stopped containers report no usage
+		return nil
+	}
+
+	stats, err := m.dockerClient.ContainerStats(ctx, containerName, false)
+	if err != nil {
+		return fmt.Errorf("failed to get container stats: %w", err)
+	}
+	defer stats.Body.Close()
+	var statsJSON types.StatsJSON
+	if err := json.NewDecoder(stats.Body).Decode(&statsJSON); err != nil {
+		return fmt.Errorf("failed to decode container stats: %w", err)
+	}
+
+	totals.CPUSeconds = float64(statsJSON.CPUStats.CPUUsage.TotalUsage) / float64(time.Second)
+	totals.PeakMemory = int64(statsJSON.MemoryStats.MaxUsage)
+	if totals.PeakMemory == 0 {
+		totals.PeakMemory = int64(statsJSON.MemoryStats.Usage)
+	}
+	for _, entry := range statsJSON.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			totals.DiskRead += int64(entry.Value)
+		case "write":
+			totals.DiskWrite += int64(entry.Value)
+		}
+	}
+	for _, network := range statsJSON.Networks {
+		totals.NetRx += int64(network.RxBytes)
+		totals.NetTx += int64(network.TxBytes)
+	}
+	return nil
+}
+
+// History returns the summaries of finished agent runs, most recent first, limited to
+// one agent when agentID is not empty
+func (m *Manager) History(agentID string) ([]*state.RunSummary, error) {
+	summaries, err := m.store.Summaries()
+	if err != nil || agentID == "" {
+		return summaries, err
+	}
+	var matching []*state.RunSummary
+	for _, summary := range summaries {
+		if summary.AgentID == agentID {
+			matching = append(matching, summary)
+		}
+	}
+	return matching, nil
+}
+
+// RunSummary returns a run summary by its ID, or the most recent one of an agent
+func (m *Manager) RunSummary(name string) (*state.RunSummary, error) {
+	summaries, err := m.store.Summaries()
+	if err != nil {
+		return nil, err
+	}
+	for _, summary := range summaries {
+		if summary.ID == name || summary.AgentID == name {
+			return summary, nil
+		}
+	}
+	return nil, fmt.Errorf("%w for '%s'", ErrNoRunSummary, name)
+}
@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/docker/docker/errdefs"
+)
+
+// MaxAgentIDLength keeps container names and host names derived from agent IDs within
+// the 63-character DNS label limit
+const MaxAgentIDLength = 63
+
+// ErrInvalidAgentID is returned (wrapped) for agent IDs that cannot be used safely in
+// container names and paths
+var ErrInvalidAgentID = errors.New("invalid agent ID")
+
+// agentIDPattern is the charset allowed in agent IDs: letters, digits, '.', '_' and '-',
+// starting with a letter or digit
+var agentIDPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ValidateAgentID checks that an agent ID is safe to interpolate into container names,
+// paths and shell commands
+func ValidateAgentID(agentID string) error {
+	switch {
+	case agentID == "":
+		return fmt.Errorf("%w: ID is empty", ErrInvalidAgentID)
+	case len(agentID) > MaxAgentIDLength:
+		return fmt.Errorf("%w: '%s' is longer than %d characters", ErrInvalidAgentID, agentID, MaxAgentIDLength)
+	case !agentIDPattern.MatchString(agentID):
+		return fmt.Errorf("%w: '%s' must start with a letter or digit and contain only letters, digits, '.', '_' and '-'", ErrInvalidAgentID, agentID)
+	}
+	return nil
+}
+
+// Words combined into generated agent IDs
+var (
+	idAdjectives = []string{
+		"amber", "bold", "brave", "bright", "calm", "clever", "cosmic", "crisp", "eager", "fast",
+		"gentle", "golden", "happy", "keen", "lively", "lucky", "mellow", "nimble", "quiet", "rapid",
+		"silent", "steady", "sunny", "swift", "tidy", "vivid", "wise", "witty", "young", "zesty",
+	}
+	idNouns = []string{
+		"badger", "beacon", "comet", "falcon", "fern", "forge", "harbor", "heron", "lantern", "lynx",
+		"maple", "meadow", "nebula", "orbit", "otter", "panda", "pebble", "pine", "quartz", "raven",
+		"river", "rocket", "sparrow", "spruce", "summit", "tiger", "willow", "wren", "yak", "zephyr",
+	}
+)
+
+// maxGenerateAttempts bounds the retries of GenerateAgentID on collisions
+const maxGenerateAttempts = 10
+
+// GenerateAgentID returns a readable agent ID such as "brave-otter-3f2a" that is not
+// used by a recorded agent or an existing container
+func (m *Manager) GenerateAgentID() (string, error) {
+	ctx := context.Background()
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		agentID, err := randomAgentID()
+		if err != nil {
+			return "", err
+		}
+
+		if _, exists, err := m.store.Get(agentID); err != nil {
+			return "", err
+		} else if exists {
+			continue
+		}
+		_, err = m.dockerClient.ContainerInspect(ctx, m.containerName(agentID))
+		if errdefs.IsNotFound(err) {
+			return agentID, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to check container name: %w", err)
+		}
+	}
+	return "", fmt.Errorf("failed to generate a unique agent ID after %d attempts", maxGenerateAttempts)
+}
+
+// randomAgentID builds an adjective-noun-hash ID from crypto/rand
+func randomAgentID() (string, error) {
+	buf := make([]byte, 4)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate agent ID: %w", err)
+	}
+	adjective := idAdjectives[int(buf[0])%len(idAdjectives)]
+	noun := idNouns[int(buf[1])%len(idNouns)]
+	return fmt.Sprintf("%s-%s-%s", adjective, noun, hex.EncodeToString(buf[2:])), nil
+}
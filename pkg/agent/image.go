@@ -0,0 +1,243 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/dockerclient"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Outcomes of recreating one agent during an image rollout
+const (
+	RolloutRecreated = "recreated" // the agent now runs on the new base image
+	RolloutCurrent   = "current"   // the agent already ran on the base image
+	RolloutFailed    = "failed"    // recreating the agent failed
+	RolloutSkipped   = "skipped"   // not attempted because an earlier agent failed
+)
+
+// RolloutResult is the outcome of one agent in RolloutImage
+type RolloutResult struct {
+	AgentID string `json:"agent_id"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BaseImageID returns the ID of the base image of the host's platform, or "" when it
+// has not been built
+func (m *Manager) BaseImageID() (string, error) {
+	return m.imageID(m.baseImageName)
+}
+
+// RefreshBaseImage rebuilds the base image of a platform ("" for the host's) from a
+// freshly pulled images.from image, picking up new versions of Git and the toolchain.
+// Existing agents keep running on the previous image until they are recreated. The
+// IDs of the previous image ("" if there was none) and of the new one are returned.
+func (m *Manager) RefreshBaseImage(platform string) (string, string, error) {
+	ctx := context.Background()
+
+	if platform != "" {
+		normalized, err := dockerclient.NormalizePlatform(platform)
+		if err != nil {
+			return "", "", err
+		}
+		platform = normalized
+	}
+	baseImage := m.baseImageFor(platform)
+
+	m.imageMutex.Lock()
+	defer m.imageMutex.Unlock()
+
+	metrics.StartTimer("refresh_base_image", metrics.ContainerOps, "")
+	defer metrics.StopTimer("refresh_base_image", metrics.ContainerOps, "")
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.RefreshBaseImage", map[string]interface{}{
+		"image":    baseImage,
+		"platform": platform,
+	})
+
+	previous, err := m.imageID(baseImage)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return "", "", err
+	}
+	if err := m.buildBaseImage(ctx, platform); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return "", "", err
+	}
+	current, err := m.imageID(baseImage)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return "", "", err
+	}
+
+	tracing.EndSpanSuccess(spanID)
+	return previous, current, nil
+}
+
+// Recreate replaces an agent's container with a new one from the current base image.
+// The workspace, overlay diff and container-level dependencies live on the host and
+// are kept, so the repository and its uncommitted changes survive. Processes running
+// in the agent and files outside the mounted directories are lost; services marked
+// autostart are started again.
+func (m *Manager) Recreate(agentID string) error {
+	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return err
+	}
+
+	// Serialize with Create and Destroy calls for this agent
+	unlock := m.agentLocks.lock(agentID)
+	defer unlock()
+	release, err := m.store.Lock(agentID, "recreated")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	metrics.StartTimer("recreate_container", metrics.ContainerOps, agentID)
+	defer metrics.StopTimer("recreate_container", metrics.ContainerOps, agentID)
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.Recreate", map[string]interface{}{
+		"agent_id": agentID,
+	})
+
+	if err := m.recreate(ctx, agentID); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return err
+	}
+
+	metrics.RecordCount("container_recreated", metrics.ContainerOps, 1, agentID)
+	tracing.EndSpanSuccess(spanID)
+	return nil
+}
+
+// recreate does the work of Recreate; the caller holds the agent's locks
+func (m *Manager) recreate(ctx context.Context, agentID string) error {
+	st, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+	if st.Adopted {
+		return fmt.Errorf("agent '%s' was adopted from a container built outside git-capsulate and cannot be recreated", agentID)
+	}
+
+	// The agent is rebuilt from its recorded configuration; the repository is not
+	// cloned again
+	config := agentConfigFromState(st)
+
+	if err := m.ensureBaseImage(ctx, config.Platform); err != nil {
+		return err
+	}
+
+	// Remove the old container and its sidecars; the host directories it mounted are
+	// kept
+	if err := m.removeSidecars(ctx, agentID); err != nil {
+		return err
+	}
+	containerName := m.containerName(agentID)
+	err = m.dockerClient.ContainerRemove(ctx, containerName, types.ContainerRemoveOptions{Force: true})
+	if err != nil && !errdefs.IsNotFound(err) {
+		return fmt.Errorf("failed to remove container: %w", err)
+	}
+
+	if err := m.startContainer(ctx, &config); err != nil {
+		return err
+	}
+	if err := m.prepareWorkspace(config); err != nil {
+		return err
+	}
+
+	// Mirrors live in the container's global Git configuration
+	if config.RepoURL != "" {
+		if err := m.configureMirrors(agentID); err != nil {
+			return err
+		}
+	}
+
+	if err := m.relinkDependencies(agentID, st.Overrides); err != nil {
+		return err
+	}
+	return m.autostartServices(agentID)
+}
+
+// relinkDependencies links the dependency layers into an agent's container again;
+// installed overrides are kept in the host's container-level dependency directory
+func (m *Manager) relinkDependencies(agentID string, overrides []state.DependencyResolution) error {
+	providers := m.resolveProviders(agentID)
+	if _, err := m.Exec(agentID, m.generateDependencySetupScript(overrides, providers)); err != nil {
+		return fmt.Errorf("failed to set up dependencies: %w", err)
+	}
+	if err := m.store.Update(agentID, func(st *state.AgentState) error {
+		st.Providers = providerNames(providers)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to record dependency providers: %w", err)
+	}
+	return nil
+}
+
+// RolloutImage recreates the given agents one at a time so they run on the current
+// base image of their platform, skipping those already on it. It stops at the first
+// failure, leaving the remaining agents untouched; running it again resumes the
+// rollout. progress, if not nil, is called after each agent.
+func (m *Manager) RolloutImage(agentIDs []string, progress func(RolloutResult)) ([]RolloutResult, error) {
+	imageIDs := make(map[string]string)
+
+	results := make([]RolloutResult, 0, len(agentIDs))
+	failed := false
+	for _, agentID := range agentIDs {
+		platform := ""
+		if st, exists, err := m.store.Get(agentID); err == nil && exists {
+			platform = st.Platform
+		}
+		baseImage := m.baseImageFor(platform)
+		imageID, ok := imageIDs[baseImage]
+		if !ok {
+			id, err := m.imageID(baseImage)
+			if err != nil {
+				return results, err
+			}
+			imageID, imageIDs[baseImage] = id, id
+		}
+
+		result := RolloutResult{AgentID: agentID}
+		switch {
+		case failed:
+			result.Status = RolloutSkipped
+		case imageID != "" && m.containerImageID(agentID) == imageID:
+			result.Status = RolloutCurrent
+		default:
+			if err := m.Recreate(agentID); err != nil {
+				result.Status = RolloutFailed
+				result.Error = err.Error()
+				failed = true
+			} else {
+				result.Status = RolloutRecreated
+			}
+		}
+		results = append(results, result)
+		if progress != nil {
+			progress(result)
+		}
+	}
+	return results, nil
+}
+
+// containerImageID returns the ID of the image an agent's container runs, or "" if
+// it cannot be inspected
+func (m *Manager) containerImageID(agentID string) string {
+	inspect, err := m.dockerClient.ContainerInspect(context.Background(), m.containerName(agentID))
+	if err != nil {
+		return ""
+	}
+	return inspect.Image
+}
@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/your-org/capsulate-repo/pkg/dockerclient"
+)
+
+// Outcomes of a doctor check
+const (
+	CheckOK      = "ok"
+	CheckWarning = "warning"
+	CheckError   = "error"
+)
+
+// DoctorCheck is the outcome of one check of the setup git-capsulate runs in
+type DoctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	// Hint tells how to fix a failed check
+	Hint string `json:"hint,omitempty"`
+}
+
+// bindProbeTimeout bounds the container run by the bind mount check
+const bindProbeTimeout = 60 * time.Second
+
+// RuntimeEnvironment describes where git-capsulate runs relative to the Docker daemon
+func (m *Manager) RuntimeEnvironment() *dockerclient.Environment {
+	return m.runtime
+}
+
+// hostMounts translates the sources of bind mounts into paths the Docker daemon can
+// mount, which differ from the paths git-capsulate sees when it runs in a container
+// and talks to the host's daemon through a mounted socket
+func (m *Manager) hostMounts(mounts []mount.Mount) error {
+	for i := range mounts {
+		if mounts[i].Type != mount.TypeBind {
+			continue
+		}
+		source, err := m.runtime.HostPath(mounts[i].Source)
+		if err != nil {
+			return err
+		}
+		mounts[i].Source = source
+	}
+	return nil
+}
+
+// CheckInContainer checks that git-capsulate can run agents from inside a container:
+// how the daemon is reached, that the directories it bind mounts into agents are
+// visible to the daemon, and that a bind mount actually shows their content
+func (m *Manager) CheckInContainer() []DoctorCheck {
+	env := m.runtime
+	var checks []DoctorCheck
+
+	if !env.InContainer {
+		return append(checks, DoctorCheck{Name: "container", Status: CheckOK,
+			Detail: "git-capsulate runs on the Docker host; no path translation is needed"})
+	}
+	switch env.Mode {
+	case dockerclient.ModeSocket:
+		checks = append(checks, DoctorCheck{Name: "container", Status: CheckOK,
+			Detail: fmt.Sprintf("running in container %.12s with the host's Docker daemon at %s (socket mount); bind mount paths are translated through its %d mounts",
+				env.ContainerID, env.DaemonHost, len(env.Mappings))})
+	default:
+		checks = append(checks, DoctorCheck{Name: "container", Status: CheckOK,
+			Detail: fmt.Sprintf("running in a container with a Docker-in-Docker daemon at %s; bind mount paths are used as is", env.DaemonHost),
+			Hint: "if this container was started with the host's socket, the container was not found by the daemon; set " +
+				dockerclient.ContainerIDEnv + " to its ID"})
+	}
+
+	// Everything bind mounted into agents lives in these directories
+	dirs := []struct{ name, path string }{
+		{"workspace", m.workspaceDir},
+		{"data", m.dataDir},
+		{"ssh", m.sshDir},
+	}
+	for _, dir := range dirs {
+		check := DoctorCheck{Name: "path " + dir.name, Status: CheckOK}
+		hostPath, err := env.HostPath(dir.path)
+		switch {
+		case err != nil:
+			check.Status, check.Detail = CheckError, err.Error()
+			check.Hint = fmt.Sprintf("start the container with -v <host path>:%s, or -v \"$PWD:$PWD\" -w \"$PWD\" for the workspace", dir.path)
+		case hostPath != dir.path:
+			check.Detail = fmt.Sprintf("%s is %s for the daemon", dir.path, hostPath)
+		default:
+			check.Detail = fmt.Sprintf("%s is the same path for the daemon", dir.path)
+		}
+		checks = append(checks, check)
+	}
+
+	return append(checks, m.checkBindMount())
+}
+
+// checkBindMount bind mounts the data directory into a throwaway container of the base
+// image and looks for a marker file, which catches daemons that do not share paths
+// with git-capsulate, such as a Docker-in-Docker sidecar without a shared volume
+func (m *Manager) checkBindMount() DoctorCheck {
+	check := DoctorCheck{Name: "bind mount"}
+	ctx, cancel := context.WithTimeout(context.Background(), bindProbeTimeout)
+	defer cancel()
+
+	if _, _, err := m.dockerClient.ImageInspectWithRaw(ctx, m.baseImageName); err != nil {
+		check.Status = CheckWarning
+		check.Detail = fmt.Sprintf("not checked: base image %s is not built yet", m.baseImageName)
+		check.Hint = "run 'git-capsulate image refresh' and check again"
+		return check
+	}
+
+	marker, err := os.CreateTemp(m.dataDir, ".doctor-")
+	if err != nil {
+		check.Status, check.Detail = CheckError, fmt.Sprintf("failed to create marker file: %v", err)
+		return check
+	}
+	marker.Close()
+	defer os.Remove(marker.Name())
+
+	mounts := []mount.Mount{{Type: mount.TypeBind, Source: m.dataDir, Target: "/probe", ReadOnly: true}}
+	if err := m.hostMounts(mounts); err != nil {
+		check.Status, check.Detail = CheckError, err.Error()
+		return check
+	}
+	resp, err := m.dockerClient.ContainerCreate(ctx,
+		&container.Config{
+			Image: m.baseImageName,
+			Cmd:   []string{"test", "-f", "/probe/" + filepath.Base(marker.Name())},
+		},
+		&container.HostConfig{Mounts: mounts},
+		nil, nil, "")
+	if err != nil {
+		check.Status, check.Detail = CheckError, fmt.Sprintf("failed to create probe container: %v", err)
+		return check
+	}
+	defer m.dockerClient.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+
+	if err := m.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		check.Status, check.Detail = CheckError, fmt.Sprintf("failed to start probe container: %v", err)
+		return check
+	}
+	statusCh, errCh := m.dockerClient.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		check.Status, check.Detail = CheckError, fmt.Sprintf("failed to wait for probe container: %v", err)
+	case status := <-statusCh:
+		if status.StatusCode == 0 {
+			check.Status = CheckOK
+			check.Detail = fmt.Sprintf("files written to %s are visible in agent containers", m.dataDir)
+		} else {
+			check.Status = CheckError
+			check.Detail = fmt.Sprintf("files written to %s are not visible in agent containers (mounted from %s)", m.dataDir, mounts[0].Source)
+			check.Hint = "with a Docker-in-Docker sidecar, mount the workspace volume at the same path in both containers; " +
+				"with the host's socket, mount the workspace from the host"
+		}
+	}
+	return check
+}
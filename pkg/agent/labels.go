@@ -0,0 +1,155 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// LabelPrefix namespaces agent labels among the Docker labels of a container
+const LabelPrefix = "capsulate.label."
+
+// labelKeyPattern restricts label keys to a safe set usable in Docker labels
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._/-]*[A-Za-z0-9])?$`)
+
+// ParseLabels parses "key=value" entries into a label map
+func ParseLabels(entries []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, entry := range entries {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label '%s': expected key=value", entry)
+		}
+		key = strings.TrimSpace(key)
+		if !labelKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid label key '%s'", key)
+		}
+		labels[key] = strings.TrimSpace(value)
+	}
+	return labels, nil
+}
+
+// FormatLabels renders labels as sorted "key=value" pairs separated by commas
+func FormatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for key, value := range labels {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// selectorTerm is a single requirement of a Selector
+type selectorTerm struct {
+	key   string
+	value string
+	op    string // "=", "!=", "exists" or "!exists"
+}
+
+// Selector filters agents by their labels. The syntax is a comma-separated list of
+// requirements that must all hold: key=value, key!=value, key (label is set) and
+// !key (label is not set).
+type Selector struct {
+	terms []selectorTerm
+}
+
+// ParseSelector parses a selector expression; an empty expression matches everything
+func ParseSelector(expr string) (*Selector, error) {
+	selector := &Selector{}
+	for _, part := range strings.Split(expr, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		var term selectorTerm
+		switch {
+		case strings.Contains(part, "!="):
+			key, value, _ := strings.Cut(part, "!=")
+			term = selectorTerm{key: strings.TrimSpace(key), value: strings.TrimSpace(value), op: "!="}
+		case strings.Contains(part, "="):
+			key, value, _ := strings.Cut(part, "=")
+			term = selectorTerm{key: strings.TrimSpace(key), value: strings.TrimSpace(value), op: "="}
+		case strings.HasPrefix(part, "!"):
+			term = selectorTerm{key: strings.TrimSpace(part[1:]), op: "!exists"}
+		default:
+			term = selectorTerm{key: part, op: "exists"}
+		}
+		if !labelKeyPattern.MatchString(term.key) {
+			return nil, fmt.Errorf("invalid selector '%s'", part)
+		}
+		selector.terms = append(selector.terms, term)
+	}
+	return selector, nil
+}
+
+// Matches reports whether a label set satisfies every requirement of the selector
+func (s *Selector) Matches(labels map[string]string) bool {
+	for _, term := range s.terms {
+		value, ok := labels[term.key]
+		switch term.op {
+		case "=":
+			if !ok || value != term.value {
+				return false
+			}
+		case "!=":
+			if ok && value == term.value {
+				return false
+			}
+		case "exists":
+			if !ok {
+				return false
+			}
+		case "!exists":
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ListAgents returns the recorded state of the agents matching a selector, sorted by ID
+func (m *Manager) ListAgents(selector string) ([]*state.AgentState, error) {
+	sel, err := ParseSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	states, err := m.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var matched []*state.AgentState
+	for _, st := range states {
+		if sel.Matches(st.Labels) {
+			matched = append(matched, st)
+		}
+	}
+	return matched, nil
+}
+
+// SelectAgentIDs returns the IDs of the agents matching a selector, sorted
+func (m *Manager) SelectAgentIDs(selector string) ([]string, error) {
+	states, err := m.ListAgents(selector)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(states))
+	for _, st := range states {
+		ids = append(ids, st.ID)
+	}
+	return ids, nil
+}
+
+// dockerLabels converts agent labels into the Docker labels of its container
+func dockerLabels(agentID string, labels map[string]string) map[string]string {
+	result := map[string]string{"capsulate.agent-id": agentID}
+	for key, value := range labels {
+		result[LabelPrefix+key] = value
+	}
+	return result
+}
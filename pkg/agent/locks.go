@@ -0,0 +1,44 @@
+package agent
+
+import "sync"
+
+// agentLocks serializes lifecycle operations on the same agent ID within a process.
+// Each ID gets its own mutex, created on first use and dropped when nobody holds or
+// waits for it, so operations on different agents never block each other.
+type agentLocks struct {
+	mutex sync.Mutex
+	locks map[string]*agentLock
+}
+
+// agentLock is the mutex of one agent ID with the number of holders and waiters
+type agentLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock blocks until the agent's lock is held and returns the function releasing it
+func (l *agentLocks) lock(agentID string) func() {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*agentLock)
+	}
+	entry, exists := l.locks[agentID]
+	if !exists {
+		entry = &agentLock{}
+		l.locks[agentID] = entry
+	}
+	entry.refs++
+	l.mutex.Unlock()
+
+	entry.Lock()
+	return func() {
+		entry.Unlock()
+
+		l.mutex.Lock()
+		entry.refs--
+		if entry.refs == 0 {
+			delete(l.locks, agentID)
+		}
+		l.mutex.Unlock()
+	}
+}
@@ -0,0 +1,128 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// DefaultReflogExpire is how long reflog entries are kept by scheduled maintenance
+const DefaultReflogExpire = "30.days"
+
+// reflogExpirePattern matches the approxidates accepted for reflog expiry, e.g.
+// 30.days, 2.weeks.ago, now or never
+var reflogExpirePattern = regexp.MustCompile(`^([0-9]+\.[a-z]+(\.ago)?|now|never|all)$`)
+
+// MaintenanceCommand returns the command scheduled to keep an agent's repository
+// compact: reflog entries older than reflogExpire are pruned, then 'git maintenance
+// run --auto' repacks and collects garbage when needed. When it fails, e.g. with Git
+// before 2.29, which has no maintenance command, 'git gc --auto' runs instead.
+func MaintenanceCommand(reflogExpire string) ([]string, error) {
+	if reflogExpire == "" {
+		reflogExpire = DefaultReflogExpire
+	}
+	if !reflogExpirePattern.MatchString(reflogExpire) {
+		return nil, fmt.Errorf("invalid reflog expiry '%s': use e.g. 30.days, 2.weeks, now or never", reflogExpire)
+	}
+	script := fmt.Sprintf("git reflog expire --expire=%s --all && "+
+		"{ git maintenance run --auto --quiet 2>/dev/null || git gc --auto --quiet; }", reflogExpire)
+	return []string{"sh", "-c", script}, nil
+}
+
+// IsMaintenanceCommand reports whether a scheduled command was built by
+// MaintenanceCommand
+func IsMaintenanceCommand(command []string) bool {
+	return len(command) == 3 && command[0] == "sh" && strings.HasPrefix(command[2], "git reflog expire --expire=")
+}
+
+// RepoStats measures the object storage of an agent's repository
+type RepoStats struct {
+	AgentID string `json:"agent_id"`
+	// Objects is the number of objects, loose and packed
+	Objects int64 `json:"objects"`
+	// LooseObjects and LooseSize are the objects not yet packed and their size
+	LooseObjects int64 `json:"loose_objects"`
+	LooseSize    int64 `json:"loose_size"`
+	// PackedObjects, Packs and PackSize describe the packfiles
+	PackedObjects int64 `json:"packed_objects"`
+	Packs         int64 `json:"packs"`
+	PackSize      int64 `json:"pack_size"`
+	// PrunePackable are loose objects already in a pack, Garbage files git does not
+	// recognize in the object directory
+	PrunePackable int64 `json:"prune_packable"`
+	Garbage       int64 `json:"garbage"`
+	GarbageSize   int64 `json:"garbage_size"`
+	Refs          int64 `json:"refs"`
+}
+
+// RepoStats reports the object count, loose objects and pack size of an agent's
+// repository, from 'git count-objects'. The agent's container must be running.
+func (m *Manager) RepoStats(agentID string) (*RepoStats, error) {
+	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+
+	_, spanID := tracing.StartSpan(ctx, "agent.RepoStats", map[string]interface{}{
+		"agent_id": agentID,
+	})
+
+	stats, err := m.repoStats(agentID)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	tracing.EndSpanSuccess(spanID)
+	return stats, nil
+}
+
+// repoStats does the work of RepoStats
+func (m *Manager) repoStats(agentID string) (*RepoStats, error) {
+	if hasRepo, err := m.hasRepository(agentID); err != nil {
+		return nil, agentError(agentID, err)
+	} else if !hasRepo {
+		return nil, fmt.Errorf("agent '%s' has %w", agentID, ErrNoRepository)
+	}
+	output, err := m.ExecArgs(agentID, repoRoot, "git", "count-objects", "-v")
+	if err != nil {
+		return nil, fmt.Errorf("failed to count objects: %w", err)
+	}
+
+	stats := &RepoStats{AgentID: agentID}
+	// Sizes are reported in KiB
+	fields := map[string]*int64{
+		"count":          &stats.LooseObjects,
+		"size":           &stats.LooseSize,
+		"in-pack":        &stats.PackedObjects,
+		"packs":          &stats.Packs,
+		"size-pack":      &stats.PackSize,
+		"prune-packable": &stats.PrunePackable,
+		"garbage":        &stats.Garbage,
+		"size-garbage":   &stats.GarbageSize,
+	}
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if field, known := fields[strings.TrimSpace(key)]; ok && known {
+			*field, _ = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		}
+	}
+	stats.LooseSize *= 1024
+	stats.PackSize *= 1024
+	stats.GarbageSize *= 1024
+	stats.Objects = stats.LooseObjects + stats.PackedObjects
+
+	refs, err := m.ExecArgs(agentID, repoRoot, "git", "for-each-ref", "--format=%(refname)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	if refs = strings.TrimSpace(refs); refs != "" {
+		stats.Refs = int64(strings.Count(refs, "\n") + 1)
+	}
+	return stats, nil
+}
@@ -1,20 +1,35 @@
 package agent
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/your-org/capsulate-repo/pkg/artifacts"
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/deps"
+	"github.com/your-org/capsulate-repo/pkg/dockerclient"
 	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/notify"
+	"github.com/your-org/capsulate-repo/pkg/schedule"
+	"github.com/your-org/capsulate-repo/pkg/state"
 	"github.com/your-org/capsulate-repo/pkg/tracing"
+	"github.com/your-org/capsulate-repo/pkg/webhook"
 )
 
 // AgentConfig holds configuration for a git-isolate agent
@@ -22,63 +37,220 @@ type AgentConfig struct {
 	ID              string
 	DependencyLevel string // "core", "team", or "container"
 	TeamID          string // Team identifier for team-level dependencies
+	TeamSnapshot    string // Team dependency snapshot (ID or label) to pin instead of the live layer
 	OverrideDeps    []string
 	UseOverlay      bool
+	OverlayMount    string // How the overlay workspace is mounted, kernel or fuse; resolved by Create
+	StorageDriver   string // Where the overlay workspace's layer is stored, overlay, btrfs or zfs; resolved by Create
+	Template        string // Name of the template the agent was created from
+	Labels          map[string]string // Arbitrary key=value labels used to select agents
 	// Git repository configuration
 	RepoURL         string // URL of Git repository to clone
 	Branch          string // Branch to checkout
+	Commit          string // Commit to check out as a detached HEAD instead of a branch
 	Depth           int    // Depth for shallow clones
 	GitConfig       map[string]string // Git configuration to apply
+	SSHAcceptNew    bool   // Trust SSH host keys seen for the first time
+	FromDir         string // Host checkout mounted as the repository instead of cloning RepoURL
+	// SSH server for remote IDEs, rsync and scp
+	SSHServer       bool   // Run sshd in the agent, publishing port 22 on the host
+	SSHServerPort   int    // Host port of the SSH server; 0 lets Docker assign one
+	SSHAuthorizedKey string // Public key allowed to log in; a key from the SSH directory by default
+	// Monorepo scoping
+	Path            string // Repository subdirectory used as the default working directory
+	Sparse          bool   // Limit the checkout to Path with sparse checkout
+	// KeepOnFailure leaves the container, state and directories of a failed create
+	// in place for debugging instead of rolling them back
+	KeepOnFailure   bool
+	// Image runs the container on this image, e.g. the ID recorded in a manifest,
+	// instead of the base image. Recreate moves the agent back to the base image.
+	Image           string
+	// Platform runs the agent on another architecture than the Docker host's, e.g.
+	// linux/amd64 on an arm64 host, emulated with qemu; empty for the host's
+	Platform        string
+	// SkipCapacityCheck creates the agent without checking that the host has room
+	// for it, e.g. when a batch was checked as a whole
+	SkipCapacityCheck bool
+	// phases receives the duration of the steps of Create measured by benchmarks
+	phases map[string]time.Duration
 }
 
 // GitStatus represents the status of a Git repository in an agent
 type GitStatus struct {
-	Branch          string
-	CurrentCommit   string
-	ModifiedFiles   []string
-	UntrackedFiles  []string
-	AheadCount      int
-	BehindCount     int
+	Branch          string   `json:"branch"` // empty when HEAD is detached
+	CurrentCommit   string   `json:"commit"`
+	PinnedCommit    string   `json:"pinned_commit,omitempty"` // commit the agent was created at with --commit
+	Upstream        string   `json:"upstream,omitempty"`
+	Tracking        string   `json:"tracking"` // one of the Tracking* states
+	Detached        bool     `json:"detached"`
+	Dirty           bool     `json:"dirty"`
+	StagedFiles     []string `json:"staged_files"`
+	ModifiedFiles   []string `json:"modified_files"`
+	UntrackedFiles  []string `json:"untracked_files"`
+	ConflictedFiles []string `json:"conflicted_files"`
+	StashCount      int      `json:"stash_count"`
+	AheadCount      int      `json:"ahead"`
+	BehindCount     int      `json:"behind"`
+	NoRepository    bool     `json:"no_repository,omitempty"` // the agent was created without a repository
 }
 
-// Manager manages Docker containers for git-isolate agents
+// Upstream tracking states reported in GitStatus.Tracking. Ahead and behind counts
+// are only meaningful for the in-sync, ahead, behind and diverged states.
+const (
+	TrackingNone     = "none"     // no upstream is configured (or HEAD is detached)
+	TrackingGone     = "gone"     // the upstream is configured but has not been pushed or was deleted
+	TrackingInSync   = "in-sync"  // the branch and its upstream point at the same commit
+	TrackingAhead    = "ahead"    // the branch has commits its upstream lacks
+	TrackingBehind   = "behind"   // the upstream has commits the branch lacks
+	TrackingDiverged = "diverged" // both have commits the other lacks
+)
+
+// ExitError is returned by Exec when a command runs but exits with a non-zero code
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("command exited with code %d", e.Code)
+}
+
+// Manager manages Docker containers for git-isolate agents.
+//
+// A Manager is safe for concurrent use, e.g. by a long-running daemon serving several
+// clients. Its configuration is fixed when it is created; agent state is guarded by the
+// state store and metrics and tracing by their own locks. Create and Destroy calls for
+// the same agent ID are serialized, so a Destroy waits for an in-flight Create to finish
+// and a second Create fails cleanly with "already exists". Operations on different
+// agents run in parallel. Exec-based operations (Git commands, sync, checks) are not
+// serialized; callers running several against one agent get Git's own locking.
 type Manager struct {
 	dockerClient  *client.Client
+	dockerPing    types.Ping // daemon capabilities probed at startup
+	// Where git-capsulate runs relative to the daemon, translating bind mount paths
+	runtime       *dockerclient.Environment
+	// Platform of the daemon's containers, read once by HostPlatform
+	hostPlatform     string
+	hostPlatformErr  error
+	hostPlatformOnce sync.Once
+	// Whether fuse-overlayfs works in containers of an image, keyed by image; see fuseWorks
+	fuseProbes       sync.Map
 	baseImageName string
 	sshDir        string
 	workspaceDir  string
+	// Project isolating the agents, and the directory holding their state and workspaces
+	project       string
+	dataDir       string
 	// Dependency and file system management
 	coreDepsPath     string
-	teamDepsPath     map[string]string
 	containerDepsPath string
 	// OverlayFS paths
 	baseRepoPath     string
 	diffsPath        string
 	workPath         string
+	// Project configuration and persisted agent state
+	config           *config.Config
+	store            *state.Store
+	artifacts        *artifacts.Store
+	schedules        *schedule.Store
+	// Webhooks and notifiers told of lifecycle events, and the handler of failed deliveries
+	webhooks         *webhook.Dispatcher
+	notifyError      func(error)
+	capacityWarning  func(error)
+	// Per-agent locks serializing Create and Destroy, and the base image build
+	agentLocks       agentLocks
+	imageMutex       sync.Mutex
+	// Images whose signature was checked, see verifyImage
+	verifiedImages   sync.Map
 }
 
 // NewManager creates a new Manager instance
 func NewManager(sshDir, workspaceDir string) (*Manager, error) {
-	// Initialize Docker client
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv)
+	// Initialize Docker client and check the daemon is recent enough
+	dockerClient, err := dockerclient.New()
+	if err != nil {
+		return nil, err
+	}
+	dockerPing, err := dockerclient.Probe(context.Background(), dockerClient)
+	if err != nil {
+		return nil, err
+	}
+	runtime, err := dockerclient.Detect(context.Background(), dockerClient)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Docker client: %v", err)
+		return nil, err
+	}
+
+	// Load project configuration (capsulate.yaml)
+	cfg, err := config.Load(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Export traces in the format of capsulate.yaml
+	if err := tracing.SetFormat(cfg.Tracing.Format, cfg.Tracing.ServiceName); err != nil {
+		return nil, err
+	}
+
+	// Count timer durations in the histogram buckets of capsulate.yaml
+	metrics.SetBuckets("", config.BucketDurations(cfg.Metrics.Buckets))
+	for operation, bounds := range cfg.Metrics.Timers {
+		metrics.SetBuckets(operation, config.BucketDurations(bounds))
+	}
+
+	// Upgrade state written by older releases before reading it
+	if _, err := state.Migrate(workspaceDir); err != nil {
+		return nil, err
+	}
+
+	// Agents of other projects sharing the Docker host and workspace are invisible
+	project, err := config.ResolveProject(cfg)
+	if err != nil {
+		return nil, err
+	}
+	dataDir := config.DataDir(workspaceDir, project)
+
+	// Open the agent state store
+	store, err := state.NewStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the artifact store keeping check output beyond the life of agents
+	artifactStore, err := artifacts.NewStore(dataDir, artifacts.Retention{
+		KeepRuns: cfg.Artifacts.KeepRuns,
+		MaxAge:   time.Duration(cfg.Artifacts.MaxAge),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the store of scheduled commands
+	scheduleStore, err := schedule.NewStore(dataDir)
+	if err != nil {
+		return nil, err
 	}
 
 	// Initialize manager
 	m := &Manager{
 		dockerClient:     dockerClient,
+		dockerPing:       dockerPing,
+		runtime:          runtime,
 		baseImageName:    "capsulate-base:latest",
 		sshDir:           sshDir,
 		workspaceDir:     workspaceDir,
-		// Default paths for dependency management
+		project:          project,
+		dataDir:          dataDir,
+		// Default paths for dependency management; per-agent layers belong to the project
 		coreDepsPath:     filepath.Join(workspaceDir, ".capsulate", "dependencies", "core"),
-		teamDepsPath:     make(map[string]string),
-		containerDepsPath: filepath.Join(workspaceDir, ".capsulate", "dependencies", "container"),
+		containerDepsPath: filepath.Join(dataDir, "dependencies", "container"),
 		// Default paths for OverlayFS
 		baseRepoPath:     filepath.Join(workspaceDir, ".capsulate", "overlay", "base"),
-		diffsPath:        filepath.Join(workspaceDir, ".capsulate", "overlay", "diffs"),
-		workPath:         filepath.Join(workspaceDir, ".capsulate", "overlay", "work"),
+		diffsPath:        filepath.Join(dataDir, "overlay", "diffs"),
+		workPath:         filepath.Join(dataDir, "overlay", "work"),
+		config:           cfg,
+		store:            store,
+		artifacts:        artifactStore,
+		schedules:        scheduleStore,
+		webhooks:         webhook.NewDispatcher(append(append([]config.WebhookConfig{}, cfg.Webhooks...), notify.Webhooks(cfg.Notifiers)...)),
 	}
 
 	// Ensure directories exist
@@ -91,16 +263,59 @@ func NewManager(sshDir, workspaceDir string) (*Manager, error) {
 	}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
-			return nil, fmt.Errorf("failed to create directory %s: %v", dir, err)
+			return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
 		}
 	}
 
 	return m, nil
 }
 
+// Config returns the project configuration the manager was created with
+func (m *Manager) Config() *config.Config {
+	return m.config
+}
+
+// State returns the store holding persisted agent state
+func (m *Manager) State() *state.Store {
+	return m.store
+}
+
+// Artifacts returns the store holding the output and artifacts of check runs
+func (m *Manager) Artifacts() *artifacts.Store {
+	return m.artifacts
+}
+
+// Schedules returns the store holding the commands scheduled in agents
+func (m *Manager) Schedules() *schedule.Store {
+	return m.schedules
+}
+
 // Create creates a new agent container
-func (m *Manager) Create(config AgentConfig) error {
+func (m *Manager) Create(config AgentConfig) (err error) {
 	ctx := context.Background()
+
+	if err := ValidateAgentID(config.ID); err != nil {
+		return err
+	}
+
+	// Webhooks are told once the agent is unlocked, so that a slow endpoint does not
+	// hold up other commands on it
+	created := false
+	defer func() {
+		if created {
+			m.notifyCreated(config)
+		}
+	}()
+
+	// Serialize with other Create and Destroy calls for this agent, in this process
+	// and across processes on the host
+	unlock := m.agentLocks.lock(config.ID)
+	defer unlock()
+	release, err := m.store.Lock(config.ID, "created")
+	if err != nil {
+		return err
+	}
+	defer release()
 	
 	// Start metrics timer
 	metrics.StartTimer("create_container", metrics.ContainerOps, config.ID)
@@ -118,17 +333,143 @@ func (m *Manager) Create(config AgentConfig) error {
 			panic(r) // Re-throw the panic
 		}
 	}()
+	// A failed create ends the trace with the error recorded on it
+	defer func() {
+		if err != nil {
+			tracing.RecordError(spanID, err)
+			tracing.EndSpanError(spanID, err.Error())
+		}
+	}()
+
+	// Validate dependency overrides before creating anything
+	for _, spec := range config.OverrideDeps {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		if _, err := deps.ParseOverride(spec); err != nil {
+			return err
+		}
+	}
+
+	// Reject repository arguments git would parse as options
+	if config.RepoURL != "" {
+		if err := checkArg("repository URL", config.RepoURL); err != nil {
+			return err
+		}
+	}
+	if config.Branch != "" {
+		if err := checkArg("branch name", config.Branch); err != nil {
+			return err
+		}
+	}
+	if config.Commit != "" {
+		if config.Branch != "" {
+			return fmt.Errorf("a branch and a commit cannot both be checked out")
+		}
+		if config.RepoURL == "" {
+			return fmt.Errorf("pinning a commit needs a repository")
+		}
+		if !commitSHAPattern.MatchString(config.Commit) {
+			return fmt.Errorf("invalid commit '%s': expected a hexadecimal commit SHA", config.Commit)
+		}
+	}
+	for key := range config.GitConfig {
+		if err := checkArg("Git config key", key); err != nil {
+			return err
+		}
+	}
+	if config.Path != "" {
+		subdir, err := cleanRepoPath(config.Path)
+		if err != nil {
+			return err
+		}
+		config.Path = subdir
+	}
+	if config.Sparse && (config.Path == "" || config.RepoURL == "") {
+		return fmt.Errorf("sparse checkout needs both a repository and a path")
+	}
+	// Without a repository the agent is a bare tool container
+	if config.RepoURL == "" && config.FromDir == "" && (config.Branch != "" || config.Path != "" || config.Depth > 0) {
+		return fmt.Errorf("a branch, path or clone depth needs a repository")
+	}
+	if config.FromDir != "" {
+		if config.RepoURL != "" || config.Branch != "" || config.Commit != "" || config.UseOverlay {
+			return fmt.Errorf("an existing checkout is used as it is, without a repository, branch, commit or overlay workspace")
+		}
+		if err := m.inspectCheckout(&config); err != nil {
+			return err
+		}
+	}
+	if config.Template != "" && len(m.config.Templates) > 0 {
+		if _, ok := m.config.Templates[config.Template]; !ok {
+			return fmt.Errorf("unknown template '%s': not defined under templates in %s", config.Template, m.config.Path())
+		}
+	}
+	if config.SSHServer {
+		if config.SSHServerPort < 0 || config.SSHServerPort > 65535 {
+			return fmt.Errorf("invalid SSH server port %d", config.SSHServerPort)
+		}
+		key, err := m.authorizedKey(config.SSHAuthorizedKey)
+		if err != nil {
+			return err
+		}
+		config.SSHAuthorizedKey = key
+	}
+
+	if config.Platform != "" {
+		platform, err := dockerclient.NormalizePlatform(config.Platform)
+		if err != nil {
+			return err
+		}
+		config.Platform = platform
+	}
+
+	// Overlay workspaces need a daemon that can mount OverlayFS in the container
+	if config.UseOverlay {
+		if err := dockerclient.Require(m.dockerPing, dockerclient.Overlay); err != nil {
+			return err
+		}
+	}
 
 	// Ensure base image exists
-	m.ensureBaseImage(ctx)
+	m.ensureBaseImage(ctx, config.Platform)
+
+	// Pick where the overlay workspace's layer is stored and, for OverlayFS, how it
+	// is mounted, probing whether FUSE works
+	if config.UseOverlay {
+		driver, err := m.storageDriverFor()
+		if err != nil {
+			return err
+		}
+		config.StorageDriver = driver
+		tracing.AddEvent(spanID, "storage_driver_selected", map[string]interface{}{"driver": driver})
+	}
+	if config.UseOverlay && !snapshotStorage(config.StorageDriver) {
+		mode, err := m.overlayMountMode(ctx, config)
+		if err != nil {
+			return err
+		}
+		config.OverlayMount = mode
+		tracing.AddEvent(spanID, "overlay_mount_selected", map[string]interface{}{"mount": mode})
+	}
+
+	// A pinned image is never rebuilt, so it must still be on the host
+	if config.Image != "" {
+		if _, _, err := m.dockerClient.ImageInspectWithRaw(ctx, config.Image); err != nil {
+			if errdefs.IsNotFound(err) {
+				return fmt.Errorf("image %s is no longer on the host; it may have been pruned", config.Image)
+			}
+			return fmt.Errorf("failed to inspect image %s: %w", config.Image, err)
+		}
+	}
 
 	// Container name based on agent ID
-	containerName := fmt.Sprintf("capsulate-%s", config.ID)
+	containerName := m.containerName(config.ID)
 
 	// Check if container already exists
 	containers, err := m.dockerClient.ContainerList(ctx, types.ContainerListOptions{All: true})
 	if err != nil {
-		return fmt.Errorf("failed to list containers: %v", err)
+		return fmt.Errorf("failed to list containers: %w", err)
 	}
 
 	for _, c := range containers {
@@ -139,10 +480,214 @@ func (m *Manager) Create(config AgentConfig) error {
 		}
 	}
 
+	// Refuse an agent the host has no disk, memory or CPU left for
+	if !config.SkipCapacityCheck {
+		if err := m.checkCapacity(1, config); err != nil {
+			return err
+		}
+	}
+
+	// Refuse an agent the caller has no quota left for
+	if err := m.reserveCreate(config.ID); err != nil {
+		return err
+	}
+
+	// From here on, a failed step rolls back the container, state and directories
+	// made by the earlier ones, unless they are kept for debugging
+	var undo rollback
+	defer func() {
+		if err == nil || config.KeepOnFailure {
+			return
+		}
+		tracing.AddEvent(spanID, "create_rolled_back", map[string]interface{}{
+			"error": err.Error(),
+		})
+		if rollbackErr := undo.run(); rollbackErr != nil {
+			err = fmt.Errorf("%w; %v", err, rollbackErr)
+		}
+	}()
+	undo.addDirs(m.agentDirs(config.ID)...)
+	if snapshotStorage(config.StorageDriver) {
+		undo.add("storage layer", func() error {
+			return m.storage(config.StorageDriver).remove(config.ID)
+		})
+	}
+	undo.addContainer(m, config.ID)
+
+	// Create and start the container
+	if err := m.startContainer(ctx, &config); err != nil {
+		return err
+	}
+
+	// Record the agent in the state store
+	undo.add("state", func() error {
+		return m.store.Delete(config.ID)
+	})
+	now := time.Now()
+	if err := m.store.Save(&state.AgentState{
+		ID:              config.ID,
+		CreatedAt:       now,
+		RepoURL:         config.RepoURL,
+		Branch:          config.Branch,
+		Commit:          config.Commit,
+		DependencyLevel: config.DependencyLevel,
+		TeamID:          config.TeamID,
+		TeamSnapshot:    config.TeamSnapshot,
+		UseOverlay:      config.UseOverlay,
+		OverlayMount:    config.OverlayMount,
+		StorageDriver:   config.StorageDriver,
+		Template:        config.Template,
+		Labels:          config.Labels,
+		Path:            config.Path,
+		Sparse:          config.Sparse,
+		SSHAcceptNew:    config.SSHAcceptNew,
+		SourceDir:       config.FromDir,
+		SSHServer:       config.SSHServer,
+		SSHServerPort:   config.SSHServerPort,
+		SSHAuthorizedKey: config.SSHAuthorizedKey,
+		Platform:        config.Platform,
+	}); err != nil {
+		return fmt.Errorf("failed to record agent state: %w", err)
+	}
+
+	// Prepare the repository directory, on the overlay filesystem if requested
+	if err := m.prepareWorkspace(config); err != nil {
+		return err
+	}
+
+	// Setup Git repository if URL is provided; an existing checkout is mounted instead
+	if config.FromDir != "" {
+		m.recordStartCommit(config.ID)
+	} else if config.RepoURL != "" {
+		cloneStart := time.Now()
+		if err := m.setupGitRepository(config, spanID); err != nil {
+			return err
+		}
+		m.recordStartCommit(config.ID)
+		if config.phases != nil {
+			config.phases[BenchClone] = time.Since(cloneStart)
+		}
+	}
+
+	// Install the container-level overrides, then link the dependency layers
+	// for the providers the project uses
+	providers := m.resolveProviders(config.ID)
+	overrides, err := m.installOverrides(config.ID, providers, config.OverrideDeps)
+	if err != nil {
+		return fmt.Errorf("failed to install dependency overrides: %w", err)
+	}
+	depSetupCmd := m.generateDependencySetupScript(overrides, providers)
+	_, err = m.Exec(config.ID, depSetupCmd)
+	if err != nil {
+		return fmt.Errorf("failed to set up dependencies: %w", err)
+	}
+	if err := m.store.Update(config.ID, func(st *state.AgentState) error {
+		st.Providers = providerNames(providers)
+		st.Overrides = overrides
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to record dependency providers: %w", err)
+	}
+	m.recordDependencyCacheUse(config, providers)
+
+	if err := m.autostartServices(config.ID); err != nil {
+		return err
+	}
+
+	// Record successful operation
+	tracing.EndSpanSuccess(spanID)
+	created = true
+	return nil
+}
+
+// agentDirs returns the host directories holding an agent's repository, overlay
+// layers and installed dependencies
+func (m *Manager) agentDirs(agentID string) []string {
+	return []string{
+		filepath.Join(m.dataDir, "workspaces", agentID),
+		filepath.Join(m.diffsPath, agentID),
+		filepath.Join(m.workPath, agentID),
+		filepath.Join(m.containerDepsPath, agentID),
+	}
+}
+
+// containerSpec is the Docker configuration of an agent container and the files
+// injected into it before it starts
+type containerSpec struct {
+	config        *container.Config
+	hostConfig    *container.HostConfig
+	registryCreds *registryCredentials
+	sshFiles      map[string]string
+	// sshdFiles configure the agent's SSH server, if it runs one
+	sshdFiles map[string]string
+	// platform is the agent's platform when it is not the host's
+	platform *ocispec.Platform
+}
+
+// startContainer creates and starts the container of an agent from the base image,
+// with the workspace and dependency mounts its configuration calls for, followed by
+// its template's sidecars. A team snapshot label in config is resolved to the
+// snapshot's ID.
+func (m *Manager) startContainer(ctx context.Context, config *AgentConfig) error {
+	spec, err := m.containerSpec(config)
+	if err != nil {
+		return err
+	}
+
+	// Create container
+	resp, err := m.dockerClient.ContainerCreate(ctx, spec.config, spec.hostConfig, nil, spec.platform, m.containerName(config.ID))
+	if err != nil {
+		return fmt.Errorf("failed to create container: %w", err)
+	}
+
+	// Inject private registry credentials into the home directory of the agent
+	if err := m.injectRegistryCredentials(ctx, resp.ID, "/root", 0, 0, spec.registryCreds); err != nil {
+		return err
+	}
+
+	// Provision known hosts so clones neither prompt nor hang on new hosts
+	if err := m.injectFiles(ctx, resp.ID, spec.sshFiles, 0644); err != nil {
+		return err
+	}
+
+	// Authorize the key that may log in to the SSH server
+	if len(spec.sshdFiles) > 0 {
+		if err := m.injectFiles(ctx, resp.ID, map[string]string{sshdConfigPath: sshdConfig}, 0644); err != nil {
+			return err
+		}
+		if err := m.injectFiles(ctx, resp.ID, spec.sshdFiles, 0600); err != nil {
+			return err
+		}
+	}
+
+	// Install the supervisor that runs the services declared in capsulate.yaml
+	if err := m.injectFiles(ctx, resp.ID, map[string]string{supervisorPath: supervisorScript}, 0755); err != nil {
+		return err
+	}
+
+	// Start container
+	if err := m.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+
+	// Start the template's sidecars in the network of the running container
+	if err := m.startSidecars(ctx, config); err != nil {
+		return err
+	}
+
+	if config.SSHServer {
+		return m.startSSHServer(ctx, config)
+	}
+	return nil
+}
+
+// containerSpec builds the container configuration of an agent, creating the host
+// directories it mounts
+func (m *Manager) containerSpec(config *AgentConfig) (*containerSpec, error) {
 	// Create agent-specific workspace directory
-	agentWorkspace := filepath.Join(m.workspaceDir, ".capsulate", "workspaces", config.ID)
+	agentWorkspace := filepath.Join(m.dataDir, "workspaces", config.ID)
 	if err := os.MkdirAll(agentWorkspace, 0755); err != nil {
-		return fmt.Errorf("failed to create agent workspace directory: %v", err)
+		return nil, fmt.Errorf("failed to create agent workspace directory: %w", err)
 	}
 
 	// Prepare volume mounts
@@ -157,37 +702,14 @@ func (m *Manager) Create(config AgentConfig) error {
 	
 	// Add workspace mount - either direct or via overlay
 	if config.UseOverlay {
-		// For overlay, we'll set up the separate mounts for base, diff, and work dirs
-		// Create container-specific directories
-		containerDiffPath := filepath.Join(m.diffsPath, config.ID)
-		containerWorkPath := filepath.Join(m.workPath, config.ID)
-		
-		os.MkdirAll(containerDiffPath, 0755)
-		os.MkdirAll(containerWorkPath, 0755)
-		
-		// Mount the base repo as read-only
-		mounts = append(mounts, mount.Mount{
-			Type:     mount.TypeBind,
-			Source:   m.baseRepoPath,
-			Target:   "/workspace/base",
-			ReadOnly: true,
-		})
-		
-		// Mount container-specific diff directory
-		mounts = append(mounts, mount.Mount{
-			Type:   mount.TypeBind,
-			Source: containerDiffPath,
-			Target: "/workspace/diff",
-		})
-		
-		// Mount container-specific work directory
-		mounts = append(mounts, mount.Mount{
-			Type:   mount.TypeBind,
-			Source: containerWorkPath,
-			Target: "/workspace/work",
-		})
-		
-		// We'll need an entrypoint script to set up the overlay mount inside container
+		// The agent's copy-on-write layer over the shared base, kept by its storage
+		// driver. OverlayFS is mounted inside the container once it runs, with the
+		// privileges granted by overlayHostConfig below; snapshots are mounted merged.
+		driver := m.storage(config.StorageDriver)
+		if err := driver.create(config.ID); err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, driver.mounts(config.ID)...)
 	} else {
 		// Without overlay, mount workspace directory directly
 		mounts = append(mounts, mount.Mount{
@@ -195,6 +717,14 @@ func (m *Manager) Create(config AgentConfig) error {
 			Source: agentWorkspace,
 			Target: "/workspace",
 		})
+		// An existing checkout is the repository itself
+		if config.FromDir != "" {
+			mounts = append(mounts, mount.Mount{
+				Type:   mount.TypeBind,
+				Source: config.FromDir,
+				Target: repoRoot,
+			})
+		}
 	}
 	
 	// Add dependency mounts based on isolation level
@@ -210,14 +740,20 @@ func (m *Manager) Create(config AgentConfig) error {
 	
 	// Add team deps if applicable
 	if config.DependencyLevel == "team" && config.TeamID != "" {
-		teamPath, exists := m.teamDepsPath[config.TeamID]
-		if !exists {
-			// Create team dependency path if it doesn't exist
-			teamPath = filepath.Join(m.workspaceDir, ".capsulate", "dependencies", "team", config.TeamID)
-			os.MkdirAll(teamPath, 0755)
-			m.teamDepsPath[config.TeamID] = teamPath
-		}
-		
+		// Create team dependency path if it doesn't exist
+		teamPath := m.teamLayerPath(config.TeamID)
+		os.MkdirAll(teamPath, 0755)
+
+		// Pin the agent to a frozen snapshot of the team layer if requested
+		if config.TeamSnapshot != "" {
+			snapshot, err := m.resolveTeamSnapshot(config.TeamID, config.TeamSnapshot)
+			if err != nil {
+				return nil, err
+			}
+			config.TeamSnapshot = snapshot.ID
+			teamPath = m.teamSnapshotPath(config.TeamID, snapshot.ID)
+		}
+
 		mounts = append(mounts, mount.Mount{
 			Type:     mount.TypeBind,
 			Source:   teamPath,
@@ -226,6 +762,18 @@ func (m *Manager) Create(config AgentConfig) error {
 		})
 	}
 	
+	// Add the clone cache, shared by all agents
+	if m.config.Cache.Clone {
+		if err := os.MkdirAll(m.cloneCachePath(), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create clone cache directory: %w", err)
+		}
+		mounts = append(mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: m.cloneCachePath(),
+			Target: cloneCacheMount,
+		})
+	}
+
 	// Add container-specific deps directory
 	containerDepsPath := filepath.Join(m.containerDepsPath, config.ID)
 	os.MkdirAll(containerDepsPath, 0755)
@@ -235,6 +783,11 @@ func (m *Manager) Create(config AgentConfig) error {
 		Target: "/workspace/container-deps",
 	})
 
+	// The daemon may see the directories under other paths, see RuntimeEnvironment
+	if err := m.hostMounts(mounts); err != nil {
+		return nil, err
+	}
+
 	// Prepare environment variables
 	env := []string{
 		fmt.Sprintf("AGENT_ID=%s", config.ID),
@@ -244,135 +797,263 @@ func (m *Manager) Create(config AgentConfig) error {
 		fmt.Sprintf("USE_OVERLAY=%v", config.UseOverlay),
 	}
 
-	// Create container
-	resp, err := m.dockerClient.ContainerCreate(
-		ctx,
-		&container.Config{
-			Image: m.baseImageName,
-			Cmd:   []string{"tail", "-f", "/dev/null"}, // Keep container running
-			Tty:   true,
-			Env:   env,
-		},
-		&container.HostConfig{
-			Mounts: mounts,
-		},
-		nil,
-		nil,
-		containerName,
-	)
+	// Add the layering environment of the dependency providers. The repository is
+	// not cloned yet, so without configured providers all of them are prepared.
+	envProviders, err := m.configuredProviders()
+	if err != nil {
+		return nil, err
+	}
+	if len(envProviders) == 0 {
+		for _, name := range deps.Names() {
+			provider, _ := deps.Get(name)
+			envProviders = append(envProviders, provider)
+		}
+	}
+	for _, provider := range envProviders {
+		env = append(env, provider.Env()...)
+	}
+
+	// Resolve private registry credentials before creating anything
+	registryCreds, err := m.registryCredentials()
 	if err != nil {
-		return fmt.Errorf("failed to create container: %v", err)
+		return nil, err
 	}
+	env = append(env, registryCreds.env...)
 
-	// Start container
-	if err := m.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return fmt.Errorf("failed to start container: %v", err)
+	// Render host key verification for Git over SSH
+	sshFiles, err := m.sshFiles(config.SSHAcceptNew)
+	if err != nil {
+		return nil, err
 	}
 
-	// Set up the overlay filesystem if requested
+	// The template may replace the idle primary process, e.g. with a dev server. An
+	// init process reaps zombies and forwards signals unless the template disables it.
+	template := m.config.Templates[config.Template]
+	cmd := []string{"tail", "-f", "/dev/null"} // Keep container running
+	if len(template.Command) > 0 {
+		cmd = template.Command
+	}
+	useInit := template.InitEnabled()
+
+	// Sidecars share the agent's network namespace, so their ports are published here
+	exposedPorts, portBindings, err := sidecarPorts(template.Sidecars)
+	if err != nil {
+		return nil, err
+	}
+	var sshdFiles map[string]string
+	if config.SSHServer {
+		exposedPorts, portBindings = sshPortBinding(config.SSHServerPort, exposedPorts, portBindings)
+		sshdFiles = map[string]string{authorizedKeysPath: config.SSHAuthorizedKey}
+	}
+
+	image := m.baseImageFor(config.Platform)
+	if config.Image != "" {
+		image = config.Image
+	}
+	var platform *ocispec.Platform
+	if config.Platform != "" {
+		platform = dockerclient.OCIPlatform(config.Platform)
+	}
+
+	hostConfig := &container.HostConfig{
+		Init:         &useInit,
+		Mounts:       mounts,
+		PortBindings: portBindings,
+	}
+	if config.UseOverlay && !snapshotStorage(config.StorageDriver) {
+		overlayHostConfig(hostConfig, config.OverlayMount)
+	}
+
+	return &containerSpec{
+		config: &container.Config{
+			Image:        image,
+			Entrypoint:   template.Entrypoint,
+			Cmd:          cmd,
+			Tty:          true,
+			Env:          env,
+			Labels:       m.projectLabels(dockerLabels(config.ID, config.Labels)),
+			ExposedPorts: exposedPorts,
+			Healthcheck:  healthConfig(template.Healthcheck),
+		},
+		hostConfig:    hostConfig,
+		registryCreds: registryCreds,
+		sshFiles:      sshFiles,
+		sshdFiles:     sshdFiles,
+		platform:      platform,
+	}, nil
+}
+
+// prepareWorkspace creates the repository directory of a started agent container,
+// mounting the overlay filesystem first for overlay agents, with the kernel's
+// OverlayFS or fuse-overlayfs. Snapshot layers are already mounted merged.
+func (m *Manager) prepareWorkspace(config AgentConfig) error {
 	if config.UseOverlay {
-		setupCmd := `mkdir -p /workspace/merged && 
-			mount -t overlay overlay -o lowerdir=/workspace/base,upperdir=/workspace/diff,workdir=/workspace/work /workspace/merged &&
+		setupCmd := `mkdir -p /workspace/merged/repo`
+		if !snapshotStorage(config.StorageDriver) {
+			setupCmd = `mkdir -p /workspace/merged && 
+			` + overlayMountCommand(config.OverlayMount) + ` &&
 			mkdir -p /workspace/merged/repo`
+		}
 		_, err := m.Exec(config.ID, setupCmd)
 		if err != nil {
-			return fmt.Errorf("failed to set up overlay filesystem: %v", err)
+			return fmt.Errorf("failed to set up overlay filesystem: %w", err)
 		}
 	} else {
 		// Ensure repo directory exists
 		_, err := m.Exec(config.ID, "mkdir -p /workspace/repo")
 		if err != nil {
-			return fmt.Errorf("failed to create repo directory: %v", err)
+			return fmt.Errorf("failed to create repo directory: %w", err)
 		}
 	}
-
-	// Set up dependency linking
-	depSetupCmd := m.generateDependencySetupScript(config)
-	_, err = m.Exec(config.ID, depSetupCmd)
-	if err != nil {
-		return fmt.Errorf("failed to set up dependencies: %v", err)
-	}
-
-	// Setup Git repository if URL is provided
-	if config.RepoURL != "" {
-		return m.setupGitRepository(config)
+	// Git refuses repositories owned by another user, as a host checkout is
+	if config.FromDir != "" {
+		if _, err := m.ExecArgs(config.ID, "", "git", "config", "--global", "--replace-all",
+			"safe.directory", repoRoot, "^"+regexp.QuoteMeta(repoRoot)+"$"); err != nil {
+			return fmt.Errorf("failed to trust the mounted checkout: %w", err)
+		}
 	}
-
-	// Record successful operation
-	tracing.EndSpanSuccess(spanID)
 	return nil
 }
 
 // generateDependencySetupScript creates a script to set up the dependencies inside the container
-func (m *Manager) generateDependencySetupScript(config AgentConfig) string {
-	script := `#!/bin/bash
-# Set up node_modules directory
-mkdir -p /workspace/node_modules
-
-# Link core dependencies if available
-if [ -d "/workspace/core-deps" ]; then
-    for pkg in $(find /workspace/core-deps -maxdepth 1 -type d ! -path "/workspace/core-deps"); do
-        pkg_name=$(basename $pkg)
-        # Don't link if it's in the override list
-        if [[ ! " %s " =~ " $pkg_name " ]]; then
-            ln -sf "$pkg" "/workspace/node_modules/$pkg_name"
-        fi
-    done
-fi
-
-# Link team dependencies if available
-if [ "%s" = "team" ] && [ -d "/workspace/team-deps" ]; then
-    for pkg in $(find /workspace/team-deps -maxdepth 1 -type d ! -path "/workspace/team-deps"); do
-        pkg_name=$(basename $pkg)
-        # Don't link if it's in the override list
-        if [[ ! " %s " =~ " $pkg_name " ]]; then
-            ln -sf "$pkg" "/workspace/node_modules/$pkg_name"
-        fi
-    done
-fi
-
-# Set up container-specific overrides
-if [ -n "%s" ] && [ -d "/workspace/container-deps" ]; then
-    # Install override dependencies (simplified example)
-    echo "Setting up container-specific dependencies: %s"
-    # In a real implementation, this would run npm/yarn install for those packages
-fi
-`
-	overrideDeps := strings.Join(config.OverrideDeps, " ")
-	return fmt.Sprintf(script, overrideDeps, config.DependencyLevel, overrideDeps, overrideDeps, overrideDeps)
+func (m *Manager) generateDependencySetupScript(overrides []state.DependencyResolution, providers []deps.Provider) string {
+	// Overridden packages are not linked from the shared layers
+	names := make([]string, len(overrides))
+	for i, override := range overrides {
+		names[i] = override.Name
+	}
+
+	script := deps.SetupPreamble(names)
+	for _, provider := range providers {
+		script += provider.SetupScript()
+	}
+	return script
 }
 
-// setupGitRepository initializes a Git repository in the agent container
-func (m *Manager) setupGitRepository(config AgentConfig) error {
-	// Prepare clone command with options
-	cloneCmd := fmt.Sprintf("git clone %s", config.RepoURL)
+// setupGitRepository initializes a Git repository in the agent container, recording
+// the milestones of the clone on the create span
+func (m *Manager) setupGitRepository(config AgentConfig, spanID string) error {
+	// Route clones and fetches through the configured mirrors
+	if err := m.configureMirrors(config.ID); err != nil {
+		return err
+	}
+
+	// Prepare clone command with options. The ext:: transport runs arbitrary commands
+	// and is refused even if the image's Git allows it.
+	cloneArgs := []string{"git", "-c", "protocol.ext.allow=never", "clone"}
 	
 	// Add branch option if specified
 	if config.Branch != "" {
-		cloneCmd += fmt.Sprintf(" --branch %s", config.Branch)
+		cloneArgs = append(cloneArgs, "--branch", config.Branch)
 	}
 	
 	// Add depth option if specified
 	if config.Depth > 0 {
-		cloneCmd += fmt.Sprintf(" --depth %d", config.Depth)
+		cloneArgs = append(cloneArgs, "--depth", strconv.Itoa(config.Depth))
 	}
-	
-	// Add target directory
-	cloneCmd += " /workspace/repo"
-	
-	// Execute clone command
-	_, err := m.Exec(config.ID, cloneCmd)
-	if err != nil {
-		return fmt.Errorf("failed to clone repository: %v", err)
+
+	// Borrow objects from the clone cache's mirror of the repository. --dissociate
+	// copies them, so the agent does not break when the cache is pruned. Shallow
+	// clones gain little from the cache and skip it; when the mirror cannot be
+	// updated the agent clones from the remote alone.
+	candidates := m.cloneCandidates(config.RepoURL)
+	if m.config.Cache.Clone && config.Depth == 0 {
+		mirror, err := m.cloneFromCache(config.ID, candidates[0].URL)
+		if errors.Is(err, ErrHostKeyVerification) {
+			return err
+		}
+		if err == nil {
+			cloneArgs = append(cloneArgs, "--reference-if-able", mirror, "--dissociate")
+			tracing.AddEvent(spanID, "clone_cache_used", map[string]interface{}{
+				"mirror": mirror,
+			})
+		} else {
+			tracing.RecordError(spanID, err)
+		}
 	}
 	
+	// Clone with the preferred protocol, falling back to the other one. A host key
+	// failure is never worked around by switching to HTTPS.
+	var used cloneCandidate
+	cloneStart := time.Now()
+	metrics.StartTimer("clone", metrics.GitOps, config.ID)
+	defer metrics.StopTimer("clone", metrics.GitOps, config.ID)
+	for i, candidate := range candidates {
+		tracing.AddEvent(spanID, "clone_started", map[string]interface{}{
+			"protocol": candidate.Protocol,
+			"attempt":  i + 1,
+		})
+		args := append(append([]string{}, cloneArgs...), "--", candidate.URL, "/workspace/repo")
+		output, err := m.ExecArgs(config.ID, "", args...)
+		if err == nil {
+			used = candidate
+			break
+		}
+		if hostKeyErr := hostKeyError(candidate.URL, output); hostKeyErr != nil {
+			return hostKeyErr
+		}
+		if i == len(candidates)-1 {
+			return fmt.Errorf("failed to clone repository: %w", err)
+		}
+		tracing.RecordError(spanID, err)
+		tracing.AddEvent(spanID, "clone_retry", map[string]interface{}{
+			"failed_protocol": candidate.Protocol,
+			"next_protocol":   candidates[i+1].Protocol,
+		})
+	}
+	tracing.AddEvent(spanID, "clone_completed", map[string]interface{}{
+		"protocol":    used.Protocol,
+		"duration_ms": time.Since(cloneStart).Milliseconds(),
+	})
+
+	// Record the protocol the repository was cloned with
+	if used.Protocol != "" {
+		if err := m.store.Update(config.ID, func(st *state.AgentState) error {
+			st.CloneProtocol = used.Protocol
+			st.CloneURL = used.URL
+			return nil
+		}); err != nil {
+			return fmt.Errorf("failed to record clone protocol: %w", err)
+		}
+	}
+
+	// Detach HEAD at the pinned commit
+	if config.Commit != "" {
+		if err := m.checkoutCommit(config); err != nil {
+			return err
+		}
+		tracing.AddEvent(spanID, "commit_checked_out", map[string]interface{}{
+			"commit": config.Commit,
+		})
+	}
+
+	// Scope the agent to its subdirectory
+	if config.Path != "" {
+		if err := m.setupWorkDir(config.ID, config.Path, config.Sparse); err != nil {
+			return err
+		}
+		tracing.AddEvent(spanID, "workdir_scoped", map[string]interface{}{
+			"path":   config.Path,
+			"sparse": config.Sparse,
+		})
+	}
+
+	// Install hooks enforcing the protected branch policy
+	if err := m.installPolicyHooks(config.ID); err != nil {
+		return err
+	}
+
+	// Publish the agent's pushes under its own namespace on origin
+	if err := m.configurePushNamespace(config.ID); err != nil {
+		return err
+	}
+
 	// Apply Git configuration if specified
 	if len(config.GitConfig) > 0 {
 		for key, value := range config.GitConfig {
-			configCmd := fmt.Sprintf("cd /workspace/repo && git config %s \"%s\"", key, value)
-			_, err := m.Exec(config.ID, configCmd)
+			_, err := m.ExecArgs(config.ID, "/workspace/repo", "git", "config", key, value)
 			if err != nil {
-				return fmt.Errorf("failed to apply Git config %s: %v", key, err)
+				return fmt.Errorf("failed to apply Git config %s: %w", key, err)
 			}
 		}
 	}
@@ -380,9 +1061,75 @@ func (m *Manager) setupGitRepository(config AgentConfig) error {
 	return nil
 }
 
-// Exec executes a command in an agent container
+// commitSHAPattern matches full or abbreviated hexadecimal commit SHAs accepted by --commit
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{4,64}$`)
+
+// checkoutCommit checks out the commit an agent is pinned to as a detached HEAD and
+// records its full SHA. A shallow clone may not contain the commit, in which case it
+// is fetched from origin first.
+func (m *Manager) checkoutCommit(config AgentConfig) error {
+	if _, err := m.ExecArgs(config.ID, repoRoot, "git", "checkout", "--quiet", "--detach", config.Commit); err != nil {
+		if config.Depth == 0 {
+			return fmt.Errorf("failed to check out commit %s: %w", config.Commit, err)
+		}
+		if _, err := m.ExecArgs(config.ID, repoRoot, "git", "fetch", "--depth", strconv.Itoa(config.Depth), "origin", config.Commit); err != nil {
+			return fmt.Errorf("failed to fetch commit %s: %w", config.Commit, err)
+		}
+		if _, err := m.ExecArgs(config.ID, repoRoot, "git", "checkout", "--quiet", "--detach", config.Commit); err != nil {
+			return fmt.Errorf("failed to check out commit %s: %w", config.Commit, err)
+		}
+	}
+
+	output, err := m.ExecArgs(config.ID, repoRoot, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to resolve commit %s: %w", config.Commit, err)
+	}
+	if err := m.store.Update(config.ID, func(st *state.AgentState) error {
+		st.Commit = strings.TrimSpace(output)
+		return nil
+	}); err != nil {
+		return fmt.Errorf("failed to record pinned commit: %w", err)
+	}
+	return nil
+}
+
+// Exec executes a command in an agent container, capturing up to DefaultMaxCapture
+// bytes of output
 func (m *Manager) Exec(agentID string, command string) (string, error) {
+	result, err := m.ExecWithOptions(agentID, command, ExecOptions{})
+	if result == nil {
+		return "", err
+	}
+	return result.Output, err
+}
+
+// ExecWithOptions executes a command in an agent container with control over how its
+// output is captured. When the command exits non-zero, the result is returned along
+// with an *ExitError.
+func (m *Manager) ExecWithOptions(agentID string, command string, opts ExecOptions) (*ExecResult, error) {
+	// Commands run on behalf of users count against the caller's quota
+	if opts.Record {
+		release, err := m.reserveExec(agentID)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+	startedAt := time.Now()
+	result, err := m.execArgv(agentID, []string{"/bin/bash", "-c", command}, opts)
+	if opts.Record {
+		m.recordCommand(agentID, command, startedAt, result)
+	}
+	return result, err
+}
+
+// execArgv runs argv in an agent container; ExecWithOptions and ExecArgs build on it
+func (m *Manager) execArgv(agentID string, argv []string, opts ExecOptions) (*ExecResult, error) {
 	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
 	
 	// Start metrics timer
 	metrics.StartTimer("exec_command", metrics.ContainerOps, agentID)
@@ -391,7 +1138,7 @@ func (m *Manager) Exec(agentID string, command string) (string, error) {
 	// Create a trace
 	ctx, spanID := tracing.StartSpan(ctx, "agent.Exec", map[string]interface{}{
 		"agent_id": agentID,
-		"command": command,
+		"command": formatArgv(argv),
 	})
 	defer func() {
 		if r := recover(); r != nil {
@@ -401,11 +1148,12 @@ func (m *Manager) Exec(agentID string, command string) (string, error) {
 	}()
 
 	// Container name based on agent ID
-	containerName := fmt.Sprintf("capsulate-%s", agentID)
+	containerName := m.containerName(agentID)
 
 	// Create exec configuration
 	execConfig := types.ExecConfig{
-		Cmd:          []string{"/bin/bash", "-c", command},
+		Cmd:          argv,
+		WorkingDir:   opts.WorkingDir,
 		AttachStdout: true,
 		AttachStderr: true,
 	}
@@ -414,136 +1162,306 @@ func (m *Manager) Exec(agentID string, command string) (string, error) {
 	execIDResp, err := m.dockerClient.ContainerExecCreate(ctx, containerName, execConfig)
 	if err != nil {
 		tracing.EndSpanError(spanID, err.Error())
-		return "", fmt.Errorf("failed to create exec: %v", err)
+		return nil, agentError(agentID, fmt.Errorf("failed to create exec: %w", err))
 	}
 
 	// Attach to exec instance
 	execAttachResp, err := m.dockerClient.ContainerExecAttach(ctx, execIDResp.ID, types.ExecAttachOptions{})
 	if err != nil {
 		tracing.EndSpanError(spanID, err.Error())
-		return "", fmt.Errorf("failed to attach to exec: %v", err)
+		return nil, fmt.Errorf("failed to attach to exec: %w", err)
 	}
 	defer execAttachResp.Close()
 
-	// Read the output
-	var outBuf bytes.Buffer
-	_, err = io.Copy(&outBuf, execAttachResp.Reader)
+	// Read the output, demultiplexing the stdout/stderr streams into one capped
+	// buffer and, if requested, spilling the complete output to a host file
+	outBuf := newCappedBuffer(opts.MaxCapture)
+	var out io.Writer = outBuf
+	if opts.OutputFile != "" {
+		outFile, err := os.Create(opts.OutputFile)
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer outFile.Close()
+		out = io.MultiWriter(outFile, outBuf)
+	}
+	_, err = stdcopy.StdCopy(out, out, execAttachResp.Reader)
 	if err != nil {
 		tracing.EndSpanError(spanID, err.Error())
-		return "", fmt.Errorf("failed to read exec output: %v", err)
+		return nil, fmt.Errorf("failed to read exec output: %w", err)
+	}
+
+	result := &ExecResult{
+		Output:         outBuf.String(),
+		TotalBytes:     outBuf.total,
+		CapturedBytes:  outBuf.captured(),
+		TruncatedBytes: outBuf.total - outBuf.captured(),
+		OutputFile:     opts.OutputFile,
+	}
+	if result.Truncated() {
+		metrics.RecordCount("exec_output_truncated", metrics.ContainerOps, 1, agentID)
 	}
 
 	// Get the exit code
 	inspect, err := m.dockerClient.ContainerExecInspect(ctx, execIDResp.ID)
 	if err != nil {
 		tracing.EndSpanError(spanID, err.Error())
-		return "", fmt.Errorf("failed to inspect exec: %v", err)
+		return nil, fmt.Errorf("failed to inspect exec: %w", err)
 	}
+	result.ExitCode = inspect.ExitCode
 
 	// Check if the command exited with an error
 	if inspect.ExitCode != 0 {
-		err := fmt.Errorf("command exited with code %d", inspect.ExitCode)
+		err := &ExitError{Code: inspect.ExitCode}
 		tracing.EndSpanError(spanID, err.Error())
-		return outBuf.String(), err
+		return result, err
 	}
 
 	tracing.EndSpanSuccess(spanID)
-	return outBuf.String(), nil
+	return result, nil
 }
 
-// GetGitStatus retrieves the Git status of the repository in the agent container
+// noRepositoryMarker is printed by the status command of agents without a repository
+const noRepositoryMarker = "# no-repository"
+
+// hasRepository reports whether an agent has a Git repository; agents created without
+// one are bare tool containers
+func (m *Manager) hasRepository(agentID string) (bool, error) {
+	_, err := m.ExecArgs(agentID, "", "git", "-C", repoRoot, "rev-parse", "--git-dir")
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetGitStatus retrieves the Git status of the repository in the agent container.
+// Everything is read from a single `git status --porcelain=v2 --branch` call. Files are
+// limited to the agent's working directory when it is scoped to one. Agents without a
+// repository report NoRepository.
 func (m *Manager) GetGitStatus(agentID string) (*GitStatus, error) {
-	// Get current branch
-	branchOutput, err := m.Exec(agentID, "cd /workspace/repo && git branch --show-current")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current branch: %v", err)
+	// Agents scoped to a subdirectory only see the files below it
+	pathspec := ""
+	if dir := m.WorkDir(agentID); dir != "" {
+		pathspec = " -- " + shellQuote(dir)
 	}
-	branch := strings.TrimSpace(branchOutput)
-	
-	// Get current commit
-	commitOutput, err := m.Exec(agentID, "cd /workspace/repo && git rev-parse HEAD")
+	output, err := m.Exec(agentID, `{ cd /workspace/repo 2>/dev/null && git rev-parse --git-dir >/dev/null 2>&1; } || { echo "`+noRepositoryMarker+`"; exit 0; }; git -c core.quotePath=false status --porcelain=v2 --branch`+pathspec+` && echo "# stash.count $(git stash list | wc -l)"`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current commit: %v", err)
+		return nil, fmt.Errorf("failed to get Git status: %w", err)
 	}
-	commit := strings.TrimSpace(commitOutput)
-	
-	// Get modified files
-	modifiedOutput, err := m.Exec(agentID, "cd /workspace/repo && git diff --name-only")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get modified files: %v", err)
+	status := parseGitStatus(output)
+	if st, exists, err := m.store.Get(agentID); err == nil && exists {
+		status.PinnedCommit = st.Commit
 	}
-	var modifiedFiles []string
-	if modifiedOutput != "" {
-		modifiedFiles = strings.Split(strings.TrimSpace(modifiedOutput), "\n")
-	}
-	
-	// Get untracked files
-	untrackedOutput, err := m.Exec(agentID, "cd /workspace/repo && git ls-files --others --exclude-standard")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get untracked files: %v", err)
+	return status, nil
+}
+
+// parseGitStatus parses porcelain v2 status output, followed by a "# stash.count" line
+func parseGitStatus(output string) *GitStatus {
+	status := &GitStatus{}
+	hasAheadBehind := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case line == noRepositoryMarker:
+			status.NoRepository = true
+		case strings.HasPrefix(line, "# branch.oid "):
+			status.CurrentCommit = strings.TrimPrefix(line, "# branch.oid ")
+			if status.CurrentCommit == "(initial)" {
+				status.CurrentCommit = ""
+			}
+		case strings.HasPrefix(line, "# branch.head "):
+			status.Branch = strings.TrimPrefix(line, "# branch.head ")
+			if status.Branch == "(detached)" {
+				status.Branch = ""
+				status.Detached = true
+			}
+		case strings.HasPrefix(line, "# branch.upstream "):
+			status.Upstream = strings.TrimPrefix(line, "# branch.upstream ")
+		case strings.HasPrefix(line, "# branch.ab "):
+			fmt.Sscanf(strings.TrimPrefix(line, "# branch.ab "), "+%d -%d", &status.AheadCount, &status.BehindCount)
+			hasAheadBehind = true
+		case strings.HasPrefix(line, "# stash.count "):
+			fmt.Sscanf(strings.TrimSpace(strings.TrimPrefix(line, "# stash.count ")), "%d", &status.StashCount)
+		case strings.HasPrefix(line, "1 "), strings.HasPrefix(line, "2 "):
+			// Ordinary and renamed/copied entries: "<type> XY <6 or 7 fields> path[\torig]"
+			n := 9
+			if line[0] == '2' {
+				n = 10
+			}
+			fields := strings.SplitN(line, " ", n)
+			if len(fields) < n {
+				continue
+			}
+			path := strings.SplitN(fields[n-1], "\t", 2)[0]
+			if fields[1][0] != '.' {
+				status.StagedFiles = append(status.StagedFiles, path)
+			}
+			if fields[1][1] != '.' {
+				status.ModifiedFiles = append(status.ModifiedFiles, path)
+			}
+		case strings.HasPrefix(line, "u "):
+			if fields := strings.SplitN(line, " ", 11); len(fields) == 11 {
+				status.ConflictedFiles = append(status.ConflictedFiles, fields[10])
+			}
+		case strings.HasPrefix(line, "? "):
+			status.UntrackedFiles = append(status.UntrackedFiles, strings.TrimPrefix(line, "? "))
+		}
 	}
-	var untrackedFiles []string
-	if untrackedOutput != "" {
-		untrackedFiles = strings.Split(strings.TrimSpace(untrackedOutput), "\n")
+
+	status.Dirty = len(status.StagedFiles) > 0 || len(status.ModifiedFiles) > 0 ||
+		len(status.UntrackedFiles) > 0 || len(status.ConflictedFiles) > 0
+
+	// Git omits the ahead/behind line when the upstream ref does not exist
+	switch {
+	case status.Upstream == "":
+		status.Tracking = TrackingNone
+	case !hasAheadBehind:
+		status.Tracking = TrackingGone
+	case status.AheadCount > 0 && status.BehindCount > 0:
+		status.Tracking = TrackingDiverged
+	case status.AheadCount > 0:
+		status.Tracking = TrackingAhead
+	case status.BehindCount > 0:
+		status.Tracking = TrackingBehind
+	default:
+		status.Tracking = TrackingInSync
 	}
-	
-	// Get ahead/behind counts
-	aheadBehindOutput, err := m.Exec(agentID, "cd /workspace/repo && git rev-list --count --left-right @{upstream}...HEAD 2>/dev/null || echo '0 0'")
-	if err != nil {
-		// If error (possibly due to no upstream), default to 0 0
-		aheadBehindOutput = "0 0"
+	return status
+}
+
+// CreateBranch creates a new Git branch in the agent container. With track, or when
+// branches.track is set in capsulate.yaml, the branch tracks origin/<branch>.
+func (m *Manager) CreateBranch(agentID, branchName string, checkout, track bool) error {
+	// Enforce the branch naming policy
+	if err := m.ValidateBranchName(agentID, branchName); err != nil {
+		return err
 	}
-	
-	aheadBehind := strings.Fields(strings.TrimSpace(aheadBehindOutput))
-	ahead, behind := 0, 0
-	if len(aheadBehind) >= 2 {
-		fmt.Sscanf(aheadBehind[1], "%d", &ahead)
-		fmt.Sscanf(aheadBehind[0], "%d", &behind)
+
+	if err := checkArg("branch name", branchName); err != nil {
+		return err
 	}
-	
-	return &GitStatus{
-		Branch:         branch,
-		CurrentCommit:  commit,
-		ModifiedFiles:  modifiedFiles,
-		UntrackedFiles: untrackedFiles,
-		AheadCount:     ahead,
-		BehindCount:    behind,
-	}, nil
-}
 
-// CreateBranch creates a new Git branch in the agent container
-func (m *Manager) CreateBranch(agentID, branchName string, checkout bool) error {
-	createCmd := fmt.Sprintf("cd /workspace/repo && git branch %s", branchName)
-	_, err := m.Exec(agentID, createCmd)
+	_, err := m.ExecArgs(agentID, "/workspace/repo", "git", "branch", branchName)
 	if err != nil {
-		return fmt.Errorf("failed to create branch: %v", err)
+		return fmt.Errorf("failed to create branch: %w", err)
+	}
+
+	if track || m.config.Branches.Track {
+		if err := m.setUpstream(agentID, branchName); err != nil {
+			return err
+		}
 	}
 	
 	if checkout {
-		checkoutCmd := fmt.Sprintf("cd /workspace/repo && git checkout %s", branchName)
-		_, err := m.Exec(agentID, checkoutCmd)
+		_, err := m.ExecArgs(agentID, "/workspace/repo", "git", "checkout", branchName)
 		if err != nil {
-			return fmt.Errorf("failed to checkout branch: %v", err)
+			return fmt.Errorf("failed to checkout branch: %w", err)
 		}
 	}
 	
 	return nil
 }
 
-// CheckoutBranch checks out a Git branch in the agent container
-func (m *Manager) CheckoutBranch(agentID, branchName string) error {
-	checkoutCmd := fmt.Sprintf("cd /workspace/repo && git checkout %s", branchName)
-	_, err := m.Exec(agentID, checkoutCmd)
+// CheckoutBranch checks out a Git branch in the agent container. With track, or when
+// branches.track is set in capsulate.yaml, a branch without an upstream is made to
+// track origin/<branch>.
+func (m *Manager) CheckoutBranch(agentID, branchName string, track bool) error {
+	if err := checkArg("branch name", branchName); err != nil {
+		return err
+	}
+
+	_, err := m.ExecArgs(agentID, "/workspace/repo", "git", "checkout", branchName)
 	if err != nil {
-		return fmt.Errorf("failed to checkout branch: %v", err)
+		return fmt.Errorf("failed to checkout branch: %w", err)
+	}
+
+	if track || m.config.Branches.Track {
+		if err := m.setUpstream(agentID, branchName); err != nil {
+			return err
+		}
 	}
 	
 	return nil
 }
 
-// Destroy destroys an agent container
+// setUpstream makes a branch track the branch of the same name on origin, or in the
+// agent's push namespace when branches.namespace is set, unless it already has an
+// upstream. The remote branch need not exist yet: until it is pushed the status
+// reports the upstream as gone rather than in sync.
+func (m *Manager) setUpstream(agentID, branchName string) error {
+	if _, err := m.ExecArgs(agentID, repoRoot, "git", "config", "--get", "branch."+branchName+".merge"); err == nil {
+		return nil
+	}
+	remoteBranch := branchName
+	if m.config.Branches.Namespace {
+		remoteBranch = PushNamespace(agentID) + branchName
+	}
+	if _, err := m.ExecArgs(agentID, repoRoot, "git", "config", "branch."+branchName+".remote", "origin"); err != nil {
+		return fmt.Errorf("failed to set upstream of branch %s: %w", branchName, err)
+	}
+	if _, err := m.ExecArgs(agentID, repoRoot, "git", "config", "branch."+branchName+".merge", "refs/heads/"+remoteBranch); err != nil {
+		return fmt.Errorf("failed to set upstream of branch %s: %w", branchName, err)
+	}
+	return nil
+}
+
+// DestroyOptions control what Destroy keeps of an agent
+type DestroyOptions struct {
+	// SkipTrash leaves the agent's files in place instead of moving them to the trash
+	SkipTrash bool
+	// SkipSummary records no run summary, for throwaway agents such as benchmark runs
+	SkipSummary bool
+	// StopTimeout is how long the container gets to exit after SIGTERM before it is
+	// killed; nil uses stop.timeout of capsulate.yaml
+	StopTimeout *time.Duration
+	// Kill sends SIGKILL right away and skips the pre-stop hooks
+	Kill bool
+	// SkipHooks does not run the stop.pre_stop hooks of capsulate.yaml
+	SkipHooks bool
+	// OnHookError is told about each failed pre-stop hook; the destroy goes on
+	OnHookError func(name string, err error)
+	// SkipScrub leaves credential files in the agent's repository and dependencies,
+	// which outlive its container in the workspace and the trash
+	SkipScrub bool
+	// OnScrub is told what the scrub removed and found, or why it failed; the
+	// destroy goes on
+	OnScrub func(agentID string, report *ScrubReport, err error)
+}
+
+// Destroy destroys an agent container. Unless the trash is disabled in capsulate.yaml,
+// its files and a bundle of its repository are moved to the trash for Undelete.
 func (m *Manager) Destroy(agentID string) error {
+	return m.DestroyWithOptions(agentID, DestroyOptions{})
+}
+
+// DestroyWithOptions destroys an agent container with control over what is kept
+func (m *Manager) DestroyWithOptions(agentID string, opts DestroyOptions) error {
 	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return err
+	}
+
+	// Webhooks are told once the agent is unlocked, so that a slow endpoint does not
+	// hold up other commands on it
+	destroyed := false
+	defer func() {
+		if destroyed {
+			m.notify(config.EventAgentDestroyed, agentID, nil)
+		}
+	}()
+
+	// Serialize with other Create and Destroy calls for this agent, in this process
+	// and across processes on the host
+	unlock := m.agentLocks.lock(agentID)
+	defer unlock()
+	release, err := m.store.Lock(agentID, "destroyed")
+	if err != nil {
+		return err
+	}
+	defer release()
 	
 	// Start metrics timer
 	metrics.StartTimer("destroy_container", metrics.ContainerOps, agentID)
@@ -560,11 +1478,66 @@ func (m *Manager) Destroy(agentID string) error {
 		}
 	}()
 
+	// Run the pre-stop hooks first, so that what they save, such as a checkpoint
+	// commit, is part of the trash entry and the run summary
+	if !opts.Kill && !opts.SkipHooks {
+		m.runPreStopHooks(ctx, agentID, spanID, opts.OnHookError)
+	}
+
+	// Then remove the credential files, which would outlive the container in the
+	// workspace and the trash, including any a hook wrote
+	if !opts.SkipScrub {
+		report, err := m.scrub(ctx, agentID, false)
+		if err != nil {
+			tracing.AddEvent(spanID, "scrub_failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		} else {
+			tracing.AddEvent(spanID, "scrubbed", map[string]interface{}{
+				"removed":  len(report.Removed),
+				"scrubbed": len(report.Scrubbed),
+				"secrets":  len(report.Secrets),
+			})
+		}
+		if opts.OnScrub != nil {
+			opts.OnScrub(agentID, report, err)
+		}
+	}
+
+	// Start the agent's trash entry while its container can still bundle the
+	// repository; it is dropped if the destroy fails
+	var trash *TrashEntry
+	if !opts.SkipTrash {
+		if trash, err = m.beginTrash(ctx, agentID, spanID); err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return err
+		}
+	}
+	defer func() {
+		if trash != nil && !destroyed {
+			os.RemoveAll(trash.Path)
+		}
+	}()
+	// An unreadable state record is not kept
+	st, _, _ := m.store.Get(agentID)
+
+	// Summarize the run while the container can still be inspected
+	var summary *state.RunSummary
+	if st != nil && !opts.SkipSummary {
+		summary = m.summarizeRun(ctx, st, spanID)
+	}
+
+	// Remove the sidecars first; they live in the agent container's network
+	if err := m.removeSidecars(ctx, agentID); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return agentError(agentID, err)
+	}
+
 	// Container name based on agent ID
-	containerName := fmt.Sprintf("capsulate-%s", agentID)
+	containerName := m.containerName(agentID)
 
 	// Stop the container
-	err := m.dockerClient.ContainerStop(ctx, containerName, container.StopOptions{})
+	err = m.stopContainer(ctx, containerName, opts.StopTimeout, opts.Kill)
 	if err != nil {
 		tracing.AddEvent(spanID, "container_stop_failed", map[string]interface{}{
 			"error": err.Error(),
@@ -578,7 +1551,61 @@ func (m *Manager) Destroy(agentID string) error {
 	})
 	if err != nil {
 		tracing.EndSpanError(spanID, err.Error())
-		return fmt.Errorf("failed to remove container: %v", err)
+		return agentError(agentID, fmt.Errorf("failed to remove container: %w", err))
+	}
+
+	// Forget the agent's recorded state
+	if err := m.store.Delete(agentID); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return err
+	}
+	destroyed = true
+
+	// Its schedules would only fail from now on
+	if removed, err := m.schedules.RemoveAgent(agentID); err != nil {
+		tracing.AddEvent(spanID, "schedules_remove_failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+	} else if len(removed) > 0 {
+		tracing.AddEvent(spanID, "schedules_removed", map[string]interface{}{
+			"schedules": removed,
+		})
+	}
+
+	if summary != nil {
+		if trash != nil {
+			summary.TrashEntry = trash.ID
+		}
+		if err := m.store.SaveSummary(summary); err != nil {
+			tracing.AddEvent(spanID, "summary_failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	// Move the agent's files to the trash and purge the entries past their retention.
+	// Failures leave the files in place and do not fail the destroy.
+	if trash != nil {
+		if err := m.finishTrash(trash, st); err != nil {
+			tracing.AddEvent(spanID, "trash_failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+		if _, err := m.PurgeTrash(false); err != nil {
+			tracing.AddEvent(spanID, "trash_purge_failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
+	}
+
+	// Snapshot layers the trash did not take are not plain directories left for
+	// 'orphans' to clean up; remove them with their driver
+	if st != nil && st.UseOverlay && snapshotStorage(st.StorageDriver) {
+		if err := m.storage(st.StorageDriver).remove(agentID); err != nil {
+			tracing.AddEvent(spanID, "storage_remove_failed", map[string]interface{}{
+				"error": err.Error(),
+			})
+		}
 	}
 
 	// Record container destruction
@@ -588,43 +1615,68 @@ func (m *Manager) Destroy(agentID string) error {
 	return nil
 }
 
-// ensureBaseImage makes sure the base Docker image exists
-func (m *Manager) ensureBaseImage(ctx context.Context) error {
+// ensureBaseImage makes sure the base Docker image of a platform exists; "" is the
+// platform of the Docker host
+func (m *Manager) ensureBaseImage(ctx context.Context, platform string) error {
+	// Concurrent creates must not build the image twice
+	m.imageMutex.Lock()
+	defer m.imageMutex.Unlock()
+
+	baseImage := m.baseImageFor(platform)
+
 	// Check if image exists
 	images, err := m.dockerClient.ImageList(ctx, types.ImageListOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to list images: %v", err)
+		return fmt.Errorf("failed to list images: %w", err)
 	}
 
 	for _, image := range images {
 		for _, tag := range image.RepoTags {
-			if tag == m.baseImageName {
+			if tag == baseImage {
 				return nil // Image exists
 			}
 		}
 	}
 
 	// If we get here, need to build the image
-	fmt.Printf("Building base image...\n")
+	fmt.Printf("Building base image %s...\n", baseImage)
+	return m.buildBaseImage(ctx, platform)
+}
+
+// buildBaseImage builds the base image of a platform from images.from (ubuntu by
+// default) and tags it, replacing an existing base image. Images of another platform
+// than the host's are built under qemu emulation. The caller must hold imageMutex.
+func (m *Manager) buildBaseImage(ctx context.Context, platform string) error {
+	baseImage := m.baseImageFor(platform)
+	var ociPlatform *ocispec.Platform
+	if platform != "" {
+		ociPlatform = dockerclient.OCIPlatform(platform)
+	}
 
 	// Create a temporary directory for the Docker build context
 	tempDir, err := os.MkdirTemp("", "capsulate-docker-build")
 	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %v", err)
+		return fmt.Errorf("failed to create temp directory: %w", err)
 	}
 	defer os.RemoveAll(tempDir)
 
 	// Create Dockerfile in temp directory
 	dockerfilePath := filepath.Join(tempDir, "Dockerfile")
-	dockerfileContent := `FROM ubuntu:22.04
+	fromImage := m.config.Images.FromImage()
+	dockerfileContent := `FROM ` + fromImage + `
 
 RUN apt-get update && apt-get install -y \
     git \
     openssh-client \
+    openssh-server \
+    socat \
     curl \
     build-essential \
+    fuse-overlayfs \
+    fuse3 \
     && apt-get clean \
-    && rm -rf /var/lib/apt/lists/*
+    && rm -rf /var/lib/apt/lists/* \
+    && rm -f /etc/ssh/ssh_host_*_key*
 
 # Set up Git configuration
 RUN git config --global init.defaultBranch main
@@ -636,45 +1688,53 @@ WORKDIR /workspace
 CMD ["tail", "-f", "/dev/null"]
 `
 	if err := os.WriteFile(dockerfilePath, []byte(dockerfileContent), 0644); err != nil {
-		return fmt.Errorf("failed to write Dockerfile: %v", err)
+		return fmt.Errorf("failed to write Dockerfile: %w", err)
 	}
 
 	// For simplicity, let's use a pull-based approach instead of building
 	// This is a workaround since creating a proper tar archive for build context is complex
-	fmt.Printf("Using ubuntu image with Git...\n")
+	fmt.Printf("Using %s image with Git...\n", fromImage)
 	
-	// Pull ubuntu image
-	out, err := m.dockerClient.ImagePull(ctx, "ubuntu:22.04", types.ImagePullOptions{})
+	// Pull the image the base image is built from, once its signature is checked
+	if err := m.verifyImage(ctx, fromImage); err != nil {
+		return err
+	}
+	out, err := m.dockerClient.ImagePull(ctx, fromImage, types.ImagePullOptions{Platform: platform})
 	if err != nil {
-		return fmt.Errorf("failed to pull ubuntu image: %v", err)
+		return fmt.Errorf("failed to pull image %s: %w", fromImage, err)
 	}
 	defer out.Close()
 	io.Copy(io.Discard, out) // Discard output
 	
-	// Create a container to install Git
+	// Create a container to install Git, replacing one left over by a failed build
 	tempContainerName := "capsulate-image-builder"
+	m.dockerClient.ContainerRemove(ctx, tempContainerName, types.ContainerRemoveOptions{Force: true})
 	resp, err := m.dockerClient.ContainerCreate(
 		ctx,
 		&container.Config{
-			Image: "ubuntu:22.04",
+			Image: fromImage,
 			Cmd:   []string{"/bin/bash", "-c", 
-				"apt-get update && apt-get install -y git openssh-client curl build-essential && " +
+				"apt-get update && DEBIAN_FRONTEND=noninteractive apt-get install -y git openssh-client openssh-server socat curl build-essential fuse-overlayfs fuse3 && " +
 				"apt-get clean && rm -rf /var/lib/apt/lists/* && " +
+				"rm -f /etc/ssh/ssh_host_*_key* && " +
 				"git config --global init.defaultBranch main && " +
 				"mkdir -p /workspace"},
 		},
 		nil,
 		nil,
-		nil,
+		ociPlatform,
 		tempContainerName,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to create temp container: %v", err)
+		return fmt.Errorf("failed to create temp container: %w", err)
 	}
 	
 	// Start container
 	if err := m.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
-		return fmt.Errorf("failed to start temp container: %v", err)
+		if m.emulated(platform) {
+			return fmt.Errorf("failed to start temp container: %w; %s", err, emulationHint(platform))
+		}
+		return fmt.Errorf("failed to start temp container: %w", err)
 	}
 	
 	// Wait for container to finish
@@ -682,29 +1742,40 @@ CMD ["tail", "-f", "/dev/null"]
 	select {
 	case err := <-errCh:
 		if err != nil {
-			return fmt.Errorf("container wait error: %v", err)
+			return fmt.Errorf("container wait error: %w", err)
+		}
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			m.dockerClient.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{})
+			if m.emulated(platform) {
+				return fmt.Errorf("failed to install packages in the %s base image (exit code %d); %s",
+					platform, status.StatusCode, emulationHint(platform))
+			}
+			return fmt.Errorf("failed to install packages in the base image (exit code %d)", status.StatusCode)
 		}
-	case <-statusCh:
 	}
 	
 	// Commit the container as our base image
 	_, err = m.dockerClient.ContainerCommit(ctx, resp.ID, types.ContainerCommitOptions{
-		Reference: m.baseImageName,
+		Reference: baseImage,
+		// The label finds the images replaced by later builds when pruning
+		Changes:   []string{fmt.Sprintf("LABEL %s=base", ImageLabel)},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to commit container: %v", err)
+		return fmt.Errorf("failed to commit container: %w", err)
 	}
 	
 	// Remove the temporary container
 	if err := m.dockerClient.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{}); err != nil {
-		return fmt.Errorf("failed to remove temp container: %v", err)
+		return fmt.Errorf("failed to remove temp container: %w", err)
 	}
 
 	fmt.Printf("Base image built successfully\n")
 	return nil
 }
 
-// GitExec executes a git command in an agent container
+// GitExec executes a git command in the agent's repository. The arguments are passed
+// to git as they are, without a shell.
 func (m *Manager) GitExec(agentID string, args ...string) (string, error) {
 	// Start metrics timer
 	metrics.StartTimer("git_operation", metrics.GitOps, agentID)
@@ -716,7 +1787,8 @@ func (m *Manager) GitExec(agentID string, args ...string) (string, error) {
 		metrics.RecordCount("git_"+gitCmd, metrics.GitOps, 1, agentID)
 	}
 	
-	gitCmd := fmt.Sprintf("git %s", strings.Join(args, " "))
+	gitArgs := append([]string{"git"}, args...)
+	gitCmd := formatArgv(gitArgs)
 	
 	// Create a trace with the git command
 	ctx, spanID := tracing.StartSpan(context.Background(), "agent.GitExec", map[string]interface{}{
@@ -729,10 +1801,19 @@ func (m *Manager) GitExec(agentID string, args ...string) (string, error) {
 			panic(r)
 		}
 	}()
-	
-	// Execute the git command
-	output, err := m.Exec(agentID, gitCmd)
+
+	// Enforce the protected branch policy
+	if err := m.checkGitPolicy(agentID, args); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return "", err
+	}
+
+	// Execute the git command without a shell, in the repository
+	output, err := m.ExecArgs(agentID, "/workspace/repo", gitArgs...)
 	if err != nil {
+		if hostKeyErr := hostKeyError("", output); hostKeyErr != nil {
+			err = hostKeyErr
+		}
 		tracing.EndSpanError(spanID, err.Error())
 		return output, err
 	}
@@ -0,0 +1,266 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// ManifestVersion is the format version of environment manifests
+const ManifestVersion = 1
+
+// Kinds of difference between two manifests, besides DriftImage and DriftEnv
+const (
+	DriftCommit     = "commit"
+	DriftPackage    = "package"    // a system package installed with dpkg
+	DriftDependency = "dependency" // a package of a dependency provider
+)
+
+// redactedValue replaces the values of environment variables that look like secrets
+const redactedValue = "<redacted>"
+
+// secretEnvName matches the names of environment variables redacted in manifests
+var secretEnvName = regexp.MustCompile(`(?i)(TOKEN|SECRET|PASSWORD|PASSWD|CREDENTIAL|API_?KEY|PRIVATE_KEY)`)
+
+// agentSpecificEnv are the variables left out when comparing manifests: the agent's
+// ID, and the override specs, whose packages are compared as dependencies
+var agentSpecificEnv = []string{"AGENT_ID", "OVERRIDE_DEPS"}
+
+// EnvManifest records what an agent ran with: the image, the commit of the repository,
+// the installed packages and the environment, so the environment can be reproduced
+// later with create --from-manifest
+type EnvManifest struct {
+	Version    int       `json:"version"`
+	CapturedAt time.Time `json:"captured_at"`
+	AgentID    string    `json:"agent_id"`
+	// Image is the image the container was created from and ImageID its digest
+	Image   string `json:"image"`
+	ImageID string `json:"image_id"`
+	// Platform is the platform the agent was created with --platform on, if any
+	Platform string `json:"platform,omitempty"`
+	// Repository checkout; Dirty records uncommitted changes, which are not captured
+	RepoURL string `json:"repo_url,omitempty"`
+	Branch  string `json:"branch,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Dirty   bool   `json:"dirty,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Sparse  bool   `json:"sparse,omitempty"`
+	// Agent configuration
+	UseOverlay      bool                         `json:"use_overlay,omitempty"`
+	DependencyLevel string                       `json:"dependency_level,omitempty"`
+	TeamID          string                       `json:"team_id,omitempty"`
+	TeamSnapshot    string                       `json:"team_snapshot,omitempty"`
+	Template        string                       `json:"template,omitempty"`
+	Overrides       []state.DependencyResolution `json:"overrides,omitempty"`
+	// SystemPackages are the dpkg packages of the container, keyed by name
+	SystemPackages map[string]string `json:"system_packages"`
+	// Dependencies are the effective packages of the dependency providers
+	Dependencies []Dependency `json:"dependencies"`
+	// Env is the container's environment; values of secret-looking variables are
+	// redacted
+	Env []string `json:"env"`
+}
+
+// CaptureEnvironment records the image, repository commit, system packages,
+// dependencies and environment of an agent into a manifest
+func (m *Manager) CaptureEnvironment(agentID string) (*EnvManifest, error) {
+	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.CaptureEnvironment", map[string]interface{}{
+		"agent_id": agentID,
+	})
+
+	manifest, err := m.captureEnvironment(ctx, agentID)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	tracing.AddEvent(spanID, "captured", map[string]interface{}{
+		"system_packages": len(manifest.SystemPackages),
+		"dependencies":    len(manifest.Dependencies),
+	})
+	tracing.EndSpanSuccess(spanID)
+	return manifest, nil
+}
+
+// captureEnvironment does the work of CaptureEnvironment
+func (m *Manager) captureEnvironment(ctx context.Context, agentID string) (*EnvManifest, error) {
+	st, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+
+	inspect, err := m.dockerClient.ContainerInspect(ctx, m.containerName(agentID))
+	if err != nil {
+		return nil, agentError(agentID, err)
+	}
+
+	manifest := &EnvManifest{
+		Version:         ManifestVersion,
+		CapturedAt:      time.Now().UTC(),
+		AgentID:         agentID,
+		Image:           inspect.Config.Image,
+		ImageID:         inspect.Image,
+		RepoURL:         st.RepoURL,
+		Path:            st.Path,
+		Sparse:          st.Sparse,
+		UseOverlay:      st.UseOverlay,
+		DependencyLevel: st.DependencyLevel,
+		TeamID:          st.TeamID,
+		TeamSnapshot:    st.TeamSnapshot,
+		Platform:        st.Platform,
+		Template:        st.Template,
+		Overrides:       st.Overrides,
+		SystemPackages:  make(map[string]string),
+		Env:             redactEnv(inspect.Config.Env),
+	}
+
+	if st.RepoURL != "" {
+		status, err := m.GetGitStatus(agentID)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Branch = status.Branch
+		manifest.Commit = status.CurrentCommit
+		manifest.Dirty = status.Dirty
+	}
+
+	// Images without dpkg have no system packages to record
+	output, err := m.ExecArgs(agentID, "", "sh", "-c", `command -v dpkg-query >/dev/null || exit 0; dpkg-query -W -f '${Package}\t${Version}\n'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list system packages: %w", err)
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if name, version, ok := strings.Cut(strings.TrimSpace(line), "\t"); ok && name != "" {
+			manifest.SystemPackages[name] = version
+		}
+	}
+
+	dependencies, err := m.ListDependencies(agentID)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Dependencies = dependencies
+	if manifest.Dependencies == nil {
+		manifest.Dependencies = []Dependency{}
+	}
+	return manifest, nil
+}
+
+// ReadManifest reads an environment manifest written by env capture
+func ReadManifest(path string) (*EnvManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	var manifest EnvManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	if manifest.Version != ManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d in %s (expected %d)", manifest.Version, path, ManifestVersion)
+	}
+	if manifest.ImageID == "" {
+		return nil, fmt.Errorf("manifest %s does not record an image", path)
+	}
+	return &manifest, nil
+}
+
+// AgentConfig returns the configuration of an agent reproducing the manifest: the
+// same image, the repository checked out at the captured commit as a detached HEAD,
+// and overrides pinned to the versions they resolved to. Uncommitted changes and the
+// live team layer, which may have moved on, cannot be reproduced.
+func (e *EnvManifest) AgentConfig(agentID string) AgentConfig {
+	config := AgentConfig{
+		ID:              agentID,
+		Image:           e.ImageID,
+		RepoURL:         e.RepoURL,
+		Commit:          e.Commit,
+		Path:            e.Path,
+		Sparse:          e.Sparse,
+		UseOverlay:      e.UseOverlay,
+		DependencyLevel: e.DependencyLevel,
+		TeamID:          e.TeamID,
+		TeamSnapshot:    e.TeamSnapshot,
+		Template:        e.Template,
+		Platform:        e.Platform,
+	}
+	for _, override := range e.Overrides {
+		spec := override.Provider + ":" + override.Requested
+		if override.Path == "" && override.Version != "" {
+			spec = override.Provider + ":" + override.Name + "@" + override.Version
+		}
+		config.OverrideDeps = append(config.OverrideDeps, spec)
+	}
+	return config
+}
+
+// CompareManifests lists the differences between the environment a manifest
+// recorded and another one, e.g. captured from the agent reproducing it
+func CompareManifests(expected, actual *EnvManifest) []Drift {
+	var drift []Drift
+	add := func(kind, name, expected, actual string) {
+		drift = append(drift, Drift{Kind: kind, Name: name, Expected: expected, Actual: actual})
+	}
+
+	if expected.ImageID != actual.ImageID {
+		add(DriftImage, expected.Image, expected.ImageID, actual.ImageID)
+	}
+	if expected.Commit != actual.Commit {
+		add(DriftCommit, expected.RepoURL, expected.Commit, actual.Commit)
+	}
+	diffMaps(DriftPackage, expected.SystemPackages, actual.SystemPackages, nil, add)
+	diffMaps(DriftDependency, dependencyVersions(expected.Dependencies), dependencyVersions(actual.Dependencies), nil, add)
+	expectedEnv, actualEnv := envMap(expected.Env), envMap(actual.Env)
+	for _, name := range agentSpecificEnv {
+		delete(expectedEnv, name)
+		delete(actualEnv, name)
+	}
+	diffMaps(DriftEnv, expectedEnv, actualEnv, nil, add)
+
+	sort.SliceStable(drift, func(i, j int) bool {
+		if drift[i].Kind != drift[j].Kind {
+			return drift[i].Kind < drift[j].Kind
+		}
+		return drift[i].Name < drift[j].Name
+	})
+	return drift
+}
+
+// dependencyVersions keys the versions of dependencies by provider:name
+func dependencyVersions(dependencies []Dependency) map[string]string {
+	versions := make(map[string]string, len(dependencies))
+	for _, dep := range dependencies {
+		versions[dep.Provider+":"+dep.Name] = dep.Version
+	}
+	return versions
+}
+
+// redactEnv replaces the values of secret-looking variables in NAME=value entries
+func redactEnv(env []string) []string {
+	redacted := make([]string, 0, len(env))
+	for _, entry := range env {
+		if name, _, ok := strings.Cut(entry, "="); ok && secretEnvName.MatchString(name) {
+			entry = name + "=" + redactedValue
+		}
+		redacted = append(redacted, entry)
+	}
+	sort.Strings(redacted)
+	return redacted
+}
@@ -0,0 +1,22 @@
+package agent
+
+import "fmt"
+
+// configureMirrors writes the mirror URL rewrites into the agent's global Git
+// configuration, so they apply to the clone and every later fetch. Unless a mirror
+// takes pushes, an identity pushInsteadOf keeps pushes on the canonical URL: Git
+// derives push URLs from the original URL before insteadOf rewrites are applied.
+func (m *Manager) configureMirrors(agentID string) error {
+	for _, mirror := range m.config.Mirrors {
+		if _, err := m.ExecArgs(agentID, "", "git", "config", "--global", "--add", "url."+mirror.Mirror+".insteadOf", mirror.URL); err != nil {
+			return fmt.Errorf("failed to configure mirror for %s: %w", mirror.URL, err)
+		}
+		if mirror.PushToMirror {
+			continue
+		}
+		if _, err := m.ExecArgs(agentID, "", "git", "config", "--global", "--add", "url."+mirror.URL+".pushInsteadOf", mirror.URL); err != nil {
+			return fmt.Errorf("failed to keep pushes for %s on the canonical URL: %w", mirror.URL, err)
+		}
+	}
+	return nil
+}
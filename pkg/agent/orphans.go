@@ -0,0 +1,282 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/state"
+)
+
+// Kinds of orphaned resources, left behind by interrupted creates and destroys or by
+// containers removed with docker rm
+const (
+	OrphanContainer = "container" // agent or sidecar container of an agent without state
+	OrphanState     = "state"     // state entry of an agent whose container is gone
+	OrphanOverlay   = "overlay"   // overlay diff or work directory of an unknown agent
+	OrphanImage     = "image"     // dangling image built by git-capsulate and unused
+)
+
+// PruneOrphaned is the reason orphaned resources are removed by Prune
+const PruneOrphaned = "orphaned"
+
+// Orphan is a resource of git-capsulate that no agent accounts for
+type Orphan struct {
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	AgentID string `json:"agent_id,omitempty"`
+	Size    int64  `json:"size_bytes"`
+	// Since is when the resource was created or last modified
+	Since time.Time `json:"since"`
+	// ref is what gets removed: a container or image ID, or a directory
+	ref string
+}
+
+// FindOrphans lists the containers without state, state entries without containers and
+// overlay directories of unknown agents in the manager's project, and the dangling
+// images git-capsulate built that no container uses
+func (m *Manager) FindOrphans() ([]Orphan, error) {
+	ctx := context.Background()
+
+	states, err := m.store.List()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(states))
+	for _, st := range states {
+		known[st.ID] = true
+	}
+
+	containers, err := m.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", "capsulate.agent-id")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	var orphans []Orphan
+	withContainer := make(map[string]bool)
+	for _, c := range containers {
+		if c.Labels[ProjectLabel] != m.project {
+			continue
+		}
+		// Containers of images committed from agents carry the label, blanked
+		agentID := c.Labels["capsulate.agent-id"]
+		if agentID == "" {
+			continue
+		}
+		if c.Labels[SidecarLabel] == "" {
+			withContainer[agentID] = true
+		}
+		if known[agentID] {
+			continue
+		}
+		name := c.ID
+		if len(c.Names) > 0 {
+			name = strings.TrimPrefix(c.Names[0], "/")
+		}
+		orphans = append(orphans, Orphan{Kind: OrphanContainer, Name: name, AgentID: agentID,
+			Since: time.Unix(c.Created, 0).UTC(), ref: c.ID})
+	}
+
+	for _, st := range states {
+		// Adopted containers carry no labels and are known by name
+		if st.Adopted && !withContainer[st.ID] {
+			if _, err := m.dockerClient.ContainerInspect(ctx, m.containerName(st.ID)); err == nil {
+				withContainer[st.ID] = true
+			}
+		}
+		if !withContainer[st.ID] {
+			orphans = append(orphans, Orphan{Kind: OrphanState, Name: st.ID, AgentID: st.ID, Since: st.UpdatedAt})
+		}
+	}
+
+	for _, dir := range []string{m.diffsPath, m.workPath} {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || known[entry.Name()] {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			orphan := Orphan{Kind: OrphanOverlay, Name: path, AgentID: entry.Name(), Size: dirSize(path), ref: path}
+			if info, err := entry.Info(); err == nil {
+				orphan.Since = info.ModTime().UTC()
+			}
+			orphans = append(orphans, orphan)
+		}
+	}
+
+	images, err := m.dockerClient.ImageList(ctx, types.ImageListOptions{
+		Filters: filters.NewArgs(filters.Arg("label", ImageLabel), filters.Arg("dangling", "true")),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	used, err := m.dockerClient.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	inUse := make(map[string]bool)
+	for _, c := range used {
+		inUse[c.ImageID] = true
+	}
+	for _, image := range images {
+		if inUse[image.ID] {
+			continue
+		}
+		name := strings.TrimPrefix(image.ID, "sha256:")
+		if len(name) > 12 {
+			name = name[:12]
+		}
+		orphans = append(orphans, Orphan{Kind: OrphanImage, Name: name, Size: image.Size,
+			Since: time.Unix(image.Created, 0).UTC(), ref: image.ID})
+	}
+
+	sort.SliceStable(orphans, func(i, j int) bool {
+		if orphans[i].Kind != orphans[j].Kind {
+			return orphans[i].Kind < orphans[j].Kind
+		}
+		return orphans[i].Name < orphans[j].Name
+	})
+	return orphans, nil
+}
+
+// removeOrphan removes an orphaned resource. Agents being created or destroyed by
+// another process are skipped, and whether a resource is still orphaned is checked
+// again under the agent's lock, since a create may have completed in the meantime.
+// It reports whether the resource was removed.
+func (m *Manager) removeOrphan(ctx context.Context, orphan Orphan) (bool, error) {
+	if orphan.Kind == OrphanImage {
+		_, err := m.dockerClient.ImageRemove(ctx, orphan.ref, types.ImageRemoveOptions{PruneChildren: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return false, fmt.Errorf("failed to remove image %s: %w", orphan.Name, err)
+		}
+		return err == nil, nil
+	}
+
+	unlock := m.agentLocks.lock(orphan.AgentID)
+	defer unlock()
+	release, err := m.store.Lock(orphan.AgentID, "cleaned up")
+	if errors.Is(err, state.ErrAgentBusy) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	_, exists, err := m.store.Get(orphan.AgentID)
+	if err != nil {
+		return false, err
+	}
+	switch orphan.Kind {
+	case OrphanContainer:
+		if exists {
+			return false, nil
+		}
+		err := m.dockerClient.ContainerRemove(ctx, orphan.ref, types.ContainerRemoveOptions{Force: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return false, fmt.Errorf("failed to remove container %s: %w", orphan.Name, err)
+		}
+		return err == nil, nil
+	case OrphanState:
+		if !exists {
+			return false, nil
+		}
+		_, err := m.dockerClient.ContainerInspect(ctx, m.containerName(orphan.AgentID))
+		if err == nil {
+			return false, nil
+		}
+		if !errdefs.IsNotFound(err) {
+			return false, fmt.Errorf("failed to inspect container: %w", err)
+		}
+		if err := m.store.Delete(orphan.AgentID); err != nil {
+			return false, err
+		}
+		return true, nil
+	case OrphanOverlay:
+		if exists {
+			return false, nil
+		}
+		if err := os.RemoveAll(orphan.ref); err != nil {
+			return false, fmt.Errorf("failed to remove %s: %w", orphan.ref, err)
+		}
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown kind of orphan '%s'", orphan.Kind)
+}
+
+// pruneOrphans removes the resources found by FindOrphans
+func (m *Manager) pruneOrphans(ctx context.Context, report *PruneReport) error {
+	orphans, err := m.FindOrphans()
+	if err != nil {
+		return err
+	}
+	for _, orphan := range orphans {
+		if !report.DryRun {
+			removed, err := m.removeOrphan(ctx, orphan)
+			if err != nil {
+				return err
+			}
+			if !removed {
+				continue
+			}
+		}
+		report.Removed = append(report.Removed, PrunedEntry{
+			Kind:     orphan.Kind,
+			Name:     orphan.Name,
+			Size:     orphan.Size,
+			LastUsed: orphan.Since,
+			Reason:   PruneOrphaned,
+		})
+		report.Reclaimed += orphan.Size
+	}
+	return nil
+}
+
+// CheckOrphans reports the orphaned resources found by FindOrphans as a doctor check
+func (m *Manager) CheckOrphans() DoctorCheck {
+	check := DoctorCheck{Name: "orphans", Status: CheckOK}
+	orphans, err := m.FindOrphans()
+	if err != nil {
+		check.Status, check.Detail = CheckError, err.Error()
+		return check
+	}
+	if len(orphans) == 0 {
+		check.Detail = "no containers without state, state without containers, stray overlay directories or dangling images"
+		return check
+	}
+
+	counts := make(map[string]int)
+	var size int64
+	for _, orphan := range orphans {
+		counts[orphan.Kind]++
+		size += orphan.Size
+	}
+	var parts []string
+	for _, kind := range []struct{ kind, label string }{
+		{OrphanContainer, "containers without state"},
+		{OrphanState, "state entries without a container"},
+		{OrphanOverlay, "overlay directories of unknown agents"},
+		{OrphanImage, "dangling images"},
+	} {
+		if counts[kind.kind] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[kind.kind], kind.label))
+		}
+	}
+	check.Status = CheckWarning
+	check.Detail = fmt.Sprintf("%s (%s on disk)", strings.Join(parts, ", "), formatSize(size))
+	check.Hint = "review them with 'git-capsulate prune --orphans --dry-run' and remove them with 'git-capsulate prune --orphans'"
+	return check
+}
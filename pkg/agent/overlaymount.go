@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// fuseProbeTimeout bounds the throwaway container checking whether FUSE works
+const fuseProbeTimeout = 30 * time.Second
+
+// fuseProbeScript mounts and unmounts a fuse-overlayfs file system in a throwaway
+// container, which fails when the image lacks fuse-overlayfs or the daemon does not
+// let containers mount through /dev/fuse
+const fuseProbeScript = `mkdir -p /tmp/probe/lower /tmp/probe/upper /tmp/probe/work /tmp/probe/merged &&
+fuse-overlayfs -o lowerdir=/tmp/probe/lower,upperdir=/tmp/probe/upper,workdir=/tmp/probe/work /tmp/probe/merged &&
+fusermount3 -u /tmp/probe/merged`
+
+// overlayMountMode resolves overlay.mount for a new overlay agent. auto selects fuse
+// when a probe container of the agent's image can mount fuse-overlayfs, and the
+// kernel's OverlayFS otherwise. fuse fails early when the probe does.
+func (m *Manager) overlayMountMode(ctx context.Context, cfg AgentConfig) (string, error) {
+	mode := m.config.Overlay.MountMode()
+	if mode == config.OverlayMountKernel {
+		return mode, nil
+	}
+
+	image := m.baseImageFor(cfg.Platform)
+	if cfg.Image != "" {
+		image = cfg.Image
+	}
+	err := m.fuseWorks(ctx, image)
+	switch {
+	case err == nil:
+		return config.OverlayMountFuse, nil
+	case mode == config.OverlayMountFuse:
+		return "", fmt.Errorf("overlay.mount is fuse, but fuse-overlayfs does not work in agent containers: %w; "+
+			"rebuild the base image with 'git-capsulate image refresh' and make sure /dev/fuse exists on the Docker host", err)
+	}
+	return config.OverlayMountKernel, nil
+}
+
+// fuseWorks checks, once per image, whether fuse-overlayfs can mount in an
+// unprivileged container of the image given /dev/fuse
+func (m *Manager) fuseWorks(ctx context.Context, image string) error {
+	if cached, ok := m.fuseProbes.Load(image); ok {
+		if cached == nil {
+			return nil
+		}
+		return cached.(error)
+	}
+	err := m.probeFuse(ctx, image)
+	if err == nil {
+		m.fuseProbes.Store(image, nil)
+	} else {
+		m.fuseProbes.Store(image, err)
+	}
+	return err
+}
+
+// probeFuse runs fuseProbeScript in a throwaway container of an image
+func (m *Manager) probeFuse(ctx context.Context, image string) error {
+	ctx, cancel := context.WithTimeout(ctx, fuseProbeTimeout)
+	defer cancel()
+
+	hostConfig := &container.HostConfig{}
+	overlayHostConfig(hostConfig, config.OverlayMountFuse)
+	resp, err := m.dockerClient.ContainerCreate(ctx,
+		&container.Config{Image: image, Cmd: []string{"bash", "-c", fuseProbeScript}},
+		hostConfig, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create probe container: %w", err)
+	}
+	defer m.dockerClient.ContainerRemove(context.Background(), resp.ID, types.ContainerRemoveOptions{Force: true})
+
+	if err := m.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return fmt.Errorf("failed to start probe container: %w", err)
+	}
+	statusCh, errCh := m.dockerClient.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		return fmt.Errorf("failed to wait for probe container: %w", err)
+	case status := <-statusCh:
+		if status.StatusCode != 0 {
+			return fmt.Errorf("probe mount exited with code %d", status.StatusCode)
+		}
+	}
+	return nil
+}
+
+// overlayHostConfig grants an agent container what its overlay mount needs: the
+// fuse device for fuse-overlayfs, or CAP_SYS_ADMIN, outside the default AppArmor
+// profile that denies mounts, for the kernel's OverlayFS. Agents recorded without a
+// mount use the kernel's.
+func overlayHostConfig(hostConfig *container.HostConfig, mode string) {
+	if mode == config.OverlayMountFuse {
+		hostConfig.Devices = append(hostConfig.Devices, container.DeviceMapping{
+			PathOnHost:        "/dev/fuse",
+			PathInContainer:   "/dev/fuse",
+			CgroupPermissions: "rwm",
+		})
+		return
+	}
+	hostConfig.CapAdd = append(hostConfig.CapAdd, "SYS_ADMIN")
+	hostConfig.SecurityOpt = append(hostConfig.SecurityOpt, "apparmor=unconfined")
+}
+
+// overlayMountCommand returns the shell command mounting the overlay workspace at
+// /workspace/merged
+func overlayMountCommand(mode string) string {
+	options := "lowerdir=/workspace/base,upperdir=/workspace/diff,workdir=/workspace/work"
+	if mode == config.OverlayMountFuse {
+		return "fuse-overlayfs -o " + options + " /workspace/merged"
+	}
+	return "mount -t overlay overlay -o " + options + " /workspace/merged"
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/dockerclient"
+)
+
+// HostPlatform returns the platform of the Docker host's containers, e.g. linux/arm64
+// on Apple Silicon or Graviton. It is read from the daemon once.
+func (m *Manager) HostPlatform() (string, error) {
+	m.hostPlatformOnce.Do(func() {
+		m.hostPlatform, m.hostPlatformErr = dockerclient.HostPlatform(context.Background(), m.dockerClient)
+	})
+	return m.hostPlatform, m.hostPlatformErr
+}
+
+// emulated reports whether agents of a platform run under emulation on this host
+func (m *Manager) emulated(platform string) bool {
+	if platform == "" {
+		return false
+	}
+	host, err := m.HostPlatform()
+	return err == nil && host != platform
+}
+
+// baseImageFor returns the base image of a platform. The host's platform, or "", uses
+// the base image name; other platforms get a tag of their own, e.g.
+// capsulate-base:latest-amd64, so that the images of both architectures coexist.
+func (m *Manager) baseImageFor(platform string) string {
+	if !m.emulated(platform) {
+		return m.baseImageName
+	}
+	return m.baseImageName + "-" + dockerclient.Arch(platform)
+}
+
+// hasBaseImageTag reports whether an image is tagged as the base image of a platform
+func (m *Manager) hasBaseImageTag(tags []string) bool {
+	for _, tag := range tags {
+		if tag == m.baseImageName {
+			return true
+		}
+		for _, platform := range dockerclient.Platforms {
+			if tag == m.baseImageName+"-"+dockerclient.Arch(platform) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// imageID returns the ID of an image, or "" when it is not on the host
+func (m *Manager) imageID(image string) (string, error) {
+	inspect, _, err := m.dockerClient.ImageInspectWithRaw(context.Background(), image)
+	if errdefs.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	return inspect.ID, nil
+}
+
+// emulationHint tells how to run containers of a foreign platform
+func emulationHint(platform string) string {
+	return fmt.Sprintf("running %s containers needs qemu emulation on the Docker host, installed with "+
+		"'docker run --privileged --rm tonistiigi/binfmt --install %s' (Docker Desktop has it built in)",
+		platform, dockerclient.Arch(platform))
+}
@@ -0,0 +1,398 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// PolicyError is returned when an operation violates the configured branch policy
+type PolicyError struct {
+	Rule    string
+	Message string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("policy violation (%s): %s", e.Rule, e.Message)
+}
+
+// templatePlaceholder matches {placeholder} segments in branch templates
+var templatePlaceholder = regexp.MustCompile(`\{[a-z]+\}`)
+
+// ExpandBranchTemplate renders the configured branch template for an agent,
+// substituting name for the {name} placeholder
+func (m *Manager) ExpandBranchTemplate(agentID, name string) (string, error) {
+	template := m.config.Branches.Template
+	if template == "" {
+		return "", fmt.Errorf("no branch template configured")
+	}
+
+	values := m.templateValues(agentID)
+	values["{date}"] = time.Now().Format("20060102")
+	values["{name}"] = name
+
+	var missing error
+	expanded := templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := values[placeholder]
+		if !ok || value == "" {
+			missing = fmt.Errorf("branch template placeholder %s has no value for agent '%s'", placeholder, agentID)
+		}
+		return value
+	})
+	if missing != nil {
+		return "", missing
+	}
+	return expanded, nil
+}
+
+// ValidateBranchName checks a new branch name against the configured template
+// and the protected branch list
+func (m *Manager) ValidateBranchName(agentID, branchName string) error {
+	if m.IsProtectedBranch(branchName) {
+		return &PolicyError{
+			Rule:    "protected-branch",
+			Message: fmt.Sprintf("branch '%s' is protected and cannot be created or reset by agents", branchName),
+		}
+	}
+
+	template := m.config.Branches.Template
+	if template == "" {
+		return nil
+	}
+
+	values := m.templateValues(agentID)
+	pattern := regexp.QuoteMeta(template)
+	for _, placeholder := range templatePlaceholder.FindAllString(template, -1) {
+		var expr string
+		switch placeholder {
+		case "{date}":
+			expr = `[0-9]{8}`
+		case "{name}":
+			expr = `[^/]+`
+		default:
+			expr = regexp.QuoteMeta(values[placeholder])
+			if expr == "" {
+				expr = `[^/]+`
+			}
+		}
+		pattern = strings.Replace(pattern, regexp.QuoteMeta(placeholder), expr, 1)
+	}
+
+	if !regexp.MustCompile("^" + pattern + "$").MatchString(branchName) {
+		return &PolicyError{
+			Rule:    "branch-template",
+			Message: fmt.Sprintf("branch '%s' does not match the required template '%s'", branchName, template),
+		}
+	}
+	return nil
+}
+
+// PushNamespace returns the prefix of the branches an agent publishes on origin when
+// branches.namespace is set, e.g. "capsulate/agent1/"
+func PushNamespace(agentID string) string {
+	return "capsulate/" + agentID + "/"
+}
+
+// inPushNamespace reports whether a remote branch is in an agent's push namespace
+func inPushNamespace(agentID, branchName string) bool {
+	return strings.HasPrefix(strings.TrimPrefix(branchName, "refs/heads/"), PushNamespace(agentID))
+}
+
+// IsProtectedBranch reports whether a branch matches the protected branch list
+func (m *Manager) IsProtectedBranch(branchName string) bool {
+	branchName = strings.TrimPrefix(branchName, "refs/heads/")
+	for _, pattern := range m.config.Branches.Protected {
+		if ok, _ := path.Match(pattern, branchName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkGitPolicy inspects a git command run through GitExec and rejects commits,
+// pushes, resets, renames and deletions that would touch a protected branch
+func (m *Manager) checkGitPolicy(agentID string, args []string) error {
+	if (len(m.config.Branches.Protected) == 0 && !m.config.Branches.Namespace) || len(args) == 0 {
+		return nil
+	}
+
+	currentBranch := func() string {
+		output, err := m.Exec(agentID, "cd /workspace/repo && git branch --show-current")
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(output)
+	}
+	protectedErr := func(action, branch string) error {
+		return &PolicyError{
+			Rule:    "protected-branch",
+			Message: fmt.Sprintf("%s protected branch '%s' is not allowed from agents", action, branch),
+		}
+	}
+
+	switch args[0] {
+	case "commit", "merge", "rebase", "cherry-pick", "revert", "am":
+		if branch := currentBranch(); m.IsProtectedBranch(branch) {
+			return protectedErr(args[0]+" on", branch)
+		}
+
+	case "push":
+		_, targets := parseGitArgs(args[1:], pushValueOptions)
+		// git push [remote] [refspec...]; without refspecs the current branch is pushed,
+		// into the agent's namespace when there is one
+		if len(targets) <= 1 && !m.config.Branches.Namespace {
+			if branch := currentBranch(); m.IsProtectedBranch(branch) {
+				return protectedErr("pushing to", branch)
+			}
+		}
+		refspecs := targets
+		if len(refspecs) > 0 {
+			refspecs = refspecs[1:]
+		}
+		for _, refspec := range refspecs {
+			dst := strings.TrimPrefix(refspec, "+")
+			i := strings.LastIndex(dst, ":")
+			if i >= 0 {
+				dst = dst[i+1:]
+			}
+			if m.config.Branches.Namespace {
+				// Refspecs without a destination are mapped into the namespace
+				if i >= 0 && !strings.HasPrefix(dst, "refs/tags/") && !inPushNamespace(agentID, dst) {
+					return &PolicyError{
+						Rule:    "push-namespace",
+						Message: fmt.Sprintf("agents may only push to branches under '%s', not '%s'", PushNamespace(agentID), dst),
+					}
+				}
+				continue
+			}
+			if m.IsProtectedBranch(dst) {
+				return protectedErr("pushing to", dst)
+			}
+		}
+
+	case "checkout", "switch":
+		// -f only discards local changes; -B (and -C for switch) reset the named branch
+		options, _ := parseGitArgs(args[1:], checkoutValueOptions)
+		for _, opt := range options {
+			reset := opt.name == "-B" || (args[0] == "switch" && (opt.name == "-C" || opt.name == "--force-create"))
+			if reset && m.IsProtectedBranch(opt.value) {
+				return protectedErr("resetting", opt.value)
+			}
+		}
+
+	case "branch":
+		options, branches := parseGitArgs(args[1:], branchValueOptions)
+		var mode string
+		force := false
+		for _, opt := range options {
+			switch opt.name {
+			case "-d", "--delete":
+				mode = "delete"
+			case "-D":
+				mode, force = "delete", true
+			case "-m", "--move":
+				mode = "move"
+			case "-M":
+				mode, force = "move", true
+			case "-c", "--copy":
+				mode = "copy"
+			case "-C":
+				mode, force = "copy", true
+			case "-f", "--force":
+				force = true
+			}
+		}
+
+		switch mode {
+		case "delete":
+			for _, branch := range branches {
+				if m.IsProtectedBranch(branch) {
+					return protectedErr("deleting", branch)
+				}
+			}
+		case "move", "copy":
+			// git branch -m [<old>] <new>; the current branch is renamed or copied
+			// when <old> is left out
+			if len(branches) == 0 {
+				break
+			}
+			oldBranch, newBranch := "", branches[0]
+			if len(branches) > 1 {
+				oldBranch, newBranch = branches[0], branches[1]
+			} else {
+				oldBranch = currentBranch()
+			}
+			if mode == "move" && m.IsProtectedBranch(oldBranch) {
+				return protectedErr("renaming", oldBranch)
+			}
+			if m.IsProtectedBranch(newBranch) {
+				if mode == "move" {
+					return protectedErr("renaming to", newBranch)
+				}
+				return protectedErr("copying to", newBranch)
+			}
+		default:
+			// git branch -f <branch> [<start-point>] resets an existing branch
+			if force && len(branches) > 0 && m.IsProtectedBranch(branches[0]) {
+				return protectedErr("resetting", branches[0])
+			}
+		}
+	}
+	return nil
+}
+
+// Options of git push, checkout/switch and branch that take a value, which may be
+// given as the next argument
+var (
+	pushValueOptions = map[string]bool{
+		"-o": true, "--push-option": true, "--repo": true, "--receive-pack": true, "--exec": true,
+	}
+	checkoutValueOptions = map[string]bool{
+		"-b": true, "-B": true, "-c": true, "-C": true, "--create": true, "--force-create": true,
+		"--orphan": true, "--conflict": true, "--pathspec-from-file": true,
+	}
+	branchValueOptions = map[string]bool{
+		"-u": true, "--set-upstream-to": true, "--contains": true, "--no-contains": true,
+		"--merged": true, "--no-merged": true, "--points-at": true, "--sort": true, "--format": true,
+	}
+)
+
+// gitOption is an option given to a git command, with its value if it takes one
+type gitOption struct {
+	name  string
+	value string
+}
+
+// parseGitArgs splits the arguments of a git command into its options and operands
+// the way git parses them. Options in valued take a value: after '=' for long options,
+// attached to short ones, or else the next argument. Short options may be grouped, as
+// in -fB, and everything after "--" is an operand.
+func parseGitArgs(args []string, valued map[string]bool) ([]gitOption, []string) {
+	var options []gitOption
+	var operands []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return options, append(operands, args[i+1:]...)
+
+		case strings.HasPrefix(arg, "--"):
+			name, value, hasValue := strings.Cut(arg, "=")
+			if !hasValue && valued[name] && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			options = append(options, gitOption{name: name, value: value})
+
+		case strings.HasPrefix(arg, "-") && arg != "-":
+			for j := 1; j < len(arg); j++ {
+				name := "-" + arg[j:j+1]
+				if !valued[name] {
+					options = append(options, gitOption{name: name})
+					continue
+				}
+				value := arg[j+1:]
+				if value == "" && i+1 < len(args) {
+					i++
+					value = args[i]
+				}
+				options = append(options, gitOption{name: name, value: value})
+				break
+			}
+
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return options, operands
+}
+
+// installPolicyHooks writes pre-commit and pre-push hooks into the agent's repository
+// so the protected branch list and the push namespace also apply to git commands run
+// directly through Exec
+func (m *Manager) installPolicyHooks(agentID string) error {
+	protected := len(m.config.Branches.Protected) > 0
+	if !protected && !m.config.Branches.Namespace {
+		return nil
+	}
+
+	patterns := make([]string, len(m.config.Branches.Protected))
+	for i, pattern := range m.config.Branches.Protected {
+		patterns[i] = casePattern(pattern)
+	}
+	casePattern := strings.Join(patterns, "|")
+
+	hooks := make(map[string]string)
+	if protected {
+		hooks["pre-commit"] = fmt.Sprintf(`#!/bin/sh
+# Installed by git-capsulate: protected branch policy
+branch=$(git symbolic-ref --short -q HEAD)
+case "$branch" in
+  %s)
+    echo "capsulate policy: commits to protected branch '$branch' are not allowed" >&2
+    exit 1 ;;
+esac
+`, casePattern)
+	}
+
+	var prePush strings.Builder
+	prePush.WriteString("#!/bin/sh\n# Installed by git-capsulate: branch push policy\n")
+	prePush.WriteString("while read local_ref local_sha remote_ref remote_sha; do\n")
+	if protected {
+		fmt.Fprintf(&prePush, `  branch=${remote_ref#refs/heads/}
+  case "$branch" in
+    %s)
+      echo "capsulate policy: pushing to protected branch '$branch' is not allowed" >&2
+      exit 1 ;;
+  esac
+`, casePattern)
+	}
+	if m.config.Branches.Namespace {
+		namespace := "refs/heads/" + PushNamespace(agentID)
+		fmt.Fprintf(&prePush, `  if [ "$1" = origin ]; then
+    case "$remote_ref" in
+      %s*) ;;
+      refs/heads/*)
+        echo "capsulate policy: agents may only push to branches under '%s', not '${remote_ref#refs/heads/}'" >&2
+        exit 1 ;;
+    esac
+  fi
+`, namespace, PushNamespace(agentID))
+	}
+	prePush.WriteString("done\n")
+	hooks["pre-push"] = prePush.String()
+
+	for name, script := range hooks {
+		hookPath := "/workspace/repo/.git/hooks/" + name
+		_, err := m.ExecArgs(agentID, "", "sh", "-c", `printf '%s' "$1" > "$2" && chmod +x "$2"`, "sh", script, hookPath)
+		if err != nil {
+			return fmt.Errorf("failed to install %s hook: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// configurePushNamespace maps the agent's pushes to origin into its namespace when
+// branches.namespace is set: 'git push' and 'git push origin <branch>' publish
+// <branch> as capsulate/<agent-id>/<branch>
+func (m *Manager) configurePushNamespace(agentID string) error {
+	if !m.config.Branches.Namespace {
+		return nil
+	}
+	refspec := "refs/heads/*:refs/heads/" + PushNamespace(agentID) + "*"
+	if _, err := m.ExecArgs(agentID, repoRoot, "git", "config", "remote.origin.push", refspec); err != nil {
+		return fmt.Errorf("failed to configure push namespace: %w", err)
+	}
+	return nil
+}
+
+// templateValues returns the agent-specific placeholder values for branch templates
+func (m *Manager) templateValues(agentID string) map[string]string {
+	values := map[string]string{"{id}": agentID}
+	if st, exists, err := m.store.Get(agentID); err == nil && exists {
+		values["{team}"] = st.TeamID
+	}
+	return values
+}
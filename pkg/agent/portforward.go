@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+)
+
+// relayScript connects its standard streams to a TCP port on the container's
+// localhost, with socat when it is installed and bash's /dev/tcp otherwise. bash
+// cannot half-close the socket, so once the local client is done the relay ends.
+const relayScript = `port=$1
+if command -v socat >/dev/null 2>&1; then
+	exec socat - "TCP:127.0.0.1:$port"
+fi
+exec 3<>"/dev/tcp/127.0.0.1/$port" || exit 1
+cat <&3 &
+cat >&3
+kill $! 2>/dev/null
+`
+
+// ParsePortMapping parses a port-forward mapping, "local:remote" or "port" for the
+// same port on both ends. A local port of 0 picks a free one.
+func ParsePortMapping(spec string) (int, int, error) {
+	localSpec, remoteSpec, found := strings.Cut(spec, ":")
+	if !found {
+		remoteSpec = localSpec
+	}
+	local, err := strconv.Atoi(localSpec)
+	if err != nil || local < 0 || local > 65535 {
+		return 0, 0, fmt.Errorf("invalid local port in '%s'", spec)
+	}
+	remote, err := strconv.Atoi(remoteSpec)
+	if err != nil || remote < 1 || remote > 65535 {
+		return 0, 0, fmt.Errorf("invalid agent port in '%s'", spec)
+	}
+	return local, remote, nil
+}
+
+// ForwardPort accepts connections on listener until ctx is cancelled and tunnels each
+// one to port on the agent's localhost, where its services and sidecars listen. The
+// tunnel runs through docker exec, so it needs no published ports and works with a
+// remote Docker host. onError, if not nil, is called when a connection fails.
+func (m *Manager) ForwardPort(ctx context.Context, agentID string, listener net.Listener, port int, onError func(error)) error {
+	if err := ValidateAgentID(agentID); err != nil {
+		return err
+	}
+	if _, err := m.dockerClient.ContainerInspect(ctx, m.containerName(agentID)); err != nil {
+		return agentError(agentID, err)
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+		metrics.RecordCount("port_forward_connection", metrics.ContainerOps, 1, agentID)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := m.tunnel(ctx, agentID, conn, port); err != nil && onError != nil {
+				onError(err)
+			}
+		}()
+	}
+}
+
+// tunnel relays one connection to port in an agent container and closes it
+func (m *Manager) tunnel(ctx context.Context, agentID string, conn net.Conn, port int) error {
+	defer conn.Close()
+
+	execIDResp, err := m.dockerClient.ContainerExecCreate(ctx, m.containerName(agentID), types.ExecConfig{
+		Cmd:          []string{"bash", "-c", relayScript, "relay", strconv.Itoa(port)},
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return agentError(agentID, fmt.Errorf("failed to create exec: %w", err))
+	}
+	execAttachResp, err := m.dockerClient.ContainerExecAttach(ctx, execIDResp.ID, types.ExecAttachOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	defer execAttachResp.Close()
+
+	// Local client to agent; closing the exec's stdin tells the relay it is done
+	go func() {
+		io.Copy(execAttachResp.Conn, conn)
+		execAttachResp.CloseWrite()
+	}()
+
+	// Agent to local client; the relay's error messages go to stderr
+	stderr := newCappedBuffer(4096)
+	if _, err := stdcopy.StdCopy(conn, stderr, execAttachResp.Reader); err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("failed to relay connection to port %d: %w", port, err)
+	}
+	if message := strings.TrimSpace(stderr.String()); message != "" {
+		return fmt.Errorf("failed to connect to port %d in agent '%s': %s", port, agentID, message)
+	}
+	return nil
+}
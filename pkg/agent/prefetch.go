@@ -0,0 +1,262 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// defaultStaleAfter is how long a mirror may go without a fetch before it is
+// reported stale, when no prefetch interval is configured
+const defaultStaleAfter = 24 * time.Hour
+
+// PrefetchResult is the outcome of fetching one repository into the clone cache
+type PrefetchResult struct {
+	URL      string        `json:"url"`
+	Key      string        `json:"key"`
+	Created  bool          `json:"created,omitempty"` // the mirror was cloned for the first time
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// CacheStatus is a mirror of the clone cache, or a prefetched repository without one
+type CacheStatus struct {
+	CacheEntry
+	// Prefetched repositories are listed under cache.prefetch
+	Prefetched bool `json:"prefetched"`
+	// Missing prefetched repositories have no mirror yet
+	Missing bool `json:"missing,omitempty"`
+	// Stale mirrors were not fetched for longer than the staleness threshold
+	Stale bool `json:"stale"`
+}
+
+// Prefetch fetches the repositories listed under cache.prefetch into the clone cache,
+// cloning the mirrors that do not exist yet, so new agents clone from near-current
+// mirrors. Each repository is fetched in a throwaway container from the base image,
+// with the same SSH and mirror configuration as agents. All repositories are
+// attempted; the error reports how many failed.
+func (m *Manager) Prefetch() ([]PrefetchResult, error) {
+	ctx := context.Background()
+
+	metrics.StartTimer("prefetch", metrics.CacheOps, "")
+	defer metrics.StopTimer("prefetch", metrics.CacheOps, "")
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.Prefetch", map[string]interface{}{
+		"repositories": len(m.config.Cache.Prefetch),
+	})
+
+	if err := m.ensureBaseImage(ctx, ""); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	var results []PrefetchResult
+	failed := 0
+	for _, prefetch := range m.config.Cache.Prefetch {
+		key := cacheKey(prefetch.URL)
+		result := PrefetchResult{URL: prefetch.URL, Key: key}
+		start := time.Now()
+		args := append([]string{prefetch.URL, cloneCacheMount + "/" + key + ".git"}, prefetch.Branches...)
+		output, err := m.runCacheContainer(ctx, args)
+		result.Duration = time.Since(start)
+		if err == nil && !strings.Contains(output, "fetched") {
+			err = fmt.Errorf("failed to fetch: %s", lastLine(output))
+		}
+		if err != nil {
+			if hostKeyErr := hostKeyError(prefetch.URL, output); hostKeyErr != nil {
+				err = hostKeyErr
+			}
+			result.Error = err.Error()
+			failed++
+			tracing.AddEvent(spanID, "prefetch_failed", map[string]interface{}{
+				"url":   prefetch.URL,
+				"error": err.Error(),
+			})
+			results = append(results, result)
+			continue
+		}
+
+		entry, err := m.readCacheEntry(key)
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return results, err
+		}
+		now := time.Now().UTC()
+		if entry == nil {
+			// A prefetched mirror counts as used when created, so that pruning does
+			// not remove it before an agent clones from it
+			entry = &CacheEntry{Key: key, URL: prefetch.URL, CreatedAt: now, LastUsed: now}
+		}
+		result.Created = strings.HasPrefix(output, "miss")
+		entry.LastFetch = now
+		if err := m.writeCacheEntry(entry); err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return results, err
+		}
+		metrics.RecordCount("prefetched", metrics.CacheOps, 1, "")
+		results = append(results, result)
+	}
+
+	if failed > 0 {
+		err := fmt.Errorf("failed to prefetch %d of %d repositories", failed, len(results))
+		tracing.EndSpanError(spanID, err.Error())
+		return results, err
+	}
+	tracing.EndSpanSuccess(spanID)
+	return results, nil
+}
+
+// CacheStatus lists the mirrors of the clone cache, least recently used first,
+// followed by the prefetched repositories that have no mirror yet. Mirrors are stale
+// when they were not fetched for twice the configured prefetch interval, or a day
+// without one; staleAfter overrides the threshold when positive.
+func (m *Manager) CacheStatus(staleAfter time.Duration) ([]CacheStatus, error) {
+	if staleAfter <= 0 {
+		staleAfter = defaultStaleAfter
+		if interval := time.Duration(m.config.Cache.PrefetchInterval); interval > 0 {
+			staleAfter = 2 * interval
+		}
+	}
+
+	entries, err := m.CloneCache()
+	if err != nil {
+		return nil, err
+	}
+	prefetched := make(map[string]bool)
+	for _, prefetch := range m.config.Cache.Prefetch {
+		prefetched[cacheKey(prefetch.URL)] = true
+	}
+
+	var statuses []CacheStatus
+	present := make(map[string]bool)
+	for _, entry := range entries {
+		present[entry.Key] = true
+		statuses = append(statuses, CacheStatus{
+			CacheEntry: entry,
+			Prefetched: prefetched[entry.Key],
+			Stale:      time.Since(entry.LastFetch) > staleAfter,
+		})
+	}
+	var missing []CacheStatus
+	for _, prefetch := range m.config.Cache.Prefetch {
+		key := cacheKey(prefetch.URL)
+		if present[key] {
+			continue
+		}
+		present[key] = true
+		missing = append(missing, CacheStatus{
+			CacheEntry: CacheEntry{Key: key, URL: prefetch.URL},
+			Prefetched: true,
+			Missing:    true,
+			Stale:      true,
+		})
+	}
+	sort.Slice(missing, func(i, j int) bool { return missing[i].URL < missing[j].URL })
+	return append(statuses, missing...), nil
+}
+
+// runCacheContainer runs cacheRepoScript with args in a throwaway container from the
+// base image, with the clone cache, the SSH directory, host key verification and the
+// configured mirrors set up as in agents
+func (m *Manager) runCacheContainer(ctx context.Context, args []string) (string, error) {
+	sshFiles, err := m.sshFiles(false)
+	if err != nil {
+		return "", err
+	}
+	var gitConfig strings.Builder
+	for _, mirror := range m.config.Mirrors {
+		fmt.Fprintf(&gitConfig, "[url %q]\n\tinsteadOf = %s\n", mirror.Mirror, mirror.URL)
+	}
+	if err := os.MkdirAll(m.cloneCachePath(), 0755); err != nil {
+		return "", fmt.Errorf("failed to create clone cache directory: %w", err)
+	}
+
+	mounts := []mount.Mount{
+		{
+			Type:   mount.TypeBind,
+			Source: m.cloneCachePath(),
+			Target: cloneCacheMount,
+		},
+		{
+			Type:     mount.TypeBind,
+			Source:   m.sshDir,
+			Target:   "/root/.ssh",
+			ReadOnly: true,
+		},
+	}
+	if err := m.hostMounts(mounts); err != nil {
+		return "", err
+	}
+
+	resp, err := m.dockerClient.ContainerCreate(
+		ctx,
+		&container.Config{
+			Image: m.baseImageName,
+			Cmd:   append([]string{"bash", "-c", cacheRepoScript, "cache"}, args...),
+		},
+		&container.HostConfig{
+			Mounts: mounts,
+		},
+		nil,
+		nil,
+		"",
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create prefetch container: %w", err)
+	}
+	defer m.dockerClient.ContainerRemove(ctx, resp.ID, types.ContainerRemoveOptions{Force: true})
+
+	if err := m.injectFiles(ctx, resp.ID, sshFiles, 0644); err != nil {
+		return "", err
+	}
+	if gitConfig.Len() > 0 {
+		if err := m.injectFiles(ctx, resp.ID, map[string]string{"/root/.gitconfig": gitConfig.String()}, 0644); err != nil {
+			return "", err
+		}
+	}
+
+	if err := m.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+		return "", fmt.Errorf("failed to start prefetch container: %w", err)
+	}
+
+	var exitCode int
+	statusCh, errCh := m.dockerClient.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			return "", fmt.Errorf("prefetch wait error: %w", err)
+		}
+	case status := <-statusCh:
+		exitCode = int(status.StatusCode)
+	}
+
+	logs, err := m.dockerClient.ContainerLogs(ctx, resp.ID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return "", fmt.Errorf("failed to read prefetch output: %w", err)
+	}
+	defer logs.Close()
+	var output bytes.Buffer
+	stdcopy.StdCopy(&output, &output, logs)
+
+	if exitCode != 0 {
+		return output.String(), &ExitError{Code: exitCode}
+	}
+	return output.String(), nil
+}
+
+// lastLine returns the last non-empty line of output
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return lines[len(lines)-1]
+}
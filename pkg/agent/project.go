@@ -0,0 +1,28 @@
+package agent
+
+// ProjectLabel is the Docker label naming the project of an agent's containers; it is
+// not set on agents of the default project
+const ProjectLabel = "capsulate.project"
+
+// Project returns the project the manager's agents belong to, "" for the default one
+func (m *Manager) Project() string {
+	return m.project
+}
+
+// containerName returns the name of an agent's container: capsulate-<id> in the
+// default project and capsulate-<project>_<id> in the others, so that projects sharing
+// a Docker host can use the same agent IDs
+func (m *Manager) containerName(agentID string) string {
+	if m.project == "" {
+		return "capsulate-" + agentID
+	}
+	return "capsulate-" + m.project + "_" + agentID
+}
+
+// projectLabels adds the project label to the Docker labels of a container
+func (m *Manager) projectLabels(labels map[string]string) map[string]string {
+	if m.project != "" {
+		labels[ProjectLabel] = m.project
+	}
+	return labels
+}
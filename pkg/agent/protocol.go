@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// cloneCandidate is a URL a repository is cloned from and the protocol it uses
+type cloneCandidate struct {
+	URL      string
+	Protocol string
+}
+
+// remoteProtocol returns the protocol of a repository URL, ssh or https, and the host
+// and path it points to, or "" for other URLs such as local paths
+func remoteProtocol(repoURL string) (protocol, host, repoPath string) {
+	if strings.Contains(repoURL, "://") {
+		parsed, err := url.Parse(repoURL)
+		if err != nil || parsed.Hostname() == "" {
+			return "", "", ""
+		}
+		switch parsed.Scheme {
+		case "ssh", "git+ssh":
+			return config.ProtocolSSH, parsed.Hostname(), strings.TrimPrefix(parsed.Path, "/")
+		case "https":
+			return config.ProtocolHTTPS, parsed.Hostname(), strings.TrimPrefix(parsed.Path, "/")
+		}
+		return "", "", ""
+	}
+	host = sshHost(repoURL)
+	if host == "" {
+		return "", "", ""
+	}
+	_, repoPath, _ = strings.Cut(repoURL, ":")
+	return config.ProtocolSSH, host, strings.TrimPrefix(repoPath, "/")
+}
+
+// protocolURL rewrites a repository on host to the URL of protocol
+func protocolURL(protocol, host, repoPath string) string {
+	if protocol == config.ProtocolSSH {
+		return "git@" + host + ":" + repoPath
+	}
+	return "https://" + host + "/" + repoPath
+}
+
+// hasSSHKey reports whether the SSH directory mounted in agents holds a private key,
+// without which an SSH fallback cannot authenticate
+func (m *Manager) hasSSHKey() bool {
+	keys, _ := filepath.Glob(filepath.Join(m.sshDir, "id_*"))
+	for _, key := range keys {
+		if strings.HasSuffix(key, ".pub") {
+			continue
+		}
+		if info, err := os.Stat(key); err == nil && info.Mode().IsRegular() {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneCandidates returns the URLs to clone a repository from, in order: the URL of
+// the protocol preferred for its host under protocols in capsulate.yaml, then the
+// other protocol when fallback is enabled. SSH is only fallen back to when an SSH key
+// is available. URLs with no preference, or that are neither SSH nor HTTPS, are used
+// as given.
+func (m *Manager) cloneCandidates(repoURL string) []cloneCandidate {
+	protocol, host, repoPath := remoteProtocol(repoURL)
+	if protocol == "" {
+		return []cloneCandidate{{URL: repoURL}}
+	}
+	pref, ok := m.config.ProtocolFor(host)
+	if !ok {
+		return []cloneCandidate{{URL: repoURL, Protocol: protocol}}
+	}
+
+	preferred := cloneCandidate{URL: repoURL, Protocol: protocol}
+	if pref.Prefer != protocol {
+		preferred = cloneCandidate{URL: protocolURL(pref.Prefer, host, repoPath), Protocol: pref.Prefer}
+	}
+	candidates := []cloneCandidate{preferred}
+	if !pref.FallbackEnabled() {
+		return candidates
+	}
+
+	other := config.ProtocolSSH
+	if preferred.Protocol == config.ProtocolSSH {
+		other = config.ProtocolHTTPS
+	}
+	if other == config.ProtocolSSH && !m.hasSSHKey() {
+		return candidates
+	}
+	fallback := cloneCandidate{URL: repoURL, Protocol: protocol}
+	if protocol != other {
+		fallback = cloneCandidate{URL: protocolURL(other, host, repoPath), Protocol: other}
+	}
+	return append(candidates, fallback)
+}
@@ -0,0 +1,262 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// ImageLabel is the Docker label marking the images git-capsulate builds: base for
+// base images and export for images committed from agents
+const ImageLabel = "capsulate.image"
+
+// Kinds of entries removed by Prune
+const (
+	PrunedCloneCache = "clone-cache"
+	PrunedImage      = "image"
+)
+
+// Reasons an entry is removed by Prune
+const (
+	PruneMaxAge     = "max-age"    // not used for longer than the retention's max_age
+	PruneMaxSize    = "max-size"   // least recently used while the total exceeded max_size
+	PruneIncomplete = "incomplete" // left over by an interrupted clone into the cache
+)
+
+// staleCloneAge is how old an incomplete mirror must be before it is removed, so that
+// clones in progress are left alone
+const staleCloneAge = time.Hour
+
+// PruneOptions select what Prune removes. A nil retention skips that kind of entry;
+// a retention without limits only removes leftovers.
+type PruneOptions struct {
+	CloneCache *config.RetentionConfig
+	Images     *config.RetentionConfig
+	// Orphans removes the resources found by FindOrphans
+	Orphans bool
+	// DryRun reports what would be removed without removing it
+	DryRun bool
+}
+
+// PrunedEntry is a clone cache mirror or image removed by Prune
+type PrunedEntry struct {
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Size     int64     `json:"size_bytes"`
+	LastUsed time.Time `json:"last_used"`
+	Reason   string    `json:"reason"`
+}
+
+// PruneReport is the outcome of Prune
+type PruneReport struct {
+	Removed   []PrunedEntry `json:"removed"`
+	Reclaimed int64         `json:"reclaimed_bytes"`
+	DryRun    bool          `json:"dry_run,omitempty"`
+}
+
+// lruEntry is an entry considered for removal by pruneLRU
+type lruEntry struct {
+	PrunedEntry
+	// inUse entries count towards the total size but are never removed
+	inUse bool
+}
+
+// Prune enforces the retention policies of the clone cache and of the base images
+// replaced by 'image refresh', removing the least recently used entries first, and
+// with Orphans removes orphaned resources. The current base image and images used by
+// containers are kept. The space reclaimed is recorded in the metrics.
+func (m *Manager) Prune(opts PruneOptions) (*PruneReport, error) {
+	ctx := context.Background()
+
+	metrics.StartTimer("prune", metrics.ContainerOps, "")
+	defer metrics.StopTimer("prune", metrics.ContainerOps, "")
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.Prune", map[string]interface{}{
+		"dry_run": opts.DryRun,
+	})
+
+	report := &PruneReport{DryRun: opts.DryRun}
+	if opts.CloneCache != nil {
+		if err := m.pruneCloneCache(*opts.CloneCache, report); err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return report, err
+		}
+	}
+	if opts.Images != nil {
+		if err := m.pruneImages(ctx, *opts.Images, report); err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return report, err
+		}
+	}
+
+	if opts.Orphans {
+		if err := m.pruneOrphans(ctx, report); err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return report, err
+		}
+	}
+
+	tracing.AddEvent(spanID, "pruned", map[string]interface{}{
+		"removed":         len(report.Removed),
+		"reclaimed_bytes": report.Reclaimed,
+	})
+	tracing.EndSpanSuccess(spanID)
+	return report, nil
+}
+
+// pruneCloneCache removes mirrors from the clone cache according to the retention
+func (m *Manager) pruneCloneCache(retention config.RetentionConfig, report *PruneReport) error {
+	// Temporary directories of interrupted clones never become mirrors
+	dir := m.cloneCachePath()
+	files, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read clone cache: %w", err)
+	}
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil || !strings.Contains(file.Name(), ".git.tmp.") || time.Since(info.ModTime()) < staleCloneAge {
+			continue
+		}
+		path := filepath.Join(dir, file.Name())
+		entry := PrunedEntry{Kind: PrunedCloneCache, Name: file.Name(), Size: dirSize(path), LastUsed: info.ModTime(), Reason: PruneIncomplete}
+		if !report.DryRun {
+			if err := os.RemoveAll(path); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+		}
+		report.Removed = append(report.Removed, entry)
+		report.Reclaimed += entry.Size
+	}
+
+	cached, err := m.CloneCache()
+	if err != nil {
+		return err
+	}
+	entries := make([]lruEntry, 0, len(cached))
+	for _, c := range cached {
+		name := c.URL
+		if name == "" {
+			name = c.Key
+		}
+		entries = append(entries, lruEntry{PrunedEntry: PrunedEntry{
+			Kind:     PrunedCloneCache,
+			Name:     name,
+			Size:     c.Size,
+			LastUsed: c.LastUsed,
+		}})
+	}
+
+	var reclaimed int64
+	for _, i := range pruneLRU(entries, retention, time.Now()) {
+		if !report.DryRun {
+			if err := m.removeCacheEntry(cached[i].Key); err != nil {
+				return err
+			}
+		}
+		report.Removed = append(report.Removed, entries[i].PrunedEntry)
+		reclaimed += entries[i].Size
+	}
+	report.Reclaimed += reclaimed
+	if !report.DryRun {
+		metrics.RecordGauge("clone_cache_reclaimed", metrics.CacheOps, float64(reclaimed), "bytes", "")
+	}
+	return nil
+}
+
+// pruneImages removes base images replaced by later builds according to the retention.
+// Images committed from agents are the user's and never pruned.
+func (m *Manager) pruneImages(ctx context.Context, retention config.RetentionConfig, report *PruneReport) error {
+	images, err := m.dockerClient.ImageList(ctx, types.ImageListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", ImageLabel+"=base")),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list images: %w", err)
+	}
+	containers, err := m.dockerClient.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+	used := make(map[string]bool)
+	for _, c := range containers {
+		used[c.ImageID] = true
+	}
+
+	// Images are not tracked when used, so an image counts as last used when it was
+	// built; the images in use are kept regardless
+	sort.Slice(images, func(i, j int) bool { return images[i].Created < images[j].Created })
+	entries := make([]lruEntry, 0, len(images))
+	for _, image := range images {
+		name := strings.TrimPrefix(image.ID, "sha256:")
+		if len(name) > 12 {
+			name = name[:12]
+		}
+		if len(image.RepoTags) > 0 && image.RepoTags[0] != "<none>:<none>" {
+			name += " (" + strings.Join(image.RepoTags, ", ") + ")"
+		}
+		entries = append(entries, lruEntry{
+			PrunedEntry: PrunedEntry{
+				Kind:     PrunedImage,
+				Name:     name,
+				Size:     image.Size,
+				LastUsed: time.Unix(image.Created, 0).UTC(),
+			},
+			inUse: used[image.ID] || m.hasBaseImageTag(image.RepoTags),
+		})
+	}
+
+	var reclaimed int64
+	for _, i := range pruneLRU(entries, retention, time.Now()) {
+		if !report.DryRun {
+			if _, err := m.dockerClient.ImageRemove(ctx, images[i].ID, types.ImageRemoveOptions{PruneChildren: true}); err != nil {
+				return fmt.Errorf("failed to remove image %s: %w", entries[i].Name, err)
+			}
+		}
+		report.Removed = append(report.Removed, entries[i].PrunedEntry)
+		reclaimed += entries[i].Size
+	}
+	report.Reclaimed += reclaimed
+	if !report.DryRun {
+		metrics.RecordGauge("image_reclaimed", metrics.ContainerOps, float64(reclaimed), "bytes", "")
+	}
+	return nil
+}
+
+// pruneLRU returns the indexes of the entries to remove, sorted least recently used
+// first, and records why in each of them: entries unused for longer than MaxAge, then
+// the least recently used ones until the total size, in-use entries included, fits
+// in MaxSize
+func pruneLRU(entries []lruEntry, retention config.RetentionConfig, now time.Time) []int {
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	var removed []int
+	for i := range entries {
+		if entries[i].inUse {
+			continue
+		}
+		switch {
+		case retention.MaxAge > 0 && now.Sub(entries[i].LastUsed) > time.Duration(retention.MaxAge):
+			entries[i].Reason = PruneMaxAge
+		case retention.MaxSize > 0 && total > int64(retention.MaxSize):
+			entries[i].Reason = PruneMaxSize
+		default:
+			continue
+		}
+		removed = append(removed, i)
+		total -= entries[i].Size
+	}
+	return removed
+}
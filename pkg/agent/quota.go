@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"errors"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+)
+
+// reserveCreate counts the agent being created against the caller's
+// quotas.agents_per_hour
+func (m *Manager) reserveCreate(agentID string) error {
+	caller := config.Caller()
+	err := m.store.ReserveCreate(caller, m.config.Quotas.For(caller).AgentsPerHour)
+	if errors.Is(err, ErrQuotaExceeded) {
+		metrics.RecordCount("quota_refusals", metrics.ContainerOps, 1, agentID)
+	}
+	return err
+}
+
+// reserveExec takes one of the commands the caller may run at once per
+// quotas.concurrent_execs. The returned function gives it back.
+func (m *Manager) reserveExec(agentID string) (func(), error) {
+	caller := config.Caller()
+	release, err := m.store.ReserveExec(caller, m.config.Quotas.For(caller).ConcurrentExecs)
+	if errors.Is(err, ErrQuotaExceeded) {
+		metrics.RecordCount("quota_refusals", metrics.ContainerOps, 1, agentID)
+	}
+	return release, err
+}
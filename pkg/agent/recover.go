@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Outcomes of recovering one agent, e.g. after the host rebooted
+const (
+	RecoverHealthy   = "healthy"   // running with its workspace mounted; nothing was done
+	RecoverRestarted = "restarted" // its stopped container or sidecars were started and set up again
+	RecoverRemounted = "remounted" // running, but its overlay workspace had to be mounted again
+	RecoverRecreated = "recreated" // its container was gone and was recreated
+	RecoverFailed    = "failed"    // recovering the agent failed
+)
+
+// RecoverResult is the outcome of recovering one agent. Integrity is "ok" when git
+// fsck found the repository intact, or what it reported otherwise.
+type RecoverResult struct {
+	AgentID   string `json:"agent_id"`
+	Status    string `json:"status"`
+	Integrity string `json:"integrity,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// overlayMounted checks inside a container whether the overlay workspace is mounted,
+// by the kernel or fuse-overlayfs
+const overlayMounted = "grep -qs ' /workspace/merged ' /proc/mounts"
+
+// clearServiceState forgets the supervisor processes of a restarted container, whose
+// PIDs may since belong to other processes
+const clearServiceState = "rm -f /run/capsulate/services/*/supervisor /run/capsulate/services/*/child"
+
+// Recover brings the given agents back after their containers stopped, e.g. when the
+// host rebooted: stopped containers and sidecars are started, overlay workspaces
+// mounted again, dependency layers relinked and services marked autostart started;
+// containers that are gone are recreated. The repository of each agent is then
+// checked with git fsck. Agents are recovered one at a time, and a failure does not
+// stop the others. progress, if not nil, is called after each agent.
+func (m *Manager) Recover(agentIDs []string, progress func(RecoverResult)) []RecoverResult {
+	results := make([]RecoverResult, 0, len(agentIDs))
+	for _, agentID := range agentIDs {
+		result := RecoverResult{AgentID: agentID}
+		status, err := m.recoverAgent(agentID)
+		if err != nil {
+			result.Status, result.Error = RecoverFailed, err.Error()
+		} else {
+			result.Status = status
+			result.Integrity = m.checkIntegrity(agentID)
+		}
+		results = append(results, result)
+		if progress != nil {
+			progress(result)
+		}
+	}
+	return results
+}
+
+// recoverAgent recovers one agent under its locks and returns the outcome
+func (m *Manager) recoverAgent(agentID string) (status string, err error) {
+	ctx := context.Background()
+
+	unlock := m.agentLocks.lock(agentID)
+	defer unlock()
+	release, err := m.store.Lock(agentID, "recovered")
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.Recover", map[string]interface{}{
+		"agent_id": agentID,
+	})
+	defer func() {
+		if err != nil {
+			tracing.RecordError(spanID, err)
+			tracing.EndSpanError(spanID, err.Error())
+			return
+		}
+		tracing.AddEvent(spanID, "agent_recovered", map[string]interface{}{"status": status})
+		tracing.EndSpanSuccess(spanID)
+		if status != RecoverHealthy {
+			metrics.RecordCount("agent_recovered", metrics.ContainerOps, 1, agentID)
+		}
+	}()
+
+	st, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+
+	config := agentConfigFromState(st)
+
+	info, err := m.dockerClient.ContainerInspect(ctx, m.containerName(agentID))
+	if errdefs.IsNotFound(err) {
+		if err := m.recreate(ctx, agentID); err != nil {
+			return "", err
+		}
+		return RecoverRecreated, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	if info.State.Running {
+		status = RecoverHealthy
+		if info.State.Paused {
+			if err := m.dockerClient.ContainerUnpause(ctx, info.ID); err != nil {
+				return "", fmt.Errorf("failed to unpause container: %w", err)
+			}
+			status = RecoverRestarted
+		}
+		started, err := m.startStoppedSidecars(ctx, agentID)
+		if err != nil {
+			return "", err
+		}
+		if started > 0 {
+			status = RecoverRestarted
+		}
+		if st.UseOverlay {
+			if _, err := m.Exec(agentID, overlayMounted); err != nil {
+				if err := m.prepareWorkspace(config); err != nil {
+					return "", err
+				}
+				if err := m.relinkDependencies(agentID, st.Overrides); err != nil {
+					return "", err
+				}
+				status = RecoverRemounted
+			}
+		}
+		return status, nil
+	}
+
+	// The container kept its files but lost its processes and mounts
+	if err := m.dockerClient.ContainerStart(ctx, info.ID, types.ContainerStartOptions{}); err != nil {
+		return "", fmt.Errorf("failed to start container: %w", err)
+	}
+	if _, err := m.startStoppedSidecars(ctx, agentID); err != nil {
+		return "", err
+	}
+	if _, err := m.Exec(agentID, clearServiceState); err != nil {
+		return "", fmt.Errorf("failed to clear service state: %w", err)
+	}
+	if err := m.prepareWorkspace(config); err != nil {
+		return "", err
+	}
+	if err := m.relinkDependencies(agentID, st.Overrides); err != nil {
+		return "", err
+	}
+	if st.SSHServer {
+		if err := m.startSSHServer(ctx, &config); err != nil {
+			return "", err
+		}
+	}
+	if err := m.autostartServices(agentID); err != nil {
+		return "", err
+	}
+	return RecoverRestarted, nil
+}
+
+// startStoppedSidecars starts the sidecar containers of an agent that are not running
+// and returns how many were started
+func (m *Manager) startStoppedSidecars(ctx context.Context, agentID string) (int, error) {
+	containers, err := m.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", "capsulate.agent-id="+agentID),
+			filters.Arg("label", SidecarLabel),
+		),
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list sidecars: %w", err)
+	}
+	started := 0
+	for _, c := range containers {
+		if c.Labels[ProjectLabel] != m.project || c.State == "running" {
+			continue
+		}
+		if err := m.dockerClient.ContainerStart(ctx, c.ID, types.ContainerStartOptions{}); err != nil {
+			return started, fmt.Errorf("failed to start sidecar '%s': %w", c.Labels[SidecarLabel], err)
+		}
+		started++
+	}
+	return started, nil
+}
+
+// checkIntegrity runs git fsck on an agent's repository and returns "ok", or the
+// problems it reported
+func (m *Manager) checkIntegrity(agentID string) string {
+	output, err := m.ExecArgs(agentID, repoRoot, "git", "fsck", "--connectivity-only", "--no-progress")
+	if err == nil {
+		return "ok"
+	}
+	if output = strings.TrimSpace(output); output != "" {
+		return output
+	}
+	return err.Error()
+}
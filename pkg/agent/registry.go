@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/your-org/capsulate-repo/pkg/secrets"
+)
+
+// registryCredentials holds the rendered credential files for the configured private
+// registries. Files are written to the home directory of the container user, outside
+// /workspace, so they never show up in overlay diffs, workspace syncs or layer snapshots.
+type registryCredentials struct {
+	// files maps a path relative to the home directory to its contents
+	files map[string]string
+	// env holds non-secret settings such as GOPRIVATE
+	env []string
+}
+
+// registryCredentials resolves the secrets of the configured registries and renders
+// .npmrc, pip.conf and .netrc for them
+func (m *Manager) registryCredentials() (*registryCredentials, error) {
+	creds := &registryCredentials{files: map[string]string{}}
+	if len(m.config.Registries) == 0 {
+		return creds, nil
+	}
+
+	var npmrc, pipConf, netrc strings.Builder
+	var goPrivate []string
+	for _, registry := range m.config.Registries {
+		token, err := secrets.Resolve(registry.Token)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve credentials for registry %s: %w", registry.URL, err)
+		}
+		parsed, err := url.Parse(registry.URL)
+		if err != nil || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid registry url '%s'", registry.URL)
+		}
+
+		switch registry.Provider {
+		case "npm":
+			// npm matches auth entries against the registry URL without its scheme
+			base := strings.TrimSuffix(parsed.Host+parsed.Path, "/") + "/"
+			if registry.Scope != "" {
+				fmt.Fprintf(&npmrc, "%s:registry=%s\n", registry.Scope, registry.URL)
+			} else {
+				fmt.Fprintf(&npmrc, "registry=%s\n", registry.URL)
+			}
+			fmt.Fprintf(&npmrc, "//%s:_authToken=%s\n", base, token)
+
+		case "pip":
+			// The index URL stays credential-free; pip picks up the login from .netrc
+			if pipConf.Len() == 0 {
+				pipConf.WriteString("[global]\n")
+				fmt.Fprintf(&pipConf, "extra-index-url = %s\n", registry.URL)
+			} else {
+				fmt.Fprintf(&pipConf, "    %s\n", registry.URL)
+			}
+			writeNetrc(&netrc, parsed.Hostname(), registry.Username, token)
+
+		case "go":
+			goPrivate = append(goPrivate, registry.Private...)
+			writeNetrc(&netrc, parsed.Hostname(), registry.Username, token)
+		}
+	}
+
+	if npmrc.Len() > 0 {
+		creds.files[".npmrc"] = npmrc.String()
+	}
+	if pipConf.Len() > 0 {
+		creds.files[".config/pip/pip.conf"] = pipConf.String()
+	}
+	if netrc.Len() > 0 {
+		creds.files[".netrc"] = netrc.String()
+	}
+	if len(goPrivate) > 0 {
+		creds.env = append(creds.env, fmt.Sprintf("GOPRIVATE=%s", strings.Join(goPrivate, ",")))
+	}
+
+	return creds, nil
+}
+
+// writeNetrc appends a machine entry; git, go and pip all read logins from .netrc
+func writeNetrc(b *strings.Builder, host, username, token string) {
+	if username == "" {
+		// Token-based hosts (GitHub, GitLab...) accept any non-empty login
+		username = "oauth2"
+	}
+	fmt.Fprintf(b, "machine %s\nlogin %s\npassword %s\n", host, username, token)
+}
+
+// injectRegistryCredentials copies the credential files into the home directory of a
+// container. Files are copied as a tar archive rather than written through exec so that
+// secrets never appear in command lines, traces or logs. uid and gid own the files.
+func (m *Manager) injectRegistryCredentials(ctx context.Context, containerID, home string, uid, gid int, creds *registryCredentials) error {
+	if len(creds.files) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(creds.files))
+	for name := range creds.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now()
+	dirs := map[string]bool{}
+	for _, name := range names {
+		// Parent directories must precede the files inside them
+		for dir := path.Dir(name); dir != "." && !dirs[dir]; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	dirNames := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		dirNames = append(dirNames, dir)
+	}
+	sort.Strings(dirNames)
+	for _, dir := range dirNames {
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     path.Join(strings.TrimPrefix(home, "/"), dir) + "/",
+			Mode:     0700,
+			Uid:      uid,
+			Gid:      gid,
+			ModTime:  now,
+		}); err != nil {
+			return fmt.Errorf("failed to write tar header for %s: %w", dir, err)
+		}
+	}
+	for _, name := range names {
+		content := creds.files[name]
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     path.Join(strings.TrimPrefix(home, "/"), name),
+			Mode:     0600,
+			Size:     int64(len(content)),
+			Uid:      uid,
+			Gid:      gid,
+			ModTime:  now,
+		}); err != nil {
+			return fmt.Errorf("failed to write tar header for %s: %w", name, err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return fmt.Errorf("failed to write %s to tar: %w", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize tar archive: %w", err)
+	}
+
+	if err := m.dockerClient.CopyToContainer(ctx, containerID, "/", &buf, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy registry credentials to container: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// ReviewOptions configures the changeset report produced by Review
+type ReviewOptions struct {
+	BaseRef string   // Ref to compare against; defaults to the upstream branch or origin/HEAD
+	Tests   []string // Test commands to run inside the agent
+	Lints   []string // Lint commands to run inside the agent
+}
+
+// ReviewCommit is a commit made by the agent since the base
+type ReviewCommit struct {
+	SHA     string    `json:"sha"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+}
+
+// ReviewFile is a file touched by the agent since the base
+type ReviewFile struct {
+	Path      string `json:"path"`
+	Additions int    `json:"additions"`
+	Deletions int    `json:"deletions"`
+	Binary    bool   `json:"binary,omitempty"`
+}
+
+// CommandResult records the outcome of a command run inside an agent
+type CommandResult struct {
+	Command  string        `json:"command"`
+	ExitCode int           `json:"exit_code"`
+	Passed   bool          `json:"passed"`
+	Duration time.Duration `json:"duration_ns"`
+	Output   string        `json:"output,omitempty"`
+}
+
+// ReviewReport summarizes an agent's work for human review
+type ReviewReport struct {
+	AgentID     string              `json:"agent_id"`
+	Branch      string              `json:"branch"`
+	BaseRef     string              `json:"base_ref"`
+	BaseCommit  string              `json:"base_commit"`
+	HeadCommit  string              `json:"head_commit"`
+	Commits     []ReviewCommit      `json:"commits"`
+	Files       []ReviewFile        `json:"files"`
+	Additions   int                 `json:"additions"`
+	Deletions   int                 `json:"deletions"`
+	DiffStat    string              `json:"diff_stat"`
+	Tests       []CommandResult     `json:"tests,omitempty"`
+	Lints       []CommandResult     `json:"lints,omitempty"`
+	Checks      []state.CheckResult `json:"checks,omitempty"`
+	GeneratedAt time.Time           `json:"generated_at"`
+	// NoRepository is set for agents created without a repository, which have no
+	// changes to report, only the results of commands
+	NoRepository bool `json:"no_repository,omitempty"`
+}
+
+// Review builds a changeset report of the agent's commits and working tree changes
+// relative to a base ref, running any configured test and lint commands
+func (m *Manager) Review(agentID string, opts ReviewOptions) (*ReviewReport, error) {
+	metrics.StartTimer("review", metrics.GitOps, agentID)
+	defer metrics.StopTimer("review", metrics.GitOps, agentID)
+
+	_, spanID := tracing.StartSpan(context.Background(), "agent.Review", map[string]interface{}{
+		"agent_id": agentID,
+		"base_ref": opts.BaseRef,
+	})
+
+	report, err := m.buildReview(agentID, opts)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	tracing.EndSpanSuccess(spanID)
+	return report, nil
+}
+
+func (m *Manager) buildReview(agentID string, opts ReviewOptions) (*ReviewReport, error) {
+	report := &ReviewReport{
+		AgentID:     agentID,
+		BaseRef:     opts.BaseRef,
+		GeneratedAt: time.Now(),
+	}
+
+	hasRepo, err := m.hasRepository(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if hasRepo {
+		if err := m.reviewChanges(agentID, report); err != nil {
+			return nil, err
+		}
+	} else {
+		report.BaseRef = ""
+		report.NoRepository = true
+	}
+
+	// Test and lint commands
+	for _, command := range opts.Tests {
+		report.Tests = append(report.Tests, m.runRepoCommand(agentID, command, ExecOptions{}))
+	}
+	for _, command := range opts.Lints {
+		report.Lints = append(report.Lints, m.runRepoCommand(agentID, command, ExecOptions{}))
+	}
+
+	// Results of the most recent configured check run
+	if st, exists, err := m.store.Get(agentID); err == nil && exists {
+		report.Checks = st.Checks
+	}
+
+	return report, nil
+}
+
+// reviewChanges fills in the commits and files of a review since its base ref,
+// resolving the ref first when none was given
+func (m *Manager) reviewChanges(agentID string, report *ReviewReport) error {
+	// Resolve the base ref
+	if report.BaseRef == "" {
+		output, err := m.Exec(agentID, "cd /workspace/repo && (git rev-parse --abbrev-ref --symbolic-full-name @{upstream} 2>/dev/null || git rev-parse --abbrev-ref origin/HEAD)")
+		if err != nil {
+			return fmt.Errorf("failed to determine base ref (use an explicit base): %w", err)
+		}
+		report.BaseRef = strings.TrimSpace(output)
+	}
+
+	branch, err := m.Exec(agentID, "cd /workspace/repo && git branch --show-current")
+	if err != nil {
+		return fmt.Errorf("failed to get current branch: %w", err)
+	}
+	report.Branch = strings.TrimSpace(branch)
+
+	head, err := m.Exec(agentID, "cd /workspace/repo && git rev-parse HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to get current commit: %w", err)
+	}
+	report.HeadCommit = strings.TrimSpace(head)
+
+	if err := checkArg("base ref", report.BaseRef); err != nil {
+		return err
+	}
+	base, err := m.ExecArgs(agentID, "/workspace/repo", "git", "merge-base", report.BaseRef, "HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to find merge base with %s: %w", report.BaseRef, err)
+	}
+	report.BaseCommit = strings.TrimSpace(base)
+
+	// Commits made since the base
+	logOutput, err := m.ExecArgs(agentID, "/workspace/repo", "git", "log", "--format=%H%x1f%an%x1f%aI%x1f%s", report.BaseCommit+"..HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to list commits: %w", err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(logOutput), "\n") {
+		fields := strings.Split(line, "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[2])
+		report.Commits = append(report.Commits, ReviewCommit{
+			SHA:     fields[0],
+			Author:  fields[1],
+			Date:    date,
+			Subject: fields[3],
+		})
+	}
+
+	// Files touched, including uncommitted changes in the working tree
+	numstat, err := m.ExecArgs(agentID, "/workspace/repo", "git", "diff", "--numstat", report.BaseCommit)
+	if err != nil {
+		return fmt.Errorf("failed to get diff: %w", err)
+	}
+	report.Files = parseNumstat(numstat)
+	for _, file := range report.Files {
+		report.Additions += file.Additions
+		report.Deletions += file.Deletions
+	}
+
+	diffStat, err := m.ExecArgs(agentID, "/workspace/repo", "git", "diff", "--stat", report.BaseCommit)
+	if err != nil {
+		return fmt.Errorf("failed to get diff stat: %w", err)
+	}
+	report.DiffStat = strings.TrimRight(diffStat, "\n")
+	return nil
+}
+
+// parseNumstat parses the output of git diff --numstat
+func parseNumstat(numstat string) []ReviewFile {
+	var files []ReviewFile
+	for _, line := range strings.Split(strings.TrimSpace(numstat), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		file := ReviewFile{Path: fields[2]}
+		if fields[0] == "-" {
+			file.Binary = true
+		} else {
+			file.Additions, _ = strconv.Atoi(fields[0])
+			file.Deletions, _ = strconv.Atoi(fields[1])
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// runRepoCommand runs a command in the repository, or the agent's working directory
+// within it, and records its result. opts controls how the output is captured.
+func (m *Manager) runRepoCommand(agentID, command string, opts ExecOptions) CommandResult {
+	start := time.Now()
+	res, err := m.ExecWithOptions(agentID, "cd "+shellQuote(m.repoDir(agentID))+" && "+command, opts)
+	result := CommandResult{
+		Command:  command,
+		Passed:   err == nil,
+		Duration: time.Since(start),
+	}
+	if res != nil {
+		result.Output = res.Output
+	}
+
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.Code
+	} else if err != nil {
+		result.ExitCode = -1
+		result.Output = err.Error()
+	}
+	return result
+}
+
+// Markdown renders the report as a Markdown document
+func (r *ReviewReport) Markdown() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Review: agent `%s`\n\n", r.AgentID)
+	if r.NoRepository {
+		fmt.Fprintf(&b, "- **Generated:** %s\n\n", r.GeneratedAt.Format(time.RFC3339))
+		b.WriteString("_The agent has no repository, so there are no changes to review._\n")
+		r.writeResults(&b)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "- **Branch:** `%s`\n", r.Branch)
+	fmt.Fprintf(&b, "- **Base:** `%s` (`%s`)\n", r.BaseRef, shortSHA(r.BaseCommit))
+	fmt.Fprintf(&b, "- **Head:** `%s`\n", shortSHA(r.HeadCommit))
+	fmt.Fprintf(&b, "- **Changes:** %d files, +%d/-%d\n", len(r.Files), r.Additions, r.Deletions)
+	fmt.Fprintf(&b, "- **Generated:** %s\n\n", r.GeneratedAt.Format(time.RFC3339))
+
+	fmt.Fprintf(&b, "## Commits (%d)\n\n", len(r.Commits))
+	if len(r.Commits) == 0 {
+		b.WriteString("_No commits since base._\n")
+	}
+	for _, c := range r.Commits {
+		fmt.Fprintf(&b, "- `%s` %s — %s\n", shortSHA(c.SHA), c.Subject, c.Author)
+	}
+
+	fmt.Fprintf(&b, "\n## Files touched (%d)\n\n", len(r.Files))
+	if len(r.Files) > 0 {
+		b.WriteString("| File | + | - |\n|------|---|---|\n")
+		for _, f := range r.Files {
+			if f.Binary {
+				fmt.Fprintf(&b, "| `%s` | binary | |\n", f.Path)
+			} else {
+				fmt.Fprintf(&b, "| `%s` | %d | %d |\n", f.Path, f.Additions, f.Deletions)
+			}
+		}
+	}
+	if r.DiffStat != "" {
+		fmt.Fprintf(&b, "\n```\n%s\n```\n", r.DiffStat)
+	}
+
+	r.writeResults(&b)
+	return b.String()
+}
+
+// writeResults renders the test, lint and check results of the report
+func (r *ReviewReport) writeResults(b *strings.Builder) {
+	writeList := func(title string, results []CommandResult) {
+		if len(results) == 0 {
+			return
+		}
+		fmt.Fprintf(b, "\n## %s\n\n", title)
+		for _, res := range results {
+			mark := "✅"
+			if !res.Passed {
+				mark = "❌"
+			}
+			fmt.Fprintf(b, "- %s `%s` (exit %d, %s)\n", mark, res.Command, res.ExitCode, res.Duration.Round(time.Millisecond))
+		}
+	}
+	writeList("Tests", r.Tests)
+	writeList("Lints", r.Lints)
+
+	if len(r.Checks) > 0 {
+		b.WriteString("\n## Checks (last run)\n\n")
+		for _, check := range r.Checks {
+			mark := "✅"
+			if !check.Passed {
+				mark = "❌"
+			}
+			fmt.Fprintf(b, "- %s **%s** `%s` (exit %d, %s)\n", mark, check.Name, check.Command, check.ExitCode, check.Duration.Round(time.Millisecond))
+			if check.Reports == nil {
+				continue
+			}
+			if summary := check.Reports.String(); summary != "" {
+				fmt.Fprintf(b, "  - %s\n", summary)
+			}
+			if check.Reports.Tests != nil {
+				for _, name := range check.Reports.Tests.Failures {
+					fmt.Fprintf(b, "  - ❌ `%s`\n", name)
+				}
+			}
+		}
+	}
+}
+
+// shortSHA abbreviates a commit hash for display
+func shortSHA(sha string) string {
+	if len(sha) > 12 {
+		return sha[:12]
+	}
+	return sha
+}
@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/errdefs"
+)
+
+// rollbackStep undoes one step of a create
+type rollbackStep struct {
+	name string
+	undo func() error
+}
+
+// rollback records the steps a create has completed so that a failed create can be
+// undone in reverse order instead of leaving a half-configured agent behind
+type rollback struct {
+	steps []rollbackStep
+}
+
+// add records a completed step and how to undo it
+func (r *rollback) add(name string, undo func() error) {
+	r.steps = append(r.steps, rollbackStep{name: name, undo: undo})
+}
+
+// run undoes the recorded steps, newest first. Every step is attempted; the names of
+// the steps that could not be undone are returned with their errors.
+func (r *rollback) run() error {
+	var failed []string
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		if err := r.steps[i].undo(); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", r.steps[i].name, err))
+		}
+	}
+	r.steps = nil
+	if len(failed) > 0 {
+		return fmt.Errorf("rollback incomplete (%s)", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// addDirs records the removal of those directories that do not exist yet, to be
+// called before a step creates them
+func (r *rollback) addDirs(dirs ...string) {
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			continue
+		}
+		dir := dir
+		r.add("directory "+dir, func() error {
+			return os.RemoveAll(dir)
+		})
+	}
+}
+
+// addContainer records the removal of an agent's container and sidecars, to be
+// called before a step creates them
+func (r *rollback) addContainer(m *Manager, agentID string) {
+	r.add("container", func() error {
+		if err := m.removeSidecars(context.Background(), agentID); err != nil {
+			return err
+		}
+		err := m.dockerClient.ContainerRemove(context.Background(), m.containerName(agentID), types.ContainerRemoveOptions{Force: true})
+		if errdefs.IsNotFound(err) {
+			return nil
+		}
+		return err
+	})
+}
@@ -0,0 +1,319 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// defaultScanTimeout limits each scanner run when scan.timeout is not set
+const defaultScanTimeout = 10 * time.Minute
+
+// Kinds of scan targets
+const (
+	ScanTargetImage = "image" // a container image
+	ScanTargetLayer = "layer" // a dependency layer directory on the host
+)
+
+// ScanTarget is an image or a dependency layer scanned for vulnerabilities
+type ScanTarget struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"` // image reference or layer name
+	Ref  string `json:"ref"`  // image ID or host directory
+}
+
+// Vulnerability is a finding of the scanner
+type Vulnerability struct {
+	Target       string `json:"target"`
+	ID           string `json:"id"`
+	Package      string `json:"package"`
+	Version      string `json:"version"`
+	FixedVersion string `json:"fixed_version,omitempty"`
+	Severity     string `json:"severity"` // one of config.ScanSeverities, or "unknown"
+	Title        string `json:"title,omitempty"`
+}
+
+// ScanReport is the outcome of Scan
+type ScanReport struct {
+	AgentID         string          `json:"agent_id,omitempty"`
+	Scanner         string          `json:"scanner"`
+	Targets         []ScanTarget    `json:"targets"`
+	Vulnerabilities []Vulnerability `json:"vulnerabilities"`
+	// Counts are the numbers of vulnerabilities by severity
+	Counts map[string]int `json:"counts"`
+}
+
+// AtOrAbove returns the number of vulnerabilities of severity threshold or higher
+func (r *ScanReport) AtOrAbove(threshold string) int {
+	rank := severityRank(threshold)
+	count := 0
+	for _, vuln := range r.Vulnerabilities {
+		if severityRank(vuln.Severity) >= rank {
+			count++
+		}
+	}
+	return count
+}
+
+// Scan runs the configured vulnerability scanner (trivy or grype) on the host against
+// an agent's image and its core, team and container dependency layers, or against the
+// base image alone when agentID is empty. Findings are normalized to the severities
+// low, medium, high and critical, most severe first.
+func (m *Manager) Scan(agentID string) (*ScanReport, error) {
+	ctx := context.Background()
+
+	scanner := m.config.Scan.ScannerName()
+	command := m.config.Scan.Command
+	if command == "" {
+		command = scanner
+	}
+	if _, err := exec.LookPath(command); err != nil {
+		return nil, fmt.Errorf("scanner %s not found: install it or set scan.command in capsulate.yaml", command)
+	}
+
+	metrics.StartTimer("scan", metrics.ContainerOps, agentID)
+	defer metrics.StopTimer("scan", metrics.ContainerOps, agentID)
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.Scan", map[string]interface{}{
+		"agent_id": agentID,
+		"scanner":  scanner,
+	})
+
+	targets, err := m.scanTargets(ctx, agentID)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	report := &ScanReport{
+		AgentID:         agentID,
+		Scanner:         scanner,
+		Targets:         targets,
+		Vulnerabilities: []Vulnerability{},
+		Counts:          make(map[string]int),
+	}
+	for _, target := range targets {
+		vulns, err := m.runScanner(ctx, scanner, command, target)
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, err
+		}
+		report.Vulnerabilities = append(report.Vulnerabilities, vulns...)
+	}
+	for _, vuln := range report.Vulnerabilities {
+		report.Counts[vuln.Severity]++
+	}
+	sort.SliceStable(report.Vulnerabilities, func(i, j int) bool {
+		return severityRank(report.Vulnerabilities[i].Severity) > severityRank(report.Vulnerabilities[j].Severity)
+	})
+
+	tracing.AddEvent(spanID, "scanned", map[string]interface{}{
+		"targets":         len(targets),
+		"vulnerabilities": len(report.Vulnerabilities),
+	})
+	tracing.EndSpanSuccess(spanID)
+	return report, nil
+}
+
+// scanTargets returns the image of an agent and its dependency layers that hold
+// packages, or the base image when agentID is empty
+func (m *Manager) scanTargets(ctx context.Context, agentID string) ([]ScanTarget, error) {
+	if agentID == "" {
+		if err := m.ensureBaseImage(ctx, ""); err != nil {
+			return nil, err
+		}
+		imageID, err := m.BaseImageID()
+		if err != nil {
+			return nil, err
+		}
+		return []ScanTarget{{Kind: ScanTargetImage, Name: m.baseImageName, Ref: imageID}}, nil
+	}
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+	st, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+	inspect, err := m.dockerClient.ContainerInspect(ctx, m.containerName(agentID))
+	if err != nil {
+		return nil, agentError(agentID, err)
+	}
+
+	targets := []ScanTarget{{Kind: ScanTargetImage, Name: inspect.Config.Image, Ref: inspect.Image}}
+	layers := []ScanTarget{{Kind: ScanTargetLayer, Name: "core", Ref: m.coreDepsPath}}
+	if st.DependencyLevel == "team" && st.TeamID != "" {
+		teamDir := m.teamLayerPath(st.TeamID)
+		if st.TeamSnapshot != "" {
+			teamDir = m.teamSnapshotPath(st.TeamID, st.TeamSnapshot)
+		}
+		layers = append(layers, ScanTarget{Kind: ScanTargetLayer, Name: "team", Ref: teamDir})
+	}
+	layers = append(layers, ScanTarget{Kind: ScanTargetLayer, Name: "container", Ref: filepath.Join(m.containerDepsPath, agentID)})
+	for _, layer := range layers {
+		if entries, err := os.ReadDir(layer.Ref); err == nil && len(entries) > 0 {
+			targets = append(targets, layer)
+		}
+	}
+	return targets, nil
+}
+
+// runScanner scans one target and returns its vulnerabilities
+func (m *Manager) runScanner(ctx context.Context, scanner, command string, target ScanTarget) ([]Vulnerability, error) {
+	timeout := time.Duration(m.config.Scan.Timeout)
+	if timeout <= 0 {
+		timeout = defaultScanTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var args []string
+	switch {
+	case scanner == config.ScannerGrype && target.Kind == ScanTargetImage:
+		args = []string{"docker:" + target.Ref, "-o", "json", "-q"}
+	case scanner == config.ScannerGrype:
+		args = []string{"dir:" + target.Ref, "-o", "json", "-q"}
+	case target.Kind == ScanTargetImage:
+		args = []string{"image", "--quiet", "--format", "json", target.Ref}
+	default:
+		args = []string{"fs", "--quiet", "--format", "json", target.Ref}
+	}
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s timed out scanning %s after %s", scanner, target.Name, timeout)
+		}
+		return nil, fmt.Errorf("%s failed to scan %s: %v\n%s", scanner, target.Name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	name := target.Kind + " " + target.Name
+	if scanner == config.ScannerGrype {
+		return parseGrype(name, stdout.Bytes())
+	}
+	return parseTrivy(name, stdout.Bytes())
+}
+
+// trivyReport is the part of trivy's JSON output scan reads
+type trivyReport struct {
+	Results []struct {
+		Target          string `json:"Target"`
+		Vulnerabilities []struct {
+			VulnerabilityID  string `json:"VulnerabilityID"`
+			PkgName          string `json:"PkgName"`
+			InstalledVersion string `json:"InstalledVersion"`
+			FixedVersion     string `json:"FixedVersion"`
+			Severity         string `json:"Severity"`
+			Title            string `json:"Title"`
+		} `json:"Vulnerabilities"`
+	} `json:"Results"`
+}
+
+// parseTrivy converts trivy's JSON output into vulnerabilities of target
+func parseTrivy(target string, data []byte) ([]Vulnerability, error) {
+	var report trivyReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse trivy output: %w", err)
+	}
+	var vulns []Vulnerability
+	for _, result := range report.Results {
+		for _, v := range result.Vulnerabilities {
+			vulns = append(vulns, Vulnerability{
+				Target:       target,
+				ID:           v.VulnerabilityID,
+				Package:      v.PkgName,
+				Version:      v.InstalledVersion,
+				FixedVersion: v.FixedVersion,
+				Severity:     normalizeSeverity(v.Severity),
+				Title:        v.Title,
+			})
+		}
+	}
+	return vulns, nil
+}
+
+// grypeReport is the part of grype's JSON output scan reads
+type grypeReport struct {
+	Matches []struct {
+		Vulnerability struct {
+			ID          string `json:"id"`
+			Severity    string `json:"severity"`
+			Description string `json:"description"`
+			Fix         struct {
+				Versions []string `json:"versions"`
+			} `json:"fix"`
+		} `json:"vulnerability"`
+		Artifact struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"artifact"`
+	} `json:"matches"`
+}
+
+// parseGrype converts grype's JSON output into vulnerabilities of target
+func parseGrype(target string, data []byte) ([]Vulnerability, error) {
+	var report grypeReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to parse grype output: %w", err)
+	}
+	var vulns []Vulnerability
+	for _, match := range report.Matches {
+		vulns = append(vulns, Vulnerability{
+			Target:       target,
+			ID:           match.Vulnerability.ID,
+			Package:      match.Artifact.Name,
+			Version:      match.Artifact.Version,
+			FixedVersion: strings.Join(match.Vulnerability.Fix.Versions, ", "),
+			Severity:     normalizeSeverity(match.Vulnerability.Severity),
+			Title:        firstLine(match.Vulnerability.Description),
+		})
+	}
+	return vulns, nil
+}
+
+// normalizeSeverity maps the severities of the scanners to config.ScanSeverities;
+// grype's negligible counts as low and anything unrecognized as unknown
+func normalizeSeverity(severity string) string {
+	severity = strings.ToLower(severity)
+	if severity == "negligible" {
+		return "low"
+	}
+	if config.ValidScanSeverity(severity) {
+		return severity
+	}
+	return "unknown"
+}
+
+// severityRank orders severities; unknown ranks below low
+func severityRank(severity string) int {
+	for i, known := range config.ScanSeverities {
+		if severity == known {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// firstLine returns the first line of text
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return line
+}
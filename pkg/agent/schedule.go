@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"errors"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/schedule"
+)
+
+// scheduleOutputCapture is the number of output bytes kept in a schedule's history
+const scheduleOutputCapture = 8 << 10
+
+// RunSchedule runs a schedule's command in its agent's working directory and records
+// the execution in the schedule's history
+func (m *Manager) RunSchedule(sched *schedule.Schedule) (schedule.Execution, error) {
+	metrics.StartTimer("run_schedule", metrics.ContainerOps, sched.AgentID)
+	defer metrics.StopTimer("run_schedule", metrics.ContainerOps, sched.AgentID)
+
+	exec := schedule.Execution{StartedAt: time.Now()}
+	result, err := m.execArgv(sched.AgentID, sched.Command, ExecOptions{
+		MaxCapture: scheduleOutputCapture,
+		WorkingDir: m.repoDir(sched.AgentID),
+	})
+	exec.Duration = time.Since(exec.StartedAt)
+	m.recordCommand(sched.AgentID, formatArgv(sched.Command), exec.StartedAt, result)
+
+	if result != nil {
+		// The command ran; a non-zero exit is recorded, not an error
+		exec.Output = result.Output
+		exec.ExitCode = result.ExitCode
+		exec.Passed = result.ExitCode == 0
+	} else {
+		exec.ExitCode = -1
+		exec.Error = err.Error()
+	}
+	if !exec.Passed {
+		metrics.RecordCount("schedule_failures", metrics.ContainerOps, 1, sched.AgentID)
+	}
+
+	err = m.schedules.Record(sched.ID, exec)
+	m.notify(config.EventJobFinished, sched.AgentID, map[string]interface{}{
+		"schedule":    sched.ID,
+		"command":     sched.Command,
+		"passed":      exec.Passed,
+		"exit_code":   exec.ExitCode,
+		"duration_ms": exec.Duration.Milliseconds(),
+		"error":       exec.Error,
+	})
+	return exec, err
+}
+
+// RunDueSchedules runs every schedule that is due at now, one after another. It
+// returns the schedules that ran, each with its new execution last in History.
+// Schedules of agents that no longer exist are removed instead of run. A failure to
+// record an execution does not stop the other schedules; the failures are returned
+// together.
+func (m *Manager) RunDueSchedules(now time.Time) ([]*schedule.Schedule, error) {
+	schedules, err := m.schedules.List("")
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []*schedule.Schedule
+	var errs []error
+	for _, sched := range schedules {
+		if !sched.Due(now) {
+			continue
+		}
+		if _, exists, err := m.store.Get(sched.AgentID); err != nil {
+			errs = append(errs, err)
+			continue
+		} else if !exists {
+			if err := m.schedules.Remove(sched.ID); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		exec, err := m.RunSchedule(sched)
+		sched.LastRun = exec.StartedAt
+		sched.History = append(sched.History, exec)
+		ran = append(ran, sched)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return ran, errors.Join(errs...)
+}
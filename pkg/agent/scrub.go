@@ -0,0 +1,197 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/deps"
+	"github.com/your-org/capsulate-repo/pkg/scrub"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// scrubDirs are the directories of an agent that outlive its container: the
+// repository (the workspace or overlay diff on the host) and its container-level
+// dependencies
+var scrubDirs = []string{repoRoot, deps.ContainerMount}
+
+// diffScript prints the changes of an agent's repository as a unified diff,
+// untracked files included, for the secret scan
+const diffScript = `cd ` + repoRoot + ` 2>/dev/null || exit 0
+git diff HEAD --no-color --no-ext-diff 2>/dev/null
+git ls-files -z --others --exclude-standard | while IFS= read -r -d '' file; do
+	git diff --no-color --no-ext-diff --no-index -- /dev/null "$file"
+done
+exit 0
+`
+
+// ScrubReport lists the credentials removed from an agent and the secrets left in its
+// changes
+type ScrubReport struct {
+	AgentID string `json:"agent_id"`
+	DryRun  bool   `json:"dry_run,omitempty"`
+	// Removed are credential files deleted, Scrubbed .npmrc files stripped of their
+	// auth entries
+	Removed  []string `json:"removed"`
+	Scrubbed []string `json:"scrubbed"`
+	// Tracked are credential files committed to the repository, left in place
+	Tracked []string `json:"tracked,omitempty"`
+	// Secrets are likely credentials in the agent's uncommitted changes, only reported
+	Secrets []scrub.Secret `json:"secrets"`
+}
+
+// Clean reports whether nothing was, or would be, removed and no secret was found
+func (r *ScrubReport) Clean() bool {
+	return len(r.Removed) == 0 && len(r.Scrubbed) == 0 && len(r.Tracked) == 0 && len(r.Secrets) == 0
+}
+
+// Scrub removes credential files (.git-credentials, .netrc, .pypirc, SSH private keys)
+// from the directories of an agent that outlive its container, strips the auth entries
+// of .npmrc files there, and scans the agent's uncommitted changes for secrets, so its
+// files can be shared or archived safely. Files committed to the repository are only
+// reported, and so are the secrets found. With dryRun nothing is modified. The agent's
+// container must be running.
+func (m *Manager) Scrub(agentID string, dryRun bool) (*ScrubReport, error) {
+	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.Scrub", map[string]interface{}{
+		"agent_id": agentID,
+		"dry_run":  dryRun,
+	})
+
+	report, err := m.scrub(ctx, agentID, dryRun)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	tracing.AddEvent(spanID, "scrubbed", map[string]interface{}{
+		"removed":  len(report.Removed),
+		"scrubbed": len(report.Scrubbed),
+		"secrets":  len(report.Secrets),
+	})
+	tracing.EndSpanSuccess(spanID)
+	return report, nil
+}
+
+// scrub does the work of Scrub
+func (m *Manager) scrub(ctx context.Context, agentID string, dryRun bool) (*ScrubReport, error) {
+	report := &ScrubReport{AgentID: agentID, DryRun: dryRun, Removed: []string{}, Scrubbed: []string{}, Secrets: []scrub.Secret{}}
+
+	candidates, err := m.credentialCandidates(agentID)
+	if err != nil {
+		return nil, err
+	}
+	tracked, err := m.trackedFiles(agentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var remove []string
+	for _, file := range candidates {
+		if rel := strings.TrimPrefix(file, repoRoot+"/"); rel != file && tracked[rel] {
+			report.Tracked = append(report.Tracked, file)
+			continue
+		}
+		if path.Base(file) != scrub.Npmrc {
+			remove = append(remove, file)
+			continue
+		}
+
+		data, err := m.ExecArgs(agentID, "", "cat", "--", file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file, err)
+		}
+		scrubbed, changed := scrub.ScrubNpmrc([]byte(data))
+		if !changed {
+			continue
+		}
+		report.Scrubbed = append(report.Scrubbed, file)
+		if !dryRun {
+			if err := m.injectFiles(ctx, m.containerName(agentID), map[string]string{file: string(scrubbed)}, 0600); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(remove) > 0 {
+		if !dryRun {
+			if _, err := m.ExecArgs(agentID, "", append([]string{"rm", "-f", "--"}, remove...)...); err != nil {
+				return nil, fmt.Errorf("failed to remove credential files: %w", err)
+			}
+		}
+		report.Removed = remove
+	}
+
+	// Secrets in the changes are only reported: removing them is the author's call
+	diff, err := m.ExecArgs(agentID, "", "bash", "-c", diffScript)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the changes: %w", err)
+	}
+	report.Secrets = append(report.Secrets, scrub.ScanDiff(diff)...)
+	return report, nil
+}
+
+// credentialCandidates finds the files named like credentials in the directories
+// that outlive an agent's container, Git directories excluded. A host checkout
+// mounted with --from-dir belongs to its owner and is left alone.
+func (m *Manager) credentialCandidates(agentID string) ([]string, error) {
+	dirs := scrubDirs
+	if st, exists, err := m.store.Get(agentID); err != nil {
+		return nil, err
+	} else if exists && st.SourceDir != "" {
+		dirs = []string{deps.ContainerMount}
+	}
+
+	var names []string
+	for _, name := range scrub.CredentialNames() {
+		if len(names) > 0 {
+			names = append(names, "-o")
+		}
+		names = append(names, "-name", name)
+	}
+
+	var files []string
+	for _, dir := range dirs {
+		if _, err := m.ExecArgs(agentID, "", "test", "-d", dir); err != nil {
+			continue
+		}
+		argv := append([]string{"find", dir, "-name", ".git", "-prune", "-o", "-type", "f", "("}, names...)
+		argv = append(argv, ")", "-print")
+		output, err := m.ExecArgs(agentID, "", argv...)
+		if err != nil {
+			return nil, agentError(agentID, fmt.Errorf("failed to look for credential files: %w", err))
+		}
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			if line != "" {
+				files = append(files, line)
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// trackedFiles returns the files committed to an agent's repository, relative to its
+// root
+func (m *Manager) trackedFiles(agentID string) (map[string]bool, error) {
+	tracked := make(map[string]bool)
+	if _, err := m.ExecArgs(agentID, repoRoot, "git", "rev-parse", "--git-dir"); err != nil {
+		return tracked, nil
+	}
+	output, err := m.ExecArgs(agentID, repoRoot, "git", "ls-files", "-z")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tracked files: %w", err)
+	}
+	for _, file := range strings.Split(output, "\x00") {
+		if file != "" {
+			tracked[file] = true
+		}
+	}
+	return tracked, nil
+}
@@ -0,0 +1,330 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/errdefs"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Keys of a search query. Metadata keys are answered from the state store; live keys
+// need the agent's container and are not available when searching history.
+var (
+	searchMetadataKeys = []string{"id", "branch", "team", "template", "repo", "label", "checks", "age"}
+	searchLiveKeys     = []string{"dirty", "tracking", "container"}
+)
+
+// queryTerm is a single key:value requirement of a Query
+type queryTerm struct {
+	key    string
+	value  string
+	negate bool
+	// pattern matches value for the keys compared with globs
+	pattern *regexp.Regexp
+}
+
+// Query is a search over agents. The syntax is a space-separated list of key:value
+// terms that must all hold, e.g. "branch:feature/* dirty:true team:payments". A term
+// prefixed with - must not hold, and a bare word matches agent IDs containing it.
+//
+//	id, branch, team, template, repo   glob; * matches any text, "/" included
+//	label:key=glob, label:key          label value, or that the label is set
+//	checks:passed|failed|none          outcome of the last check run
+//	age:>24h, age:<7d                  time since the agent was created
+//	dirty:true|false                   uncommitted changes (live)
+//	tracking:ahead|behind|...          upstream tracking state (live)
+//	container:running|exited|missing   state of the container (live)
+//
+// branch is the agent's current branch when any live term is given, and the branch
+// it was created on otherwise.
+type Query struct {
+	terms []queryTerm
+	live  bool
+}
+
+// ParseQuery parses a search query; an empty query matches every agent
+func ParseQuery(expr string) (*Query, error) {
+	query := &Query{}
+	for _, word := range strings.Fields(expr) {
+		term := queryTerm{}
+		if strings.HasPrefix(word, "-") {
+			term.negate = true
+			word = word[1:]
+		}
+		key, value, ok := strings.Cut(word, ":")
+		if !ok {
+			key, value = "id", "*"+word+"*"
+		}
+		term.key, term.value = strings.ToLower(key), value
+		if term.value == "" {
+			return nil, fmt.Errorf("invalid search term '%s': missing value", word)
+		}
+
+		switch term.key {
+		case "id", "branch", "team", "template", "repo":
+			term.pattern = globPattern(term.value)
+		case "label":
+			labelKey, labelValue, hasValue := strings.Cut(term.value, "=")
+			if !labelKeyPattern.MatchString(labelKey) {
+				return nil, fmt.Errorf("invalid label key in search term '%s'", word)
+			}
+			if hasValue {
+				term.pattern = globPattern(labelValue)
+			}
+		case "checks":
+			switch term.value {
+			case state.ChecksPassed, state.ChecksFailed, state.ChecksNone:
+			default:
+				return nil, fmt.Errorf("invalid search term '%s': checks is passed, failed or none", word)
+			}
+		case "age":
+			if _, _, err := parseAgeTerm(term.value); err != nil {
+				return nil, fmt.Errorf("invalid search term '%s': %w", word, err)
+			}
+		case "dirty":
+			if _, err := strconv.ParseBool(term.value); err != nil {
+				return nil, fmt.Errorf("invalid search term '%s': dirty is true or false", word)
+			}
+			query.live = true
+		case "tracking", "container":
+			query.live = true
+		default:
+			return nil, fmt.Errorf("unknown search key '%s'; use one of %s", key,
+				strings.Join(append(append([]string{}, searchMetadataKeys...), searchLiveKeys...), ", "))
+		}
+		query.terms = append(query.terms, term)
+	}
+	return query, nil
+}
+
+// globPattern compiles a glob in which * matches any text into an anchored regexp
+func globPattern(glob string) *regexp.Regexp {
+	parts := strings.Split(glob, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+}
+
+// parseAgeTerm parses ">24h" or "<7d" into the comparison and the duration; a day
+// is 24 hours
+func parseAgeTerm(value string) (string, time.Duration, error) {
+	if len(value) < 2 || (value[0] != '>' && value[0] != '<') {
+		return "", 0, fmt.Errorf("age is >duration or <duration, e.g. >24h or <7d")
+	}
+	op, spec := value[:1], value[1:]
+	if days, ok := strings.CutSuffix(spec, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid age '%s'", spec)
+		}
+		return op, time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(spec)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid age '%s'", spec)
+	}
+	return op, d, nil
+}
+
+// searchFields are the values a query is matched against
+type searchFields struct {
+	id, branch, team, template, repo string
+	labels                           map[string]string
+	checks                           string
+	since                            time.Time
+	// Live values; empty when not gathered
+	container string
+	git       *GitStatus
+}
+
+// matches reports whether the fields satisfy every term of the query
+func (q *Query) matches(f searchFields) bool {
+	for _, term := range q.terms {
+		// An agent whose live status is unknown matches neither a live term nor its negation
+		if (term.key == "dirty" || term.key == "tracking") && f.git == nil {
+			return false
+		}
+		if term.key == "container" && f.container == "" {
+			return false
+		}
+		if term.match(f) == term.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// match reports whether the fields satisfy the term, ignoring its negation
+func (t queryTerm) match(f searchFields) bool {
+	switch t.key {
+	case "id":
+		return t.pattern.MatchString(f.id)
+	case "branch":
+		return t.pattern.MatchString(f.branch)
+	case "team":
+		return t.pattern.MatchString(f.team)
+	case "template":
+		return t.pattern.MatchString(f.template)
+	case "repo":
+		return t.pattern.MatchString(f.repo)
+	case "label":
+		labelKey, _, _ := strings.Cut(t.value, "=")
+		value, ok := f.labels[labelKey]
+		return ok && (t.pattern == nil || t.pattern.MatchString(value))
+	case "checks":
+		return f.checks == t.value
+	case "age":
+		op, d, _ := parseAgeTerm(t.value)
+		age := time.Since(f.since)
+		if op == ">" {
+			return age > d
+		}
+		return age < d
+	case "dirty":
+		want, _ := strconv.ParseBool(t.value)
+		return f.git != nil && f.git.Dirty == want
+	case "tracking":
+		return f.git != nil && f.git.Tracking == t.value
+	case "container":
+		return f.container == t.value
+	}
+	return false
+}
+
+// SearchMatch is an agent found by Search, with its live status when the query used it
+type SearchMatch struct {
+	*state.AgentState
+	// Container is the state of the agent's container: running, exited, missing, ...
+	Container string     `json:"container,omitempty"`
+	Git       *GitStatus `json:"git_status,omitempty"`
+	// Error describes why the live status could not be read
+	Error string `json:"error,omitempty"`
+}
+
+// Search returns the agents matching a query, sorted by ID. Live status is gathered
+// concurrently, and only when the query has live terms.
+func (m *Manager) Search(expr string) ([]*SearchMatch, error) {
+	ctx := context.Background()
+
+	query, err := ParseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.Search", map[string]interface{}{
+		"query": expr,
+	})
+
+	states, err := m.store.List()
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+	candidates := make([]*SearchMatch, len(states))
+	ids := make([]string, len(states))
+	for i, st := range states {
+		candidates[i] = &SearchMatch{AgentState: st}
+		ids[i] = st.ID
+	}
+	if query.live {
+		forEachParallel(ids, func(i int, agentID string) {
+			m.searchLiveStatus(ctx, candidates[i])
+		})
+	}
+
+	var matches []*SearchMatch
+	for _, candidate := range candidates {
+		st := candidate.AgentState
+		checks, _, _ := checksStatus(st.Checks)
+		fields := searchFields{
+			id:        st.ID,
+			branch:    st.Branch,
+			team:      st.TeamID,
+			template:  st.Template,
+			repo:      st.RepoURL,
+			labels:    st.Labels,
+			checks:    checks,
+			since:     st.CreatedAt,
+			container: candidate.Container,
+			git:       candidate.Git,
+		}
+		if candidate.Git != nil && candidate.Git.Branch != "" {
+			fields.branch = candidate.Git.Branch
+		}
+		if query.matches(fields) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	tracing.AddEvent(spanID, "search_matched", map[string]interface{}{
+		"agents":  len(states),
+		"matches": len(matches),
+	})
+	tracing.EndSpanSuccess(spanID)
+	return matches, nil
+}
+
+// searchLiveStatus reads the container state and, for a running container, the Git
+// status of a search candidate
+func (m *Manager) searchLiveStatus(ctx context.Context, match *SearchMatch) {
+	info, err := m.dockerClient.ContainerInspect(ctx, m.containerName(match.ID))
+	switch {
+	case errdefs.IsNotFound(err):
+		match.Container = "missing"
+		return
+	case err != nil:
+		match.Error = err.Error()
+		return
+	}
+	match.Container = info.State.Status
+	if !info.State.Running {
+		return
+	}
+	status, err := m.GetGitStatus(match.ID)
+	if err != nil {
+		match.Error = err.Error()
+		return
+	}
+	match.Git = status
+}
+
+// SearchHistory returns the run summaries matching a query, most recent first. Only
+// metadata terms are allowed; age is the time since the run ended.
+func (m *Manager) SearchHistory(expr string) ([]*state.RunSummary, error) {
+	query, err := ParseQuery(expr)
+	if err != nil {
+		return nil, err
+	}
+	if query.live {
+		return nil, fmt.Errorf("history can only be searched by %s", strings.Join(searchMetadataKeys, ", "))
+	}
+
+	summaries, err := m.store.Summaries()
+	if err != nil {
+		return nil, err
+	}
+	var matches []*state.RunSummary
+	for _, summary := range summaries {
+		fields := searchFields{
+			id:       summary.AgentID,
+			branch:   summary.Branch,
+			team:     summary.TeamID,
+			template: summary.Template,
+			repo:     summary.RepoURL,
+			labels:   summary.Labels,
+			checks:   summary.ChecksStatus,
+			since:    summary.EndedAt,
+		}
+		if query.matches(fields) {
+			matches = append(matches, summary)
+		}
+	}
+	return matches, nil
+}
@@ -0,0 +1,179 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+)
+
+// ErrServiceNotFound is returned for services not declared in capsulate.yaml
+var ErrServiceNotFound = errors.New("service not declared")
+
+// States of a service
+const (
+	ServiceRunning = "running" // the service's command is running
+	ServiceBackoff = "backoff" // the command exited and waits to be restarted
+	ServiceExited  = "exited"  // the command exited and its restart policy let it be
+	ServiceStopped = "stopped" // the service was stopped or never started
+)
+
+// Service is the state of a service in an agent
+type Service struct {
+	Name     string `json:"name"`
+	State    string `json:"state"`
+	PID      int    `json:"pid,omitempty"`
+	Restarts int    `json:"restarts"`
+	ExitCode *int   `json:"exit_code,omitempty"`
+	Restart  string `json:"restart"`
+	Declared bool   `json:"declared"`
+}
+
+// ListServices returns the services of an agent: those declared in capsulate.yaml,
+// in declaration order, followed by services started earlier that are no longer
+// declared
+func (m *Manager) ListServices(agentID string) ([]Service, error) {
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+
+	output, err := m.ExecArgs(agentID, "", supervisorPath, "status")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query services: %w", err)
+	}
+	running := parseServiceStatus(output)
+
+	services := make([]Service, 0, len(m.config.Services))
+	seen := make(map[string]bool)
+	for _, declared := range m.config.Services {
+		service, ok := running[declared.Name]
+		if !ok {
+			service = Service{Name: declared.Name, State: ServiceStopped}
+		}
+		service.Restart = declared.RestartPolicy()
+		service.Declared = true
+		services = append(services, service)
+		seen[declared.Name] = true
+	}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		name, _, _ := strings.Cut(line, "\t")
+		if service, ok := running[name]; ok && !seen[name] {
+			services = append(services, service)
+			seen[name] = true
+		}
+	}
+	return services, nil
+}
+
+// StartService starts a declared service in an agent under capsulate-supervisor
+func (m *Manager) StartService(agentID, name string) error {
+	if err := ValidateAgentID(agentID); err != nil {
+		return err
+	}
+	service, err := m.serviceConfig(name)
+	if err != nil {
+		return err
+	}
+
+	metrics.StartTimer("start_service", metrics.ContainerOps, agentID)
+	defer metrics.StopTimer("start_service", metrics.ContainerOps, agentID)
+
+	dir := path.Join(m.repoDir(agentID), service.Dir)
+	if output, err := m.ExecArgs(agentID, "", supervisorPath, "start", service.Name, service.RestartPolicy(), dir, service.Command); err != nil {
+		return serviceError("start", name, output, err)
+	}
+	return nil
+}
+
+// StopService stops a service in an agent along with the processes it started
+func (m *Manager) StopService(agentID, name string) error {
+	if err := ValidateAgentID(agentID); err != nil {
+		return err
+	}
+	if !config.ValidName(name) {
+		return fmt.Errorf("invalid service name '%s'", name)
+	}
+
+	metrics.StartTimer("stop_service", metrics.ContainerOps, agentID)
+	defer metrics.StopTimer("stop_service", metrics.ContainerOps, agentID)
+
+	if output, err := m.ExecArgs(agentID, "", supervisorPath, "stop", name); err != nil {
+		return serviceError("stop", name, output, err)
+	}
+	return nil
+}
+
+// ServiceLogs returns the last lines of a service's output
+func (m *Manager) ServiceLogs(agentID, name string, lines int) (string, error) {
+	if err := ValidateAgentID(agentID); err != nil {
+		return "", err
+	}
+	if !config.ValidName(name) {
+		return "", fmt.Errorf("invalid service name '%s'", name)
+	}
+	if lines <= 0 {
+		lines = 100
+	}
+
+	output, err := m.ExecArgs(agentID, "", supervisorPath, "logs", name, strconv.Itoa(lines))
+	if err != nil {
+		return "", serviceError("read logs of", name, output, err)
+	}
+	return output, nil
+}
+
+// autostartServices starts the declared services marked autostart in a new or
+// recreated agent
+func (m *Manager) autostartServices(agentID string) error {
+	for _, service := range m.config.Services {
+		if !service.Autostart {
+			continue
+		}
+		if err := m.StartService(agentID, service.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// serviceConfig returns the declaration of a service
+func (m *Manager) serviceConfig(name string) (config.ServiceConfig, error) {
+	for _, service := range m.config.Services {
+		if service.Name == name {
+			return service, nil
+		}
+	}
+	return config.ServiceConfig{}, fmt.Errorf("%w: '%s' (declare it under services in %s)", ErrServiceNotFound, name, m.config.Path())
+}
+
+// parseServiceStatus parses the tab-separated output of capsulate-supervisor status
+func parseServiceStatus(output string) map[string]Service {
+	services := make(map[string]Service)
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) < 6 || fields[0] == "" {
+			continue
+		}
+		service := Service{Name: fields[0], State: fields[1], Restart: fields[5]}
+		service.PID, _ = strconv.Atoi(fields[2])
+		service.Restarts, _ = strconv.Atoi(fields[3])
+		if code, err := strconv.Atoi(fields[4]); err == nil {
+			service.ExitCode = &code
+		}
+		services[service.Name] = service
+	}
+	return services
+}
+
+// serviceError describes a failed supervisor command by the message it printed,
+// falling back to the exec error
+func serviceError(action, name, output string, err error) error {
+	if message := strings.TrimSpace(output); message != "" {
+		return fmt.Errorf("failed to %s service '%s': %s", action, name, message)
+	}
+	return fmt.Errorf("failed to %s service '%s': %w", action, name, err)
+}
@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// safeWord matches arguments that need no quoting in a shell command line
+var safeWord = regexp.MustCompile(`^[a-zA-Z0-9_./:=@%+,-]+$`)
+
+// ExecArgs runs a command in an agent container as an argv array, without a shell, so
+// values such as branch names, URLs and paths are passed verbatim and never
+// interpreted. dir sets the working directory; the container's default is used when
+// it is empty. Like Exec, a non-zero exit returns the output along with an *ExitError.
+func (m *Manager) ExecArgs(agentID, dir string, argv ...string) (string, error) {
+	result, err := m.execArgv(agentID, argv, ExecOptions{WorkingDir: dir})
+	if result == nil {
+		return "", err
+	}
+	return result.Output, err
+}
+
+// shellQuote quotes a value for safe use as a single word in a shell command
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// formatArgv renders argv as a shell command line, quoting only the words that need it.
+// It is used for traces and messages; commands are run from the argv itself.
+func formatArgv(argv []string) string {
+	words := make([]string, len(argv))
+	for i, arg := range argv {
+		if safeWord.MatchString(arg) {
+			words[i] = arg
+		} else {
+			words[i] = shellQuote(arg)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// casePattern escapes a glob for a shell case statement so that only the wildcards
+// *, ? and [...] (including [!...]) keep their meaning
+func casePattern(pattern string) string {
+	var b strings.Builder
+	for _, r := range pattern {
+		switch {
+		case r == '*' || r == '?' || r == '[' || r == ']' || r == '!':
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '/' || r == '-' || r == '_' || r == '.':
+		default:
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// checkArg rejects values that git would parse as an option or that contain control
+// characters before they are passed as an argument, e.g. checkArg("branch name", name)
+func checkArg(kind, value string) error {
+	if value == "" {
+		return fmt.Errorf("%s must not be empty", kind)
+	}
+	if strings.HasPrefix(value, "-") {
+		return fmt.Errorf("%s '%s' must not start with '-'", kind, value)
+	}
+	for _, r := range value {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("%s %q must not contain control characters", kind, value)
+		}
+	}
+	return nil
+}
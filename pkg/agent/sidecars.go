@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/go-connections/nat"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// SidecarLabel is the Docker label naming the sidecar a container runs; its
+// capsulate.agent-id label names the agent it belongs to
+const SidecarLabel = "capsulate.sidecar"
+
+// sidecarContainerName returns the container name of one of an agent's sidecars
+func (m *Manager) sidecarContainerName(agentID, name string) string {
+	return m.containerName(agentID) + "." + name
+}
+
+// sidecarPorts returns the ports the agent container exposes and publishes for its
+// template's sidecars. Sidecars join the agent container's network namespace, so
+// their ports are published from it. Ports without a host IP are bound to 127.0.0.1.
+func sidecarPorts(sidecars []config.SidecarConfig) (nat.PortSet, nat.PortMap, error) {
+	var specs []string
+	for _, sidecar := range sidecars {
+		specs = append(specs, sidecar.Ports...)
+	}
+	if len(specs) == 0 {
+		return nil, nil, nil
+	}
+	exposed, bindings, err := nat.ParsePortSpecs(specs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid sidecar port: %w", err)
+	}
+	for port, portBindings := range bindings {
+		for i := range portBindings {
+			if portBindings[i].HostIP == "" {
+				portBindings[i].HostIP = "127.0.0.1"
+			}
+		}
+		bindings[port] = portBindings
+	}
+	return exposed, bindings, nil
+}
+
+// startSidecars creates and starts the sidecar containers of an agent's template in
+// the network namespace of its running container, pulling missing images
+func (m *Manager) startSidecars(ctx context.Context, config *AgentConfig) error {
+	for _, sidecar := range m.config.Templates[config.Template].Sidecars {
+		if _, err := m.ensureImage(ctx, sidecar.Image); err != nil {
+			return fmt.Errorf("sidecar '%s': %w", sidecar.Name, err)
+		}
+
+		env := make([]string, 0, len(sidecar.Env))
+		for key, value := range sidecar.Env {
+			env = append(env, key+"="+value)
+		}
+		sort.Strings(env)
+
+		resp, err := m.dockerClient.ContainerCreate(ctx,
+			&container.Config{
+				Image: sidecar.Image,
+				Cmd:   sidecar.Command,
+				Env:   env,
+				Labels: m.projectLabels(map[string]string{
+					"capsulate.agent-id": config.ID,
+					SidecarLabel:         sidecar.Name,
+				}),
+			},
+			&container.HostConfig{
+				NetworkMode: container.NetworkMode("container:" + m.containerName(config.ID)),
+			},
+			nil, nil, m.sidecarContainerName(config.ID, sidecar.Name))
+		if err != nil {
+			return fmt.Errorf("failed to create sidecar '%s': %w", sidecar.Name, err)
+		}
+		if err := m.dockerClient.ContainerStart(ctx, resp.ID, types.ContainerStartOptions{}); err != nil {
+			return fmt.Errorf("failed to start sidecar '%s': %w", sidecar.Name, err)
+		}
+	}
+	return nil
+}
+
+// removeSidecars removes the sidecar containers of an agent, found by their labels
+// so that sidecars since removed from the template are cleaned up too. Sidecars of a
+// same-named agent in another project are left alone.
+func (m *Manager) removeSidecars(ctx context.Context, agentID string) error {
+	containers, err := m.dockerClient.ContainerList(ctx, types.ContainerListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", "capsulate.agent-id="+agentID),
+			filters.Arg("label", SidecarLabel),
+		),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list sidecars: %w", err)
+	}
+	for _, c := range containers {
+		if c.Labels[ProjectLabel] != m.project {
+			continue
+		}
+		err := m.dockerClient.ContainerRemove(ctx, c.ID, types.ContainerRemoveOptions{Force: true})
+		if err != nil && !errdefs.IsNotFound(err) {
+			return fmt.Errorf("failed to remove sidecar '%s': %w", c.Labels[SidecarLabel], err)
+		}
+	}
+	return nil
+}
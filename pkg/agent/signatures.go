@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// verifyImage checks the cosign signature of an image before it is pulled or run, when
+// signatures.cosign_key is set or in strict mode. An unsigned image is allowed outside
+// strict mode and counted; one whose signature does not verify is always refused.
+// Results are kept for the lifetime of the Manager.
+func (m *Manager) verifyImage(ctx context.Context, image string) error {
+	sigs := m.config.Signatures
+	_, cosignKey := m.config.SignatureKeys()
+	if cosignKey == "" && !sigs.StrictMode() {
+		return nil
+	}
+	if _, ok := m.verifiedImages.Load(image); ok {
+		return nil
+	}
+
+	_, spanID := tracing.StartSpan(ctx, "agent.VerifyImage", map[string]interface{}{
+		"image":  image,
+		"strict": sigs.StrictMode(),
+	})
+	signed, err := config.VerifyImage(sigs, cosignKey, image)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return err
+	}
+	if !signed {
+		tracing.AddEvent(spanID, "image_unsigned", map[string]interface{}{
+			"image": image,
+		})
+		metrics.RecordCount("image_unsigned", metrics.ContainerOps, 1, "")
+	}
+	m.verifiedImages.Store(image, signed)
+	tracing.EndSpanSuccess(spanID)
+	return nil
+}
@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/scrub"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// SnapshotRef is the ref of snapshot bundles pointing at a commit of the agent's
+// uncommitted changes, on top of HEAD
+const SnapshotRef = "refs/capsulate/snapshot"
+
+// snapshotBundle is where the bundle is written inside the container
+const snapshotBundle = "/tmp/capsulate-snapshot.bundle"
+
+// WorkSnapshot is a Git bundle of an agent's refs and uncommitted changes, kept on the
+// host under .capsulate/snapshots
+type WorkSnapshot struct {
+	AgentID string `json:"agent_id"`
+	Path    string `json:"path"`
+	// Commit holds the working tree, untracked files included, as a child of HEAD
+	Commit string `json:"commit"`
+	Size   int64  `json:"size_bytes"`
+}
+
+// UnsavedWork describes the work destroying an agent would lose: uncommitted changes,
+// stashes and commits not on any remote. It is empty when everything is pushed.
+func (m *Manager) UnsavedWork(agentID string) ([]string, error) {
+	status, err := m.GetGitStatus(agentID)
+	if err != nil {
+		return nil, agentError(agentID, err)
+	}
+
+	var unsaved []string
+	if status.Dirty {
+		files := len(status.StagedFiles) + len(status.ModifiedFiles) + len(status.UntrackedFiles) + len(status.ConflictedFiles)
+		unsaved = append(unsaved, fmt.Sprintf("uncommitted changes to %d files", files))
+	}
+	if status.StashCount > 0 {
+		unsaved = append(unsaved, fmt.Sprintf("%d stashes", status.StashCount))
+	}
+
+	switch status.Tracking {
+	case TrackingAhead, TrackingDiverged:
+		unsaved = append(unsaved, fmt.Sprintf("%d commits not pushed to %s", status.AheadCount, status.Upstream))
+	case TrackingNone, TrackingGone:
+		// Without an upstream, count the commits no remote-tracking branch contains
+		if status.CurrentCommit == "" {
+			break
+		}
+		output, err := m.ExecArgs(agentID, repoRoot, "git", "rev-list", "--count", "HEAD", "--not", "--remotes")
+		if err != nil {
+			return nil, fmt.Errorf("failed to count unpushed commits: %w", err)
+		}
+		if n, _ := strconv.Atoi(strings.TrimSpace(output)); n > 0 {
+			unsaved = append(unsaved, fmt.Sprintf("%d commits not on any remote", n))
+		}
+	}
+	return unsaved, nil
+}
+
+// SnapshotWork saves an agent's refs and uncommitted changes, untracked files included
+// and credential files left out, to a Git bundle on the host. The changes are
+// committed to SnapshotRef on top of HEAD without touching the agent's branch, index
+// or working tree. Restore with:
+//
+//	git fetch <bundle> refs/capsulate/snapshot:refs/heads/recovered
+func (m *Manager) SnapshotWork(agentID string) (*WorkSnapshot, error) {
+	ctx := context.Background()
+
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.SnapshotWork", map[string]interface{}{
+		"agent_id": agentID,
+	})
+
+	snapshot, err := m.snapshotWork(ctx, agentID)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	tracing.AddEvent(spanID, "snapshot_written", map[string]interface{}{
+		"size": snapshot.Size,
+	})
+	tracing.EndSpanSuccess(spanID)
+	return snapshot, nil
+}
+
+// snapshotWork does the work of SnapshotWork
+func (m *Manager) snapshotWork(ctx context.Context, agentID string) (*WorkSnapshot, error) {
+	dir := filepath.Join(m.dataDir, "snapshots")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	snapshot := &WorkSnapshot{
+		AgentID: agentID,
+		Path:    filepath.Join(dir, fmt.Sprintf("%s-%s.bundle", agentID, time.Now().UTC().Format("20060102-150405"))),
+	}
+	var err error
+	if snapshot.Commit, snapshot.Size, err = m.writeBundle(ctx, agentID, snapshot.Path); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// writeBundle writes a bundle of an agent's refs and uncommitted work to dest on the
+// host and returns the commit holding the work and the bundle's size. Credential
+// files not committed to the repository are left out of the work.
+func (m *Manager) writeBundle(ctx context.Context, agentID, dest string) (string, int64, error) {
+	var excludes []string
+	for _, name := range scrub.CredentialNames() {
+		excludes = append(excludes, shellQuote(":(exclude,glob)**/"+name))
+	}
+
+	// A temporary index collects the working tree so the agent's own index is unchanged
+	script := `set -e
+index=$(mktemp)
+trap 'rm -f "$index"' EXIT
+export GIT_INDEX_FILE="$index"
+git read-tree HEAD
+git add -A -- . ` + strings.Join(excludes, " ") + `
+tree=$(git write-tree)
+commit=$(git -c user.name=git-capsulate -c user.email=git-capsulate@localhost commit-tree "$tree" -p HEAD -m "Snapshot of uncommitted work")
+git update-ref ` + SnapshotRef + ` "$commit"
+git bundle create -q ` + snapshotBundle + ` --all
+echo "$commit"`
+	output, err := m.ExecArgs(agentID, repoRoot, "sh", "-c", script)
+	if err != nil {
+		return "", 0, agentError(agentID, fmt.Errorf("failed to bundle the repository: %w", err))
+	}
+	defer m.ExecArgs(agentID, repoRoot, "rm", "-f", snapshotBundle)
+
+	reader, _, err := m.dockerClient.CopyFromContainer(ctx, m.containerName(agentID), snapshotBundle)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to copy the bundle: %w", err)
+	}
+	defer reader.Close()
+	tr := tar.NewReader(reader)
+	if _, err := tr.Next(); err != nil {
+		return "", 0, fmt.Errorf("failed to read the bundle: %w", err)
+	}
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	size, err := io.Copy(out, tr)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dest)
+		return "", 0, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return strings.TrimSpace(output), size, nil
+}
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// ErrHostKeyVerification is returned (wrapped) when a Git command fails because the
+// SSH host key of the server is unknown or has changed
+var ErrHostKeyVerification = errors.New("SSH host key verification failed")
+
+// Files provisioning host key verification in agent containers
+const (
+	knownHostsPath = "/etc/ssh/capsulate_known_hosts"
+	sshConfigPath  = "/etc/ssh/ssh_config.d/capsulate.conf"
+)
+
+// providerHostKeys are the host keys published by GitHub, GitLab and Bitbucket
+const providerHostKeys = `github.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl
+github.com ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTYAAAAIbmlzdHAyNTYAAABBBEmKSENjQEezOmxkZMy7opKgwFB9nkt5YRrYMjNuG5N87uRgg6CLrbo5wAdT/y6v0mKV0U2w0WZ2YB/++Tpockg=
+github.com ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABgQCj7ndNxQowgcQnjshcLrqPEiiphnt+VTTvDP6mHBL9j1aNUkY4Ue1gvwnGLVlOhGeYrnZaMgRK6+PKCUXaDbC7qtbW8gIkhL7aGCsOr/C56SJMy/BCZfxd1nWzAOxSDPgVsmerOBYfNqltV9/hWCqBywINIR+5dIg6JTJ72pcEpEjcYgXkE2YEFXV1JHnsKgbLWNlhScqb2UmyRkQyytRLtL+38TGxkxCflmO+5Z8CSSNY7GidjMIZ7Q4zMjA2n1nGrlTDkzwDCsw+wqFPGQA179cnfGWOWRVruj16z6XyvxvjJwbz0wQZ75XK5tKSb7FNyeIEs4TT4jk+S4dhPeAUC5y+bDYirYgM4GC7uEnztnZyaVWQ7B381AK4Qdrwt51ZqExKbQpTUNn+EjqoTwvqNj4kqx5QUCI0ThS/YkOxJCXmPUWZbhjpCg56i+2aB6CmK2JGhn57K5mj0MNdBXA4/WnwH6XoPWJzK5Nyu2zB3nAZp+S5hpQs+p1vN1/wsjk=
+gitlab.com ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAfuCHKVTjquxvt6CM6tdG4SLp1Btn/nOeHHE5UOzRdf
+bitbucket.org ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIIazEu89wgQZ4bqs3d63QSMzYVa0MuJ2e2gKTKqu+UUO
+`
+
+// hostKeyFailure matches the messages ssh prints when it rejects a host key
+var hostKeyFailure = regexp.MustCompile(`Host key verification failed|REMOTE HOST IDENTIFICATION HAS CHANGED|No \S+ host key is known for`)
+
+// sshFiles renders the known_hosts file and ssh_config snippet provisioned in an agent.
+// ssh runs in batch mode so an unknown host fails instead of waiting for a prompt.
+func (m *Manager) sshFiles(acceptNew bool) (map[string]string, error) {
+	var knownHosts strings.Builder
+	// The provisioned file comes first so accept-new can record keys in it
+	userFiles := []string{knownHostsPath}
+
+	for _, source := range m.config.SSH.KnownHostsSources() {
+		switch source {
+		case config.KnownHostsHost:
+			// The user's SSH directory is mounted read-only at /root/.ssh
+			userFiles = append(userFiles, "/root/.ssh/known_hosts")
+		case config.KnownHostsProviders:
+			knownHosts.WriteString(providerHostKeys)
+		default:
+			file, err := m.config.KnownHostsFile(source)
+			if err != nil {
+				return nil, err
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read known_hosts file %s: %w", file, err)
+			}
+			knownHosts.Write(data)
+			if len(data) > 0 && data[len(data)-1] != '\n' {
+				knownHosts.WriteByte('\n')
+			}
+		}
+	}
+
+	strict := "yes"
+	if acceptNew || m.config.SSH.AcceptNew {
+		strict = "accept-new"
+	}
+	sshConfig := fmt.Sprintf(`# Written by git-capsulate: host key verification for agents
+Host *
+    BatchMode yes
+    StrictHostKeyChecking %s
+    UserKnownHostsFile %s
+`, strict, strings.Join(userFiles, " "))
+
+	return map[string]string{
+		knownHostsPath: knownHosts.String(),
+		sshConfigPath:  sshConfig,
+	}, nil
+}
+
+// injectFiles copies files, keyed by absolute container path, into a container as a
+// tar archive. Missing parent directories are created by Docker.
+func (m *Manager) injectFiles(ctx context.Context, containerID string, files map[string]string, mode int64) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	now := time.Now()
+	for _, name := range names {
+		content := files[name]
+		if err := tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     strings.TrimPrefix(name, "/"),
+			Mode:     mode,
+			Size:     int64(len(content)),
+			ModTime:  now,
+		}); err != nil {
+			return fmt.Errorf("failed to write tar header for %s: %w", name, err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return fmt.Errorf("failed to write %s to tar: %w", name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finalize tar archive: %w", err)
+	}
+
+	if err := m.dockerClient.CopyToContainer(ctx, containerID, "/", &buf, types.CopyToContainerOptions{}); err != nil {
+		return fmt.Errorf("failed to copy files to container: %w", err)
+	}
+	return nil
+}
+
+// hostKeyError recognises a host key verification failure in the output of a Git
+// command and returns an error wrapping ErrHostKeyVerification, or nil
+func hostKeyError(repoURL, output string) error {
+	if !hostKeyFailure.MatchString(output) {
+		return nil
+	}
+	target := "the Git server"
+	if host := sshHost(repoURL); host != "" {
+		target = host
+	}
+	return fmt.Errorf("%w for %s: add its key to ~/.ssh/known_hosts or a file listed in ssh.known_hosts, "+
+		"or create the agent with --ssh-accept-new to trust it on first use", ErrHostKeyVerification, target)
+}
+
+// sshHost extracts the host from an SSH repository URL such as
+// git@github.com:org/repo.git or ssh://git@host:2222/org/repo, or returns ""
+func sshHost(repoURL string) string {
+	if strings.Contains(repoURL, "://") {
+		parsed, err := url.Parse(repoURL)
+		if err != nil || (parsed.Scheme != "ssh" && parsed.Scheme != "git+ssh") {
+			return ""
+		}
+		return parsed.Hostname()
+	}
+	// scp-like syntax: [user@]host:path
+	hostPart, _, ok := strings.Cut(repoURL, ":")
+	if !ok || strings.Contains(hostPart, "/") || filepath.IsAbs(repoURL) {
+		return ""
+	}
+	if _, host, ok := strings.Cut(hostPart, "@"); ok {
+		return host
+	}
+	return hostPart
+}
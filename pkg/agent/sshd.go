@@ -0,0 +1,117 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+)
+
+// Files and settings of the SSH server run in agents created with SSHServer
+const (
+	sshdConfigPath     = "/etc/ssh/sshd_config.d/capsulate.conf"
+	authorizedKeysPath = "/root/.ssh/authorized_keys"
+	sshdPort           = nat.Port("22/tcp")
+	// sshdService is the name sshd runs under in capsulate-supervisor
+	sshdService = "sshd"
+)
+
+// sshdConfig only allows root to log in, with a key
+const sshdConfig = `# Managed by git-capsulate
+PasswordAuthentication no
+KbdInteractiveAuthentication no
+PermitRootLogin prohibit-password
+`
+
+// defaultPublicKeys are the public keys in the SSH directory tried, in order, when
+// no authorized key is given
+var defaultPublicKeys = []string{"id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"}
+
+// authorizedKey returns the public key allowed to log in to an agent's SSH server:
+// key if set, otherwise the first default public key in the SSH directory
+func (m *Manager) authorizedKey(key string) (string, error) {
+	if key = strings.TrimSpace(key); key != "" {
+		return key + "\n", nil
+	}
+	for _, name := range defaultPublicKeys {
+		data, err := os.ReadFile(filepath.Join(m.sshDir, name))
+		if err == nil {
+			return strings.TrimSpace(string(data)) + "\n", nil
+		}
+	}
+	return "", fmt.Errorf("no public key to authorize: none of %s found in %s (pass one with --ssh-key)", strings.Join(defaultPublicKeys, ", "), m.sshDir)
+}
+
+// sshPortBinding adds the SSH server's port to the ports an agent container exposes
+// and publishes. It is published on all interfaces so agents on a remote Docker host
+// can be reached; a port of 0 lets Docker assign one.
+func sshPortBinding(port int, exposed nat.PortSet, bindings nat.PortMap) (nat.PortSet, nat.PortMap) {
+	if exposed == nil {
+		exposed = nat.PortSet{}
+	}
+	if bindings == nil {
+		bindings = nat.PortMap{}
+	}
+	hostPort := ""
+	if port != 0 {
+		hostPort = strconv.Itoa(port)
+	}
+	exposed[sshdPort] = struct{}{}
+	bindings[sshdPort] = []nat.PortBinding{{HostPort: hostPort}}
+	return exposed, bindings
+}
+
+// startSSHServer starts sshd in a running agent container under capsulate-supervisor
+// and records the host port Docker published it on in config
+func (m *Manager) startSSHServer(ctx context.Context, config *AgentConfig) error {
+	if _, err := m.ExecArgs(config.ID, "", "test", "-x", "/usr/sbin/sshd"); err != nil {
+		return fmt.Errorf("the base image has no SSH server; rebuild it with 'git-capsulate image refresh'")
+	}
+	command := "mkdir -p /run/sshd && ssh-keygen -A && exec /usr/sbin/sshd -D -e"
+	if output, err := m.ExecArgs(config.ID, "", supervisorPath, "start", sshdService, "always", "/", command); err != nil {
+		return serviceError("start", sshdService, output, err)
+	}
+
+	inspect, err := m.dockerClient.ContainerInspect(ctx, m.containerName(config.ID))
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+	if inspect.NetworkSettings == nil || len(inspect.NetworkSettings.Ports[sshdPort]) == 0 {
+		return fmt.Errorf("the SSH server's port was not published")
+	}
+	port, err := strconv.Atoi(inspect.NetworkSettings.Ports[sshdPort][0].HostPort)
+	if err != nil {
+		return fmt.Errorf("invalid SSH server port: %w", err)
+	}
+	config.SSHServerPort = port
+	return nil
+}
+
+// SSHEndpoint returns the host and port of an agent's SSH server. The host is the
+// Docker daemon's, or localhost when the daemon is reached through a local socket.
+func (m *Manager) SSHEndpoint(agentID string) (string, int, error) {
+	st, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return "", 0, err
+	}
+	if !exists {
+		return "", 0, fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+	if !st.SSHServer {
+		return "", 0, fmt.Errorf("agent '%s' was not created with an SSH server", agentID)
+	}
+
+	host := "localhost"
+	if daemon, err := url.Parse(m.dockerClient.DaemonHost()); err == nil && daemon.Hostname() != "" {
+		switch daemon.Scheme {
+		case "tcp", "http", "https", "ssh":
+			host = daemon.Hostname()
+		}
+	}
+	return host, st.SSHServerPort, nil
+}
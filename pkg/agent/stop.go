@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// runPreStopHooks runs the stop.pre_stop hooks of capsulate.yaml in the agent's
+// repository, in order. The hooks only run in a running container; a failing hook is
+// reported to onError and does not stop the others.
+func (m *Manager) runPreStopHooks(ctx context.Context, agentID, spanID string, onError func(name string, err error)) {
+	hooks := m.config.Stop.PreStop
+	if len(hooks) == 0 {
+		return
+	}
+	info, err := m.dockerClient.ContainerInspect(ctx, m.containerName(agentID))
+	if err != nil || info.State == nil || !info.State.Running {
+		return
+	}
+
+	for _, hook := range hooks {
+		command := hook.Command
+		if hook.Timeout > 0 {
+			command = fmt.Sprintf("timeout %d bash -c %s", int(time.Duration(hook.Timeout).Seconds()), shellQuote(hook.Command))
+		}
+		res := m.runRepoCommand(agentID, command, ExecOptions{})
+		if res.Passed {
+			tracing.AddEvent(spanID, "pre_stop_hook", map[string]interface{}{
+				"hook":        hook.Name,
+				"duration_ms": res.Duration.Milliseconds(),
+			})
+			continue
+		}
+
+		err := fmt.Errorf("exited with code %d", res.ExitCode)
+		if hook.Timeout > 0 && res.ExitCode == timeoutExitCode {
+			err = fmt.Errorf("timed out after %s", time.Duration(hook.Timeout))
+		} else if res.ExitCode < 0 {
+			err = fmt.Errorf("%s", res.Output)
+		}
+		tracing.AddEvent(spanID, "pre_stop_failed", map[string]interface{}{
+			"hook":  hook.Name,
+			"error": err.Error(),
+		})
+		metrics.RecordCount("pre_stop_failed", metrics.ContainerOps, 1, agentID)
+		if onError != nil {
+			onError(hook.Name, err)
+		}
+	}
+}
+
+// stopContainer stops an agent's container: with SIGKILL right away when kill is set,
+// otherwise with SIGTERM and SIGKILL after the timeout, or stop.timeout when nil
+func (m *Manager) stopContainer(ctx context.Context, containerName string, timeout *time.Duration, kill bool) error {
+	if kill {
+		return m.dockerClient.ContainerKill(ctx, containerName, "KILL")
+	}
+	grace := m.config.Stop.StopTimeout()
+	if timeout != nil {
+		grace = *timeout
+	}
+	seconds := int(grace.Seconds())
+	return m.dockerClient.ContainerStop(ctx, containerName, container.StopOptions{Timeout: &seconds})
+}
@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/mount"
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// storageDriver stores the copy-on-write layer of an overlay agent's workspace on
+// the host, on top of the shared base at baseRepoPath
+type storageDriver interface {
+	// name is the driver's storage.driver value
+	name() string
+	// available returns why the driver cannot be used on this host, or nil
+	available() error
+	// create makes an agent's layer unless it exists, e.g. for a recreated agent
+	create(agentID string) error
+	// mounts returns the mounts giving an agent container its layer
+	mounts(agentID string) []mount.Mount
+	// remove deletes an agent's layer if it exists
+	remove(agentID string) error
+}
+
+// storage returns the driver recorded for an agent; agents recorded without one use
+// overlay
+func (m *Manager) storage(driver string) storageDriver {
+	switch driver {
+	case config.StorageDriverBtrfs:
+		return btrfsStorage{base: m.baseRepoPath, layers: m.diffsPath, quota: int64(m.config.Storage.Quota)}
+	case config.StorageDriverZFS:
+		return zfsStorage{base: m.baseRepoPath, layers: m.diffsPath, quota: int64(m.config.Storage.Quota)}
+	}
+	return overlayStorage{base: m.baseRepoPath, diffs: m.diffsPath, work: m.workPath}
+}
+
+// storageDriverFor resolves storage.driver for a new overlay agent. auto selects zfs
+// when the overlay base is a dataset, btrfs when it is a subvolume and overlay
+// otherwise; btrfs and zfs fail early when the host cannot use them.
+func (m *Manager) storageDriverFor() (string, error) {
+	driver := m.config.Storage.DriverName()
+	if driver == config.StorageDriverOverlay {
+		return driver, nil
+	}
+	if driver != config.StorageDriverAuto {
+		if err := m.storage(driver).available(); err != nil {
+			return "", fmt.Errorf("storage.driver is %s, but %w", driver, err)
+		}
+		return driver, nil
+	}
+	for _, candidate := range []string{config.StorageDriverZFS, config.StorageDriverBtrfs} {
+		if m.storage(candidate).available() == nil {
+			return candidate, nil
+		}
+	}
+	return config.StorageDriverOverlay, nil
+}
+
+// snapshotStorage reports whether an agent's layer is a btrfs or zfs snapshot, which
+// the container sees already merged with the base instead of mounting OverlayFS
+func snapshotStorage(driver string) bool {
+	return driver == config.StorageDriverBtrfs || driver == config.StorageDriverZFS
+}
+
+// runStorageCommand runs a btrfs or zfs command on the host and returns its output
+func runStorageCommand(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%s %s: %s", name, args[0], message)
+		}
+		return "", fmt.Errorf("%s %s: %w", name, args[0], err)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// mergedMount bind-mounts a snapshot layer where the overlay would be mounted
+func mergedMount(source string) []mount.Mount {
+	return []mount.Mount{{
+		Type:   mount.TypeBind,
+		Source: source,
+		Target: "/workspace/merged",
+	}}
+}
+
+// overlayStorage keeps an agent's layer in OverlayFS upper and work directories,
+// mounted over the base inside the container
+type overlayStorage struct {
+	base, diffs, work string
+}
+
+func (d overlayStorage) name() string { return config.StorageDriverOverlay }
+
+func (d overlayStorage) available() error { return nil }
+
+func (d overlayStorage) create(agentID string) error {
+	for _, dir := range []string{filepath.Join(d.diffs, agentID), filepath.Join(d.work, agentID)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create overlay directory: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d overlayStorage) mounts(agentID string) []mount.Mount {
+	return []mount.Mount{
+		// The base is shared by every agent and never written
+		{Type: mount.TypeBind, Source: d.base, Target: "/workspace/base", ReadOnly: true},
+		{Type: mount.TypeBind, Source: filepath.Join(d.diffs, agentID), Target: "/workspace/diff"},
+		{Type: mount.TypeBind, Source: filepath.Join(d.work, agentID), Target: "/workspace/work"},
+	}
+}
+
+func (d overlayStorage) remove(agentID string) error {
+	for _, dir := range []string{filepath.Join(d.diffs, agentID), filepath.Join(d.work, agentID)} {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// btrfsStorage keeps an agent's layer in a writable snapshot of the base subvolume,
+// limited by a qgroup when a quota is set
+type btrfsStorage struct {
+	base, layers string
+	quota        int64
+}
+
+func (d btrfsStorage) name() string { return config.StorageDriverBtrfs }
+
+func (d btrfsStorage) available() error {
+	if _, err := runStorageCommand("btrfs", "subvolume", "show", d.base); err != nil {
+		return fmt.Errorf("%s is not a btrfs subvolume: %w", d.base, err)
+	}
+	return nil
+}
+
+func (d btrfsStorage) create(agentID string) error {
+	layer := filepath.Join(d.layers, agentID)
+	if _, err := os.Stat(layer); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(d.layers, 0755); err != nil {
+		return fmt.Errorf("failed to create layers directory: %w", err)
+	}
+	if _, err := runStorageCommand("btrfs", "subvolume", "snapshot", d.base, layer); err != nil {
+		return fmt.Errorf("failed to snapshot overlay base: %w", err)
+	}
+	if d.quota > 0 {
+		// Limits need quotas enabled on the file system: btrfs quota enable <path>
+		if _, err := runStorageCommand("btrfs", "qgroup", "limit", strconv.FormatInt(d.quota, 10), layer); err != nil {
+			d.remove(agentID)
+			return fmt.Errorf("failed to set quota: %w", err)
+		}
+	}
+	return nil
+}
+
+func (d btrfsStorage) mounts(agentID string) []mount.Mount {
+	return mergedMount(filepath.Join(d.layers, agentID))
+}
+
+func (d btrfsStorage) remove(agentID string) error {
+	layer := filepath.Join(d.layers, agentID)
+	if _, err := os.Stat(layer); os.IsNotExist(err) {
+		return nil
+	}
+	if _, err := runStorageCommand("btrfs", "subvolume", "delete", layer); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %w", err)
+	}
+	return nil
+}
+
+// zfsStorage keeps an agent's layer in a clone of a snapshot of the base dataset,
+// mounted in the layers directory and limited by the clone's quota when one is set.
+// The clone is named after the base dataset and the agent, e.g. tank/capsulate-base-a1.
+type zfsStorage struct {
+	base, layers string
+	quota        int64
+}
+
+func (d zfsStorage) name() string { return config.StorageDriverZFS }
+
+// dataset returns the ZFS dataset mounted at the overlay base
+func (d zfsStorage) dataset() (string, error) {
+	output, err := runStorageCommand("zfs", "list", "-H", "-o", "name,mountpoint", d.base)
+	if err != nil {
+		return "", fmt.Errorf("%s is not on a ZFS dataset: %w", d.base, err)
+	}
+	fields := strings.Split(output, "\t")
+	if len(fields) != 2 || filepath.Clean(fields[1]) != filepath.Clean(d.base) {
+		return "", fmt.Errorf("%s is not the mountpoint of a ZFS dataset", d.base)
+	}
+	return fields[0], nil
+}
+
+func (d zfsStorage) available() error {
+	_, err := d.dataset()
+	return err
+}
+
+func (d zfsStorage) create(agentID string) error {
+	dataset, err := d.dataset()
+	if err != nil {
+		return err
+	}
+	clone := dataset + "-" + agentID
+	if _, err := runStorageCommand("zfs", "list", "-H", "-o", "name", clone); err == nil {
+		return nil
+	}
+	snapshot := dataset + "@" + agentID
+	if _, err := runStorageCommand("zfs", "snapshot", snapshot); err != nil {
+		return fmt.Errorf("failed to snapshot overlay base: %w", err)
+	}
+	args := []string{"clone", "-o", "mountpoint=" + filepath.Join(d.layers, agentID)}
+	if d.quota > 0 {
+		args = append(args, "-o", "quota="+strconv.FormatInt(d.quota, 10))
+	}
+	if _, err := runStorageCommand("zfs", append(args, snapshot, clone)...); err != nil {
+		runStorageCommand("zfs", "destroy", snapshot)
+		return fmt.Errorf("failed to clone overlay base: %w", err)
+	}
+	return nil
+}
+
+func (d zfsStorage) mounts(agentID string) []mount.Mount {
+	return mergedMount(filepath.Join(d.layers, agentID))
+}
+
+func (d zfsStorage) remove(agentID string) error {
+	dataset, err := d.dataset()
+	if err != nil {
+		return err
+	}
+	// The clone depends on the snapshot and goes first
+	for _, name := range []string{dataset + "-" + agentID, dataset + "@" + agentID} {
+		if _, err := runStorageCommand("zfs", "list", "-H", "-o", "name", name); err != nil {
+			continue
+		}
+		if _, err := runStorageCommand("zfs", "destroy", name); err != nil {
+			return fmt.Errorf("failed to destroy %s: %w", name, err)
+		}
+	}
+	os.Remove(filepath.Join(d.layers, agentID))
+	return nil
+}
@@ -0,0 +1,310 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Kinds of drafts made by Suggest
+const (
+	SuggestCommitMessage = "commit-message"
+	SuggestPRDescription = "pr-description"
+)
+
+// Sources of a suggestion
+const (
+	SuggestionFromCommand = "command" // drafted by the suggest command in capsulate.yaml
+	SuggestionFromSummary = "summary" // summarized from the changed files and commits
+)
+
+// maxSuggestDiff caps the diff passed to the suggest command
+const maxSuggestDiff = 64 * 1024
+
+// defaultSuggestTimeout limits a suggest command without a configured timeout
+const defaultSuggestTimeout = 2 * time.Minute
+
+// suggestInstructions precede the changes passed to the suggest command
+var suggestInstructions = map[string]string{
+	SuggestCommitMessage: "Write a Git commit message for the changes below: an imperative subject line of at most 72 characters, a blank line and a short body explaining what changed and why. Reply with the message only.",
+	SuggestPRDescription: "Write a pull request description in Markdown for the changes below: a title on the first line, a blank line, a summary of what changed and why, and notes for reviewers. Reply with the description only.",
+}
+
+// suggestChanges are the changes a suggestion describes
+type suggestChanges struct {
+	files   []ReviewFile
+	commits []ReviewCommit // newest first; only for pull request descriptions
+	branch  string
+	stat    string
+	diff    string
+	head    string
+}
+
+// Suggest drafts a commit message or a pull request description for an agent's
+// changes and records it in the agent's state, where commit --suggested picks it up.
+// Commit messages describe the staged changes, or all uncommitted changes when
+// nothing is staged; pull request descriptions cover everything since baseRef (the
+// upstream branch or origin/HEAD when empty).
+func (m *Manager) Suggest(agentID, kind, baseRef string) (*state.Suggestion, error) {
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, err
+	}
+	if _, ok := suggestInstructions[kind]; !ok {
+		return nil, fmt.Errorf("unknown suggestion '%s' (%s or %s)", kind, SuggestCommitMessage, SuggestPRDescription)
+	}
+
+	metrics.StartTimer("suggest", metrics.GitOps, agentID)
+	defer metrics.StopTimer("suggest", metrics.GitOps, agentID)
+
+	ctx, spanID := tracing.StartSpan(context.Background(), "agent.Suggest", map[string]interface{}{
+		"agent_id": agentID,
+		"kind":     kind,
+	})
+
+	var changes *suggestChanges
+	var err error
+	if kind == SuggestCommitMessage {
+		changes, err = m.uncommittedChanges(agentID)
+	} else {
+		changes, err = m.branchChanges(agentID, baseRef)
+	}
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	suggestion := &state.Suggestion{
+		HeadCommit: changes.head,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if m.config.Suggest.Command != "" {
+		suggestion.Source = SuggestionFromCommand
+		suggestion.Text, err = m.runSuggestCommand(ctx, agentID, kind, changes)
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, err
+		}
+	} else {
+		suggestion.Source = SuggestionFromSummary
+		if kind == SuggestCommitMessage {
+			suggestion.Text = summarizeCommit(changes)
+		} else {
+			suggestion.Text = summarizePullRequest(changes)
+		}
+	}
+
+	if err := m.store.Update(agentID, func(st *state.AgentState) error {
+		if st.Suggestions == nil {
+			st.Suggestions = make(map[string]state.Suggestion)
+		}
+		st.Suggestions[kind] = *suggestion
+		return nil
+	}); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, fmt.Errorf("failed to record suggestion: %w", err)
+	}
+
+	tracing.EndSpanSuccess(spanID)
+	return suggestion, nil
+}
+
+// Suggestion returns the latest suggestion of a kind recorded for an agent
+func (m *Manager) Suggestion(agentID, kind string) (*state.Suggestion, error) {
+	st, exists, err := m.store.Get(agentID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+	suggestion, ok := st.Suggestions[kind]
+	if !ok {
+		return nil, fmt.Errorf("no %s has been suggested for agent '%s' (run 'git-capsulate suggest %s %s')", strings.ReplaceAll(kind, "-", " "), agentID, kind, agentID)
+	}
+	return &suggestion, nil
+}
+
+// uncommittedChanges collects the staged changes of an agent, or all of its
+// uncommitted changes when nothing is staged
+func (m *Manager) uncommittedChanges(agentID string) (*suggestChanges, error) {
+	head, err := m.ExecArgs(agentID, repoRoot, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current commit: %w", err)
+	}
+	changes := &suggestChanges{head: strings.TrimSpace(head)}
+
+	diffArgs := []string{"--cached"}
+	numstat, err := m.ExecArgs(agentID, repoRoot, "git", "diff", "--cached", "--numstat")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get diff: %w", err)
+	}
+	if strings.TrimSpace(numstat) == "" {
+		diffArgs = []string{"HEAD"}
+		if numstat, err = m.ExecArgs(agentID, repoRoot, "git", "diff", "HEAD", "--numstat"); err != nil {
+			return nil, fmt.Errorf("failed to get diff: %w", err)
+		}
+	}
+	changes.files = parseNumstat(numstat)
+	if len(changes.files) == 0 {
+		return nil, fmt.Errorf("agent '%s' has no uncommitted changes to describe", agentID)
+	}
+
+	if err := m.collectDiff(agentID, changes, diffArgs...); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// branchChanges collects the commits and changes of an agent since its base
+func (m *Manager) branchChanges(agentID, baseRef string) (*suggestChanges, error) {
+	report, err := m.buildReview(agentID, ReviewOptions{BaseRef: baseRef})
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Files) == 0 && len(report.Commits) == 0 {
+		return nil, fmt.Errorf("agent '%s' has no changes since %s to describe", agentID, report.BaseRef)
+	}
+	changes := &suggestChanges{
+		files:   report.Files,
+		commits: report.Commits,
+		branch:  report.Branch,
+		head:    report.HeadCommit,
+	}
+	if err := m.collectDiff(agentID, changes, report.BaseCommit); err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// collectDiff records the diff stat and the capped patch of the changes
+func (m *Manager) collectDiff(agentID string, changes *suggestChanges, diffArgs ...string) error {
+	stat, err := m.ExecArgs(agentID, repoRoot, append([]string{"git", "diff", "--stat"}, diffArgs...)...)
+	if err != nil {
+		return fmt.Errorf("failed to get diff stat: %w", err)
+	}
+	changes.stat = strings.TrimRight(stat, "\n")
+
+	result, err := m.execArgv(agentID, append([]string{"git", "diff"}, diffArgs...), ExecOptions{WorkingDir: repoRoot, MaxCapture: maxSuggestDiff})
+	if err != nil {
+		return fmt.Errorf("failed to get diff: %w", err)
+	}
+	changes.diff = result.Output
+	return nil
+}
+
+// runSuggestCommand runs the suggest command from capsulate.yaml on the host with
+// the instructions and changes on stdin, returning the draft it prints
+func (m *Manager) runSuggestCommand(ctx context.Context, agentID, kind string, changes *suggestChanges) (string, error) {
+	timeout := time.Duration(m.config.Suggest.Timeout)
+	if timeout <= 0 {
+		timeout = defaultSuggestTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var input strings.Builder
+	input.WriteString(suggestInstructions[kind])
+	if len(changes.commits) > 0 {
+		input.WriteString("\n\nCommits:\n")
+		for i := len(changes.commits) - 1; i >= 0; i-- {
+			fmt.Fprintf(&input, "- %s\n", changes.commits[i].Subject)
+		}
+	}
+	fmt.Fprintf(&input, "\n\nFiles changed:\n%s\n\nDiff:\n%s", changes.stat, changes.diff)
+
+	cmd := exec.CommandContext(ctx, "bash", "-c", m.config.Suggest.Command)
+	cmd.Dir = m.workspaceDir
+	cmd.Env = append(os.Environ(), "CAPSULATE_AGENT_ID="+agentID, "CAPSULATE_SUGGESTION="+kind)
+	cmd.Stdin = strings.NewReader(input.String())
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", fmt.Errorf("suggest command timed out after %s", timeout)
+		}
+		return "", fmt.Errorf("suggest command failed: %v\n%s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	text := strings.TrimSpace(stdout.String())
+	if text == "" {
+		return "", fmt.Errorf("suggest command printed no %s", strings.ReplaceAll(kind, "-", " "))
+	}
+	return text, nil
+}
+
+// summarizeCommit drafts a commit message from the changed files
+func summarizeCommit(changes *suggestChanges) string {
+	var b strings.Builder
+	b.WriteString(changeSubject(changes.files))
+	b.WriteString("\n\n")
+	writeFileList(&b, changes.files)
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// summarizePullRequest drafts a pull request description from the commits and
+// changed files
+func summarizePullRequest(changes *suggestChanges) string {
+	var b strings.Builder
+
+	// A single commit names the change; otherwise the branch does
+	title := changes.branch
+	switch {
+	case len(changes.commits) == 1:
+		title = changes.commits[0].Subject
+	case title == "":
+		title = changeSubject(changes.files)
+	}
+	b.WriteString(title + "\n\n")
+
+	if len(changes.commits) > 0 {
+		b.WriteString("## Changes\n\n")
+		for i := len(changes.commits) - 1; i >= 0; i-- {
+			fmt.Fprintf(&b, "- %s\n", changes.commits[i].Subject)
+		}
+		b.WriteString("\n")
+	}
+	if len(changes.files) > 0 {
+		b.WriteString("## Files\n\n")
+		writeFileList(&b, changes.files)
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// changeSubject summarizes changed files in a subject line, e.g. "Update 3 files in
+// pkg/agent"
+func changeSubject(files []ReviewFile) string {
+	if len(files) == 1 {
+		return "Update " + files[0].Path
+	}
+	dir := path.Dir(files[0].Path)
+	for _, file := range files[1:] {
+		for dir != "." && dir != path.Dir(file.Path) && !strings.HasPrefix(file.Path, dir+"/") {
+			dir = path.Dir(dir)
+		}
+	}
+	if dir == "." {
+		return fmt.Sprintf("Update %d files", len(files))
+	}
+	return fmt.Sprintf("Update %d files in %s", len(files), dir)
+}
+
+// writeFileList writes one line per changed file with its line counts
+func writeFileList(b *strings.Builder, files []ReviewFile) {
+	for _, file := range files {
+		if file.Binary {
+			fmt.Fprintf(b, "- %s (binary)\n", file.Path)
+		} else {
+			fmt.Fprintf(b, "- %s (+%d -%d)\n", file.Path, file.Additions, file.Deletions)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package agent
+
+// supervisorPath is where capsulate-supervisor is installed in agent containers
+const supervisorPath = "/usr/local/bin/capsulate-supervisor"
+
+// supervisorScript is capsulate-supervisor, a small process supervisor for the
+// services declared in capsulate.yaml. Each service runs in its own process group
+// under a monitor loop that applies the restart policy with exponential backoff.
+// State lives in /run/capsulate/services/<name>.
+const supervisorScript = `#!/bin/bash
+# capsulate-supervisor: runs the services of a git-capsulate agent with restart policies
+#
+#   capsulate-supervisor start NAME RESTART DIR COMMAND   start a service (RESTART: always, on-failure, never)
+#   capsulate-supervisor stop NAME                        stop a service and its processes
+#   capsulate-supervisor status                           NAME STATE PID RESTARTS EXIT POLICY, tab separated
+#   capsulate-supervisor logs NAME [LINES]                last lines of a service's output
+set -u
+STATE_DIR=${CAPSULATE_SERVICE_DIR:-/run/capsulate/services}
+
+alive() { [ -n "${1:-}" ] && kill -0 "$1" 2>/dev/null; }
+read_file() { cat "$1" 2>/dev/null; }
+
+supervise() {
+	local name=$1 restart=$2 dir=$3 command=$4
+	local svc="$STATE_DIR/$name" delay=1 code started
+	while :; do
+		echo running > "$svc/state"
+		started=$SECONDS
+		(cd "$dir" && exec setsid bash -c "$command") >> "$svc/output.log" 2>&1 < /dev/null &
+		echo $! > "$svc/child"
+		wait $!
+		code=$?
+		echo "$code" > "$svc/exit_code"
+		[ -f "$svc/stop" ] && break
+		case $restart in
+			always) ;;
+			on-failure) [ "$code" -ne 0 ] || break ;;
+			*) break ;;
+		esac
+		echo $(( $(read_file "$svc/restarts") + 1 )) > "$svc/restarts"
+		echo backoff > "$svc/state"
+		# A service that ran for a while restarts quickly again
+		if [ $(( SECONDS - started )) -ge 60 ]; then delay=1; fi
+		sleep "$delay"
+		[ -f "$svc/stop" ] && break
+		delay=$(( delay < 30 ? delay * 2 : 30 ))
+	done
+	if [ -f "$svc/stop" ]; then echo stopped > "$svc/state"; else echo exited > "$svc/state"; fi
+	rm -f "$svc/child" "$svc/supervisor"
+}
+
+start() {
+	[ $# -eq 4 ] || { echo "usage: capsulate-supervisor start NAME RESTART DIR COMMAND" >&2; exit 2; }
+	local svc="$STATE_DIR/$1"
+	mkdir -p "$svc"
+	if alive "$(read_file "$svc/supervisor")"; then
+		echo "service '$1' is already running" >&2
+		exit 1
+	fi
+	rm -f "$svc/stop" "$svc/exit_code" "$svc/child"
+	echo 0 > "$svc/restarts"
+	echo "$2" > "$svc/policy"
+	nohup setsid "$0" __supervise "$@" > /dev/null 2>&1 < /dev/null &
+	echo $! > "$svc/supervisor"
+}
+
+stop() {
+	[ $# -eq 1 ] || { echo "usage: capsulate-supervisor stop NAME" >&2; exit 2; }
+	local svc="$STATE_DIR/$1" supervisor child i
+	[ -d "$svc" ] || { echo "service '$1' has not been started" >&2; exit 1; }
+	supervisor=$(read_file "$svc/supervisor")
+	child=$(read_file "$svc/child")
+	touch "$svc/stop"
+	alive "$child" && kill -TERM -- "-$child" 2>/dev/null
+	for i in $(seq 1 50); do
+		alive "$supervisor" || break
+		sleep 0.2
+	done
+	if alive "$child"; then kill -KILL -- "-$child" 2>/dev/null; fi
+	if alive "$supervisor"; then kill -KILL "$supervisor" 2>/dev/null; fi
+	echo stopped > "$svc/state"
+	rm -f "$svc/child" "$svc/supervisor"
+}
+
+status() {
+	local svc name state pid
+	for svc in "$STATE_DIR"/*/; do
+		[ -d "$svc" ] || continue
+		name=$(basename "$svc")
+		state=$(read_file "$svc/state")
+		pid=$(read_file "$svc/child")
+		# Services whose supervisor is gone, e.g. after a container restart, are stopped
+		if ! alive "$(read_file "$svc/supervisor")" && [ "$state" != exited ]; then
+			state=stopped
+			pid=
+		fi
+		printf '%s\t%s\t%s\t%s\t%s\t%s\n' "$name" "${state:-stopped}" "$pid" \
+			"$(read_file "$svc/restarts")" "$(read_file "$svc/exit_code")" "$(read_file "$svc/policy")"
+	done
+}
+
+logs() {
+	[ $# -ge 1 ] || { echo "usage: capsulate-supervisor logs NAME [LINES]" >&2; exit 2; }
+	local log="$STATE_DIR/$1/output.log"
+	[ -f "$log" ] || { echo "service '$1' has no output" >&2; exit 1; }
+	tail -n "${2:-100}" "$log"
+}
+
+command=${1:-}
+shift || true
+case $command in
+	start) start "$@" ;;
+	stop) stop "$@" ;;
+	status) status ;;
+	logs) logs "$@" ;;
+	__supervise) supervise "$@" ;;
+	*) echo "usage: capsulate-supervisor start|stop|status|logs" >&2; exit 2 ;;
+esac
+`
@@ -0,0 +1,495 @@
+package agent
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/fsnotify/fsnotify"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// defaultSyncExcludes are skipped unless explicitly synced, so the host's Git
+// metadata never clobbers the repository inside the container
+var defaultSyncExcludes = []string{".git", ".capsulate"}
+
+// syncDebounce is how long the watcher waits for changes to settle before pushing them
+const syncDebounce = 300 * time.Millisecond
+
+// SyncOptions configures a sync between a host directory and an agent container
+type SyncOptions struct {
+	From    string   // Host directory to sync from
+	To      string   // Path inside the container to sync into
+	Exclude []string // Additional patterns (matched against relative paths and base names) to skip
+}
+
+// SyncEvent describes a batch of changes pushed into an agent by WatchSync
+type SyncEvent struct {
+	Copied  []string
+	Removed []string
+	Err     error
+}
+
+// PullResult describes the files brought back from an agent by PullFromAgent
+type PullResult struct {
+	Copied  []string
+	Removed []string
+}
+
+// SyncConflictError is returned when files changed in the agent are also modified locally
+type SyncConflictError struct {
+	Files []string
+}
+
+func (e *SyncConflictError) Error() string {
+	return fmt.Sprintf("%d file(s) modified both locally and in the agent: %s",
+		len(e.Files), strings.Join(e.Files, ", "))
+}
+
+// SyncToAgent copies the full contents of a host directory into an agent container
+// and returns the number of files copied
+func (m *Manager) SyncToAgent(agentID string, opts SyncOptions) (int, error) {
+	ctx := context.Background()
+
+	metrics.StartTimer("sync_to_agent", metrics.FileOps, agentID)
+	defer metrics.StopTimer("sync_to_agent", metrics.FileOps, agentID)
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.SyncToAgent", map[string]interface{}{
+		"agent_id": agentID,
+		"from":     opts.From,
+		"to":       opts.To,
+	})
+
+	var files []string
+	err := filepath.Walk(opts.From, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(opts.From, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		if opts.excluded(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0 {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return 0, fmt.Errorf("failed to scan %s: %w", opts.From, err)
+	}
+
+	if err := m.copyFilesToAgent(ctx, agentID, opts, files); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return 0, err
+	}
+
+	metrics.RecordCount("files_synced", metrics.FileOps, len(files), agentID)
+	tracing.EndSpanSuccess(spanID)
+	return len(files), nil
+}
+
+// WatchSync watches the host directory and pushes changes into the agent until stop is closed.
+// Each pushed batch is reported through onSync, which may be nil.
+func (m *Manager) WatchSync(agentID string, opts SyncOptions, stop <-chan struct{}, onSync func(SyncEvent)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := opts.watchTree(watcher, opts.From); err != nil {
+		return err
+	}
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(syncDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			rel, err := filepath.Rel(opts.From, event.Name)
+			if err != nil || rel == "." || opts.excluded(rel) {
+				continue
+			}
+
+			// Newly created directories need to be watched and their contents pushed
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					opts.watchTree(watcher, event.Name)
+					filepath.Walk(event.Name, func(p string, info os.FileInfo, err error) error {
+						if err == nil && info.Mode().IsRegular() {
+							if r, err := filepath.Rel(opts.From, p); err == nil && !opts.excluded(r) {
+								pending[filepath.ToSlash(r)] = true
+							}
+						}
+						return nil
+					})
+				}
+			}
+
+			pending[filepath.ToSlash(rel)] = true
+			timer.Reset(syncDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			if onSync != nil {
+				onSync(SyncEvent{Err: fmt.Errorf("watch error: %w", err)})
+			}
+
+		case <-timer.C:
+			event := m.flushPending(agentID, opts, pending)
+			pending = make(map[string]bool)
+			if onSync != nil {
+				onSync(event)
+			}
+		}
+	}
+}
+
+// flushPending pushes changed files into the container and removes deleted ones
+func (m *Manager) flushPending(agentID string, opts SyncOptions, pending map[string]bool) SyncEvent {
+	ctx := context.Background()
+
+	var event SyncEvent
+	for rel := range pending {
+		info, err := os.Lstat(filepath.Join(opts.From, filepath.FromSlash(rel)))
+		switch {
+		case os.IsNotExist(err):
+			event.Removed = append(event.Removed, rel)
+		case err != nil:
+			continue
+		case info.Mode().IsRegular() || info.Mode()&os.ModeSymlink != 0:
+			event.Copied = append(event.Copied, rel)
+		}
+	}
+	sort.Strings(event.Copied)
+	sort.Strings(event.Removed)
+
+	if len(event.Copied) > 0 {
+		if err := m.copyFilesToAgent(ctx, agentID, opts, event.Copied); err != nil {
+			event.Err = err
+			return event
+		}
+		metrics.RecordCount("files_synced", metrics.FileOps, len(event.Copied), agentID)
+	}
+
+	if len(event.Removed) > 0 {
+		targets := make([]string, len(event.Removed))
+		for i, rel := range event.Removed {
+			targets[i] = shellQuote(path.Join(opts.To, rel))
+		}
+		if _, err := m.Exec(agentID, "rm -rf -- "+strings.Join(targets, " ")); err != nil {
+			event.Err = fmt.Errorf("failed to remove files in container: %w", err)
+			return event
+		}
+		metrics.RecordCount("files_removed", metrics.FileOps, len(event.Removed), agentID)
+	}
+
+	return event
+}
+
+// PullFromAgent copies files changed inside the agent (per git status at opts.To) back into
+// the host checkout at opts.From. Files that are also modified in the host checkout are not
+// overwritten unless force is set; a *SyncConflictError lists them instead.
+func (m *Manager) PullFromAgent(agentID string, opts SyncOptions, force bool) (*PullResult, error) {
+	ctx := context.Background()
+
+	metrics.StartTimer("sync_from_agent", metrics.FileOps, agentID)
+	defer metrics.StopTimer("sync_from_agent", metrics.FileOps, agentID)
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.PullFromAgent", map[string]interface{}{
+		"agent_id": agentID,
+		"from":     opts.To,
+		"to":       opts.From,
+		"force":    force,
+	})
+
+	// Files changed inside the agent. Porcelain paths are relative to the repository root,
+	// so the prefix of the synced directory is printed first and stripped afterwards.
+	output, err := m.Exec(agentID, fmt.Sprintf("cd %s && git rev-parse --show-prefix && git status --porcelain -z --untracked-files=all -- .", shellQuote(opts.To)))
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, fmt.Errorf("failed to get agent changes: %w", err)
+	}
+	prefix, status, _ := strings.Cut(output, "\n")
+	agentChanges := parsePorcelainZ(status).relativeTo(prefix)
+
+	// Files modified in the host checkout
+	hostPrefix, err := exec.Command("git", "-C", opts.From, "rev-parse", "--show-prefix").Output()
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, fmt.Errorf("'%s' is not a Git checkout: %w", opts.From, err)
+	}
+	hostOutput, err := exec.Command("git", "-C", opts.From, "status", "--porcelain", "-z", "--untracked-files=all", "--", ".").Output()
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, fmt.Errorf("failed to get local changes: %w", err)
+	}
+	hostChanges := parsePorcelainZ(string(hostOutput)).relativeTo(strings.TrimSpace(string(hostPrefix)))
+
+	result := &PullResult{}
+	var conflicts []string
+	for _, change := range agentChanges {
+		if opts.excluded(change.Path) {
+			continue
+		}
+		if _, modified := hostChanges.lookup(change.Path); modified {
+			conflicts = append(conflicts, change.Path)
+		}
+		if change.Deleted {
+			result.Removed = append(result.Removed, change.Path)
+		} else {
+			result.Copied = append(result.Copied, change.Path)
+		}
+	}
+	if len(conflicts) > 0 && !force {
+		sort.Strings(conflicts)
+		err := &SyncConflictError{Files: conflicts}
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	containerName := m.containerName(agentID)
+	for _, rel := range result.Copied {
+		reader, _, err := m.dockerClient.CopyFromContainer(ctx, containerName, path.Join(opts.To, rel))
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, fmt.Errorf("failed to copy %s from container: %w", rel, err)
+		}
+		err = extractSingleFile(reader, filepath.Join(opts.From, filepath.FromSlash(rel)))
+		reader.Close()
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, err
+		}
+	}
+	for _, rel := range result.Removed {
+		if err := os.Remove(filepath.Join(opts.From, filepath.FromSlash(rel))); err != nil && !os.IsNotExist(err) {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, fmt.Errorf("failed to remove %s: %w", rel, err)
+		}
+	}
+
+	metrics.RecordCount("files_pulled", metrics.FileOps, len(result.Copied)+len(result.Removed), agentID)
+	tracing.EndSpanSuccess(spanID)
+	return result, nil
+}
+
+// porcelainChange is a single entry from git status --porcelain -z
+type porcelainChange struct {
+	Path    string
+	Status  string
+	Deleted bool
+}
+
+type porcelainChanges []porcelainChange
+
+// lookup finds the change for a path, if any
+func (c porcelainChanges) lookup(p string) (porcelainChange, bool) {
+	for _, change := range c {
+		if change.Path == p {
+			return change, true
+		}
+	}
+	return porcelainChange{}, false
+}
+
+// relativeTo strips a repository-relative directory prefix from every path
+func (c porcelainChanges) relativeTo(prefix string) porcelainChanges {
+	if prefix == "" {
+		return c
+	}
+	result := make(porcelainChanges, 0, len(c))
+	for _, change := range c {
+		if strings.HasPrefix(change.Path, prefix) {
+			change.Path = strings.TrimPrefix(change.Path, prefix)
+			result = append(result, change)
+		}
+	}
+	return result
+}
+
+// parsePorcelainZ parses NUL-separated git status --porcelain output.
+// Renames are reported as a deletion of the old path and a change of the new one.
+func parsePorcelainZ(output string) porcelainChanges {
+	var changes porcelainChanges
+	entries := strings.Split(output, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		status, file := entry[:2], entry[3:]
+		changes = append(changes, porcelainChange{
+			Path:    file,
+			Status:  status,
+			Deleted: strings.Contains(status, "D"),
+		})
+		if status[0] == 'R' || status[0] == 'C' {
+			i++
+			if status[0] == 'R' && i < len(entries) && entries[i] != "" {
+				changes = append(changes, porcelainChange{Path: entries[i], Status: "D ", Deleted: true})
+			}
+		}
+	}
+	return changes
+}
+
+// extractSingleFile writes the first entry of a tar stream from CopyFromContainer to dest
+func extractSingleFile(reader io.Reader, dest string) error {
+	tr := tar.NewReader(reader)
+	header, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("failed to read archive for %s: %w", dest, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", dest, err)
+	}
+
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		os.Remove(dest)
+		if err := os.Symlink(header.Linkname, dest); err != nil {
+			return fmt.Errorf("failed to create symlink %s: %w", dest, err)
+		}
+	case tar.TypeReg:
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", dest, err)
+		}
+		defer f.Close()
+		if _, err := io.Copy(f, tr); err != nil {
+			return fmt.Errorf("failed to write %s: %w", dest, err)
+		}
+	default:
+		return fmt.Errorf("unsupported file type for %s", dest)
+	}
+	return nil
+}
+
+// copyFilesToAgent streams the given relative paths into the container as a tar archive
+func (m *Manager) copyFilesToAgent(ctx context.Context, agentID string, opts SyncOptions, files []string) error {
+	if _, err := m.Exec(agentID, "mkdir -p "+shellQuote(opts.To)); err != nil {
+		return fmt.Errorf("failed to create %s in container: %w", opts.To, err)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, rel := range files {
+		if err := addFileToTar(tw, filepath.Join(opts.From, filepath.FromSlash(rel)), rel); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to build sync archive: %w", err)
+	}
+
+	containerName := m.containerName(agentID)
+	err := m.dockerClient.CopyToContainer(ctx, containerName, opts.To, &buf, types.CopyToContainerOptions{
+		AllowOverwriteDirWithFile: true,
+	})
+	if err != nil {
+		return agentError(agentID, fmt.Errorf("failed to copy files to container: %w", err))
+	}
+	return nil
+}
+
+// addFileToTar writes a single host file (or symlink) into the archive under name
+func addFileToTar(tw *tar.Writer, hostPath, name string) error {
+	info, err := os.Lstat(hostPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", hostPath, err)
+	}
+
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(hostPath); err != nil {
+			return fmt.Errorf("failed to read link %s: %w", hostPath, err)
+		}
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for %s: %w", hostPath, err)
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header for %s: %w", hostPath, err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(hostPath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", hostPath, err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to archive %s: %w", hostPath, err)
+	}
+	return nil
+}
+
+// watchTree adds root and all non-excluded directories below it to the watcher
+func (o SyncOptions) watchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		if rel, err := filepath.Rel(o.From, p); err == nil && rel != "." && o.excluded(rel) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(p); err != nil {
+			return fmt.Errorf("failed to watch %s: %w", p, err)
+		}
+		return nil
+	})
+}
+
+// excluded reports whether a relative path matches the default or configured exclude patterns
+func (o SyncOptions) excluded(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	patterns := append(append([]string{}, defaultSyncExcludes...), o.Exclude...)
+	for _, pattern := range patterns {
+		for _, part := range strings.Split(rel, "/") {
+			if ok, _ := path.Match(pattern, part); ok {
+				return true
+			}
+		}
+		if ok, _ := path.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,229 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// TeamSnapshot is a frozen copy of a team's dependency layer
+type TeamSnapshot struct {
+	ID        string    `json:"id"`
+	Label     string    `json:"label,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// RolledBackFrom is set on the snapshot taken automatically before a rollback
+	RolledBackFrom string `json:"rolled_back_from,omitempty"`
+}
+
+// snapshotIndexFile lists the snapshots of a team, oldest first
+const snapshotIndexFile = "snapshots.json"
+
+// FreezeTeamDependencies copies the current team dependency layer into a new
+// snapshot that agents can pin with --team-snapshot
+func (m *Manager) FreezeTeamDependencies(teamID, label string) (*TeamSnapshot, error) {
+	metrics.StartTimer("team_deps_freeze", metrics.DependencyOps, "")
+	defer metrics.StopTimer("team_deps_freeze", metrics.DependencyOps, "")
+
+	_, spanID := tracing.StartSpan(context.Background(), "agent.FreezeTeamDependencies", map[string]interface{}{
+		"team_id": teamID,
+		"label":   label,
+	})
+
+	snapshot, err := m.freezeTeam(teamID, label, "")
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	tracing.EndSpanSuccess(spanID)
+	return snapshot, nil
+}
+
+// RollbackTeamDependencies restores the team dependency layer from a snapshot (ID or
+// label). The current layer is frozen first so the rollback can itself be undone.
+// Agents pinned to a snapshot are not affected; other team agents see the change.
+func (m *Manager) RollbackTeamDependencies(teamID, ref string) (*TeamSnapshot, error) {
+	metrics.StartTimer("team_deps_rollback", metrics.DependencyOps, "")
+	defer metrics.StopTimer("team_deps_rollback", metrics.DependencyOps, "")
+
+	_, spanID := tracing.StartSpan(context.Background(), "agent.RollbackTeamDependencies", map[string]interface{}{
+		"team_id":  teamID,
+		"snapshot": ref,
+	})
+
+	target, err := m.resolveTeamSnapshot(teamID, ref)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	if _, err := m.freezeTeam(teamID, "pre-rollback", target.ID); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	// Replace the contents rather than the directory itself, so running agents that
+	// bind-mount the team layer see the restored packages
+	teamPath := m.teamLayerPath(teamID)
+	entries, err := os.ReadDir(teamPath)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, fmt.Errorf("failed to read team layer: %w", err)
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(teamPath, entry.Name())); err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return nil, fmt.Errorf("failed to clear team layer: %w", err)
+		}
+	}
+	if err := copyTree(m.teamSnapshotPath(teamID, target.ID), teamPath); err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, fmt.Errorf("failed to restore snapshot %s: %w", target.ID, err)
+	}
+
+	metrics.RecordCount("team_deps_rollback", metrics.DependencyOps, 1, "")
+	tracing.EndSpanSuccess(spanID)
+	return target, nil
+}
+
+// ListTeamSnapshots returns the snapshots of a team, oldest first
+func (m *Manager) ListTeamSnapshots(teamID string) ([]TeamSnapshot, error) {
+	path := filepath.Join(m.teamSnapshotsDir(teamID), snapshotIndexFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var snapshots []TeamSnapshot
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return snapshots, nil
+}
+
+// freezeTeam copies the team layer into a new snapshot and records it in the index
+func (m *Manager) freezeTeam(teamID, label, rolledBackFrom string) (*TeamSnapshot, error) {
+	teamPath := m.teamLayerPath(teamID)
+	if _, err := os.Stat(teamPath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("team '%s' does not exist", teamID)
+	}
+
+	snapshots, err := m.ListTeamSnapshots(teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	id := now.Format("20060102-150405")
+	for suffix := 2; hasSnapshot(snapshots, id); suffix++ {
+		id = fmt.Sprintf("%s-%d", now.Format("20060102-150405"), suffix)
+	}
+
+	snapshot := TeamSnapshot{ID: id, Label: label, CreatedAt: now, RolledBackFrom: rolledBackFrom}
+	if err := copyTree(teamPath, m.teamSnapshotPath(teamID, id)); err != nil {
+		os.RemoveAll(m.teamSnapshotPath(teamID, id))
+		return nil, fmt.Errorf("failed to snapshot team '%s': %w", teamID, err)
+	}
+
+	snapshots = append(snapshots, snapshot)
+	data, err := json.MarshalIndent(snapshots, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot index: %w", err)
+	}
+	path := filepath.Join(m.teamSnapshotsDir(teamID), snapshotIndexFile)
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	metrics.RecordCount("team_deps_snapshot", metrics.DependencyOps, 1, "")
+	return &snapshot, nil
+}
+
+// resolveTeamSnapshot finds a snapshot by ID, or the most recent one with the given label
+func (m *Manager) resolveTeamSnapshot(teamID, ref string) (*TeamSnapshot, error) {
+	snapshots, err := m.ListTeamSnapshots(teamID)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if snapshots[i].ID == ref || snapshots[i].Label == ref {
+			return &snapshots[i], nil
+		}
+	}
+	return nil, fmt.Errorf("team '%s' has no dependency snapshot '%s'", teamID, ref)
+}
+
+// hasSnapshot reports whether a snapshot ID is already taken
+func hasSnapshot(snapshots []TeamSnapshot, id string) bool {
+	for _, snapshot := range snapshots {
+		if snapshot.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// teamLayerPath returns the live dependency layer of a team
+func (m *Manager) teamLayerPath(teamID string) string {
+	return filepath.Join(m.workspaceDir, ".capsulate", "dependencies", "team", teamID)
+}
+
+// teamSnapshotsDir returns the directory holding a team's snapshots and their index
+func (m *Manager) teamSnapshotsDir(teamID string) string {
+	return filepath.Join(m.workspaceDir, ".capsulate", "dependencies", "team-snapshots", teamID)
+}
+
+// teamSnapshotPath returns the directory of a single team snapshot
+func (m *Manager) teamSnapshotPath(teamID, snapshotID string) string {
+	return filepath.Join(m.teamSnapshotsDir(teamID), snapshotID)
+}
+
+// copyTree copies a directory tree, preserving file modes and symlinks
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm())
+		case info.Mode().IsRegular():
+			in, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, in); err != nil {
+				out.Close()
+				return err
+			}
+			return out.Close()
+		}
+		return nil
+	})
+}
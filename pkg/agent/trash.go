@@ -0,0 +1,318 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Files of a trash entry
+const (
+	trashMetaFile   = "trash.json"
+	trashStateFile  = "state.json"
+	trashBundleFile = "repo.bundle"
+)
+
+// ErrNotInTrash is returned (wrapped) by Undelete when no destroyed agent matches
+var ErrNotInTrash = errors.New("not in the trash")
+
+// TrashEntry is a destroyed agent kept in .capsulate/trash until its retention ends
+type TrashEntry struct {
+	// ID names the entry's directory: the agent ID, the time it was destroyed and a
+	// random suffix
+	ID        string    `json:"id"`
+	AgentID   string    `json:"agent_id"`
+	DeletedAt time.Time `json:"deleted_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// Dirs are the agent's host directories moved into the entry
+	Dirs []string `json:"dirs"`
+	// Bundle holds the agent's refs and uncommitted work, if it could be written
+	Bundle bool  `json:"bundle"`
+	Size   int64 `json:"size_bytes"`
+	// Path is the entry's directory
+	Path string `json:"-"`
+}
+
+// trashDir is a host directory of an agent and its name inside a trash entry
+type trashDir struct {
+	name string
+	path string
+}
+
+// trashDirs names the directories of agentDirs inside a trash entry
+func (m *Manager) trashDirs(agentID string) []trashDir {
+	names := []string{"workspace", "diff", "work", "container-deps"}
+	var dirs []trashDir
+	for i, path := range m.agentDirs(agentID) {
+		dirs = append(dirs, trashDir{name: names[i], path: path})
+	}
+	return dirs
+}
+
+// trashPath returns the directory holding the trash
+func (m *Manager) trashPath() string {
+	return filepath.Join(m.dataDir, "trash")
+}
+
+// beginTrash starts the trash entry of an agent being destroyed, writing a bundle of
+// its repository while the container still runs. It returns nil when the trash is
+// disabled. A bundle that cannot be written, e.g. for a stopped container, is skipped.
+func (m *Manager) beginTrash(ctx context.Context, agentID string, spanID string) (*TrashEntry, error) {
+	if !m.config.Trash.TrashEnabled() {
+		return nil, nil
+	}
+	// The random suffix keeps apart the entries of an agent destroyed, recreated and
+	// destroyed again within a second
+	suffix := make([]byte, 3)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to create trash entry: %w", err)
+	}
+	now := time.Now().UTC()
+	entry := &TrashEntry{
+		ID:        fmt.Sprintf("%s-%s-%s", agentID, now.Format("20060102-150405"), hex.EncodeToString(suffix)),
+		AgentID:   agentID,
+		DeletedAt: now,
+		ExpiresAt: now.Add(m.config.Trash.RetentionPeriod()),
+	}
+	entry.Path = filepath.Join(m.trashPath(), entry.ID)
+	if err := os.MkdirAll(m.trashPath(), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create trash entry: %w", err)
+	}
+	if err := os.Mkdir(entry.Path, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create trash entry: %w", err)
+	}
+	// Recorded right away so that an interrupted destroy still leaves a purgeable entry
+	if err := writeTrashEntry(entry); err != nil {
+		return nil, fmt.Errorf("failed to create trash entry: %w", err)
+	}
+
+	if _, _, err := m.writeBundle(ctx, agentID, filepath.Join(entry.Path, trashBundleFile)); err != nil {
+		tracing.AddEvent(spanID, "trash_bundle_failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+	} else {
+		entry.Bundle = true
+	}
+	return entry, nil
+}
+
+// finishTrash moves the agent's state record and host directories into its trash
+// entry. A ZFS layer is a mountpoint that cannot be moved and is left to the destroy.
+func (m *Manager) finishTrash(entry *TrashEntry, st *state.AgentState) error {
+	if st != nil {
+		data, err := json.MarshalIndent(st, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode state: %w", err)
+		}
+		if err := os.WriteFile(filepath.Join(entry.Path, trashStateFile), data, 0600); err != nil {
+			return fmt.Errorf("failed to write state to the trash: %w", err)
+		}
+	}
+	for _, dir := range m.trashDirs(entry.AgentID) {
+		if _, err := os.Stat(dir.path); err != nil {
+			continue
+		}
+		if dir.name == "diff" && st != nil && st.StorageDriver == config.StorageDriverZFS {
+			continue
+		}
+		if err := os.Rename(dir.path, filepath.Join(entry.Path, dir.name)); err != nil {
+			return fmt.Errorf("failed to move %s to the trash: %w", dir.path, err)
+		}
+		entry.Dirs = append(entry.Dirs, dir.name)
+	}
+	entry.Size = dirSize(entry.Path)
+	return writeTrashEntry(entry)
+}
+
+// writeTrashEntry records an entry's metadata in its directory
+func writeTrashEntry(entry *TrashEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(entry.Path, trashMetaFile), data, 0600)
+}
+
+// ListTrash returns the destroyed agents in the trash, newest first
+func (m *Manager) ListTrash() ([]*TrashEntry, error) {
+	dirs, err := os.ReadDir(m.trashPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trash: %w", err)
+	}
+
+	var entries []*TrashEntry
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		path := filepath.Join(m.trashPath(), dir.Name())
+		data, err := os.ReadFile(filepath.Join(path, trashMetaFile))
+		if err != nil {
+			// Not an entry
+			continue
+		}
+		entry := &TrashEntry{}
+		if err := json.Unmarshal(data, entry); err != nil {
+			continue
+		}
+		entry.Path = path
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].DeletedAt.After(entries[j].DeletedAt)
+	})
+	return entries, nil
+}
+
+// PurgeTrash removes the trash entries whose retention has ended, or all of them, and
+// returns the entries removed
+func (m *Manager) PurgeTrash(all bool) ([]*TrashEntry, error) {
+	entries, err := m.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var purged []*TrashEntry
+	for _, entry := range entries {
+		if !all && now.Before(entry.ExpiresAt) {
+			continue
+		}
+		if err := os.RemoveAll(entry.Path); err != nil {
+			return purged, fmt.Errorf("failed to remove trash entry %s: %w", entry.ID, err)
+		}
+		purged = append(purged, entry)
+	}
+	if len(purged) > 0 {
+		metrics.RecordCount("trash_purged", metrics.ContainerOps, len(purged), "")
+	}
+	return purged, nil
+}
+
+// Undelete restores a destroyed agent from the trash: its host directories and state
+// are moved back and its container is recreated from the recorded configuration.
+// name is an agent ID, restoring its most recent entry, or the ID of an entry.
+func (m *Manager) Undelete(name string) (*TrashEntry, error) {
+	ctx := context.Background()
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.Undelete", map[string]interface{}{
+		"name": name,
+	})
+
+	entry, err := m.undelete(ctx, name)
+	if err != nil {
+		tracing.EndSpanError(spanID, err.Error())
+		return nil, err
+	}
+
+	tracing.EndSpanSuccess(spanID)
+	return entry, nil
+}
+
+// undelete does the work of Undelete
+func (m *Manager) undelete(ctx context.Context, name string) (*TrashEntry, error) {
+	entries, err := m.ListTrash()
+	if err != nil {
+		return nil, err
+	}
+	var entry *TrashEntry
+	for _, e := range entries {
+		if e.ID == name || e.AgentID == name {
+			entry = e
+			break
+		}
+	}
+	if entry == nil {
+		return nil, fmt.Errorf("agent '%s' is %w", name, ErrNotInTrash)
+	}
+	agentID := entry.AgentID
+	if err := ValidateAgentID(agentID); err != nil {
+		return nil, fmt.Errorf("trash entry %s: %w", entry.ID, err)
+	}
+
+	// Serialize with Create and Destroy calls for this agent from before the check that
+	// it does not exist again until its container is recreated
+	unlock := m.agentLocks.lock(agentID)
+	defer unlock()
+	release, err := m.store.Lock(agentID, "restored from the trash")
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	// Another undelete may have restored the entry while this one waited
+	if _, err := os.Stat(filepath.Join(entry.Path, trashMetaFile)); err != nil {
+		return nil, fmt.Errorf("agent '%s' is %w", name, ErrNotInTrash)
+	}
+
+	data, err := os.ReadFile(filepath.Join(entry.Path, trashStateFile))
+	if err != nil {
+		return nil, fmt.Errorf("trash entry %s has no recorded state to recreate the agent from; its files are in %s", entry.ID, entry.Path)
+	}
+	st := &state.AgentState{}
+	if err := json.Unmarshal(data, st); err != nil {
+		return nil, fmt.Errorf("failed to read state of trash entry %s: %w", entry.ID, err)
+	}
+
+	if _, exists, err := m.store.Get(agentID); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, fmt.Errorf("agent '%s' exists again; destroy it or restore the files in %s by hand", agentID, entry.Path)
+	}
+	for _, dir := range m.trashDirs(agentID) {
+		if !entry.hasDir(dir.name) {
+			continue
+		}
+		if _, err := os.Stat(dir.path); err == nil {
+			return nil, fmt.Errorf("%s already exists; restore the files in %s by hand", dir.path, entry.Path)
+		}
+	}
+
+	// Move the directories back, then the state the container is recreated from
+	for _, dir := range m.trashDirs(agentID) {
+		if !entry.hasDir(dir.name) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dir.path), 0755); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(filepath.Join(entry.Path, dir.name), dir.path); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", dir.path, err)
+		}
+	}
+	if err := m.store.Save(st); err != nil {
+		return nil, fmt.Errorf("failed to restore state: %w", err)
+	}
+	if err := m.recreate(ctx, agentID); err != nil {
+		return nil, fmt.Errorf("files and state restored, but the container could not be recreated: %w", err)
+	}
+
+	metrics.RecordCount("agent_undeleted", metrics.ContainerOps, 1, agentID)
+	if err := os.RemoveAll(entry.Path); err != nil {
+		return entry, fmt.Errorf("agent restored, but the trash entry could not be removed: %w", err)
+	}
+	return entry, nil
+}
+
+// hasDir reports whether the entry holds the agent directory named name
+func (e *TrashEntry) hasDir(name string) bool {
+	for _, dir := range e.Dirs {
+		if dir == name {
+			return true
+		}
+	}
+	return false
+}
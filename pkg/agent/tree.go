@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+)
+
+// TreeEntry is a file or directory in an agent's repository, as returned by ListTree
+type TreeEntry struct {
+	Name     string       `json:"name"`
+	Path     string       `json:"path"` // relative to the repository root; "" for the root
+	Type     string       `json:"type"` // "file", "dir", "symlink" or "other"
+	Size     int64        `json:"size"`
+	Mode     string       `json:"mode"` // permission bits in octal, e.g. "0644"
+	ModTime  time.Time    `json:"mtime"`
+	Children []*TreeEntry `json:"children,omitempty"`
+}
+
+// treeTypes maps find's %y file types to TreeEntry types
+var treeTypes = map[string]string{"f": "file", "d": "dir", "l": "symlink"}
+
+// ListTree lists a directory of an agent's repository, given relative to the
+// repository root ("" for the root), as a tree listed in one call. depth limits how many
+// levels below the directory are listed; zero lists everything. The .git directory is
+// always skipped, and with respectGitignore so are files ignored by Git.
+func (m *Manager) ListTree(agentID, dir string, depth int, respectGitignore bool) (*TreeEntry, error) {
+	metrics.StartTimer("list_tree", metrics.FileOps, agentID)
+	defer metrics.StopTimer("list_tree", metrics.FileOps, agentID)
+
+	root := ""
+	if dir != "" {
+		cleaned, err := cleanRepoPath(dir)
+		if err != nil {
+			return nil, err
+		}
+		root = cleaned
+	}
+	if depth < 0 {
+		return nil, fmt.Errorf("depth must not be negative")
+	}
+
+	args := []string{"find", path.Join(repoRoot, root)}
+	if depth > 0 {
+		args = append(args, "-maxdepth", strconv.Itoa(depth))
+	}
+	args = append(args, "-name", ".git", "-prune", "-o", "-printf", `%y\t%s\t%m\t%T@\t%P\0`)
+	output, err := m.ExecArgs(agentID, "", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", path.Join(repoRoot, root), err)
+	}
+
+	var ignored map[string]bool
+	if respectGitignore {
+		lsArgs := []string{"git", "ls-files", "--others", "--ignored", "--exclude-standard", "--directory", "-z"}
+		if root != "" {
+			lsArgs = append(lsArgs, "--", root)
+		}
+		ignoredOutput, err := m.ExecArgs(agentID, repoRoot, lsArgs...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ignored files: %w", err)
+		}
+		ignored = make(map[string]bool)
+		for _, p := range strings.Split(ignoredOutput, "\x00") {
+			if p != "" {
+				ignored[strings.TrimSuffix(p, "/")] = true
+			}
+		}
+	}
+
+	tree := parseTree(root, output, ignored)
+	if tree == nil {
+		return nil, fmt.Errorf("failed to list %s: no such directory", path.Join(repoRoot, root))
+	}
+	return tree, nil
+}
+
+// parseTree builds the tree rooted at root from find -printf '%y\t%s\t%m\t%T@\t%P\0'
+// output, leaving out ignored paths and everything below them. find lists
+// directories before their contents, so parents are always known.
+func parseTree(root, output string, ignored map[string]bool) *TreeEntry {
+	var tree *TreeEntry
+	entries := make(map[string]*TreeEntry)
+	for _, record := range strings.Split(output, "\x00") {
+		fields := strings.SplitN(record, "\t", 5)
+		if len(fields) != 5 {
+			continue
+		}
+		rel := fields[4]
+		entry := &TreeEntry{
+			Path: path.Join(root, rel),
+			Type: treeTypes[fields[0]],
+			Mode: "0" + fields[2],
+		}
+		if entry.Type == "" {
+			entry.Type = "other"
+		}
+		entry.Size, _ = strconv.ParseInt(fields[1], 10, 64)
+		sec, frac, _ := strings.Cut(fields[3], ".")
+		secs, _ := strconv.ParseInt(sec, 10, 64)
+		nsecs, _ := strconv.ParseInt((frac + "000000000")[:9], 10, 64)
+		entry.ModTime = time.Unix(secs, nsecs).UTC()
+
+		if rel == "" {
+			entry.Name = path.Base(path.Join(repoRoot, root))
+			tree = entry
+			entries[""] = entry
+			continue
+		}
+		entry.Name = path.Base(rel)
+		if ignored[entry.Path] {
+			continue
+		}
+		parentRel := path.Dir(rel)
+		if parentRel == "." {
+			parentRel = ""
+		}
+		// Parents missing from the map were ignored or pruned
+		if parent, ok := entries[parentRel]; ok {
+			parent.Children = append(parent.Children, entry)
+			entries[rel] = entry
+		}
+	}
+
+	for _, entry := range entries {
+		sort.Slice(entry.Children, func(i, j int) bool {
+			return entry.Children[i].Name < entry.Children[j].Name
+		})
+	}
+	return tree
+}
@@ -0,0 +1,242 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/your-org/capsulate-repo/pkg/dockerclient"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// PoolLabel is the label of the agents pre-created by Warm; its value is the name of
+// the pool, the template they were created from or "default"
+const PoolLabel = "pool"
+
+// defaultPool names the pool of agents created without a template
+const defaultPool = "default"
+
+// WarmOptions selects what Warm prepares
+type WarmOptions struct {
+	// Template whose images are pulled and whose pooled agents are created
+	Template string
+	// Count is the number of pooled agents to keep; 0 creates none
+	Count int
+	// Repository, branch and platform of the pooled agents
+	RepoURL  string
+	Branch   string
+	Platform string
+	// SkipPrefetch leaves the clone cache alone
+	SkipPrefetch bool
+	// SkipCapacityCheck creates the pooled agents without checking that the host
+	// has room for them
+	SkipCapacityCheck bool
+}
+
+// WarmStep is the outcome of one step of Warm
+type WarmStep struct {
+	Name     string        `json:"name"`
+	Detail   string        `json:"detail,omitempty"`
+	Duration time.Duration `json:"duration_ns"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// WarmReport is the outcome of Warm
+type WarmReport struct {
+	Steps []WarmStep `json:"steps"`
+	// Pool is the name of the pool and PoolAgents its agents after warming, sorted
+	Pool       string   `json:"pool,omitempty"`
+	PoolAgents []string `json:"pool_agents,omitempty"`
+	// Created lists the pooled agents created by this run
+	Created []string `json:"created,omitempty"`
+}
+
+// Warm prepares the host for a batch of agents: it builds the base image, pulls the
+// sidecar images of the template, refreshes the clone cache with the repositories
+// under cache.prefetch and tops up the pool of pre-created agents labeled
+// pool=<template> to Count. Every step is attempted; the error reports the failed ones.
+func (m *Manager) Warm(opts WarmOptions) (*WarmReport, error) {
+	ctx := context.Background()
+
+	if opts.Count < 0 {
+		return nil, fmt.Errorf("invalid pool size %d", opts.Count)
+	}
+	if opts.Platform != "" {
+		platform, err := dockerclient.NormalizePlatform(opts.Platform)
+		if err != nil {
+			return nil, err
+		}
+		opts.Platform = platform
+	}
+	template, ok := m.config.Templates[opts.Template]
+	if opts.Template != "" && !ok {
+		return nil, fmt.Errorf("unknown template '%s': not defined under templates in %s", opts.Template, m.config.Path())
+	}
+
+	metrics.StartTimer("warm", metrics.ContainerOps, "")
+	defer metrics.StopTimer("warm", metrics.ContainerOps, "")
+
+	ctx, spanID := tracing.StartSpan(ctx, "agent.Warm", map[string]interface{}{
+		"template": opts.Template,
+		"count":    opts.Count,
+	})
+
+	report := &WarmReport{}
+	failed := 0
+	step := func(name string, fn func() (string, error)) {
+		start := time.Now()
+		detail, err := fn()
+		s := WarmStep{Name: name, Detail: detail, Duration: time.Since(start)}
+		if err != nil {
+			s.Error = err.Error()
+			failed++
+			tracing.AddEvent(spanID, "warm_step_failed", map[string]interface{}{
+				"step":  name,
+				"error": err.Error(),
+			})
+		}
+		report.Steps = append(report.Steps, s)
+	}
+
+	step("base image", func() (string, error) {
+		if err := m.ensureBaseImage(ctx, opts.Platform); err != nil {
+			return "", err
+		}
+		return m.baseImageFor(opts.Platform), nil
+	})
+
+	for _, sidecar := range template.Sidecars {
+		sidecar := sidecar
+		step("sidecar "+sidecar.Name, func() (string, error) {
+			pulled, err := m.ensureImage(ctx, sidecar.Image)
+			if err != nil {
+				return "", err
+			}
+			if pulled {
+				return sidecar.Image + " pulled", nil
+			}
+			return sidecar.Image + " present", nil
+		})
+	}
+
+	if !opts.SkipPrefetch && len(m.config.Cache.Prefetch) > 0 {
+		step("clone cache", func() (string, error) {
+			results, err := m.Prefetch()
+			return fmt.Sprintf("%d repositories fetched", len(results)), err
+		})
+	}
+
+	if opts.Count > 0 {
+		report.Pool = opts.Template
+		if report.Pool == "" {
+			report.Pool = defaultPool
+		}
+		step("pool "+report.Pool, func() (string, error) {
+			created, err := m.fillPool(report.Pool, opts)
+			report.Created = created
+			return fmt.Sprintf("%d agents created", len(created)), err
+		})
+		pool, err := m.SelectAgentIDs(PoolLabel + "=" + report.Pool)
+		if err != nil {
+			tracing.EndSpanError(spanID, err.Error())
+			return report, err
+		}
+		report.PoolAgents = pool
+	}
+
+	if failed > 0 {
+		err := fmt.Errorf("%d of %d warm-up steps failed", failed, len(report.Steps))
+		tracing.EndSpanError(spanID, err.Error())
+		return report, err
+	}
+	tracing.EndSpanSuccess(spanID)
+	return report, nil
+}
+
+// fillPool creates agents labeled pool=<pool> until the pool holds opts.Count agents,
+// in parallel, and returns the IDs created
+func (m *Manager) fillPool(pool string, opts WarmOptions) ([]string, error) {
+	existing, err := m.SelectAgentIDs(PoolLabel + "=" + pool)
+	if err != nil {
+		return nil, err
+	}
+	missing := opts.Count - len(existing)
+	if missing <= 0 {
+		return nil, nil
+	}
+
+	// The agents are checked against the host's capacity as a batch, before any is
+	// created, rather than one by one while the others are being created
+	if !opts.SkipCapacityCheck {
+		if err := m.checkCapacity(missing, AgentConfig{RepoURL: opts.RepoURL}); err != nil {
+			return nil, err
+		}
+	}
+
+	// IDs are generated up front, since concurrent generation could pick the same one
+	ids := make([]string, 0, missing)
+	seen := make(map[string]bool)
+	for len(ids) < missing {
+		agentID, err := m.GenerateAgentID()
+		if err != nil {
+			return nil, err
+		}
+		if !seen[agentID] {
+			seen[agentID] = true
+			ids = append(ids, agentID)
+		}
+	}
+
+	errs := make([]error, len(ids))
+	forEachParallel(ids, func(i int, agentID string) {
+		errs[i] = m.Create(AgentConfig{
+			ID:              agentID,
+			DependencyLevel: "container",
+			Template:        opts.Template,
+			RepoURL:         opts.RepoURL,
+			Branch:          opts.Branch,
+			Platform:        opts.Platform,
+			Labels:          map[string]string{PoolLabel: pool},
+			// Checked above for the whole pool
+			SkipCapacityCheck: true,
+		})
+	})
+
+	var created []string
+	var firstErr error
+	for i, agentID := range ids {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		created = append(created, agentID)
+	}
+	metrics.RecordCount("pool_agent_created", metrics.ContainerOps, len(created), "")
+	if firstErr != nil {
+		return created, fmt.Errorf("failed to create %d of %d pooled agents: %w", len(ids)-len(created), len(ids), firstErr)
+	}
+	return created, nil
+}
+
+// ensureImage pulls an image that is not on the host, once its signature is checked,
+// and reports whether it was pulled
+func (m *Manager) ensureImage(ctx context.Context, image string) (bool, error) {
+	if err := m.verifyImage(ctx, image); err != nil {
+		return false, err
+	}
+	if _, _, err := m.dockerClient.ImageInspectWithRaw(ctx, image); err == nil {
+		return false, nil
+	}
+	out, err := m.dockerClient.ImagePull(ctx, image, types.ImagePullOptions{})
+	if err != nil {
+		return false, fmt.Errorf("failed to pull image %s: %w", image, err)
+	}
+	defer out.Close()
+	io.Copy(io.Discard, out)
+	return true, nil
+}
@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/notify"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/webhook"
+)
+
+// SetNotifyErrorHandler sets the function told about webhook deliveries that failed
+// after their retries. Failures never fail the operation that raised the event.
+func (m *Manager) SetNotifyErrorHandler(handler func(error)) {
+	m.notifyError = handler
+}
+
+// notify delivers a lifecycle event of an agent to the configured webhooks and
+// notifiers
+func (m *Manager) notify(event, agentID string, data map[string]interface{}) {
+	if !m.webhooks.Enabled() {
+		return
+	}
+	e := webhook.Event{
+		Event:   event,
+		AgentID: agentID,
+		Project: m.project,
+		Data:    data,
+	}
+	e.Message = notify.Message(e)
+	errs := m.webhooks.Send(e)
+	for _, err := range errs {
+		metrics.RecordCount("webhook_failures", metrics.ContainerOps, 1, agentID)
+		if m.notifyError != nil {
+			m.notifyError(err)
+		}
+	}
+}
+
+// notifyCreated delivers the agent.created event of a new agent
+func (m *Manager) notifyCreated(agentConfig AgentConfig) {
+	m.notify(config.EventAgentCreated, agentConfig.ID, map[string]interface{}{
+		"repo_url": agentConfig.RepoURL,
+		"branch":   agentConfig.Branch,
+		"template": agentConfig.Template,
+	})
+}
+
+// diffTotals returns the lines added and removed by an agent since its branch left
+// its upstream, or origin's default branch, uncommitted changes and untracked files
+// included
+func (m *Manager) diffTotals(agentID string) (int, int, error) {
+	base, err := m.Exec(agentID, "cd /workspace/repo && git merge-base HEAD \"$(git rev-parse --abbrev-ref --symbolic-full-name @{upstream} 2>/dev/null || git rev-parse --abbrev-ref origin/HEAD)\"")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to find the base of the agent's changes: %w", err)
+	}
+	numstat, err := m.ExecArgs(agentID, repoRoot, "git", "diff", "--numstat", strings.TrimSpace(base))
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get diff: %w", err)
+	}
+	// git diff leaves out untracked files; each is diffed against /dev/null instead
+	untracked, err := m.ExecArgs(agentID, "", "bash", "-c", untrackedNumstatScript)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get diff of untracked files: %w", err)
+	}
+	additions, deletions := 0, 0
+	for _, file := range parseNumstat(numstat + "\n" + untracked) {
+		additions += file.Additions
+		deletions += file.Deletions
+	}
+	return additions, deletions, nil
+}
+
+// untrackedNumstatScript prints the lines of an agent's untracked files in the
+// format of git diff --numstat
+const untrackedNumstatScript = `cd ` + repoRoot + ` || exit 1
+git ls-files -z --others --exclude-standard | while IFS= read -r -d '' file; do
+	git diff --numstat --no-index -- /dev/null "$file"
+done
+exit 0
+`
+
+// notifyChecks delivers the checks.finished event of a check run, and check.failed
+// when a check failed, with the size of the agent's changes
+func (m *Manager) notifyChecks(agentID string, results []state.CheckResult) {
+	if !m.webhooks.Subscribed(config.EventChecksFinished) && !m.webhooks.Subscribed(config.EventCheckFailed) {
+		return
+	}
+	names := []string{}
+	for _, result := range results {
+		if !result.Passed {
+			names = append(names, result.Name)
+		}
+	}
+	data := map[string]interface{}{
+		"failed": names,
+		"passed": len(results) - len(names),
+		"total":  len(results),
+	}
+	if additions, deletions, err := m.diffTotals(agentID); err == nil {
+		data["additions"] = additions
+		data["deletions"] = deletions
+	}
+
+	m.notify(config.EventChecksFinished, agentID, data)
+	if len(names) > 0 {
+		m.notify(config.EventCheckFailed, agentID, data)
+	}
+}
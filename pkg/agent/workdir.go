@@ -0,0 +1,62 @@
+package agent
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// repoRoot is the repository checkout inside agent containers
+const repoRoot = "/workspace/repo"
+
+// cleanRepoPath validates a repository subdirectory given with --path and returns it
+// in clean, slash-separated form
+func cleanRepoPath(p string) (string, error) {
+	if err := checkArg("path", p); err != nil {
+		return "", err
+	}
+	cleaned := path.Clean(strings.ReplaceAll(p, "\\", "/"))
+	if path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("path '%s' must be a subdirectory of the repository", p)
+	}
+	if cleaned == "." {
+		return "", nil
+	}
+	return cleaned, nil
+}
+
+// WorkDir returns the default working directory of an agent scoped to a subdirectory
+// of the repository with --path, or "" for agents working at the repository root
+func (m *Manager) WorkDir(agentID string) string {
+	st, exists, err := m.store.Get(agentID)
+	if err != nil || !exists || st.Path == "" {
+		return ""
+	}
+	return path.Join(repoRoot, st.Path)
+}
+
+// repoDir returns the directory repository commands such as checks run in: the
+// agent's working directory or the repository root
+func (m *Manager) repoDir(agentID string) string {
+	if dir := m.WorkDir(agentID); dir != "" {
+		return dir
+	}
+	return repoRoot
+}
+
+// setupWorkDir limits the checkout to the agent's subdirectory when sparse is set and
+// makes sure the subdirectory exists in the repository
+func (m *Manager) setupWorkDir(agentID, subdir string, sparse bool) error {
+	if sparse {
+		if _, err := m.ExecArgs(agentID, repoRoot, "git", "sparse-checkout", "init", "--cone"); err != nil {
+			return fmt.Errorf("failed to enable sparse checkout: %w", err)
+		}
+		if _, err := m.ExecArgs(agentID, repoRoot, "git", "sparse-checkout", "set", subdir); err != nil {
+			return fmt.Errorf("failed to limit sparse checkout to %s: %w", subdir, err)
+		}
+	}
+	if _, err := m.ExecArgs(agentID, "", "test", "-d", path.Join(repoRoot, subdir)); err != nil {
+		return fmt.Errorf("path '%s' does not exist in the repository", subdir)
+	}
+	return nil
+}
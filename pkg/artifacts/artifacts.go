@@ -0,0 +1,244 @@
+package artifacts
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// DefaultKeepRuns is the number of runs kept per agent and job when no limit is configured
+const DefaultKeepRuns = 10
+
+// Files of a run directory
+const (
+	OutputFile = "output.log" // complete stdout and stderr of the run
+	FilesDir   = "files"      // artifacts copied out of the agent, under their declared paths
+	runFile    = "run.json"
+)
+
+// unsafeJobChars matches characters not used in job directory names
+var unsafeJobChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// Run is the record of one job or check run kept in the artifact store
+type Run struct {
+	ID         string        `json:"id"`
+	AgentID    string        `json:"agent_id"`
+	Job        string        `json:"job"`
+	Command    string        `json:"command,omitempty"`
+	StartedAt  time.Time     `json:"started_at"`
+	Duration   time.Duration `json:"duration_ns"`
+	ExitCode   int           `json:"exit_code"`
+	Passed     bool          `json:"passed"`
+	OutputSize int64         `json:"output_size"`
+	Files      []File        `json:"files,omitempty"`
+	// Missing lists declared artifact paths that did not exist after the run
+	Missing []string `json:"missing,omitempty"`
+}
+
+// File is an artifact file stored with a run
+type File struct {
+	Path string `json:"path"` // declared path, relative to the agent's working directory
+	Size int64  `json:"size"`
+}
+
+// Retention limits how many runs the store keeps for each agent and job
+type Retention struct {
+	KeepRuns int           // newest runs kept; zero selects DefaultKeepRuns
+	MaxAge   time.Duration // runs older than this are removed; zero keeps them regardless of age
+}
+
+// Store keeps the output and artifacts of runs under
+// <dataDir>/artifacts/<agent>/<job>/<run>, so they outlive the agent
+type Store struct {
+	dir       string
+	retention Retention
+}
+
+// NewStore creates a store rooted at <dataDir>/artifacts, where dataDir is the
+// project's data directory (see config.DataDir)
+func NewStore(dataDir string, retention Retention) (*Store, error) {
+	dir := filepath.Join(dataDir, "artifacts")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory %s: %v", dir, err)
+	}
+	if retention.KeepRuns <= 0 {
+		retention.KeepRuns = DefaultKeepRuns
+	}
+	return &Store{dir: dir, retention: retention}, nil
+}
+
+// Begin creates the directory of a new run. The run is recorded by Finish.
+func (s *Store) Begin(agentID, job, command string, startedAt time.Time) (*Run, error) {
+	jobDir := s.jobDir(agentID, job)
+	if err := os.MkdirAll(jobDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory %s: %v", jobDir, err)
+	}
+
+	id := startedAt.Format("20060102-150405")
+	for suffix := 2; ; suffix++ {
+		err := os.Mkdir(filepath.Join(jobDir, id), 0755)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create run directory: %v", err)
+		}
+		id = fmt.Sprintf("%s-%d", startedAt.Format("20060102-150405"), suffix)
+	}
+
+	return &Run{ID: id, AgentID: agentID, Job: job, Command: command, StartedAt: startedAt}, nil
+}
+
+// Finish records a run begun with Begin and applies the retention limits to the
+// other runs of its job
+func (s *Store) Finish(run *Run) error {
+	if info, err := os.Stat(s.OutputPath(run)); err == nil {
+		run.OutputSize = info.Size()
+	}
+
+	data, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run %s: %v", run.ID, err)
+	}
+	if err := os.WriteFile(filepath.Join(s.RunDir(run), runFile), append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to record run %s: %v", run.ID, err)
+	}
+	return s.prune(run.AgentID, run.Job)
+}
+
+// List returns the recorded runs of an agent, newest first. When job is non-empty
+// only its runs are returned.
+func (s *Store) List(agentID, job string) ([]*Run, error) {
+	jobDirs := []string{s.jobDir(agentID, job)}
+	if job == "" {
+		entries, err := os.ReadDir(filepath.Join(s.dir, agentID))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read artifacts of agent '%s': %v", agentID, err)
+		}
+		jobDirs = jobDirs[:0]
+		for _, entry := range entries {
+			if entry.IsDir() {
+				jobDirs = append(jobDirs, filepath.Join(s.dir, agentID, entry.Name()))
+			}
+		}
+	}
+
+	var runs []*Run
+	for _, jobDir := range jobDirs {
+		entries, err := os.ReadDir(jobDir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read artifacts in %s: %v", jobDir, err)
+		}
+		for _, entry := range entries {
+			run, err := readRun(filepath.Join(jobDir, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			if run != nil {
+				runs = append(runs, run)
+			}
+		}
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		if !runs[i].StartedAt.Equal(runs[j].StartedAt) {
+			return runs[i].StartedAt.After(runs[j].StartedAt)
+		}
+		return runs[i].ID > runs[j].ID
+	})
+	return runs, nil
+}
+
+// Get returns a run of a job by ID, or its latest run when runID is "" or "latest"
+func (s *Store) Get(agentID, job, runID string) (*Run, error) {
+	if runID == "" || runID == "latest" {
+		runs, err := s.List(agentID, job)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) == 0 {
+			return nil, fmt.Errorf("no runs of '%s' recorded for agent '%s'", job, agentID)
+		}
+		return runs[0], nil
+	}
+
+	run, err := readRun(filepath.Join(s.jobDir(agentID, job), filepath.Base(runID)))
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, fmt.Errorf("run '%s' of '%s' not found for agent '%s'", runID, job, agentID)
+	}
+	return run, nil
+}
+
+// RunDir returns the directory holding a run's output and artifacts
+func (s *Store) RunDir(run *Run) string {
+	return filepath.Join(s.jobDir(run.AgentID, run.Job), run.ID)
+}
+
+// OutputPath returns the file holding a run's complete output
+func (s *Store) OutputPath(run *Run) string {
+	return filepath.Join(s.RunDir(run), OutputFile)
+}
+
+// FilesPath returns the directory holding a run's artifact files
+func (s *Store) FilesPath(run *Run) string {
+	return filepath.Join(s.RunDir(run), FilesDir)
+}
+
+// prune removes the runs of a job beyond the retention limits
+func (s *Store) prune(agentID, job string) error {
+	runs, err := s.List(agentID, job)
+	if err != nil {
+		return err
+	}
+
+	for i, run := range runs {
+		expired := s.retention.MaxAge > 0 && time.Since(run.StartedAt) > s.retention.MaxAge
+		if i < s.retention.KeepRuns && !expired {
+			continue
+		}
+		if err := os.RemoveAll(s.RunDir(run)); err != nil {
+			return fmt.Errorf("failed to remove run %s: %v", run.ID, err)
+		}
+	}
+	return nil
+}
+
+// jobDir returns the directory holding the runs of an agent's job
+func (s *Store) jobDir(agentID, job string) string {
+	name := unsafeJobChars.ReplaceAllString(job, "_")
+	if name == "." || name == ".." {
+		name = "_" + name
+	}
+	return filepath.Join(s.dir, agentID, name)
+}
+
+// readRun loads the record of a run directory; nil is returned for runs that have
+// not finished
+func readRun(runDir string) (*Run, error) {
+	data, err := os.ReadFile(filepath.Join(runDir, runFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read run %s: %v", runDir, err)
+	}
+
+	var run Run
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("failed to parse run %s: %v", runDir, err)
+	}
+	return &run, nil
+}
@@ -0,0 +1,479 @@
+// Package backup archives the fleet configuration kept in a workspace's .capsulate
+// directory, so that it survives a host rebuild or moves to another machine.
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/scrub"
+	"github.com/your-org/capsulate-repo/pkg/state"
+	"github.com/your-org/capsulate-repo/pkg/version"
+)
+
+// FormatVersion is the version of the archive layout written by Create
+const FormatVersion = 1
+
+// Names inside an archive
+const (
+	manifestName = "capsulate-backup.json"
+	configName   = "capsulate.yaml"
+	dataPrefix   = ".capsulate/"
+	cacheStats   = "metrics/cache-stats.json"
+)
+
+// ErrExists is returned (wrapped) by Restore when the workspace already holds
+// agent state and overwriting was not requested
+var ErrExists = errors.New("workspace already has capsulate state")
+
+// dataDirs are the directories under .capsulate kept in a backup, for the default
+// project and for each project under .capsulate/projects
+var dataDirs = []string{"state", "schedules"}
+
+// workspaceDirs hold the repositories and installed dependencies of a project's
+// agents, only kept with Options.Workspaces
+var workspaceDirs = []string{"workspaces", "overlay/diffs", "dependencies/container"}
+
+// sharedDirs are the directories under .capsulate shared by all projects
+var sharedDirs = []string{
+	"dependencies/core",
+	"dependencies/team",
+	"dependencies/team-snapshots",
+	"overlay/base",
+}
+
+// Options select the optional contents of a backup
+type Options struct {
+	// Artifacts includes the stored output and artifacts of check runs
+	Artifacts bool
+	// Workspaces includes the agents' repositories, overlay diffs and installed
+	// dependencies, so their containers can be recreated after a restore
+	Workspaces bool
+}
+
+// Manifest describes an archive. It is its first entry.
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	ToolVersion   string    `json:"tool_version"`
+	// Contents lists the top-level paths archived, e.g. ".capsulate/state"
+	Contents []string `json:"contents"`
+}
+
+// Summary reports what Create archived or Restore extracted
+type Summary struct {
+	Manifest
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// Scrubbed are the credential files of workspaces left out of the archive, and
+	// the .npmrc files archived without their auth entries
+	Scrubbed []string `json:"scrubbed,omitempty"`
+}
+
+// Create writes a gzipped tar archive of a workspace's capsulate.yaml (with its
+// templates), agent state, schedules, team and core dependencies, overlay bases and
+// cache stats to w. Containers and images are never included, and neither are the
+// credential files of workspaces.
+func Create(workspaceDir string, w io.Writer, opts Options) (*Summary, error) {
+	// Collect the paths to archive, relative to the workspace
+	var dirs []string
+	projects, err := config.Projects(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	wanted := append([]string{}, dataDirs...)
+	if opts.Artifacts {
+		wanted = append(wanted, "artifacts")
+	}
+	if opts.Workspaces {
+		wanted = append(wanted, workspaceDirs...)
+	}
+	for _, project := range projects {
+		base, err := filepath.Rel(workspaceDir, config.DataDir(workspaceDir, project))
+		if err != nil {
+			return nil, err
+		}
+		for _, dir := range wanted {
+			dirs = append(dirs, filepath.ToSlash(filepath.Join(base, dir)))
+		}
+	}
+	for _, dir := range sharedDirs {
+		dirs = append(dirs, ".capsulate/"+dir)
+	}
+	// The schema version tells the restoring release whether it can read the state
+	dirs = append(dirs, ".capsulate/"+state.LayoutFile)
+
+	summary := &Summary{Manifest: Manifest{
+		FormatVersion: FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		ToolVersion:   version.Version,
+	}}
+	configPath := config.ResolvePath(workspaceDir)
+	if _, err := os.Stat(configPath); err == nil {
+		summary.Contents = append(summary.Contents, configName)
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(filepath.Join(workspaceDir, filepath.FromSlash(dir))); err == nil {
+			summary.Contents = append(summary.Contents, dir)
+		}
+	}
+	statsPath := filepath.Join(metrics.Dir(), path.Base(cacheStats))
+	if _, err := os.Stat(statsPath); err == nil {
+		summary.Contents = append(summary.Contents, cacheStats)
+	}
+	if len(summary.Contents) == 0 {
+		return nil, fmt.Errorf("nothing to back up in %s", workspaceDir)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	manifest, err := json.MarshalIndent(summary.Manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if err := writeFile(tw, manifestName, manifest); err != nil {
+		return nil, err
+	}
+
+	for _, name := range summary.Contents {
+		switch name {
+		case configName:
+			err = addFile(tw, summary, configName, configPath)
+		case cacheStats:
+			err = addFile(tw, summary, cacheStats, statsPath)
+		default:
+			err = addTree(tw, summary, name, filepath.Join(workspaceDir, filepath.FromSlash(name)), isWorkspaceDir(name))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write archive: %v", err)
+	}
+	return summary, nil
+}
+
+// writeFile adds a file with the given content to an archive
+func writeFile(tw *tar.Writer, name string, data []byte) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+	}); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+// addFile adds the file at src to an archive under name
+func addFile(tw *tar.Writer, summary *Summary, name, src string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", src, err)
+	}
+	return addEntry(tw, summary, name, src, info)
+}
+
+// isWorkspaceDir reports whether an archived directory is one of workspaceDirs
+func isWorkspaceDir(name string) bool {
+	for _, dir := range workspaceDirs {
+		if strings.HasSuffix(name, "/"+dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// addTree adds a directory and everything below it to an archive under name. Lock
+// files are skipped, and so are credential files when scrubbing.
+func addTree(tw *tar.Writer, summary *Summary, name, src string, scrubCredentials bool) error {
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", p, err)
+		}
+		if d.IsDir() && d.Name() == "locks" {
+			return filepath.SkipDir
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", p, err)
+		}
+		entry := path.Join(name, filepath.ToSlash(rel))
+		if scrubCredentials && info.Mode().IsRegular() {
+			if scrub.CredentialFile(p) {
+				summary.Scrubbed = append(summary.Scrubbed, entry)
+				return nil
+			}
+			if d.Name() == scrub.Npmrc {
+				return addNpmrc(tw, summary, entry, p)
+			}
+		}
+		return addEntry(tw, summary, entry, p, info)
+	})
+}
+
+// addNpmrc adds an .npmrc file to an archive without its auth entries
+func addNpmrc(tw *tar.Writer, summary *Summary, name, src string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", src, err)
+	}
+	data, scrubbed := scrub.ScrubNpmrc(data)
+	if scrubbed {
+		summary.Scrubbed = append(summary.Scrubbed, name)
+	}
+	if err := writeFile(tw, name, data); err != nil {
+		return err
+	}
+	summary.Files++
+	summary.Bytes += int64(len(data))
+	return nil
+}
+
+// addEntry adds one file, directory or symbolic link to an archive
+func addEntry(tw *tar.Writer, summary *Summary, name, src string, info fs.FileInfo) error {
+	link := ""
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return fmt.Errorf("failed to read link %s: %v", src, err)
+		}
+		link = target
+	} else if !info.Mode().IsRegular() && !info.IsDir() {
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %v", src, err)
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to archive %s: %v", src, err)
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", src, err)
+	}
+	defer file.Close()
+	n, err := io.Copy(tw, file)
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %v", src, err)
+	}
+	summary.Files++
+	summary.Bytes += n
+	return nil
+}
+
+// Inspect reads the manifest of an archive without extracting it
+func Inspect(r io.Reader) (*Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a capsulate backup: %v", err)
+	}
+	defer gz.Close()
+	return readManifest(tar.NewReader(gz))
+}
+
+// readManifest reads and checks the first entry of an archive
+func readManifest(tr *tar.Reader) (*Manifest, error) {
+	header, err := tr.Next()
+	if err != nil || header.Name != manifestName {
+		return nil, fmt.Errorf("not a capsulate backup: missing %s", manifestName)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("invalid backup manifest: %v", err)
+	}
+	if manifest.FormatVersion < 1 || manifest.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("backup format %d is not supported by this version of git-capsulate (up to %d)", manifest.FormatVersion, FormatVersion)
+	}
+	return &manifest, nil
+}
+
+// Restore extracts an archive made by Create into a workspace. It refuses to touch a
+// workspace that already has agent state unless overwrite is set, in which case files
+// from the archive replace existing ones and other files are kept.
+func Restore(workspaceDir string, r io.Reader, overwrite bool) (*Summary, error) {
+	if !overwrite {
+		if exists, err := hasState(workspaceDir); err != nil {
+			return nil, err
+		} else if exists {
+			return nil, fmt.Errorf("%w in %s", ErrExists, workspaceDir)
+		}
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("not a capsulate backup: %v", err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+	summary := &Summary{Manifest: *manifest}
+
+	configPath := config.ResolvePath(workspaceDir)
+	statsPath := filepath.Join(metrics.Dir(), path.Base(cacheStats))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup: %v", err)
+		}
+
+		var dest string
+		name := path.Clean(header.Name)
+		switch {
+		case name == configName:
+			if _, err := os.Stat(configPath); err == nil && !overwrite {
+				continue
+			}
+			dest = configPath
+		case name == cacheStats:
+			dest = statsPath
+		case strings.HasPrefix(name, dataPrefix):
+			dest = filepath.Join(workspaceDir, filepath.FromSlash(name))
+		default:
+			return nil, fmt.Errorf("unexpected entry '%s' in backup", header.Name)
+		}
+		if err := extract(tr, header, filepath.Join(workspaceDir, ".capsulate"), dest, summary); err != nil {
+			return nil, err
+		}
+	}
+	return summary, nil
+}
+
+// hasState reports whether a workspace records any agent, in any project
+func hasState(workspaceDir string) (bool, error) {
+	projects, err := config.Projects(workspaceDir)
+	if err != nil {
+		return false, err
+	}
+	for _, project := range projects {
+		entries, err := os.ReadDir(filepath.Join(config.DataDir(workspaceDir, project), "state", "agents"))
+		if err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed to read state directory: %v", err)
+		}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".json") {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// extract writes one archive entry to dest. Entries under .capsulate must stay inside
+// root once symbolic links already extracted are resolved.
+func extract(tr *tar.Reader, header *tar.Header, root, dest string, summary *Summary) error {
+	if strings.HasPrefix(dest, root+string(filepath.Separator)) {
+		if err := checkInside(root, filepath.Dir(dest)); err != nil {
+			return fmt.Errorf("unsafe entry '%s' in backup: %v", header.Name, err)
+		}
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", dest, err)
+		}
+	case tar.TypeSymlink:
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", filepath.Dir(dest), err)
+		}
+		os.Remove(dest)
+		if err := os.Symlink(header.Linkname, dest); err != nil {
+			return fmt.Errorf("failed to create link %s: %v", dest, err)
+		}
+	case tar.TypeReg:
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %v", filepath.Dir(dest), err)
+		}
+		// Replace rather than write through an existing file, which may be a link
+		os.Remove(dest)
+		file, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fs.FileMode(header.Mode)&0777)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %v", dest, err)
+		}
+		n, err := io.Copy(file, tr)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %v", dest, err)
+		}
+		summary.Files++
+		summary.Bytes += n
+	}
+	return nil
+}
+
+// checkInside verifies that dir, with symbolic links resolved, is root or below it
+func checkInside(root, dir string) error {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// Resolve the deepest existing ancestor of dir
+	existing := dir
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return nil
+		}
+		existing = parent
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return err
+	}
+	if resolved != resolvedRoot && !strings.HasPrefix(resolved, resolvedRoot+string(filepath.Separator)) {
+		return fmt.Errorf("%s is outside %s", dir, root)
+	}
+	return nil
+}
@@ -0,0 +1,1337 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/your-org/capsulate-repo/pkg/secrets"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+	"gopkg.in/yaml.v3"
+)
+
+// FileName is the name of the project configuration file in the workspace directory
+const FileName = "capsulate.yaml"
+
+// Config holds the project configuration loaded from capsulate.yaml
+type Config struct {
+	// Project isolates the agents of this workspace from those of other projects
+	// sharing the Docker host; --project and CAPSULATE_PROJECT override it
+	Project string `yaml:"project,omitempty"`
+
+	// Extends is a remote configuration, <repo>//<path>@<ref>, this file is layered
+	// on: its values apply unless this file sets them
+	Extends string `yaml:"extends,omitempty"`
+
+	// Checks are validation commands (build, test, lint...) run inside agents
+	Checks []CheckConfig `yaml:"checks"`
+
+	// Branches is the branch naming and protection policy enforced inside agents
+	Branches BranchPolicy `yaml:"branches"`
+
+	// Provenance controls the metadata attached to commits made by agents
+	Provenance ProvenanceConfig `yaml:"provenance"`
+
+	// Dependencies selects the package managers whose dependency layers are set up in agents
+	Dependencies DependencyConfig `yaml:"dependencies"`
+
+	// Registries holds credentials for private package registries used inside agents
+	Registries []RegistryConfig `yaml:"registries"`
+
+	// SSH controls how agents verify the host keys of Git servers
+	SSH SSHConfig `yaml:"ssh"`
+
+	// Mirrors rewrites repository URLs inside agents so clones and fetches use a mirror
+	Mirrors []MirrorConfig `yaml:"mirrors"`
+
+	// Protocols selects SSH or HTTPS for the clones of each Git host
+	Protocols []ProtocolConfig `yaml:"protocols"`
+
+	// Artifacts sets how long the output and artifacts of check runs are kept
+	Artifacts ArtifactConfig `yaml:"artifacts"`
+
+	// Cache configures the bare repository cache agents clone from
+	Cache CacheConfig `yaml:"cache"`
+
+	// Images sets how long base images replaced by 'image refresh' are kept
+	Images ImageConfig `yaml:"images"`
+
+	// Templates configure the containers of agents created with --template <name>
+	Templates map[string]TemplateConfig `yaml:"templates"`
+
+	// Services are long-running processes (databases, dev servers) supervised inside agents
+	Services []ServiceConfig `yaml:"services"`
+
+	// Suggest configures how commit messages and pull request descriptions are drafted
+	Suggest SuggestConfig `yaml:"suggest"`
+
+	// Scan selects the vulnerability scanner run by 'git-capsulate scan'
+	Scan ScanConfig `yaml:"scan"`
+
+	// Webhooks are notified of agent lifecycle events
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+
+	// Notifiers post human-readable messages about agent events to Slack or Teams
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+
+	// CI defines the ephemeral agents created by 'ci exec'
+	CI CIConfig `yaml:"ci"`
+
+	// Trash keeps the files of destroyed agents for a while so they can be undeleted
+	Trash TrashConfig `yaml:"trash"`
+
+	// Signatures verifies remote templates and container images before they are used
+	Signatures SignatureConfig `yaml:"signatures"`
+
+	// Stop configures how the containers of destroyed agents are stopped
+	Stop StopConfig `yaml:"stop"`
+
+	// Tracing selects the format traces are exported in
+	Tracing TracingConfig `yaml:"tracing"`
+
+	// Metrics configures the histograms of timer durations
+	Metrics MetricsConfig `yaml:"metrics"`
+
+	// Overlay selects how overlay workspaces are mounted in agent containers
+	Overlay OverlayConfig `yaml:"overlay"`
+
+	// Storage selects where the copy-on-write layers of overlay workspaces live
+	Storage StorageConfig `yaml:"storage"`
+
+	// Telemetry switches the collection of metrics and traces and configures the
+	// anonymized usage reporter
+	Telemetry TelemetryConfig `yaml:"telemetry"`
+
+	// Capacity sets what new agents are expected to take from the host, checked
+	// before they are created
+	Capacity CapacityConfig `yaml:"capacity"`
+
+	// Quotas limit how many agents each caller creates and how many commands it runs
+	// at once
+	Quotas QuotaConfig `yaml:"quotas"`
+
+	// path is the file the configuration was loaded from, empty if defaults are used
+	path string
+}
+
+// CheckConfig describes a single validation command
+type CheckConfig struct {
+	Name    string   `yaml:"name"`
+	Command string   `yaml:"command"`
+	Timeout Duration `yaml:"timeout,omitempty"`
+	// Artifacts are files or directories, relative to the agent's working directory,
+	// copied into the artifact store after each run (e.g. "coverage.out", "dist")
+	Artifacts []string `yaml:"artifacts,omitempty"`
+	// Reports are glob patterns, relative to the agent's working directory, of the
+	// result files parsed after each run: JUnit and Checkstyle XML, and Cobertura, LCOV
+	// or Go coverage (e.g. "build/test-results/**/*.xml", "coverage.out")
+	Reports []string `yaml:"reports,omitempty"`
+}
+
+// BranchPolicy controls which branches agents may create and write to
+type BranchPolicy struct {
+	// Template is the pattern new branch names must follow, e.g. "agent/{id}/{date}".
+	// Supported placeholders: {id}, {team}, {date} (YYYYMMDD) and {name} (any segment).
+	Template string `yaml:"template,omitempty"`
+	// Protected lists branch names or glob patterns (e.g. "release/*") agents may not
+	// commit to, push to or reset
+	Protected []string `yaml:"protected,omitempty"`
+	// Track makes branches created or checked out by agents track the branch of the
+	// same name on origin, as if --track were given
+	Track bool `yaml:"track,omitempty"`
+	// Namespace maps the pushes of each agent to refs/heads/capsulate/<agent-id>/* on
+	// origin, so agents share their work without overwriting anyone else's branches
+	Namespace bool `yaml:"namespace,omitempty"`
+}
+
+// ProvenanceConfig controls the trailers appended to commits made through the Manager
+type ProvenanceConfig struct {
+	// Enabled appends Capsulate-* trailers (agent, template, version, trace) to agent commits
+	Enabled bool `yaml:"enabled"`
+	// Sign creates GPG/SSH signed commits using the signing key configured in the agent
+	Sign bool `yaml:"sign,omitempty"`
+}
+
+// DependencyConfig selects the dependency providers used for agents
+type DependencyConfig struct {
+	// Providers lists the providers (npm, go, pip, cargo) to set up. When empty,
+	// providers are detected from marker files such as package.json or go.mod.
+	Providers []string `yaml:"providers,omitempty"`
+}
+
+// RegistryConfig describes a private package registry and how to authenticate to it
+type RegistryConfig struct {
+	// Provider is the dependency provider using the registry: npm, pip or go, or
+	// docker for a container registry 'commit-image --push' pushes to
+	Provider string `yaml:"provider"`
+	// URL of the registry, e.g. https://npm.pkg.github.com or https://pypi.example.com/simple.
+	// For go it is the host serving the private modules, e.g. https://github.com, and
+	// for docker the registry, e.g. https://ghcr.io.
+	URL string `yaml:"url"`
+	// Scope restricts an npm registry to a package scope such as "@myorg"
+	Scope string `yaml:"scope,omitempty"`
+	// Private lists GOPRIVATE module patterns such as "github.com/myorg/*" (go only)
+	Private []string `yaml:"private,omitempty"`
+	// Username for basic authentication (pip, go and docker)
+	Username string `yaml:"username,omitempty"`
+	// Token is a secret reference for the token or password, e.g. "env:NPM_TOKEN"
+	Token string `yaml:"token"`
+}
+
+// SSHConfig controls host key verification for Git over SSH inside agents
+type SSHConfig struct {
+	// KnownHosts lists the sources of trusted host keys: "host" (the user's
+	// ~/.ssh/known_hosts), "providers" (the published keys of GitHub, GitLab and
+	// Bitbucket) or paths to known_hosts files. Defaults to host and providers.
+	KnownHosts []string `yaml:"known_hosts,omitempty"`
+	// AcceptNew trusts the key of a host seen for the first time instead of failing
+	// (StrictHostKeyChecking=accept-new); changed keys are still rejected
+	AcceptNew bool `yaml:"accept_new,omitempty"`
+}
+
+// MirrorConfig rewrites a URL prefix to a mirror through Git's url.<base>.insteadOf
+type MirrorConfig struct {
+	// URL is the canonical URL prefix, e.g. "https://github.com/org/"
+	URL string `yaml:"url"`
+	// Mirror is the prefix fetched from instead, e.g. "https://git.internal/mirror/org/"
+	Mirror string `yaml:"mirror"`
+	// PushToMirror sends pushes through the mirror too. By default pushes keep going
+	// to the canonical URL.
+	PushToMirror bool `yaml:"push_to_mirror,omitempty"`
+}
+
+// Clone protocols of ProtocolConfig
+const (
+	ProtocolSSH   = "ssh"
+	ProtocolHTTPS = "https"
+)
+
+// ProtocolConfig sets the protocol repositories of a Git host are cloned with. Clone
+// URLs are rewritten to the preferred protocol, and a failed clone is retried with the
+// other one unless fallback is disabled.
+type ProtocolConfig struct {
+	// Host is the Git host, e.g. "github.com", or "*" for every other host
+	Host string `yaml:"host"`
+	// Prefer is ssh or https
+	Prefer string `yaml:"prefer"`
+	// Fallback retries a failed clone with the other protocol (default true)
+	Fallback *bool `yaml:"fallback,omitempty"`
+}
+
+// FallbackEnabled reports whether a failed clone is retried with the other protocol
+func (p ProtocolConfig) FallbackEnabled() bool {
+	return p.Fallback == nil || *p.Fallback
+}
+
+// ProtocolFor returns the protocol settings of a Git host: its own entry, else the
+// "*" entry
+func (c *Config) ProtocolFor(host string) (ProtocolConfig, bool) {
+	var wildcard *ProtocolConfig
+	for i, protocol := range c.Protocols {
+		if strings.EqualFold(protocol.Host, host) {
+			return protocol, true
+		}
+		if protocol.Host == "*" && wildcard == nil {
+			wildcard = &c.Protocols[i]
+		}
+	}
+	if wildcard != nil {
+		return *wildcard, true
+	}
+	return ProtocolConfig{}, false
+}
+
+// ArtifactConfig sets the retention limits of the artifact store
+type ArtifactConfig struct {
+	// KeepRuns is the number of runs kept per agent and check (default 10)
+	KeepRuns int `yaml:"keep_runs,omitempty"`
+	// MaxAge removes runs older than this, e.g. "168h"; runs are kept regardless of
+	// age when unset
+	MaxAge Duration `yaml:"max_age,omitempty"`
+}
+
+// CacheConfig configures the clone cache
+type CacheConfig struct {
+	// Clone keeps a bare mirror of each repository agents clone in
+	// .capsulate/cache/repos; later agents clone from it and only fetch what changed
+	Clone bool `yaml:"clone,omitempty"`
+	// Retention limits the mirrors kept, least recently used first, when pruning
+	Retention RetentionConfig `yaml:"retention,omitempty"`
+	// Prefetch lists the repositories 'cache prefetch' keeps fetched into the cache,
+	// so new agents clone from a near-current mirror
+	Prefetch []PrefetchConfig `yaml:"prefetch,omitempty"`
+	// PrefetchInterval is how often 'cache prefetch --every' fetches by default, e.g.
+	// "15m"; mirrors not fetched for twice as long are reported stale
+	PrefetchInterval Duration `yaml:"prefetch_interval,omitempty"`
+}
+
+// PrefetchConfig is a repository fetched into the clone cache ahead of clones
+type PrefetchConfig struct {
+	URL string `yaml:"url"`
+	// Branches limits the fetch to these branches; all refs are fetched when empty.
+	// A mirror is created with all refs the first time.
+	Branches []string `yaml:"branches,omitempty"`
+}
+
+// prefetchBranchPattern matches the branch names accepted in cache.prefetch
+var prefetchBranchPattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9/_.-]*$`)
+
+// validPrefetchBranch reports whether branch may be fetched into the clone cache
+func validPrefetchBranch(branch string) bool {
+	return prefetchBranchPattern.MatchString(branch) && !strings.Contains(branch, "..") && !strings.HasSuffix(branch, ".lock")
+}
+
+// ImageConfig configures the images built by git-capsulate
+type ImageConfig struct {
+	// From is the image the base image is built from (default ubuntu:22.04), e.g. a
+	// signed image of a central registry. It needs apt-get.
+	From string `yaml:"from,omitempty"`
+	// Retention limits the base images replaced by 'image refresh' that are kept,
+	// least recently used first, when pruning. Images used by a container are kept.
+	Retention RetentionConfig `yaml:"retention,omitempty"`
+}
+
+// DefaultBaseImageFrom is the image the base image is built from by default
+const DefaultBaseImageFrom = "ubuntu:22.04"
+
+// FromImage returns images.from, or DefaultBaseImageFrom
+func (c ImageConfig) FromImage() string {
+	if c.From == "" {
+		return DefaultBaseImageFrom
+	}
+	return c.From
+}
+
+// MetricsConfig configures the histograms timer durations are counted in, from which
+// 'metrics show' estimates percentiles
+type MetricsConfig struct {
+	// Buckets are the upper bounds of the histogram buckets of every timer, e.g.
+	// [1s, 5s, 30s, 2m]; the defaults span 10ms to 10m
+	Buckets []Duration `yaml:"buckets,omitempty"`
+	// Timers overrides the buckets of individual timers, keyed by operation such as
+	// create_container, exec_command or clone
+	Timers map[string][]Duration `yaml:"timers,omitempty"`
+}
+
+// BucketDurations returns the bucket bounds as durations, nil when none are set
+func BucketDurations(bounds []Duration) []time.Duration {
+	if len(bounds) == 0 {
+		return nil
+	}
+	durations := make([]time.Duration, len(bounds))
+	for i, bound := range bounds {
+		durations[i] = time.Duration(bound)
+	}
+	return durations
+}
+
+// checkBuckets checks that histogram bucket bounds are positive and increasing
+func checkBuckets(bounds []Duration) error {
+	for i, bound := range bounds {
+		if bound <= 0 {
+			return fmt.Errorf("bucket bound %s must be positive", time.Duration(bound))
+		}
+		if i > 0 && bound <= bounds[i-1] {
+			return fmt.Errorf("bucket bounds must increase, but %s follows %s", time.Duration(bound), time.Duration(bounds[i-1]))
+		}
+	}
+	return nil
+}
+
+// Ways overlay workspaces are mounted in agent containers
+const (
+	OverlayMountAuto   = "auto"   // fuse when agent containers can use it, kernel otherwise
+	OverlayMountKernel = "kernel" // kernel OverlayFS; the container needs CAP_SYS_ADMIN
+	OverlayMountFuse   = "fuse"   // fuse-overlayfs through /dev/fuse, without CAP_SYS_ADMIN
+)
+
+// OverlayConfig selects how overlay workspaces are mounted
+type OverlayConfig struct {
+	// Mount is auto (default), kernel or fuse
+	Mount string `yaml:"mount,omitempty"`
+}
+
+// MountMode returns overlay.mount, or OverlayMountAuto
+func (o OverlayConfig) MountMode() string {
+	if o.Mount == "" {
+		return OverlayMountAuto
+	}
+	return o.Mount
+}
+
+// Drivers storing the copy-on-write layer of an overlay workspace
+const (
+	StorageDriverAuto    = "auto"    // zfs or btrfs when the overlay base is a dataset or subvolume, overlay otherwise
+	StorageDriverOverlay = "overlay" // OverlayFS upper and work directories, mounted in the container
+	StorageDriverBtrfs   = "btrfs"   // a snapshot of the base subvolume per agent
+	StorageDriverZFS     = "zfs"     // a clone of a snapshot of the base dataset per agent
+)
+
+// StorageConfig selects how the copy-on-write layers of overlay workspaces are stored
+type StorageConfig struct {
+	// Driver is auto (default), overlay, btrfs or zfs
+	Driver string `yaml:"driver,omitempty"`
+	// Quota caps the size of each agent's layer, e.g. "20GB"; btrfs and zfs only
+	Quota ByteSize `yaml:"quota,omitempty"`
+}
+
+// DriverName returns storage.driver, or StorageDriverAuto
+func (s StorageConfig) DriverName() string {
+	if s.Driver == "" {
+		return StorageDriverAuto
+	}
+	return s.Driver
+}
+
+// TracingConfig selects how traces are exported to the traces directory
+type TracingConfig struct {
+	// Format is json (default), zipkin or otlp-file; the JSON trace files are always
+	// written and the other formats alongside them. GIT_CAPSULATE_TRACE_FORMAT
+	// overrides it.
+	Format string `yaml:"format,omitempty"`
+	// ServiceName is the service traces are reported under (default git-capsulate)
+	ServiceName string `yaml:"service_name,omitempty"`
+}
+
+// DefaultUsageInterval is how often usage is reported when telemetry.usage sets no
+// interval
+const DefaultUsageInterval = 24 * time.Hour
+
+// TelemetryConfig switches the collection of metrics, traces and operation journals,
+// for every command or individual ones
+type TelemetryConfig struct {
+	// Enabled switches collection; on when unset. GIT_CAPSULATE_TELEMETRY overrides it.
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// Commands switches collection for individual commands, keyed by the command
+	// without the program name, e.g. "exec" or "team-deps sync": false opts a command
+	// out, true opts it in while collection is otherwise off
+	Commands map[string]bool `yaml:"commands,omitempty"`
+	// Usage reports anonymized command counts and durations to an endpoint
+	Usage UsageConfig `yaml:"usage,omitempty"`
+}
+
+// UsageConfig configures the usage reporter. Reports hold the commands run, without
+// their arguments, with counts and durations, under a random installation ID; never
+// agent IDs, repositories, paths or user names.
+type UsageConfig struct {
+	// Endpoint receives the reports as JSON POST requests; without one, usage is not
+	// recorded. It may be a secret reference such as "env:USAGE_URL".
+	Endpoint string `yaml:"endpoint,omitempty"`
+	// Token is a secret reference, e.g. "env:USAGE_TOKEN", whose value is sent as a
+	// bearer token
+	Token string `yaml:"token,omitempty"`
+	// Interval is the least time between reports (default 24h)
+	Interval Duration `yaml:"interval,omitempty"`
+}
+
+// TelemetryEnabled reports whether telemetry is on for commands not listed under
+// telemetry.commands
+func (t TelemetryConfig) TelemetryEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// ReportInterval returns the least time between usage reports
+func (u UsageConfig) ReportInterval() time.Duration {
+	if u.Interval <= 0 {
+		return DefaultUsageInterval
+	}
+	return time.Duration(u.Interval)
+}
+
+// DefaultStopTimeout is how long the primary process of an agent gets to exit after
+// SIGTERM before it is killed, by default
+const DefaultStopTimeout = 10 * time.Second
+
+// StopConfig configures how the containers of destroyed agents are stopped
+type StopConfig struct {
+	// Timeout is how long the primary process gets to exit after SIGTERM before it is
+	// killed with SIGKILL, e.g. "30s" (default 10s)
+	Timeout Duration `yaml:"timeout,omitempty"`
+	// PreStop are commands run in the agent's repository, in order, before its
+	// container is stopped, e.g. to flush caches or commit a checkpoint. A failing
+	// hook is reported and does not prevent the destroy.
+	PreStop []HookConfig `yaml:"pre_stop,omitempty"`
+}
+
+// HookConfig is a command run in an agent at a point of its lifecycle
+type HookConfig struct {
+	Name    string `yaml:"name"`
+	Command string `yaml:"command"`
+	// Timeout stops the command when it runs longer, e.g. "30s"; unlimited by default
+	Timeout Duration `yaml:"timeout,omitempty"`
+}
+
+// StopTimeout returns stop.timeout, or DefaultStopTimeout
+func (s StopConfig) StopTimeout() time.Duration {
+	if s.Timeout <= 0 {
+		return DefaultStopTimeout
+	}
+	return time.Duration(s.Timeout)
+}
+
+// Modes of capacity.check
+const (
+	CapacityRefuse = "refuse" // refuse to create agents the host has no room for
+	CapacityWarn   = "warn"   // create them with a warning
+	CapacityOff    = "off"    // do not check
+)
+
+// Defaults of the capacity preflight
+const (
+	DefaultAgentMemory   = 512 << 20
+	DefaultAgentDisk     = 1 << 30
+	DefaultOverlayDisk   = 100 << 20
+	DefaultMinFreeDisk   = 1 << 30
+	DefaultMinFreeMemory = 256 << 20
+	DefaultMaxLoad       = 1.5
+)
+
+// CapacityConfig sets what creating an agent is expected to take from the host and
+// what the host must keep free. Agents have no resource limits, so these are
+// estimates compared with the host's free disk, available memory and load.
+type CapacityConfig struct {
+	// Check is refuse (default), warn or off
+	Check string `yaml:"check,omitempty"`
+	// AgentMemory is the memory an agent is expected to use (default 512MiB)
+	AgentMemory ByteSize `yaml:"agent_memory,omitempty"`
+	// AgentDisk is the disk a cloned repository is expected to take when its size
+	// is not known from another agent or the clone cache (default 1GiB)
+	AgentDisk ByteSize `yaml:"agent_disk,omitempty"`
+	// OverlayDisk is the disk an overlay agent is expected to take for its changes
+	// (default 100MiB)
+	OverlayDisk ByteSize `yaml:"overlay_disk,omitempty"`
+	// MinFreeDisk and MinFreeMemory are left free once the agents are created
+	// (default 1GiB and 256MiB)
+	MinFreeDisk   ByteSize `yaml:"min_free_disk,omitempty"`
+	MinFreeMemory ByteSize `yaml:"min_free_memory,omitempty"`
+	// MaxLoad is the load average per CPU above which no agent is created (default 1.5)
+	MaxLoad float64 `yaml:"max_load,omitempty"`
+}
+
+// Mode returns capacity.check, or CapacityRefuse
+func (c CapacityConfig) Mode() string {
+	if c.Check == "" {
+		return CapacityRefuse
+	}
+	return c.Check
+}
+
+// Limits returns the per-agent estimates and the host's reserves, with defaults
+// for those not set
+func (c CapacityConfig) Limits() CapacityConfig {
+	defaults := []struct {
+		value *ByteSize
+		def   ByteSize
+	}{
+		{&c.AgentMemory, DefaultAgentMemory},
+		{&c.AgentDisk, DefaultAgentDisk},
+		{&c.OverlayDisk, DefaultOverlayDisk},
+		{&c.MinFreeDisk, DefaultMinFreeDisk},
+		{&c.MinFreeMemory, DefaultMinFreeMemory},
+	}
+	for _, d := range defaults {
+		if *d.value <= 0 {
+			*d.value = d.def
+		}
+	}
+	if c.MaxLoad <= 0 {
+		c.MaxLoad = DefaultMaxLoad
+	}
+	return c
+}
+
+// CallerEnv is the environment variable naming the caller whose quotas apply, set by
+// --caller
+const CallerEnv = "CAPSULATE_CALLER"
+
+// Caller returns the caller a command runs for: CAPSULATE_CALLER, or else the user
+// running it
+func Caller() string {
+	for _, name := range []string{CallerEnv, "USER", "USERNAME"} {
+		if caller := os.Getenv(name); caller != "" {
+			return caller
+		}
+	}
+	return "unknown"
+}
+
+// QuotaLimits are the limits of a caller; zero is unlimited
+type QuotaLimits struct {
+	// AgentsPerHour is how many agents the caller may create in any hour
+	AgentsPerHour int `yaml:"agents_per_hour,omitempty"`
+	// ConcurrentExecs is how many commands the caller may run in agents at once with
+	// exec and ci exec
+	ConcurrentExecs int `yaml:"concurrent_execs,omitempty"`
+}
+
+// QuotaConfig limits what each caller, e.g. an orchestrator, may do on a shared host,
+// so that a runaway one cannot take it over. The limits apply to every caller unless
+// Callers sets others for it.
+type QuotaConfig struct {
+	AgentsPerHour   int `yaml:"agents_per_hour,omitempty"`
+	ConcurrentExecs int `yaml:"concurrent_execs,omitempty"`
+	// Callers holds the limits of individual callers; those they leave at zero are
+	// taken from the limits above
+	Callers map[string]QuotaLimits `yaml:"callers,omitempty"`
+}
+
+// For returns the limits of a caller
+func (q QuotaConfig) For(caller string) QuotaLimits {
+	limits := QuotaLimits{AgentsPerHour: q.AgentsPerHour, ConcurrentExecs: q.ConcurrentExecs}
+	if own, ok := q.Callers[caller]; ok {
+		if own.AgentsPerHour != 0 {
+			limits.AgentsPerHour = own.AgentsPerHour
+		}
+		if own.ConcurrentExecs != 0 {
+			limits.ConcurrentExecs = own.ConcurrentExecs
+		}
+	}
+	return limits
+}
+
+// negative returns the settings of the quotas that are negative, sorted
+func (q QuotaConfig) negative() []string {
+	var settings []string
+	check := func(prefix string, limits QuotaLimits) {
+		if limits.AgentsPerHour < 0 {
+			settings = append(settings, prefix+"agents_per_hour")
+		}
+		if limits.ConcurrentExecs < 0 {
+			settings = append(settings, prefix+"concurrent_execs")
+		}
+	}
+	check("quotas.", QuotaLimits{AgentsPerHour: q.AgentsPerHour, ConcurrentExecs: q.ConcurrentExecs})
+	for caller, limits := range q.Callers {
+		check("quotas.callers."+caller+".", limits)
+	}
+	sort.Strings(settings)
+	return settings
+}
+
+// DefaultTrashRetention is how long destroyed agents stay in the trash by default
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// TrashConfig sets how destroyed agents are kept in .capsulate/trash
+type TrashConfig struct {
+	// Enabled moves the workspace of destroyed agents to the trash, with a bundle
+	// of their repository, instead of leaving it in place. Enabled unless set to false.
+	Enabled *bool `yaml:"enabled,omitempty"`
+	// Retention is how long destroyed agents can be undeleted, e.g. "72h" (default
+	// 168h); older entries are purged when another agent is destroyed
+	Retention Duration `yaml:"retention,omitempty"`
+}
+
+// TrashEnabled reports whether destroyed agents are moved to the trash
+func (t TrashConfig) TrashEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// RetentionPeriod returns how long destroyed agents are kept in the trash
+func (t TrashConfig) RetentionPeriod() time.Duration {
+	if t.Retention <= 0 {
+		return DefaultTrashRetention
+	}
+	return time.Duration(t.Retention)
+}
+
+// RetentionConfig is a least-recently-used retention policy
+type RetentionConfig struct {
+	// MaxSize removes the least recently used entries until the total fits, e.g. "10GB"
+	MaxSize ByteSize `yaml:"max_size,omitempty"`
+	// MaxAge removes entries not used for this long, e.g. "720h"
+	MaxAge Duration `yaml:"max_age,omitempty"`
+}
+
+// TemplateConfig configures the container of agents created from a template
+type TemplateConfig struct {
+	// From is a remote template, <repo>//<path>@<ref>, whose values apply unless the
+	// template sets them
+	From string `yaml:"from,omitempty"`
+	// Init runs an init process (Docker's --init) as PID 1, which reaps zombie
+	// processes and forwards signals. Enabled unless set to false.
+	Init *bool `yaml:"init,omitempty"`
+	// Entrypoint replaces the entrypoint of the base image
+	Entrypoint []string `yaml:"entrypoint,omitempty"`
+	// Command is the primary process of the container, e.g. a dev server. By default
+	// the container idles with "tail -f /dev/null".
+	Command []string `yaml:"command,omitempty"`
+	// Sidecars are containers, such as a database or cache, created and destroyed
+	// with each agent of the template
+	Sidecars []SidecarConfig `yaml:"sidecars,omitempty"`
+	// Healthcheck is run by Docker in agents of the template, which turn unhealthy
+	// when it keeps failing, e.g. because their dev server froze
+	Healthcheck *HealthcheckConfig `yaml:"healthcheck,omitempty"`
+}
+
+// Defaults of the healthcheck of a template
+const (
+	DefaultHealthInterval = 30 * time.Second
+	DefaultHealthTimeout  = 10 * time.Second
+	DefaultHealthRetries  = 3
+)
+
+// HealthcheckConfig declares a command checking that an agent works, like a HEALTHCHECK
+// of a Dockerfile
+type HealthcheckConfig struct {
+	// Command runs in the container with sh -c; exiting non-zero is a failure, e.g.
+	// "curl -fs localhost:3000/health"
+	Command string `yaml:"command"`
+	// Interval is the time between checks (default 30s) and Timeout how long a check
+	// may take before it fails (default 10s)
+	Interval Duration `yaml:"interval,omitempty"`
+	Timeout  Duration `yaml:"timeout,omitempty"`
+	// Retries is the number of consecutive failures that make the agent unhealthy
+	// (default 3)
+	Retries int `yaml:"retries,omitempty"`
+	// StartPeriod is the time after the start during which failures do not count,
+	// e.g. while a dev server compiles
+	StartPeriod Duration `yaml:"start_period,omitempty"`
+}
+
+// HealthInterval returns the interval of the healthcheck, or DefaultHealthInterval
+func (h HealthcheckConfig) HealthInterval() time.Duration {
+	if h.Interval <= 0 {
+		return DefaultHealthInterval
+	}
+	return time.Duration(h.Interval)
+}
+
+// HealthTimeout returns the timeout of the healthcheck, or DefaultHealthTimeout
+func (h HealthcheckConfig) HealthTimeout() time.Duration {
+	if h.Timeout <= 0 {
+		return DefaultHealthTimeout
+	}
+	return time.Duration(h.Timeout)
+}
+
+// HealthRetries returns the retries of the healthcheck, or DefaultHealthRetries
+func (h HealthcheckConfig) HealthRetries() int {
+	if h.Retries <= 0 {
+		return DefaultHealthRetries
+	}
+	return h.Retries
+}
+
+// SidecarConfig declares a container run next to an agent. Sidecars share the
+// agent's network, so the agent reaches them on localhost.
+type SidecarConfig struct {
+	Name  string            `yaml:"name"`
+	Image string            `yaml:"image"`
+	Env   map[string]string `yaml:"env,omitempty"`
+	// Ports are published on the host as [ip:][host-port:]port[/proto], e.g. "5432"
+	// or "15432:5432". Without an IP they are bound to 127.0.0.1.
+	Ports []string `yaml:"ports,omitempty"`
+	// Command replaces the command of the image
+	Command []string `yaml:"command,omitempty"`
+}
+
+// InitEnabled reports whether agents of the template run an init process
+func (t TemplateConfig) InitEnabled() bool {
+	return t.Init == nil || *t.Init
+}
+
+// SuggestConfig configures the drafts made by 'git-capsulate suggest'
+type SuggestConfig struct {
+	// Command runs on the host with bash -c, receiving instructions followed by the
+	// agent's diff on stdin and printing the draft on stdout, e.g. a script calling an
+	// LLM endpoint. Without it, drafts summarize the changed files and commits.
+	Command string `yaml:"command,omitempty"`
+	// Timeout limits the command (default 2m)
+	Timeout Duration `yaml:"timeout,omitempty"`
+}
+
+// Vulnerability scanners supported by 'git-capsulate scan'
+const (
+	ScannerTrivy = "trivy"
+	ScannerGrype = "grype"
+)
+
+// ScanSeverities are the severities of vulnerabilities, from lowest to highest
+var ScanSeverities = []string{"low", "medium", "high", "critical"}
+
+// ScanConfig configures 'git-capsulate scan'
+type ScanConfig struct {
+	// Scanner is trivy (default) or grype, run on the host
+	Scanner string `yaml:"scanner,omitempty"`
+	// Command is the scanner executable, found on the PATH by default
+	Command string `yaml:"command,omitempty"`
+	// FailOn is the default severity at or above which scan fails, e.g. "high"
+	FailOn string `yaml:"fail_on,omitempty"`
+	// Timeout limits each scanner run (default 10m)
+	Timeout Duration `yaml:"timeout,omitempty"`
+}
+
+// ScannerName returns the configured scanner, trivy by default
+func (s ScanConfig) ScannerName() string {
+	if s.Scanner == "" {
+		return ScannerTrivy
+	}
+	return s.Scanner
+}
+
+// Agent lifecycle events delivered to webhooks
+const (
+	EventAgentCreated   = "agent.created"
+	EventAgentDestroyed = "agent.destroyed"
+	EventCheckFailed    = "check.failed"
+	EventChecksFinished = "checks.finished" // a check run completed, passed or not
+	EventJobFinished    = "job.finished"    // a scheduled command ran
+)
+
+// WebhookEvents are the events webhooks can subscribe to
+var WebhookEvents = []string{EventAgentCreated, EventAgentDestroyed, EventCheckFailed, EventChecksFinished, EventJobFinished}
+
+// WebhookConfig is an HTTP endpoint notified of agent lifecycle events
+type WebhookConfig struct {
+	// URL receives a POST request for each event. It may be a secret reference such
+	// as "env:HOOK_URL" when the URL itself is a credential.
+	URL string `yaml:"url"`
+	// Events are the events delivered, all of WebhookEvents by default
+	Events []string `yaml:"events,omitempty"`
+	// Payload is a Go template rendering the request body from the event (.Event,
+	// .AgentID, .Project, .Time and .Data); the event as JSON by default. The json
+	// function quotes a value as JSON.
+	Payload string `yaml:"payload,omitempty"`
+	// ContentType of the payload (default application/json)
+	ContentType string `yaml:"content_type,omitempty"`
+	// Headers are added to each request
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// Secret is a secret reference, e.g. "env:WEBHOOK_SECRET", whose value signs the
+	// payload with HMAC-SHA256 in the X-Capsulate-Signature header
+	Secret string `yaml:"secret,omitempty"`
+	// Retries is the number of times a failed delivery is retried (default 3)
+	Retries *int `yaml:"retries,omitempty"`
+	// Timeout limits each delivery attempt (default 10s)
+	Timeout Duration `yaml:"timeout,omitempty"`
+}
+
+// Notifier types
+const (
+	NotifierSlack = "slack"
+	NotifierTeams = "teams"
+)
+
+// NotifierEvents are the events notifiers post by default; check.failed is left out
+// as checks.finished already reports failures
+var NotifierEvents = []string{EventAgentCreated, EventAgentDestroyed, EventChecksFinished, EventJobFinished}
+
+// NotifierConfig posts human-readable messages about agent events to a Slack or
+// Microsoft Teams incoming webhook, e.g. "agent refactor-7 finished checks: 2 passed,
+// 1 failed, diff +320/-90"
+type NotifierConfig struct {
+	// Type is slack or teams
+	Type string `yaml:"type"`
+	// URL of the incoming webhook, usually a secret reference such as
+	// "env:SLACK_WEBHOOK_URL"
+	URL string `yaml:"url"`
+	// Channel overrides the channel of a Slack webhook, e.g. "#agents"
+	Channel string `yaml:"channel,omitempty"`
+	// Events are the events posted, NotifierEvents by default
+	Events []string `yaml:"events,omitempty"`
+}
+
+// CIConfig defines the ephemeral agent of 'ci exec' and what it runs
+type CIConfig struct {
+	// Template is the environment template the agent is created from
+	Template string `yaml:"template,omitempty"`
+	// DependencyLevel is the dependency isolation level of the agent (default container)
+	DependencyLevel string `yaml:"dependency_level,omitempty"`
+	// Depth makes a shallow clone of this many commits; 0 clones the full history
+	Depth int `yaml:"depth,omitempty"`
+	// Commands are run in order when none are given on the command line
+	Commands []string `yaml:"commands,omitempty"`
+	// Artifacts are files or directories, relative to the agent's working directory,
+	// exported after the commands ran (e.g. "dist", "coverage.out")
+	Artifacts []string `yaml:"artifacts,omitempty"`
+}
+
+// ValidWebhookURL reports whether url is an http(s) URL or a secret reference
+func ValidWebhookURL(url string) bool {
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") ||
+		strings.HasPrefix(url, "env:") || strings.HasPrefix(url, "file:")
+}
+
+// Subscribed reports whether the webhook receives event
+func (w WebhookConfig) Subscribed(event string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, e := range w.Events {
+		if e == event || e == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryCount returns the number of retries of a failed delivery
+func (w WebhookConfig) RetryCount() int {
+	if w.Retries == nil {
+		return 3
+	}
+	return *w.Retries
+}
+
+// PayloadTemplate parses the payload template, or returns nil when none is set
+func (w WebhookConfig) PayloadTemplate() (*template.Template, error) {
+	if w.Payload == "" {
+		return nil, nil
+	}
+	return template.New("payload").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(w.Payload)
+}
+
+// ValidWebhookEvent reports whether event is one of WebhookEvents, or "*"
+func ValidWebhookEvent(event string) bool {
+	if event == "*" {
+		return true
+	}
+	for _, known := range WebhookEvents {
+		if event == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidScanSeverity reports whether severity is one of ScanSeverities
+func ValidScanSeverity(severity string) bool {
+	for _, known := range ScanSeverities {
+		if severity == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Restart policies of services
+const (
+	RestartAlways    = "always"     // restart whenever the service exits
+	RestartOnFailure = "on-failure" // restart when the service exits non-zero (default)
+	RestartNever     = "never"      // leave the service exited
+)
+
+// namePattern matches valid service and sidecar names
+var namePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ServiceConfig declares a service run inside agents by capsulate-supervisor
+type ServiceConfig struct {
+	Name string `yaml:"name"`
+	// Command is run with bash -c, in its own process group
+	Command string `yaml:"command"`
+	// Dir is the directory the command runs in, relative to the agent's working
+	// directory
+	Dir string `yaml:"dir,omitempty"`
+	// Restart is the restart policy: always, on-failure (default) or never
+	Restart string `yaml:"restart,omitempty"`
+	// Autostart starts the service when the agent is created or recreated
+	Autostart bool `yaml:"autostart,omitempty"`
+}
+
+// RestartPolicy returns the service's restart policy, defaulting to on-failure
+func (s ServiceConfig) RestartPolicy() string {
+	if s.Restart == "" {
+		return RestartOnFailure
+	}
+	return s.Restart
+}
+
+// ValidName reports whether name may be used as a service or sidecar name
+func ValidName(name string) bool {
+	return namePattern.MatchString(name)
+}
+
+// Duration is a time.Duration that unmarshals from strings such as "90s" or "5m"
+type Duration time.Duration
+
+// UnmarshalYAML parses a duration string
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q: %v", value.Line, s, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalYAML writes the duration in its string form
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+// ByteSize is a size in bytes that unmarshals from integers or strings such as
+// "500MB" or "10GiB"
+type ByteSize int64
+
+// byteUnits are the suffixes accepted in sizes, longest first
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000}, {"TB", 1000 * 1000 * 1000 * 1000},
+	{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30}, {"T", 1 << 40},
+	{"B", 1},
+}
+
+// ParseByteSize parses a size such as "512", "500MB" or "10GiB"
+func ParseByteSize(s string) (ByteSize, error) {
+	trimmed := strings.TrimSpace(s)
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(strings.ToUpper(trimmed), strings.ToUpper(unit.suffix)) {
+			trimmed = strings.TrimSpace(trimmed[:len(trimmed)-len(unit.suffix)])
+			multiplier = unit.size
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(value * float64(multiplier)), nil
+}
+
+// UnmarshalYAML parses a size string or a number of bytes
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	var s string
+	if err := value.Decode(&s); err != nil {
+		return err
+	}
+	parsed, err := ParseByteSize(s)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", value.Line, err)
+	}
+	*b = parsed
+	return nil
+}
+
+// Sources of trusted host keys in ssh.known_hosts besides file paths
+const (
+	KnownHostsHost      = "host"
+	KnownHostsProviders = "providers"
+)
+
+// KnownHostsSources returns ssh.known_hosts, or the default of host and providers
+func (c *SSHConfig) KnownHostsSources() []string {
+	if len(c.KnownHosts) == 0 {
+		return []string{KnownHostsHost, KnownHostsProviders}
+	}
+	return c.KnownHosts
+}
+
+// KnownHostsFile resolves a known_hosts path from ssh.known_hosts: "~/" expands to
+// the home directory and relative paths are relative to the configuration file
+func (c *Config) KnownHostsFile(source string) (string, error) {
+	if strings.HasPrefix(source, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve home directory: %v", err)
+		}
+		return filepath.Join(home, source[2:]), nil
+	}
+	if !filepath.IsAbs(source) && c.path != "" {
+		return filepath.Join(filepath.Dir(c.path), source), nil
+	}
+	return source, nil
+}
+
+// Path returns the file the configuration was loaded from, or "" when no file exists
+func (c *Config) Path() string {
+	return c.path
+}
+
+// Load reads capsulate.yaml from the workspace directory. The GIT_CAPSULATE_CONFIG
+// environment variable overrides the location. A missing file yields an empty config.
+func Load(workspaceDir string) (*Config, error) {
+	path := os.Getenv("GIT_CAPSULATE_CONFIG")
+	if path == "" {
+		path = filepath.Join(workspaceDir, FileName)
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %v", path, err)
+	}
+
+	if data, err = resolveRemote(data, workspaceDir, filepath.Dir(path)); err != nil {
+		return nil, fmt.Errorf("failed to resolve remote configuration of %s: %v", path, err)
+	}
+
+	cfg := &Config{path: path}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+
+	if cfg.Project != "" {
+		if err := ValidateProject(cfg.Project); err != nil {
+			return nil, fmt.Errorf("%v in %s", err, path)
+		}
+	}
+
+	for i, check := range cfg.Checks {
+		if check.Command == "" {
+			return nil, fmt.Errorf("check #%d (%s) in %s has no command", i+1, check.Name, path)
+		}
+		if check.Name == "" {
+			cfg.Checks[i].Name = fmt.Sprintf("check-%d", i+1)
+		}
+	}
+
+	for i, registry := range cfg.Registries {
+		switch registry.Provider {
+		case "npm", "pip", "go", "docker":
+		default:
+			return nil, fmt.Errorf("registry #%d in %s has unsupported provider '%s' (npm, pip, go or docker)", i+1, path, registry.Provider)
+		}
+		if registry.URL == "" {
+			return nil, fmt.Errorf("registry #%d in %s has no url", i+1, path)
+		}
+		if err := secrets.Validate(registry.Token); err != nil {
+			return nil, fmt.Errorf("registry #%d in %s: %v", i+1, path, err)
+		}
+	}
+
+	for i, mirror := range cfg.Mirrors {
+		if mirror.URL == "" || mirror.Mirror == "" {
+			return nil, fmt.Errorf("mirror #%d in %s needs both url and mirror", i+1, path)
+		}
+	}
+
+	for i, source := range cfg.SSH.KnownHosts {
+		if source == "" {
+			return nil, fmt.Errorf("ssh.known_hosts entry #%d in %s is empty", i+1, path)
+		}
+	}
+
+	for i, protocol := range cfg.Protocols {
+		if protocol.Host == "" {
+			return nil, fmt.Errorf("protocols entry #%d in %s has no host", i+1, path)
+		}
+		if protocol.Prefer != ProtocolSSH && protocol.Prefer != ProtocolHTTPS {
+			return nil, fmt.Errorf("protocols entry for %s in %s must prefer ssh or https", protocol.Host, path)
+		}
+	}
+
+	for i, prefetch := range cfg.Cache.Prefetch {
+		if prefetch.URL == "" || strings.HasPrefix(prefetch.URL, "-") {
+			return nil, fmt.Errorf("cache.prefetch entry #%d in %s needs a repository url", i+1, path)
+		}
+		for _, branch := range prefetch.Branches {
+			if !validPrefetchBranch(branch) {
+				return nil, fmt.Errorf("cache.prefetch entry #%d in %s has an invalid branch '%s'", i+1, path, branch)
+			}
+		}
+	}
+	if cfg.Cache.PrefetchInterval < 0 {
+		return nil, fmt.Errorf("cache.prefetch_interval in %s must not be negative", path)
+	}
+
+	if cfg.Artifacts.KeepRuns < 0 || cfg.Artifacts.MaxAge < 0 {
+		return nil, fmt.Errorf("artifacts limits in %s must not be negative", path)
+	}
+
+	switch cfg.Scan.ScannerName() {
+	case ScannerTrivy, ScannerGrype:
+	default:
+		return nil, fmt.Errorf("unknown scanner '%s' in %s (trivy or grype)", cfg.Scan.Scanner, path)
+	}
+	if cfg.Scan.FailOn != "" && !ValidScanSeverity(cfg.Scan.FailOn) {
+		return nil, fmt.Errorf("scan.fail_on in %s must be one of %s", path, strings.Join(ScanSeverities, ", "))
+	}
+	if cfg.Scan.Timeout < 0 {
+		return nil, fmt.Errorf("scan.timeout in %s must not be negative", path)
+	}
+
+	for i, hook := range cfg.Webhooks {
+		if !ValidWebhookURL(hook.URL) {
+			return nil, fmt.Errorf("webhook #%d in %s needs an http(s) url or a secret reference", i+1, path)
+		}
+		for _, event := range hook.Events {
+			if !ValidWebhookEvent(event) {
+				return nil, fmt.Errorf("webhook #%d in %s has unknown event '%s' (%s)", i+1, path, event, strings.Join(WebhookEvents, ", "))
+			}
+		}
+		if _, err := hook.PayloadTemplate(); err != nil {
+			return nil, fmt.Errorf("webhook #%d in %s has an invalid payload template: %v", i+1, path, err)
+		}
+		if hook.Secret != "" {
+			if err := secrets.Validate(hook.Secret); err != nil {
+				return nil, fmt.Errorf("webhook #%d in %s: %v", i+1, path, err)
+			}
+		}
+		if hook.RetryCount() < 0 || hook.Timeout < 0 {
+			return nil, fmt.Errorf("webhook #%d in %s: retries and timeout must not be negative", i+1, path)
+		}
+	}
+
+	for i, notifier := range cfg.Notifiers {
+		if notifier.Type != NotifierSlack && notifier.Type != NotifierTeams {
+			return nil, fmt.Errorf("notifier #%d in %s has unknown type '%s' (slack or teams)", i+1, path, notifier.Type)
+		}
+		if !ValidWebhookURL(notifier.URL) {
+			return nil, fmt.Errorf("notifier #%d in %s needs an http(s) url or a secret reference", i+1, path)
+		}
+		for _, event := range notifier.Events {
+			if !ValidWebhookEvent(event) {
+				return nil, fmt.Errorf("notifier #%d in %s has unknown event '%s' (%s)", i+1, path, event, strings.Join(WebhookEvents, ", "))
+			}
+		}
+	}
+
+	if cfg.CI.Template != "" {
+		if _, ok := cfg.Templates[cfg.CI.Template]; !ok {
+			return nil, fmt.Errorf("ci.template in %s names unknown template '%s'", path, cfg.CI.Template)
+		}
+	}
+	switch cfg.CI.DependencyLevel {
+	case "", "core", "team", "container":
+	default:
+		return nil, fmt.Errorf("ci.dependency_level in %s must be core, team or container", path)
+	}
+	if cfg.CI.Depth < 0 {
+		return nil, fmt.Errorf("ci.depth in %s must not be negative", path)
+	}
+	if cfg.Trash.Retention < 0 {
+		return nil, fmt.Errorf("trash.retention in %s must not be negative", path)
+	}
+	if cfg.Stop.Timeout < 0 {
+		return nil, fmt.Errorf("stop.timeout in %s must not be negative", path)
+	}
+	if err := tracing.ValidFormat(cfg.Tracing.Format); err != nil {
+		return nil, fmt.Errorf("tracing.format in %s: %w", path, err)
+	}
+	if err := checkBuckets(cfg.Metrics.Buckets); err != nil {
+		return nil, fmt.Errorf("metrics.buckets in %s: %w", path, err)
+	}
+	switch cfg.Overlay.MountMode() {
+	case OverlayMountAuto, OverlayMountKernel, OverlayMountFuse:
+	default:
+		return nil, fmt.Errorf("overlay.mount in %s must be auto, kernel or fuse, not '%s'", path, cfg.Overlay.Mount)
+	}
+	switch cfg.Storage.DriverName() {
+	case StorageDriverAuto, StorageDriverOverlay, StorageDriverBtrfs, StorageDriverZFS:
+	default:
+		return nil, fmt.Errorf("storage.driver in %s must be auto, overlay, btrfs or zfs, not '%s'", path, cfg.Storage.Driver)
+	}
+	if cfg.Storage.Quota < 0 {
+		return nil, fmt.Errorf("storage.quota in %s must not be negative", path)
+	}
+	switch cfg.Capacity.Mode() {
+	case CapacityRefuse, CapacityWarn, CapacityOff:
+	default:
+		return nil, fmt.Errorf("capacity.check in %s must be refuse, warn or off, not '%s'", path, cfg.Capacity.Check)
+	}
+	if cfg.Capacity.MaxLoad < 0 {
+		return nil, fmt.Errorf("capacity.max_load in %s must not be negative", path)
+	}
+	if negative := cfg.Quotas.negative(); len(negative) > 0 {
+		return nil, fmt.Errorf("%s in %s must not be negative", negative[0], path)
+	}
+	if usage := cfg.Telemetry.Usage; usage.Endpoint != "" && !ValidWebhookURL(usage.Endpoint) {
+		return nil, fmt.Errorf("telemetry.usage.endpoint in %s must be an http(s) url or a secret reference", path)
+	}
+	if token := cfg.Telemetry.Usage.Token; token != "" {
+		if err := secrets.Validate(token); err != nil {
+			return nil, fmt.Errorf("telemetry.usage.token in %s: %v", path, err)
+		}
+	}
+	if cfg.Telemetry.Usage.Interval < 0 {
+		return nil, fmt.Errorf("telemetry.usage.interval in %s must not be negative", path)
+	}
+	for operation, bounds := range cfg.Metrics.Timers {
+		if err := checkBuckets(bounds); err != nil {
+			return nil, fmt.Errorf("metrics.timers.%s in %s: %w", operation, path, err)
+		}
+	}
+	hooks := make(map[string]bool)
+	for i, hook := range cfg.Stop.PreStop {
+		if hook.Name == "" {
+			return nil, fmt.Errorf("pre-stop hook #%d in %s has no name", i+1, path)
+		}
+		if hooks[hook.Name] {
+			return nil, fmt.Errorf("pre-stop hook '%s' is declared twice in %s", hook.Name, path)
+		}
+		hooks[hook.Name] = true
+		if hook.Command == "" {
+			return nil, fmt.Errorf("pre-stop hook '%s' in %s has no command", hook.Name, path)
+		}
+		if hook.Timeout < 0 {
+			return nil, fmt.Errorf("timeout of pre-stop hook '%s' in %s must not be negative", hook.Name, path)
+		}
+	}
+
+	services := make(map[string]bool)
+	for i, service := range cfg.Services {
+		if !ValidName(service.Name) {
+			return nil, fmt.Errorf("service #%d in %s has an invalid name '%s'", i+1, path, service.Name)
+		}
+		if services[service.Name] {
+			return nil, fmt.Errorf("service '%s' is declared twice in %s", service.Name, path)
+		}
+		services[service.Name] = true
+		if service.Command == "" {
+			return nil, fmt.Errorf("service '%s' in %s has no command", service.Name, path)
+		}
+		switch service.RestartPolicy() {
+		case RestartAlways, RestartOnFailure, RestartNever:
+		default:
+			return nil, fmt.Errorf("service '%s' in %s has unknown restart policy '%s' (always, on-failure or never)", service.Name, path, service.Restart)
+		}
+	}
+
+	for name, template := range cfg.Templates {
+		for _, arg := range append(append([]string{}, template.Entrypoint...), template.Command...) {
+			if arg == "" {
+				return nil, fmt.Errorf("template '%s' in %s has an empty entrypoint or command argument", name, path)
+			}
+		}
+		if hc := template.Healthcheck; hc != nil {
+			if hc.Command == "" {
+				return nil, fmt.Errorf("healthcheck of template '%s' in %s has no command", name, path)
+			}
+			if hc.Interval < 0 || hc.Timeout < 0 || hc.StartPeriod < 0 || hc.Retries < 0 {
+				return nil, fmt.Errorf("healthcheck of template '%s' in %s must not have a negative interval, timeout, start_period or retries", name, path)
+			}
+		}
+		sidecars := make(map[string]bool)
+		for i, sidecar := range template.Sidecars {
+			if !ValidName(sidecar.Name) {
+				return nil, fmt.Errorf("sidecar #%d of template '%s' in %s has an invalid name '%s'", i+1, name, path, sidecar.Name)
+			}
+			if sidecars[sidecar.Name] {
+				return nil, fmt.Errorf("sidecar '%s' is declared twice in template '%s' in %s", sidecar.Name, name, path)
+			}
+			sidecars[sidecar.Name] = true
+			if sidecar.Image == "" {
+				return nil, fmt.Errorf("sidecar '%s' of template '%s' in %s has no image", sidecar.Name, name, path)
+			}
+			for _, port := range sidecar.Ports {
+				if _, err := nat.ParsePortSpec(port); err != nil {
+					return nil, fmt.Errorf("sidecar '%s' of template '%s' in %s has an invalid port '%s': %v", sidecar.Name, name, path, port, err)
+				}
+			}
+		}
+	}
+
+	return cfg, nil
+}
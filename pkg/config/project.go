@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// ProjectEnv is the environment variable selecting the project, set by --project
+const ProjectEnv = "CAPSULATE_PROJECT"
+
+// projectPattern matches valid project names, which are embedded in container names
+var projectPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// maxProjectLength keeps container names of project agents short enough for Docker
+const maxProjectLength = 32
+
+// ValidateProject checks that a project name is usable in container names and paths
+func ValidateProject(project string) error {
+	if len(project) > maxProjectLength || !projectPattern.MatchString(project) {
+		return fmt.Errorf("invalid project '%s': it must start with a lowercase letter or digit, contain only lowercase letters, digits and '-' and be at most %d characters", project, maxProjectLength)
+	}
+	return nil
+}
+
+// ResolveProject returns the project whose agents a command works on: the
+// CAPSULATE_PROJECT environment variable if set, otherwise project in capsulate.yaml.
+// The default project, "", keeps the container names and paths of a single-project
+// workspace.
+func ResolveProject(cfg *Config) (string, error) {
+	project := os.Getenv(ProjectEnv)
+	if project == "" {
+		project = cfg.Project
+	}
+	if project == "" {
+		return "", nil
+	}
+	if err := ValidateProject(project); err != nil {
+		return "", err
+	}
+	return project, nil
+}
+
+// DataDir returns the directory holding the state, artifacts, schedules and
+// workspaces of a project's agents: <workspaceDir>/.capsulate for the default
+// project and <workspaceDir>/.capsulate/projects/<project> for the others
+func DataDir(workspaceDir, project string) string {
+	if project == "" {
+		return filepath.Join(workspaceDir, ".capsulate")
+	}
+	return filepath.Join(workspaceDir, ".capsulate", "projects", project)
+}
+
+// Projects returns the projects with a data directory in a workspace, sorted, with
+// the default project "" first
+func Projects(workspaceDir string) ([]string, error) {
+	projects := []string{""}
+	entries, err := os.ReadDir(filepath.Join(workspaceDir, ".capsulate", "projects"))
+	if os.IsNotExist(err) {
+		return projects, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list projects: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() && ValidateProject(entry.Name()) == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return append(projects, names...), nil
+}
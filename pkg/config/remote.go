@@ -0,0 +1,402 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RemoteRef points at a file in a Git repository at a pinned version, written as
+// <repo>//<path>@<ref>, e.g. git@github.com:org/platform.git//templates/node-dev.yaml@v3
+type RemoteRef struct {
+	Repo string `json:"repo"`
+	Path string `json:"path"`
+	// Ref is the tag, branch or commit the file is read at
+	Ref string `json:"ref"`
+}
+
+// String formats the reference as <repo>//<path>@<ref>
+func (r RemoteRef) String() string {
+	return r.Repo + "//" + r.Path + "@" + r.Ref
+}
+
+// ParseRemoteRef parses a <repo>//<path>@<ref> reference. The ref is required so
+// that every repository using the file is pinned to a version.
+func ParseRemoteRef(s string) (RemoteRef, error) {
+	// The // of a URL scheme is not the separator
+	start := 0
+	if i := strings.Index(s, "://"); i >= 0 {
+		start = i + 3
+	}
+	sep := strings.Index(s[start:], "//")
+	if sep < 0 {
+		return RemoteRef{}, fmt.Errorf("invalid remote reference '%s': expected <repo>//<path>@<ref>", s)
+	}
+	ref := RemoteRef{Repo: s[:start+sep]}
+	path := s[start+sep+2:]
+	at := strings.LastIndex(path, "@")
+	if at < 0 || at == len(path)-1 {
+		return RemoteRef{}, fmt.Errorf("remote reference '%s' must be pinned with @<tag, branch or commit>", s)
+	}
+	ref.Path, ref.Ref = path[:at], path[at+1:]
+
+	cleaned := filepath.ToSlash(filepath.Clean(ref.Path))
+	if ref.Repo == "" || strings.HasPrefix(ref.Repo, "-") {
+		return RemoteRef{}, fmt.Errorf("invalid repository in remote reference '%s'", s)
+	}
+	if ref.Path == "" || strings.HasPrefix(cleaned, "/") || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return RemoteRef{}, fmt.Errorf("invalid path in remote reference '%s': it must be relative to the repository root", s)
+	}
+	if strings.HasPrefix(ref.Ref, "-") || strings.ContainsAny(ref.Ref, " :~^") {
+		return RemoteRef{}, fmt.Errorf("invalid ref in remote reference '%s'", s)
+	}
+	ref.Path = cleaned
+	return ref, nil
+}
+
+// RemoteFile is a file of a RemoteRef read from the local cache
+type RemoteFile struct {
+	Ref RemoteRef `json:"ref"`
+	// Commit is the commit the ref resolved to when it was fetched
+	Commit    string    `json:"commit"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Content   []byte    `json:"-"`
+}
+
+// remoteMeta records what a cached repository was fetched at
+type remoteMeta struct {
+	Repo      string    `json:"repo"`
+	Ref       string    `json:"ref"`
+	Commit    string    `json:"commit"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// RemoteCacheDir returns the directory caching the repositories of remote templates
+// and configurations, shared by the projects of a workspace
+func RemoteCacheDir(workspaceDir string) string {
+	return filepath.Join(workspaceDir, ".capsulate", "remote")
+}
+
+// remoteCachePath returns the cache directory of a repository at a ref
+func remoteCachePath(workspaceDir string, ref RemoteRef) string {
+	sum := sha256.Sum256([]byte(ref.Repo + "@" + ref.Ref))
+	return filepath.Join(RemoteCacheDir(workspaceDir), hex.EncodeToString(sum[:8]))
+}
+
+// ReadRemote returns a remote file from the cache. The repository is fetched at the
+// pinned ref the first time only, so a moving ref such as a branch stays at the
+// commit it was first fetched at until UpdateRemote is called.
+func ReadRemote(workspaceDir string, ref RemoteRef) (*RemoteFile, error) {
+	dir := remoteCachePath(workspaceDir, ref)
+	meta, err := readRemoteMeta(dir)
+	if err != nil {
+		return nil, err
+	}
+	if meta == nil {
+		if meta, err = fetchRemote(dir, ref); err != nil {
+			return nil, err
+		}
+	}
+	return showRemote(dir, ref, meta)
+}
+
+// UpdateRemote fetches the pinned ref of a remote file again and returns the file
+// and the commit it was cached at before, empty when it was not cached
+func UpdateRemote(workspaceDir string, ref RemoteRef) (*RemoteFile, string, error) {
+	dir := remoteCachePath(workspaceDir, ref)
+	previous := ""
+	if meta, err := readRemoteMeta(dir); err != nil {
+		return nil, "", err
+	} else if meta != nil {
+		previous = meta.Commit
+	}
+	meta, err := fetchRemote(dir, ref)
+	if err != nil {
+		return nil, previous, err
+	}
+	file, err := showRemote(dir, ref, meta)
+	return file, previous, err
+}
+
+// readRemoteMeta reads the metadata of a cached repository, nil when not cached
+func readRemoteMeta(dir string) (*remoteMeta, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "meta.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote cache: %v", err)
+	}
+	meta := &remoteMeta{}
+	if err := json.Unmarshal(data, meta); err != nil {
+		return nil, fmt.Errorf("failed to parse remote cache %s: %v", dir, err)
+	}
+	return meta, nil
+}
+
+// fetchRemote fetches the ref of a remote repository into its cache directory with
+// the Git client of the host, and its credentials
+func fetchRemote(dir string, ref RemoteRef) (*remoteMeta, error) {
+	repo := filepath.Join(dir, "repo.git")
+	if _, err := os.Stat(repo); os.IsNotExist(err) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create remote cache: %v", err)
+		}
+		if _, err := runGit("init", "--quiet", "--bare", repo); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := runGit("-C", repo, "fetch", "--quiet", "--depth", "1", "--", ref.Repo, ref.Ref); err != nil {
+		return nil, fmt.Errorf("failed to fetch %s at %s: %v", ref.Repo, ref.Ref, err)
+	}
+	commit, err := runGit("-C", repo, "rev-parse", "FETCH_HEAD^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	// Keep the commit reachable so later fetches and gc do not drop it
+	if _, err := runGit("-C", repo, "update-ref", "refs/capsulate/pinned", commit); err != nil {
+		return nil, err
+	}
+
+	meta := &remoteMeta{Repo: ref.Repo, Ref: ref.Ref, Commit: commit, FetchedAt: time.Now().UTC()}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "meta.json"), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write remote cache: %v", err)
+	}
+	return meta, nil
+}
+
+// showRemote reads a file at the cached commit of a repository
+func showRemote(dir string, ref RemoteRef, meta *remoteMeta) (*RemoteFile, error) {
+	// The content is kept byte for byte so that detached signatures verify
+	content, err := runGitRaw("-C", filepath.Join(dir, "repo.git"), "show", meta.Commit+":"+ref.Path)
+	if err != nil {
+		return nil, fmt.Errorf("%s not found in %s at %s", ref.Path, ref.Repo, ref.Ref)
+	}
+	return &RemoteFile{Ref: ref, Commit: meta.Commit, FetchedAt: meta.FetchedAt, Content: content}, nil
+}
+
+// runGit runs git on the host and returns its trimmed output
+func runGit(args ...string) (string, error) {
+	output, err := runGitRaw(args...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// runGitRaw runs git on the host and returns its output as is
+func runGitRaw(args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("git: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("failed to run git: %v", err)
+	}
+	return output, nil
+}
+
+// RemoteRefs returns the remote references of a configuration file: its extends and
+// the from of its templates, by template name ("" for extends)
+func RemoteRefs(data []byte) (map[string]string, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	refs := make(map[string]string)
+	if extends, ok := doc["extends"].(string); ok && extends != "" {
+		refs[""] = extends
+	}
+	templates, _ := doc["templates"].(map[string]interface{})
+	for name, value := range templates {
+		template, _ := value.(map[string]interface{})
+		if from, ok := template["from"].(string); ok && from != "" {
+			refs[name] = from
+		}
+	}
+	return refs, nil
+}
+
+// resolveRemote merges the remote configuration a file extends and the remote
+// templates it uses into its content. Values of the file override those of the
+// remote one: mappings are merged key by key, lists and scalars are replaced. Content
+// without remote references is returned unchanged. Remote files are verified with the
+// signatures section of the file itself, whose keys are relative to configDir, so a
+// remote configuration cannot relax its own verification.
+func resolveRemote(data []byte, workspaceDir, configDir string) ([]byte, error) {
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil || doc == nil {
+		// Parse errors are reported when the content is decoded
+		return data, nil
+	}
+	var local struct {
+		Signatures SignatureConfig `yaml:"signatures"`
+	}
+	if err := yaml.Unmarshal(data, &local); err != nil {
+		return nil, fmt.Errorf("signatures: %v", err)
+	}
+	verify := func(file *RemoteFile) error {
+		_, err := VerifyRemote(workspaceDir, configDir, local.Signatures, file)
+		return err
+	}
+
+	changed := false
+	if extends, ok := doc["extends"].(string); ok && extends != "" {
+		base, err := readRemoteYAML(workspaceDir, extends, verify)
+		if err != nil {
+			return nil, fmt.Errorf("extends: %v", err)
+		}
+		if _, nested := base["extends"]; nested {
+			return nil, fmt.Errorf("extends: %s extends another configuration, which is not supported", extends)
+		}
+		doc = mergeYAML(base, doc)
+		changed = true
+	}
+
+	templates, _ := doc["templates"].(map[string]interface{})
+	for name, value := range templates {
+		template, _ := value.(map[string]interface{})
+		from, ok := template["from"].(string)
+		if !ok || from == "" {
+			continue
+		}
+		base, err := readRemoteYAML(workspaceDir, from, verify)
+		if err != nil {
+			return nil, fmt.Errorf("template '%s': %v", name, err)
+		}
+		delete(base, "from")
+		templates[name] = mergeYAML(base, template)
+		changed = true
+	}
+
+	if !changed {
+		return data, nil
+	}
+	return yaml.Marshal(doc)
+}
+
+// readRemoteYAML reads a remote file holding a YAML mapping once verify accepts it
+func readRemoteYAML(workspaceDir, s string, verify func(*RemoteFile) error) (map[string]interface{}, error) {
+	ref, err := ParseRemoteRef(s)
+	if err != nil {
+		return nil, err
+	}
+	file, err := ReadRemote(workspaceDir, ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(file); err != nil {
+		return nil, err
+	}
+	var doc map[string]interface{}
+	if err := yaml.Unmarshal(file.Content, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", s, err)
+	}
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	return doc, nil
+}
+
+// mergeYAML returns base with the values of override applied on top, merging
+// mappings recursively
+func mergeYAML(base, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		baseMap, baseOK := merged[key].(map[string]interface{})
+		overrideMap, overrideOK := value.(map[string]interface{})
+		if baseOK && overrideOK {
+			merged[key] = mergeYAML(baseMap, overrideMap)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// SetTemplateSource makes the template name of a configuration file use a remote
+// template, keeping the other content and comments of the file. The file is created
+// when it does not exist.
+func SetTemplateSource(path, name, ref string) error {
+	var root yaml.Node
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config %s: %v", path, err)
+	}
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("failed to parse config %s: %v", path, err)
+	}
+	if len(root.Content) == 0 {
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return fmt.Errorf("config %s is not a mapping", path)
+	}
+
+	templates := mappingValue(doc, "templates")
+	template := mappingValue(templates, name)
+	if from := mappingEntry(template, "from"); from != nil {
+		from.Value = ref
+	} else {
+		template.Content = append([]*yaml.Node{
+			{Kind: yaml.ScalarNode, Value: "from"},
+			{Kind: yaml.ScalarNode, Value: ref},
+		}, template.Content...)
+	}
+
+	var out strings.Builder
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("failed to encode config: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write config %s: %v", path, err)
+	}
+	return nil
+}
+
+// mappingEntry returns the value of key in a mapping node, or nil
+func mappingEntry(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingValue returns the mapping stored under key in a mapping node, adding an
+// empty one when the key is missing or not a mapping
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if value := mappingEntry(node, key); value != nil {
+		if value.Kind != yaml.MappingNode {
+			*value = yaml.Node{Kind: yaml.MappingNode}
+		}
+		return value
+	}
+	value := &yaml.Node{Kind: yaml.MappingNode}
+	node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+	return value
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// StrictEnv is the environment variable turning on strict signature checks, set by
+// --strict
+const StrictEnv = "CAPSULATE_STRICT"
+
+// Signature tools
+const (
+	SignerMinisign = "minisign"
+	SignerCosign   = "cosign"
+)
+
+// ErrUnsigned is returned (wrapped) in strict mode for remote templates and images
+// without a valid signature
+var ErrUnsigned = errors.New("no valid signature")
+
+// SignatureConfig verifies the remote templates and configurations and the container
+// images fetched from central sources. Remote files are signed with detached
+// signatures committed next to them: <file>.minisig for minisign and <file>.sig for
+// cosign sign-blob. Images are signed with cosign sign. A signature that does not
+// verify is always refused; a missing one only in strict mode.
+type SignatureConfig struct {
+	// Strict refuses remote files and images without a valid signature; --strict
+	// turns it on for a single command
+	Strict bool `yaml:"strict,omitempty"`
+	// MinisignKey is the minisign public key file verifying remote files
+	MinisignKey string `yaml:"minisign_key,omitempty"`
+	// CosignKey is the cosign public key (a file or a KMS URI) verifying remote files
+	// and images
+	CosignKey string `yaml:"cosign_key,omitempty"`
+}
+
+// StrictMode reports whether signatures are required, by signatures.strict or --strict
+func (s SignatureConfig) StrictMode() bool {
+	return s.Strict || os.Getenv(StrictEnv) != ""
+}
+
+// Enabled reports whether anything is verified
+func (s SignatureConfig) Enabled() bool {
+	return s.StrictMode() || s.MinisignKey != "" || s.CosignKey != ""
+}
+
+// resolveKey resolves a key path relative to the directory of the configuration file;
+// "~/" expands to the home directory and cosign KMS URIs are kept
+func resolveKey(key, configDir string) string {
+	switch {
+	case key == "" || strings.Contains(key, "://"):
+		return key
+	case strings.HasPrefix(key, "~/"):
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, key[2:])
+		}
+	case !filepath.IsAbs(key) && configDir != "":
+		return filepath.Join(configDir, key)
+	}
+	return key
+}
+
+// SignatureKeys returns the minisign and cosign keys resolved relative to the
+// configuration file
+func (c *Config) SignatureKeys() (string, string) {
+	dir := ""
+	if c.path != "" {
+		dir = filepath.Dir(c.path)
+	}
+	return resolveKey(c.Signatures.MinisignKey, dir), resolveKey(c.Signatures.CosignKey, dir)
+}
+
+// VerifyRemote checks the signature of a remote file committed next to it and returns
+// the tool that verified it, or "" for an unsigned file outside strict mode.
+// configDir is the directory keys are relative to.
+func VerifyRemote(workspaceDir, configDir string, sigs SignatureConfig, file *RemoteFile) (string, error) {
+	signers := []struct {
+		tool, key, suffix string
+	}{
+		{SignerMinisign, resolveKey(sigs.MinisignKey, configDir), ".minisig"},
+		{SignerCosign, resolveKey(sigs.CosignKey, configDir), ".sig"},
+	}
+	for _, signer := range signers {
+		if signer.key == "" {
+			continue
+		}
+		sigRef := file.Ref
+		sigRef.Path += signer.suffix
+		signature, err := ReadRemote(workspaceDir, sigRef)
+		if err != nil {
+			// Not signed with this tool
+			continue
+		}
+		if err := verifyBlob(signer.tool, signer.key, file.Content, signature.Content); err != nil {
+			return "", fmt.Errorf("signature of %s does not verify: %v", file.Ref, err)
+		}
+		return signer.tool, nil
+	}
+
+	if !sigs.StrictMode() {
+		return "", nil
+	}
+	if sigs.MinisignKey == "" && sigs.CosignKey == "" {
+		return "", fmt.Errorf("%w for %s: strict mode needs signatures.minisign_key or signatures.cosign_key", ErrUnsigned, file.Ref)
+	}
+	return "", fmt.Errorf("%w for %s: no %s.minisig or %s.sig matching a configured key", ErrUnsigned, file.Ref, file.Ref.Path, file.Ref.Path)
+}
+
+// verifyBlob verifies content against a detached signature with the minisign or cosign
+// tool of the host
+func verifyBlob(tool, key string, content, signature []byte) error {
+	dir, err := os.MkdirTemp("", "capsulate-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	contentPath, signaturePath := filepath.Join(dir, "file"), filepath.Join(dir, "file.sig")
+	if err := os.WriteFile(contentPath, content, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(signaturePath, signature, 0600); err != nil {
+		return err
+	}
+
+	var cmd *exec.Cmd
+	switch tool {
+	case SignerMinisign:
+		cmd = exec.Command("minisign", "-V", "-q", "-p", key, "-m", contentPath, "-x", signaturePath)
+	case SignerCosign:
+		cmd = exec.Command("cosign", "verify-blob", "--key", key, "--signature", signaturePath, contentPath)
+	default:
+		return fmt.Errorf("unknown signature tool '%s'", tool)
+	}
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return fmt.Errorf("%s is not installed", tool)
+		}
+		return fmt.Errorf("%s: %s", tool, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// VerifyImage checks the cosign signature of a container image in its registry and
+// reports whether it is signed. An unsigned image is an error in strict mode only.
+// cosignKey is the resolved signatures.cosign_key.
+func VerifyImage(sigs SignatureConfig, cosignKey, image string) (bool, error) {
+	if cosignKey == "" {
+		if sigs.StrictMode() {
+			return false, fmt.Errorf("%w for image %s: strict mode needs signatures.cosign_key", ErrUnsigned, image)
+		}
+		return false, nil
+	}
+
+	output, err := exec.Command("cosign", "verify", "--key", cosignKey, image).CombinedOutput()
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return false, fmt.Errorf("cosign is not installed; it is needed to verify image %s", image)
+	}
+	message := strings.TrimSpace(string(output))
+	if strings.Contains(message, "no signatures found") {
+		if sigs.StrictMode() {
+			return false, fmt.Errorf("%w for image %s", ErrUnsigned, image)
+		}
+		return false, nil
+	}
+	return false, fmt.Errorf("signature of image %s does not verify: %s", image, message)
+}
@@ -0,0 +1,777 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/your-org/capsulate-repo/pkg/deps"
+	"github.com/your-org/capsulate-repo/pkg/secrets"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+	"gopkg.in/yaml.v3"
+)
+
+// Issue severities reported by ValidateFile
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Issue is a problem found while validating a configuration file
+type Issue struct {
+	Path     string `json:"path"`           // key path such as "checks[1].timeout"
+	Line     int    `json:"line,omitempty"` // line in the file, 0 when unknown
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// String formats the issue as "line N: path: severity: message"
+func (i Issue) String() string {
+	location := i.Path
+	if i.Line > 0 {
+		location = fmt.Sprintf("line %d: %s", i.Line, i.Path)
+	}
+	return fmt.Sprintf("%s: %s: %s", location, i.Severity, i.Message)
+}
+
+// branchPlaceholders are the placeholders supported in branch templates
+var branchPlaceholders = map[string]bool{"{id}": true, "{team}": true, "{date}": true, "{name}": true}
+
+// placeholderPattern matches {placeholder} segments
+var placeholderPattern = regexp.MustCompile(`\{[^}]*\}`)
+
+// ResolvePath returns the configuration file used for a workspace, honouring
+// GIT_CAPSULATE_CONFIG like Load does
+func ResolvePath(workspaceDir string) string {
+	if path := os.Getenv("GIT_CAPSULATE_CONFIG"); path != "" {
+		return path
+	}
+	return filepath.Join(workspaceDir, FileName)
+}
+
+// ValidateFile checks a configuration file against the schema and reports every
+// problem found rather than stopping at the first one: unknown keys, wrongly typed
+// values, bad durations, conflicting settings and secret references that cannot be
+// resolved. The error is only set when the file cannot be read.
+func ValidateFile(path string) ([]Issue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return []Issue{{Severity: SeverityError, Message: fmt.Sprintf("invalid YAML: %v", err)}}, nil
+	}
+	if len(root.Content) == 0 {
+		return nil, nil
+	}
+
+	var issues []Issue
+	checkNode(root.Content[0], reflect.TypeOf(Config{}), "", &issues)
+
+	// Decode value by value so one bad value does not hide problems in the others
+	cfg := Config{path: path}
+	decodeLenient(root.Content[0], reflect.ValueOf(&cfg).Elem(), "", &issues)
+	issues = append(issues, checkValues(&cfg, root.Content[0])...)
+
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return issues, nil
+}
+
+// HasErrors reports whether any issue is an error rather than a warning
+func HasErrors(issues []Issue) bool {
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// checkNode reports keys that do not exist in the schema type t, recursively
+func checkNode(node *yaml.Node, t reflect.Type, path string, issues *[]Issue) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == reflect.TypeOf(Duration(0)) || node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			field, ok := fields[key.Value]
+			if !ok {
+				*issues = append(*issues, Issue{
+					Path:     joinPath(path, key.Value),
+					Line:     key.Line,
+					Severity: SeverityError,
+					Message:  fmt.Sprintf("unknown key '%s'", key.Value),
+				})
+				continue
+			}
+			checkNode(value, field.Type, joinPath(path, key.Value), issues)
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, item := range node.Content {
+			checkNode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), issues)
+		}
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkNode(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value), issues)
+		}
+	}
+}
+
+// decodeLenient decodes node into v field by field and element by element,
+// reporting values that fail to decode instead of stopping at the first one
+func decodeLenient(node *yaml.Node, v reflect.Value, path string, issues *[]Issue) {
+	t := v.Type()
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if field, ok := fields[key.Value]; ok {
+				decodeLenient(value, v.FieldByIndex(field.Index), joinPath(path, key.Value), issues)
+			}
+		}
+		return
+
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		slice := reflect.MakeSlice(t, len(node.Content), len(node.Content))
+		for i, item := range node.Content {
+			decodeLenient(item, slice.Index(i), fmt.Sprintf("%s[%d]", path, i), issues)
+		}
+		v.Set(slice)
+		return
+	}
+
+	if err := node.Decode(v.Addr().Interface()); err != nil {
+		message := err.Error()
+		if typeErr, ok := err.(*yaml.TypeError); ok {
+			message = strings.Join(typeErr.Errors, "; ")
+		}
+		*issues = append(*issues, Issue{
+			Path:     path,
+			Line:     node.Line,
+			Severity: SeverityError,
+			Message:  strings.TrimPrefix(message, fmt.Sprintf("line %d: ", node.Line)),
+		})
+	}
+}
+
+// checkValues runs the semantic checks on a decoded configuration
+func checkValues(cfg *Config, root *yaml.Node) []Issue {
+	var issues []Issue
+	add := func(path, severity, format string, args ...interface{}) {
+		issues = append(issues, Issue{
+			Path:     path,
+			Line:     lineOf(root, path),
+			Severity: severity,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	if cfg.Project != "" {
+		if err := ValidateProject(cfg.Project); err != nil {
+			add("project", SeverityError, "%v", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i, check := range cfg.Checks {
+		path := fmt.Sprintf("checks[%d]", i)
+		if check.Command == "" {
+			add(path, SeverityError, "check has no command")
+		}
+		if check.Name != "" && names[check.Name] {
+			add(path+".name", SeverityError, "duplicate check name '%s'", check.Name)
+		}
+		names[check.Name] = true
+		if check.Timeout < 0 {
+			add(path+".timeout", SeverityError, "timeout must not be negative")
+		}
+		for j, artifact := range check.Artifacts {
+			cleaned := filepath.Clean(artifact)
+			if artifact == "" || filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+				add(fmt.Sprintf("%s.artifacts[%d]", path, j), SeverityError, "artifact path '%s' must be relative to the working directory", artifact)
+			}
+		}
+		for j, pattern := range check.Reports {
+			cleaned := filepath.Clean(pattern)
+			if pattern == "" || filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+				add(fmt.Sprintf("%s.reports[%d]", path, j), SeverityError, "report pattern '%s' must be relative to the working directory", pattern)
+			} else if _, err := filepath.Match(pattern, ""); err != nil {
+				add(fmt.Sprintf("%s.reports[%d]", path, j), SeverityError, "invalid pattern '%s': %v", pattern, err)
+			}
+		}
+	}
+
+	for _, placeholder := range placeholderPattern.FindAllString(cfg.Branches.Template, -1) {
+		if !branchPlaceholders[placeholder] {
+			add("branches.template", SeverityError, "unknown placeholder %s (supported: {id}, {team}, {date}, {name})", placeholder)
+		}
+	}
+	for i, pattern := range cfg.Branches.Protected {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			add(fmt.Sprintf("branches.protected[%d]", i), SeverityError, "invalid pattern '%s': %v", pattern, err)
+		}
+	}
+
+	if cfg.Provenance.Sign && !cfg.Provenance.Enabled {
+		add("provenance.sign", SeverityWarning, "sign has no effect unless provenance is enabled")
+	}
+
+	for i, name := range cfg.Dependencies.Providers {
+		if _, err := deps.Get(name); err != nil {
+			add(fmt.Sprintf("dependencies.providers[%d]", i), SeverityError, "unknown provider '%s' (available: %s)", name, strings.Join(deps.Names(), ", "))
+		}
+	}
+
+	for i, registry := range cfg.Registries {
+		path := fmt.Sprintf("registries[%d]", i)
+		switch registry.Provider {
+		case "npm", "pip", "go", "docker":
+		default:
+			add(path+".provider", SeverityError, "unsupported provider '%s' (npm, pip, go or docker)", registry.Provider)
+		}
+		if registry.URL == "" {
+			add(path+".url", SeverityError, "registry has no url")
+		}
+		if registry.Scope != "" && registry.Provider != "npm" {
+			add(path+".scope", SeverityWarning, "scope only applies to npm registries")
+		}
+		if len(registry.Private) > 0 && registry.Provider != "go" {
+			add(path+".private", SeverityWarning, "private only applies to go registries")
+		}
+		if registry.Provider == "docker" && registry.Username == "" {
+			add(path+".username", SeverityWarning, "docker registries generally need a username besides the token")
+		}
+		if registry.Provider == "go" && len(registry.Private) == 0 {
+			add(path+".private", SeverityWarning, "go registry lists no private module patterns, so GOPRIVATE is not set")
+		}
+		if err := secrets.Validate(registry.Token); err != nil {
+			add(path+".token", SeverityError, "%v", err)
+		} else if _, err := secrets.Resolve(registry.Token); err != nil {
+			add(path+".token", SeverityError, "referenced secret is missing: %v", err)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, mirror := range cfg.Mirrors {
+		path := fmt.Sprintf("mirrors[%d]", i)
+		if mirror.URL == "" {
+			add(path+".url", SeverityError, "mirror has no url")
+		}
+		if mirror.Mirror == "" {
+			add(path+".mirror", SeverityError, "mirror has no mirror url")
+		}
+		if mirror.URL != "" && mirror.URL == mirror.Mirror {
+			add(path+".mirror", SeverityWarning, "mirror is the same as url")
+		}
+		if seen[mirror.URL] {
+			add(path+".url", SeverityError, "duplicate mirror for '%s'", mirror.URL)
+		}
+		seen[mirror.URL] = true
+	}
+
+	hosts := make(map[string]bool)
+	for i, protocol := range cfg.Protocols {
+		path := fmt.Sprintf("protocols[%d]", i)
+		if protocol.Host == "" {
+			add(path+".host", SeverityError, "protocol preference has no host")
+		} else if hosts[strings.ToLower(protocol.Host)] {
+			add(path+".host", SeverityError, "duplicate protocol preference for '%s'", protocol.Host)
+		}
+		hosts[strings.ToLower(protocol.Host)] = true
+		if protocol.Prefer != ProtocolSSH && protocol.Prefer != ProtocolHTTPS {
+			add(path+".prefer", SeverityError, "prefer must be ssh or https")
+		}
+	}
+
+	for i, source := range cfg.SSH.KnownHosts {
+		path := fmt.Sprintf("ssh.known_hosts[%d]", i)
+		switch source {
+		case "":
+			add(path, SeverityError, "entry is empty")
+		case KnownHostsHost, KnownHostsProviders:
+		default:
+			file, err := cfg.KnownHostsFile(source)
+			if err == nil {
+				_, err = os.Stat(file)
+			}
+			if err != nil {
+				add(path, SeverityError, "known_hosts file cannot be read: %v", err)
+			}
+		}
+	}
+
+	minisignKey, cosignKey := cfg.SignatureKeys()
+	for _, key := range []struct{ path, file string }{
+		{"signatures.minisign_key", minisignKey},
+		{"signatures.cosign_key", cosignKey},
+	} {
+		if key.file == "" || strings.Contains(key.file, "://") {
+			continue
+		}
+		if _, err := os.Stat(key.file); err != nil {
+			add(key.path, SeverityError, "key cannot be read: %v", err)
+		}
+	}
+	if cfg.Signatures.StrictMode() && cosignKey == "" {
+		if minisignKey == "" {
+			add("signatures", SeverityError, "strict mode refuses everything without a minisign_key or cosign_key")
+		} else {
+			add("signatures.cosign_key", SeverityWarning, "strict mode refuses every image without a cosign_key")
+		}
+	}
+	if cfg.Images.From != "" && strings.HasPrefix(cfg.Images.From, "-") {
+		add("images.from", SeverityError, "invalid image '%s'", cfg.Images.From)
+	}
+
+	prefetched := make(map[string]bool)
+	for i, prefetch := range cfg.Cache.Prefetch {
+		path := fmt.Sprintf("cache.prefetch[%d]", i)
+		if prefetch.URL == "" || strings.HasPrefix(prefetch.URL, "-") {
+			add(path+".url", SeverityError, "prefetch entry needs a repository url")
+		} else if prefetched[prefetch.URL] {
+			add(path+".url", SeverityWarning, "'%s' is prefetched twice", prefetch.URL)
+		}
+		prefetched[prefetch.URL] = true
+		for j, branch := range prefetch.Branches {
+			if !validPrefetchBranch(branch) {
+				add(fmt.Sprintf("%s.branches[%d]", path, j), SeverityError, "invalid branch name '%s'", branch)
+			}
+		}
+	}
+	if len(cfg.Cache.Prefetch) > 0 && !cfg.Cache.Clone {
+		add("cache.prefetch", SeverityWarning, "prefetched mirrors are only cloned from when cache.clone is set")
+	}
+	if cfg.Cache.PrefetchInterval < 0 {
+		add("cache.prefetch_interval", SeverityError, "prefetch_interval must not be negative")
+	}
+
+	if cfg.Artifacts.KeepRuns < 0 {
+		add("artifacts.keep_runs", SeverityError, "keep_runs must not be negative")
+	}
+
+	switch cfg.Scan.ScannerName() {
+	case ScannerTrivy, ScannerGrype:
+	default:
+		add("scan.scanner", SeverityError, "unknown scanner '%s' (trivy or grype)", cfg.Scan.Scanner)
+	}
+	if cfg.Scan.FailOn != "" && !ValidScanSeverity(cfg.Scan.FailOn) {
+		add("scan.fail_on", SeverityError, "fail_on must be one of %s", strings.Join(ScanSeverities, ", "))
+	}
+	if cfg.Scan.Timeout < 0 {
+		add("scan.timeout", SeverityError, "timeout must not be negative")
+	}
+	if cfg.Artifacts.MaxAge < 0 {
+		add("artifacts.max_age", SeverityError, "max_age must not be negative")
+	}
+
+	for i, hook := range cfg.Webhooks {
+		path := fmt.Sprintf("webhooks[%d]", i)
+		if hook.URL == "" {
+			add(path+".url", SeverityError, "webhook has no url")
+		} else {
+			checkWebhookURL(path+".url", hook.URL, add)
+			if strings.HasPrefix(hook.URL, "http://") && hook.Secret == "" {
+				add(path+".url", SeverityWarning, "events are sent unencrypted and unsigned")
+			}
+		}
+		for _, event := range hook.Events {
+			if !ValidWebhookEvent(event) {
+				add(path+".events", SeverityError, "unknown event '%s' (%s)", event, strings.Join(WebhookEvents, ", "))
+			}
+		}
+		if _, err := hook.PayloadTemplate(); err != nil {
+			add(path+".payload", SeverityError, "invalid payload template: %v", err)
+		}
+		if hook.Secret != "" {
+			if err := secrets.Validate(hook.Secret); err != nil {
+				add(path+".secret", SeverityError, "%v", err)
+			} else if _, err := secrets.Resolve(hook.Secret); err != nil {
+				add(path+".secret", SeverityError, "referenced secret is missing: %v", err)
+			}
+		}
+		if hook.RetryCount() < 0 {
+			add(path+".retries", SeverityError, "retries must not be negative")
+		}
+		if hook.Timeout < 0 {
+			add(path+".timeout", SeverityError, "timeout must not be negative")
+		}
+	}
+
+	for i, notifier := range cfg.Notifiers {
+		path := fmt.Sprintf("notifiers[%d]", i)
+		if notifier.Type != NotifierSlack && notifier.Type != NotifierTeams {
+			add(path+".type", SeverityError, "unknown notifier type '%s' (slack or teams)", notifier.Type)
+		}
+		if notifier.URL == "" {
+			add(path+".url", SeverityError, "notifier has no url")
+		} else {
+			checkWebhookURL(path+".url", notifier.URL, add)
+			if strings.HasPrefix(notifier.URL, "http") {
+				add(path+".url", SeverityWarning, "incoming webhook URLs are credentials: use a secret reference such as env:SLACK_WEBHOOK_URL")
+			}
+		}
+		if notifier.Channel != "" && notifier.Type != NotifierSlack {
+			add(path+".channel", SeverityWarning, "channel only applies to slack notifiers")
+		}
+		for _, event := range notifier.Events {
+			if !ValidWebhookEvent(event) {
+				add(path+".events", SeverityError, "unknown event '%s' (%s)", event, strings.Join(WebhookEvents, ", "))
+			}
+		}
+	}
+
+	if cfg.Extends != "" {
+		if _, err := ParseRemoteRef(cfg.Extends); err != nil {
+			add("extends", SeverityError, "%v", err)
+		}
+	}
+	for name, template := range cfg.Templates {
+		if template.From != "" {
+			if _, err := ParseRemoteRef(template.From); err != nil {
+				add("templates."+name+".from", SeverityError, "%v", err)
+			}
+		}
+	}
+
+	// Templates may come from the configuration this file extends
+	if cfg.CI.Template != "" && cfg.Extends == "" {
+		if _, ok := cfg.Templates[cfg.CI.Template]; !ok {
+			add("ci.template", SeverityError, "unknown template '%s'", cfg.CI.Template)
+		}
+	}
+	switch cfg.CI.DependencyLevel {
+	case "", "core", "team", "container":
+	default:
+		add("ci.dependency_level", SeverityError, "unknown dependency level '%s' (core, team or container)", cfg.CI.DependencyLevel)
+	}
+	if cfg.CI.Depth < 0 {
+		add("ci.depth", SeverityError, "depth must not be negative")
+	}
+	for i, command := range cfg.CI.Commands {
+		if strings.TrimSpace(command) == "" {
+			add(fmt.Sprintf("ci.commands[%d]", i), SeverityError, "command is empty")
+		}
+	}
+	for i, artifact := range cfg.CI.Artifacts {
+		cleaned := filepath.Clean(artifact)
+		if artifact == "" || filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			add(fmt.Sprintf("ci.artifacts[%d]", i), SeverityError, "artifact path '%s' must be relative to the working directory", artifact)
+		}
+	}
+
+	if cfg.Trash.Retention < 0 {
+		add("trash.retention", SeverityError, "retention must not be negative")
+	} else if cfg.Trash.Retention > 0 && !cfg.Trash.TrashEnabled() {
+		add("trash.retention", SeverityWarning, "retention has no effect while the trash is disabled")
+	}
+
+	if cfg.Stop.Timeout < 0 {
+		add("stop.timeout", SeverityError, "timeout must not be negative")
+	}
+	if err := tracing.ValidFormat(cfg.Tracing.Format); err != nil {
+		add("tracing.format", SeverityError, "%v", err)
+	}
+	if err := checkBuckets(cfg.Metrics.Buckets); err != nil {
+		add("metrics.buckets", SeverityError, "%v", err)
+	}
+	switch cfg.Overlay.MountMode() {
+	case OverlayMountAuto, OverlayMountKernel, OverlayMountFuse:
+	default:
+		add("overlay.mount", SeverityError, "unknown mount '%s': use auto, kernel or fuse", cfg.Overlay.Mount)
+	}
+	switch cfg.Storage.DriverName() {
+	case StorageDriverAuto, StorageDriverOverlay, StorageDriverBtrfs, StorageDriverZFS:
+	default:
+		add("storage.driver", SeverityError, "unknown driver '%s': use auto, overlay, btrfs or zfs", cfg.Storage.Driver)
+	}
+	if cfg.Storage.Quota < 0 {
+		add("storage.quota", SeverityError, "quota must not be negative")
+	} else if cfg.Storage.Quota > 0 && cfg.Storage.DriverName() == StorageDriverOverlay {
+		add("storage.quota", SeverityWarning, "the overlay driver cannot enforce a quota; it applies to btrfs and zfs layers only")
+	}
+	for operation, bounds := range cfg.Metrics.Timers {
+		if err := checkBuckets(bounds); err != nil {
+			add("metrics.timers."+operation, SeverityError, "%v", err)
+		}
+	}
+	switch cfg.Capacity.Mode() {
+	case CapacityRefuse, CapacityWarn, CapacityOff:
+	default:
+		add("capacity.check", SeverityError, "unknown check '%s': use refuse, warn or off", cfg.Capacity.Check)
+	}
+	if cfg.Capacity.MaxLoad < 0 {
+		add("capacity.max_load", SeverityError, "max_load must not be negative")
+	}
+	for _, setting := range cfg.Quotas.negative() {
+		add(setting, SeverityError, "%s must not be negative", setting[strings.LastIndex(setting, ".")+1:])
+	}
+	usage := cfg.Telemetry.Usage
+	if usage.Endpoint != "" {
+		checkWebhookURL("telemetry.usage.endpoint", usage.Endpoint, add)
+	}
+	if usage.Token != "" {
+		if err := secrets.Validate(usage.Token); err != nil {
+			add("telemetry.usage.token", SeverityError, "%v", err)
+		} else if usage.Endpoint == "" {
+			add("telemetry.usage.token", SeverityWarning, "token has no effect without an endpoint")
+		}
+	}
+	if usage.Interval < 0 {
+		add("telemetry.usage.interval", SeverityError, "interval must not be negative")
+	}
+	optedIn := cfg.Telemetry.TelemetryEnabled()
+	for _, enabled := range cfg.Telemetry.Commands {
+		optedIn = optedIn || enabled
+	}
+	if usage.Endpoint != "" && !optedIn {
+		add("telemetry.usage", SeverityWarning, "usage is not reported while telemetry is disabled for every command")
+	}
+	hooks := make(map[string]bool)
+	for i, hook := range cfg.Stop.PreStop {
+		path := fmt.Sprintf("stop.pre_stop[%d]", i)
+		if hook.Name == "" {
+			add(path+".name", SeverityError, "hook has no name")
+		} else if hooks[hook.Name] {
+			add(path+".name", SeverityError, "duplicate hook name '%s'", hook.Name)
+		}
+		hooks[hook.Name] = true
+		if hook.Command == "" {
+			add(path, SeverityError, "hook has no command")
+		}
+		if hook.Timeout < 0 {
+			add(path+".timeout", SeverityError, "timeout must not be negative")
+		}
+	}
+
+	services := make(map[string]bool)
+	for i, service := range cfg.Services {
+		path := fmt.Sprintf("services[%d]", i)
+		if !ValidName(service.Name) {
+			add(path+".name", SeverityError, "invalid service name '%s': use letters, digits, '.', '_' and '-'", service.Name)
+		} else if services[service.Name] {
+			add(path+".name", SeverityError, "duplicate service name '%s'", service.Name)
+		}
+		services[service.Name] = true
+		if service.Command == "" {
+			add(path+".command", SeverityError, "service has no command")
+		}
+		switch service.RestartPolicy() {
+		case RestartAlways, RestartOnFailure, RestartNever:
+		default:
+			add(path+".restart", SeverityError, "unknown restart policy '%s' (always, on-failure or never)", service.Restart)
+		}
+		cleaned := filepath.Clean(service.Dir)
+		if service.Dir != "" && (filepath.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../")) {
+			add(path+".dir", SeverityError, "dir '%s' must be relative to the working directory", service.Dir)
+		}
+	}
+
+	templateNames := make([]string, 0, len(cfg.Templates))
+	for name := range cfg.Templates {
+		templateNames = append(templateNames, name)
+	}
+	sort.Strings(templateNames)
+	for _, name := range templateNames {
+		template := cfg.Templates[name]
+		path := "templates." + name
+		for i, arg := range template.Entrypoint {
+			if arg == "" {
+				add(fmt.Sprintf("%s.entrypoint[%d]", path, i), SeverityError, "argument is empty")
+			}
+		}
+		for i, arg := range template.Command {
+			if arg == "" {
+				add(fmt.Sprintf("%s.command[%d]", path, i), SeverityError, "argument is empty")
+			}
+		}
+		if hc := template.Healthcheck; hc != nil {
+			hcPath := path + ".healthcheck"
+			if hc.Command == "" {
+				add(hcPath+".command", SeverityError, "healthcheck has no command")
+			}
+			for field, value := range map[string]Duration{"interval": hc.Interval, "timeout": hc.Timeout, "start_period": hc.StartPeriod} {
+				if value < 0 {
+					add(hcPath+"."+field, SeverityError, "%s must not be negative", field)
+				}
+			}
+			if hc.Retries < 0 {
+				add(hcPath+".retries", SeverityError, "retries must not be negative")
+			}
+			if hc.Interval > 0 && hc.HealthTimeout() > hc.HealthInterval() {
+				add(hcPath+".timeout", SeverityWarning, "timeout %s is longer than the interval %s", hc.HealthTimeout(), hc.HealthInterval())
+			}
+		}
+		sidecars := make(map[string]bool)
+		for i, sidecar := range template.Sidecars {
+			sidecarPath := fmt.Sprintf("%s.sidecars[%d]", path, i)
+			if !ValidName(sidecar.Name) {
+				add(sidecarPath+".name", SeverityError, "invalid sidecar name '%s': use letters, digits, '.', '_' and '-'", sidecar.Name)
+			} else if sidecars[sidecar.Name] {
+				add(sidecarPath+".name", SeverityError, "duplicate sidecar name '%s'", sidecar.Name)
+			}
+			sidecars[sidecar.Name] = true
+			if sidecar.Image == "" {
+				add(sidecarPath+".image", SeverityError, "sidecar has no image")
+			}
+			for j, port := range sidecar.Ports {
+				if _, err := nat.ParsePortSpec(port); err != nil {
+					add(fmt.Sprintf("%s.ports[%d]", sidecarPath, j), SeverityError, "invalid port '%s': %v", port, err)
+				}
+			}
+		}
+	}
+
+	return issues
+}
+
+// Schema returns a JSON Schema describing capsulate.yaml, generated from Config
+func Schema() map[string]interface{} {
+	schema := schemaFor(reflect.TypeOf(Config{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = FileName
+	return schema
+}
+
+// schemaFor builds the JSON Schema of a Go type using its yaml tags
+func schemaFor(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(Duration(0)) {
+		return map[string]interface{}{
+			"type":    "string",
+			"pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+		}
+	}
+	if t == reflect.TypeOf(ByteSize(0)) {
+		return map[string]interface{}{
+			"type":    []string{"string", "integer"},
+			"pattern": `^[0-9]+(\.[0-9]+)? *([KMGTkmgt][iI]?[bB]?|[bB])?$`,
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]interface{})
+		fields := yamlFields(t)
+		for name, field := range fields {
+			properties[name] = schemaFor(field.Type)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"properties":           properties,
+			"additionalProperties": false,
+		}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float64, reflect.Float32:
+		return map[string]interface{}{"type": "number"}
+	}
+	return map[string]interface{}{"type": "string"}
+}
+
+// yamlFields returns the exported fields of a struct keyed by their yaml name
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// lineOf finds the line of a key path such as "checks[1].timeout" in the document,
+// falling back to the closest existing parent
+func lineOf(root *yaml.Node, path string) int {
+	node, line := root, root.Line
+	for _, part := range strings.Split(path, ".") {
+		key, index := part, -1
+		if i := strings.Index(part, "["); i >= 0 {
+			key = part[:i]
+			fmt.Sscanf(part[i:], "[%d]", &index)
+		}
+
+		found := false
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					line = node.Content[i].Line
+					node = node.Content[i+1]
+					found = true
+					break
+				}
+			}
+		}
+		if !found {
+			return line
+		}
+		if index >= 0 {
+			if node.Kind != yaml.SequenceNode || index >= len(node.Content) {
+				return line
+			}
+			node = node.Content[index]
+			line = node.Line
+		}
+	}
+	return line
+}
+
+// joinPath appends a key to a key path
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// checkWebhookURL reports a webhook URL that is neither http(s) nor a resolvable
+// secret reference
+func checkWebhookURL(path, url string, add func(path, severity, format string, args ...interface{})) {
+	if strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") {
+		return
+	}
+	if err := secrets.Validate(url); err != nil {
+		add(path, SeverityError, "url must be http(s) or a secret reference such as env:HOOK_URL")
+	} else if _, err := secrets.Resolve(url); err != nil {
+		add(path, SeverityError, "referenced secret is missing: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cargoProvider uses the container layer as CARGO_HOME and seeds its registry cache
+// with the crates downloaded into the core and team layers, so they are not fetched again
+type cargoProvider struct{}
+
+func init() {
+	register(cargoProvider{})
+}
+
+func (cargoProvider) Name() string        { return "cargo" }
+func (cargoProvider) Markers() []string   { return []string{"Cargo.toml"} }
+func (cargoProvider) Lockfiles() []string { return []string{"Cargo.lock"} }
+func (cargoProvider) SyncDir() string     { return "cargo/project" }
+func (cargoProvider) Image() string       { return "rust:1-slim" }
+
+func (cargoProvider) SyncFiles(lockfile string) []string {
+	return []string{"Cargo.toml", "Cargo.lock"}
+}
+
+func (cargoProvider) Env() []string {
+	return []string{"CARGO_HOME=" + ContainerMount + "/cargo"}
+}
+
+func (cargoProvider) SetupScript() string {
+	script := `
+# cargo: seed the container registry cache with crates from the shared layers
+mkdir -p %s/cargo/registry/cache
+for layer in %s %s; do
+    [ -d "$layer/cargo/registry/cache" ] || continue
+    for crate in "$layer"/cargo/registry/cache/*/*.crate; do
+        [ -f "$crate" ] || continue
+        crate_name=$(basename "$crate")
+        if is_override "${crate_name%%-[0-9]*}"; then
+            continue
+        fi
+        index_dir=%s/cargo/registry/cache/$(basename "$(dirname "$crate")")
+        mkdir -p "$index_dir"
+        ln -sfn "$crate" "$index_dir/$crate_name"
+    done
+done
+`
+	return fmt.Sprintf(script, ContainerMount, CoreMount, TeamMount, ContainerMount)
+}
+
+func (cargoProvider) InstallCommand(name, version string) string {
+	spec := name
+	if version != "" {
+		spec += "@" + version
+	}
+	return `mkdir -p /tmp/fetch/src && cd /tmp/fetch && touch src/lib.rs && ` +
+		`printf '[package]\nname = "capsulate-fetch"\nversion = "0.0.0"\nedition = "2021"\n' > Cargo.toml && ` +
+		"CARGO_HOME=/deps/cargo cargo add " + quote(spec) + " && CARGO_HOME=/deps/cargo cargo fetch"
+}
+
+// OverrideCommand patches crates.io with a local path through CARGO_HOME/config.toml.
+// Version pins are applied to the repository's Cargo.lock with cargo update --precise.
+func (cargoProvider) OverrideCommand(o Override) string {
+	switch {
+	case o.Path != "":
+		line := fmt.Sprintf("patch.crates-io.%s.path = %q", o.Name, o.Path)
+		return fmt.Sprintf("mkdir -p %s/cargo && printf '%%s\\n' %s >> %s/cargo/config.toml",
+			ContainerMount, quote(line), ContainerMount)
+	case o.Version != "":
+		return "cd /workspace/repo && cargo update -p " + quote(o.Name) + " --precise " + quote(o.Version)
+	}
+	return "cd /workspace/repo && cargo update -p " + quote(o.Name)
+}
+
+func (cargoProvider) SyncCommand(lockfile string) string {
+	return "cd /deps/cargo/project && mkdir -p src && touch src/lib.rs && CARGO_HOME=/deps/cargo cargo fetch --locked"
+}
+
+// Packages lists the <name>-<version>.crate files in <layer>/cargo/registry/cache
+func (cargoProvider) Packages(layerDir string) (map[string]string, error) {
+	packages := make(map[string]string)
+	crates, err := filepath.Glob(filepath.Join(layerDir, "cargo", "registry", "cache", "*", "*.crate"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cargo registry cache: %v", err)
+	}
+	for _, crate := range crates {
+		if _, err := os.Stat(crate); err != nil {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(crate), ".crate")
+		// Crate names may contain dashes; the version starts at the last dash followed by a digit
+		for i := len(name) - 1; i > 0; i-- {
+			if name[i] == '-' && i+1 < len(name) && name[i+1] >= '0' && name[i+1] <= '9' {
+				if current, ok := packages[name[:i]]; !ok || compareVersions(current, name[i+1:]) < 0 {
+					packages[name[:i]] = name[i+1:]
+				}
+				break
+			}
+		}
+	}
+	return packages, nil
+}
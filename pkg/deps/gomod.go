@@ -0,0 +1,120 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// goProvider layers Go modules through GOPROXY: the core and team module caches are
+// served as read-only file proxies, while the container layer is the writable GOMODCACHE
+type goProvider struct{}
+
+func init() {
+	register(goProvider{})
+}
+
+func (goProvider) Name() string        { return "go" }
+func (goProvider) Markers() []string   { return []string{"go.mod"} }
+func (goProvider) Lockfiles() []string { return []string{"go.sum", "go.mod"} }
+func (goProvider) SyncDir() string     { return "go" }
+func (goProvider) Image() string       { return "golang:1.22" }
+
+func (goProvider) SyncFiles(lockfile string) []string {
+	return []string{"go.mod", "go.sum"}
+}
+
+func (goProvider) Env() []string {
+	return []string{
+		"GOMODCACHE=" + ContainerMount + "/go/mod",
+		"GOFLAGS=-modcacherw",
+		fmt.Sprintf("GOPROXY=file://%s/go/mod/cache/download,file://%s/go/mod/cache/download,https://proxy.golang.org,direct",
+			TeamMount, CoreMount),
+	}
+}
+
+func (goProvider) SetupScript() string {
+	// Overrides need no linking: modules resolve from the container cache first
+	return fmt.Sprintf("\n# go: modules are layered through GOPROXY\nmkdir -p %s/go/mod\n", ContainerMount)
+}
+
+func (goProvider) InstallCommand(name, version string) string {
+	if version == "" {
+		version = "latest"
+	}
+	return "mkdir -p /deps/go && cd /deps/go && GOMODCACHE=/deps/go/mod GOFLAGS=-modcacherw go mod download " +
+		quote(name+"@"+version)
+}
+
+// OverrideCommand adds a replace directive to /workspace/go.work, so the override
+// applies to the repository without modifying its go.mod
+func (goProvider) OverrideCommand(o Override) string {
+	workspace := "cd /workspace && { [ -f go.work ] || go work init ./repo; }"
+	switch {
+	case o.Path != "":
+		return workspace + " && go work edit -replace=" + quote(o.Name+"="+o.Path)
+	case o.Version != "":
+		return workspace + " && go work edit -replace=" + quote(o.Name+"="+o.Name+"@"+o.Version) +
+			" && cd /workspace/repo && go mod download " + quote(o.Name+"@"+o.Version)
+	}
+	return workspace + " && version=$(go list -m -f '{{.Version}}' " + quote(o.Name+"@latest") + ")" +
+		" && go work edit -replace=" + quote(o.Name+"="+o.Name) + "@$version" +
+		" && cd /workspace/repo && go mod download " + quote(o.Name) + "@$version"
+}
+
+func (goProvider) SyncCommand(lockfile string) string {
+	return "cd /deps/go && GOMODCACHE=/deps/go/mod GOFLAGS=-modcacherw go mod download all"
+}
+
+// Packages lists the module versions in <layer>/go/mod/cache/download
+func (goProvider) Packages(layerDir string) (map[string]string, error) {
+	packages := make(map[string]string)
+	root := filepath.Join(layerDir, "go", "mod", "cache", "download")
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return packages, nil
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".info" {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		// <escaped module path>/@v/<version>.info
+		dir, file := filepath.Split(filepath.ToSlash(rel))
+		if !strings.HasSuffix(dir, "/@v/") {
+			return nil
+		}
+		module := unescapeModulePath(strings.TrimSuffix(dir, "/@v/"))
+		version := strings.TrimSuffix(file, ".info")
+		if current, ok := packages[module]; !ok || compareVersions(current, version) < 0 {
+			packages[module] = version
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read module cache %s: %v", root, err)
+	}
+	return packages, nil
+}
+
+// unescapeModulePath reverses the module cache's case encoding ("!x" for "X")
+func unescapeModulePath(escaped string) string {
+	var b strings.Builder
+	upper := false
+	for _, r := range escaped {
+		if r == '!' {
+			upper = true
+			continue
+		}
+		if upper {
+			r = r - 'a' + 'A'
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
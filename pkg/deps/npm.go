@@ -0,0 +1,144 @@
+package deps
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// npmProvider layers node packages by symlinking each layer's packages into
+// /workspace/node_modules, with later layers (team, container) taking precedence
+type npmProvider struct{}
+
+func init() {
+	register(npmProvider{})
+}
+
+func (npmProvider) Name() string        { return "npm" }
+func (npmProvider) Markers() []string   { return []string{"package.json"} }
+func (npmProvider) Lockfiles() []string { return []string{"package-lock.json"} }
+func (npmProvider) SyncDir() string     { return "." }
+func (npmProvider) Image() string       { return "node:20-bookworm-slim" }
+func (npmProvider) Env() []string       { return nil }
+
+func (npmProvider) SyncFiles(lockfile string) []string {
+	return []string{"package.json", "package-lock.json"}
+}
+
+func (npmProvider) SetupScript() string {
+	script := `
+# npm: link layer packages into /workspace/node_modules. Layers populated by npm keep
+# packages under <layer>/node_modules; plain package directories are linked too.
+mkdir -p /workspace/node_modules
+link_npm_layer() {
+    for pkg in "$1"/node_modules/* "$1"/node_modules/@*/* "$1"/*; do
+        [ -d "$pkg" ] || continue
+        pkg_name=${pkg#"$1"/}
+        pkg_name=${pkg_name#node_modules/}
+        case "$pkg_name" in
+            node_modules|go|python|cargo|.*) continue ;;
+            @*/*) ;;
+            @*) continue ;;
+        esac
+        # Don't link if it's in the override list
+        if [ "$2" = "skip-overrides" ] && is_override "$pkg_name"; then
+            continue
+        fi
+        mkdir -p "$(dirname "/workspace/node_modules/$pkg_name")"
+        ln -sfn "$pkg" "/workspace/node_modules/$pkg_name"
+    done
+}
+[ -d %s ] && link_npm_layer %s skip-overrides
+[ "$DEPENDENCY_LEVEL" = "team" ] && [ -d %s ] && link_npm_layer %s skip-overrides
+[ -d %s ] && link_npm_layer %s
+`
+	return fmt.Sprintf(script, CoreMount, CoreMount, TeamMount, TeamMount, ContainerMount, ContainerMount)
+}
+
+// npmInit creates a minimal package.json so npm installs into ./node_modules
+// instead of searching parent directories
+const npmInit = `{ [ -f package.json ] || printf '{\n  "name": "capsulate-deps",\n  "private": true\n}\n' > package.json; }`
+
+func (npmProvider) InstallCommand(name, version string) string {
+	spec := name
+	if version != "" {
+		spec += "@" + version
+	}
+	return npmInit + " && npm install --no-audit --no-fund --save-exact " + quote(spec)
+}
+
+func (npmProvider) OverrideCommand(o Override) string {
+	target := o.String()
+	if o.Path != "" {
+		target = o.Path
+	}
+	return fmt.Sprintf("mkdir -p %s && cd %s && %s && npm install --no-audit --no-fund --save-exact %s",
+		ContainerMount, ContainerMount, npmInit, quote(target))
+}
+
+func (npmProvider) SyncCommand(lockfile string) string {
+	return "if [ -f package-lock.json ]; then npm ci --no-audit --no-fund; else npm install --no-audit --no-fund; fi"
+}
+
+// Packages reads the versions of the packages under <layer>/node_modules, and of
+// plain package directories at the layer root (as created by add-dep and add-team-dep)
+func (npmProvider) Packages(layerDir string) (map[string]string, error) {
+	packages := make(map[string]string)
+	for _, root := range []string{layerDir, filepath.Join(layerDir, "node_modules")} {
+		entries, err := os.ReadDir(root)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", root, err)
+		}
+
+		var names []string
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			if root == layerDir && (name == "node_modules" || name == "go" || name == "python" || name == "cargo") {
+				continue
+			}
+			if strings.HasPrefix(name, "@") {
+				scoped, err := os.ReadDir(filepath.Join(root, name))
+				if err != nil {
+					continue
+				}
+				for _, s := range scoped {
+					names = append(names, name+"/"+s.Name())
+				}
+				continue
+			}
+			names = append(names, name)
+		}
+
+		for _, name := range names {
+			if version, ok := npmPackageVersion(filepath.Join(root, name)); ok {
+				packages[name] = version
+			}
+		}
+	}
+	return packages, nil
+}
+
+// npmPackageVersion reads a package version from package.json, or from the plain
+// version file written for stub packages
+func npmPackageVersion(dir string) (string, bool) {
+	if data, err := os.ReadFile(filepath.Join(dir, "package.json")); err == nil {
+		var pkg struct {
+			Version string `json:"version"`
+		}
+		if json.Unmarshal(data, &pkg) == nil {
+			return pkg.Version, true
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "version")); err == nil {
+		return strings.TrimSpace(string(data)), true
+	}
+	return "", false
+}
@@ -0,0 +1,92 @@
+package deps
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pipProvider layers Python packages through PYTHONPATH: the container layer comes
+// first and is where pip installs inside the agent, followed by the team and core layers
+type pipProvider struct{}
+
+func init() {
+	register(pipProvider{})
+}
+
+func (pipProvider) Name() string    { return "pip" }
+func (pipProvider) SyncDir() string { return "python" }
+func (pipProvider) Image() string   { return "python:3.12-slim" }
+func (pipProvider) Markers() []string {
+	return []string{"requirements.txt", "pyproject.toml", "setup.py"}
+}
+
+func (pipProvider) Lockfiles() []string {
+	return []string{"requirements.txt", "requirements.lock"}
+}
+
+func (pipProvider) SyncFiles(lockfile string) []string {
+	return []string{filepath.Base(lockfile)}
+}
+
+func (pipProvider) Env() []string {
+	return []string{
+		fmt.Sprintf("PYTHONPATH=%s/python:%s/python:%s/python", ContainerMount, TeamMount, CoreMount),
+		"PIP_TARGET=" + ContainerMount + "/python",
+	}
+}
+
+func (pipProvider) SetupScript() string {
+	return fmt.Sprintf("\n# pip: packages are layered through PYTHONPATH\nmkdir -p %s/python\n", ContainerMount)
+}
+
+func (pipProvider) InstallCommand(name, version string) string {
+	spec := name
+	if version != "" {
+		spec += "==" + version
+	}
+	return "pip install --no-cache-dir --upgrade --target /deps/python " + quote(spec)
+}
+
+func (pipProvider) OverrideCommand(o Override) string {
+	target := o.Name
+	switch {
+	case o.Path != "":
+		target = o.Path
+	case o.Version != "":
+		target = o.Name + "==" + o.Version
+	}
+	return fmt.Sprintf("pip install --no-cache-dir --upgrade --target %s/python %s", ContainerMount, quote(target))
+}
+
+func (pipProvider) SyncCommand(lockfile string) string {
+	if lockfile == "" {
+		lockfile = "requirements.txt"
+	}
+	return "pip install --no-cache-dir --upgrade --target /deps/python -r " + quote("/deps/python/"+filepath.Base(lockfile))
+}
+
+// Packages reads the <name>-<version>.dist-info directories in <layer>/python
+func (pipProvider) Packages(layerDir string) (map[string]string, error) {
+	packages := make(map[string]string)
+	root := filepath.Join(layerDir, "python")
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return packages, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", root, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() || !strings.HasSuffix(name, ".dist-info") {
+			continue
+		}
+		name = strings.TrimSuffix(name, ".dist-info")
+		if i := strings.LastIndex(name, "-"); i > 0 {
+			packages[name[:i]] = name[i+1:]
+		}
+	}
+	return packages, nil
+}
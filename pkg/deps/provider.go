@@ -0,0 +1,201 @@
+package deps
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Mount points of the dependency layers inside agent containers
+const (
+	CoreMount      = "/workspace/core-deps"
+	TeamMount      = "/workspace/team-deps"
+	ContainerMount = "/workspace/container-deps"
+)
+
+// Provider wires one package manager's dependencies into the core/team/container
+// layers. Each provider keeps its files in its own subdirectory of a layer.
+type Provider interface {
+	// Name is the identifier used in capsulate.yaml and on the command line
+	Name() string
+	// Markers are repository files that indicate the project uses this provider
+	Markers() []string
+	// Lockfiles are the file names SyncCommand accepts
+	Lockfiles() []string
+	// SyncFiles returns the files copied from the lockfile's directory into SyncDir
+	SyncFiles(lockfile string) []string
+	// SyncDir is the layer subdirectory that receives the synced files
+	SyncDir() string
+	// Image is the container image used to install packages into shared layers
+	Image() string
+	// Env returns the environment variables that layer dependencies inside an agent
+	Env() []string
+	// SetupScript returns the shell fragment run in the agent to link the layers. It
+	// follows SetupPreamble, and leaves the packages is_override accepts for the
+	// container layer.
+	SetupScript() string
+	// InstallCommand installs name (at version, if set) into the layer mounted at /deps
+	InstallCommand(name, version string) string
+	// SyncCommand installs exactly what the synced files pin into the layer mounted at /deps.
+	// An empty lockfile reinstalls from the files already in the layer.
+	SyncCommand(lockfile string) string
+	// OverrideCommand installs an override into the container layer from inside the agent
+	OverrideCommand(o Override) string
+	// Packages returns the packages installed in a layer directory on the host,
+	// keyed by name with their versions
+	Packages(layerDir string) (map[string]string, error)
+}
+
+// Override is a container-level dependency from --override-deps, pinned to a version
+// ("name@version") or built from a local path inside the agent ("name=path"). A
+// "provider:" prefix selects the provider when several are active.
+type Override struct {
+	Provider string `json:"provider,omitempty"`
+	Name     string `json:"name"`
+	Version  string `json:"version,omitempty"`
+	Path     string `json:"path,omitempty"`
+}
+
+// overrideName matches the package names overrides accept: npm packages, optionally
+// scoped, Go module paths, Python packages and crates. The names end up in shell
+// scripts and package manager arguments and may not start with '-'.
+var overrideName = regexp.MustCompile(`^@?[A-Za-z0-9][A-Za-z0-9._~-]*(/[A-Za-z0-9][A-Za-z0-9._~-]*)*$`)
+
+// ParseOverride parses an --override-deps entry
+func ParseOverride(spec string) (Override, error) {
+	var o Override
+	rest := strings.TrimSpace(spec)
+	if i := strings.Index(rest, ":"); i > 0 {
+		if _, ok := registry[rest[:i]]; ok {
+			o.Provider, rest = rest[:i], rest[i+1:]
+		}
+	}
+
+	if name, path, ok := strings.Cut(rest, "="); ok {
+		if path == "" {
+			return o, fmt.Errorf("override '%s' has an empty path", spec)
+		}
+		o.Name, o.Path = name, path
+	} else if i := strings.LastIndex(rest, "@"); i > 0 {
+		o.Name, o.Version = rest[:i], rest[i+1:]
+	} else {
+		o.Name = rest
+	}
+	if o.Name == "" {
+		return o, fmt.Errorf("invalid override '%s'", spec)
+	}
+	if !overrideName.MatchString(o.Name) {
+		return o, fmt.Errorf("invalid override '%s': '%s' is not a package name", spec, o.Name)
+	}
+	return o, nil
+}
+
+// SetupPreamble returns the start of a dependency setup script. It exports the names
+// of the overrides in OVERRIDE_NAMES, one per line, and defines is_override, which
+// reports whether its argument is one of them. Names are compared literally.
+func SetupPreamble(overrides []string) string {
+	return "#!/bin/bash\nexport OVERRIDE_NAMES=" + quote(strings.Join(overrides, "\n")) + `
+is_override() {
+    local name
+    while IFS= read -r name; do
+        [ -n "$name" ] && [ "$name" = "$1" ] && return 0
+    done <<< "$OVERRIDE_NAMES"
+    return 1
+}
+`
+}
+
+// String returns the override in --override-deps syntax, without the provider prefix
+func (o Override) String() string {
+	switch {
+	case o.Path != "":
+		return o.Name + "=" + o.Path
+	case o.Version != "":
+		return o.Name + "@" + o.Version
+	}
+	return o.Name
+}
+
+var registry = map[string]Provider{}
+
+// register adds a provider to the registry; called from the providers' init functions
+func register(p Provider) {
+	registry[p.Name()] = p
+}
+
+// Get returns the provider with the given name
+func Get(name string) (Provider, error) {
+	p, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown dependency provider '%s' (available: %s)", name, strings.Join(Names(), ", "))
+	}
+	return p, nil
+}
+
+// Names returns the names of all registered providers in sorted order
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Detect returns the providers whose marker files exist according to exists,
+// which is called with paths relative to the repository root
+func Detect(exists func(path string) bool) []Provider {
+	var detected []Provider
+	for _, name := range Names() {
+		p := registry[name]
+		for _, marker := range p.Markers() {
+			if exists(marker) {
+				detected = append(detected, p)
+				break
+			}
+		}
+	}
+	return detected
+}
+
+// ForLockfile returns the provider that understands the given lockfile
+func ForLockfile(lockfile string) (Provider, error) {
+	base := filepath.Base(lockfile)
+	for _, name := range Names() {
+		for _, candidate := range registry[name].Lockfiles() {
+			if candidate == base {
+				return registry[name], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("unsupported lockfile %s", lockfile)
+}
+
+// compareVersions orders dotted version strings numerically where possible,
+// ignoring a leading "v" (v1.10.0 sorts after v1.9.0)
+func compareVersions(a, b string) int {
+	as := strings.FieldsFunc(strings.TrimPrefix(a, "v"), func(r rune) bool { return r == '.' || r == '-' || r == '+' })
+	bs := strings.FieldsFunc(strings.TrimPrefix(b, "v"), func(r rune) bool { return r == '.' || r == '-' || r == '+' })
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil && an != bn:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case (aErr != nil || bErr != nil) && as[i] != bs[i]:
+			return strings.Compare(as[i], bs[i])
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// quote single-quotes a string for use in a shell command
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,190 @@
+// Package diskusage measures the disk space taken by git-capsulate's storage, by
+// category and by agent, so that operators can see what to prune.
+package diskusage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Categories of storage
+const (
+	Workspaces   = "workspaces"    // agents' repositories
+	OverlayDiffs = "overlay-diffs" // agents' overlay upper and work directories
+	OverlayBase  = "overlay-base"  // base repository shared by overlay agents
+	Dependencies = "dependencies"  // core, team and per-agent dependency layers
+	CloneCache   = "clone-cache"   // repository mirrors of cache.clone
+	Artifacts    = "artifacts"     // stored output and artifacts of check runs
+	State        = "state"         // agent state and schedules
+	Traces       = "traces"        // trace files, outside the workspace
+	Metrics      = "metrics"       // metrics files, outside the workspace
+)
+
+// categories lists the categories in the order they are reported
+var categories = []string{
+	Workspaces, OverlayDiffs, OverlayBase, Dependencies, CloneCache, Artifacts, State, Traces, Metrics,
+}
+
+// agentDirs are the directories of a project's data directory holding one
+// subdirectory per agent, with the category they count towards
+var agentDirs = []struct {
+	path     string
+	category string
+}{
+	{"workspaces", Workspaces},
+	{"overlay/diffs", OverlayDiffs},
+	{"overlay/work", OverlayDiffs},
+	{"dependencies/container", Dependencies},
+	{"artifacts", Artifacts},
+}
+
+// projectDirs are the directories of a project's data directory not split by agent
+var projectDirs = map[string]string{
+	"state":     State,
+	"schedules": State,
+}
+
+// sharedDirs are the directories under .capsulate shared by all projects
+var sharedDirs = map[string]string{
+	"overlay/base":                OverlayBase,
+	"dependencies/core":           Dependencies,
+	"dependencies/team":           Dependencies,
+	"dependencies/team-snapshots": Dependencies,
+	"cache/repos":                 CloneCache,
+}
+
+// Category is the space taken by one category of storage
+type Category struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size_bytes"`
+	Files int    `json:"files"`
+}
+
+// Agent is the space taken by one agent, split by category
+type Agent struct {
+	Project    string           `json:"project,omitempty"`
+	ID         string           `json:"id"`
+	Size       int64            `json:"size_bytes"`
+	Categories map[string]int64 `json:"categories"`
+	// Orphaned agents have storage left but no recorded state, e.g. after an
+	// interrupted destroy
+	Orphaned bool `json:"orphaned,omitempty"`
+}
+
+// Report is the disk usage of a workspace's capsulate storage
+type Report struct {
+	Categories []Category `json:"categories"`
+	Agents     []Agent    `json:"agents"`
+	Size       int64      `json:"size_bytes"`
+}
+
+// Measure walks the storage of a workspace, for every project, along with the traces
+// and metrics directories. Sizes are the apparent sizes of regular files; files that
+// cannot be read, e.g. written as root by containers, are skipped.
+func Measure(workspaceDir string) (*Report, error) {
+	projects, err := config.Projects(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]*Category, len(categories))
+	for _, name := range categories {
+		totals[name] = &Category{Name: name}
+	}
+	add := func(category string, size int64, files int) {
+		totals[category].Size += size
+		totals[category].Files += files
+	}
+
+	report := &Report{}
+	for _, project := range projects {
+		dataDir := config.DataDir(workspaceDir, project)
+		for path, category := range projectDirs {
+			size, files := walk(filepath.Join(dataDir, filepath.FromSlash(path)))
+			add(category, size, files)
+		}
+
+		agents := make(map[string]*Agent)
+		for _, dir := range agentDirs {
+			root := filepath.Join(dataDir, filepath.FromSlash(dir.path))
+			entries, err := os.ReadDir(root)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("failed to read %s: %v", root, err)
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					continue
+				}
+				size, files := walk(filepath.Join(root, entry.Name()))
+				add(dir.category, size, files)
+
+				agent, ok := agents[entry.Name()]
+				if !ok {
+					agent = &Agent{Project: project, ID: entry.Name(), Categories: make(map[string]int64)}
+					agents[entry.Name()] = agent
+				}
+				agent.Categories[dir.category] += size
+				agent.Size += size
+			}
+		}
+		for id, agent := range agents {
+			if _, err := os.Stat(filepath.Join(dataDir, "state", "agents", id+".json")); os.IsNotExist(err) {
+				agent.Orphaned = true
+			}
+			report.Agents = append(report.Agents, *agent)
+		}
+	}
+
+	root := filepath.Join(workspaceDir, ".capsulate")
+	for path, category := range sharedDirs {
+		size, files := walk(filepath.Join(root, filepath.FromSlash(path)))
+		add(category, size, files)
+	}
+	size, files := walk(tracing.Dir())
+	add(Traces, size, files)
+	size, files = walk(metrics.Dir())
+	add(Metrics, size, files)
+
+	for _, name := range categories {
+		report.Categories = append(report.Categories, *totals[name])
+		report.Size += totals[name].Size
+	}
+	// Largest agents first, as the likeliest to prune
+	sort.Slice(report.Agents, func(i, j int) bool {
+		if report.Agents[i].Size != report.Agents[j].Size {
+			return report.Agents[i].Size > report.Agents[j].Size
+		}
+		return AgentName(report.Agents[i]) < AgentName(report.Agents[j])
+	})
+	return report, nil
+}
+
+// AgentName returns the name an agent is reported under: its ID, prefixed with its
+// project outside the default one
+func AgentName(agent Agent) string {
+	if agent.Project == "" {
+		return agent.ID
+	}
+	return agent.Project + "/" + agent.ID
+}
+
+// walk returns the total size and count of the regular files under a directory,
+// zero when it does not exist
+func walk(dir string) (int64, int) {
+	var size int64
+	var files int
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+			files++
+		}
+		return nil
+	})
+	return size, files
+}
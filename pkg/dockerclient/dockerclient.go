@@ -0,0 +1,155 @@
+package dockerclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+)
+
+// MinAPIVersion is the oldest Docker Engine API version git-capsulate supports
+// (Docker 19.03)
+const MinAPIVersion = "1.40"
+
+// probeTimeout bounds how long Probe waits for the daemon to answer
+const probeTimeout = 10 * time.Second
+
+// Feature is a capability git-capsulate needs from the Docker daemon
+type Feature struct {
+	Name             string
+	MinAPIVersion    string
+	MinDockerVersion string
+	LinuxOnly        bool // requires a daemon running Linux containers
+}
+
+// Features checked against the daemon
+var (
+	// Core covers the containers, exec sessions and archive copies every command uses
+	Core = Feature{Name: "git-capsulate", MinAPIVersion: MinAPIVersion, MinDockerVersion: "19.03"}
+	// Overlay is the OverlayFS workspace mounted inside agent containers
+	Overlay = Feature{Name: "overlay workspaces", MinAPIVersion: MinAPIVersion, MinDockerVersion: "19.03", LinuxOnly: true}
+	// CgroupV2Stats is container resource monitoring, which reports wrong values on
+	// cgroup v2 hosts before Docker 20.10
+	CgroupV2Stats = Feature{Name: "cgroup v2 container stats", MinAPIVersion: "1.41", MinDockerVersion: "20.10", LinuxOnly: true}
+)
+
+// UnsupportedError is returned when the daemon cannot provide a feature
+type UnsupportedError struct {
+	Feature    Feature
+	APIVersion string // API version reported by the daemon
+	OSType     string // OS of the daemon's containers
+}
+
+func (e *UnsupportedError) Error() string {
+	if e.Feature.LinuxOnly && e.OSType != "" && e.OSType != "linux" {
+		return fmt.Sprintf("a Docker daemon running Linux containers is required for %s (daemon runs %s containers)", e.Feature.Name, e.OSType)
+	}
+	return fmt.Sprintf("Docker %s (API %s) or newer is required for %s, but the daemon supports API %s",
+		e.Feature.MinDockerVersion, e.Feature.MinAPIVersion, e.Feature.Name, e.APIVersion)
+}
+
+// New creates a Docker client configured from the environment that negotiates the
+// API version with the daemon, so older daemons are addressed at a version they accept
+func New() (*client.Client, error) {
+	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	return dockerClient, nil
+}
+
+// Probe pings the daemon, negotiates the API version and checks that the daemon
+// supports the Core feature. The ping is returned for later Require checks.
+func Probe(ctx context.Context, dockerClient *client.Client) (types.Ping, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	ping, err := dockerClient.Ping(ctx)
+	if err != nil {
+		return ping, fmt.Errorf("failed to connect to the Docker daemon: %w", err)
+	}
+	dockerClient.NegotiateAPIVersionPing(ping)
+
+	return ping, Require(ping, Core)
+}
+
+// Require checks a probed daemon against the requirements of a feature
+func Require(ping types.Ping, feature Feature) error {
+	unsupported := &UnsupportedError{Feature: feature, APIVersion: ping.APIVersion, OSType: ping.OSType}
+	if feature.LinuxOnly && ping.OSType != "" && ping.OSType != "linux" {
+		return unsupported
+	}
+	if ping.APIVersion != "" && versions.LessThan(ping.APIVersion, feature.MinAPIVersion) {
+		return unsupported
+	}
+	return nil
+}
+
+// Version describes the Docker daemon and the API version negotiated with it
+type Version struct {
+	ClientAPIVersion string `json:"client_api_version"` // API version the CLI was built for
+	APIVersion       string `json:"api_version"`        // API version negotiated with the daemon
+	ServerVersion    string `json:"server_version"`
+	ServerAPIVersion string `json:"server_api_version"`
+	MinAPIVersion    string `json:"server_min_api_version,omitempty"`
+	OS               string `json:"os"`
+	Arch             string `json:"arch"`
+}
+
+// GetVersion connects to the Docker daemon configured in the environment,
+// negotiates the API version and reports the daemon's versions
+func GetVersion(ctx context.Context) (*Version, error) {
+	dockerClient, err := New()
+	if err != nil {
+		return nil, err
+	}
+	defer dockerClient.Close()
+
+	clientAPIVersion := dockerClient.ClientVersion()
+	dockerClient.NegotiateAPIVersion(ctx)
+
+	server, err := dockerClient.ServerVersion(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Docker version: %w", err)
+	}
+
+	return &Version{
+		ClientAPIVersion: clientAPIVersion,
+		APIVersion:       dockerClient.ClientVersion(),
+		ServerVersion:    server.Version,
+		ServerAPIVersion: server.APIVersion,
+		MinAPIVersion:    server.MinAPIVersion,
+		OS:               server.Os,
+		Arch:             server.Arch,
+	}, nil
+}
+
+// CompatibilityWarnings reports where the daemon and the CLI diverge: a daemon older
+// than git-capsulate supports, a daemon the CLI cannot talk to at its own API version,
+// or one newer than the CLI knows about
+func (v *Version) CompatibilityWarnings() []string {
+	var warnings []string
+	switch {
+	case versions.LessThan(v.ServerAPIVersion, MinAPIVersion):
+		warnings = append(warnings, fmt.Sprintf("Docker daemon %s (API %s) is older than the minimum supported API %s (Docker %s)",
+			v.ServerVersion, v.ServerAPIVersion, MinAPIVersion, Core.MinDockerVersion))
+	case versions.LessThan(v.ServerAPIVersion, v.ClientAPIVersion):
+		warnings = append(warnings, fmt.Sprintf("Docker daemon API %s is older than the CLI's API %s; requests are downgraded to %s",
+			v.ServerAPIVersion, v.ClientAPIVersion, v.APIVersion))
+	case versions.GreaterThan(v.ServerAPIVersion, v.ClientAPIVersion):
+		warnings = append(warnings, fmt.Sprintf("Docker daemon API %s is newer than the CLI's API %s; consider upgrading git-capsulate",
+			v.ServerAPIVersion, v.ClientAPIVersion))
+	}
+	if v.MinAPIVersion != "" && versions.LessThan(v.ClientAPIVersion, v.MinAPIVersion) {
+		warnings = append(warnings, fmt.Sprintf("Docker daemon requires API %s or newer but the CLI speaks %s",
+			v.MinAPIVersion, v.ClientAPIVersion))
+	}
+	if versions.LessThan(v.ServerAPIVersion, CgroupV2Stats.MinAPIVersion) {
+		warnings = append(warnings, fmt.Sprintf("Docker %s (API %s) or newer is required for %s",
+			CgroupV2Stats.MinDockerVersion, CgroupV2Stats.MinAPIVersion, CgroupV2Stats.Name))
+	}
+	return warnings
+}
@@ -0,0 +1,157 @@
+package dockerclient
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+)
+
+// ContainerIDEnv names the container git-capsulate runs in when it cannot be detected,
+// e.g. when the container's hostname was changed
+const ContainerIDEnv = "CAPSULATE_CONTAINER_ID"
+
+// Ways of reaching a Docker daemon from inside a container
+const (
+	// ModeHost is git-capsulate running directly on the daemon's host
+	ModeHost = "host"
+	// ModeSocket is the host's daemon reached through its mounted socket (Docker out of
+	// Docker). Bind mount sources are paths on the host, not in this container.
+	ModeSocket = "socket"
+	// ModeDinD is a daemon of its own, running in this container or next to it (Docker
+	// in Docker). Bind mount sources are paths in the daemon's file system.
+	ModeDinD = "dind"
+)
+
+// PathMapping is a bind mount of the container git-capsulate runs in
+type PathMapping struct {
+	ContainerPath string `json:"container_path"`
+	HostPath      string `json:"host_path"`
+}
+
+// Environment describes where git-capsulate runs relative to the Docker daemon
+type Environment struct {
+	InContainer bool   `json:"in_container"`
+	Mode        string `json:"mode"`
+	// ContainerID is the container git-capsulate runs in, when the daemon knows it
+	ContainerID string `json:"container_id,omitempty"`
+	// DaemonHost is the address of the daemon, a unix socket by default
+	DaemonHost string `json:"daemon_host"`
+	// Mappings are the mounts of the container by container path, longest first, used
+	// to translate paths for the daemon in socket mode
+	Mappings []PathMapping `json:"mappings,omitempty"`
+}
+
+// Detect finds out whether git-capsulate runs in a container and, if so, how the
+// daemon is reached. When the daemon knows the container it is the host's daemon,
+// reached through a mounted socket, and the container's mounts are read to
+// translate paths; otherwise the daemon is a Docker-in-Docker daemon that sees the
+// same paths as git-capsulate.
+func Detect(ctx context.Context, dockerClient *client.Client) (*Environment, error) {
+	env := &Environment{Mode: ModeHost, DaemonHost: dockerClient.DaemonHost()}
+	if !InContainer() {
+		return env, nil
+	}
+	env.InContainer = true
+	env.Mode = ModeDinD
+
+	for _, id := range containerIDCandidates() {
+		info, err := dockerClient.ContainerInspect(ctx, id)
+		if errdefs.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect the container git-capsulate runs in: %w", err)
+		}
+		env.Mode = ModeSocket
+		env.ContainerID = info.ID
+		for _, m := range info.Mounts {
+			if m.Source != "" && m.Destination != "" {
+				env.Mappings = append(env.Mappings, PathMapping{ContainerPath: m.Destination, HostPath: m.Source})
+			}
+		}
+		sort.Slice(env.Mappings, func(i, j int) bool {
+			return len(env.Mappings[i].ContainerPath) > len(env.Mappings[j].ContainerPath)
+		})
+		break
+	}
+	return env, nil
+}
+
+// HostPath translates a path of this container into the path the daemon mounts. In
+// socket mode the path must lie in one of the container's mounts, since the host's
+// daemon cannot see the container's own file system.
+func (e *Environment) HostPath(path string) (string, error) {
+	if e == nil || e.Mode != ModeSocket {
+		return path, nil
+	}
+	path = filepath.Clean(path)
+	for _, mapping := range e.Mappings {
+		rel, err := filepath.Rel(mapping.ContainerPath, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			continue
+		}
+		return filepath.Join(mapping.HostPath, rel), nil
+	}
+	return "", fmt.Errorf("%s is not on a volume or bind mount of container %.12s, so the Docker daemon of the host cannot mount it; "+
+		"mount it into the container, see 'git-capsulate doctor --in-container'", path, e.ContainerID)
+}
+
+// InContainer reports whether the process runs in a container, from the marker files
+// of Docker and Podman, Kubernetes' environment and the cgroup of the init process
+func InContainer() bool {
+	for _, marker := range []string{"/.dockerenv", "/run/.containerenv"} {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" || os.Getenv(ContainerIDEnv) != "" {
+		return true
+	}
+	data, err := os.ReadFile("/proc/1/cgroup")
+	if err != nil {
+		return false
+	}
+	content := string(data)
+	for _, runtime := range []string{"docker", "kubepods", "containerd", "libpod"} {
+		if strings.Contains(content, runtime) {
+			return true
+		}
+	}
+	return false
+}
+
+// containerIDPattern matches the container IDs in the paths Docker mounts into
+// containers, e.g. /var/lib/docker/containers/<id>/hostname
+var containerIDPattern = regexp.MustCompile(`/containers/([0-9a-f]{64})/`)
+
+// containerIDCandidates returns the IDs this container may have: the one configured in
+// the environment, the one in the sources of Docker's mounts and the hostname, which
+// defaults to the short container ID
+func containerIDCandidates() []string {
+	var ids []string
+	if id := os.Getenv(ContainerIDEnv); id != "" {
+		ids = append(ids, id)
+	}
+	if file, err := os.Open("/proc/self/mountinfo"); err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if match := containerIDPattern.FindStringSubmatch(scanner.Text()); match != nil {
+				ids = append(ids, match[1])
+				break
+			}
+		}
+		file.Close()
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		ids = append(ids, hostname)
+	}
+	return ids
+}
@@ -0,0 +1,67 @@
+package dockerclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Platforms agents can run on
+const (
+	PlatformAMD64 = "linux/amd64"
+	PlatformARM64 = "linux/arm64"
+)
+
+// Platforms lists the supported platforms
+var Platforms = []string{PlatformAMD64, PlatformARM64}
+
+// platformAliases maps the architecture names in use to the supported platforms
+var platformAliases = map[string]string{
+	"amd64":          PlatformAMD64,
+	"x86_64":         PlatformAMD64,
+	"linux/amd64":    PlatformAMD64,
+	"linux/x86_64":   PlatformAMD64,
+	"arm64":          PlatformARM64,
+	"aarch64":        PlatformARM64,
+	"linux/arm64":    PlatformARM64,
+	"linux/arm64/v8": PlatformARM64,
+	"linux/aarch64":  PlatformARM64,
+}
+
+// NormalizePlatform turns a platform or architecture name such as arm64, aarch64 or
+// linux/arm64/v8 into one of Platforms
+func NormalizePlatform(platform string) (string, error) {
+	if normalized, ok := platformAliases[strings.ToLower(platform)]; ok {
+		return normalized, nil
+	}
+	return "", fmt.Errorf("unsupported platform '%s'; use one of %s", platform, strings.Join(Platforms, ", "))
+}
+
+// HostPlatform returns the platform of the daemon's containers, from the OS and
+// architecture it reports
+func HostPlatform(ctx context.Context, dockerClient *client.Client) (string, error) {
+	server, err := dockerClient.ServerVersion(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Docker version: %w", err)
+	}
+	platform, err := NormalizePlatform(server.Os + "/" + server.Arch)
+	if err != nil {
+		return "", fmt.Errorf("the Docker daemon runs %s/%s containers, which git-capsulate does not support", server.Os, server.Arch)
+	}
+	return platform, nil
+}
+
+// Arch returns the architecture of a platform, e.g. arm64 for linux/arm64
+func Arch(platform string) string {
+	_, arch, _ := strings.Cut(platform, "/")
+	return arch
+}
+
+// OCIPlatform returns a platform in the form container creation takes
+func OCIPlatform(platform string) *ocispec.Platform {
+	os, arch, _ := strings.Cut(platform, "/")
+	return &ocispec.Platform{OS: os, Architecture: arch}
+}
@@ -0,0 +1,183 @@
+// Package i18n translates the messages of the CLI: its errors, warnings,
+// informational output and help. Messages are looked up by their English text in
+// a catalog of the selected language, and left in English when it has none.
+// Machine-readable output is never translated.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Env selects the language of the CLI, before the locale of the environment
+const Env = "GIT_CAPSULATE_LANG"
+
+// DefaultLanguage is the language messages are written in
+const DefaultLanguage = "en"
+
+//go:embed locales/*.json
+var locales embed.FS
+
+var (
+	mutex    sync.RWMutex
+	language = DefaultLanguage
+	catalog  map[string]string
+)
+
+// verbPattern matches the formatting verbs of a message, which a translation must
+// keep in the same order
+var verbPattern = regexp.MustCompile(`%[-+# 0]*(\[[0-9]+\])?[0-9*]*(\.[0-9*]*)?[a-zA-Z%]`)
+
+// Detect returns the language selected by the --lang flag, GIT_CAPSULATE_LANG or the
+// locale of the environment (LC_ALL, LC_MESSAGES, LANG), in that order, as a
+// language code such as "de"
+func Detect(flag string) string {
+	for _, val := range []string{flag, os.Getenv(Env), os.Getenv("LC_ALL"), os.Getenv("LC_MESSAGES"), os.Getenv("LANG")} {
+		if lang := normalize(val); lang != "" {
+			return lang
+		}
+	}
+	return DefaultLanguage
+}
+
+// normalize reduces a locale such as "de_DE.UTF-8" to its language code; the C and
+// POSIX locales are English
+func normalize(locale string) string {
+	locale = strings.TrimSpace(locale)
+	if i := strings.IndexAny(locale, "_.@-"); i >= 0 {
+		locale = locale[:i]
+	}
+	locale = strings.ToLower(locale)
+	if locale == "c" || locale == "posix" {
+		return DefaultLanguage
+	}
+	return locale
+}
+
+// Languages returns the languages with a catalog, built in or in the locales
+// directory, English included
+func Languages() []string {
+	found := map[string]bool{DefaultLanguage: true}
+	if entries, err := locales.ReadDir("locales"); err == nil {
+		for _, entry := range entries {
+			found[strings.TrimSuffix(entry.Name(), ".json")] = true
+		}
+	}
+	if entries, err := os.ReadDir(Dir()); err == nil {
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name(), ".json") {
+				found[strings.TrimSuffix(entry.Name(), ".json")] = true
+			}
+		}
+	}
+	langs := make([]string, 0, len(found))
+	for lang := range found {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Dir returns the directory of the catalogs added or overridden on the host,
+// ~/.git-capsulate/locales, where <lang>.json maps English messages to translations
+func Dir() string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "git-capsulate", "locales")
+	}
+	return filepath.Join(homeDir, ".git-capsulate", "locales")
+}
+
+// SetLanguage loads the catalog of a language, the built-in one overlaid with the
+// one in the locales directory. Messages stay in English, and an error is returned,
+// when there is neither.
+func SetLanguage(lang string) error {
+	lang = normalize(lang)
+	if lang == "" {
+		lang = DefaultLanguage
+	}
+	entries := make(map[string]string)
+	found := lang == DefaultLanguage
+
+	if data, err := locales.ReadFile("locales/" + lang + ".json"); err == nil {
+		if err := merge(entries, data); err != nil {
+			return fmt.Errorf("invalid built-in catalog for '%s': %w", lang, err)
+		}
+		found = true
+	}
+	path := filepath.Join(Dir(), lang+".json")
+	if data, err := os.ReadFile(path); err == nil {
+		if err := merge(entries, data); err != nil {
+			return fmt.Errorf("invalid catalog %s: %w", path, err)
+		}
+		found = true
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read catalog: %w", err)
+	}
+
+	if !found {
+		return fmt.Errorf("no catalog for language '%s' (available: %s)", lang, strings.Join(Languages(), ", "))
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	language, catalog = lang, entries
+	return nil
+}
+
+// merge adds the entries of a catalog, skipping translations that do not keep the
+// formatting verbs of their message, which would garble it
+func merge(entries map[string]string, data []byte) error {
+	var parsed map[string]string
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+	for msg, translation := range parsed {
+		if translation == "" || !sameVerbs(msg, translation) {
+			continue
+		}
+		entries[msg] = translation
+	}
+	return nil
+}
+
+// sameVerbs reports whether two messages have the same formatting verbs in the same order
+func sameVerbs(a, b string) bool {
+	return strings.Join(verbPattern.FindAllString(a, -1), " ") == strings.Join(verbPattern.FindAllString(b, -1), " ")
+}
+
+// Language returns the language messages are translated to
+func Language() string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return language
+}
+
+// T translates a message, or returns it unchanged when the catalog has no
+// translation. Leading and trailing whitespace, such as a final newline, is not part
+// of the message looked up, and is kept.
+func T(msg string) string {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	if len(catalog) == 0 {
+		return msg
+	}
+	trimmed := strings.TrimSpace(msg)
+	translation, ok := catalog[trimmed]
+	if !ok {
+		return msg
+	}
+	start := strings.Index(msg, trimmed)
+	return msg[:start] + translation + msg[start+len(trimmed):]
+}
+
+// Sprintf formats the translation of a format
+func Sprintf(format string, args ...interface{}) string {
+	return fmt.Sprintf(T(format), args...)
+}
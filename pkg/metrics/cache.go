@@ -0,0 +1,175 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CacheOps represents clone and dependency cache metrics
+const CacheOps MetricType = "cache_ops"
+
+// Cache names used with RecordCacheHit and RecordCacheMiss
+const (
+	// CloneCache is the bare repository cache agents clone from
+	CloneCache = "clone"
+	// DependencyCache is the set of shared core and team dependency layers
+	DependencyCache = "deps"
+)
+
+// cacheStatsFile is the file in the metrics directory holding cumulative cache stats
+const cacheStatsFile = "cache-stats.json"
+
+// CacheStats holds the cumulative effectiveness of a cache for one agent
+type CacheStats struct {
+	Hits       int       `json:"hits"`
+	Misses     int       `json:"misses"`
+	BytesSaved int64     `json:"bytes_saved"`
+	TimeSaved  float64   `json:"time_saved_ms"`
+	LastUpdate time.Time `json:"last_update"`
+}
+
+// HitRate returns the fraction of lookups served from the cache
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Add accumulates other into s
+func (s *CacheStats) Add(other CacheStats) {
+	s.Hits += other.Hits
+	s.Misses += other.Misses
+	s.BytesSaved += other.BytesSaved
+	s.TimeSaved += other.TimeSaved
+	if other.LastUpdate.After(s.LastUpdate) {
+		s.LastUpdate = other.LastUpdate
+	}
+}
+
+var cacheStatsMutex sync.Mutex
+
+// RecordCacheHit records a lookup served from a cache, with the bytes that did not
+// have to be downloaded and the time that did not have to be spent (0 when unknown).
+// Unlike other metrics, cache stats are persisted so they accumulate across commands.
+func RecordCacheHit(cache, agentID string, bytesSaved int64, timeSaved time.Duration) {
+	RecordCount(cache+"_hit", CacheOps, 1, agentID)
+	updateCacheStats(cache, agentID, CacheStats{
+		Hits:       1,
+		BytesSaved: bytesSaved,
+		TimeSaved:  float64(timeSaved.Milliseconds()),
+	})
+}
+
+// RecordCacheMiss records a lookup the cache could not serve
+func RecordCacheMiss(cache, agentID string) {
+	RecordCount(cache+"_miss", CacheOps, 1, agentID)
+	updateCacheStats(cache, agentID, CacheStats{Misses: 1})
+}
+
+// GetCacheStats returns the persisted cache stats keyed by cache name, then agent ID
+func GetCacheStats() (map[string]map[string]CacheStats, error) {
+	cacheStatsMutex.Lock()
+	defer cacheStatsMutex.Unlock()
+	return loadCacheStats()
+}
+
+// GetAgentCacheStats returns the persisted stats of every cache for a single agent
+func GetAgentCacheStats(agentID string) (map[string]CacheStats, error) {
+	all, err := GetCacheStats()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]CacheStats)
+	for cache, byAgent := range all {
+		if stats, ok := byAgent[agentID]; ok {
+			result[cache] = stats
+		}
+	}
+	return result, nil
+}
+
+// CacheTotals sums the persisted stats of each cache over all agents
+func CacheTotals(all map[string]map[string]CacheStats) map[string]CacheStats {
+	totals := make(map[string]CacheStats)
+	for cache, byAgent := range all {
+		var total CacheStats
+		for _, stats := range byAgent {
+			total.Add(stats)
+		}
+		totals[cache] = total
+	}
+	return totals
+}
+
+// CacheNames returns the names of the caches in all, sorted
+func CacheNames(all map[string]map[string]CacheStats) []string {
+	names := make([]string, 0, len(all))
+	for cache := range all {
+		names = append(names, cache)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ClearCacheStats removes the persisted cache stats
+func ClearCacheStats() error {
+	cacheStatsMutex.Lock()
+	defer cacheStatsMutex.Unlock()
+	if err := os.Remove(filepath.Join(Dir(), cacheStatsFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cache stats: %v", err)
+	}
+	return nil
+}
+
+// updateCacheStats merges a delta into the persisted stats. Failures are ignored:
+// metrics must never break the operation being measured.
+func updateCacheStats(cache, agentID string, delta CacheStats) {
+	if !Enabled() {
+		return
+	}
+	cacheStatsMutex.Lock()
+	defer cacheStatsMutex.Unlock()
+
+	all, err := loadCacheStats()
+	if err != nil {
+		return
+	}
+	if all[cache] == nil {
+		all[cache] = make(map[string]CacheStats)
+	}
+	delta.LastUpdate = time.Now()
+	stats := all[cache][agentID]
+	stats.Add(delta)
+	all[cache][agentID] = stats
+
+	if err := os.MkdirAll(Dir(), 0755); err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return
+	}
+	os.WriteFile(filepath.Join(Dir(), cacheStatsFile), data, 0644)
+}
+
+// loadCacheStats reads the persisted stats; callers must hold cacheStatsMutex
+func loadCacheStats() (map[string]map[string]CacheStats, error) {
+	all := make(map[string]map[string]CacheStats)
+	data, err := os.ReadFile(filepath.Join(Dir(), cacheStatsFile))
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache stats: %v", err)
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse cache stats: %v", err)
+	}
+	return all, nil
+}
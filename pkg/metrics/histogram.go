@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// histogramsFile is the file in the metrics directory holding the timer histograms
+const histogramsFile = "histograms.json"
+
+// DefaultBuckets are the upper bounds of the histogram buckets of timers, from
+// sub-second file operations to clones and image builds taking minutes
+var DefaultBuckets = []time.Duration{
+	10 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond,
+	250 * time.Millisecond, 500 * time.Millisecond, time.Second,
+	2500 * time.Millisecond, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+	time.Minute, 2 * time.Minute, 5 * time.Minute, 10 * time.Minute,
+}
+
+// Histogram counts the durations of a timer in buckets. Counts has one entry per
+// bound, counting durations up to it, and a last one for longer durations.
+type Histogram struct {
+	Bounds []float64 `json:"bounds_ms"`
+	Counts []int64   `json:"counts"`
+	Count  int64     `json:"count"`
+	Sum    float64   `json:"sum_ms"`
+	Min    float64   `json:"min_ms"`
+	Max    float64   `json:"max_ms"`
+}
+
+// HistogramSummary is the count, average, extremes and percentiles of a histogram, in
+// milliseconds
+type HistogramSummary struct {
+	Count int64   `json:"count"`
+	Avg   float64 `json:"avg_ms"`
+	Min   float64 `json:"min_ms"`
+	Max   float64 `json:"max_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+// newHistogram creates an empty histogram with the given bucket bounds
+func newHistogram(bounds []time.Duration) Histogram {
+	h := Histogram{Bounds: make([]float64, len(bounds)), Counts: make([]int64, len(bounds)+1)}
+	for i, bound := range bounds {
+		h.Bounds[i] = float64(bound) / float64(time.Millisecond)
+	}
+	return h
+}
+
+// Observe adds a duration in milliseconds to the histogram
+func (h *Histogram) Observe(ms float64) {
+	i := sort.SearchFloat64s(h.Bounds, ms)
+	h.Counts[i]++
+	if h.Count == 0 || ms < h.Min {
+		h.Min = ms
+	}
+	if ms > h.Max {
+		h.Max = ms
+	}
+	h.Count++
+	h.Sum += ms
+}
+
+// Percentile estimates the p-th percentile (0 to 100) of the durations in
+// milliseconds, interpolating linearly within the bucket it falls in. The estimate
+// is never below Min or above Max.
+func (h Histogram) Percentile(p float64) float64 {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(h.Count)
+	var cumulative int64
+	for i, count := range h.Counts {
+		if count == 0 || float64(cumulative+count) < rank {
+			cumulative += count
+			continue
+		}
+		lower, upper := h.Min, h.Max
+		if i > 0 && h.Bounds[i-1] > lower {
+			lower = h.Bounds[i-1]
+		}
+		if i < len(h.Bounds) && h.Bounds[i] < upper {
+			upper = h.Bounds[i]
+		}
+		value := lower + (upper-lower)*(rank-float64(cumulative))/float64(count)
+		if value < h.Min {
+			value = h.Min
+		}
+		if value > h.Max {
+			value = h.Max
+		}
+		return value
+	}
+	return h.Max
+}
+
+// Summary returns the count, average, extremes and p50, p90 and p99 of the histogram
+func (h Histogram) Summary() HistogramSummary {
+	if h.Count == 0 {
+		return HistogramSummary{}
+	}
+	return HistogramSummary{
+		Count: h.Count,
+		Avg:   h.Sum / float64(h.Count),
+		Min:   h.Min,
+		Max:   h.Max,
+		P50:   h.Percentile(50),
+		P90:   h.Percentile(90),
+		P99:   h.Percentile(99),
+	}
+}
+
+// sameBounds reports whether the histogram has the given bucket bounds
+func (h Histogram) sameBounds(other Histogram) bool {
+	if len(h.Bounds) != len(other.Bounds) || len(h.Counts) != len(h.Bounds)+1 {
+		return false
+	}
+	for i := range h.Bounds {
+		if h.Bounds[i] != other.Bounds[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	// buckets are the bucket bounds of timers configured with SetBuckets, keyed by
+	// operation; "" holds the bounds of the other timers
+	buckets         = make(map[string][]time.Duration)
+	bucketsMutex    sync.Mutex
+	histogramsMutex sync.Mutex
+)
+
+// SetBuckets sets the histogram bucket bounds of a timer's operation, e.g.
+// create_container, or of every other timer for "". Nil restores DefaultBuckets.
+// Histograms recorded with other bounds start over at their next duration.
+func SetBuckets(operation string, bounds []time.Duration) {
+	bucketsMutex.Lock()
+	defer bucketsMutex.Unlock()
+	if bounds == nil {
+		delete(buckets, operation)
+		return
+	}
+	sorted := append([]time.Duration{}, bounds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	buckets[operation] = sorted
+}
+
+// bucketsFor returns the histogram bucket bounds of an operation
+func bucketsFor(operation string) []time.Duration {
+	bucketsMutex.Lock()
+	defer bucketsMutex.Unlock()
+	if bounds, ok := buckets[operation]; ok {
+		return bounds
+	}
+	if bounds, ok := buckets[""]; ok {
+		return bounds
+	}
+	return DefaultBuckets
+}
+
+// GetHistograms returns the persisted timer histograms keyed by type.operation
+func GetHistograms() (map[string]Histogram, error) {
+	histogramsMutex.Lock()
+	defer histogramsMutex.Unlock()
+	return loadHistograms()
+}
+
+// ClearHistograms removes the persisted timer histograms
+func ClearHistograms() error {
+	histogramsMutex.Lock()
+	defer histogramsMutex.Unlock()
+	if err := os.Remove(filepath.Join(Dir(), histogramsFile)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove histograms: %v", err)
+	}
+	return nil
+}
+
+// observeDuration adds the duration of a timer to its persisted histogram, which
+// accumulates across commands like the cache stats. Failures are ignored: metrics
+// must never break the operation being measured.
+func observeDuration(metricType MetricType, operation string, duration time.Duration) {
+	histogramsMutex.Lock()
+	defer histogramsMutex.Unlock()
+
+	all, err := loadHistograms()
+	if err != nil {
+		return
+	}
+	key := string(metricType) + "." + operation
+	fresh := newHistogram(bucketsFor(operation))
+	h, ok := all[key]
+	if !ok || !h.sameBounds(fresh) {
+		h = fresh
+	}
+	h.Observe(float64(duration) / float64(time.Millisecond))
+	all[key] = h
+
+	if err := os.MkdirAll(Dir(), 0755); err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return
+	}
+	os.WriteFile(filepath.Join(Dir(), histogramsFile), data, 0644)
+}
+
+// loadHistograms reads the persisted histograms; callers must hold histogramsMutex
+func loadHistograms() (map[string]Histogram, error) {
+	all := make(map[string]Histogram)
+	data, err := os.ReadFile(filepath.Join(Dir(), histogramsFile))
+	if os.IsNotExist(err) {
+		return all, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read histograms: %v", err)
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse histograms: %v", err)
+	}
+	return all, nil
+}
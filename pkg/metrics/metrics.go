@@ -6,7 +6,10 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/tracing"
 )
 
 // MetricType represents different metric categories
@@ -23,6 +26,8 @@ const (
 	DependencyOps MetricType = "dependency_ops"
 	// ResourceUsage represents resource usage metrics
 	ResourceUsage MetricType = "resource_usage"
+	// CheckOps represents validation check (build/test/lint) metrics
+	CheckOps MetricType = "check_ops"
 )
 
 // Internal metrics storage
@@ -37,8 +42,25 @@ var (
 	gaugesMutex sync.Mutex
 )
 
+// disabled is set while recording is switched off by SetEnabled
+var disabled atomic.Bool
+
+// SetEnabled switches the recording of metrics, e.g. off when telemetry is disabled.
+// While disabled, timers, counters, gauges and cache stats record nothing.
+func SetEnabled(enabled bool) {
+	disabled.Store(!enabled)
+}
+
+// Enabled reports whether metrics are recorded
+func Enabled() bool {
+	return !disabled.Load()
+}
+
 // StartTimer starts a timer for the specified operation
 func StartTimer(operation string, metricType MetricType, agentID string) {
+	if !Enabled() {
+		return
+	}
 	key := formatKey(string(metricType), operation, agentID)
 	
 	timersMutex.Lock()
@@ -61,27 +83,50 @@ func StopTimer(operation string, metricType MetricType, agentID string) time.Dur
 	}
 	
 	duration := time.Since(startTime)
+	observeDuration(metricType, operation, duration)
+	tracing.RecordOperation(tracing.OperationMetric, operation, agentID, map[string]interface{}{
+		"type":        string(metricType),
+		"duration_ms": duration.Milliseconds(),
+	})
 	return duration
 }
 
 // RecordCount increments a counter for the specified operation
 func RecordCount(operation string, metricType MetricType, count int, agentID string) {
+	if !Enabled() {
+		return
+	}
 	key := formatKey(string(metricType), operation, agentID)
 	
 	countersMutex.Lock()
-	defer countersMutex.Unlock()
-	
 	counters[key] += count
+	countersMutex.Unlock()
+	
+	tracing.RecordOperation(tracing.OperationMetric, operation, agentID, map[string]interface{}{
+		"type":  string(metricType),
+		"count": count,
+	})
 }
 
 // RecordGauge sets a gauge value for the specified operation
 func RecordGauge(operation string, metricType MetricType, value float64, unit string, agentID string) {
+	if !Enabled() {
+		return
+	}
 	key := formatKey(string(metricType), operation, agentID)
 	
 	gaugesMutex.Lock()
-	defer gaugesMutex.Unlock()
-	
 	gauges[key] = value
+	gaugesMutex.Unlock()
+	
+	// Resource usage is sampled continuously by the monitor rather than by operations
+	if metricType != ResourceUsage {
+		tracing.RecordOperation(tracing.OperationMetric, operation, agentID, map[string]interface{}{
+			"type":  string(metricType),
+			"value": value,
+			"unit":  unit,
+		})
+	}
 }
 
 // GetMetrics returns all collected metrics
@@ -132,50 +177,111 @@ func formatKey(metricType, operation, agentID string) string {
 	return metricType + "." + operation + "." + agentID
 }
 
-// GetSummary returns a summary of metrics by category
-func GetSummary() map[string]interface{} {
-	metrics := GetMetrics()
-	summary := make(map[string]interface{})
-	
-	// Group by metric type
-	for metricsType, metricsData := range metrics {
-		switch metricsData.(type) {
-		case map[string]int:
-			byType := make(map[string]map[string]int)
-			for key, val := range metricsData.(map[string]int) {
-				// Extract type, operation, and agent from key
-				parts := splitKey(key)
-				if len(parts) >= 2 {
-					metricType := parts[0]
-					operation := parts[1]
-					
-					if _, exists := byType[metricType]; !exists {
-						byType[metricType] = make(map[string]int)
-					}
-					byType[metricType][operation] = val
-				}
-			}
-			summary[metricsType] = byType
-			
-		case map[string]float64:
-			byType := make(map[string]map[string]float64)
-			for key, val := range metricsData.(map[string]float64) {
-				// Extract type, operation, and agent from key
-				parts := splitKey(key)
-				if len(parts) >= 2 {
-					metricType := parts[0]
-					operation := parts[1]
-					
-					if _, exists := byType[metricType]; !exists {
-						byType[metricType] = make(map[string]float64)
-					}
-					byType[metricType][operation] = val
-				}
-			}
-			summary[metricsType] = byType
+// OperationStats are the metrics of one operation. Count is the number of
+// occurrences recorded with RecordCount plus the number of timed runs; the durations,
+// in milliseconds, come from the operation's timer histogram, and Value is the sum of
+// its gauges over agents.
+type OperationStats struct {
+	Count       int     `json:"count"`
+	AvgDuration float64 `json:"avg_duration_ms,omitempty"`
+	MinDuration float64 `json:"min_duration_ms,omitempty"`
+	MaxDuration float64 `json:"max_duration_ms,omitempty"`
+	P50         float64 `json:"p50_ms,omitempty"`
+	P90         float64 `json:"p90_ms,omitempty"`
+	P99         float64 `json:"p99_ms,omitempty"`
+	Value       float64 `json:"value,omitempty"`
+}
+
+// CategorySummary are the metrics of one category, such as container_ops. The
+// durations, in milliseconds, are over all timed runs of its operations.
+type CategorySummary struct {
+	TotalCount  int                       `json:"total_count"`
+	AvgDuration float64                   `json:"avg_duration_ms,omitempty"`
+	MinDuration float64                   `json:"min_duration_ms,omitempty"`
+	MaxDuration float64                   `json:"max_duration_ms,omitempty"`
+	Operations  map[string]OperationStats `json:"operations"`
+}
+
+// Report is the metrics summary shown by 'metrics show' and written by Flush
+type Report struct {
+	Timestamp  time.Time                  `json:"timestamp"`
+	Categories map[string]CategorySummary `json:"categories"`
+	// Caches are the persisted cache stats keyed by cache name, then agent ID
+	Caches map[string]map[string]CacheStats `json:"caches,omitempty"`
+}
+
+// GetSummary returns the metrics by category: the counters and gauges of this
+// process and the persisted timer histograms
+func GetSummary() map[string]CategorySummary {
+	summary := make(map[string]CategorySummary)
+	// update applies fn to the stats of the operation a type.operation[.agent] key names
+	update := func(key string, fn func(stats *OperationStats)) string {
+		parts := splitKey(key)
+		if len(parts) < 2 {
+			return ""
 		}
+		category, ok := summary[parts[0]]
+		if !ok {
+			category = CategorySummary{Operations: make(map[string]OperationStats)}
+			summary[parts[0]] = category
+		}
+		stats := category.Operations[parts[1]]
+		fn(&stats)
+		category.Operations[parts[1]] = stats
+		return parts[0]
+	}
+
+	countersMutex.Lock()
+	for key, count := range counters {
+		update(key, func(stats *OperationStats) { stats.Count += count })
+	}
+	countersMutex.Unlock()
+
+	gaugesMutex.Lock()
+	for key, value := range gauges {
+		update(key, func(stats *OperationStats) { stats.Value += value })
+	}
+	gaugesMutex.Unlock()
+
+	histograms, _ := GetHistograms()
+	timed := make(map[string]Histogram)
+	for key, h := range histograms {
+		if h.Count == 0 {
+			continue
+		}
+		hs := h.Summary()
+		c := update(key, func(stats *OperationStats) {
+			stats.Count += int(hs.Count)
+			stats.AvgDuration, stats.MinDuration, stats.MaxDuration = hs.Avg, hs.Min, hs.Max
+			stats.P50, stats.P90, stats.P99 = hs.P50, hs.P90, hs.P99
+		})
+		if c == "" {
+			continue
+		}
+
+		// Category durations are over the runs of all its timers
+		total, ok := timed[c]
+		if !ok || h.Min < total.Min {
+			total.Min = h.Min
+		}
+		if h.Max > total.Max {
+			total.Max = h.Max
+		}
+		total.Count += h.Count
+		total.Sum += h.Sum
+		timed[c] = total
+	}
+
+	for name, category := range summary {
+		for _, stats := range category.Operations {
+			category.TotalCount += stats.Count
+		}
+		if total, ok := timed[name]; ok {
+			category.AvgDuration = total.Sum / float64(total.Count)
+			category.MinDuration, category.MaxDuration = total.Min, total.Max
+		}
+		summary[name] = category
 	}
-	
 	return summary
 }
 
@@ -195,18 +301,37 @@ func splitKey(key string) []string {
 	return result
 }
 
-// GetSummaryJSON returns a JSON representation of the metrics summary
+// GetReport returns the metrics summary along with the persisted cache stats
+func GetReport() (*Report, error) {
+	report := &Report{
+		Timestamp:  time.Now(),
+		Categories: GetSummary(),
+	}
+	caches, err := GetCacheStats()
+	if err != nil {
+		return nil, err
+	}
+	if len(caches) > 0 {
+		report.Caches = caches
+	}
+	return report, nil
+}
+
+// GetSummaryJSON returns a JSON representation of the metrics report
 func GetSummaryJSON() (string, error) {
-	summary := GetSummary()
-	data, err := json.MarshalIndent(summary, "", "  ")
+	report, err := GetReport()
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal summary: %v", err)
 	}
 	return string(data), nil
 }
 
-// Flush writes metrics to disk and clears them
-func Flush() error {
+// Dir returns the directory metrics are written to
+func Dir() string {
 	metricsPath := os.Getenv("GIT_CAPSULATE_METRICS_PATH")
 	if metricsPath == "" {
 		homeDir, err := os.UserHomeDir()
@@ -216,7 +341,13 @@ func Flush() error {
 			metricsPath = filepath.Join(os.TempDir(), "git-capsulate", "metrics")
 		}
 	}
-	
+	return metricsPath
+}
+
+// Flush writes metrics to disk and clears them
+func Flush() error {
+	metricsPath := Dir()
+
 	// Create directory if it doesn't exist
 	if err := os.MkdirAll(metricsPath, 0755); err != nil {
 		return fmt.Errorf("failed to create metrics directory: %v", err)
@@ -226,14 +357,14 @@ func Flush() error {
 	timestamp := time.Now().Format("20060102-150405")
 	filename := filepath.Join(metricsPath, fmt.Sprintf("metrics-%s.json", timestamp))
 	
-	// Get metrics summary
-	summary := GetSummary()
-	
-	// Add timestamp
-	summary["timestamp"] = time.Now().Format(time.RFC3339)
+	// Get metrics report
+	report, err := GetReport()
+	if err != nil {
+		return err
+	}
 	
 	// Marshal to JSON
-	data, err := json.MarshalIndent(summary, "", "  ")
+	data, err := json.MarshalIndent(report, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal metrics: %v", err)
 	}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/your-org/capsulate-repo/pkg/dockerclient"
 	"github.com/your-org/capsulate-repo/pkg/metrics"
 )
 
@@ -17,7 +18,9 @@ import (
 type ContainerStats struct {
 	ContainerID   string    `json:"container_id"`
 	AgentID       string    `json:"agent_id"`
-	CPUUsage      float64   `json:"cpu_usage_percent"`
+	Project       string    `json:"project,omitempty"` // project of the agent, empty for the default project
+	Sidecar       string    `json:"sidecar,omitempty"` // name of the sidecar, empty for the agent container
+	CPUUsage      float64   `json:"cpu_usage_percent"` // percent of one CPU
 	MemoryUsage   int64     `json:"memory_usage_bytes"`
 	MemoryLimit   int64     `json:"memory_limit_bytes"`
 	MemoryPercent float64   `json:"memory_usage_percent"`
@@ -26,32 +29,99 @@ type ContainerStats struct {
 	NetRx         int64     `json:"network_rx_bytes"`
 	NetTx         int64     `json:"network_tx_bytes"`
 	Timestamp     time.Time `json:"timestamp"`
+
+	// CPULimit is the number of CPUs the container may use, 0 without a limit, and
+	// CPULimitPercent its CPU usage relative to that limit
+	CPULimit        float64 `json:"cpu_limit,omitempty"`
+	CPULimitPercent float64 `json:"cpu_limit_percent,omitempty"`
+	// MemoryLimited is whether MemoryLimit is a limit of the container rather than
+	// the memory of the host
+	MemoryLimited bool `json:"memory_limited,omitempty"`
+	// Throttling of the container by the CFS scheduler, from its cgroup: the periods
+	// and time it was throttled in total, and the share of throttled periods in the
+	// last sample
+	ThrottledPeriods uint64        `json:"throttled_periods"`
+	ThrottledTime    time.Duration `json:"throttled_time_ns"`
+	ThrottledPercent float64       `json:"throttled_percent"`
+	// Alerts lists the limits the container is running into, e.g. AlertCPUPinned
+	Alerts []string `json:"alerts,omitempty"`
+	// Rates of the disk and network counters in bytes per second, since the previous
+	// sample of the container; zero on its first sample
+	DiskReadRate  float64 `json:"disk_read_bytes_per_sec"`
+	DiskWriteRate float64 `json:"disk_write_bytes_per_sec"`
+	NetRxRate     float64 `json:"network_rx_bytes_per_sec"`
+	NetTxRate     float64 `json:"network_tx_bytes_per_sec"`
+}
+
+// applyRates computes the disk and network rates from the previous sample of the
+// container. Counters that went down were reset by a restart and have no rate.
+func (s *ContainerStats) applyRates(previous *ContainerStats) {
+	if previous == nil {
+		return
+	}
+	elapsed := s.Timestamp.Sub(previous.Timestamp).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	rate := func(current, before int64) float64 {
+		if current < before {
+			return 0
+		}
+		return float64(current-before) / elapsed
+	}
+	s.DiskReadRate = rate(s.DiskRead, previous.DiskRead)
+	s.DiskWriteRate = rate(s.DiskWrite, previous.DiskWrite)
+	s.NetRxRate = rate(s.NetRx, previous.NetRx)
+	s.NetTxRate = rate(s.NetTx, previous.NetTx)
+}
+
+// clone returns a copy of the stats that shares nothing with them
+func (s *ContainerStats) clone() *ContainerStats {
+	c := *s
+	c.Alerts = append([]string(nil), s.Alerts...)
+	return &c
 }
 
 // Monitor monitors resource usage of Docker containers
 type Monitor struct {
-	dockerClient   *client.Client
-	containerStats map[string]*ContainerStats
-	mutex          sync.RWMutex
-	interval       time.Duration
-	stopChan       chan struct{}
-	running        bool
+	dockerClient    *client.Client
+	containerStats  map[string]*ContainerStats
+	containerLimits map[string]resourceLimits
+	schedules       map[string]pollSchedule
+	mutex           sync.RWMutex
+	interval        time.Duration
+	idleInterval    time.Duration
+	stopChan        chan struct{}
+	running         bool
 }
 
 // NewMonitor creates a new container monitor
 func NewMonitor(interval time.Duration) (*Monitor, error) {
-	// Initialize Docker client
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv)
+	// Initialize Docker client and check the daemon reports usable stats
+	dockerClient, err := dockerclient.New()
+	if err != nil {
+		return nil, err
+	}
+	ping, err := dockerclient.Probe(context.Background(), dockerClient)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create Docker client: %v", err)
+		return nil, err
+	}
+	if err := dockerclient.Require(ping, dockerclient.CgroupV2Stats); err != nil {
+		return nil, err
 	}
 
 	monitor := &Monitor{
-		dockerClient:   dockerClient,
-		containerStats: make(map[string]*ContainerStats),
-		interval:       interval,
-		stopChan:       make(chan struct{}),
-		running:        false,
+		dockerClient:    dockerClient,
+		containerStats:  make(map[string]*ContainerStats),
+		containerLimits: make(map[string]resourceLimits),
+		schedules:       make(map[string]pollSchedule),
+		interval:        interval,
+		idleInterval:    interval,
+		stopChan:        make(chan struct{}),
+		running:         false,
+	}
+	if DefaultIdleInterval > interval {
+		monitor.idleInterval = DefaultIdleInterval
 	}
 
 	return monitor, nil
@@ -100,20 +170,7 @@ func (m *Monitor) GetAllContainerStats() map[string]*ContainerStats {
 	// Return a copy to prevent race conditions
 	statsCopy := make(map[string]*ContainerStats, len(m.containerStats))
 	for id, stats := range m.containerStats {
-		// Make a copy of stats
-		statsCopy[id] = &ContainerStats{
-			ContainerID:   stats.ContainerID,
-			AgentID:       stats.AgentID,
-			CPUUsage:      stats.CPUUsage,
-			MemoryUsage:   stats.MemoryUsage,
-			MemoryLimit:   stats.MemoryLimit,
-			MemoryPercent: stats.MemoryPercent,
-			DiskRead:      stats.DiskRead,
-			DiskWrite:     stats.DiskWrite,
-			NetRx:         stats.NetRx,
-			NetTx:         stats.NetTx,
-			Timestamp:     stats.Timestamp,
-		}
+		statsCopy[id] = stats.clone()
 	}
 	return statsCopy
 }
@@ -126,42 +183,36 @@ func (m *Monitor) GetContainerStatsByAgentID(agentID string) []*ContainerStats {
 	var containerStats []*ContainerStats
 	for _, stats := range m.containerStats {
 		if stats.AgentID == agentID {
-			// Make a copy of stats
-			statsCopy := &ContainerStats{
-				ContainerID:   stats.ContainerID,
-				AgentID:       stats.AgentID,
-				CPUUsage:      stats.CPUUsage,
-				MemoryUsage:   stats.MemoryUsage,
-				MemoryLimit:   stats.MemoryLimit,
-				MemoryPercent: stats.MemoryPercent,
-				DiskRead:      stats.DiskRead,
-				DiskWrite:     stats.DiskWrite,
-				NetRx:         stats.NetRx,
-				NetTx:         stats.NetTx,
-				Timestamp:     stats.Timestamp,
-			}
-			containerStats = append(containerStats, statsCopy)
+			containerStats = append(containerStats, stats.clone())
 		}
 	}
 	return containerStats
 }
 
-// monitorLoop periodically collects statistics for all containers
+// monitorLoop collects statistics as containers become due, and right away when Docker
+// reports a container starting, being updated or running out of memory. Without events,
+// e.g. while reconnecting to the daemon, it keeps polling.
 func (m *Monitor) monitorLoop() {
 	ticker := time.NewTicker(m.interval)
 	defer ticker.Stop()
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := m.watchEvents(ctx)
+
 	for {
 		select {
 		case <-ticker.C:
 			m.collectStats()
+		case event := <-events:
+			m.handleEvent(ctx, event)
 		case <-m.stopChan:
 			return
 		}
 	}
 }
 
-// collectStats collects statistics for all containers
+// collectStats collects statistics for the containers that are due
 func (m *Monitor) collectStats() {
 	ctx := context.Background()
 
@@ -173,72 +224,132 @@ func (m *Monitor) collectStats() {
 	}
 
 	// Collect stats for each container
+	now := time.Now()
+	running := make(map[string]bool, len(containers))
 	for _, container := range containers {
 		// Only monitor git-capsulate containers
 		if !isCapsulateContainer(container.Names) {
 			continue
 		}
-
-		// Extract the agent ID from the container name
-		agentID := extractAgentID(container.Names)
-
-		// Get container stats
-		stats, err := m.dockerClient.ContainerStats(ctx, container.ID, false)
-		if err != nil {
-			fmt.Printf("Failed to get stats for container %s: %v\n", container.ID, err)
-			continue
+		running[container.ID] = true
+		if m.due(container.ID, now) {
+			m.collectContainer(ctx, container)
 		}
+	}
 
-		// Parse container stats
-		var statsJSON types.StatsJSON
-		if err := json.NewDecoder(stats.Body).Decode(&statsJSON); err != nil {
-			fmt.Printf("Failed to decode stats for container %s: %v\n", container.ID, err)
-			stats.Body.Close()
-			continue
+	// Forget the containers that are gone
+	m.mutex.Lock()
+	for id := range m.containerStats {
+		if !running[id] {
+			m.forgetLocked(id)
 		}
+	}
+	for id := range m.schedules {
+		if !running[id] {
+			m.forgetLocked(id)
+		}
+	}
+	m.mutex.Unlock()
+}
+
+// collectContainer collects statistics for a container and schedules its next sample
+func (m *Monitor) collectContainer(ctx context.Context, container types.Container) {
+	// Extract the agent ID from the container's labels, falling back to its name;
+	// sidecars are grouped under the agent they belong to
+	agentID := container.Labels["capsulate.agent-id"]
+	if agentID == "" {
+		agentID = extractAgentID(container.Names)
+	}
+	sidecar := container.Labels["capsulate.sidecar"]
+	project := container.Labels["capsulate.project"]
+
+	// Get container stats
+	stats, err := m.dockerClient.ContainerStats(ctx, container.ID, false)
+	if err != nil {
+		fmt.Printf("Failed to get stats for container %s: %v\n", container.ID, err)
+		return
+	}
+
+	// Parse container stats
+	var statsJSON types.StatsJSON
+	if err := json.NewDecoder(stats.Body).Decode(&statsJSON); err != nil {
+		fmt.Printf("Failed to decode stats for container %s: %v\n", container.ID, err)
 		stats.Body.Close()
+		return
+	}
+	stats.Body.Close()
+
+	// Calculate CPU usage percentage; cgroup v2 reports no per-CPU usage, only the
+	// number of online CPUs
+	cpuDelta := float64(statsJSON.CPUStats.CPUUsage.TotalUsage - statsJSON.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(statsJSON.CPUStats.SystemUsage - statsJSON.PreCPUStats.SystemUsage)
+	onlineCPUs := float64(statsJSON.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(statsJSON.CPUStats.CPUUsage.PercpuUsage))
+	}
+	cpuPercent := 0.0
+	if systemDelta > 0.0 && cpuDelta > 0.0 {
+		cpuPercent = (cpuDelta / systemDelta) * onlineCPUs * 100.0
+	}
 
-		// Calculate CPU usage percentage
-		cpuDelta := float64(statsJSON.CPUStats.CPUUsage.TotalUsage - statsJSON.PreCPUStats.CPUUsage.TotalUsage)
-		systemDelta := float64(statsJSON.CPUStats.SystemUsage - statsJSON.PreCPUStats.SystemUsage)
-		cpuPercent := 0.0
-		if systemDelta > 0.0 && cpuDelta > 0.0 {
-			cpuPercent = (cpuDelta / systemDelta) * float64(len(statsJSON.CPUStats.CPUUsage.PercpuUsage)) * 100.0
-		}
+	// Calculate memory usage percentage, of the container's limit if it has one
+	memoryUsage := memoryUsage(statsJSON.MemoryStats)
+	memoryPercent := 0.0
+	if statsJSON.MemoryStats.Limit > 0 {
+		memoryPercent = float64(memoryUsage) / float64(statsJSON.MemoryStats.Limit) * 100.0
+	}
+	diskRead, diskWrite := blkioBytes(statsJSON.BlkioStats)
+
+	// Store container stats
+	containerStats := &ContainerStats{
+		ContainerID:   container.ID,
+		AgentID:       agentID,
+		Project:       project,
+		Sidecar:       sidecar,
+		CPUUsage:      cpuPercent,
+		MemoryUsage:   memoryUsage,
+		MemoryLimit:   int64(statsJSON.MemoryStats.Limit),
+		MemoryPercent: memoryPercent,
+		DiskRead:      diskRead,
+		DiskWrite:     diskWrite,
+		NetRx:         int64(statsJSON.Networks["eth0"].RxBytes),
+		NetTx:         int64(statsJSON.Networks["eth0"].TxBytes),
+		Timestamp:     time.Now(),
+	}
 
-		// Calculate memory usage percentage
-		memoryPercent := 0.0
-		if statsJSON.MemoryStats.Limit > 0 {
-			memoryPercent = float64(statsJSON.MemoryStats.Usage) / float64(statsJSON.MemoryStats.Limit) * 100.0
-		}
+	// Relate the usage to the container's own limits, which the host's figures hide
+	limits, err := m.limits(ctx, container.ID)
+	if err != nil {
+		fmt.Printf("Failed to inspect container %s: %v\n", container.ID, err)
+	}
+	applyLimits(containerStats, limits, &statsJSON)
 
-		// Store container stats
-		containerStats := &ContainerStats{
-			ContainerID:   container.ID,
-			AgentID:       agentID,
-			CPUUsage:      cpuPercent,
-			MemoryUsage:   int64(statsJSON.MemoryStats.Usage),
-			MemoryLimit:   int64(statsJSON.MemoryStats.Limit),
-			MemoryPercent: memoryPercent,
-			DiskRead:      int64(statsJSON.BlkioStats.IoServiceBytesRecursive[0].Value),
-			DiskWrite:     int64(statsJSON.BlkioStats.IoServiceBytesRecursive[1].Value),
-			NetRx:         int64(statsJSON.Networks["eth0"].RxBytes),
-			NetTx:         int64(statsJSON.Networks["eth0"].TxBytes),
-			Timestamp:     time.Now(),
-		}
+	m.mutex.Lock()
+	containerStats.applyRates(m.containerStats[container.ID])
+	m.containerStats[container.ID] = containerStats
+	m.scheduleLocked(container.ID, containerStats)
+	m.mutex.Unlock()
 
-		m.mutex.Lock()
-		m.containerStats[container.ID] = containerStats
-		m.mutex.Unlock()
+	// The agent's gauges describe its own container; sidecars only have stats
+	if sidecar != "" {
+		return
+	}
 
-		// Record metrics
-		metrics.RecordGauge("cpu_usage", metrics.ResourceUsage, cpuPercent, "percent", agentID)
-		metrics.RecordGauge("memory_usage", metrics.ResourceUsage, float64(statsJSON.MemoryStats.Usage), "bytes", agentID)
-		metrics.RecordGauge("memory_percent", metrics.ResourceUsage, memoryPercent, "percent", agentID)
-		metrics.RecordGauge("disk_read", metrics.ResourceUsage, float64(containerStats.DiskRead), "bytes", agentID)
-		metrics.RecordGauge("disk_write", metrics.ResourceUsage, float64(containerStats.DiskWrite), "bytes", agentID)
-		metrics.RecordGauge("net_rx", metrics.ResourceUsage, float64(containerStats.NetRx), "bytes", agentID)
-		metrics.RecordGauge("net_tx", metrics.ResourceUsage, float64(containerStats.NetTx), "bytes", agentID)
+	// Record metrics
+	metrics.RecordGauge("cpu_usage", metrics.ResourceUsage, cpuPercent, "percent", agentID)
+	metrics.RecordGauge("memory_usage", metrics.ResourceUsage, float64(memoryUsage), "bytes", agentID)
+	metrics.RecordGauge("memory_percent", metrics.ResourceUsage, memoryPercent, "percent", agentID)
+	metrics.RecordGauge("disk_read", metrics.ResourceUsage, float64(containerStats.DiskRead), "bytes", agentID)
+	metrics.RecordGauge("disk_write", metrics.ResourceUsage, float64(containerStats.DiskWrite), "bytes", agentID)
+	metrics.RecordGauge("net_rx", metrics.ResourceUsage, float64(containerStats.NetRx), "bytes", agentID)
+	metrics.RecordGauge("net_tx", metrics.ResourceUsage, float64(containerStats.NetTx), "bytes", agentID)
+	metrics.RecordGauge("disk_read_rate", metrics.ResourceUsage, containerStats.DiskReadRate, "bytes/s", agentID)
+	metrics.RecordGauge("disk_write_rate", metrics.ResourceUsage, containerStats.DiskWriteRate, "bytes/s", agentID)
+	metrics.RecordGauge("net_rx_rate", metrics.ResourceUsage, containerStats.NetRxRate, "bytes/s", agentID)
+	metrics.RecordGauge("net_tx_rate", metrics.ResourceUsage, containerStats.NetTxRate, "bytes/s", agentID)
+	metrics.RecordGauge("cpu_throttled", metrics.ResourceUsage, containerStats.ThrottledPercent, "percent", agentID)
+	if containerStats.CPULimit > 0 {
+		metrics.RecordGauge("cpu_limit_percent", metrics.ResourceUsage, containerStats.CPULimitPercent, "percent", agentID)
 	}
 }
 
@@ -282,6 +393,9 @@ func init() {
 		fmt.Printf("Failed to create container monitor: %v\n", err)
 		return
 	}
+	if idleInterval, err := time.ParseDuration(os.Getenv("CAPSULATE_MONITOR_IDLE_INTERVAL")); err == nil {
+		monitor.SetIdleInterval(idleInterval)
+	}
 
 	GlobalMonitor = monitor
 
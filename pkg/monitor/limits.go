@@ -0,0 +1,126 @@
+package monitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// Alerts raised for containers running into their resource limits
+const (
+	AlertCPUPinned       = "cpu_pinned"        // using nearly all of its CPU quota
+	AlertCPUThrottled    = "cpu_throttled"     // throttled in many CFS periods
+	AlertMemoryNearLimit = "memory_near_limit" // close to being OOM-killed
+)
+
+// Thresholds of the alerts, in percent
+const (
+	cpuPinnedPercent       = 95.0 // of the CPU limit
+	cpuThrottledPercent    = 25.0 // of the CFS periods of the sample
+	memoryNearLimitPercent = 90.0 // of the memory limit
+)
+
+// limitsRefresh is how long the limits read from a container are reused, so that
+// 'docker update' is picked up without inspecting every container on every round
+const limitsRefresh = time.Minute
+
+// resourceLimits are the CPU and memory limits of a container; zero means unlimited
+type resourceLimits struct {
+	cpus   float64
+	memory int64
+	readAt time.Time
+}
+
+// limits returns the resource limits of a container, inspecting it at most once per
+// limitsRefresh
+func (m *Monitor) limits(ctx context.Context, containerID string) (resourceLimits, error) {
+	m.mutex.RLock()
+	cached, ok := m.containerLimits[containerID]
+	m.mutex.RUnlock()
+	if ok && time.Since(cached.readAt) < limitsRefresh {
+		return cached, nil
+	}
+
+	inspect, err := m.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return resourceLimits{}, err
+	}
+	limits := resourceLimits{readAt: time.Now()}
+	if inspect.HostConfig != nil {
+		resources := inspect.HostConfig.Resources
+		switch {
+		case resources.NanoCPUs > 0:
+			limits.cpus = float64(resources.NanoCPUs) / 1e9
+		case resources.CPUQuota > 0:
+			period := resources.CPUPeriod
+			if period == 0 {
+				period = 100000 // the kernel's default CFS period, in microseconds
+			}
+			limits.cpus = float64(resources.CPUQuota) / float64(period)
+		}
+		limits.memory = resources.Memory
+	}
+
+	m.mutex.Lock()
+	m.containerLimits[containerID] = limits
+	m.mutex.Unlock()
+	return limits, nil
+}
+
+// applyLimits fills in the usage of a container relative to its limits, its CFS
+// throttling in the sample and the alerts it raises
+func applyLimits(stats *ContainerStats, limits resourceLimits, statsJSON *types.StatsJSON) {
+	throttling := statsJSON.CPUStats.ThrottlingData
+	stats.ThrottledPeriods = throttling.ThrottledPeriods
+	stats.ThrottledTime = time.Duration(throttling.ThrottledTime)
+	// The sample's share of throttled periods, or the lifetime share on the first one
+	periods := throttling.Periods - statsJSON.PreCPUStats.ThrottlingData.Periods
+	throttled := throttling.ThrottledPeriods - statsJSON.PreCPUStats.ThrottlingData.ThrottledPeriods
+	if statsJSON.PreCPUStats.ThrottlingData.Periods == 0 || throttling.Periods < statsJSON.PreCPUStats.ThrottlingData.Periods {
+		periods, throttled = throttling.Periods, throttling.ThrottledPeriods
+	}
+	if periods > 0 {
+		stats.ThrottledPercent = float64(throttled) / float64(periods) * 100
+	}
+
+	if limits.cpus > 0 {
+		stats.CPULimit = limits.cpus
+		stats.CPULimitPercent = stats.CPUUsage / limits.cpus
+		if stats.CPULimitPercent >= cpuPinnedPercent {
+			stats.Alerts = append(stats.Alerts, AlertCPUPinned)
+		}
+	}
+	if stats.ThrottledPercent >= cpuThrottledPercent {
+		stats.Alerts = append(stats.Alerts, AlertCPUThrottled)
+	}
+	if limits.memory > 0 {
+		stats.MemoryLimited = true
+		if stats.MemoryPercent >= memoryNearLimitPercent {
+			stats.Alerts = append(stats.Alerts, AlertMemoryNearLimit)
+		}
+	}
+}
+
+// memoryUsage returns the memory a container uses without the page cache it can give
+// back, as docker stats reports it: cgroup v2 reports it as inactive_file
+func memoryUsage(memory types.MemoryStats) int64 {
+	usage := memory.Usage
+	if inactive, ok := memory.Stats["inactive_file"]; ok && inactive < usage {
+		usage -= inactive
+	}
+	return int64(usage)
+}
+
+// blkioBytes returns the bytes read and written by a container
+func blkioBytes(blkio types.BlkioStats) (read, write int64) {
+	for _, entry := range blkio.IoServiceBytesRecursive {
+		switch entry.Op {
+		case "read", "Read":
+			read += int64(entry.Value)
+		case "write", "Write":
+			write += int64(entry.Value)
+		}
+	}
+	return read, write
+}
@@ -0,0 +1,135 @@
+package monitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// DefaultIdleInterval is the longest an idle container goes without being sampled
+const DefaultIdleInterval = time.Minute
+
+// busyCPUPercent is the CPU usage, in percent of one CPU, from which a container is
+// busy and sampled at every interval
+const busyCPUPercent = 10.0
+
+// pollSchedule is when a container is sampled next. Busy containers are sampled at the
+// monitor's interval; the interval of an idle one doubles at every sample, up to the
+// idle interval, so that dozens of idle agents cost few stats requests.
+type pollSchedule struct {
+	next     time.Time
+	interval time.Duration
+}
+
+// SetIdleInterval sets the longest an idle container goes without being sampled; it is
+// never shorter than the monitor's interval
+func (m *Monitor) SetIdleInterval(interval time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if interval < m.interval {
+		interval = m.interval
+	}
+	m.idleInterval = interval
+}
+
+// due reports whether a container is to be sampled; containers not sampled yet are
+func (m *Monitor) due(containerID string, now time.Time) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	schedule, ok := m.schedules[containerID]
+	return !ok || !now.Before(schedule.next)
+}
+
+// scheduleLocked schedules the next sample of a container from its latest stats;
+// callers must hold the mutex
+func (m *Monitor) scheduleLocked(containerID string, stats *ContainerStats) {
+	interval := m.interval
+	if stats.CPUUsage < busyCPUPercent && len(stats.Alerts) == 0 {
+		if previous, ok := m.schedules[containerID]; ok {
+			interval = previous.interval * 2
+		}
+		if interval > m.idleInterval {
+			interval = m.idleInterval
+		}
+	}
+	// Samples are taken on the monitor's ticks, so a container becomes due on the tick
+	// just before its interval is over
+	m.schedules[containerID] = pollSchedule{next: stats.Timestamp.Add(interval - m.interval/2), interval: interval}
+}
+
+// forgetLocked drops the stats, limits and schedule of a container; callers must hold
+// the mutex
+func (m *Monitor) forgetLocked(containerID string) {
+	delete(m.containerStats, containerID)
+	delete(m.containerLimits, containerID)
+	delete(m.schedules, containerID)
+}
+
+// watchEvents streams the lifecycle events of git-capsulate containers until ctx is
+// done, subscribing again after the monitor's interval when the stream fails
+func (m *Monitor) watchEvents(ctx context.Context) <-chan events.Message {
+	out := make(chan events.Message)
+	go func() {
+		for {
+			messages, errs := m.dockerClient.Events(ctx, types.EventsOptions{
+				Filters: filters.NewArgs(
+					filters.Arg("type", "container"),
+					filters.Arg("label", "capsulate.agent-id"),
+				),
+			})
+		stream:
+			for {
+				select {
+				case message := <-messages:
+					select {
+					case out <- message:
+					case <-ctx.Done():
+						return
+					}
+				case <-errs:
+					break stream
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-time.After(m.interval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// handleEvent samples a container as soon as it starts, is updated or runs out of
+// memory, rather than at its next poll, and forgets it once it is gone
+func (m *Monitor) handleEvent(ctx context.Context, event events.Message) {
+	id := event.Actor.ID
+	action := string(event.Action)
+	switch action {
+	case "start", "unpause", "update", "oom":
+		m.mutex.Lock()
+		if action == "update" {
+			delete(m.containerLimits, id) // its limits may have changed
+		}
+		delete(m.schedules, id)
+		m.mutex.Unlock()
+
+		container := types.Container{
+			ID:     id,
+			Names:  []string{"/" + event.Actor.Attributes["name"]},
+			Labels: event.Actor.Attributes,
+		}
+		if isCapsulateContainer(container.Names) {
+			m.collectContainer(ctx, container)
+		}
+	case "die", "destroy":
+		m.mutex.Lock()
+		m.forgetLocked(id)
+		m.mutex.Unlock()
+	}
+}
@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/webhook"
+)
+
+// Payload templates posting the message of an event to the incoming webhooks of
+// Slack and Microsoft Teams
+const (
+	slackPayload = `{"text": {{json .Message}}%s}`
+	teamsPayload = `{"@type": "MessageCard", "@context": "https://schema.org/extensions", "summary": {{json .Message}}, "text": {{json .Message}}}`
+)
+
+// Webhooks turns notifiers into the webhooks delivering their messages, so they are
+// sent, retried and reported like other webhooks
+func Webhooks(notifiers []config.NotifierConfig) []config.WebhookConfig {
+	hooks := make([]config.WebhookConfig, 0, len(notifiers))
+	for _, notifier := range notifiers {
+		payload := teamsPayload
+		if notifier.Type == config.NotifierSlack {
+			channel := ""
+			if notifier.Channel != "" {
+				channel = fmt.Sprintf(`, "channel": {{json %q}}`, notifier.Channel)
+			}
+			payload = fmt.Sprintf(slackPayload, channel)
+		}
+		events := notifier.Events
+		if len(events) == 0 {
+			events = config.NotifierEvents
+		}
+		hooks = append(hooks, config.WebhookConfig{
+			URL:     notifier.URL,
+			Events:  events,
+			Payload: payload,
+		})
+	}
+	return hooks
+}
+
+// Message summarizes an event for people supervising agents, e.g. "agent refactor-7
+// finished checks: 2 passed, 1 failed, diff +320/-90"
+func Message(event webhook.Event) string {
+	agent := "agent " + event.AgentID
+	if event.Project != "" {
+		agent += " (" + event.Project + ")"
+	}
+	data := event.Data
+
+	switch event.Event {
+	case config.EventAgentCreated:
+		if repo, _ := data["repo_url"].(string); repo != "" {
+			if branch, _ := data["branch"].(string); branch != "" {
+				repo += "@" + branch
+			}
+			return fmt.Sprintf("%s created from %s", agent, repo)
+		}
+		return agent + " created"
+
+	case config.EventAgentDestroyed:
+		return agent + " destroyed"
+
+	case config.EventCheckFailed, config.EventChecksFinished:
+		passed, _ := data["passed"].(int)
+		names, _ := data["failed"].([]string)
+		failed := len(names)
+		msg := fmt.Sprintf("%s finished checks: %d passed, %d failed", agent, passed, failed)
+		if event.Event == config.EventCheckFailed {
+			msg = fmt.Sprintf("%s failed checks %s: %d passed, %d failed", agent, strings.Join(names, ", "), passed, failed)
+		}
+		if additions, ok := data["additions"].(int); ok {
+			deletions, _ := data["deletions"].(int)
+			msg += fmt.Sprintf(", diff +%d/-%d", additions, deletions)
+		}
+		return msg
+
+	case config.EventJobFinished:
+		sched, _ := data["schedule"].(string)
+		msg := fmt.Sprintf("%s finished scheduled job %s", agent, sched)
+		switch {
+		case data["error"] != nil && data["error"] != "":
+			msg += fmt.Sprintf(": %v", data["error"])
+		case data["passed"] == true:
+			msg += ": passed"
+		default:
+			msg += fmt.Sprintf(": failed with exit code %v", data["exit_code"])
+		}
+		if ms, ok := data["duration_ms"].(int64); ok {
+			msg += " in " + (time.Duration(ms) * time.Millisecond).Round(100*time.Millisecond).String()
+		}
+		return msg
+	}
+	return fmt.Sprintf("%s: %s", agent, event.Event)
+}
@@ -0,0 +1,332 @@
+// Package reports parses the result files written by test runners, linters and
+// coverage tools into summaries kept with check results.
+package reports
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxFailures is the number of failed test names kept in a summary
+const MaxFailures = 20
+
+// Formats of report files
+const (
+	FormatJUnit      = "junit"
+	FormatCheckstyle = "checkstyle"
+	FormatCobertura  = "cobertura"
+	FormatLCOV       = "lcov"
+	FormatGoCover    = "gocover"
+)
+
+// Summary is the combined content of the report files of a check run
+type Summary struct {
+	// Files are the report files parsed, relative to the repository root
+	Files    []string         `json:"files"`
+	Tests    *TestSummary     `json:"tests,omitempty"`
+	Lint     *LintSummary     `json:"lint,omitempty"`
+	Coverage *CoverageSummary `json:"coverage,omitempty"`
+	// Errors describe report files that could not be parsed
+	Errors []string `json:"errors,omitempty"`
+}
+
+// TestSummary counts the test cases of JUnit XML reports
+type TestSummary struct {
+	Total   int `json:"total"`
+	Passed  int `json:"passed"`
+	Failed  int `json:"failed"` // failures and errors
+	Skipped int `json:"skipped"`
+	// Failures name the first MaxFailures failed tests
+	Failures []string `json:"failures,omitempty"`
+}
+
+// LintSummary counts the issues of Checkstyle XML reports
+type LintSummary struct {
+	Files    int `json:"files"` // files with issues
+	Errors   int `json:"errors"`
+	Warnings int `json:"warnings"`
+	Infos    int `json:"infos"`
+}
+
+// CoverageSummary counts the lines (statements for Go) covered by tests
+type CoverageSummary struct {
+	Covered int64 `json:"covered"`
+	Total   int64 `json:"total"`
+}
+
+// Percent returns the coverage as a percentage
+func (c *CoverageSummary) Percent() float64 {
+	if c.Total == 0 {
+		return 0
+	}
+	return float64(c.Covered) * 100 / float64(c.Total)
+}
+
+// Add parses a report file, detecting its format from its content, and adds it to the
+// summary. A file in an unknown or malformed format is recorded in Errors.
+func (s *Summary) Add(name string, data []byte) {
+	s.Files = append(s.Files, name)
+	var err error
+	switch format := Detect(data); format {
+	case FormatJUnit:
+		err = s.addJUnit(data)
+	case FormatCheckstyle:
+		err = s.addCheckstyle(data)
+	case FormatCobertura:
+		err = s.addCobertura(data)
+	case FormatLCOV:
+		err = s.addLCOV(data)
+	case FormatGoCover:
+		err = s.addGoCover(data)
+	default:
+		err = fmt.Errorf("unrecognized report format")
+	}
+	if err != nil {
+		s.Errors = append(s.Errors, fmt.Sprintf("%s: %v", name, err))
+	}
+}
+
+// String summarizes the reports on one line, e.g. "42 tests: 40 passed, 1 failed,
+// 1 skipped; 3 lint errors, 5 warnings; coverage 81.3%"
+func (s *Summary) String() string {
+	var parts []string
+	if t := s.Tests; t != nil {
+		parts = append(parts, fmt.Sprintf("%d tests: %d passed, %d failed, %d skipped", t.Total, t.Passed, t.Failed, t.Skipped))
+	}
+	if l := s.Lint; l != nil {
+		parts = append(parts, fmt.Sprintf("%d lint errors, %d warnings", l.Errors, l.Warnings))
+	}
+	if c := s.Coverage; c != nil {
+		parts = append(parts, fmt.Sprintf("coverage %.1f%%", c.Percent()))
+	}
+	if len(s.Errors) > 0 {
+		parts = append(parts, fmt.Sprintf("%d unreadable reports", len(s.Errors)))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Detect returns the format of a report file, or ""
+func Detect(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("mode:")) {
+		return FormatGoCover
+	}
+	if bytes.HasPrefix(trimmed, []byte("TN:")) || bytes.HasPrefix(trimmed, []byte("SF:")) {
+		return FormatLCOV
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(trimmed))
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return ""
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			switch start.Name.Local {
+			case "testsuites", "testsuite":
+				return FormatJUnit
+			case "checkstyle":
+				return FormatCheckstyle
+			case "coverage":
+				return FormatCobertura
+			}
+			return ""
+		}
+	}
+}
+
+// junitSuite is a <testsuites> or <testsuite> element; suites may nest
+type junitSuite struct {
+	Suites []junitSuite `xml:"testsuite"`
+	Cases  []struct {
+		Name      string    `xml:"name,attr"`
+		Classname string    `xml:"classname,attr"`
+		Failure   *struct{} `xml:"failure"`
+		Error     *struct{} `xml:"error"`
+		Skipped   *struct{} `xml:"skipped"`
+	} `xml:"testcase"`
+}
+
+// addJUnit adds the test cases of a JUnit XML report
+func (s *Summary) addJUnit(data []byte) error {
+	var root junitSuite
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+	if s.Tests == nil {
+		s.Tests = &TestSummary{}
+	}
+	var walk func(suite junitSuite)
+	walk = func(suite junitSuite) {
+		for _, c := range suite.Cases {
+			s.Tests.Total++
+			switch {
+			case c.Failure != nil || c.Error != nil:
+				s.Tests.Failed++
+				if len(s.Tests.Failures) < MaxFailures {
+					name := c.Name
+					if c.Classname != "" {
+						name = c.Classname + "." + c.Name
+					}
+					s.Tests.Failures = append(s.Tests.Failures, name)
+				}
+			case c.Skipped != nil:
+				s.Tests.Skipped++
+			default:
+				s.Tests.Passed++
+			}
+		}
+		for _, child := range suite.Suites {
+			walk(child)
+		}
+	}
+	walk(root)
+	return nil
+}
+
+// addCheckstyle adds the issues of a Checkstyle XML report
+func (s *Summary) addCheckstyle(data []byte) error {
+	var report struct {
+		Files []struct {
+			Errors []struct {
+				Severity string `xml:"severity,attr"`
+			} `xml:"error"`
+		} `xml:"file"`
+	}
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return err
+	}
+	if s.Lint == nil {
+		s.Lint = &LintSummary{}
+	}
+	for _, file := range report.Files {
+		if len(file.Errors) > 0 {
+			s.Lint.Files++
+		}
+		for _, issue := range file.Errors {
+			switch strings.ToLower(issue.Severity) {
+			case "warning":
+				s.Lint.Warnings++
+			case "info", "ignore":
+				s.Lint.Infos++
+			default:
+				s.Lint.Errors++
+			}
+		}
+	}
+	return nil
+}
+
+// addCobertura adds the line coverage of a Cobertura XML report
+func (s *Summary) addCobertura(data []byte) error {
+	var report struct {
+		LinesValid   int64 `xml:"lines-valid,attr"`
+		LinesCovered int64 `xml:"lines-covered,attr"`
+		Packages     []struct {
+			Classes []struct {
+				Lines []struct {
+					Hits int64 `xml:"hits,attr"`
+				} `xml:"lines>line"`
+			} `xml:"classes>class"`
+		} `xml:"packages>package"`
+	}
+	if err := xml.Unmarshal(data, &report); err != nil {
+		return err
+	}
+	covered, total := report.LinesCovered, report.LinesValid
+	if total == 0 {
+		// Older writers leave out the totals; count the lines instead
+		for _, pkg := range report.Packages {
+			for _, class := range pkg.Classes {
+				for _, line := range class.Lines {
+					total++
+					if line.Hits > 0 {
+						covered++
+					}
+				}
+			}
+		}
+	}
+	s.addCoverage(covered, total)
+	return nil
+}
+
+// addLCOV adds the line coverage of an LCOV tracefile
+func (s *Summary) addLCOV(data []byte) error {
+	var covered, total int64
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		n, _ := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		switch key {
+		case "LF":
+			total += n
+		case "LH":
+			covered += n
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	s.addCoverage(covered, total)
+	return nil
+}
+
+// addGoCover adds the statement coverage of a Go coverage profile. Blocks listed
+// more than once, as with -coverpkg, count once.
+func (s *Summary) addGoCover(data []byte) error {
+	type block struct{ statements, count int64 }
+	blocks := make(map[string]block)
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadString('\n')
+		fields := strings.Fields(line)
+		if len(fields) == 3 && !strings.HasPrefix(line, "mode:") {
+			statements, err1 := strconv.ParseInt(fields[1], 10, 64)
+			count, err2 := strconv.ParseInt(fields[2], 10, 64)
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("malformed coverage line %q", strings.TrimSpace(line))
+			}
+			b := blocks[fields[0]]
+			b.statements = statements
+			if count > b.count {
+				b.count = count
+			}
+			blocks[fields[0]] = b
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var covered, total int64
+	for _, b := range blocks {
+		total += b.statements
+		if b.count > 0 {
+			covered += b.statements
+		}
+	}
+	s.addCoverage(covered, total)
+	return nil
+}
+
+// addCoverage adds covered and total lines to the coverage summary
+func (s *Summary) addCoverage(covered, total int64) {
+	if s.Coverage == nil {
+		s.Coverage = &CoverageSummary{}
+	}
+	s.Coverage.Covered += covered
+	s.Coverage.Total += total
+}
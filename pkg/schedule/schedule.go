@@ -0,0 +1,258 @@
+package schedule
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxHistory is the number of executions kept with each schedule
+const MaxHistory = 20
+
+// validName matches schedule IDs given by the user
+var validName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]{0,62}$`)
+
+// Schedule is a command run in an agent whenever its cron expression fires
+type Schedule struct {
+	ID        string    `json:"id"`
+	AgentID   string    `json:"agent_id"`
+	Cron      string    `json:"cron"`
+	Command   []string  `json:"command"`
+	CreatedAt time.Time `json:"created_at"`
+	// LastRun is the start of the most recent execution
+	LastRun time.Time `json:"last_run,omitempty"`
+	// History holds the most recent executions, oldest first
+	History []Execution `json:"history,omitempty"`
+}
+
+// Execution records one run of a schedule's command
+type Execution struct {
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration_ns"`
+	ExitCode  int           `json:"exit_code"`
+	Passed    bool          `json:"passed"`
+	Output    string        `json:"output,omitempty"`
+	// Error describes a failure to run the command at all, e.g. a stopped agent
+	Error string `json:"error,omitempty"`
+}
+
+// Next returns the first time after the schedule last ran (or was created) at which
+// it fires, or the zero time if its expression never fires
+func (s *Schedule) Next() (time.Time, error) {
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return time.Time{}, err
+	}
+	from := s.CreatedAt
+	if s.LastRun.After(from) {
+		from = s.LastRun
+	}
+	return cron.Next(from.Local()), nil
+}
+
+// Due reports whether the schedule should run at now. A schedule that missed several
+// times, e.g. because no scheduler was running, runs once.
+func (s *Schedule) Due(now time.Time) bool {
+	next, err := s.Next()
+	return err == nil && !next.IsZero() && !next.After(now)
+}
+
+// Store persists schedules as one JSON file per schedule
+type Store struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewStore creates a store rooted at <dataDir>/schedules, where dataDir is the
+// project's data directory (see config.DataDir)
+func NewStore(dataDir string) (*Store, error) {
+	dir := filepath.Join(dataDir, "schedules")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create schedule directory %s: %v", dir, err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Add validates and saves a new schedule. A random ID is assigned when s.ID is empty.
+func (s *Store) Add(sched *Schedule) error {
+	if _, err := ParseCron(sched.Cron); err != nil {
+		return err
+	}
+	if len(sched.Command) == 0 {
+		return fmt.Errorf("schedule has no command")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if sched.ID == "" {
+		id, err := randomID()
+		if err != nil {
+			return err
+		}
+		sched.ID = id
+	} else if !validName.MatchString(sched.ID) {
+		return fmt.Errorf("invalid schedule name '%s': use letters, digits, '.', '_' and '-'", sched.ID)
+	}
+	if _, err := os.Stat(s.path(sched.ID)); err == nil {
+		return fmt.Errorf("schedule '%s' already exists", sched.ID)
+	}
+	if sched.CreatedAt.IsZero() {
+		sched.CreatedAt = time.Now()
+	}
+	return s.write(sched)
+}
+
+// Get returns a schedule by ID
+func (s *Store) Get(id string) (*Schedule, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.read(id)
+}
+
+// List returns the schedules of an agent, or all schedules when agentID is empty,
+// sorted by agent and ID
+func (s *Store) List(agentID string) ([]*Schedule, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule directory: %v", err)
+	}
+
+	var schedules []*Schedule
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		sched, err := s.read(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if agentID == "" || sched.AgentID == agentID {
+			schedules = append(schedules, sched)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		if schedules[i].AgentID != schedules[j].AgentID {
+			return schedules[i].AgentID < schedules[j].AgentID
+		}
+		return schedules[i].ID < schedules[j].ID
+	})
+	return schedules, nil
+}
+
+// Remove deletes a schedule
+func (s *Store) Remove(id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !validName.MatchString(id) {
+		return fmt.Errorf("schedule '%s' not found", id)
+	}
+	if err := os.Remove(s.path(id)); err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("schedule '%s' not found", id)
+		}
+		return fmt.Errorf("failed to remove schedule '%s': %v", id, err)
+	}
+	return nil
+}
+
+// RemoveAgent deletes every schedule of an agent, e.g. when it is destroyed, and
+// returns the IDs of those removed
+func (s *Store) RemoveAgent(agentID string) ([]string, error) {
+	schedules, err := s.List(agentID)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, sched := range schedules {
+		if err := s.Remove(sched.ID); err != nil {
+			return removed, err
+		}
+		removed = append(removed, sched.ID)
+	}
+	return removed, nil
+}
+
+// Record appends an execution to a schedule's history, dropping the oldest beyond
+// MaxHistory, and sets its last run. Schedules removed meanwhile are left removed.
+func (s *Store) Record(id string, exec Execution) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sched, err := s.read(id)
+	if err != nil {
+		if _, statErr := os.Stat(s.path(id)); os.IsNotExist(statErr) {
+			return nil
+		}
+		return err
+	}
+	sched.LastRun = exec.StartedAt
+	sched.History = append(sched.History, exec)
+	if len(sched.History) > MaxHistory {
+		sched.History = sched.History[len(sched.History)-MaxHistory:]
+	}
+	return s.write(sched)
+}
+
+// read loads a schedule; the caller must hold the mutex
+func (s *Store) read(id string) (*Schedule, error) {
+	if !validName.MatchString(id) {
+		return nil, fmt.Errorf("schedule '%s' not found", id)
+	}
+	data, err := os.ReadFile(s.path(id))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("schedule '%s' not found", id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule '%s': %v", id, err)
+	}
+
+	var sched Schedule
+	if err := json.Unmarshal(data, &sched); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule '%s': %v", id, err)
+	}
+	return &sched, nil
+}
+
+// write saves a schedule atomically; the caller must hold the mutex
+func (s *Store) write(sched *Schedule) error {
+	data, err := json.MarshalIndent(sched, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal schedule '%s': %v", sched.ID, err)
+	}
+
+	tmp := s.path(sched.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write schedule '%s': %v", sched.ID, err)
+	}
+	if err := os.Rename(tmp, s.path(sched.ID)); err != nil {
+		return fmt.Errorf("failed to write schedule '%s': %v", sched.ID, err)
+	}
+	return nil
+}
+
+// path returns the file of a schedule
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// randomID returns a random 8-character hex schedule ID
+func randomID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate schedule ID: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,176 @@
+// Package scrub finds credentials in the files agents leave behind, so they can be
+// shared or archived safely.
+package scrub
+
+import (
+	"bytes"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// credentialNames are files that only hold credentials and are removed whole
+var credentialNames = map[string]bool{
+	".git-credentials": true,
+	".netrc":           true,
+	"_netrc":           true,
+	".pypirc":          true,
+	"id_rsa":           true,
+	"id_dsa":           true,
+	"id_ecdsa":         true,
+	"id_ed25519":       true,
+}
+
+// Npmrc is the npm configuration file, scrubbed of its auth entries only
+const Npmrc = ".npmrc"
+
+// npmrcAuth matches the auth entries of an .npmrc file
+var npmrcAuth = regexp.MustCompile(`(^|:)(_authToken|_auth|_password)\s*=`)
+
+// lockfiles are skipped when looking for secrets: their hashes are high-entropy
+var lockfiles = map[string]bool{
+	"package-lock.json": true,
+	"yarn.lock":         true,
+	"pnpm-lock.yaml":    true,
+	"go.sum":            true,
+	"Cargo.lock":        true,
+	"poetry.lock":       true,
+	"Pipfile.lock":      true,
+}
+
+// Kinds of secrets found by ScanDiff
+const (
+	KindPrivateKey  = "private key"
+	KindAWSKey      = "AWS access key"
+	KindGitHubToken = "GitHub token"
+	KindSlackToken  = "Slack token"
+	KindHighEntropy = "high-entropy value"
+)
+
+// secretPatterns recognize well-known credential formats
+var secretPatterns = []struct {
+	kind    string
+	pattern *regexp.Regexp
+}{
+	{KindPrivateKey, regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+	{KindAWSKey, regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{KindGitHubToken, regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{KindSlackToken, regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+}
+
+// secretAssignment matches a value assigned to a secret-looking key, e.g.
+// api_key = "..." or "password": "..."
+var secretAssignment = regexp.MustCompile(`(?i)(key|token|secret|passw(or)?d|credential|auth)[\w.-]*["']?\s*[:=]\s*["']?([A-Za-z0-9+/=_.~-]{20,})`)
+
+// minEntropy is the Shannon entropy, in bits per character, above which an assigned
+// value is considered random enough to be a secret
+const minEntropy = 4.0
+
+// Secret is a likely credential found in a diff
+type Secret struct {
+	Path string `json:"path"`
+	Line int    `json:"line"`
+	Kind string `json:"kind"`
+}
+
+// CredentialFile reports whether the file at p only holds credentials and should be
+// removed before an agent's files are shared
+func CredentialFile(p string) bool {
+	return credentialNames[path.Base(p)]
+}
+
+// CredentialNames returns the names of the files CredentialFile matches, and .npmrc
+func CredentialNames() []string {
+	names := []string{Npmrc}
+	for name := range credentialNames {
+		names = append(names, name)
+	}
+	return names
+}
+
+// ScrubNpmrc removes the auth entries of an .npmrc file, reporting whether there were any
+func ScrubNpmrc(data []byte) ([]byte, bool) {
+	var out bytes.Buffer
+	scrubbed := false
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if npmrcAuth.MatchString(strings.TrimSpace(line)) {
+			scrubbed = true
+			continue
+		}
+		out.WriteString(line)
+	}
+	return out.Bytes(), scrubbed
+}
+
+// ScanDiff looks for credentials in the lines a unified diff adds: well-known token
+// formats and private keys, and high-entropy values assigned to secret-looking keys.
+// Lockfiles are skipped.
+func ScanDiff(diff string) []Secret {
+	var secrets []Secret
+	file, line := "", 0
+	for _, text := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(text, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(text, "+++ "), "b/")
+			if file == "/dev/null" {
+				file = ""
+			}
+		case strings.HasPrefix(text, "@@ "):
+			line = hunkStart(text)
+		case strings.HasPrefix(text, "+"):
+			if file != "" && !lockfiles[path.Base(file)] {
+				if kind := ScanLine(text[1:]); kind != "" {
+					secrets = append(secrets, Secret{Path: file, Line: line, Kind: kind})
+				}
+			}
+			line++
+		case strings.HasPrefix(text, " "):
+			line++
+		}
+	}
+	return secrets
+}
+
+// ScanLine returns the kind of credential a line holds, or ""
+func ScanLine(text string) string {
+	for _, p := range secretPatterns {
+		if p.pattern.MatchString(text) {
+			return p.kind
+		}
+	}
+	for _, match := range secretAssignment.FindAllStringSubmatch(text, -1) {
+		if entropy(match[3]) >= minEntropy {
+			return KindHighEntropy
+		}
+	}
+	return ""
+}
+
+// hunkStart returns the first line number of the new side of a hunk header such as
+// "@@ -1,4 +1,5 @@"
+func hunkStart(header string) int {
+	fields := strings.Fields(header)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return 0
+	}
+	start, _, _ := strings.Cut(fields[2][1:], ",")
+	n, _ := strconv.Atoi(start)
+	return n
+}
+
+// entropy returns the Shannon entropy of s in bits per character
+func entropy(s string) float64 {
+	counts := make(map[rune]int)
+	for _, r := range s {
+		counts[r]++
+	}
+	var h float64
+	n := float64(len(s))
+	for _, count := range counts {
+		p := float64(count) / n
+		h -= p * math.Log2(p)
+	}
+	return h
+}
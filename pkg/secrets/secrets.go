@@ -0,0 +1,54 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Resolve returns the value of a secret reference. Secrets are never stored in
+// capsulate.yaml itself; the configuration refers to them instead:
+//
+//	env:NAME   the host environment variable NAME
+//	file:PATH  the contents of a host file (~ expands to the home directory)
+func Resolve(ref string) (string, error) {
+	scheme, value, ok := strings.Cut(ref, ":")
+	if !ok || value == "" {
+		return "", fmt.Errorf("invalid secret reference '%s': expected env:NAME or file:PATH", ref)
+	}
+
+	switch scheme {
+	case "env":
+		secret, ok := os.LookupEnv(value)
+		if !ok || secret == "" {
+			return "", fmt.Errorf("secret environment variable %s is not set", value)
+		}
+		return secret, nil
+
+	case "file":
+		if strings.HasPrefix(value, "~/") {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", fmt.Errorf("failed to resolve home directory: %v", err)
+			}
+			value = filepath.Join(home, value[2:])
+		}
+		data, err := os.ReadFile(value)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file %s: %v", value, err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	return "", fmt.Errorf("unsupported secret reference scheme '%s' in '%s'", scheme, ref)
+}
+
+// Validate checks the syntax of a secret reference without resolving it
+func Validate(ref string) error {
+	scheme, value, ok := strings.Cut(ref, ":")
+	if !ok || value == "" || (scheme != "env" && scheme != "file") {
+		return fmt.Errorf("invalid secret reference '%s': expected env:NAME or file:PATH", ref)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CommandRecord records a command run in an agent by a user, a schedule or a CI job
+type CommandRecord struct {
+	Command   string        `json:"command"`
+	ExitCode  int           `json:"exit_code"`
+	StartedAt time.Time     `json:"started_at"`
+	Duration  time.Duration `json:"duration_ns"`
+}
+
+// CommitRecord is a commit made in an agent
+type CommitRecord struct {
+	SHA     string `json:"sha"`
+	Subject string `json:"subject"`
+}
+
+// ResourceTotals is the resource usage of an agent container over its lifetime
+type ResourceTotals struct {
+	CPUSeconds float64 `json:"cpu_seconds"`
+	// PeakMemory is the highest memory usage, or the last one where the kernel does
+	// not report a peak
+	PeakMemory int64 `json:"peak_memory_bytes"`
+	DiskRead   int64 `json:"disk_read_bytes"`
+	DiskWrite  int64 `json:"disk_write_bytes"`
+	NetRx      int64 `json:"network_rx_bytes"`
+	NetTx      int64 `json:"network_tx_bytes"`
+	// WritableLayer is the size of the container's writable layer
+	WritableLayer int64 `json:"writable_layer_bytes"`
+}
+
+// Check statuses of a run summary
+const (
+	ChecksNone   = "none"
+	ChecksPassed = "passed"
+	ChecksFailed = "failed"
+)
+
+// RunSummary records what an agent did between its creation and its destruction
+type RunSummary struct {
+	// ID names the summary: the agent ID and the time the run ended
+	ID       string            `json:"id"`
+	AgentID  string            `json:"agent_id"`
+	RepoURL  string            `json:"repo_url,omitempty"`
+	Branch   string            `json:"branch,omitempty"`
+	Template string            `json:"template,omitempty"`
+	TeamID   string            `json:"team_id,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+
+	StartedAt time.Time     `json:"started_at"`
+	EndedAt   time.Time     `json:"ended_at"`
+	Duration  time.Duration `json:"duration_ns"`
+
+	// Commands holds the most recent commands and CommandCount the number run in total
+	Commands     []CommandRecord `json:"commands,omitempty"`
+	CommandCount int             `json:"command_count"`
+
+	// Commits are the commits made on top of StartCommit, newest first, up to a limit;
+	// CommitCount is their total number
+	StartCommit string         `json:"start_commit,omitempty"`
+	HeadCommit  string         `json:"head_commit,omitempty"`
+	Commits     []CommitRecord `json:"commits,omitempty"`
+	CommitCount int            `json:"commit_count"`
+
+	// ChecksStatus summarizes the most recent check run: none, passed or failed
+	ChecksStatus string `json:"checks_status"`
+	ChecksPassed int    `json:"checks_passed"`
+	ChecksFailed int    `json:"checks_failed"`
+
+	Resources ResourceTotals `json:"resources"`
+
+	// TrashEntry is the trash entry holding the agent's files, if they were trashed
+	TrashEntry string `json:"trash_entry,omitempty"`
+}
+
+// historyDir returns the directory holding run summaries, next to the agent states
+func (s *Store) historyDir() string {
+	return filepath.Join(filepath.Dir(s.dir), "history")
+}
+
+// SaveSummary records the summary of a finished agent run
+func (s *Store) SaveSummary(summary *RunSummary) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.MkdirAll(s.historyDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create history directory: %v", err)
+	}
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal run summary '%s': %v", summary.ID, err)
+	}
+	path := filepath.Join(s.historyDir(), summary.ID+".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write run summary '%s': %v", summary.ID, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write run summary '%s': %v", summary.ID, err)
+	}
+	return nil
+}
+
+// Summaries returns the recorded run summaries, most recent first
+func (s *Store) Summaries() ([]*RunSummary, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := os.ReadDir(s.historyDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history directory: %v", err)
+	}
+
+	var summaries []*RunSummary
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.historyDir(), entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read run summary %s: %v", entry.Name(), err)
+		}
+		var summary RunSummary
+		if err := json.Unmarshal(data, &summary); err != nil {
+			return nil, fmt.Errorf("failed to parse run summary %s: %v", entry.Name(), err)
+		}
+		summaries = append(summaries, &summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].EndedAt.After(summaries[j].EndedAt) })
+	return summaries, nil
+}
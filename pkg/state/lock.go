@@ -0,0 +1,64 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrAgentNotFound is returned (wrapped) when an operation targets an agent without
+// recorded state
+var ErrAgentNotFound = errors.New("agent not found")
+
+// ErrAgentBusy is returned (wrapped) when another process holds an agent's lock
+var ErrAgentBusy = errors.New("agent is busy")
+
+// Lock takes the host-wide lock of an agent, held while it is created, destroyed or
+// reset (recreated, recovered or restored from the trash) so that two git-capsulate
+// processes cannot race on the same ID. operation says what the holder does, e.g.
+// "recreated", for the busy error of other processes. Lock does not wait: when
+// another process holds the lock, an error wrapping ErrAgentBusy names that process
+// and its operation. The returned function releases the lock.
+func (s *Store) Lock(agentID, operation string) (func(), error) {
+	dir := filepath.Join(filepath.Dir(s.dir), "locks")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory %s: %v", dir, err)
+	}
+
+	path := filepath.Join(dir, agentID+".lock")
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock for agent '%s': %v", agentID, err)
+	}
+
+	locked, err := tryLock(file)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock agent '%s': %v", agentID, err)
+	}
+	if !locked {
+		holder, _ := os.ReadFile(path)
+		file.Close()
+		pid, held, _ := strings.Cut(strings.TrimSpace(string(holder)), " ")
+		if held == "" {
+			held = "changed"
+		}
+		if pid, err := strconv.Atoi(pid); err == nil {
+			return nil, fmt.Errorf("%w: '%s' is being %s by process %d", ErrAgentBusy, agentID, held, pid)
+		}
+		return nil, fmt.Errorf("%w: '%s' is being %s by another process", ErrAgentBusy, agentID, held)
+	}
+
+	// Record the holder for the busy error of other processes
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())+" "+operation+"\n"), 0)
+
+	return func() {
+		file.Truncate(0)
+		unlock(file)
+		file.Close()
+	}, nil
+}
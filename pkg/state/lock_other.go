@@ -0,0 +1,24 @@
+//go:build !unix
+
+package state
+
+import "os"
+
+// tryLock always succeeds on platforms without flock, where agents are only protected
+// by the Manager's in-process locks
+func tryLock(file *os.File) (bool, error) {
+	return true, nil
+}
+
+// unlock is a no-op on platforms without flock
+func unlock(file *os.File) {}
+
+// waitLock always succeeds on platforms without flock
+func waitLock(file *os.File) error {
+	return nil
+}
+
+// processAlive cannot tell on platforms without signals and assumes every process runs
+func processAlive(pid int) bool {
+	return true
+}
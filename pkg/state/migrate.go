@@ -0,0 +1,208 @@
+package state
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+)
+
+// SchemaVersion is the version of the agent state format and .capsulate layout written
+// by this release. Workspaces written by older releases are migrated to it; those
+// written by newer ones are refused.
+const SchemaVersion = 1
+
+// LayoutFile records the schema version of a workspace's .capsulate directory
+const LayoutFile = "layout.json"
+
+// ErrNewerSchema is returned (wrapped) for state written by a newer git-capsulate
+var ErrNewerSchema = errors.New("state was written by a newer version of git-capsulate")
+
+// Layout is the content of LayoutFile
+type Layout struct {
+	SchemaVersion int       `json:"schema_version"`
+	MigratedAt    time.Time `json:"migrated_at"`
+}
+
+// Migration upgrades a workspace from the previous schema version to Version
+type Migration struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	// apply migrates the .capsulate directory of a workspace; it must be safe to run
+	// again after a partial failure
+	apply func(workspaceDir string) error
+}
+
+// migrations upgrade workspaces one version at a time, in order. Unversioned
+// workspaces, from before the layout was versioned, are version 0.
+var migrations = []Migration{
+	{
+		Version:     1,
+		Description: "record the schema version in agent state files",
+		apply:       stampAgentStates,
+	},
+}
+
+// WorkspaceVersion returns the schema version of a workspace's .capsulate directory:
+// 0 for workspaces created before the layout was versioned
+func WorkspaceVersion(workspaceDir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(workspaceDir, ".capsulate", LayoutFile))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read layout version: %v", err)
+	}
+	var layout Layout
+	if err := json.Unmarshal(data, &layout); err != nil {
+		return 0, fmt.Errorf("failed to parse %s: %v", LayoutFile, err)
+	}
+	return layout.SchemaVersion, nil
+}
+
+// PendingMigrations returns the migrations a workspace needs, in the order they run.
+// An error wrapping ErrNewerSchema is returned for workspaces from a newer release.
+func PendingMigrations(workspaceDir string) ([]Migration, error) {
+	current, err := WorkspaceVersion(workspaceDir)
+	if err != nil {
+		return nil, err
+	}
+	if current > SchemaVersion {
+		return nil, fmt.Errorf("%w: the workspace is at schema version %d, this release supports up to %d", ErrNewerSchema, current, SchemaVersion)
+	}
+	var pending []Migration
+	for _, migration := range migrations {
+		if migration.Version > current {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate brings a workspace's .capsulate directory to SchemaVersion, returning the
+// migrations applied. The version is recorded after each migration so an interrupted
+// upgrade resumes where it stopped. Concurrent git-capsulate processes wait for the
+// migration to end.
+func Migrate(workspaceDir string) ([]Migration, error) {
+	root := filepath.Join(workspaceDir, ".capsulate")
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory %s: %v", root, err)
+	}
+	pending, err := PendingMigrations(workspaceDir)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+
+	release, err := lockMigration(root)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Another process may have migrated the workspace while we waited
+	if pending, err = PendingMigrations(workspaceDir); err != nil {
+		return nil, err
+	}
+	for i, migration := range pending {
+		if err := migration.apply(workspaceDir); err != nil {
+			return pending[:i], fmt.Errorf("failed to migrate the workspace to schema version %d (%s): %v", migration.Version, migration.Description, err)
+		}
+		if err := writeLayout(root, migration.Version); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+// lockMigration takes the workspace-wide migration lock, waiting for another process
+// holding it. The returned function releases the lock.
+func lockMigration(root string) (func(), error) {
+	file, err := os.OpenFile(filepath.Join(root, "migrate.lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migration lock: %v", err)
+	}
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to take migration lock: %v", err)
+		}
+		if locked {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return func() {
+		unlock(file)
+		file.Close()
+	}, nil
+}
+
+// writeLayout records the schema version of a .capsulate directory atomically
+func writeLayout(root string, version int) error {
+	data, err := json.MarshalIndent(Layout{SchemaVersion: version, MigratedAt: time.Now().UTC()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal layout version: %v", err)
+	}
+	path := filepath.Join(root, LayoutFile)
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write layout version: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write layout version: %v", err)
+	}
+	return nil
+}
+
+// stampAgentStates rewrites the agent state files of every project with their schema
+// version, keeping fields this release does not know
+func stampAgentStates(workspaceDir string) error {
+	projects, err := config.Projects(workspaceDir)
+	if err != nil {
+		return err
+	}
+	for _, project := range projects {
+		dir := filepath.Join(config.DataDir(workspaceDir, project), "state", "agents")
+		entries, err := os.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read state directory: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %v", path, err)
+			}
+			// Numbers are kept as written rather than converted to float64
+			var fields map[string]interface{}
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.UseNumber()
+			if err := decoder.Decode(&fields); err != nil {
+				return fmt.Errorf("failed to parse %s: %v", path, err)
+			}
+			fields["schema_version"] = 1
+			if data, err = json.MarshalIndent(fields, "", "  "); err != nil {
+				return fmt.Errorf("failed to marshal %s: %v", path, err)
+			}
+			if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %v", path, err)
+			}
+			if err := os.Rename(path+".tmp", path); err != nil {
+				return fmt.Errorf("failed to write %s: %v", path, err)
+			}
+		}
+	}
+	return nil
+}
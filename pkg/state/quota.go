@@ -0,0 +1,169 @@
+package state
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrQuotaExceeded is returned (wrapped) when a caller is over one of its quotas
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// quotaWindow is the period agents_per_hour counts creates over
+const quotaWindow = time.Hour
+
+// callerUsage is what a caller has used of its quotas
+type callerUsage struct {
+	// Creates are the times the caller created agents within the last hour
+	Creates []time.Time `json:"creates,omitempty"`
+	// Execs are the commands the caller is running
+	Execs []execSlot `json:"execs,omitempty"`
+}
+
+// execSlot is a command a caller is running, held by the process running it
+type execSlot struct {
+	ID        string    `json:"id"`
+	PID       int       `json:"pid"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// ReserveCreate counts an agent the caller is creating against the limit of agents it
+// may create per hour. When it created limit agents within the last hour, it returns
+// an error wrapping ErrQuotaExceeded that says when the next may be created. A limit
+// of zero is unlimited.
+func (s *Store) ReserveCreate(caller string, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	return s.updateQuotas(func(usage map[string]*callerUsage) error {
+		u := usageOf(usage, caller)
+		if len(u.Creates) >= limit {
+			retry := u.Creates[len(u.Creates)-limit].Add(quotaWindow).Sub(time.Now()).Round(time.Second)
+			return fmt.Errorf("%w: caller '%s' created %d agent(s) in the last hour (limit %d); try again in %s",
+				ErrQuotaExceeded, caller, len(u.Creates), limit, retry)
+		}
+		u.Creates = append(u.Creates, time.Now().UTC())
+		return nil
+	})
+}
+
+// ReserveExec takes one of the limit commands the caller may run at once. When it runs
+// limit commands already, it returns an error wrapping ErrQuotaExceeded. Commands of
+// processes that have exited no longer count. The returned function gives the slot
+// back; a limit of zero is unlimited.
+func (s *Store) ReserveExec(caller string, limit int) (func(), error) {
+	if limit <= 0 {
+		return func() {}, nil
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	slot := execSlot{ID: hex.EncodeToString(id), PID: os.Getpid(), StartedAt: time.Now().UTC()}
+	err := s.updateQuotas(func(usage map[string]*callerUsage) error {
+		u := usageOf(usage, caller)
+		if len(u.Execs) >= limit {
+			return fmt.Errorf("%w: caller '%s' is running %d command(s) (limit %d)", ErrQuotaExceeded, caller, len(u.Execs), limit)
+		}
+		u.Execs = append(u.Execs, slot)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		s.updateQuotas(func(usage map[string]*callerUsage) error {
+			u := usageOf(usage, caller)
+			for i, held := range u.Execs {
+				if held.ID == slot.ID {
+					u.Execs = append(u.Execs[:i], u.Execs[i+1:]...)
+					break
+				}
+			}
+			return nil
+		})
+	}, nil
+}
+
+// usageOf returns the usage of a caller, adding it when it has none
+func usageOf(usage map[string]*callerUsage, caller string) *callerUsage {
+	if usage[caller] == nil {
+		usage[caller] = &callerUsage{}
+	}
+	return usage[caller]
+}
+
+// updateQuotas reads the quota usage of every caller under a host-wide lock, drops
+// creates older than an hour and commands of exited processes, and passes it to fn.
+// The usage is written back unless fn fails.
+func (s *Store) updateQuotas(fn func(map[string]*callerUsage) error) error {
+	dir := filepath.Dir(s.dir)
+	file, err := os.OpenFile(filepath.Join(dir, "quotas.lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open quota lock: %v", err)
+	}
+	defer file.Close()
+	if err := waitLock(file); err != nil {
+		return fmt.Errorf("failed to lock quotas: %v", err)
+	}
+	defer unlock(file)
+
+	path := filepath.Join(dir, "quotas.json")
+	usage := make(map[string]*callerUsage)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read quotas: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &usage); err != nil {
+			return fmt.Errorf("failed to parse quotas: %v", err)
+		}
+	}
+
+	since := time.Now().Add(-quotaWindow)
+	for _, u := range usage {
+		if u == nil {
+			continue
+		}
+		creates := u.Creates[:0]
+		for _, at := range u.Creates {
+			if at.After(since) {
+				creates = append(creates, at)
+			}
+		}
+		u.Creates = creates
+		execs := u.Execs[:0]
+		for _, slot := range u.Execs {
+			if processAlive(slot.PID) {
+				execs = append(execs, slot)
+			}
+		}
+		u.Execs = execs
+	}
+
+	if err := fn(usage); err != nil {
+		return err
+	}
+	for caller, u := range usage {
+		if u == nil || (len(u.Creates) == 0 && len(u.Execs) == 0) {
+			delete(usage, caller)
+		}
+	}
+	data, err = json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write quotas: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write quotas: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/reports"
+)
+
+// AgentState is the persisted record of an agent
+type AgentState struct {
+	// SchemaVersion is the format version the state was written with (see SchemaVersion)
+	SchemaVersion   int       `json:"schema_version,omitempty"`
+	ID              string    `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	RepoURL         string    `json:"repo_url,omitempty"`
+	Branch          string    `json:"branch,omitempty"`
+	Commit          string    `json:"commit,omitempty"` // full SHA the agent was pinned to with --commit
+	DependencyLevel string    `json:"dependency_level,omitempty"`
+	TeamID          string    `json:"team_id,omitempty"`
+	TeamSnapshot    string    `json:"team_snapshot,omitempty"`
+	UseOverlay      bool      `json:"use_overlay,omitempty"`
+	// OverlayMount is how the overlay workspace is mounted, kernel or fuse; empty for
+	// agents created before fuse mounts, which use the kernel's
+	OverlayMount string `json:"overlay_mount,omitempty"`
+	// StorageDriver stores the overlay workspace's copy-on-write layer, overlay, btrfs
+	// or zfs; empty for agents created before storage drivers, which use overlay
+	StorageDriver string `json:"storage_driver,omitempty"`
+	Template        string    `json:"template,omitempty"`
+	Providers       []string  `json:"providers,omitempty"`
+	SSHAcceptNew    bool      `json:"ssh_accept_new,omitempty"`
+
+	// CloneProtocol is the protocol the repository was cloned with, ssh or https, and
+	// CloneURL the URL, which differs from RepoURL when protocols rewrote it
+	CloneProtocol string `json:"clone_protocol,omitempty"`
+	CloneURL      string `json:"clone_url,omitempty"`
+
+	// SSHServer records that the agent runs sshd, published on SSHServerPort of the
+	// Docker host, and SSHAuthorizedKey the public key allowed to log in
+	SSHServer        bool   `json:"ssh_server,omitempty"`
+	SSHServerPort    int    `json:"ssh_server_port,omitempty"`
+	SSHAuthorizedKey string `json:"ssh_authorized_key,omitempty"`
+
+	// Platform is the platform the agent runs on when it was created with --platform,
+	// e.g. linux/amd64; empty for the platform of the Docker host
+	Platform string `json:"platform,omitempty"`
+
+	// SourceDir is the host checkout mounted as the repository of an agent created
+	// with --from-dir, which is never moved, scrubbed or removed with the agent
+	SourceDir string `json:"source_dir,omitempty"`
+
+	// Adopted records that the agent's container was built outside git-capsulate
+	// and brought under its management by adopt-container, so it cannot be recreated
+	Adopted bool `json:"adopted,omitempty"`
+
+	// Path is the repository subdirectory the agent is scoped to, and Sparse whether
+	// the checkout is limited to it
+	Path   string `json:"path,omitempty"`
+	Sparse bool   `json:"sparse,omitempty"`
+
+	// Labels are the key=value labels the agent was created with
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Overrides records how the container-level dependency overrides were resolved
+	Overrides []DependencyResolution `json:"overrides,omitempty"`
+
+	// Checks holds the results of the most recent check run
+	Checks []CheckResult `json:"checks,omitempty"`
+
+	// StartCommit is HEAD right after the repository was cloned, where the agent's
+	// own commits start
+	StartCommit string `json:"start_commit,omitempty"`
+
+	// Commands holds the most recent commands run in the agent and CommandCount the
+	// number run in total, for its run summary
+	Commands     []CommandRecord `json:"commands,omitempty"`
+	CommandCount int             `json:"command_count,omitempty"`
+
+	// Suggestions holds the latest drafted commit message and pull request
+	// description, keyed by kind
+	Suggestions map[string]Suggestion `json:"suggestions,omitempty"`
+}
+
+// Suggestion is a drafted commit message or pull request description
+type Suggestion struct {
+	Text       string    `json:"text"`
+	Source     string    `json:"source"`      // "command" or "summary"
+	HeadCommit string    `json:"head_commit"` // HEAD of the agent when it was drafted
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// DependencyResolution records the package a container-level override resolved to
+type DependencyResolution struct {
+	Provider  string `json:"provider"`
+	Name      string `json:"name"`
+	Requested string `json:"requested"`
+	Version   string `json:"version,omitempty"`
+	Path      string `json:"path,omitempty"`
+}
+
+// CheckResult records the outcome of a single validation check
+type CheckResult struct {
+	Name      string        `json:"name"`
+	Command   string        `json:"command"`
+	Passed    bool          `json:"passed"`
+	ExitCode  int           `json:"exit_code"`
+	Duration  time.Duration `json:"duration_ns"`
+	StartedAt time.Time     `json:"started_at"`
+	Output    string        `json:"output,omitempty"`
+	// ArtifactRun is the run in the artifact store holding the complete output and
+	// artifacts of the check
+	ArtifactRun string `json:"artifact_run,omitempty"`
+	// Reports summarizes the test, lint and coverage reports the check wrote
+	Reports *reports.Summary `json:"reports,omitempty"`
+}
+
+// Store persists agent state as one JSON file per agent
+type Store struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewStore creates a store rooted at <dataDir>/state, where dataDir is the project's
+// data directory (see config.DataDir)
+func NewStore(dataDir string) (*Store, error) {
+	dir := filepath.Join(dataDir, "state", "agents")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory %s: %v", dir, err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// Get returns the state of an agent. The boolean is false when no state is recorded.
+func (s *Store) Get(agentID string) (*AgentState, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.read(agentID)
+}
+
+// Save writes the state of an agent
+func (s *Store) Save(st *AgentState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write(st)
+}
+
+// Update applies fn to the agent's state and saves the result. An error wrapping
+// ErrAgentNotFound is returned, and nothing saved, when no state is recorded.
+func (s *Store) Update(agentID string, fn func(*AgentState) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st, exists, err := s.read(agentID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: '%s'", ErrAgentNotFound, agentID)
+	}
+	if err := fn(st); err != nil {
+		return err
+	}
+	return s.write(st)
+}
+
+// Delete removes the state of an agent
+func (s *Store) Delete(agentID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := os.Remove(s.path(agentID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete state for agent '%s': %v", agentID, err)
+	}
+	return nil
+}
+
+// List returns the state of all agents, sorted by ID
+func (s *Store) List() ([]*AgentState, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state directory: %v", err)
+	}
+
+	var states []*AgentState
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		st, exists, err := s.read(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			states = append(states, st)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].ID < states[j].ID })
+	return states, nil
+}
+
+// read loads an agent's state; the caller must hold the mutex
+func (s *Store) read(agentID string) (*AgentState, bool, error) {
+	data, err := os.ReadFile(s.path(agentID))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read state for agent '%s': %v", agentID, err)
+	}
+
+	var st AgentState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, false, fmt.Errorf("failed to parse state for agent '%s': %v", agentID, err)
+	}
+	if st.SchemaVersion > SchemaVersion {
+		return nil, false, fmt.Errorf("%w: agent '%s' has schema version %d, this release supports up to %d", ErrNewerSchema, agentID, st.SchemaVersion, SchemaVersion)
+	}
+	return &st, true, nil
+}
+
+// write saves an agent's state atomically; the caller must hold the mutex
+func (s *Store) write(st *AgentState) error {
+	st.UpdatedAt = time.Now()
+	st.SchemaVersion = SchemaVersion
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state for agent '%s': %v", st.ID, err)
+	}
+
+	tmp := s.path(st.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write state for agent '%s': %v", st.ID, err)
+	}
+	if err := os.Rename(tmp, s.path(st.ID)); err != nil {
+		return fmt.Errorf("failed to write state for agent '%s': %v", st.ID, err)
+	}
+	return nil
+}
+
+// path returns the state file for an agent
+func (s *Store) path(agentID string) string {
+	return filepath.Join(s.dir, agentID+".json")
+}
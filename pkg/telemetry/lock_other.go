@@ -0,0 +1,14 @@
+//go:build !unix
+
+package telemetry
+
+import "os"
+
+// lockFile always succeeds on platforms without flock, where the usage is only
+// protected against other goroutines
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// unlockFile is a no-op on platforms without flock
+func unlockFile(file *os.File) {}
@@ -0,0 +1,60 @@
+package telemetry
+
+import (
+	"os"
+	"strings"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/tracing"
+)
+
+// Env switches telemetry for every command, overriding capsulate.yaml: "0" or "false"
+// turns it off, any other value on
+const Env = "GIT_CAPSULATE_TELEMETRY"
+
+// What decided whether telemetry is collected for a command
+const (
+	SourceEnv     = "environment" // GIT_CAPSULATE_TELEMETRY or --no-telemetry
+	SourceCommand = "command"     // the command's entry, or its parent's, under telemetry.commands
+	SourceConfig  = "config"      // telemetry.enabled
+	SourceDefault = "default"     // telemetry is on unless configured otherwise
+)
+
+// Decision is whether telemetry is collected for a command, and what decided it
+type Decision struct {
+	Command string `json:"command"`
+	Enabled bool   `json:"enabled"`
+	Source  string `json:"source"`
+}
+
+// Resolve decides whether metrics and traces are collected for a command, given
+// without the program name, e.g. "team-deps sync". GIT_CAPSULATE_TELEMETRY comes
+// first, then the entry of the command under telemetry.commands, or of the nearest
+// parent command with one, then telemetry.enabled.
+func Resolve(cfg config.TelemetryConfig, command string) Decision {
+	if val := os.Getenv(Env); val != "" {
+		return Decision{Command: command, Enabled: val != "0" && val != "false", Source: SourceEnv}
+	}
+	for name := command; name != ""; {
+		if enabled, ok := cfg.Commands[name]; ok {
+			return Decision{Command: command, Enabled: enabled, Source: SourceCommand}
+		}
+		i := strings.LastIndex(name, " ")
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	if cfg.Enabled != nil {
+		return Decision{Command: command, Enabled: *cfg.Enabled, Source: SourceConfig}
+	}
+	return Decision{Command: command, Enabled: true, Source: SourceDefault}
+}
+
+// Apply switches the collection of metrics, traces and operation journals for the
+// rest of the process
+func Apply(enabled bool) {
+	metrics.SetEnabled(enabled)
+	tracing.SetEnabled(enabled)
+}
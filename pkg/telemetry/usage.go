@@ -0,0 +1,269 @@
+package telemetry
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/metrics"
+	"github.com/your-org/capsulate-repo/pkg/secrets"
+	"github.com/your-org/capsulate-repo/pkg/version"
+)
+
+// usageFile is the file in the metrics directory holding the usage not reported yet
+const usageFile = "usage.json"
+
+// reportTimeout limits a usage report, which is sent at the end of a command
+const reportTimeout = 5 * time.Second
+
+// CommandUsage counts the runs of a command and their durations in milliseconds
+type CommandUsage struct {
+	Count         int     `json:"count"`
+	TotalDuration float64 `json:"total_duration_ms"`
+	MaxDuration   float64 `json:"max_duration_ms"`
+}
+
+// Usage is the usage recorded since the last report. InstallID is random and
+// identifies nothing but the installation, so that reports can be counted per host.
+type Usage struct {
+	InstallID  string                  `json:"install_id"`
+	Since      time.Time               `json:"since"`
+	LastReport time.Time               `json:"last_report,omitempty"`
+	LastError  string                  `json:"last_error,omitempty"`
+	Commands   map[string]CommandUsage `json:"commands"`
+}
+
+// Report is the body of a usage report
+type Report struct {
+	InstallID string                  `json:"install_id"`
+	Version   string                  `json:"version"`
+	Platform  string                  `json:"platform"`
+	From      time.Time               `json:"from"`
+	To        time.Time               `json:"to"`
+	Commands  map[string]CommandUsage `json:"commands"`
+}
+
+var usageMutex sync.Mutex
+
+// lockUsage takes usageMutex and a flock on the usage file's lock, so that neither
+// other goroutines nor other git-capsulate processes update the usage in between a
+// read and a write. The returned function releases both.
+func lockUsage() (func(), error) {
+	usageMutex.Lock()
+	if err := os.MkdirAll(metrics.Dir(), 0755); err != nil {
+		usageMutex.Unlock()
+		return nil, fmt.Errorf("failed to create metrics directory: %v", err)
+	}
+	file, err := os.OpenFile(filepath.Join(metrics.Dir(), usageFile+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		usageMutex.Unlock()
+		return nil, fmt.Errorf("failed to open usage lock: %v", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		usageMutex.Unlock()
+		return nil, fmt.Errorf("failed to lock usage: %v", err)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+		usageMutex.Unlock()
+	}, nil
+}
+
+// LoadUsage returns the usage recorded since the last report
+func LoadUsage() (*Usage, error) {
+	release, err := lockUsage()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return loadUsage()
+}
+
+// RecordUsage adds a run of a command, given without its arguments, to the usage
+// not reported yet. Failures are ignored: telemetry must never break a command.
+func RecordUsage(command string, duration time.Duration) {
+	release, err := lockUsage()
+	if err != nil {
+		return
+	}
+	defer release()
+
+	usage, err := loadUsage()
+	if err != nil {
+		return
+	}
+	ms := float64(duration.Milliseconds())
+	stats := usage.Commands[command]
+	stats.Count++
+	stats.TotalDuration += ms
+	if ms > stats.MaxDuration {
+		stats.MaxDuration = ms
+	}
+	usage.Commands[command] = stats
+	saveUsage(usage)
+}
+
+// ReportUsage sends the usage recorded since the last report to the configured
+// endpoint, once the report interval has passed or when forced, and reports whether
+// it did. Usage is kept for the next attempt when the endpoint cannot be reached.
+func ReportUsage(cfg config.UsageConfig, force bool) (bool, error) {
+	if cfg.Endpoint == "" {
+		return false, nil
+	}
+
+	release, err := lockUsage()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	usage, err := loadUsage()
+	if err != nil {
+		return false, err
+	}
+	last := usage.LastReport
+	if last.IsZero() {
+		last = usage.Since
+	}
+	if len(usage.Commands) == 0 || (!force && time.Since(last) < cfg.ReportInterval()) {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	report := Report{
+		InstallID: usage.InstallID,
+		Version:   version.Version,
+		Platform:  version.Platform(),
+		From:      usage.Since,
+		To:        now,
+		Commands:  usage.Commands,
+	}
+	if err := send(cfg, report); err != nil {
+		usage.LastError = err.Error()
+		saveUsage(usage)
+		return false, err
+	}
+	usage.Since, usage.LastReport, usage.LastError = now, now, ""
+	usage.Commands = make(map[string]CommandUsage)
+	saveUsage(usage)
+	return true, nil
+}
+
+// send posts a report to the endpoint
+func send(cfg config.UsageConfig, report Report) error {
+	endpoint := cfg.Endpoint
+	if !strings.HasPrefix(endpoint, "https://") && !strings.HasPrefix(endpoint, "http://") {
+		resolved, err := secrets.Resolve(endpoint)
+		if err != nil {
+			return err
+		}
+		endpoint = resolved
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "git-capsulate/"+version.Version)
+	if cfg.Token != "" {
+		token, err := secrets.Resolve(cfg.Token)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := &http.Client{Timeout: reportTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL may be a credential and is left out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("server responded %s", resp.Status)
+	}
+	return nil
+}
+
+// loadUsage reads the recorded usage, starting and saving a new record with a fresh
+// installation ID when there is none; callers must hold the lock of lockUsage
+func loadUsage() (*Usage, error) {
+	usage := &Usage{}
+	data, err := os.ReadFile(filepath.Join(metrics.Dir(), usageFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read usage: %v", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, usage); err != nil {
+			return nil, fmt.Errorf("failed to parse usage: %v", err)
+		}
+	}
+	if usage.InstallID == "" {
+		id := make([]byte, 16)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		usage.InstallID = hex.EncodeToString(id)
+		usage.Since = time.Now().UTC()
+		usage.Commands = make(map[string]CommandUsage)
+		saveUsage(usage)
+	}
+	if usage.Commands == nil {
+		usage.Commands = make(map[string]CommandUsage)
+	}
+	return usage, nil
+}
+
+// saveUsage writes the recorded usage through a temporary file of its own, so that
+// a reader never sees it half written; callers must hold the lock of lockUsage.
+// Failures are ignored like those of other metrics.
+func saveUsage(usage *Usage) {
+	if err := os.MkdirAll(metrics.Dir(), 0755); err != nil {
+		return
+	}
+	data, err := json.MarshalIndent(usage, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(metrics.Dir(), usageFile+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	os.Chmod(tmp.Name(), 0644)
+	if err := os.Rename(tmp.Name(), filepath.Join(metrics.Dir(), usageFile)); err != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,271 @@
+package tracing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Formats traces are exported in. The JSON trace files read by 'op show' are always
+// written; the other formats are written alongside them.
+const (
+	FormatJSON     = "json"      // trace-<id>.json only
+	FormatZipkin   = "zipkin"    // also trace-<id>.zipkin.json, a Zipkin v2 span list
+	FormatOTLPFile = "otlp-file" // also a line per span in spans.otlp.jsonl, as OTLP/JSON
+)
+
+// Formats lists the supported export formats
+var Formats = []string{FormatJSON, FormatZipkin, FormatOTLPFile}
+
+// FormatEnv selects the export format, overriding tracing.format in capsulate.yaml
+const FormatEnv = "GIT_CAPSULATE_TRACE_FORMAT"
+
+// DefaultServiceName is the service traces are reported under
+const DefaultServiceName = "git-capsulate"
+
+// OTLPFile is the file of the otlp-file format, in the traces directory
+const OTLPFile = "spans.otlp.jsonl"
+
+// maxOTLPFileSize is the size past which OTLPFile is rotated to OTLPFile.1
+const maxOTLPFileSize = 64 << 20
+
+// ValidFormat checks that a format is one of Formats; "" selects FormatJSON
+func ValidFormat(format string) error {
+	if format == "" {
+		return nil
+	}
+	for _, f := range Formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown trace format '%s'; use one of %s", format, strings.Join(Formats, ", "))
+}
+
+// SetFormat selects the format traces are exported in, and the service name they are
+// reported under ("" for DefaultServiceName). FormatEnv, when set, takes precedence.
+func (t *Tracer) SetFormat(format, serviceName string) error {
+	if env := os.Getenv(FormatEnv); env != "" {
+		format = env
+	}
+	if err := ValidFormat(format); err != nil {
+		return err
+	}
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.format = format
+	t.serviceName = serviceName
+	return nil
+}
+
+// SetFormat selects the export format of the global tracer
+func SetFormat(format, serviceName string) error {
+	return GlobalTracer.SetFormat(format, serviceName)
+}
+
+// exportFormat writes the spans of a trace in the selected format besides JSON.
+// Export failures are ignored, like those of the JSON trace files.
+func (t *Tracer) exportFormat(traceID string, spans []*Span) {
+	t.mutex.Lock()
+	format, serviceName := t.format, t.serviceName
+	t.mutex.Unlock()
+	if serviceName == "" {
+		serviceName = DefaultServiceName
+	}
+
+	switch format {
+	case FormatZipkin:
+		data, err := json.MarshalIndent(zipkinSpans(spans, serviceName), "", "  ")
+		if err != nil {
+			return
+		}
+		os.WriteFile(filepath.Join(t.tracesPath, fmt.Sprintf("trace-%s.zipkin.json", traceID)), data, 0644)
+	case FormatOTLPFile:
+		var lines []byte
+		for _, span := range spans {
+			line, err := json.Marshal(otlpSpan(span, serviceName))
+			if err != nil {
+				continue
+			}
+			lines = append(append(lines, line...), '\n')
+		}
+		appendOTLPFile(filepath.Join(t.tracesPath, OTLPFile), lines)
+	}
+}
+
+// appendOTLPFile appends lines to the OTLP file in a single write, so that the lines
+// of concurrent processes do not interleave, rotating the file once it is too large
+func appendOTLPFile(path string, lines []byte) {
+	if info, err := os.Stat(path); err == nil && info.Size() > maxOTLPFileSize {
+		os.Rename(path, path+".1")
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	f.Write(lines)
+}
+
+// hexID derives an ID of n hex digits from a span or trace ID, which the formats
+// require to be hex-encoded, so that parents still match their children
+func hexID(id string, n int) string {
+	if id == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])[:n]
+}
+
+// zipkinSpan is a span in the Zipkin v2 JSON format
+type zipkinSpan struct {
+	TraceID       string             `json:"traceId"`
+	ID            string             `json:"id"`
+	ParentID      string             `json:"parentId,omitempty"`
+	Name          string             `json:"name"`
+	Timestamp     int64              `json:"timestamp"`
+	Duration      int64              `json:"duration"`
+	LocalEndpoint zipkinEndpoint     `json:"localEndpoint"`
+	Tags          map[string]string  `json:"tags,omitempty"`
+	Annotations   []zipkinAnnotation `json:"annotations,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type zipkinAnnotation struct {
+	Timestamp int64  `json:"timestamp"`
+	Value     string `json:"value"`
+}
+
+// zipkinSpans converts spans to Zipkin v2 spans, which take times in microseconds
+// and string tags. Span events become annotations and a failed status the error tag.
+func zipkinSpans(spans []*Span, serviceName string) []zipkinSpan {
+	result := make([]zipkinSpan, 0, len(spans))
+	for _, span := range spans {
+		z := zipkinSpan{
+			TraceID:       hexID(span.Context.TraceID, 32),
+			ID:            hexID(span.Context.SpanID, 16),
+			ParentID:      hexID(span.ParentID, 16),
+			Name:          span.Name,
+			Timestamp:     span.StartTime.UnixMicro(),
+			Duration:      span.EndTime.Sub(span.StartTime).Microseconds(),
+			LocalEndpoint: zipkinEndpoint{ServiceName: serviceName},
+			Tags:          make(map[string]string),
+		}
+		for key, value := range span.Attributes {
+			z.Tags[key] = fmt.Sprint(value)
+		}
+		if span.OperationID != "" {
+			z.Tags["operation_id"] = span.OperationID
+		}
+		if span.Status.Code == 2 {
+			z.Tags["error"] = span.Status.Message
+		}
+		for _, event := range span.Events {
+			value := event.Name
+			if len(event.Attributes) > 0 {
+				if attrs, err := json.Marshal(event.Attributes); err == nil {
+					value += " " + string(attrs)
+				}
+			}
+			z.Annotations = append(z.Annotations, zipkinAnnotation{Timestamp: event.Timestamp.UnixMicro(), Value: value})
+		}
+		result = append(result, z)
+	}
+	return result
+}
+
+// otlpSpan converts a span to an OTLP/JSON export request holding only that span, the
+// form the file exporter of the OpenTelemetry Collector writes and its file receiver
+// reads
+func otlpSpan(span *Span, serviceName string) map[string]interface{} {
+	attributes := otlpAttributes(span.Attributes)
+	if span.OperationID != "" {
+		attributes = append(attributes, otlpAttribute("operation_id", span.OperationID))
+	}
+	events := make([]map[string]interface{}, 0, len(span.Events))
+	for _, event := range span.Events {
+		events = append(events, map[string]interface{}{
+			"timeUnixNano": strconv.FormatInt(event.Timestamp.UnixNano(), 10),
+			"name":         event.Name,
+			"attributes":   otlpAttributes(event.Attributes),
+		})
+	}
+	status := map[string]interface{}{"code": span.Status.Code}
+	if span.Status.Message != "" {
+		status["message"] = span.Status.Message
+	}
+
+	s := map[string]interface{}{
+		"traceId":           hexID(span.Context.TraceID, 32),
+		"spanId":            hexID(span.Context.SpanID, 16),
+		"name":              span.Name,
+		"kind":              1, // SPAN_KIND_INTERNAL
+		"startTimeUnixNano": strconv.FormatInt(span.StartTime.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		"attributes":        attributes,
+		"events":            events,
+		"status":            status,
+	}
+	if span.ParentID != "" {
+		s["parentSpanId"] = hexID(span.ParentID, 16)
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": []interface{}{otlpAttribute("service.name", serviceName)},
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": DefaultServiceName},
+				"spans": []interface{}{s},
+			}},
+		}},
+	}
+}
+
+// otlpAttributes converts attributes to OTLP key-value pairs, sorted by key
+func otlpAttributes(attributes map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	result := make([]interface{}, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, otlpAttribute(key, attributes[key]))
+	}
+	return result
+}
+
+// otlpAttribute converts an attribute to an OTLP key-value pair, whose value is typed;
+// 64-bit integers are strings in OTLP/JSON
+func otlpAttribute(key string, value interface{}) map[string]interface{} {
+	var v map[string]interface{}
+	switch value := value.(type) {
+	case string:
+		v = map[string]interface{}{"stringValue": value}
+	case bool:
+		v = map[string]interface{}{"boolValue": value}
+	case int:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(int64(value), 10)}
+	case int64:
+		v = map[string]interface{}{"intValue": strconv.FormatInt(value, 10)}
+	case float64:
+		v = map[string]interface{}{"doubleValue": value}
+	default:
+		v = map[string]interface{}{"stringValue": fmt.Sprint(value)}
+	}
+	return map[string]interface{}{"key": key, "value": v}
+}
@@ -0,0 +1,262 @@
+package tracing
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OperationEnv carries the ID of the current operation to the processes it starts,
+// so that nested git-capsulate commands are recorded under the same operation
+const OperationEnv = "GIT_CAPSULATE_OPERATION_ID"
+
+// Kinds of entries recorded in an operation's journal
+const (
+	OperationCommand = "command" // the command line that started the operation
+	OperationTrace   = "trace"   // a trace exported to the traces directory
+	OperationEvent   = "event"   // an event added to a span
+	OperationMetric  = "metric"  // a timer, counter or gauge
+)
+
+// maxOperations is how many operation journals are kept, oldest removed first
+const maxOperations = 500
+
+// ErrOperationNotFound is returned (wrapped) for unknown or ambiguous operation IDs
+var ErrOperationNotFound = errors.New("operation not found")
+
+// OperationEntry is a record in an operation's journal
+type OperationEntry struct {
+	Time       time.Time              `json:"time"`
+	Kind       string                 `json:"kind"`
+	Name       string                 `json:"name"`
+	AgentID    string                 `json:"agent_id,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// OperationSummary describes a recorded operation
+type OperationSummary struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	Command   string    `json:"command,omitempty"`
+	Entries   int       `json:"entries"`
+}
+
+var (
+	operationID    string
+	operationMutex sync.Mutex
+)
+
+// OperationID returns the ID of the current operation: the one inherited through
+// OperationEnv, or one generated for this process
+func OperationID() string {
+	operationMutex.Lock()
+	defer operationMutex.Unlock()
+
+	if operationID == "" {
+		operationID = os.Getenv(OperationEnv)
+		if operationID == "" {
+			operationID = newOperationID()
+			os.Setenv(OperationEnv, operationID)
+		}
+	}
+	return operationID
+}
+
+// StartOperation begins a new operation in this process, e.g. for each round of a
+// long-running loop, and returns its ID
+func StartOperation() string {
+	operationMutex.Lock()
+	defer operationMutex.Unlock()
+
+	operationID = newOperationID()
+	os.Setenv(OperationEnv, operationID)
+	return operationID
+}
+
+// OperationsDir returns the directory operation journals are written to
+func OperationsDir() string {
+	return filepath.Join(Dir(), "operations")
+}
+
+// RecordOperation appends an entry to the journal of the current operation. Nothing
+// is recorded when tracing is disabled; failures to write are ignored, like those of
+// trace exports.
+func RecordOperation(kind, name, agentID string, attributes map[string]interface{}) {
+	if GlobalTracer == nil || !GlobalTracer.enabled {
+		return
+	}
+
+	data, err := json.Marshal(OperationEntry{
+		Time:       time.Now().UTC(),
+		Kind:       kind,
+		Name:       name,
+		AgentID:    agentID,
+		Attributes: attributes,
+	})
+	if err != nil {
+		return
+	}
+
+	dir := OperationsDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	path := filepath.Join(dir, OperationID()+".jsonl")
+	_, statErr := os.Stat(path)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	f.Write(append(data, '\n'))
+	f.Close()
+
+	// Journals are pruned when a new one is started
+	if os.IsNotExist(statErr) {
+		pruneOperations(dir)
+	}
+}
+
+// ListOperations returns the recorded operations, most recent first
+func ListOperations() ([]OperationSummary, error) {
+	files, err := os.ReadDir(OperationsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read operations directory: %v", err)
+	}
+
+	var operations []OperationSummary
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".jsonl") {
+			continue
+		}
+		id := strings.TrimSuffix(file.Name(), ".jsonl")
+		entries, err := readJournal(id)
+		if err != nil || len(entries) == 0 {
+			continue
+		}
+		summary := OperationSummary{ID: id, StartedAt: entries[0].Time, Entries: len(entries)}
+		for _, entry := range entries {
+			if entry.Kind == OperationCommand {
+				summary.Command = entry.Name
+				break
+			}
+		}
+		operations = append(operations, summary)
+	}
+	sort.Slice(operations, func(i, j int) bool { return operations[i].StartedAt.After(operations[j].StartedAt) })
+	return operations, nil
+}
+
+// ReadOperation returns the full ID and the journal of an operation, given its ID or
+// an unambiguous prefix of it
+func ReadOperation(id string) (string, []OperationEntry, error) {
+	files, err := os.ReadDir(OperationsDir())
+	if err != nil && !os.IsNotExist(err) {
+		return "", nil, fmt.Errorf("failed to read operations directory: %v", err)
+	}
+	var matches []string
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".jsonl")
+		if name == id {
+			matches = []string{name}
+			break
+		}
+		if id != "" && strings.HasPrefix(name, id) && strings.HasSuffix(file.Name(), ".jsonl") {
+			matches = append(matches, name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", nil, fmt.Errorf("%w: '%s'", ErrOperationNotFound, id)
+	case 1:
+	default:
+		return "", nil, fmt.Errorf("%w: '%s' matches %d operations", ErrOperationNotFound, id, len(matches))
+	}
+
+	entries, err := readJournal(matches[0])
+	if err != nil {
+		return "", nil, err
+	}
+	return matches[0], entries, nil
+}
+
+// ReadTrace returns the spans of an exported trace
+func ReadTrace(traceID string) ([]*Span, error) {
+	data, err := os.ReadFile(filepath.Join(Dir(), fmt.Sprintf("trace-%s.json", traceID)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trace %s: %v", traceID, err)
+	}
+	var trace struct {
+		Spans []*Span `json:"spans"`
+	}
+	if err := json.Unmarshal(data, &trace); err != nil {
+		return nil, fmt.Errorf("failed to parse trace %s: %v", traceID, err)
+	}
+	return trace.Spans, nil
+}
+
+// readJournal reads the entries of an operation's journal, skipping lines cut short
+// by a process that exited while writing
+func readJournal(id string) ([]OperationEntry, error) {
+	f, err := os.Open(filepath.Join(OperationsDir(), id+".jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read operation %s: %v", id, err)
+	}
+	defer f.Close()
+
+	var entries []OperationEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var entry OperationEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err == nil {
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read operation %s: %v", id, err)
+	}
+	return entries, nil
+}
+
+// pruneOperations removes the oldest journals beyond maxOperations
+func pruneOperations(dir string) {
+	files, err := os.ReadDir(dir)
+	if err != nil || len(files) <= maxOperations {
+		return
+	}
+	type journal struct {
+		path    string
+		modTime time.Time
+	}
+	var journals []journal
+	for _, file := range files {
+		if info, err := file.Info(); err == nil && !file.IsDir() {
+			journals = append(journals, journal{filepath.Join(dir, file.Name()), info.ModTime()})
+		}
+	}
+	sort.Slice(journals, func(i, j int) bool { return journals[i].modTime.Before(journals[j].modTime) })
+	for i := 0; i < len(journals)-maxOperations; i++ {
+		os.Remove(journals[i].path)
+	}
+}
+
+// newOperationID generates a short random operation ID
+func newOperationID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
@@ -3,6 +3,7 @@ package tracing
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -16,17 +17,53 @@ type SpanContext struct {
 	SpanID  string `json:"span_id"`
 }
 
+// contextKey is the type of the context keys of this package, so that they cannot
+// collide with the keys of other packages
+type contextKey int
+
+// spanContextKey carries the SpanContext of the current span
+const spanContextKey contextKey = iota
+
+// ContextWithSpan returns a copy of ctx carrying a span's context; spans started from
+// it become children of that span
+func ContextWithSpan(ctx context.Context, sc SpanContext) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, spanContextKey, sc)
+}
+
+// SpanFromContext returns the context of the span ctx carries, and whether it carries
+// one
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	if ctx == nil {
+		return SpanContext{}, false
+	}
+	sc, ok := ctx.Value(spanContextKey).(SpanContext)
+	if !ok || sc.SpanID == "" || sc.TraceID == "" {
+		return SpanContext{}, false
+	}
+	return sc, true
+}
+
+// TraceIDFromContext returns the ID of the trace ctx carries, or ""
+func TraceIDFromContext(ctx context.Context) string {
+	sc, _ := SpanFromContext(ctx)
+	return sc.TraceID
+}
+
 // Span represents a single span in a trace
 type Span struct {
-	Name       string                 `json:"name"`
-	Context    SpanContext            `json:"context"`
-	ParentID   string                 `json:"parent_id,omitempty"`
-	StartTime  time.Time              `json:"start_time"`
-	EndTime    time.Time              `json:"end_time,omitempty"`
-	Duration   int64                  `json:"duration_ms,omitempty"`
-	Attributes map[string]interface{} `json:"attributes,omitempty"`
-	Events     []SpanEvent            `json:"events,omitempty"`
-	Status     SpanStatus             `json:"status"`
+	Name        string                 `json:"name"`
+	Context     SpanContext            `json:"context"`
+	OperationID string                 `json:"operation_id,omitempty"`
+	ParentID    string                 `json:"parent_id,omitempty"`
+	StartTime   time.Time              `json:"start_time"`
+	EndTime     time.Time              `json:"end_time,omitempty"`
+	Duration    int64                  `json:"duration_ms,omitempty"`
+	Attributes  map[string]interface{} `json:"attributes,omitempty"`
+	Events      []SpanEvent            `json:"events,omitempty"`
+	Status      SpanStatus             `json:"status"`
 }
 
 // SpanEvent represents an event within a span
@@ -42,6 +79,9 @@ type SpanStatus struct {
 	Message string `json:"message,omitempty"`
 }
 
+// ExceptionEvent is the name of the events recorded by RecordError
+const ExceptionEvent = "exception"
+
 // Event represents a tracing event
 type Event struct {
 	Name       string
@@ -56,6 +96,10 @@ type Tracer struct {
 	mutex       sync.Mutex
 	enabled     bool
 	tracesPath  string
+	// format is the export format besides JSON, and serviceName the service traces
+	// are reported under (see SetFormat)
+	format      string
+	serviceName string
 }
 
 // NewTracer creates a new tracer
@@ -66,9 +110,11 @@ func NewTracer(tracesPath string, enabled bool) *Tracer {
 	}
 
 	return &Tracer{
-		spans:      make(map[string]*Span),
-		enabled:    enabled,
-		tracesPath: tracesPath,
+		spans:       make(map[string]*Span),
+		enabled:     enabled,
+		tracesPath:  tracesPath,
+		format:      os.Getenv(FormatEnv),
+		serviceName: DefaultServiceName,
 	}
 }
 
@@ -86,10 +132,9 @@ func (t *Tracer) StartSpan(ctx context.Context, name string, attributes map[stri
 	
 	// Extract parent span ID from context if it exists
 	var traceID, parentID string
-	parentSpanID := ctx.Value("span_id")
-	if parentSpanID != nil {
-		parentID = parentSpanID.(string)
-		traceID = ctx.Value("trace_id").(string)
+	if parent, ok := SpanFromContext(ctx); ok {
+		parentID = parent.SpanID
+		traceID = parent.TraceID
 	} else {
 		// This is a root span, generate a new trace ID
 		traceID = generateID()
@@ -102,9 +147,10 @@ func (t *Tracer) StartSpan(ctx context.Context, name string, attributes map[stri
 			TraceID: traceID,
 			SpanID:  spanID,
 		},
-		ParentID:   parentID,
-		StartTime:  time.Now(),
-		Attributes: attributes,
+		OperationID: OperationID(),
+		ParentID:    parentID,
+		StartTime:   time.Now(),
+		Attributes:  attributes,
 		Status: SpanStatus{
 			Code: 0, // Unset
 		},
@@ -115,10 +161,7 @@ func (t *Tracer) StartSpan(ctx context.Context, name string, attributes map[stri
 	t.activeSpans.Store(spanID, span)
 
 	// Create a new context with span information
-	newCtx := context.WithValue(ctx, "span_id", spanID)
-	newCtx = context.WithValue(newCtx, "trace_id", traceID)
-
-	return newCtx, spanID
+	return ContextWithSpan(ctx, span.Context), spanID
 }
 
 // EndSpan ends a span and computes its duration
@@ -128,10 +171,9 @@ func (t *Tracer) EndSpan(spanID string, status SpanStatus) {
 	}
 
 	t.mutex.Lock()
-	defer t.mutex.Unlock()
-
 	span, exists := t.spans[spanID]
 	if !exists {
+		t.mutex.Unlock()
 		return
 	}
 
@@ -142,11 +184,56 @@ func (t *Tracer) EndSpan(spanID string, status SpanStatus) {
 
 	// Remove from active spans
 	t.activeSpans.Delete(spanID)
+	root := span.ParentID == ""
+	agentID := spanAgentID(span)
+	t.mutex.Unlock()
+
+	// Export trace if this is a root span (no parent ID). The export is done before
+	// returning so that the traces of commands exiting right after are not lost.
+	if root {
+		t.exportTrace(span.Context.TraceID)
+		RecordOperation(OperationTrace, span.Name, agentID, map[string]interface{}{
+			"trace_id":    span.Context.TraceID,
+			"duration_ms": span.Duration,
+			"status":      status.Code,
+		})
+	}
+}
+
+// AddEvent records an event on a span
+func (t *Tracer) AddEvent(spanID string, name string, attributes map[string]interface{}) {
+	if !t.enabled || spanID == "" {
+		return
+	}
+
+	t.mutex.Lock()
+	span, exists := t.spans[spanID]
+	if !exists {
+		t.mutex.Unlock()
+		return
+	}
+	span.Events = append(span.Events, SpanEvent{
+		Name:       name,
+		Timestamp:  time.Now(),
+		Attributes: attributes,
+	})
+	agentID := spanAgentID(span)
+	t.mutex.Unlock()
+
+	RecordOperation(OperationEvent, name, agentID, attributes)
+}
 
-	// Export trace if this is a root span (no parent ID)
-	if span.ParentID == "" {
-		go t.exportTrace(span.Context.TraceID)
+// RecordError records an error on a span as an "exception" event, with the attributes
+// exporters such as Zipkin and OTLP understand. It does not end the span or set its
+// status; EndSpanError does.
+func (t *Tracer) RecordError(spanID string, err error) {
+	if err == nil {
+		return
 	}
+	t.AddEvent(spanID, ExceptionEvent, map[string]interface{}{
+		"exception.type":    fmt.Sprintf("%T", rootCause(err)),
+		"exception.message": err.Error(),
+	})
 }
 
 // AddAttribute adds or updates an attribute on a span
@@ -243,6 +330,8 @@ func (t *Tracer) exportTrace(traceID string) {
 		"spans":    traceSpans,
 	})
 
+	t.exportFormat(traceID, traceSpans)
+
 	// Clean up trace spans from memory
 	t.mutex.Lock()
 	for _, span := range traceSpans {
@@ -251,6 +340,24 @@ func (t *Tracer) exportTrace(traceID string) {
 	t.mutex.Unlock()
 }
 
+// rootCause returns the innermost error wrapped by err, whose type tells more than
+// that of the wrapping errors
+func rootCause(err error) error {
+	for {
+		next := errors.Unwrap(err)
+		if next == nil {
+			return err
+		}
+		err = next
+	}
+}
+
+// spanAgentID returns the agent a span is about, from its agent_id attribute
+func spanAgentID(span *Span) string {
+	agentID, _ := span.Attributes["agent_id"].(string)
+	return agentID
+}
+
 // generateID generates a unique ID for spans and traces
 func generateID() string {
 	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), os.Getpid())
@@ -259,6 +366,35 @@ func generateID() string {
 // Initialize the global tracer
 func init() {
 	// Get traces directory from environment or use default
+	tracesPath := Dir()
+
+	GlobalTracer = NewTracer(tracesPath, EnvEnabled())
+}
+
+// EnvEnabled reports whether GIT_CAPSULATE_TRACING_ENABLED leaves tracing on
+func EnvEnabled() bool {
+	if val := os.Getenv("GIT_CAPSULATE_TRACING_ENABLED"); val != "" {
+		return val != "0" && val != "false"
+	}
+	return true
+}
+
+// SetEnabled switches the global tracer, e.g. off when telemetry is disabled. Spans,
+// events and operation journals record nothing while it is off. Tracing disabled
+// with GIT_CAPSULATE_TRACING_ENABLED stays off. It must be called before spans are
+// started, as the process begins.
+func SetEnabled(enabled bool) {
+	enabled = enabled && EnvEnabled()
+	if enabled && GlobalTracer.tracesPath != "" {
+		os.MkdirAll(GlobalTracer.tracesPath, 0755)
+	}
+	GlobalTracer.mutex.Lock()
+	GlobalTracer.enabled = enabled
+	GlobalTracer.mutex.Unlock()
+}
+
+// Dir returns the directory traces are written to
+func Dir() string {
 	tracesPath := os.Getenv("GIT_CAPSULATE_TRACES_PATH")
 	if tracesPath == "" {
 		homeDir, err := os.UserHomeDir()
@@ -268,14 +404,7 @@ func init() {
 			tracesPath = filepath.Join(os.TempDir(), "git-capsulate", "traces")
 		}
 	}
-
-	// Check if tracing is enabled
-	tracingEnabled := true
-	if val := os.Getenv("GIT_CAPSULATE_TRACING_ENABLED"); val != "" {
-		tracingEnabled = val != "0" && val != "false"
-	}
-
-	GlobalTracer = NewTracer(tracesPath, tracingEnabled)
+	return tracesPath
 }
 
 // StartSpan starts a new trace span using the global tracer
@@ -298,11 +427,21 @@ func EndSpanError(spanID string, message string) {
 	EndSpan(spanID, SpanStatus{Code: 2, Message: message}) // Error
 }
 
+// RecordError records an error on a span using the global tracer
+func RecordError(spanID string, err error) {
+	GlobalTracer.RecordError(spanID, err)
+}
+
 // AddAttribute adds or updates an attribute on a span using the global tracer
 func AddAttribute(spanID string, key string, value interface{}) {
 	GlobalTracer.AddAttribute(spanID, key, value)
 }
 
+// AddEvent records an event on a span using the global tracer
+func AddEvent(spanID string, name string, attributes map[string]interface{}) {
+	GlobalTracer.AddEvent(spanID, name, attributes)
+}
+
 // SetStatus sets the status of a span using the global tracer
 func SetStatus(spanID string, code int, message string) {
 	GlobalTracer.SetStatus(spanID, code, message)
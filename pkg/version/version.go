@@ -0,0 +1,41 @@
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version and Commit describe the git-capsulate build. They are overridden at
+// build time with -ldflags "-X github.com/your-org/capsulate-repo/pkg/version.Version=...".
+var (
+	// Version is the released version of git-capsulate
+	Version = "dev"
+	// Commit is the Git commit the binary was built from
+	Commit = ""
+)
+
+// GitCommit returns Commit, falling back to the VCS revision recorded by the Go
+// toolchain when the binary was built without -ldflags
+func GitCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				return setting.Value
+			}
+		}
+	}
+	return ""
+}
+
+// GoVersion returns the Go version the binary was built with
+func GoVersion() string {
+	return runtime.Version()
+}
+
+// Platform returns the operating system and architecture the binary was built for
+func Platform() string {
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
@@ -0,0 +1,263 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/your-org/capsulate-repo/pkg/config"
+	"github.com/your-org/capsulate-repo/pkg/secrets"
+	"github.com/your-org/capsulate-repo/pkg/version"
+)
+
+// Headers of webhook requests
+const (
+	EventHeader     = "X-Capsulate-Event"
+	DeliveryHeader  = "X-Capsulate-Delivery"
+	TimestampHeader = "X-Capsulate-Timestamp"
+	// SignatureHeader holds "sha256=" and the hex HMAC-SHA256 of the timestamp, a
+	// '.', and the body, keyed with the webhook's secret
+	SignatureHeader = "X-Capsulate-Signature"
+)
+
+// defaultTimeout limits each delivery attempt when the webhook sets no timeout
+const defaultTimeout = 10 * time.Second
+
+// retryDelay is the wait before the first retry; it doubles with each retry
+var retryDelay = time.Second
+
+// sendTimeout bounds Send as a whole, retries included, so that a dead endpoint
+// cannot hold up the command that raised the event
+var sendTimeout = 30 * time.Second
+
+// Event is an agent lifecycle transition delivered to webhooks
+type Event struct {
+	Event   string    `json:"event"`
+	AgentID string    `json:"agent_id"`
+	Project string    `json:"project,omitempty"`
+	Time    time.Time `json:"time"`
+	// Message is a human-readable summary of the event
+	Message string                 `json:"message,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// Dispatcher delivers events to the webhooks of the configuration
+type Dispatcher struct {
+	hooks  []config.WebhookConfig
+	client *http.Client
+}
+
+// NewDispatcher creates a dispatcher for the configured webhooks
+func NewDispatcher(hooks []config.WebhookConfig) *Dispatcher {
+	return &Dispatcher{hooks: hooks, client: &http.Client{}}
+}
+
+// Enabled reports whether any webhook is configured
+func (d *Dispatcher) Enabled() bool {
+	return d != nil && len(d.hooks) > 0
+}
+
+// Subscribed reports whether any webhook receives event
+func (d *Dispatcher) Subscribed(event string) bool {
+	if d == nil {
+		return false
+	}
+	for _, hook := range d.hooks {
+		if hook.Subscribed(event) {
+			return true
+		}
+	}
+	return false
+}
+
+// Send delivers an event to every webhook subscribed to it, in parallel, retrying
+// failed deliveries with exponential backoff until sendTimeout has passed. It returns
+// the error of each webhook that could not be reached.
+func (d *Dispatcher) Send(event Event) []error {
+	if !d.Enabled() {
+		return nil
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+
+	var (
+		wg    sync.WaitGroup
+		mutex sync.Mutex
+		errs  []error
+	)
+	for i, hook := range d.hooks {
+		if !hook.Subscribed(event.Event) {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, hook config.WebhookConfig) {
+			defer wg.Done()
+			if err := d.deliver(ctx, hook, event); err != nil {
+				mutex.Lock()
+				errs = append(errs, fmt.Errorf("%s for %s: %v", describe(i, hook), event.Event, err))
+				mutex.Unlock()
+			}
+		}(i, hook)
+	}
+	wg.Wait()
+	return errs
+}
+
+// describe names a webhook in errors without its URL, which may carry a token: by
+// its secret reference, or by the scheme and host of the URL
+func describe(index int, hook config.WebhookConfig) string {
+	if !strings.HasPrefix(hook.URL, "https://") && !strings.HasPrefix(hook.URL, "http://") {
+		return fmt.Sprintf("webhook %s", hook.URL)
+	}
+	if u, err := url.Parse(hook.URL); err == nil && u.Host != "" {
+		return fmt.Sprintf("webhook %s://%s", u.Scheme, u.Host)
+	}
+	return fmt.Sprintf("webhook #%d", index+1)
+}
+
+// deliver sends an event to one webhook, retrying failures until ctx is done
+func (d *Dispatcher) deliver(ctx context.Context, hook config.WebhookConfig, event Event) error {
+	body, err := render(hook, event)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(hook.URL, "https://") && !strings.HasPrefix(hook.URL, "http://") {
+		if hook.URL, err = secrets.Resolve(hook.URL); err != nil {
+			return err
+		}
+	}
+	var secret string
+	if hook.Secret != "" {
+		if secret, err = secrets.Resolve(hook.Secret); err != nil {
+			return err
+		}
+	}
+	delivery, err := deliveryID()
+	if err != nil {
+		return err
+	}
+
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err = d.post(ctx, hook, event.Event, delivery, secret, body)
+		if err == nil {
+			return nil
+		}
+		deadline, _ := ctx.Deadline()
+		if attempt >= hook.RetryCount() || time.Now().Add(delay).After(deadline) {
+			if attempt > 0 {
+				return fmt.Errorf("%v (after %d attempts)", err, attempt+1)
+			}
+			return err
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return fmt.Errorf("%v (after %d attempts)", err, attempt+1)
+		}
+		delay *= 2
+	}
+}
+
+// post makes one delivery attempt. Any response other than 2xx is a failure.
+func (d *Dispatcher) post(ctx context.Context, hook config.WebhookConfig, event, delivery, secret string, body []byte) error {
+	timeout := time.Duration(hook.Timeout)
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		// The URL may be a credential and is left out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	contentType := hook.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "git-capsulate/"+version.Version)
+	for key, value := range hook.Headers {
+		req.Header.Set(key, value)
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(DeliveryHeader, delivery)
+	req.Header.Set(TimestampHeader, timestamp)
+	if secret != "" {
+		req.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+	}
+
+	client := *d.client
+	client.Timeout = timeout
+	resp, err := client.Do(req)
+	if err != nil {
+		// The URL may be a credential and is left out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("server responded %s", resp.Status)
+	}
+	return nil
+}
+
+// Sign returns the value of the signature header for a body sent at timestamp
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// render builds the request body of an event: the webhook's payload template, or the
+// event as JSON
+func render(hook config.WebhookConfig, event Event) ([]byte, error) {
+	tmpl, err := hook.PayloadTemplate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload template: %v", err)
+	}
+	if tmpl == nil {
+		return json.Marshal(event)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("failed to render payload: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// deliveryID returns a random ID identifying a delivery across its retries
+func deliveryID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate delivery ID: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -141,10 +141,34 @@ else
   exit 1
 fi
 
+# Test 5: Hostile branch names and arguments are never run by a shell
+echo "🛡️  Testing hostile inputs..."
+rm -f /tmp/capsulate-pwned
+git-capsulate exec test-agent1 "rm -f /tmp/capsulate-pwned"
+for hostile in 'foo; touch /tmp/capsulate-pwned' 'foo$(touch /tmp/capsulate-pwned)' 'foo`touch /tmp/capsulate-pwned`' 'foo && touch /tmp/capsulate-pwned' "foo' ; touch /tmp/capsulate-pwned ; '"; do
+  git-capsulate branch test-agent1 "$hostile" > /dev/null 2>&1
+  git-capsulate checkout test-agent1 "$hostile" > /dev/null 2>&1
+done
+
+# Option injection must be refused before git sees it
+if git-capsulate branch test-agent1 "--help" > /dev/null 2>&1 ||
+   git-capsulate checkout test-agent1 "--orphan=x" > /dev/null 2>&1; then
+  echo "❌ Hostile input test failed: option-like branch name was accepted"
+  exit 1
+fi
+
+pwned=$(git-capsulate exec test-agent1 "test -e /tmp/capsulate-pwned && echo yes || echo no" | tr -d '\n\r')
+if [ "$pwned" = "no" ] && [ ! -e /tmp/capsulate-pwned ]; then
+  echo "✅ Hostile input test passed!"
+else
+  echo "❌ Hostile input test failed: a branch name was executed by a shell"
+  exit 1
+fi
+
 # Clean up test agents
 echo "🧹 Cleaning up test agents..."
-git-capsulate destroy test-agent1
-git-capsulate destroy test-agent2
-git-capsulate destroy clone-agent
+git-capsulate destroy --force test-agent1
+git-capsulate destroy --force test-agent2
+git-capsulate destroy --force clone-agent
 
 echo "🎉 All Phase 2 Git operations tests completed successfully!" 
\ No newline at end of file
@@ -5,12 +5,12 @@ echo "🧪 Testing dependency and file system management..."
 
 # Cleanup any existing test agents
 echo "🧹 Cleaning up any existing test agents..."
-git-capsulate destroy deps-agent1 2>/dev/null || true
-git-capsulate destroy deps-agent2 2>/dev/null || true
-git-capsulate destroy overlay-agent 2>/dev/null || true
-git-capsulate destroy core-agent1 2>/dev/null || true
-git-capsulate destroy core-agent2 2>/dev/null || true
-git-capsulate destroy override-agent 2>/dev/null || true
+git-capsulate destroy --force deps-agent1 2>/dev/null || true
+git-capsulate destroy --force deps-agent2 2>/dev/null || true
+git-capsulate destroy --force overlay-agent 2>/dev/null || true
+git-capsulate destroy --force core-agent1 2>/dev/null || true
+git-capsulate destroy --force core-agent2 2>/dev/null || true
+git-capsulate destroy --force override-agent 2>/dev/null || true
 
 # Test 1: Three-tier dependency architecture
 echo "📦 Testing three-tier dependency architecture..."
@@ -122,11 +122,11 @@ fi
 
 # Clean up test agents
 echo "🧹 Cleaning up test agents..."
-git-capsulate destroy deps-agent1
-git-capsulate destroy deps-agent2
-git-capsulate destroy overlay-agent
-git-capsulate destroy core-agent1
-git-capsulate destroy core-agent2
-git-capsulate destroy override-agent
+git-capsulate destroy --force deps-agent1
+git-capsulate destroy --force deps-agent2
+git-capsulate destroy --force overlay-agent
+git-capsulate destroy --force core-agent1
+git-capsulate destroy --force core-agent2
+git-capsulate destroy --force override-agent
 
 echo "🎉 All Phase 3 dependency & file system tests completed successfully!" 
\ No newline at end of file
@@ -5,8 +5,8 @@ echo "🧪 Testing observability and metrics infrastructure..."
 
 # Setup: Create test agents for metrics collection
 echo "📦 Setting up test environment..."
-git-capsulate destroy obs-test-agent1 2>/dev/null || true
-git-capsulate destroy obs-test-agent2 2>/dev/null || true
+git-capsulate destroy --force obs-test-agent1 2>/dev/null || true
+git-capsulate destroy --force obs-test-agent2 2>/dev/null || true
 
 # Clear any existing metrics
 git-capsulate metrics clear
@@ -70,7 +70,7 @@ echo "🔍 Testing distributed tracing..."
 
 # Create a third agent with tracing
 # (This should generate spans that we can check)
-git-capsulate destroy obs-test-agent3 2>/dev/null || true
+git-capsulate destroy --force obs-test-agent3 2>/dev/null || true
 git-capsulate create obs-test-agent3
 
 # Check for traces
@@ -118,9 +118,9 @@ fi
 
 # Clean up
 echo "🧹 Cleaning up..."
-git-capsulate destroy obs-test-agent1
-git-capsulate destroy obs-test-agent2
-git-capsulate destroy obs-test-agent3
+git-capsulate destroy --force obs-test-agent1
+git-capsulate destroy --force obs-test-agent2
+git-capsulate destroy --force obs-test-agent3
 git-capsulate monitor stop
 
 echo "🎉 All Phase 4 observability tests completed!" 
\ No newline at end of file